@@ -0,0 +1,220 @@
+package archive
+
+// 7z archives are decoded with github.com/bodgit/sevenzip. The start
+// header and the header at the end of the archive are kept in the
+// index so opening a member only needs requests for the packed data.
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/rclone/rclone/fs"
+)
+
+// 7z signature and sizes
+const (
+	sevenZipSig          = "7z\xbc\xaf\x27\x1c"
+	sevenZipStartLen     = 32
+	sevenZipMaxHeaderLen = 1 << 30
+)
+
+// sevenZipHeaders are the parts of a 7z archive which describe the
+// packed data between them
+type sevenZipHeaders struct {
+	size      int64  // size of the archive
+	start     []byte // the start header
+	dataEnd   int64  // offset of the end of the packed data
+	endHeader []byte // the header after the packed data
+}
+
+// readSevenZipHeaders reads the start header and the header at the
+// end of a 7z archive
+func readSevenZipHeaders(ra *rangeReaderAt) (*sevenZipHeaders, error) {
+	h := &sevenZipHeaders{
+		size:  ra.size,
+		start: make([]byte, sevenZipStartLen),
+	}
+	if _, err := ra.ReadAt(h.start, 0); err != nil {
+		return nil, fmt.Errorf("failed to read start header: %w", err)
+	}
+	if string(h.start[:len(sevenZipSig)]) != sevenZipSig {
+		return nil, errors.New("not a 7z file")
+	}
+	if crc32.ChecksumIEEE(h.start[12:]) != binary.LittleEndian.Uint32(h.start[8:]) {
+		return nil, errors.New("bad 7z start header CRC")
+	}
+	offset := binary.LittleEndian.Uint64(h.start[12:])
+	size := binary.LittleEndian.Uint64(h.start[20:])
+	if size > sevenZipMaxHeaderLen || offset > uint64(ra.size) || sevenZipStartLen+offset+size > uint64(ra.size) {
+		return nil, errors.New("bad 7z header size")
+	}
+	h.dataEnd = sevenZipStartLen + int64(offset)
+	h.endHeader = make([]byte, size)
+	if _, err := ra.ReadAt(h.endHeader, h.dataEnd); err != nil {
+		return nil, fmt.Errorf("failed to read 7z header: %w", err)
+	}
+	return h, nil
+}
+
+// open parses the headers reading the packed data from data
+func (h *sevenZipHeaders) open(data io.ReaderAt) (*sevenzip.Reader, error) {
+	return sevenzip.NewReader(&sevenZipReaderAt{h: h, data: data}, h.size)
+}
+
+// sevenZipReaderAt reads a 7z archive using the headers in memory and
+// data for the packed data
+type sevenZipReaderAt struct {
+	h    *sevenZipHeaders
+	data io.ReaderAt
+}
+
+// ReadAt reads len(p) bytes at off
+func (r *sevenZipReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	h := r.h
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	for n < len(p) {
+		pos := off + int64(n)
+		var copied int
+		switch {
+		case pos >= h.dataEnd+int64(len(h.endHeader)):
+			return n, io.EOF
+		case pos < sevenZipStartLen:
+			copied = copy(p[n:], h.start[pos:])
+		case pos >= h.dataEnd:
+			copied = copy(p[n:], h.endHeader[pos-h.dataEnd:])
+		default:
+			q := p[n:]
+			if int64(len(q)) > h.dataEnd-pos {
+				q = q[:h.dataEnd-pos]
+			}
+			copied, err = r.data.ReadAt(q, pos)
+			if err != nil && copied < len(q) {
+				return n + copied, err
+			}
+		}
+		n += copied
+	}
+	return n, nil
+}
+
+// readSevenZipIndex reads the list of files in a 7z archive
+//
+// The member offset is the number of the file in the archive.
+func readSevenZipIndex(ra *rangeReaderAt) (members []*member, h *sevenZipHeaders, err error) {
+	h, err = readSevenZipHeaders(ra)
+	if err != nil {
+		return nil, nil, err
+	}
+	// An encoded header is read from the packed data
+	z, err := h.open(ra)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, file := range z.File {
+		mode := file.FileInfo().Mode()
+		switch {
+		case mode.IsDir():
+			members = append(members, &member{name: strings.TrimRight(file.Name, "/"), dir: true, modTime: file.Modified})
+		case mode.IsRegular():
+			members = append(members, &member{
+				name:    file.Name,
+				size:    int64(file.UncompressedSize),
+				modTime: file.Modified,
+				offset:  int64(i),
+				crc:     file.CRC32,
+			})
+		default:
+			fs.Debugf(nil, "Ignoring 7z member %q of type %v", file.Name, mode.Type())
+		}
+	}
+	return members, h, nil
+}
+
+// openSevenZipMember opens the 7z member m in the archive o
+//
+// The packed data is read with one request from the start of the
+// block holding the member. Members of solid blocks have to be
+// decompressed from the start of the block.
+func openSevenZipMember(ctx context.Context, o fs.Object, h *sevenZipHeaders, m *member, offset, limit int64) (rc io.ReadCloser, err error) {
+	data := &streamReaderAt{ctx: ctx, o: o, end: h.dataEnd}
+	defer func() {
+		if err != nil {
+			_ = data.Close()
+		}
+	}()
+	z, err := h.open(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.offset >= int64(len(z.File)) {
+		return nil, errors.New("7z member not found")
+	}
+	in, err := z.File[m.offset].Open()
+	if err != nil {
+		return nil, err
+	}
+	r := &memberReader{in: data, out: in, closeOut: in.Close, remaining: m.size}
+	if offset == 0 && limit == m.size {
+		if m.size == 0 || m.crc != 0 {
+			r.hash, r.crc = crc32.NewIEEE(), m.crc
+		}
+	} else {
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r.remaining = limit
+	}
+	return r, nil
+}
+
+// streamReaderAt reads an object with one ranged request for each
+// run of sequential reads
+type streamReaderAt struct {
+	ctx context.Context
+	o   fs.Object
+	end int64         // requests end here
+	in  io.ReadCloser // the current request if set
+	pos int64         // offset of the next byte from in
+}
+
+// ReadAt reads len(p) bytes at off
+func (r *streamReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if r.in == nil || off != r.pos {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		if off >= r.end {
+			return 0, io.EOF
+		}
+		r.in, err = r.o.Open(r.ctx, &fs.RangeOption{Start: off, End: r.end - 1})
+		if err != nil {
+			return 0, err
+		}
+		r.pos = off
+	}
+	n, err = io.ReadFull(r.in, p)
+	r.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close closes the current request
+func (r *streamReaderAt) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
@@ -0,0 +1,408 @@
+// Package archive implements a backend which shows archives on
+// another remote as directories.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/cache"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "archive",
+		Description: "Read archives on a remote as directories",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "remote",
+			Help: `Remote containing the archives.
+
+Normally should contain a ':' and a path, e.g. "myremote:path/to/dir",
+"myremote:bucket" or maybe "myremote:" (not recommended).`,
+			Required: true,
+		}, {
+			Name: "index_cache_time",
+			Help: `How long to keep the list of files in an archive in memory.
+
+Reading the list of files needs a few requests to the remote (or
+reading the whole archive for compressed tar files) so it is kept
+for this long after it was last used. It is read again if the archive
+changes size or modification time.`,
+			Default:  fs.Duration(5 * time.Minute),
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote         string      `config:"remote"`
+	IndexCacheTime fs.Duration `config:"index_cache_time"`
+}
+
+// errReadOnly is returned when trying to change the inside of an
+// archive
+var errReadOnly = errors.New("archives can't be modified")
+
+// Fs represents archives on a wrapped fs.Fs
+type Fs struct {
+	name     string
+	root     string // root relative to the wrapped Fs
+	opt      Options
+	features *fs.Features // optional features
+	wrapped  fs.Fs        // the remote containing the archives
+	indexes  *cache.Cache // archive indexes by archive path, size and modification time
+	hashes   hash.Set     // hashes available for the files under root
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point archive remote at itself - check the value of the remote setting")
+	}
+	wrapped, err := fs.NewFs(ctx, opt.Remote)
+	if err == fs.ErrorIsFile {
+		return nil, fmt.Errorf("remote %q must be a directory", opt.Remote)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+	}
+	f := &Fs{
+		name:    name,
+		root:    strings.Trim(path.Clean("/"+root), "/"),
+		opt:     *opt,
+		wrapped: wrapped,
+		indexes: cache.New().SetExpireDuration(time.Duration(opt.IndexCacheTime)),
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+
+	// Check to see if the root is a file
+	isFile := false
+	if f.root != "" {
+		_, err := f.NewObject(ctx, "")
+		if err == nil {
+			isFile = true
+			f.root = path.Dir(f.root)
+			if f.root == "." {
+				f.root = ""
+			}
+		}
+	}
+	f.hashes, err = f.findHashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// findHashes returns the hashes available for the files under root
+//
+// Inside a zip or 7z archive this is the CRC32 stored for each file,
+// inside other archives there are none, and outside archives they
+// are the hashes of the wrapped remote.
+func (f *Fs) findHashes(ctx context.Context) (hash.Set, error) {
+	arc, _, err := f.resolve(ctx, f.root)
+	if err != nil {
+		return hash.Set(hash.None), err
+	}
+	if arc == nil {
+		return f.wrapped.Hashes(), nil
+	}
+	switch archiveKindFromName(path.Base(arc.Remote())) {
+	case kindZip, kind7z:
+		return hash.NewHashSet(hash.CRC32), nil
+	}
+	return hash.Set(hash.None), nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("archive root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return f.wrapped.Precision()
+}
+
+// Hashes returns the supported hash sets.
+//
+// CRC32 is only available if the root is in a zip or 7z archive or
+// the wrapped remote supports it.
+func (f *Fs) Hashes() hash.Set {
+	return f.hashes
+}
+
+// archiveExts are the extensions of archives which are shown as
+// directories
+var archiveExts = []struct {
+	ext  string
+	kind archiveKind
+}{
+	{".zip", kindZip},
+	{".tar", kindTar},
+	{".tar.gz", kindTarGz},
+	{".tgz", kindTarGz},
+	{".tar.zst", kindTarZst},
+	{".tzst", kindTarZst},
+	{".7z", kind7z},
+}
+
+// archiveKindFromName returns the kind of archive name is or
+// kindNone if it isn't an archive
+func archiveKindFromName(name string) archiveKind {
+	lower := strings.ToLower(name)
+	for _, x := range archiveExts {
+		if strings.HasSuffix(lower, x.ext) && len(lower) > len(x.ext) {
+			return x.kind
+		}
+	}
+	return kindNone
+}
+
+// fullPath returns the path of remote relative to the wrapped Fs
+func (f *Fs) fullPath(remote string) string {
+	return path.Join(f.root, remote)
+}
+
+// relPath returns the path of full relative to the root
+func (f *Fs) relPath(full string) string {
+	if f.root == "" {
+		return full
+	}
+	if full == f.root {
+		return ""
+	}
+	return strings.TrimPrefix(full, f.root+"/")
+}
+
+// resolve finds the archive which contains full returning the
+// archive and the path within it.
+//
+// If full isn't in an archive then o is nil. If full is an archive
+// then inner is "".
+func (f *Fs) resolve(ctx context.Context, full string) (o fs.Object, inner string, err error) {
+	if full == "" {
+		return nil, "", nil
+	}
+	segments := strings.Split(full, "/")
+	for i, segment := range segments {
+		if archiveKindFromName(segment) == kindNone {
+			continue
+		}
+		o, err = f.wrapped.NewObject(ctx, strings.Join(segments[:i+1], "/"))
+		if err == fs.ErrorObjectNotFound || err == fs.ErrorIsDir || err == fs.ErrorNotAFile {
+			// a directory with an archive name
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+		return o, strings.Join(segments[i+1:], "/"), nil
+	}
+	return nil, "", nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	full := f.fullPath(dir)
+	arc, inner, err := f.resolve(ctx, full)
+	if err != nil {
+		return nil, err
+	}
+	if arc != nil {
+		return f.listArchive(ctx, arc, inner)
+	}
+	wrappedEntries, err := f.wrapped.List(ctx, full)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range wrappedEntries {
+		remote := f.relPath(entry.Remote())
+		switch x := entry.(type) {
+		case fs.Object:
+			if archiveKindFromName(path.Base(remote)) != kindNone {
+				entries = append(entries, fs.NewDir(remote, x.ModTime(ctx)))
+			} else {
+				entries = append(entries, f.newObject(x, remote))
+			}
+		case fs.Directory:
+			entries = append(entries, fs.NewDirCopy(ctx, x).SetRemote(remote))
+		default:
+			return nil, fmt.Errorf("unknown object type %T", entry)
+		}
+	}
+	return entries, nil
+}
+
+// listArchive lists the directory inner in the archive arc
+func (f *Fs) listArchive(ctx context.Context, arc fs.Object, inner string) (entries fs.DirEntries, err error) {
+	idx, err := f.index(ctx, arc)
+	if err != nil {
+		return nil, err
+	}
+	children, found := idx.children[inner]
+	if !found {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, m := range children {
+		remote := f.relPath(path.Join(arc.Remote(), m.name))
+		if m.dir {
+			entries = append(entries, fs.NewDir(remote, m.modTime))
+		} else {
+			entries = append(entries, f.newMember(idx, m, remote))
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	full := f.fullPath(remote)
+	arc, inner, err := f.resolve(ctx, full)
+	if err != nil {
+		return nil, err
+	}
+	if arc == nil {
+		o, err := f.wrapped.NewObject(ctx, full)
+		if err != nil {
+			return nil, err
+		}
+		return f.newObject(o, remote), nil
+	}
+	if inner == "" {
+		return nil, fs.ErrorIsDir
+	}
+	idx, err := f.index(ctx, arc)
+	if err != nil {
+		return nil, err
+	}
+	m, found := idx.members[inner]
+	if !found {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if m.dir {
+		return nil, fs.ErrorIsDir
+	}
+	return f.newMember(idx, m, remote), nil
+}
+
+// checkWritable returns an error if full is inside an archive
+func (f *Fs) checkWritable(ctx context.Context, full string) error {
+	arc, inner, err := f.resolve(ctx, full)
+	if err != nil {
+		return err
+	}
+	if arc != nil && inner != "" {
+		return errReadOnly
+	}
+	return nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// Files can't be put inside archives.
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	full := f.fullPath(src.Remote())
+	if err := f.checkWritable(ctx, full); err != nil {
+		return nil, err
+	}
+	o, err := f.wrapped.Put(ctx, in, fs.NewOverrideRemote(src, full), options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, src.Remote()), nil
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	full := f.fullPath(dir)
+	arc, inner, err := f.resolve(ctx, full)
+	if err != nil {
+		return err
+	}
+	if arc == nil {
+		return f.wrapped.Mkdir(ctx, full)
+	}
+	idx, err := f.index(ctx, arc)
+	if err != nil {
+		return err
+	}
+	if _, found := idx.children[inner]; found {
+		return nil
+	}
+	return errReadOnly
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	full := f.fullPath(dir)
+	arc, _, err := f.resolve(ctx, full)
+	if err != nil {
+		return err
+	}
+	if arc != nil {
+		return errReadOnly
+	}
+	return f.wrapped.Rmdir(ctx, full)
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.wrapped
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.UnWrapper = (*Fs)(nil)
+)
@@ -0,0 +1,456 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testTime   = time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	helloData  = "hello world\n"
+	longData   = strings.Repeat("the quick brown fox jumps over the lazy dog\n", 1000)
+	testHashes = map[string]string{
+		"hello.txt":        fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(helloData))),
+		"sub/deflated.txt": fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(longData))),
+	}
+)
+
+// makeZip makes a zip archive with a stored and a deflated file
+func makeZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, x := range []struct {
+		name   string
+		data   string
+		method uint16
+	}{
+		{"hello.txt", helloData, zip.Store},
+		{"empty/", "", zip.Store},
+		{"sub/deflated.txt", longData, zip.Deflate},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: x.name, Method: x.method, Modified: testTime})
+		require.NoError(t, err)
+		_, err = io.WriteString(w, x.data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// makeTar makes a tar archive with the same files as makeZip
+func makeTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "./hello.txt", Typeflag: tar.TypeReg, Size: int64(len(helloData)), Mode: 0644, ModTime: testTime},
+		{Name: "empty/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: testTime},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "hello.txt", ModTime: testTime},
+		{Name: "sub/deflated.txt", Typeflag: tar.TypeReg, Size: int64(len(longData)), Mode: 0644, ModTime: testTime},
+	} {
+		require.NoError(t, tw.WriteHeader(hdr))
+		switch hdr.Name {
+		case "./hello.txt":
+			_, _ = io.WriteString(tw, helloData)
+		case "sub/deflated.txt":
+			_, _ = io.WriteString(tw, longData)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// write7zNumber writes a number in the variable length encoding
+// used in 7z headers
+func write7zNumber(buf *bytes.Buffer, v uint64) {
+	n := 0
+	for n < 8 && v >= 1<<(7*(n+1)) {
+		n++
+	}
+	first := byte(0xff << (8 - n))
+	if n < 8 {
+		first |= byte(v >> (8 * n))
+	}
+	buf.WriteByte(first)
+	for i := 0; i < n; i++ {
+		buf.WriteByte(byte(v >> (8 * i)))
+	}
+}
+
+// make7z makes a 7z archive with the files in makeZip stored in one
+// solid block without compression
+func make7z(t *testing.T) []byte {
+	data := helloData + longData
+	var h bytes.Buffer
+	num := func(v uint64) { write7zNumber(&h, v) }
+	u32 := func(v uint32) { _ = binary.Write(&h, binary.LittleEndian, v) }
+	h.Write([]byte{0x01, 0x04}) // header, main streams info
+	// pack info: one packed stream at 0
+	h.WriteByte(0x06)
+	num(0)
+	num(1)
+	h.WriteByte(0x09)
+	num(uint64(len(data)))
+	h.WriteByte(0x00)
+	// unpack info: one folder with the copy coder
+	h.Write([]byte{0x07, 0x0b, 0x01, 0x00, 0x01, 0x01, 0x00, 0x0c})
+	num(uint64(len(data)))
+	h.WriteByte(0x00)
+	// substreams info: two files in the folder
+	h.Write([]byte{0x08, 0x0d, 0x02, 0x09})
+	num(uint64(len(helloData)))
+	h.Write([]byte{0x0a, 0x01})
+	u32(crc32.ChecksumIEEE([]byte(helloData)))
+	u32(crc32.ChecksumIEEE([]byte(longData)))
+	h.Write([]byte{0x00, 0x00})
+	// files info: "empty" has no stream
+	names := []string{"hello.txt", "empty", "sub/deflated.txt"}
+	h.WriteByte(0x05)
+	num(uint64(len(names)))
+	h.Write([]byte{0x0e, 0x01, 0x40})
+	var nameData bytes.Buffer
+	nameData.WriteByte(0x00)
+	for _, name := range names {
+		for _, c := range name + "\x00" {
+			_ = binary.Write(&nameData, binary.LittleEndian, uint16(c))
+		}
+	}
+	h.WriteByte(0x11)
+	num(uint64(nameData.Len()))
+	h.Write(nameData.Bytes())
+	h.WriteByte(0x14)
+	num(uint64(2 + 8*len(names)))
+	h.Write([]byte{0x01, 0x00})
+	for range names {
+		// 100ns intervals since 1601
+		_ = binary.Write(&h, binary.LittleEndian, uint64(testTime.Unix()+11644473600)*10000000)
+	}
+	h.WriteByte(0x15)
+	num(uint64(2 + 4*len(names)))
+	h.Write([]byte{0x01, 0x00})
+	for _, attr := range []uint32{0x20, 0x10, 0x20} {
+		u32(attr)
+	}
+	h.Write([]byte{0x00, 0x00})
+
+	start := make([]byte, 20)
+	binary.LittleEndian.PutUint64(start, uint64(len(data)))
+	binary.LittleEndian.PutUint64(start[8:], uint64(h.Len()))
+	binary.LittleEndian.PutUint32(start[16:], crc32.ChecksumIEEE(h.Bytes()))
+	var buf bytes.Buffer
+	buf.WriteString("7z\xbc\xaf\x27\x1c\x00\x04")
+	_ = binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(start))
+	buf.Write(start)
+	buf.WriteString(data)
+	buf.Write(h.Bytes())
+	return buf.Bytes()
+}
+
+// compress compresses data with the compressor for kind
+func compress(t *testing.T, data []byte, kind archiveKind) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch kind {
+	case kindTarGz:
+		w = gzip.NewWriter(&buf)
+	case kindTarZst:
+		w, err = zstd.NewWriter(&buf)
+		require.NoError(t, err)
+	}
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// newTestFs makes a directory with archives in and an archive Fs on it
+func newTestFs(t *testing.T, root string) (*Fs, string) {
+	dir := t.TempDir()
+	tarData := makeTar(t)
+	for name, data := range map[string][]byte{
+		"plain.txt":                  []byte("plain"),
+		"a.zip":                      makeZip(t),
+		"a.tar":                      tarData,
+		"a.tar.gz":                   compress(t, tarData, kindTarGz),
+		"a.tar.zst":                  compress(t, tarData, kindTarZst),
+		"a.7z":                       make7z(t),
+		"dir.zip/not-an-archive.txt": []byte("x"),
+		"bad.zip":                    []byte("this isn't a zip file"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0666))
+	}
+	f, err := NewFs(context.Background(), "TestArchive", root, configmap.Simple{"remote": dir})
+	if err != fs.ErrorIsFile {
+		require.NoError(t, err)
+	}
+	return f.(*Fs), dir
+}
+
+// listNames lists dir returning the names with / after directories
+func listNames(t *testing.T, f fs.Fs, dir string) []string {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		name := entry.Remote()
+		if _, ok := entry.(fs.Directory); ok {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readObject reads remote from f with the options
+func readObject(t *testing.T, f fs.Fs, remote string, options ...fs.OpenOption) string {
+	ctx := context.Background()
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestArchiveKindFromName(t *testing.T) {
+	for name, want := range map[string]archiveKind{
+		"a.zip":     kindZip,
+		"A.ZIP":     kindZip,
+		"a.tar":     kindTar,
+		"a.tar.gz":  kindTarGz,
+		"a.tgz":     kindTarGz,
+		"a.tar.zst": kindTarZst,
+		"a.tzst":    kindTarZst,
+		".zip":      kindNone,
+		"a.gz":      kindNone,
+		"a.7z":      kind7z,
+		"a.rar":     kindNone,
+		"zip":       kindNone,
+	} {
+		assert.Equal(t, want, archiveKindFromName(name), name)
+	}
+}
+
+func TestList(t *testing.T) {
+	f, _ := newTestFs(t, "")
+	assert.Equal(t, []string{"a.7z/", "a.tar.gz/", "a.tar.zst/", "a.tar/", "a.zip/", "bad.zip/", "dir.zip/", "plain.txt"}, listNames(t, f, ""))
+	assert.Equal(t, []string{"dir.zip/not-an-archive.txt"}, listNames(t, f, "dir.zip"))
+	for _, arc := range []string{"a.zip", "a.tar", "a.tar.gz", "a.tar.zst", "a.7z"} {
+		assert.Equal(t, []string{arc + "/empty/", arc + "/hello.txt", arc + "/sub/"}, listNames(t, f, arc), arc)
+		assert.Equal(t, []string{arc + "/sub/deflated.txt"}, listNames(t, f, arc+"/sub"), arc)
+		assert.Nil(t, listNames(t, f, arc+"/empty"), arc)
+		_, err := f.List(context.Background(), arc+"/missing")
+		assert.Equal(t, fs.ErrorDirNotFound, err, arc)
+		_, err = f.List(context.Background(), arc+"/hello.txt")
+		assert.Equal(t, fs.ErrorDirNotFound, err, arc)
+	}
+	_, err := f.List(context.Background(), "bad.zip")
+	assert.ErrorContains(t, err, "not a zip file")
+
+	// With a root inside an archive
+	f, _ = newTestFs(t, "a.zip/sub")
+	assert.Equal(t, []string{"deflated.txt"}, listNames(t, f, ""))
+}
+
+func TestRead(t *testing.T) {
+	ctx := context.Background()
+	f, _ := newTestFs(t, "")
+	assert.Equal(t, "plain", readObject(t, f, "plain.txt"))
+	for _, arc := range []string{"a.zip", "a.tar", "a.tar.gz", "a.tar.zst", "a.7z"} {
+		assert.Equal(t, helloData, readObject(t, f, arc+"/hello.txt"), arc)
+		assert.Equal(t, longData, readObject(t, f, arc+"/sub/deflated.txt"), arc)
+		assert.Equal(t, helloData[6:], readObject(t, f, arc+"/hello.txt", &fs.SeekOption{Offset: 6}), arc)
+		assert.Equal(t, longData[100:200], readObject(t, f, arc+"/sub/deflated.txt", &fs.RangeOption{Start: 100, End: 199}), arc)
+		assert.Equal(t, longData[len(longData)-5:], readObject(t, f, arc+"/sub/deflated.txt", &fs.RangeOption{Start: -1, End: 5}), arc)
+		assert.Equal(t, "", readObject(t, f, arc+"/hello.txt", &fs.SeekOption{Offset: 100}), arc)
+
+		o, err := f.NewObject(ctx, arc+"/sub/deflated.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(longData)), o.Size())
+		assert.Equal(t, arc+"/sub/deflated.txt", o.Remote())
+		assert.True(t, testTime.Equal(o.ModTime(ctx)), arc)
+		sum, err := o.Hash(ctx, hash.CRC32)
+		require.NoError(t, err)
+		if arc == "a.zip" || arc == "a.7z" {
+			assert.Equal(t, testHashes["sub/deflated.txt"], sum)
+		} else {
+			assert.Equal(t, "", sum)
+		}
+
+		_, err = f.NewObject(ctx, arc+"/sub")
+		assert.Equal(t, fs.ErrorIsDir, err)
+		_, err = f.NewObject(ctx, arc+"/missing")
+		assert.Equal(t, fs.ErrorObjectNotFound, err)
+		_, err = f.NewObject(ctx, arc+"/link")
+		assert.Equal(t, fs.ErrorObjectNotFound, err)
+	}
+	_, err := f.NewObject(ctx, "a.zip")
+	assert.Equal(t, fs.ErrorIsDir, err)
+	o, err := f.NewObject(ctx, "plain.txt")
+	require.NoError(t, err)
+	// the local backend has CRC32 so it is passed through
+	sum, err := o.Hash(ctx, hash.CRC32)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("plain"))), sum)
+	sum, err = o.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.NotEqual(t, "", sum)
+}
+
+func TestHashes(t *testing.T) {
+	ctx := context.Background()
+
+	// Inside a zip or 7z archive only CRC32 is available
+	for _, arc := range []string{"a.zip", "a.7z"} {
+		f, _ := newTestFs(t, arc)
+		assert.Equal(t, hash.NewHashSet(hash.CRC32), f.Hashes(), arc)
+		o, err := f.NewObject(ctx, "hello.txt")
+		require.NoError(t, err)
+		sum, err := o.Hash(ctx, hash.CRC32)
+		require.NoError(t, err)
+		assert.Equal(t, testHashes["hello.txt"], sum, arc)
+		_, err = o.Hash(ctx, hash.MD5)
+		assert.ErrorIs(t, err, hash.ErrUnsupported, arc)
+	}
+
+	// Inside a tar archive there are none
+	f, _ := newTestFs(t, "a.tar/sub")
+	assert.Equal(t, hash.Set(hash.None), f.Hashes())
+	o, err := f.NewObject(ctx, "deflated.txt")
+	require.NoError(t, err)
+	_, err = o.Hash(ctx, hash.CRC32)
+	assert.ErrorIs(t, err, hash.ErrUnsupported)
+
+	// Outside archives they are those of the wrapped remote
+	f, _ = newTestFs(t, "")
+	assert.Equal(t, f.wrapped.Hashes(), f.Hashes())
+}
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t, "")
+	put := func(remote string) error {
+		src := object.NewStaticObjectInfo(remote, testTime, 3, true, nil, nil)
+		_, err := f.Put(ctx, bytes.NewBufferString("new"), src)
+		return err
+	}
+	assert.Equal(t, errReadOnly, put("a.zip/new.txt"))
+	assert.Equal(t, errReadOnly, put("a.tar/sub/new.txt"))
+	require.NoError(t, put("dir.zip/new.txt"))
+	require.NoError(t, put("new.txt"))
+	data, err := os.ReadFile(filepath.Join(dir, "new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	assert.NoError(t, f.Mkdir(ctx, "a.zip/sub"))
+	assert.Equal(t, errReadOnly, f.Mkdir(ctx, "a.zip/newdir"))
+	assert.Equal(t, errReadOnly, f.Rmdir(ctx, "a.zip/empty"))
+	assert.NoError(t, f.Mkdir(ctx, "newdir"))
+	assert.NoError(t, f.Rmdir(ctx, "newdir"))
+
+	o, err := f.NewObject(ctx, "a.zip/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, errReadOnly, o.Remove(ctx))
+	assert.Equal(t, errReadOnly, o.SetModTime(ctx, time.Now()))
+}
+
+func TestRootIsFile(t *testing.T) {
+	f, _ := newTestFs(t, "a.zip/sub/deflated.txt")
+	assert.Equal(t, "a.zip/sub", f.Root())
+	f, _ = newTestFs(t, "plain.txt")
+	assert.Equal(t, "", f.Root())
+	f, _ = newTestFs(t, "a.tar")
+	assert.Equal(t, "a.tar", f.Root())
+}
+
+func TestZipIndexReads(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// A zip with a big file at the start
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "big.bin", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = io.CopyN(w, rand.New(rand.NewSource(1)), 5*blockSize)
+	require.NoError(t, err)
+	w, err = zw.CreateHeader(&zip.FileHeader{Name: "small.txt", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = io.WriteString(w, helloData)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.zip"), buf.Bytes(), 0666))
+
+	wrapped, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	o, err := wrapped.NewObject(ctx, "big.zip")
+	require.NoError(t, err)
+	ra := newRangeReaderAt(ctx, o)
+	members, err := readZipIndex(ra)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(members))
+	assert.LessOrEqual(t, ra.reads, 2, "should only read the end of the archive")
+	assert.Equal(t, "small.txt", members[1].name)
+
+	// Corrupt the small file and check the CRC is checked
+	data := buf.Bytes()
+	i := bytes.LastIndex(data, []byte(helloData))
+	data[i] = 'H'
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.zip"), data, 0666))
+	f, err := NewFs(ctx, "TestArchive", "", configmap.Simple{"remote": dir})
+	require.NoError(t, err)
+	obj, err := f.NewObject(ctx, "big.zip/small.txt")
+	require.NoError(t, err)
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(in)
+	assert.ErrorContains(t, err, "CRC mismatch")
+	require.NoError(t, in.Close())
+}
+
+func TestSevenZipCRC(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	data := make7z(t)
+	i := bytes.Index(data, []byte(helloData))
+	data[i] = 'H'
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.7z"), data, 0666))
+	f, err := NewFs(ctx, "TestArchive", "", configmap.Simple{"remote": dir})
+	require.NoError(t, err)
+	o, err := f.NewObject(ctx, "a.7z/hello.txt")
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(in)
+	assert.ErrorContains(t, err, "CRC mismatch")
+	require.NoError(t, in.Close())
+
+	// The other file in the block is still readable
+	assert.Equal(t, longData, readObject(t, f, "a.7z/sub/deflated.txt"))
+}
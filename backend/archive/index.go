@@ -0,0 +1,366 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+)
+
+// archiveKind is the type of an archive
+type archiveKind int
+
+// Kinds of archive
+const (
+	kindNone archiveKind = iota
+	kindZip
+	kindTar
+	kindTarGz
+	kindTarZst
+	kind7z
+)
+
+// String returns the name of the archive kind
+func (kind archiveKind) String() string {
+	switch kind {
+	case kindZip:
+		return "zip"
+	case kindTar:
+		return "tar"
+	case kindTarGz:
+		return "tar.gz"
+	case kindTarZst:
+		return "tar.zst"
+	case kind7z:
+		return "7z"
+	}
+	return "none"
+}
+
+// member is a file or directory in an archive
+type member struct {
+	name    string // path in the archive with no leading or trailing /
+	dir     bool
+	size    int64
+	modTime time.Time
+	offset  int64  // zip: offset of the local header, tar: offset of the data, 7z: number of the file
+	crc     uint32 // zip and 7z: CRC32 of the uncompressed data
+	// zip only
+	csize  int64  // compressed size
+	method uint16 // compression method
+	flags  uint16 // general purpose flags
+}
+
+// archiveIndex is the list of files in an archive
+type archiveIndex struct {
+	o        fs.Object            // the archive
+	kind     archiveKind          // type of the archive
+	members  map[string]*member   // members by name
+	children map[string][]*member // contents of each directory, "" is the root
+	sevenZip *sevenZipHeaders     // 7z only: the headers to decode the archive
+}
+
+// index returns the index of the archive o reading it if necessary
+func (f *Fs) index(ctx context.Context, o fs.Object) (*archiveIndex, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%d", o.Remote(), o.Size(), o.ModTime(ctx).UnixNano())
+	value, err := f.indexes.Get(key, func(key string) (interface{}, bool, error) {
+		idx, err := readIndex(ctx, o)
+		return idx, false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*archiveIndex), nil
+}
+
+// readIndex reads the list of files in the archive o
+func readIndex(ctx context.Context, o fs.Object) (idx *archiveIndex, err error) {
+	kind := archiveKindFromName(o.Remote())
+	fs.Debugf(o, "Reading %s archive index", kind)
+	var (
+		members  []*member
+		sevenZip *sevenZipHeaders
+	)
+	switch kind {
+	case kindZip:
+		members, err = readZipIndex(newRangeReaderAt(ctx, o))
+	case kindTar:
+		members, err = readTarIndex(newRangeReaderAt(ctx, o))
+	case kindTarGz, kindTarZst:
+		members, err = readCompressedTarIndex(ctx, o, kind)
+	case kind7z:
+		members, sevenZip, err = readSevenZipIndex(newRangeReaderAt(ctx, o))
+	default:
+		err = errors.New("not an archive")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s archive %q: %w", kind, o.Remote(), err)
+	}
+	idx = newArchiveIndex(o, kind, members)
+	idx.sevenZip = sevenZip
+	return idx, nil
+}
+
+// newArchiveIndex makes the index from the members found in the
+// archive adding any missing directories
+func newArchiveIndex(o fs.Object, kind archiveKind, members []*member) *archiveIndex {
+	idx := &archiveIndex{
+		o:        o,
+		kind:     kind,
+		members:  make(map[string]*member, len(members)),
+		children: map[string][]*member{"": nil},
+	}
+	modTime := o.ModTime(context.Background())
+	for _, m := range members {
+		name := path.Clean("/" + strings.ReplaceAll(m.name, "\\", "/"))[1:]
+		if name == "" || strings.HasPrefix(m.name, "../") || strings.Contains(m.name, "/../") {
+			fs.Debugf(o, "Ignoring archive member with bad name %q", m.name)
+			continue
+		}
+		m.name = name
+		if existing, found := idx.members[name]; found && existing.dir != m.dir {
+			fs.Debugf(o, "Ignoring archive member %q which is both a file and a directory", name)
+			continue
+		}
+		// Add any missing parent directories
+		ok := true
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			parent, found := idx.members[dir]
+			if !found {
+				idx.members[dir] = &member{name: dir, dir: true, modTime: modTime}
+			} else if !parent.dir {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			fs.Debugf(o, "Ignoring archive member %q which is inside a file", name)
+			continue
+		}
+		// Later members replace earlier ones as with tar
+		idx.members[name] = m
+	}
+	for name, m := range idx.members {
+		parent := path.Dir(name)
+		if parent == "." {
+			parent = ""
+		}
+		idx.children[parent] = append(idx.children[parent], m)
+		if m.dir {
+			if _, found := idx.children[name]; !found {
+				idx.children[name] = nil
+			}
+		}
+	}
+	for _, children := range idx.children {
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].name < children[j].name
+		})
+	}
+	return idx
+}
+
+// isTarFile returns true if hdr is a file which can be read
+func isTarFile(hdr *tar.Header) bool {
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != '\x00' {
+		return false
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return false
+		}
+	}
+	return true
+}
+
+// tarMember makes a member from a tar header returning nil if it
+// should be ignored
+func tarMember(hdr *tar.Header, offset int64) *member {
+	switch {
+	case hdr.Typeflag == tar.TypeDir:
+		return &member{name: hdr.Name, dir: true, modTime: hdr.ModTime}
+	case isTarFile(hdr):
+		return &member{name: hdr.Name, size: hdr.Size, modTime: hdr.ModTime, offset: offset}
+	}
+	fs.Debugf(nil, "Ignoring tar member %q of type %q", hdr.Name, hdr.Typeflag)
+	return nil
+}
+
+// readTarIndex reads the headers of a tar archive
+//
+// The tar reader seeks over the file data so only the blocks
+// containing the headers are read.
+func readTarIndex(ra *rangeReaderAt) (members []*member, err error) {
+	sr := io.NewSectionReader(ra, 0, ra.size)
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if m := tarMember(hdr, offset); m != nil {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	in io.Reader
+	n  int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// newDecompressor returns a reader which decompresses in
+func newDecompressor(in io.Reader, kind archiveKind) (io.ReadCloser, error) {
+	switch kind {
+	case kindTarGz:
+		return gzip.NewReader(in)
+	case kindTarZst:
+		dec, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%v isn't compressed", kind)
+}
+
+// readCompressedTarIndex reads the headers of a compressed tar
+// archive
+//
+// The compressed stream can't be seeked so this reads the whole
+// archive. The offsets are in the uncompressed stream.
+func readCompressedTarIndex(ctx context.Context, o fs.Object, kind archiveKind) (members []*member, err error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	dec, err := newDecompressor(in, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(dec, &err)
+	counter := &countingReader{in: dec}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m := tarMember(hdr, counter.n); m != nil {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// Size of the blocks read and cached when reading archive indexes
+const (
+	blockSize = 1024 * 1024
+	maxBlocks = 16
+)
+
+// rangeReaderAt reads an object with ranged requests caching the
+// blocks read
+//
+// This is used to read the archive indexes which are read in small
+// pieces.
+type rangeReaderAt struct {
+	ctx    context.Context
+	o      fs.Object
+	size   int64
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	order  []int64 // blocks in the order they were read
+	reads  int     // number of requests made
+}
+
+// newRangeReaderAt makes a rangeReaderAt to read o
+func newRangeReaderAt(ctx context.Context, o fs.Object) *rangeReaderAt {
+	return &rangeReaderAt{
+		ctx:    ctx,
+		o:      o,
+		size:   o.Size(),
+		blocks: map[int64][]byte{},
+	}
+}
+
+// block returns block number n reading it if necessary
+func (ra *rangeReaderAt) block(n int64) (data []byte, err error) {
+	if data, found := ra.blocks[n]; found {
+		return data, nil
+	}
+	start := n * blockSize
+	end := start + blockSize
+	if end > ra.size {
+		end = ra.size
+	}
+	in, err := ra.o.Open(ra.ctx, &fs.RangeOption{Start: start, End: end - 1})
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	ra.reads++
+	data = make([]byte, end-start)
+	_, err = io.ReadFull(in, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(ra.order) >= maxBlocks {
+		delete(ra.blocks, ra.order[0])
+		ra.order = ra.order[1:]
+	}
+	ra.blocks[n] = data
+	ra.order = append(ra.order, n)
+	return data, nil
+}
+
+// ReadAt reads len(p) bytes at off
+func (ra *rangeReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	for n < len(p) {
+		if off >= ra.size {
+			return n, io.EOF
+		}
+		block, err := ra.block(off / blockSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], block[off%blockSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
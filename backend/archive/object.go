@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object is a file on the wrapped remote which isn't an archive
+type Object struct {
+	fs.Object
+	f      *Fs
+	remote string
+}
+
+// newObject wraps o which is at remote
+func (f *Fs) newObject(o fs.Object, remote string) *Object {
+	return &Object{
+		Object: o,
+		f:      f,
+		remote: remote,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.Object.Update(ctx, in, fs.NewOverrideRemote(src, o.Object.Remote()), options...)
+}
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object {
+	return o.Object
+}
+
+// Member is a file inside an archive
+type Member struct {
+	f      *Fs
+	idx    *archiveIndex
+	m      *member
+	remote string
+}
+
+// newMember makes a Member for m in the archive idx which is at remote
+func (f *Fs) newMember(idx *archiveIndex, m *member, remote string) *Member {
+	return &Member{
+		f:      f,
+		idx:    idx,
+		m:      m,
+		remote: remote,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Member) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Member) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Member) Remote() string {
+	return o.remote
+}
+
+// Hash returns the selected checksum of the file
+//
+// Only zip and 7z archives store a checksum (CRC32) of their members.
+// It is blank for the hashes of the wrapped remote which the Fs
+// supports when its root is outside an archive.
+func (o *Member) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if !o.f.Hashes().Contains(ht) {
+		return "", hash.ErrUnsupported
+	}
+	if ht != hash.CRC32 || (o.idx.kind != kindZip && o.idx.kind != kind7z) {
+		return "", nil
+	}
+	if o.m.crc == 0 && o.m.size != 0 {
+		// 7z archives may leave out the CRC
+		return "", nil
+	}
+	return fmt.Sprintf("%08x", o.m.crc), nil
+}
+
+// Size returns the size of the file
+func (o *Member) Size() int64 {
+	return o.m.size
+}
+
+// ModTime returns the modification time of the file
+func (o *Member) ModTime(ctx context.Context) time.Time {
+	return o.m.modTime
+}
+
+// SetModTime sets the modification time of the file
+func (o *Member) SetModTime(ctx context.Context, modTime time.Time) error {
+	return errReadOnly
+}
+
+// Storable says whether this object can be stored
+func (o *Member) Storable() bool {
+	return true
+}
+
+// Open an object for read
+//
+// Only the part of the archive holding the member is read, except
+// for compressed tar archives which have to be read from the start
+// and solid 7z blocks which have to be read from the start of the
+// block.
+func (o *Member) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.m.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if offset > o.m.size {
+		offset = o.m.size
+	}
+	if limit < 0 || offset+limit > o.m.size {
+		limit = o.m.size - offset
+	}
+	arc := o.idx.o
+	switch o.idx.kind {
+	case kindZip:
+		return openZipMember(ctx, arc, o.m, offset, limit)
+	case kind7z:
+		return openSevenZipMember(ctx, arc, o.idx.sevenZip, o.m, offset, limit)
+	case kindTar:
+		if limit == 0 {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		start := o.m.offset + offset
+		return arc.Open(ctx, &fs.RangeOption{Start: start, End: start + limit - 1})
+	}
+	return openCompressedTarMember(ctx, arc, o.idx.kind, o.m.offset+offset, limit)
+}
+
+// openCompressedTarMember reads limit bytes from offset in the
+// uncompressed stream of a compressed tar archive
+func openCompressedTarMember(ctx context.Context, arc fs.Object, kind archiveKind, offset, limit int64) (rc io.ReadCloser, err error) {
+	in, err := arc.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := newDecompressor(in, kind)
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	r := &compressedTarReader{
+		Reader: io.LimitReader(dec, limit),
+		in:     in,
+		dec:    dec,
+	}
+	if _, err := io.CopyN(io.Discard, dec, offset); err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("failed to skip to archive member: %w", err)
+	}
+	return r, nil
+}
+
+// compressedTarReader reads a member of a compressed tar archive
+type compressedTarReader struct {
+	io.Reader
+	in  io.ReadCloser
+	dec io.ReadCloser
+}
+
+// Close closes the decompressor and the archive
+func (r *compressedTarReader) Close() error {
+	err := r.dec.Close()
+	if closeErr := r.in.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Member) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errReadOnly
+}
+
+// Remove an object
+func (o *Member) Remove(ctx context.Context) error {
+	return errReadOnly
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object          = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+	_ fs.Object          = (*Member)(nil)
+)
@@ -0,0 +1,368 @@
+package archive
+
+// The zip central directory is read here rather than with archive/zip
+// so that the reads are made in large blocks and so the offsets of
+// the members are known without reading their local headers.
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+)
+
+// Zip signatures and sizes
+const (
+	zipLocalHeaderSig      = 0x04034b50
+	zipCentralHeaderSig    = 0x02014b50
+	zipEndSig              = 0x06054b50
+	zip64EndLocatorSig     = 0x07064b50
+	zip64EndSig            = 0x06064b50
+	zipLocalHeaderLen      = 30
+	zipCentralHeaderLen    = 46
+	zipEndLen              = 22
+	zip64EndLocatorLen     = 20
+	zip64EndLen            = 56
+	zipMaxCommentLen       = 0xffff
+	zipMaxCentralDirectory = 1 << 30
+)
+
+// Zip compression methods which can be read
+const (
+	zipStore   = 0
+	zipDeflate = 8
+	zipBzip2   = 12
+	zipZstd    = 93
+)
+
+// readZipIndex reads the central directory of a zip archive
+func readZipIndex(ra *rangeReaderAt) (members []*member, err error) {
+	// Find the end of central directory record in the last 64k
+	tailLen := int64(zipEndLen + zipMaxCommentLen)
+	if tailLen > ra.size {
+		tailLen = ra.size
+	}
+	tailOffset := ra.size - tailLen
+	tail := make([]byte, tailLen)
+	if _, err := ra.ReadAt(tail, tailOffset); err != nil {
+		return nil, err
+	}
+	end := -1
+	for i := len(tail) - zipEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == zipEndSig {
+			commentLen := int(binary.LittleEndian.Uint16(tail[i+20:]))
+			if i+zipEndLen+commentLen <= len(tail) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return nil, errors.New("not a zip file")
+	}
+	record := tail[end:]
+	count := uint64(binary.LittleEndian.Uint16(record[10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(record[12:]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(record[16:]))
+
+	// Use the zip64 end of central directory record if there is one
+	if locator := end - zip64EndLocatorLen; locator >= 0 && binary.LittleEndian.Uint32(tail[locator:]) == zip64EndLocatorSig {
+		offset := int64(binary.LittleEndian.Uint64(tail[locator+8:]))
+		record := make([]byte, zip64EndLen)
+		if _, err := ra.ReadAt(record, offset); err != nil {
+			return nil, fmt.Errorf("failed to read zip64 end of central directory: %w", err)
+		}
+		if binary.LittleEndian.Uint32(record) != zip64EndSig {
+			return nil, errors.New("bad zip64 end of central directory")
+		}
+		count = binary.LittleEndian.Uint64(record[32:])
+		dirSize = binary.LittleEndian.Uint64(record[40:])
+		dirOffset = binary.LittleEndian.Uint64(record[48:])
+	}
+	if dirSize > zipMaxCentralDirectory || dirOffset+dirSize > uint64(ra.size) {
+		return nil, errors.New("bad central directory size")
+	}
+
+	dir := make([]byte, dirSize)
+	if _, err := ra.ReadAt(dir, int64(dirOffset)); err != nil {
+		return nil, fmt.Errorf("failed to read central directory: %w", err)
+	}
+	for len(dir) > 0 {
+		m, n, err := parseZipCentralHeader(dir)
+		if err != nil {
+			return nil, err
+		}
+		dir = dir[n:]
+		members = append(members, m)
+	}
+	if uint64(len(members)) != count {
+		fs.Debugf(nil, "Zip central directory has %d entries but expected %d", len(members), count)
+	}
+	return members, nil
+}
+
+// parseZipCentralHeader parses a central directory header at the
+// start of b returning it and its length
+func parseZipCentralHeader(b []byte) (m *member, n int, err error) {
+	if len(b) < zipCentralHeaderLen || binary.LittleEndian.Uint32(b) != zipCentralHeaderSig {
+		return nil, 0, errors.New("bad central directory header")
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b[28:]))
+	extraLen := int(binary.LittleEndian.Uint16(b[30:]))
+	commentLen := int(binary.LittleEndian.Uint16(b[32:]))
+	n = zipCentralHeaderLen + nameLen + extraLen + commentLen
+	if len(b) < n {
+		return nil, 0, errors.New("truncated central directory header")
+	}
+	m = &member{
+		flags:  binary.LittleEndian.Uint16(b[8:]),
+		method: binary.LittleEndian.Uint16(b[10:]),
+		crc:    binary.LittleEndian.Uint32(b[16:]),
+		csize:  int64(binary.LittleEndian.Uint32(b[20:])),
+		size:   int64(binary.LittleEndian.Uint32(b[24:])),
+		offset: int64(binary.LittleEndian.Uint32(b[42:])),
+	}
+	m.modTime = msDosTime(binary.LittleEndian.Uint16(b[14:]), binary.LittleEndian.Uint16(b[12:]))
+	name := b[zipCentralHeaderLen : zipCentralHeaderLen+nameLen]
+	if m.flags&0x800 == 0 && !utf8.Valid(name) {
+		// Not UTF-8 so probably code page 437 - show the
+		// bytes as Latin-1 which is at least valid
+		runes := make([]rune, len(name))
+		for i, c := range name {
+			runes[i] = rune(c)
+		}
+		name = []byte(string(runes))
+	}
+	m.name = string(name)
+	if strings.HasSuffix(m.name, "/") {
+		m.dir = true
+		m.name = strings.TrimRight(m.name, "/")
+	}
+
+	// Read the extra fields for zip64 sizes and better times
+	extra := b[zipCentralHeaderLen+nameLen : zipCentralHeaderLen+nameLen+extraLen]
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		switch id {
+		case 0x0001: // zip64
+			for _, x := range []*int64{&m.size, &m.csize, &m.offset} {
+				if *x != 0xffffffff {
+					continue
+				}
+				if len(field) < 8 {
+					return nil, 0, errors.New("bad zip64 extra field")
+				}
+				*x = int64(binary.LittleEndian.Uint64(field))
+				field = field[8:]
+			}
+		case 0x5455: // extended timestamp
+			if len(field) >= 5 && field[0]&1 != 0 {
+				m.modTime = time.Unix(int64(int32(binary.LittleEndian.Uint32(field[1:]))), 0)
+			}
+		}
+	}
+	if m.size < 0 || m.csize < 0 || m.offset < 0 {
+		return nil, 0, errors.New("bad sizes in central directory header")
+	}
+	return m, n, nil
+}
+
+// msDosTime converts an MS-DOS date and time into a time.Time
+//
+// The time zone isn't stored so these are treated as UTC.
+func msDosTime(dosDate, dosTime uint16) time.Time {
+	return time.Date(
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0,
+		time.UTC,
+	)
+}
+
+// openZipMember opens the zip member m in the archive o
+//
+// If the whole member is wanted then the local header and the
+// compressed data are read with one request. Part of a stored member
+// is read with a request for the local header and another for the
+// part.
+func openZipMember(ctx context.Context, o fs.Object, m *member, offset, limit int64) (rc io.ReadCloser, err error) {
+	if m.flags&0x1 != 0 {
+		return nil, errors.New("encrypted zip members aren't supported")
+	}
+	switch m.method {
+	case zipStore, zipDeflate, zipBzip2, zipZstd:
+	default:
+		return nil, fmt.Errorf("zip compression method %d isn't supported", m.method)
+	}
+	partial := offset != 0 || limit != m.size
+	if m.method == zipStore && partial {
+		dataOffset, err := zipDataOffset(ctx, o, m)
+		if err != nil {
+			return nil, err
+		}
+		if limit == 0 {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return o.Open(ctx, &fs.RangeOption{Start: dataOffset + offset, End: dataOffset + offset + limit - 1})
+	}
+
+	// Read the header and the data in one request allowing
+	// for the largest possible name and extra field
+	end := m.offset + zipLocalHeaderLen + 2*0xffff + m.csize
+	if end > o.Size() {
+		end = o.Size()
+	}
+	in, err := o.Open(ctx, &fs.RangeOption{Start: m.offset, End: end - 1})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = in.Close()
+		}
+	}()
+	header := make([]byte, zipLocalHeaderLen)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, fmt.Errorf("failed to read local header: %w", err)
+	}
+	skip, err := parseZipLocalHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, in, skip); err != nil {
+		return nil, fmt.Errorf("failed to read local header: %w", err)
+	}
+	compressed := io.LimitReader(in, m.csize)
+	var out io.Reader
+	var closeOut func() error
+	switch m.method {
+	case zipStore:
+		out = compressed
+	case zipDeflate:
+		dec := flate.NewReader(compressed)
+		out, closeOut = dec, dec.Close
+	case zipBzip2:
+		out = bzip2.NewReader(compressed)
+	case zipZstd:
+		dec, err := zstd.NewReader(compressed, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		out, closeOut = dec, func() error { dec.Close(); return nil }
+	}
+	r := &memberReader{in: in, out: out, closeOut: closeOut, remaining: m.size}
+	if !partial {
+		r.hash, r.crc = crc32.NewIEEE(), m.crc
+	} else {
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r.remaining = limit
+	}
+	return r, nil
+}
+
+// zipDataOffset reads the local header of m to find where its data
+// starts
+func zipDataOffset(ctx context.Context, o fs.Object, m *member) (int64, error) {
+	in, err := o.Open(ctx, &fs.RangeOption{Start: m.offset, End: m.offset + zipLocalHeaderLen - 1})
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, zipLocalHeaderLen)
+	_, err = io.ReadFull(in, header)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read local header: %w", err)
+	}
+	skip, err := parseZipLocalHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	return m.offset + zipLocalHeaderLen + skip, nil
+}
+
+// parseZipLocalHeader checks a local header returning the length of
+// the name and extra field which follow it
+func parseZipLocalHeader(header []byte) (int64, error) {
+	if binary.LittleEndian.Uint32(header) != zipLocalHeaderSig {
+		return 0, errors.New("bad local header")
+	}
+	nameLen := int64(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(header[28:]))
+	return nameLen + extraLen, nil
+}
+
+// memberReader reads the uncompressed data of an archive member
+// checking the CRC if reading all of it
+type memberReader struct {
+	in        io.Closer    // the ranged request
+	out       io.Reader    // the decompressed data
+	closeOut  func() error // closes out if set
+	remaining int64        // bytes left to return
+	hash      hash.Hash32  // if set check the CRC at the end
+	crc       uint32       // expected CRC
+}
+
+// Read reads the uncompressed data
+func (r *memberReader) Read(p []byte) (n int, err error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err = r.out.Read(p)
+	r.remaining -= int64(n)
+	if r.hash != nil {
+		_, _ = r.hash.Write(p[:n])
+	}
+	if r.remaining <= 0 {
+		if r.hash != nil && r.hash.Sum32() != r.crc {
+			return n, errors.New("archive member CRC mismatch")
+		}
+		return n, io.EOF
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close closes the decompressor and the ranged request
+func (r *memberReader) Close() error {
+	var err error
+	if r.closeOut != nil {
+		err = r.closeOut()
+	}
+	if closeErr := r.in.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
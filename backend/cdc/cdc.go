@@ -0,0 +1,735 @@
+// Package cdc provides a deduplicating wrapper which splits files
+// into content defined chunks.
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	filesDir        = "files"  // directory on the remote for the manifests
+	chunksDir       = "chunks" // directory on the remote for the chunks
+	manifestExt     = ".cdc"   // extension of manifest files
+	manifestVersion = 1        // version of the manifest format
+	minChunkSize    = 64 * 1024
+	maxChunkSize    = 64 * 1024 * 1024
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "cdc",
+		Description: "Deduplicate a remote with content defined chunking",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name:     "remote",
+			Required: true,
+			Help: `Remote to store the chunks and manifests in.
+
+Normally should contain a ':' and a path, e.g. "myremote:path/to/dir",
+"myremote:bucket" or maybe "myremote:" (not recommended).`,
+		}, {
+			Name:    "chunk_size",
+			Default: fs.SizeSuffix(1024 * 1024),
+			Help: `Average size of the chunks.
+
+Chunks are between a quarter and four times this size. Smaller chunks
+find more duplicate data but need more objects on the remote.
+
+This must be a power of 2 between 64 KiB and 64 MiB. Changing it
+stops new files deduplicating against the ones already stored.`,
+		}, {
+			Name:     "upload_concurrency",
+			Default:  4,
+			Advanced: true,
+			Help: `Number of chunks of a file to upload at once.
+
+Each chunk being uploaded is held in memory so this uses up to
+upload_concurrency * chunk_size * 4 bytes of memory per transfer.`,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote            string        `config:"remote"`
+	ChunkSize         fs.SizeSuffix `config:"chunk_size"`
+	UploadConcurrency int           `config:"upload_concurrency"`
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+	wrapper  fs.Fs
+	files    fs.Fs // manifests under the root
+	chunks   fs.Fs // all the chunks
+
+	mu    sync.Mutex
+	known map[string]time.Time // chunks known to be stored with when they were written or touched
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point cdc remote at itself - check the value of the remote setting")
+	}
+	if opt.ChunkSize < minChunkSize || opt.ChunkSize > maxChunkSize || bits.OnesCount64(uint64(opt.ChunkSize)) != 1 {
+		return nil, fmt.Errorf("chunk_size %v must be a power of 2 between %v and %v", opt.ChunkSize, fs.SizeSuffix(minChunkSize), fs.SizeSuffix(maxChunkSize))
+	}
+	if opt.UploadConcurrency < 1 {
+		opt.UploadConcurrency = 1
+	}
+	rpath = strings.Trim(rpath, "/")
+
+	chunks, err := cache.Get(ctx, fspath.JoinRootPath(opt.Remote, chunksDir))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q for chunks: %w", opt.Remote, err)
+	}
+	f, err := newFs(ctx, name, rpath, opt, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check to see if the root is a file
+	if rpath != "" {
+		parent := path.Dir(rpath)
+		if parent == "." {
+			parent = ""
+		}
+		pf, err := newFs(ctx, name, parent, opt, chunks)
+		if err != nil {
+			return nil, err
+		}
+		_, err = pf.NewObject(ctx, path.Base(rpath))
+		if err == nil {
+			return pf, fs.ErrorIsFile
+		}
+	}
+	return f, nil
+}
+
+// newFs makes an Fs with the manifests in the files directory at root
+func newFs(ctx context.Context, name, root string, opt *Options, chunks fs.Fs) (*Fs, error) {
+	files, err := cache.Get(ctx, fspath.JoinRootPath(opt.Remote, path.Join(filesDir, root)))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q for manifests: %w", opt.Remote, err)
+	}
+	f := &Fs{
+		name:   name,
+		root:   root,
+		opt:    *opt,
+		files:  files,
+		chunks: chunks,
+		known:  make(map[string]time.Time),
+	}
+	// the features here are ones we could support, and they are
+	// ANDed with the ones from the wrapped fs
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+	}).Fill(ctx, f).Mask(ctx, files).WrapsFs(f, files)
+	// These only write manifests so work on any remote
+	f.features.Copy = f.Copy
+	f.features.Move = f.Move
+	f.features.PutStream = f.PutStream
+	cache.PinUntilFinalized(files, f)
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("CDC '%s:%s'", f.name, f.root)
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return f.files.Precision()
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.NewHashSet(hash.MD5, hash.SHA1)
+}
+
+// makeManifestName returns the name of the manifest for remote of size
+//
+// The size is stored in the name so listings don't need to read the
+// manifests.
+func makeManifestName(remote string, size int64) string {
+	return remote + "." + strconv.FormatInt(size, 10) + manifestExt
+}
+
+// parseManifestName returns the remote and size of the manifest name
+func parseManifestName(name string) (remote string, size int64, err error) {
+	if !strings.HasSuffix(name, manifestExt) {
+		return "", -1, errors.New("missing manifest extension")
+	}
+	name = name[:len(name)-len(manifestExt)]
+	dot := strings.LastIndex(name, ".")
+	if dot <= 0 || strings.HasSuffix(name[:dot], "/") {
+		return "", -1, errors.New("missing size")
+	}
+	size, err = strconv.ParseInt(name[dot+1:], 10, 64)
+	if err != nil || size < 0 {
+		return "", -1, fmt.Errorf("bad size %q", name[dot+1:])
+	}
+	return name[:dot], size, nil
+}
+
+// chunkName returns the name of the chunk with the hex sha256 hash
+//
+// Chunks are spread over 256 directories so no directory gets too
+// big.
+func chunkName(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// newObjectFromManifest makes an Object from the manifest object mo
+func (f *Fs) newObjectFromManifest(mo fs.Object) (*Object, error) {
+	remote, size, err := parseManifestName(mo.Remote())
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		f:      f,
+		mo:     mo,
+		remote: remote,
+		size:   size,
+	}, nil
+}
+
+// processEntries converts the manifests in entries into Objects
+func (f *Fs) processEntries(entries fs.DirEntries) (newEntries fs.DirEntries) {
+	newEntries = entries[:0] // in place filter
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Object:
+			o, err := f.newObjectFromManifest(x)
+			if err != nil {
+				fs.Debugf(x, "Ignoring file which isn't a manifest: %v", err)
+				continue
+			}
+			newEntries = append(newEntries, o)
+		case fs.Directory:
+			newEntries = append(newEntries, x)
+		default:
+			panic(fmt.Sprintf("unknown entry type %T", entry))
+		}
+	}
+	return newEntries
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = f.files.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.processEntries(entries), nil
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	do := f.files.Features().ListR
+	return do(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.processEntries(entries))
+	})
+}
+
+// findManifest finds the manifest for remote by listing its directory
+func (f *Fs) findManifest(ctx context.Context, remote string) (*Object, error) {
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := f.List(ctx, dir)
+	if err == fs.ErrorDirNotFound {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok && o.remote == remote {
+			return o, nil
+		}
+	}
+	return nil, fs.ErrorObjectNotFound
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.findManifest(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// chunkRef is a reference to a chunk in a manifest
+type chunkRef struct {
+	Hash string `json:"hash"` // hex sha256 of the chunk
+	Size int64  `json:"size"` // size of the chunk
+}
+
+// manifest describes how a file is made from chunks
+type manifest struct {
+	Version int        `json:"version"`
+	Size    int64      `json:"size"`
+	MD5     string     `json:"md5"`
+	SHA1    string     `json:"sha1"`
+	Chunks  []chunkRef `json:"chunks"`
+}
+
+// readManifest reads and decodes the manifest object mo
+func readManifest(ctx context.Context, mo fs.Object) (m *manifest, err error) {
+	rc, err := mo.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(rc, &err)
+	m = new(manifest)
+	if err = json.NewDecoder(rc).Decode(m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	var size int64
+	for _, chunk := range m.Chunks {
+		if len(chunk.Hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("bad chunk hash %q in manifest", chunk.Hash)
+		}
+		size += chunk.Size
+	}
+	if size != m.Size {
+		return nil, fmt.Errorf("manifest chunks add up to %d bytes but file is %d bytes", size, m.Size)
+	}
+	return m, nil
+}
+
+// knownSince returns true if this process wrote or touched the chunk
+// with hash at or after since
+func (f *Fs) knownSince(hash string, since time.Time) bool {
+	f.mu.Lock()
+	t, ok := f.known[hash]
+	f.mu.Unlock()
+	return ok && !t.Before(since)
+}
+
+// addKnown records that the chunk with hash was stored at t
+func (f *Fs) addKnown(hash string, t time.Time) {
+	f.mu.Lock()
+	if t.After(f.known[hash]) {
+		f.known[hash] = t
+	}
+	f.mu.Unlock()
+}
+
+// forgetKnown forgets that the chunks in hashes are stored
+func (f *Fs) forgetKnown(hashes []string) {
+	f.mu.Lock()
+	for _, hash := range hashes {
+		delete(f.known, hash)
+	}
+	f.mu.Unlock()
+}
+
+// putChunk uploads data as the chunk with hash unless it is already
+// stored.
+//
+// A chunk which is already stored but older than since, the start of
+// the upload, has its modification time set to now so gc sees it as
+// in use until the manifest using it is written. Another process may
+// have run gc so the chunk is looked up unless this process wrote or
+// touched it since then.
+func (f *Fs) putChunk(ctx context.Context, hash string, data []byte, since time.Time) error {
+	if f.knownSince(hash, since) {
+		return nil
+	}
+	o, err := f.chunks.NewObject(ctx, chunkName(hash))
+	switch {
+	case err == nil:
+		if modTime := o.ModTime(ctx); !modTime.Before(since) {
+			f.addKnown(hash, modTime)
+			return nil
+		}
+		now := time.Now()
+		err = o.SetModTime(ctx, now)
+		if err == nil {
+			f.addKnown(hash, now)
+			return nil
+		}
+		if !errors.Is(err, fs.ErrorCantSetModTime) && !errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			return fmt.Errorf("failed to touch chunk %s: %w", hash, err)
+		}
+		// Upload it again to update its modification time
+		fs.Debugf(o, "Uploading reused chunk again as its modification time can't be set")
+	case err == fs.ErrorObjectNotFound || err == fs.ErrorDirNotFound:
+	default:
+		return fmt.Errorf("failed to check chunk %s: %w", hash, err)
+	}
+	now := time.Now()
+	info := object.NewStaticObjectInfo(chunkName(hash), now, int64(len(data)), true, nil, f.chunks)
+	if _, err = f.chunks.Put(ctx, bytes.NewReader(data), info); err != nil {
+		return fmt.Errorf("failed to upload chunk %s: %w", hash, err)
+	}
+	f.addKnown(hash, now)
+	return nil
+}
+
+// putChunks splits in into chunks uploading the ones which aren't
+// already stored and returns the manifest for the file.
+func (f *Fs) putChunks(ctx context.Context, in io.Reader) (*manifest, error) {
+	since := time.Now()
+	m := &manifest{
+		Version: manifestVersion,
+		Chunks:  []chunkRef{},
+	}
+	md5Hasher, sha1Hasher := md5.New(), sha1.New()
+	in = io.TeeReader(in, io.MultiWriter(md5Hasher, sha1Hasher))
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(f.opt.UploadConcurrency)
+	uploading := make(map[string]struct{})
+	s := newSplitter(in, int(f.opt.ChunkSize))
+	for gCtx.Err() == nil {
+		chunk, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = g.Wait()
+			return nil, err
+		}
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		m.Chunks = append(m.Chunks, chunkRef{Hash: hash, Size: int64(len(chunk))})
+		m.Size += int64(len(chunk))
+		if _, ok := uploading[hash]; ok {
+			continue
+		}
+		uploading[hash] = struct{}{}
+		// The splitter reuses its buffer so copy the chunk
+		data := append([]byte(nil), chunk...)
+		g.Go(func() error {
+			return f.putChunk(gCtx, hash, data, since)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.MD5 = hex.EncodeToString(md5Hasher.Sum(nil))
+	m.SHA1 = hex.EncodeToString(sha1Hasher.Sum(nil))
+	return m, nil
+}
+
+// putManifest writes m as the manifest for remote replacing old if set
+func (f *Fs) putManifest(ctx context.Context, remote string, modTime time.Time, m *manifest, old *Object) (*Object, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	name := makeManifestName(remote, m.Size)
+	info := object.NewStaticObjectInfo(name, modTime, int64(len(data)), true, nil, f.files)
+	var mo fs.Object
+	if old != nil && old.mo.Remote() == name {
+		err = old.mo.Update(ctx, bytes.NewReader(data), info)
+		mo = old.mo
+	} else {
+		mo, err = f.files.Put(ctx, bytes.NewReader(data), info)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if old != nil && old.mo.Remote() != name {
+		if err = old.mo.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("failed to remove old manifest: %w", err)
+		}
+	}
+	return &Object{
+		f:        f,
+		mo:       mo,
+		remote:   remote,
+		size:     m.Size,
+		manifest: m,
+	}, nil
+}
+
+// put uploads in as remote replacing old if set
+func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, old *Object) (*Object, error) {
+	m, err := f.putChunks(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if size := src.Size(); size >= 0 && size != m.Size {
+		return nil, fmt.Errorf("source size %d doesn't match %d bytes read", size, m.Size)
+	}
+	return f.putManifest(ctx, src.Remote(), src.ModTime(ctx), m, old)
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	old, err := f.findManifest(ctx, src.Remote())
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return nil, err
+	}
+	return f.put(ctx, in, src, old)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.files.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return f.files.Rmdir(ctx, dir)
+}
+
+// Purge all files in the directory
+//
+// Only the manifests are removed. The chunks are removed by the gc
+// command.
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	do := f.files.Features().Purge
+	if do == nil {
+		return fs.ErrorCantPurge
+	}
+	return do(ctx, dir)
+}
+
+// copyManifest writes a manifest for remote with the chunks of src
+func (f *Fs) copyManifest(ctx context.Context, src fs.Object, remote string) (*Object, *Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || srcObj.f.chunks.Name() != f.chunks.Name() || srcObj.f.chunks.Root() != f.chunks.Root() {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil, nil, fs.ErrorCantCopy
+	}
+	m, err := srcObj.getManifest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	old, err := f.findManifest(ctx, remote)
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return nil, nil, err
+	}
+	o, err := f.putManifest(ctx, remote, src.ModTime(ctx), m, old)
+	return o, srcObj, err
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// Only a new manifest is written as the chunks are shared.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	o, _, err := f.copyManifest(ctx, src, remote)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	o, srcObj, err := f.copyManifest(ctx, src, remote)
+	if err == fs.ErrorCantCopy {
+		return nil, fs.ErrorCantMove
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = srcObj.mo.Remove(ctx); err != nil {
+		return o, fmt.Errorf("failed to remove source manifest: %w", err)
+	}
+	return o, nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.files.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	return do(ctx, srcFs.files, srcRemote, dstRemote)
+}
+
+// CleanUp the trash in the Fs
+//
+// Implement this if you have a way of emptying the trash or
+// otherwise cleaning up old versions of files.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	do := f.files.Features().CleanUp
+	if do == nil {
+		return errors.New("not supported by underlying remote")
+	}
+	return do(ctx)
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	do := f.files.Features().About
+	if do == nil {
+		return nil, errors.New("not supported by underlying remote")
+	}
+	return do(ctx)
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	for _, wrapped := range []fs.Fs{f.files, f.chunks} {
+		if do := wrapped.Features().Shutdown; do != nil {
+			if err := do(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.files
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.Purger      = (*Fs)(nil)
+	_ fs.Copier      = (*Fs)(nil)
+	_ fs.Mover       = (*Fs)(nil)
+	_ fs.DirMover    = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.ListRer     = (*Fs)(nil)
+	_ fs.Commander   = (*Fs)(nil)
+	_ fs.CleanUpper  = (*Fs)(nil)
+	_ fs.Abouter     = (*Fs)(nil)
+	_ fs.Shutdowner  = (*Fs)(nil)
+	_ fs.UnWrapper   = (*Fs)(nil)
+	_ fs.Wrapper     = (*Fs)(nil)
+)
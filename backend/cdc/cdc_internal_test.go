@@ -0,0 +1,300 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChunkSize = 64 * 1024
+
+// randomData returns n bytes of reproducible random data
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// split returns the chunks of data
+func split(t *testing.T, data []byte) (chunks [][]byte) {
+	s := newSplitter(bytes.NewReader(data), testChunkSize)
+	for {
+		chunk, err := s.next()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestSplitter(t *testing.T) {
+	data := randomData(1, 8*1024*1024)
+	chunks := split(t, data)
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), testChunkSize*4, i)
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), testChunkSize/4, i)
+		}
+	}
+	// Check the average is about right
+	avg := len(data) / len(chunks)
+	assert.Greater(t, avg, testChunkSize/2)
+	assert.Less(t, avg, testChunkSize*2)
+
+	// Short and empty inputs
+	assert.Equal(t, [][]byte{[]byte("hello")}, split(t, []byte("hello")))
+	assert.Nil(t, split(t, nil))
+}
+
+func TestSplitterInsert(t *testing.T) {
+	data := randomData(2, 4*1024*1024)
+	edited := append(append(append([]byte(nil), data[:1000000]...), "inserted"...), data[1000000:]...)
+	seen := map[string]bool{}
+	for _, chunk := range split(t, data) {
+		seen[string(chunk)] = true
+	}
+	changed := 0
+	for _, chunk := range split(t, edited) {
+		if !seen[string(chunk)] {
+			changed++
+		}
+	}
+	// Only the chunks around the insert should change
+	assert.Greater(t, changed, 0)
+	assert.LessOrEqual(t, changed, 2)
+}
+
+func TestManifestName(t *testing.T) {
+	name := makeManifestName("dir/file.txt", 1234)
+	assert.Equal(t, "dir/file.txt.1234.cdc", name)
+	remote, size, err := parseManifestName(name)
+	require.NoError(t, err)
+	assert.Equal(t, "dir/file.txt", remote)
+	assert.Equal(t, int64(1234), size)
+	for _, bad := range []string{"file.txt", "file.cdc", ".1.cdc", "dir/.1.cdc", "file.x.cdc", "file.-1.cdc"} {
+		_, _, err := parseManifestName(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// newTestFs makes a cdc Fs on a temporary directory returning the
+// Fs and the directory
+func newTestFs(t *testing.T) (*Fs, string) {
+	fstest.Initialise()
+	dir := t.TempDir()
+	f, err := NewFs(context.Background(), "TestCDCInternal", "", configmap.Simple{
+		"remote":     dir,
+		"chunk_size": "64Ki",
+	})
+	require.NoError(t, err)
+	return f.(*Fs), dir
+}
+
+// put uploads data as remote to f
+func put(t *testing.T, f *Fs, remote string, data []byte) fs.Object {
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, nil)
+	o, err := f.Put(context.Background(), bytes.NewReader(data), info)
+	require.NoError(t, err)
+	return o
+}
+
+// read reads all of o
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) []byte {
+	rc, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	return data
+}
+
+// countChunks returns the number of chunks stored in f
+func countChunks(t *testing.T, f *Fs) (n int) {
+	err := walk.ListR(context.Background(), f.chunks, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		n += len(entries)
+		return nil
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func TestDedupe(t *testing.T) {
+	ctx := context.Background()
+	f, _ := newTestFs(t)
+	v1 := randomData(3, 2*1024*1024)
+	v2 := append(append(append([]byte(nil), v1[:500000]...), "new version"...), v1[500000:]...)
+
+	o1 := put(t, f, "v1.img", v1)
+	n1 := countChunks(t, f)
+	o2 := put(t, f, "v2.img", v2)
+	n2 := countChunks(t, f)
+	assert.LessOrEqual(t, n2-n1, 2, "second version should share most chunks")
+
+	assert.Equal(t, v1, read(t, o1))
+	assert.Equal(t, v2, read(t, o2))
+	assert.Equal(t, v2[123456:1234567], read(t, o2, &fs.RangeOption{Start: 123456, End: 1234566}))
+	assert.Equal(t, v2[len(v2)-10:], read(t, o2, &fs.SeekOption{Offset: int64(len(v2) - 10)}))
+
+	md5sum, err := o2.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	sum := md5.Sum(v2)
+	assert.Equal(t, hex.EncodeToString(sum[:]), md5sum)
+}
+
+// ageChunks makes the chunks stored in dir 2 hours old
+func ageChunks(t *testing.T, dir string) {
+	old := time.Now().Add(-2 * time.Hour)
+	err := filepath.Walk(filepath.Join(dir, chunksDir), func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chtimes(p, old, old)
+	})
+	require.NoError(t, err)
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t)
+	shared := randomData(4, 1024*1024)
+	o1 := put(t, f, "a", append(append([]byte(nil), shared...), randomData(5, 512*1024)...))
+	keep := append(append([]byte(nil), shared...), randomData(6, 512*1024)...)
+	o2 := put(t, f, "dir/b", keep)
+	total := countChunks(t, f)
+	require.NoError(t, o1.Remove(ctx))
+
+	// Young chunks are kept
+	stats, err := f.gc(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Manifests)
+	assert.Equal(t, total, stats.Chunks)
+	assert.Equal(t, 0, stats.Deleted)
+	assert.Equal(t, int64(len(keep)), stats.Logical)
+	assert.Equal(t, total, countChunks(t, f))
+
+	// min-age must be longer than the precision of the remote
+	_, err = f.gc(ctx, 0)
+	assert.ErrorContains(t, err, "not safe to run gc")
+
+	// Dry run doesn't remove anything
+	ageChunks(t, dir)
+	dryCtx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	stats, err = f.gc(dryCtx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Deleted)
+	assert.Greater(t, stats.Freed, int64(0))
+	assert.Equal(t, total, countChunks(t, f))
+
+	stats, err = f.gc(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Greater(t, stats.Deleted, 0)
+	assert.Equal(t, stats.Referenced, total-stats.Deleted)
+	assert.Equal(t, total-stats.Deleted, countChunks(t, f))
+	assert.Equal(t, keep, read(t, o2))
+
+	// Chunks removed by gc are uploaded again
+	o1 = put(t, f, "a", randomData(5, 512*1024))
+	assert.Equal(t, randomData(5, 512*1024), read(t, o1))
+}
+
+func TestGCReusedChunk(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t)
+	data := randomData(8, 512*1024)
+	o := put(t, f, "a", data)
+	require.NoError(t, o.Remove(ctx))
+
+	// Make the orphaned chunks look old
+	ageChunks(t, dir)
+
+	// Uploading the same data again reuses and touches the chunks
+	m, err := f.putChunks(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// gc in another process before the manifest is written mustn't
+	// remove them
+	other, err := NewFs(ctx, "TestCDCInternalOther", "", configmap.Simple{
+		"remote":     dir,
+		"chunk_size": "64Ki",
+	})
+	require.NoError(t, err)
+	stats, err := other.(*Fs).gc(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Deleted)
+
+	o, err = f.putManifest(ctx, "b", time.Now(), m, nil)
+	require.NoError(t, err)
+	assert.Equal(t, data, read(t, o))
+}
+
+func TestCorruptChunk(t *testing.T) {
+	f, dir := newTestFs(t)
+	data := randomData(7, 100)
+	o := put(t, f, "file", data)
+	m, err := o.(*Object).getManifest(context.Background())
+	require.NoError(t, err)
+	require.Len(t, m.Chunks, 1)
+	p := filepath.Join(dir, chunksDir, filepath.FromSlash(chunkName(m.Chunks[0].Hash)))
+	data[0] ^= 0xFF
+	require.NoError(t, os.WriteFile(p, data, 0600))
+
+	rc, err := o.Open(context.Background())
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	assert.ErrorContains(t, err, "corrupted chunk")
+	require.NoError(t, rc.Close())
+}
+
+// skewFs is an fs.Fs whose objects uploaded with Put have their
+// modification times set by a clock which is 2 hours fast
+type skewFs struct {
+	fs.Fs
+}
+
+func (f skewFs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o, err := f.Fs.Put(ctx, in, src, options...)
+	return skewObject{o}, err
+}
+
+type skewObject struct {
+	fs.Object
+}
+
+func (o skewObject) ModTime(ctx context.Context) time.Time {
+	return o.Object.ModTime(ctx).Add(2 * time.Hour)
+}
+
+func TestGCClockSkew(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newTestFs(t)
+	require.NoError(t, f.checkClocks(ctx, time.Hour))
+
+	f.chunks = skewFs{f.chunks}
+	_, err := f.gc(ctx, time.Hour)
+	assert.ErrorContains(t, err, "from this machine's clock")
+	require.NoError(t, f.checkClocks(ctx, 3*time.Hour))
+
+	// The clock check file isn't left behind
+	_, err = os.Stat(filepath.Join(dir, chunksDir, clockCheckName))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,52 @@
+// Test the cdc filesystem interface
+package cdc_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/backend/cdc"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	opt := fstests.Opt{
+		RemoteName: *fstest.RemoteName,
+		NilObject:  (*cdc.Object)(nil),
+		UnimplementableFsMethods: []string{
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"MergeDirs",
+			"DirCacheFlush",
+			"PutUnchecked",
+			"UserInfo",
+			"Disconnect",
+			"ChangeNotify",
+			"PublicLink",
+		},
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"ID",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+			"SetMetadata",
+			"UnWrap",
+		},
+	}
+	if *fstest.RemoteName == "" {
+		tempDir := filepath.Join(os.TempDir(), "rclone-cdc-test")
+		opt.ExtraConfig = []fstests.ExtraConfigItem{
+			{Name: "TestCDC", Key: "type", Value: "cdc"},
+			{Name: "TestCDC", Key: "remote", Value: tempDir},
+			{Name: "TestCDC", Key: "chunk_size", Value: "64Ki"},
+		}
+		opt.RemoteName = "TestCDC:"
+		opt.QuickTestOK = true
+	}
+	fstests.Run(t, &opt)
+}
@@ -0,0 +1,134 @@
+package cdc
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gear is the table of random numbers used by the rolling hash.
+//
+// It is made from a fixed seed as changing it would move all the
+// chunk boundaries and stop new uploads deduplicating against chunks
+// already stored.
+var gear = func() (table [256]uint64) {
+	// splitmix64
+	state := uint64(0x636463636463) // "cdccdc"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// splitter splits a stream into content defined chunks using the
+// FastCDC algorithm with normalized chunking.
+//
+// Chunk boundaries depend only on the bytes just before them, so
+// inserting or removing data only changes the chunks around the
+// change and the rest are deduplicated.
+type splitter struct {
+	in    io.Reader
+	min   int    // minimum chunk size
+	avg   int    // target average chunk size
+	max   int    // maximum chunk size
+	maskS uint64 // harder mask used before avg
+	maskL uint64 // easier mask used after avg
+	buf   []byte // holds up to max bytes
+	start int    // start of unread data in buf
+	end   int    // end of data in buf
+	eof   bool   // set if in returned EOF
+}
+
+// newSplitter makes a splitter reading from in making chunks of
+// avg size on average which must be a power of two.
+//
+// Chunks are at least avg/4 and at most avg*4 bytes long.
+func newSplitter(in io.Reader, avg int) *splitter {
+	avgBits := bits.Len(uint(avg)) - 1
+	return &splitter{
+		in:    in,
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 4,
+		maskS: topBits(avgBits + 2),
+		maskL: topBits(avgBits - 2),
+		buf:   make([]byte, avg*4),
+	}
+}
+
+// topBits returns a mask with the top n bits set.
+//
+// The top bits of the hash depend on the last 64 bytes whereas the
+// bottom bits only depend on the last few.
+func topBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// fill reads into buf until it is full or the input ends
+func (s *splitter) fill() error {
+	if s.start > 0 {
+		s.end = copy(s.buf, s.buf[s.start:s.end])
+		s.start = 0
+	}
+	for !s.eof && s.end < len(s.buf) {
+		n, err := s.in.Read(s.buf[s.end:])
+		s.end += n
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (s *splitter) cut(data []byte) int {
+	n := len(data)
+	if n <= s.min {
+		return n
+	}
+	if n > s.max {
+		n = s.max
+	}
+	normal := s.avg
+	if normal > n {
+		normal = n
+	}
+	var fp uint64
+	i := s.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&s.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&s.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// next returns the next chunk or io.EOF if there are no more.
+//
+// The chunk is only valid until the next call.
+func (s *splitter) next() (chunk []byte, err error) {
+	if s.end-s.start < s.max && !s.eof {
+		if err := s.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if s.start == s.end {
+		return nil, io.EOF
+	}
+	data := s.buf[s.start:s.end]
+	chunk = data[:s.cut(data)]
+	s.start += len(chunk)
+	return chunk, nil
+}
@@ -0,0 +1,272 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "gc":
+		minAge := defaultMinAge
+		if s, ok := opt["min-age"]; ok {
+			minAge, err = fs.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad min-age: %w", err)
+			}
+		}
+		return f.gc(ctx, minAge)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// Chunks younger than this aren't removed by gc by default as they
+// may belong to an upload which hasn't written its manifest yet.
+// Uploads set the modification time of the chunks they reuse so this
+// covers those too.
+const defaultMinAge = time.Hour
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "gc",
+	Short: "Remove chunks which aren't used by any file",
+	Long: `This is a mark and sweep garbage collector rather than reference
+counting. It reads every manifest on the remote to mark the chunks in
+use, then lists the chunks and removes the ones which no file uses.
+
+Removing or overwriting a file only removes its manifest, so this
+needs running from time to time to free the space.
+
+    rclone backend gc cdc:
+
+Use --dry-run to see which chunks would be removed.
+
+Chunks younger than min-age (default 1h) are kept as they may belong
+to an upload still in progress. Uploads set the modification time of
+the chunks they reuse, and each chunk is checked again just before
+it is removed. Don't run this while uploads to the remote are running
+for longer than min-age.
+
+As this relies on the modification times of the chunks, it refuses to
+run if the remote stores them less precisely than min-age, or if the
+clock of the remote is further than min-age from this machine's. The
+clocks of the machines uploading need to agree with this one too.
+
+    rclone backend gc cdc: -o min-age=24h
+
+It prints statistics about the remote when it is done.
+`,
+	Opts: map[string]string{
+		"min-age": "Don't remove chunks younger than this (default 1h)",
+	},
+}}
+
+// gcStats are the statistics returned by the gc command
+type gcStats struct {
+	Manifests  int   `json:"manifests"`  // number of files
+	Chunks     int   `json:"chunks"`     // number of chunks stored before gc
+	Referenced int   `json:"referenced"` // number of chunks used by files
+	Shared     int   `json:"shared"`     // number of chunks used more than once
+	Deleted    int   `json:"deleted"`    // number of chunks removed
+	Freed      int64 `json:"freed"`      // bytes in the chunks removed
+	Logical    int64 `json:"logical"`    // total size of the files
+	Stored     int64 `json:"stored"`     // total size of the chunks used by files
+}
+
+// markChunks reads all the manifests on the remote to mark the chunks
+// in use, returning the number of files using each chunk for the
+// statistics.
+//
+// It fails if any manifest can't be read, as otherwise chunks still
+// in use would be removed.
+func (f *Fs) markChunks(ctx context.Context, stats *gcStats) (refs map[string]int, err error) {
+	files, err := cache.Get(ctx, fspath.JoinRootPath(f.opt.Remote, filesDir))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, err
+	}
+	refs = make(map[string]int)
+	var mu sync.Mutex
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	err = walk.ListR(ctx, files, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			mo, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			if _, _, err := parseManifestName(mo.Remote()); err != nil {
+				fs.Debugf(mo, "Ignoring file which isn't a manifest: %v", err)
+				continue
+			}
+			g.Go(func() error {
+				m, err := readManifest(gCtx, mo)
+				if err != nil {
+					return fmt.Errorf("failed to read manifest %q: %w", mo.Remote(), err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				stats.Manifests++
+				stats.Logical += m.Size
+				for _, chunk := range m.Chunks {
+					refs[chunk.Hash]++
+				}
+				return nil
+			})
+		}
+		return gCtx.Err()
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		err = nil
+	}
+	if waitErr := g.Wait(); waitErr != nil {
+		return nil, waitErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// clockCheckName is the name of the file written in the chunks
+// directory to check the clock of the remote. It is removed straight
+// away, and if it is left behind gc removes it as an unused chunk.
+const clockCheckName = "clock-check"
+
+// checkClocks returns an error if the modification times of the
+// chunks can't be relied on to within minAge, as gc uses them to find
+// the chunks of uploads in progress.
+func (f *Fs) checkClocks(ctx context.Context, minAge time.Duration) error {
+	if precision := f.chunks.Precision(); precision > minAge {
+		return fmt.Errorf("modification times on %v are only accurate to %v which is more than min-age %v", f.chunks, precision, minAge)
+	}
+	if fs.GetConfig(ctx).DryRun {
+		fs.Debugf(f, "Not checking the clock of the remote as --dry-run is set")
+		return nil
+	}
+	// Remotes which can't set modification times use their own
+	// clock, so see how far it is from ours
+	now := time.Now()
+	info := object.NewStaticObjectInfo(clockCheckName, now, 0, true, nil, f.chunks)
+	o, err := f.chunks.Put(ctx, bytes.NewReader(nil), info)
+	if err != nil {
+		return fmt.Errorf("failed to check the clock of the remote: %w", err)
+	}
+	skew := o.ModTime(ctx).Sub(now)
+	if err := o.Remove(ctx); err != nil {
+		fs.Errorf(o, "Failed to remove clock check file: %v", err)
+	}
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > minAge {
+		return fmt.Errorf("modification times on %v are %v from this machine's clock which is more than min-age %v", f.chunks, skew, minAge)
+	}
+	return nil
+}
+
+// gc removes the chunks older than minAge which aren't used by any
+// manifest.
+//
+// The chunks are marked from the manifests then swept. An upload
+// running at the same time may reuse a chunk after it is listed by
+// the sweep, so each chunk is looked up again before it is removed
+// and kept if the upload has touched it.
+func (f *Fs) gc(ctx context.Context, minAge time.Duration) (*gcStats, error) {
+	if err := f.checkClocks(ctx, minAge); err != nil {
+		return nil, fmt.Errorf("not safe to run gc: %w", err)
+	}
+	stats := new(gcStats)
+	refs, err := f.markChunks(ctx, stats)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-minAge)
+	var unused []fs.Object
+	err = walk.ListR(ctx, f.chunks, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			stats.Chunks++
+			n := refs[path.Base(o.Remote())]
+			switch {
+			case n > 1:
+				stats.Shared++
+				fallthrough
+			case n == 1:
+				stats.Referenced++
+				stats.Stored += o.Size()
+			case o.ModTime(ctx).Before(cutoff):
+				unused = append(unused, o)
+			default:
+				fs.Debugf(o, "Keeping unused chunk as it is younger than %v", fs.Duration(minAge))
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, err
+	}
+	if stats.Referenced != len(refs) {
+		fs.Errorf(f, "%d chunks used by files are missing", len(refs)-stats.Referenced)
+	}
+
+	var deleted []fs.Object
+	toBeDeleted := make(fs.ObjectsChan, fs.GetConfig(ctx).Checkers)
+	go func() {
+		defer close(toBeDeleted)
+		for _, o := range unused {
+			current, err := f.chunks.NewObject(ctx, o.Remote())
+			if err == fs.ErrorObjectNotFound {
+				continue
+			}
+			if err != nil {
+				fs.Errorf(o, "Keeping unused chunk as it can't be checked again: %v", err)
+				continue
+			}
+			if !current.ModTime(ctx).Before(cutoff) {
+				fs.Debugf(o, "Keeping chunk reused by an upload during gc")
+				continue
+			}
+			deleted = append(deleted, current)
+			toBeDeleted <- current
+		}
+	}()
+	err = operations.DeleteFiles(ctx, toBeDeleted)
+	hashes := make([]string, len(deleted))
+	for i, o := range deleted {
+		hashes[i] = path.Base(o.Remote())
+		stats.Freed += o.Size()
+	}
+	f.forgetKnown(hashes)
+	if err != nil {
+		return nil, err
+	}
+	if !fs.GetConfig(ctx).DryRun {
+		stats.Deleted = len(deleted)
+	}
+	return stats, nil
+}
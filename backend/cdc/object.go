@@ -0,0 +1,274 @@
+package cdc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	gohash "hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object represents a file made of chunks described by a manifest
+type Object struct {
+	f      *Fs
+	mo     fs.Object // the manifest object
+	remote string
+	size   int64
+
+	mu       sync.Mutex
+	manifest *manifest // read on first use
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.mo.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return o.mo.SetModTime(ctx, modTime)
+}
+
+// Storable returns whether the object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// getManifest reads the manifest if it hasn't been read already
+func (o *Object) getManifest(ctx context.Context) (*manifest, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.manifest != nil {
+		return o.manifest, nil
+	}
+	m, err := readManifest(ctx, o.mo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if m.Size != o.size {
+		return nil, fmt.Errorf("manifest is for %d bytes but its name says %d", m.Size, o.size)
+	}
+	o.manifest = m
+	return m, nil
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.MD5 && ht != hash.SHA1 {
+		return "", hash.ErrUnsupported
+	}
+	m, err := o.getManifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ht == hash.MD5 {
+		return m.MD5, nil
+	}
+	return m.SHA1, nil
+}
+
+// Remove an object
+//
+// Only the manifest is removed. Chunks which are no longer used are
+// removed by the gc command.
+func (o *Object) Remove(ctx context.Context) error {
+	return o.mo.Remove(ctx)
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newO, err := o.f.put(ctx, in, src, o)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.mo = newO.mo
+	o.size = newO.size
+	o.manifest = newO.manifest
+	o.mu.Unlock()
+	return nil
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	m, err := o.getManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if limit < 0 || offset+limit > o.size {
+		limit = o.size - offset
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	// Skip the chunks before offset
+	chunks := m.Chunks
+	for len(chunks) > 0 && offset >= chunks[0].Size {
+		offset -= chunks[0].Size
+		chunks = chunks[1:]
+	}
+	return &chunkReader{
+		ctx:       ctx,
+		f:         o.f,
+		chunks:    chunks,
+		offset:    offset,
+		remaining: limit,
+	}, nil
+}
+
+// chunkReader reads a range of a file from its chunks
+type chunkReader struct {
+	ctx       context.Context
+	f         *Fs
+	chunks    []chunkRef    // chunks still to read
+	offset    int64         // offset into the first chunk
+	remaining int64         // bytes left to read
+	in        io.ReadCloser // current chunk or nil
+	left      int64         // bytes left to read from in
+	hasher    gohash.Hash   // sha256 of in if reading all of it
+	want      string        // expected hash if hasher is set
+}
+
+// openChunk opens the next chunk for reading
+func (r *chunkReader) openChunk() error {
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	start, end := r.offset, chunk.Size
+	r.offset = 0
+	if end-start > r.remaining {
+		end = start + r.remaining
+	}
+	o, err := r.f.chunks.NewObject(r.ctx, chunkName(chunk.Hash))
+	if err == fs.ErrorObjectNotFound || err == fs.ErrorDirNotFound {
+		return fmt.Errorf("chunk %s is missing - was gc run during an upload?", chunk.Hash)
+	}
+	if err != nil {
+		return err
+	}
+	if o.Size() != chunk.Size {
+		return fmt.Errorf("chunk %s is %d bytes but should be %d", chunk.Hash, o.Size(), chunk.Size)
+	}
+	var options []fs.OpenOption
+	r.hasher = nil
+	if start == 0 && end == chunk.Size {
+		r.hasher = sha256.New()
+		r.want = chunk.Hash
+	} else {
+		options = append(options, &fs.RangeOption{Start: start, End: end - 1})
+	}
+	r.in, err = o.Open(r.ctx, options...)
+	if err != nil {
+		return err
+	}
+	r.left = end - start
+	return nil
+}
+
+// closeChunk closes the current chunk checking it was all read
+func (r *chunkReader) closeChunk() error {
+	err := r.in.Close()
+	r.in = nil
+	if err != nil {
+		return err
+	}
+	if r.left != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if r.hasher != nil {
+		if got := hex.EncodeToString(r.hasher.Sum(nil)); got != r.want {
+			return fmt.Errorf("corrupted chunk: sha256 is %s but should be %s", got, r.want)
+		}
+	}
+	return nil
+}
+
+// Read reads up to len(p) bytes into p
+func (r *chunkReader) Read(p []byte) (n int, err error) {
+	for {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if r.in == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if err = r.openChunk(); err != nil {
+				return 0, err
+			}
+		}
+		if int64(len(p)) > r.left {
+			p = p[:r.left]
+		}
+		n, err = r.in.Read(p)
+		r.left -= int64(n)
+		r.remaining -= int64(n)
+		if r.hasher != nil {
+			_, _ = r.hasher.Write(p[:n])
+		}
+		if r.left == 0 || err == io.EOF {
+			if err = r.closeChunk(); err != nil {
+				return n, err
+			}
+		}
+		if err != nil || n > 0 {
+			return n, err
+		}
+	}
+}
+
+// Close closes the current chunk if any
+func (r *chunkReader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object = (*Object)(nil)
+)
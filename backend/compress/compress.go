@@ -14,12 +14,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/buengese/sgzip"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/klauspost/compress/zstd"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
@@ -38,11 +40,11 @@ const (
 	initialChunkSize = 262144  // Initial and max sizes of chunks when reading parts of the file. Currently
 	maxChunkSize     = 8388608 // at 256 KiB and 8 MiB.
 
-	bufferSize          = 8388608
-	heuristicBytes      = 1048576
-	minCompressionRatio = 1.1
+	bufferSize     = 8388608
+	heuristicBytes = 1048576
 
 	gzFileExt           = ".gz"
+	zstdFileExt         = ".zst"
 	metaFileExt         = ".json"
 	uncompressedFileExt = ".bin"
 )
@@ -51,6 +53,7 @@ const (
 const (
 	Uncompressed = 0
 	Gzip         = 2
+	Zstd         = 3
 )
 
 var nameRegexp = regexp.MustCompile(`^(.+?)\.([A-Za-z0-9-_]{11})$`)
@@ -62,6 +65,9 @@ func init() {
 		{ // Default compression mode options {
 			Value: "gzip",
 			Help:  "Standard gzip compression with fastest parameters.",
+		}, {
+			Value: "zstd",
+			Help:  "Zstandard compression in frames which can be read from any offset.",
 		},
 	}
 
@@ -84,15 +90,19 @@ func init() {
 			Examples: compressionModeOptions,
 		}, {
 			Name: "level",
-			Help: `GZIP compression level (-2 to 9).
+			Help: `Compression level.
 
-Generally -1 (default, equivalent to 5) is recommended.
-Levels 1 to 9 increase compression at the cost of speed. Going past 6 
-generally offers very little return.
+For gzip the level is -2 to 9. Generally -1 (default, equivalent to 5)
+is recommended. Levels 1 to 9 increase compression at the cost of
+speed. Going past 6 generally offers very little return.
 
 Level -2 uses Huffman encoding only. Only use if you know what you
 are doing.
-Level 0 turns off compression.`,
+Level 0 turns off compression.
+
+For zstd the level is 1 to 22 which is mapped onto the levels the
+zstd library supports. Levels less than 1 use the default level
+which is equivalent to 3.`,
 			Default:  sgzip.DefaultCompression,
 			Advanced: true,
 		}, {
@@ -105,16 +115,45 @@ Files smaller than this limit will be cached in RAM, files larger than
 this limit will be cached on disk.`,
 			Default:  fs.SizeSuffix(20 * 1024 * 1024),
 			Advanced: true,
+		}, {
+			Name: "skip_mime_types",
+			Help: `MIME types of files which aren't compressed.
+
+Files which are already compressed don't get any smaller so they are
+stored uncompressed. The MIME type is detected from the start of
+the file and can match a type in this list or one it is based on, so
+application/zip matches office documents and jar files too.
+
+This is a comma separated list of MIME types which can use * as a
+wildcard, e.g. "video/*". Set to "" to try to compress all files.`,
+			Default: fs.CommaSepList{
+				"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/heic", "image/heif", "image/jxl",
+				"video/*", "audio/mpeg", "audio/mp4", "audio/aac", "audio/ogg", "audio/flac",
+				"application/zip", "application/gzip", "application/x-bzip2", "application/x-xz",
+				"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed",
+			},
+			Advanced: true,
+		}, {
+			Name: "min_compression_ratio",
+			Help: `Minimum compression ratio for files to be stored compressed.
+
+The start of each file is compressed to see how well it compresses.
+Files are only stored compressed if the uncompressed size divided by
+the compressed size is more than this.`,
+			Default:  1.1,
+			Advanced: true,
 		}},
 	})
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote           string        `config:"remote"`
-	CompressionMode  string        `config:"mode"`
-	CompressionLevel int           `config:"level"`
-	RAMCacheLimit    fs.SizeSuffix `config:"ram_cache_limit"`
+	Remote           string          `config:"remote"`
+	CompressionMode  string          `config:"mode"`
+	CompressionLevel int             `config:"level"`
+	RAMCacheLimit    fs.SizeSuffix   `config:"ram_cache_limit"`
+	SkipMimeTypes    fs.CommaSepList `config:"skip_mime_types"`
+	MinRatio         float64         `config:"min_compression_ratio"`
 }
 
 /*** FILESYSTEM FUNCTIONS ***/
@@ -126,8 +165,9 @@ type Fs struct {
 	name     string
 	root     string
 	opt      Options
-	mode     int          // compression mode id
-	features *fs.Features // optional features
+	mode     int           // compression mode id
+	zstdEnc  *zstd.Encoder // encoder if using zstd
+	features *fs.Features  // optional features
 }
 
 // NewFs constructs an Fs from the path, container:path
@@ -139,6 +179,11 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		return nil, err
 	}
 
+	mode := compressionModeFromName(opt.CompressionMode)
+	if mode == Uncompressed {
+		return nil, fmt.Errorf("unknown compression mode %q", opt.CompressionMode)
+	}
+
 	remote := opt.Remote
 	if strings.HasPrefix(remote, name+":") {
 		return nil, errors.New("can't point press remote at itself - check the value of the remote setting")
@@ -170,7 +215,14 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		name: name,
 		root: rpath,
 		opt:  *opt,
-		mode: compressionModeFromName(opt.CompressionMode),
+		mode: mode,
+	}
+	if mode == Zstd {
+		var encErr error
+		f.zstdEnc, encErr = newZstdEncoder(opt.CompressionLevel)
+		if encErr != nil {
+			return nil, fmt.Errorf("failed to make zstd encoder: %w", encErr)
+		}
 	}
 	// the features here are ones we could support, and they are
 	// ANDed with the ones from wrappedFs
@@ -201,6 +253,8 @@ func compressionModeFromName(name string) int {
 	switch name {
 	case "gzip":
 		return Gzip
+	case "zstd":
+		return Zstd
 	default:
 		return Uncompressed
 	}
@@ -243,7 +297,7 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if err != nil {
 		return "", "", 0, errors.New("could not decode size")
 	}
-	return match[1], gzFileExt, size, nil
+	return match[1], extension, size, nil
 }
 
 // Generates the file name for a metadata file
@@ -258,12 +312,14 @@ func isMetadataFile(filename string) bool {
 
 // makeDataName generates the file name for a data file with specified compression mode
 func makeDataName(remote string, size int64, mode int) (newRemote string) {
-	if mode != Uncompressed {
-		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
-	} else {
-		newRemote = remote + uncompressedFileExt
+	switch mode {
+	case Uncompressed:
+		return remote + uncompressedFileExt
+	case Zstd:
+		return remote + "." + int64ToBase64(size) + zstdFileExt
+	default:
+		return remote + "." + int64ToBase64(size) + gzFileExt
 	}
-	return newRemote
 }
 
 // dataName generates the file name for data file
@@ -372,7 +428,7 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 		return nil, fmt.Errorf("error decoding metadata: %w", err)
 	}
 	// Create our Object
-	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.CompressionMetadata.Size, meta.Mode))
+	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.Size, meta.Mode))
 	if err != nil {
 		return nil, err
 	}
@@ -381,7 +437,7 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 
 // checkCompressAndType checks if an object is compressible and determines it's mime type
 // returns a multireader with the bytes that were read to determine mime type
-func checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool, mimeType string, err error) {
+func (f *Fs) checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool, mimeType string, err error) {
 	in, wrap := accounting.UnWrap(in)
 	buf := make([]byte, heuristicBytes)
 	n, err := in.Read(buf)
@@ -390,32 +446,59 @@ func checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool,
 		return nil, false, "", err
 	}
 	mime := mimetype.Detect(buf)
-	compressible, err = isCompressible(bytes.NewReader(buf))
-	if err != nil {
-		return nil, false, "", err
+	if f.skipMimeType(mime) {
+		fs.Debugf(f, "Not compressing file of type %q", mime)
+	} else {
+		compressible, err = f.isCompressible(buf)
+		if err != nil {
+			return nil, false, "", err
+		}
 	}
 	in = io.MultiReader(bytes.NewReader(buf), in)
 	return wrap(in), compressible, mime.String(), nil
 }
 
+// skipMimeType returns true if mime or any type it is based on
+// matches the MIME types which shouldn't be compressed
+func (f *Fs) skipMimeType(mime *mimetype.MIME) bool {
+	for ; mime != nil; mime = mime.Parent() {
+		for _, pattern := range f.opt.SkipMimeTypes {
+			if mime.Is(pattern) {
+				return true
+			}
+			if matched, _ := path.Match(pattern, mime.String()); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // isCompressible checks the compression ratio of the provided data and returns true if the ratio exceeds
 // the configured threshold
-func isCompressible(r io.Reader) (bool, error) {
-	var b bytes.Buffer
-	w, err := sgzip.NewWriterLevel(&b, sgzip.DefaultCompression)
-	if err != nil {
-		return false, err
-	}
-	n, err := io.Copy(w, r)
-	if err != nil {
-		return false, err
-	}
-	err = w.Close()
-	if err != nil {
-		return false, err
+func (f *Fs) isCompressible(data []byte) (bool, error) {
+	var compressedSize int
+	switch f.mode {
+	case Zstd:
+		compressedSize = len(f.zstdEnc.EncodeAll(data, nil))
+	default:
+		var b bytes.Buffer
+		w, err := sgzip.NewWriterLevel(&b, sgzip.DefaultCompression)
+		if err != nil {
+			return false, err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return false, err
+		}
+		err = w.Close()
+		if err != nil {
+			return false, err
+		}
+		compressedSize = b.Len()
 	}
-	ratio := float64(n) / float64(b.Len())
-	return ratio > minCompressionRatio, nil
+	ratio := float64(len(data)) / float64(compressedSize)
+	return ratio > f.opt.MinRatio, nil
 }
 
 // verifyObjectHash verifies the Objects hash
@@ -439,8 +522,39 @@ func (f *Fs) verifyObjectHash(ctx context.Context, o fs.Object, hasher *hash.Mul
 type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
 
 type compressionResult struct {
-	err  error
-	meta sgzip.GzipMetadata
+	err      error
+	meta     sgzip.GzipMetadata
+	zstdMeta ZstdMetadata
+}
+
+// compress compresses in to out with the compression mode of the Fs
+func (f *Fs) compress(out io.Writer, in io.Reader) (result compressionResult) {
+	switch f.mode {
+	case Zstd:
+		zw := newZstdWriter(out, f.zstdEnc)
+		_, result.err = io.Copy(zw, in)
+		if err := zw.Close(); err != nil && result.err == nil {
+			result.err = err
+		}
+		result.zstdMeta = zw.MetaData()
+		result.meta.Size = result.zstdMeta.Size
+	default:
+		gz, err := sgzip.NewWriterLevel(out, f.opt.CompressionLevel)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		_, result.err = io.Copy(gz, in)
+		gzErr := gz.Close()
+		if gzErr != nil {
+			fs.Errorf(nil, "Failed to close compress: %v", gzErr)
+			if result.err == nil {
+				result.err = gzErr
+			}
+		}
+		result.meta = gz.MetaData()
+	}
+	return result
 }
 
 // replicating some of operations.Rcat functionality because we want to support remotes without streaming
@@ -503,27 +617,18 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	pipeReader, pipeWriter := io.Pipe()
 	results := make(chan compressionResult)
 	go func() {
-		gz, err := sgzip.NewWriterLevel(pipeWriter, f.opt.CompressionLevel)
-		if err != nil {
-			results <- compressionResult{err: err, meta: sgzip.GzipMetadata{}}
-			return
-		}
-		_, err = io.Copy(gz, in)
-		gzErr := gz.Close()
-		if gzErr != nil {
-			fs.Errorf(nil, "Failed to close compress: %v", gzErr)
-			if err == nil {
-				err = gzErr
-			}
+		result := f.compress(pipeWriter, in)
+		if result.err != nil {
+			_ = pipeWriter.CloseWithError(result.err)
 		}
 		closeErr := pipeWriter.Close()
 		if closeErr != nil {
 			fs.Errorf(nil, "Failed to close pipe: %v", closeErr)
-			if err == nil {
-				err = closeErr
+			if result.err == nil {
+				result.err = closeErr
 			}
 		}
-		results <- compressionResult{err: err, meta: gz.MetaData()}
+		results <- result
 	}()
 	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as sgzip has it's own buffering
 
@@ -571,6 +676,10 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 
 	// Generate metadata
 	meta := newMetadata(result.meta.Size, f.mode, result.meta, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	if f.mode == Zstd {
+		meta.ZstdMetadata = &result.zstdMeta
+		meta.CompressionMetadata = sgzip.GzipMetadata{}
+	}
 
 	// Check the hashes of the compressed data if we were comparing them
 	if ht != hash.None && hasher != nil {
@@ -695,7 +804,7 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	o, err := f.NewObject(ctx, src.Remote())
 	if err == fs.ErrorObjectNotFound {
 		// Get our file compressibility
-		in, compressible, mimeType, err := checkCompressAndType(in)
+		in, compressible, mimeType, err := f.checkCompressAndType(in)
 		if err != nil {
 			return nil, err
 		}
@@ -715,7 +824,7 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 	}
 	found := err == nil
 
-	in, compressible, mimeType, err := checkCompressAndType(in)
+	in, compressible, mimeType, err := f.checkCompressAndType(in)
 	if err != nil {
 		return nil, err
 	}
@@ -1019,6 +1128,7 @@ type ObjectMetadata struct {
 	MD5                 string // MD5 hash of the file.
 	MimeType            string // Mime type of the file
 	CompressionMetadata sgzip.GzipMetadata
+	ZstdMetadata        *ZstdMetadata `json:",omitempty"`
 }
 
 // Object with external metadata
@@ -1089,7 +1199,7 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		return o.mo, o.mo.Update(ctx, in, src, options...)
 	}
 
-	in, compressible, mimeType, err := checkCompressAndType(in)
+	in, compressible, mimeType, err := o.f.checkCompressAndType(in)
 	if err != nil {
 		return err
 	}
@@ -1242,6 +1352,20 @@ func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
 	return do.Metadata(ctx)
 }
 
+// SetMetadata sets the keys in metadata on the object leaving any
+// other metadata alone
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	err := o.loadMetadataObjectIfNotLoaded(ctx)
+	if err != nil {
+		return err
+	}
+	do, ok := o.mo.(fs.SetMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
 // Hash returns the selected checksum of the file
 // If no checksum is available it returns ""
 func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
@@ -1308,6 +1432,10 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	}
 	// Get a chunkedreader for the wrapped object
 	chunkedReader := chunkedreader.New(ctx, o.Object, initialChunkSize, maxChunkSize)
+	if o.meta.Mode == Zstd {
+		// Reads only the frames needed
+		return newZstdReader(ctx, chunkedReader, o.meta.ZstdMetadata, offset, limit)
+	}
 	// Get file handle
 	var file io.Reader
 	if offset != 0 {
@@ -0,0 +1,202 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs/chunkedreader"
+)
+
+const (
+	zstdFrameSize = 1 << 20 // Uncompressed size of each zstd frame
+
+	// Constants for the seek table described in the zstd seekable format
+	// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+	zstdSkippableMagic = 0x184D2A5E
+	zstdSeekableMagic  = 0x8F92EAB1
+)
+
+// ZstdMetadata describes the frames of a zstd compressed file
+type ZstdMetadata struct {
+	FrameSize int      // Uncompressed size of each frame except the last
+	Size      int64    // Uncompressed size of the file
+	Frames    []uint32 // Compressed size of each frame
+}
+
+// newZstdEncoder makes an encoder for the zstd compression level
+//
+// Levels less than 1 use the default level.
+func newZstdEncoder(level int) (*zstd.Encoder, error) {
+	encoderLevel := zstd.SpeedDefault
+	if level >= 1 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	return zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel))
+}
+
+// zstdWriter compresses data into zstd frames of zstdFrameSize which
+// can be decompressed independently, so reads can start at any frame.
+//
+// A seek table is written at the end of the file in a skippable frame
+// so other programs which understand the zstd seekable format can seek
+// in it too. Programs which don't will ignore it.
+type zstdWriter struct {
+	w    io.Writer
+	enc  *zstd.Encoder
+	buf  []byte
+	out  []byte
+	meta ZstdMetadata
+}
+
+// newZstdWriter makes a zstdWriter writing to w with enc
+func newZstdWriter(w io.Writer, enc *zstd.Encoder) *zstdWriter {
+	return &zstdWriter{
+		w:   w,
+		enc: enc,
+		buf: make([]byte, 0, zstdFrameSize),
+		meta: ZstdMetadata{
+			FrameSize: zstdFrameSize,
+		},
+	}
+}
+
+// Write compresses p writing a frame whenever one is full
+func (z *zstdWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if space := zstdFrameSize - len(z.buf); len(chunk) > space {
+			chunk = chunk[:space]
+		}
+		z.buf = append(z.buf, chunk...)
+		n += len(chunk)
+		p = p[len(chunk):]
+		if len(z.buf) == zstdFrameSize {
+			if err = z.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes the buffered data as a frame
+func (z *zstdWriter) flush() error {
+	if len(z.buf) == 0 {
+		return nil
+	}
+	z.out = z.enc.EncodeAll(z.buf, z.out[:0])
+	if _, err := z.w.Write(z.out); err != nil {
+		return err
+	}
+	z.meta.Frames = append(z.meta.Frames, uint32(len(z.out)))
+	z.meta.Size += int64(len(z.buf))
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// Close writes the last frame and the seek table
+func (z *zstdWriter) Close() error {
+	if err := z.flush(); err != nil {
+		return err
+	}
+	_, err := z.w.Write(z.seekTable())
+	return err
+}
+
+// MetaData returns the metadata describing the frames written
+func (z *zstdWriter) MetaData() ZstdMetadata {
+	return z.meta
+}
+
+// seekTable returns the seek table of the frames written as a
+// skippable frame
+func (z *zstdWriter) seekTable() []byte {
+	const entrySize, footerSize = 8, 9
+	frameSize := len(z.meta.Frames)*entrySize + footerSize
+	table := make([]byte, 8+frameSize)
+	binary.LittleEndian.PutUint32(table[0:], zstdSkippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(frameSize))
+	p := table[8:]
+	size := z.meta.Size
+	for _, compressedSize := range z.meta.Frames {
+		decompressedSize := int64(z.meta.FrameSize)
+		if size < decompressedSize {
+			decompressedSize = size
+		}
+		size -= decompressedSize
+		binary.LittleEndian.PutUint32(p[0:], compressedSize)
+		binary.LittleEndian.PutUint32(p[4:], uint32(decompressedSize))
+		p = p[entrySize:]
+	}
+	binary.LittleEndian.PutUint32(p[0:], uint32(len(z.meta.Frames)))
+	p[4] = 0 // descriptor: no checksums
+	binary.LittleEndian.PutUint32(p[5:], zstdSeekableMagic)
+	return table
+}
+
+// zstdReader decompresses part of a zstd compressed file
+type zstdReader struct {
+	io.Reader
+	dec *zstd.Decoder
+	in  io.Closer
+}
+
+// Close releases the decoder and closes the underlying reader
+func (z *zstdReader) Close() error {
+	if z.dec != nil {
+		z.dec.Close()
+	}
+	return z.in.Close()
+}
+
+// newZstdReader returns a reader for limit bytes from offset of the
+// file described by meta read with cr. If limit is -1 it reads to
+// the end of the file.
+//
+// Only the frames which contain the data are read.
+func newZstdReader(ctx context.Context, cr *chunkedreader.ChunkedReader, meta *ZstdMetadata, offset, limit int64) (io.ReadCloser, error) {
+	if meta == nil || meta.FrameSize <= 0 {
+		return nil, errors.New("missing zstd metadata")
+	}
+	if limit < 0 || offset+limit > meta.Size {
+		limit = meta.Size - offset
+	}
+	if offset >= meta.Size || limit <= 0 {
+		return &zstdReader{Reader: bytes.NewReader(nil), in: cr}, nil
+	}
+	frameSize := int64(meta.FrameSize)
+	first, last := offset/frameSize, (offset+limit-1)/frameSize
+	if last >= int64(len(meta.Frames)) {
+		return nil, fmt.Errorf("zstd metadata has %d frames but %d are needed", len(meta.Frames), last+1)
+	}
+	var start, end int64
+	for i, compressedSize := range meta.Frames[:last+1] {
+		if int64(i) < first {
+			start += int64(compressedSize)
+		}
+		end += int64(compressedSize)
+	}
+	if _, err := cr.RangeSeek(ctx, start, io.SeekStart, end-start); err != nil {
+		return nil, err
+	}
+	// Limit the decoder to the frames needed so it doesn't read ahead
+	dec, err := zstd.NewReader(io.LimitReader(cr, end-start))
+	if err != nil {
+		return nil, err
+	}
+	z := &zstdReader{
+		Reader: io.LimitReader(dec, limit),
+		dec:    dec,
+		in:     cr,
+	}
+	if _, err := io.CopyN(io.Discard, dec, offset-first*frameSize); err != nil {
+		_ = z.Close()
+		return nil, fmt.Errorf("failed to seek in zstd data: %w", err)
+	}
+	return z, nil
+}
@@ -0,0 +1,219 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/chunkedreader"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeZstd compresses data with zstdWriter
+func makeZstd(t *testing.T, data []byte) ([]byte, ZstdMetadata) {
+	enc, err := newZstdEncoder(-1)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	zw := newZstdWriter(&out, enc)
+	// Write in odd sized pieces to check the frames are filled
+	for p := data; len(p) > 0; {
+		n := 12345
+		if n > len(p) {
+			n = len(p)
+		}
+		_, err := zw.Write(p[:n])
+		require.NoError(t, err)
+		p = p[n:]
+	}
+	require.NoError(t, zw.Close())
+	return out.Bytes(), zw.MetaData()
+}
+
+// testData makes size bytes of compressible data
+func testData(size int) []byte {
+	r := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		_, _ = fmt.Fprintf(&buf, "%d line of log number %d\n", r.Intn(100), buf.Len())
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestZstdWriter(t *testing.T) {
+	for _, size := range []int{0, 1, zstdFrameSize, 2*zstdFrameSize + 1000} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := testData(size)
+			compressed, meta := makeZstd(t, data)
+			assert.Equal(t, int64(size), meta.Size)
+			assert.Equal(t, zstdFrameSize, meta.FrameSize)
+			assert.Equal(t, (size+zstdFrameSize-1)/zstdFrameSize, len(meta.Frames))
+
+			// Check a standard decoder reads it all skipping the seek table
+			dec, err := zstd.NewReader(bytes.NewReader(compressed))
+			require.NoError(t, err)
+			defer dec.Close()
+			got, err := io.ReadAll(dec)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, got))
+
+			// Check the seek table at the end
+			footer := compressed[len(compressed)-9:]
+			assert.Equal(t, uint32(zstdSeekableMagic), binary.LittleEndian.Uint32(footer[5:]))
+			assert.Equal(t, uint32(len(meta.Frames)), binary.LittleEndian.Uint32(footer[0:]))
+			var total int
+			for _, frame := range meta.Frames {
+				total += int(frame)
+			}
+			table := compressed[total:]
+			assert.Equal(t, uint32(zstdSkippableMagic), binary.LittleEndian.Uint32(table[0:]))
+			assert.Equal(t, len(table)-8, int(binary.LittleEndian.Uint32(table[4:])))
+		})
+	}
+}
+
+// countingObject is an object which counts the bytes read from it
+type countingObject struct {
+	fs.Object
+	data []byte
+	read int64
+}
+
+func (o *countingObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		case *fs.SeekOption:
+			offset = x.Offset
+		}
+	}
+	data := o.data[offset:]
+	if limit >= 0 && limit < int64(len(data)) {
+		data = data[:limit]
+	}
+	o.read += int64(len(data))
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestZstdReader(t *testing.T) {
+	ctx := context.Background()
+	data := testData(5*zstdFrameSize + 12345)
+	compressed, meta := makeZstd(t, data)
+	o := &countingObject{
+		Object: object.NewMemoryObject("test.zst", time.Now(), compressed),
+		data:   compressed,
+	}
+
+	for _, test := range []struct {
+		offset, limit int64
+	}{
+		{0, -1},
+		{0, 10},
+		{zstdFrameSize - 5, 10},
+		{3*zstdFrameSize + 17, zstdFrameSize},
+		{int64(len(data)) - 3, -1},
+		{int64(len(data)) - 3, 100},
+		{int64(len(data)), -1},
+	} {
+		o.read = 0
+		cr := chunkedreader.New(ctx, o, initialChunkSize, maxChunkSize)
+		in, err := newZstdReader(ctx, cr, &meta, test.offset, test.limit)
+		require.NoError(t, err, "offset %d limit %d", test.offset, test.limit)
+		got, err := io.ReadAll(in)
+		require.NoError(t, err, "offset %d limit %d", test.offset, test.limit)
+		require.NoError(t, in.Close())
+		end := int64(len(data))
+		if test.limit >= 0 && test.offset+test.limit < end {
+			end = test.offset + test.limit
+		}
+		assert.Equal(t, data[test.offset:end], got, "offset %d limit %d", test.offset, test.limit)
+		// Check only the frames needed were read
+		var want int64
+		if end > test.offset {
+			for i := test.offset / zstdFrameSize; i <= (end-1)/zstdFrameSize; i++ {
+				want += int64(meta.Frames[i])
+			}
+		}
+		assert.Equal(t, want, o.read, "offset %d limit %d", test.offset, test.limit)
+	}
+
+	_, err := newZstdReader(ctx, chunkedreader.New(ctx, o, initialChunkSize, maxChunkSize), nil, 0, -1)
+	assert.Error(t, err)
+}
+
+func TestCompressPolicy(t *testing.T) {
+	f := &Fs{
+		mode: Gzip,
+		opt: Options{
+			SkipMimeTypes: []string{"video/*", "application/zip"},
+			MinRatio:      1.1,
+		},
+	}
+	zipData := []byte("PK\x03\x04\x14\x00\x00\x00\x08\x00")
+	docx := append(append([]byte{}, zipData...), "[Content_Types].xml"...)
+	for _, test := range []struct {
+		name string
+		data []byte
+		skip bool
+	}{
+		{"text", []byte("hello hello hello hello"), false},
+		{"zip", zipData, true},
+		{"mp4", []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), true},
+		{"docx based on zip", docx, true},
+	} {
+		assert.Equal(t, test.skip, f.skipMimeType(mimetype.Detect(test.data)), test.name)
+	}
+
+	random := make([]byte, 100000)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	for _, mode := range []int{Gzip, Zstd} {
+		f.mode = mode
+		if mode == Zstd {
+			var err error
+			f.zstdEnc, err = newZstdEncoder(-1)
+			require.NoError(t, err)
+		}
+		compressible, err := f.isCompressible(testData(100000))
+		require.NoError(t, err)
+		assert.True(t, compressible, mode)
+		compressible, err = f.isCompressible(random)
+		require.NoError(t, err)
+		assert.False(t, compressible, mode)
+	}
+}
+
+func TestZstdRemote(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, ":compress,mode=zstd,remote='"+t.TempDir()+"':")
+	require.NoError(t, err)
+	data := testData(3*zstdFrameSize + 100)
+	src := object.NewStaticObjectInfo("log.txt", time.Now(), int64(len(data)), true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+
+	o, err := f.NewObject(ctx, "log.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), o.Size())
+	assert.Equal(t, Zstd, o.(*Object).meta.Mode, "data should be compressed")
+	in, err := o.Open(ctx, &fs.RangeOption{Start: zstdFrameSize - 10, End: 2*zstdFrameSize + 9})
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, data[zstdFrameSize-10:2*zstdFrameSize+10], got)
+
+	require.NoError(t, o.Remove(ctx))
+	_, err = f.NewObject(ctx, "log.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+}
@@ -0,0 +1,479 @@
+// Package erasure implements a backend which stores files as Reed-Solomon
+// erasure coded shards spread over several upstreams.
+package erasure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+const shardExt = ".ec" // extension of shard files
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "erasure",
+		Description: "Erasure code files over several remotes",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "upstreams",
+			Help: `List of space separated upstreams.
+
+Each file is split into one shard per upstream. Can be
+'remotea:dir remoteb: remotec:bucket', '"remotea:dir with space" remoteb:', etc.
+
+Don't change the order of the upstreams or add or remove any once
+files have been stored.`,
+			Required: true,
+		}, {
+			Name:    "parity_shards",
+			Default: 1,
+			Help: `Number of parity shards.
+
+Files can be read as long as no more than this many upstreams are
+unavailable, and uploaded as long as fewer than this many are. The rest of the upstreams hold data shards, so each file
+uses (number of upstreams)/(number of upstreams - parity_shards) times
+its size in total.
+
+This must be less than the number of upstreams and mustn't be changed
+once files have been stored.`,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams    fs.SpaceSepList `config:"upstreams"`
+	ParityShards int             `config:"parity_shards"`
+}
+
+// Fs represents files erasure coded over several upstreams
+type Fs struct {
+	name      string
+	root      string
+	opt       Options
+	features  *fs.Features
+	upstreams []fs.Fs // shard i of each file is stored on upstreams[i]
+	k         int     // number of data shards
+	m         int     // number of parity shards
+	enc       reedsolomon.Encoder
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Upstreams) < 2 {
+		return nil, errors.New("erasure needs at least 2 upstreams - check the value of the upstreams setting")
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point erasure remote at itself - check the value of the upstreams setting")
+		}
+	}
+	if opt.ParityShards < 1 || opt.ParityShards >= len(opt.Upstreams) {
+		return nil, fmt.Errorf("parity_shards must be between 1 and %d for %d upstreams", len(opt.Upstreams)-1, len(opt.Upstreams))
+	}
+	root = strings.Trim(root, "/")
+	f, err := newFs(ctx, name, root, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check to see if the root is a file
+	if root != "" {
+		parent := path.Dir(root)
+		if parent == "." {
+			parent = ""
+		}
+		pf, err := newFs(ctx, name, parent, opt)
+		if err != nil {
+			return nil, err
+		}
+		_, err = pf.NewObject(ctx, path.Base(root))
+		if err == nil {
+			return pf, fs.ErrorIsFile
+		}
+	}
+	return f, nil
+}
+
+// newFs makes an Fs for root on the upstreams
+func newFs(ctx context.Context, name, root string, opt *Options) (*Fs, error) {
+	f := &Fs{
+		name:      name,
+		root:      root,
+		opt:       *opt,
+		upstreams: make([]fs.Fs, len(opt.Upstreams)),
+		m:         opt.ParityShards,
+		k:         len(opt.Upstreams) - opt.ParityShards,
+	}
+	var err error
+	f.enc, err = reedsolomon.New(f.k, f.m)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(opt.Upstreams))
+	multithread(len(opt.Upstreams), func(i int) {
+		f.upstreams[i], errs[i] = cache.Get(ctx, fspath.JoinRootPath(opt.Upstreams[i], root))
+	})
+	for i, err := range errs {
+		if err != nil && err != fs.ErrorIsFile {
+			return nil, fmt.Errorf("failed to make upstream %q: %w", opt.Upstreams[i], err)
+		}
+	}
+	features := (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+	}).Fill(ctx, f)
+	for _, u := range f.upstreams {
+		features = features.Mask(ctx, u)
+	}
+	f.features = features
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("erasure root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision is the greatest precision of all upstreams
+func (f *Fs) Precision() time.Duration {
+	var greatestPrecision time.Duration
+	for _, u := range f.upstreams {
+		if u.Precision() > greatestPrecision {
+			greatestPrecision = u.Precision()
+		}
+	}
+	return greatestPrecision
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// makeShardName returns the name of the shards of remote of size
+// stored by the upload with id.
+//
+// The size is stored in the name so listings don't need to read the
+// shards, and the id so shards from different uploads are never
+// mixed.
+func makeShardName(remote string, size int64, id int64) string {
+	return remote + "." + strconv.FormatInt(size, 10) + "." + strconv.FormatInt(id, 36) + shardExt
+}
+
+// parseShardName returns the remote, size and id of the shard name
+func parseShardName(name string) (remote string, size int64, id int64, err error) {
+	if !strings.HasSuffix(name, shardExt) {
+		return "", -1, 0, errors.New("missing shard extension")
+	}
+	name = name[:len(name)-len(shardExt)]
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return "", -1, 0, errors.New("missing id")
+	}
+	id, err = strconv.ParseInt(name[dot+1:], 36, 64)
+	if err != nil {
+		return "", -1, 0, fmt.Errorf("bad id %q", name[dot+1:])
+	}
+	name = name[:dot]
+	dot = strings.LastIndex(name, ".")
+	if dot <= 0 || strings.HasSuffix(name[:dot], "/") {
+		return "", -1, 0, errors.New("missing size")
+	}
+	size, err = strconv.ParseInt(name[dot+1:], 10, 64)
+	if err != nil || size < 0 {
+		return "", -1, 0, fmt.Errorf("bad size %q", name[dot+1:])
+	}
+	return name[:dot], size, id, nil
+}
+
+// dirListing is the merged listing of a directory on all the upstreams
+type dirListing struct {
+	dirs        map[string]fs.Directory
+	files       map[string][]*Object // all the uploads of each file
+	unavailable []bool               // set for upstreams which couldn't be listed
+}
+
+// listDir lists dir on all the upstreams and merges the results.
+//
+// It fails if more than parity_shards upstreams can't be listed as
+// files can't be read then.
+func (f *Fs) listDir(ctx context.Context, dir string) (*dirListing, error) {
+	n := len(f.upstreams)
+	entries := make([]fs.DirEntries, n)
+	errs := make([]error, n)
+	multithread(n, func(i int) {
+		entries[i], errs[i] = f.upstreams[i].List(ctx, dir)
+	})
+	l := &dirListing{
+		dirs:        make(map[string]fs.Directory),
+		files:       make(map[string][]*Object),
+		unavailable: make([]bool, n),
+	}
+	notFound, failed := 0, 0
+	var lastErr error
+	for i, err := range errs {
+		if err == fs.ErrorDirNotFound {
+			notFound++
+		} else if err != nil {
+			fs.Errorf(f.upstreams[i], "Failed to list %q: %v", dir, err)
+			l.unavailable[i] = true
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > f.m {
+		return nil, fmt.Errorf("%d upstreams failed to list which is more than the %d parity shards: %w", failed, f.m, lastErr)
+	}
+	if notFound+failed == n {
+		return nil, fs.ErrorDirNotFound
+	}
+	uploads := make(map[string]map[int64]*Object)
+	for i := range entries {
+		for _, entry := range entries[i] {
+			switch x := entry.(type) {
+			case fs.Object:
+				remote, size, id, err := parseShardName(x.Remote())
+				if err != nil {
+					fs.Debugf(x, "Ignoring file which isn't a shard: %v", err)
+					continue
+				}
+				if uploads[remote] == nil {
+					uploads[remote] = make(map[int64]*Object)
+				}
+				o := uploads[remote][id]
+				if o == nil {
+					o = &Object{
+						f:      f,
+						remote: remote,
+						size:   size,
+						id:     id,
+						shards: make([]fs.Object, n),
+					}
+					uploads[remote][id] = o
+					l.files[remote] = append(l.files[remote], o)
+				}
+				if size != o.size {
+					fs.Errorf(x, "Ignoring shard with size %d different to the other shards %d", size, o.size)
+					continue
+				}
+				o.shards[i] = x
+			case fs.Directory:
+				if _, found := l.dirs[x.Remote()]; !found {
+					l.dirs[x.Remote()] = x
+				}
+			default:
+				panic(fmt.Sprintf("unknown entry type %T", entry))
+			}
+		}
+	}
+	return l, nil
+}
+
+// best returns the upload of a file to use, which is the newest one
+// with enough shards to be read, or nil if there isn't one.
+//
+// An older upload with more shards isn't preferred as it holds old
+// contents of the file.
+func (f *Fs) best(uploads []*Object) (best *Object) {
+	for _, o := range uploads {
+		if o.shardCount() < f.k {
+			continue
+		}
+		if best == nil || o.id > best.id {
+			best = o
+		}
+	}
+	return best
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	l, err := f.listDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range l.dirs {
+		entries = append(entries, d)
+	}
+	for remote, uploads := range l.files {
+		o := f.best(uploads)
+		if o == nil {
+			fs.Errorf(remote, "Ignoring file with too few shards to read")
+			continue
+		}
+		if _, found := l.dirs[remote]; found {
+			fs.Errorf(remote, "Ignoring file with the same name as a directory")
+			continue
+		}
+		entries = append(entries, o)
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
+	}
+	l, err := f.listDir(ctx, dir)
+	if err == fs.ErrorDirNotFound {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := f.best(l.files[remote])
+	if o == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return o, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o, err := f.put(ctx, in, src)
+	if err != nil {
+		return nil, err
+	}
+	f.removeOldUploads(ctx, o)
+	return o, nil
+}
+
+// removeOldUploads removes the shards of the uploads of o's file
+// before o.
+//
+// Failures are only logged as o is read in preference to them and
+// the heal command removes them later.
+func (f *Fs) removeOldUploads(ctx context.Context, o *Object) {
+	dir := path.Dir(o.remote)
+	if dir == "." {
+		dir = ""
+	}
+	l, err := f.listDir(ctx, dir)
+	if err != nil {
+		fs.Errorf(o, "Failed to list old uploads: %v", err)
+		return
+	}
+	for _, old := range l.files[o.remote] {
+		if old.id < o.id {
+			if err := old.removeShards(ctx); err != nil {
+				fs.Errorf(o, "Failed to remove old upload: %v", err)
+			}
+		}
+	}
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		errs[i] = f.upstreams[i].Mkdir(ctx, dir)
+	})
+	return firstError(errs)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := make([]error, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		errs[i] = f.upstreams[i].Rmdir(ctx, dir)
+	})
+	notFound := 0
+	for i, err := range errs {
+		if err == fs.ErrorDirNotFound {
+			notFound++
+			errs[i] = nil
+		}
+	}
+	if notFound == len(errs) {
+		return fs.ErrorDirNotFound
+	}
+	return firstError(errs)
+}
+
+// firstError returns the first non nil error in errs
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// multithread runs fn(i) for i in 0..num-1 concurrently
+func multithread(num int, fn func(int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < num; i++ {
+		wg.Add(1)
+		i := i
+		go func() {
+			defer wg.Done()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.Commander = (*Fs)(nil)
+)
@@ -0,0 +1,358 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardName(t *testing.T) {
+	name := makeShardName("dir/file.txt", 1234, 5678)
+	assert.Equal(t, "dir/file.txt.1234.4dq.ec", name)
+	remote, size, id, err := parseShardName(name)
+	require.NoError(t, err)
+	assert.Equal(t, "dir/file.txt", remote)
+	assert.Equal(t, int64(1234), size)
+	assert.Equal(t, int64(5678), id)
+	for _, bad := range []string{"file.txt", "file.ec", "file.1.ec", ".1.2.ec", "dir/.1.2.ec", "file.x.2.ec", "file.1.!.ec", "file.-1.2.ec"} {
+		_, _, _, err := parseShardName(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, test := range []struct {
+		size      int64
+		k         int
+		stripes   int64
+		shardSize int64
+	}{
+		{0, 2, 0, 0},
+		{1, 2, 1, crcSize + 1},
+		{3, 2, 1, crcSize + 2},
+		{2 * blockSize, 2, 1, crcSize + blockSize},
+		{2*blockSize + 5, 2, 2, 2*crcSize + blockSize + 3},
+		{10 * blockSize, 3, 4, 4*crcSize + 3*blockSize + (blockSize+2)/3},
+	} {
+		l := newLayout(test.size, test.k)
+		assert.Equal(t, test.stripes, l.stripes(), test.size)
+		assert.Equal(t, test.shardSize, l.shardSize(), test.size)
+		assert.Equal(t, l.shardSize(), l.shardOffset(l.stripes()), test.size)
+		var total int64
+		for s := int64(0); s < l.stripes(); s++ {
+			total += l.dataLen(s)
+			assert.GreaterOrEqual(t, l.blockLen(s)*int64(test.k), l.dataLen(s))
+		}
+		assert.Equal(t, test.size, total)
+	}
+}
+
+// newTestFs makes an erasure Fs with n local upstreams and m parity
+// shards returning it and the upstream directories
+func newTestFs(t *testing.T, n, m int) (*Fs, []string) {
+	fstest.Initialise()
+	var dirs []string
+	for i := 0; i < n; i++ {
+		dirs = append(dirs, t.TempDir())
+	}
+	f, err := NewFs(context.Background(), "TestErasureInternal", "", configmap.Simple{
+		"upstreams":     strings.Join(dirs, " "),
+		"parity_shards": strconv.Itoa(m),
+	})
+	require.NoError(t, err)
+	return f.(*Fs), dirs
+}
+
+// put uploads data as remote to f
+func put(t *testing.T, f *Fs, remote string, data []byte) fs.Object {
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, nil)
+	o, err := f.Put(context.Background(), bytes.NewReader(data), info)
+	require.NoError(t, err)
+	return o
+}
+
+// read reads all of remote from f
+func read(t *testing.T, f *Fs, remote string, options ...fs.OpenOption) ([]byte, error) {
+	o, err := f.NewObject(context.Background(), remote)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, rc.Close())
+	}()
+	return io.ReadAll(rc)
+}
+
+// shardPath returns the path of the shard of o on upstream dir
+func shardPath(o fs.Object, dir string) string {
+	eo := o.(*Object)
+	return filepath.Join(dir, filepath.FromSlash(makeShardName(eo.remote, eo.size, eo.id)))
+}
+
+// corrupt flips the bits of the byte at offset in the file at path
+func corrupt(t *testing.T, path string, offset int64) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[offset] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func TestDegradedRead(t *testing.T) {
+	f, dirs := newTestFs(t, 5, 2)
+	data := make([]byte, 3*blockSize*3+12345)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+	o := put(t, f, "dir/file", data)
+
+	got, err := read(t, f, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Remove a data shard and corrupt another so 2 are lost
+	require.NoError(t, os.Remove(shardPath(o, dirs[0])))
+	corrupt(t, shardPath(o, dirs[2]), crcSize+blockSize+crcSize+10)
+
+	got, err = read(t, f, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	got, err = read(t, f, "dir/file", &fs.RangeOption{Start: 1000000, End: 2000000})
+	require.NoError(t, err)
+	assert.Equal(t, data[1000000:2000001], got)
+
+	// With 3 lost it can't be read
+	require.NoError(t, os.Remove(shardPath(o, dirs[4])))
+	_, err = read(t, f, "dir/file")
+	assert.ErrorContains(t, err, "only 2 shards could be read")
+
+	// and with fewer than 3 left it can't be found
+	require.NoError(t, os.Remove(shardPath(o, dirs[3])))
+	_, err = read(t, f, "dir/file")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
+
+func TestUpdateRemovesOld(t *testing.T) {
+	f, dirs := newTestFs(t, 3, 1)
+	put(t, f, "file", []byte("one"))
+	o := put(t, f, "file", []byte("two"))
+	got, err := read(t, f, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "two", string(got))
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, filepath.Base(shardPath(o, dir)), entries[0].Name())
+	}
+}
+
+func TestNewVersionMissingShard(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 3, 1)
+
+	// Keep the shards of the old version when the new one is put
+	old := put(t, f, "file", []byte("one"))
+	saved := make([][]byte, len(dirs))
+	for i, dir := range dirs {
+		var err error
+		saved[i], err = os.ReadFile(shardPath(old, dir))
+		require.NoError(t, err)
+	}
+	o := put(t, f, "file", []byte("second"))
+	for i, dir := range dirs {
+		require.NoError(t, os.WriteFile(shardPath(old, dir), saved[i], 0600))
+	}
+
+	// The new version has lost a shard but can still be read so it
+	// is used rather than the complete old one
+	require.NoError(t, os.Remove(shardPath(o, dirs[1])))
+	got, err := read(t, f, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(got))
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(6), entries[0].Size())
+
+	// Heal rebuilds the new version and removes the old one
+	out, err := f.Command(ctx, "heal", nil, nil)
+	require.NoError(t, err)
+	stats := out.(*healStats)
+	assert.Equal(t, 1, stats.Healed)
+	assert.Equal(t, 3, stats.OldRemoved)
+	for _, dir := range dirs {
+		_, err = os.Stat(shardPath(old, dir))
+		assert.True(t, os.IsNotExist(err))
+	}
+	got, err = read(t, f, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(got))
+}
+
+func TestHealKeepsNewerUploads(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 3, 1)
+	o := put(t, f, "file", []byte("one"))
+
+	// A shard of a newer upload which may still be running
+	newer := filepath.Join(dirs[0], makeShardName("file", 6, o.(*Object).id+1))
+	require.NoError(t, os.WriteFile(newer, []byte("newer"), 0600))
+
+	out, err := f.Command(ctx, "heal", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, out.(*healStats).OldRemoved)
+	_, err = os.Stat(newer)
+	assert.NoError(t, err)
+	got, err := read(t, f, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(got))
+
+	// Once it is older than min-age it is removed
+	out, err = f.Command(ctx, "heal", nil, map[string]string{"min-age": "0s"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, out.(*healStats).OldRemoved)
+	_, err = os.Stat(newer)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = f.Command(ctx, "heal", nil, map[string]string{"min-age": "potato"})
+	assert.ErrorContains(t, err, "bad min-age")
+}
+
+func TestPutWithUpstreamDown(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 4, 2)
+	data := make([]byte, 2*blockSize+100)
+	_, _ = rand.New(rand.NewSource(4)).Read(data)
+
+	// Make an upstream fail by replacing its directory with a file
+	down := func(dir string) {
+		require.NoError(t, os.RemoveAll(dir))
+		require.NoError(t, os.WriteFile(dir, nil, 0600))
+	}
+	up := func(dir string) {
+		require.NoError(t, os.Remove(dir))
+		require.NoError(t, os.Mkdir(dir, 0700))
+	}
+
+	// With one upstream down k+1 shards are written so it succeeds
+	down(dirs[1])
+	o := put(t, f, "file", data)
+	got, err := read(t, f, "file")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Heal writes the missing shard once the upstream is back
+	up(dirs[1])
+	out, err := f.Command(ctx, "heal", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, out.(*healStats).ShardsWritten)
+	_, err = os.Stat(shardPath(o, dirs[1]))
+	assert.NoError(t, err)
+
+	// With two down there aren't enough shards so it fails and
+	// leaves nothing behind
+	down(dirs[0])
+	down(dirs[3])
+	info := object.NewStaticObjectInfo("file2", time.Now(), int64(len(data)), true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewReader(data), info)
+	assert.ErrorContains(t, err, "only 2 shards could be uploaded but 3 are needed")
+	for _, dir := range []string{dirs[1], dirs[2]} {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	}
+}
+
+func TestCorruptBlocks(t *testing.T) {
+	f, dirs := newTestFs(t, 3, 1)
+	data := make([]byte, 2*blockSize+100)
+	_, _ = rand.New(rand.NewSource(3)).Read(data)
+	o := put(t, f, "file", data)
+
+	// Only one parity shard but the bad blocks are in different
+	// stripes so each stripe can still be rebuilt
+	corrupt(t, shardPath(o, dirs[0]), crcSize+10)
+	corrupt(t, shardPath(o, dirs[1]), crcSize+blockSize+crcSize+10)
+	got, err := read(t, f, "file")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestHeal(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 3, 1)
+	data := make([]byte, 2*blockSize+100)
+	_, _ = rand.New(rand.NewSource(2)).Read(data)
+	o := put(t, f, "a/file", data)
+	small := put(t, f, "b", []byte("small"))
+
+	// Lose a shard of one file, corrupt a shard of the other and
+	// leave a shard of an old version
+	require.NoError(t, os.Remove(shardPath(o, dirs[0])))
+	corrupt(t, shardPath(small, dirs[1]), crcSize)
+	old := filepath.Join(dirs[2], "b.5.1.ec")
+	require.NoError(t, os.WriteFile(old, []byte("old"), 0600))
+
+	// Dry run doesn't change anything
+	dryCtx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	_, err := f.Command(dryCtx, "heal", nil, nil)
+	require.NoError(t, err)
+	_, err = os.Stat(shardPath(o, dirs[0]))
+	assert.True(t, os.IsNotExist(err))
+
+	// Without verify only the missing shard is written
+	out, err := f.Command(ctx, "heal", nil, nil)
+	require.NoError(t, err)
+	stats := out.(*healStats)
+	assert.Equal(t, 2, stats.Files)
+	assert.Equal(t, 1, stats.Healthy)
+	assert.Equal(t, 1, stats.Healed)
+	assert.Equal(t, 1, stats.ShardsWritten)
+	assert.Equal(t, 1, stats.OldRemoved)
+	_, err = os.Stat(old)
+	assert.True(t, os.IsNotExist(err))
+
+	// With verify the corrupted shard is found and rewritten
+	out, err = f.Command(ctx, "heal", nil, map[string]string{"verify": "true"})
+	require.NoError(t, err)
+	stats = out.(*healStats)
+	assert.Equal(t, 1, stats.Healthy)
+	assert.Equal(t, 1, stats.Healed)
+	assert.Equal(t, 1, stats.ShardsWritten)
+
+	out, err = f.Command(ctx, "heal", nil, map[string]string{"verify": "true"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, out.(*healStats).Healthy)
+
+	// Now any one upstream can be lost
+	for _, dir := range dirs {
+		for _, obj := range []fs.Object{o, small} {
+			require.NoError(t, os.Rename(shardPath(obj, dir), shardPath(obj, dir)+".bak"))
+		}
+		got, err := read(t, f, "a/file")
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+		got, err = read(t, f, "b")
+		require.NoError(t, err)
+		assert.Equal(t, "small", string(got))
+		for _, obj := range []fs.Object{o, small} {
+			require.NoError(t, os.Rename(shardPath(obj, dir)+".bak", shardPath(obj, dir)))
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Test the erasure filesystem interface
+package erasure_test
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/backend/erasure"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: *fstest.RemoteName,
+		NilObject:  (*erasure.Object)(nil),
+	})
+}
+
+// TestStandard runs the standard tests with 3 local upstreams
+func TestStandard(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	dirs := ""
+	for i := 0; i < 3; i++ {
+		dirs += t.TempDir() + " "
+	}
+	name := "TestErasure"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*erasure.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "erasure"},
+			{Name: name, Key: "upstreams", Value: dirs},
+			{Name: name, Key: "parity_shards", Value: "1"},
+		},
+		QuickTestOK: true,
+	})
+}
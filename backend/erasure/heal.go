@@ -0,0 +1,291 @@
+package erasure
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "heal":
+		verify := false
+		if s, ok := opt["verify"]; ok {
+			verify, err = strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad verify: %w", err)
+			}
+		}
+		minAge := defaultMinAge
+		if s, ok := opt["min-age"]; ok {
+			minAge, err = fs.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad min-age: %w", err)
+			}
+		}
+		stats := new(healStats)
+		cutoff := time.Now().Add(-minAge).UnixNano()
+		err = f.healDir(ctx, "", verify, cutoff, stats)
+		if err != nil {
+			return nil, err
+		}
+		return stats, nil
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// Uploads newer than the one used for a file which are younger than
+// this aren't removed by heal by default as they may still be being
+// written.
+const defaultMinAge = time.Hour
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "heal",
+	Short: "Rewrite missing and damaged shards",
+	Long: `This checks the shards of every file and rebuilds any which are
+missing or the wrong size from the others, then removes shards left
+behind by old versions of the files and by failed uploads.
+
+    rclone backend heal erasure:path
+
+Run it after an upstream has been unavailable or replaced with an
+empty one, or after uploads failed to write some of their shards.
+Upstreams which can't be listed are skipped.
+
+With -o verify=true every shard is read and the checksums of its
+blocks checked too, which finds corrupted shards but downloads
+everything.
+
+    rclone backend heal erasure: -o verify=true
+
+Shards of uploads newer than the version of a file which is used are
+left alone unless they are older than min-age (default 1h) as they may
+belong to an upload still in progress.
+
+    rclone backend heal erasure: -o min-age=24h
+
+Don't run this while files are being uploaded to the remote, as it
+may rewrite the shards of a file being replaced or remove the shards
+of an upload which has run for longer than min-age.
+
+Use --dry-run to see what would be done. It prints statistics when
+it is done.
+`,
+	Opts: map[string]string{
+		"verify":  "Read every shard checking its checksums",
+		"min-age": "Don't remove newer unfinished uploads younger than this (default 1h)",
+	},
+}}
+
+// healStats are the statistics returned by the heal command
+type healStats struct {
+	Files         int `json:"files"`          // number of files checked
+	Healthy       int `json:"healthy"`        // files which didn't need healing
+	Healed        int `json:"healed"`         // files which had shards rewritten
+	ShardsWritten int `json:"shards_written"` // number of shards rewritten
+	OldRemoved    int `json:"old_removed"`    // shards of old versions and failed uploads removed
+	Unrecoverable int `json:"unrecoverable"`  // files with too few shards to read
+	Errors        int `json:"errors"`         // files which failed to heal
+}
+
+// healDir heals the files in dir and its subdirectories
+//
+// Uploads newer than the one used for a file are only removed if they
+// were started before cutoff in Unix nanoseconds.
+func (f *Fs) healDir(ctx context.Context, dir string, verify bool, cutoff int64, stats *healStats) error {
+	l, err := f.listDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for remote, uploads := range l.files {
+		stats.Files++
+		o := f.best(uploads)
+		if o == nil {
+			stats.Unrecoverable++
+			fs.Errorf(remote, "Can't heal as too few shards are left to read it")
+			continue
+		}
+		err := f.healObject(ctx, o, l.unavailable, verify, stats)
+		if err != nil {
+			stats.Errors++
+			err = fs.CountError(err)
+			fs.Errorf(o, "Failed to heal: %v", err)
+			continue
+		}
+		for _, old := range uploads {
+			switch {
+			case old.id < o.id:
+				f.removeOld(ctx, old, l.unavailable, stats)
+			case old.id > o.id && old.id < cutoff:
+				fs.Debugf(o, "Removing failed upload started at %v", time.Unix(0, old.id))
+				f.removeOld(ctx, old, l.unavailable, stats)
+			}
+		}
+	}
+	for subDir := range l.dirs {
+		if err := f.healDir(ctx, subDir, verify, cutoff, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// healObject rewrites the shards of o which are missing, the wrong
+// size, or corrupted if verify is set.
+func (f *Fs) healObject(ctx context.Context, o *Object, unavailable []bool, verify bool, stats *healStats) error {
+	l := newLayout(o.size, f.k)
+	rewrite := make([]bool, len(o.shards))
+	count := 0
+	for i, shard := range o.shards {
+		if unavailable[i] {
+			fs.Logf(o, "Not healing shard %d as %v is unavailable", i, f.upstreams[i])
+			continue
+		}
+		var err error
+		switch {
+		case shard == nil:
+			err = errors.New("missing")
+		case shard.Size() != l.shardSize():
+			err = fmt.Errorf("size is %d but should be %d", shard.Size(), l.shardSize())
+		case verify:
+			err = verifyShard(ctx, shard, l)
+		}
+		if err != nil {
+			fs.Infof(o, "Shard %d on %v is bad: %v", i, f.upstreams[i], err)
+			rewrite[i] = true
+			count++
+		}
+	}
+	if count == 0 {
+		stats.Healthy++
+		return nil
+	}
+	// Don't overwrite shards unless there are enough others to
+	// rebuild them from
+	good := 0
+	for i, shard := range o.shards {
+		if shard != nil && !rewrite[i] {
+			good++
+		}
+	}
+	if good < f.k {
+		return fmt.Errorf("only %d good shards to rebuild from but %d are needed", good, f.k)
+	}
+	if operations.SkipDestructive(ctx, o, "heal") {
+		return nil
+	}
+	if err := o.writeShards(ctx, rewrite); err != nil {
+		return err
+	}
+	stats.Healed++
+	stats.ShardsWritten += count
+	fs.Infof(o, "Healed %d shards", count)
+	return nil
+}
+
+// removeOld removes the shards of the old or failed upload old
+func (f *Fs) removeOld(ctx context.Context, old *Object, unavailable []bool, stats *healStats) {
+	for i, shard := range old.shards {
+		if shard == nil || unavailable[i] {
+			continue
+		}
+		err := operations.DeleteFile(ctx, shard)
+		if err != nil {
+			stats.Errors++
+			fs.Errorf(shard, "Failed to remove shard of old upload: %v", err)
+			continue
+		}
+		stats.OldRemoved++
+	}
+}
+
+// writeShards rebuilds the shards of o marked in rewrite from the
+// others and uploads them.
+func (o *Object) writeShards(ctx context.Context, rewrite []bool) (err error) {
+	l := newLayout(o.size, o.f.k)
+	usable := make([]bool, len(rewrite))
+	for i := range rewrite {
+		usable[i] = !rewrite[i]
+	}
+	r := o.newShardReader(ctx, 0, l.stripes(), usable)
+	defer fs.CheckClose(r, &err)
+	name := makeShardName(o.remote, o.size, o.id)
+	modTime := o.ModTime(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writers := make([]*shardWriter, len(rewrite))
+	for i := range writers {
+		if rewrite[i] {
+			writers[i] = o.f.newShardWriter(ctx, i, name, modTime, l.shardSize())
+		}
+	}
+	for err == nil {
+		err = r.read(true)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		for i, w := range writers {
+			if w != nil && err == nil {
+				err = w.writeBlock(r.shards[i])
+			}
+		}
+	}
+	for i, w := range writers {
+		if w == nil {
+			continue
+		}
+		shard, closeErr := w.close(err)
+		if err == nil {
+			err = closeErr
+		}
+		if closeErr == nil {
+			o.shards[i] = shard
+		}
+	}
+	return err
+}
+
+// verifyShard reads all of shard checking the checksums of its blocks
+func verifyShard(ctx context.Context, shard fs.Object, l layout) (err error) {
+	in, err := shard.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, crcSize+blockSize)
+	for s := int64(0); s < l.stripes(); s++ {
+		block := buf[:crcSize+l.blockLen(s)]
+		if _, err = io.ReadFull(in, block); err != nil {
+			return err
+		}
+		if crc32.Checksum(block[crcSize:], crcTable) != binary.LittleEndian.Uint32(block) {
+			return fmt.Errorf("block %d is corrupted", s)
+		}
+	}
+	if _, err = io.ReadFull(in, buf[:1]); err != io.EOF {
+		if err == nil {
+			err = errors.New("shard is too long")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package erasure
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object is a file stored as shards on the upstreams
+type Object struct {
+	f      *Fs
+	remote string
+	size   int64
+	id     int64       // id of the upload the shards are from
+	shards []fs.Object // shard i on upstream i or nil if missing
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// shardCount returns the number of shards present
+func (o *Object) shardCount() (count int) {
+	for _, shard := range o.shards {
+		if shard != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	for _, shard := range o.shards {
+		if shard != nil {
+			return shard.ModTime(ctx)
+		}
+	}
+	return time.Now()
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	errs := make([]error, len(o.shards))
+	multithread(len(o.shards), func(i int) {
+		if o.shards[i] != nil {
+			errs[i] = o.shards[i].SetModTime(ctx, modTime)
+		}
+	})
+	return firstError(errs)
+}
+
+// Storable returns whether the object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// removeShards removes all the shards of the object
+func (o *Object) removeShards(ctx context.Context) error {
+	errs := make([]error, len(o.shards))
+	multithread(len(o.shards), func(i int) {
+		if o.shards[i] != nil {
+			errs[i] = o.shards[i].Remove(ctx)
+		}
+	})
+	return firstError(errs)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return o.removeShards(ctx)
+}
+
+// Update in to the object with the modTime given of the given size
+//
+// New shards are uploaded and then the old ones removed.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newO, err := o.f.put(ctx, in, src)
+	if err != nil {
+		return err
+	}
+	o.f.removeOldUploads(ctx, newO)
+	*o = *newO
+	return nil
+}
+
+// Open an object for read
+//
+// Only the data shards are read unless some of them can't be read.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if offset > o.size {
+		offset = o.size
+	}
+	if limit < 0 || offset+limit > o.size {
+		limit = o.size - offset
+	}
+	l := newLayout(o.size, o.f.k)
+	start, end := offset/l.stripeSize(), l.stripes()
+	if limit > 0 {
+		end = (offset+limit-1)/l.stripeSize() + 1
+	}
+	return &objectReader{
+		r:         o.newShardReader(ctx, start, end, nil),
+		skip:      offset - start*l.stripeSize(),
+		remaining: limit,
+	}, nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object = (*Object)(nil)
+)
@@ -0,0 +1,473 @@
+package erasure
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// Each file is split into stripes of k*blockSize bytes. Each stripe
+// is split into k data blocks and m parity blocks are computed from
+// them, then block i is appended to shard i.
+//
+// Each block in a shard has a CRC32C of its data before it, so
+// corrupted blocks are detected and treated as missing.
+//
+// The last stripe is shorter, with its blocks just big enough to
+// hold its data, so small files don't use much more space than
+// they need.
+const (
+	blockSize = 256 * 1024 // data bytes in each block
+	crcSize   = 4          // size of the CRC before each block
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// layout describes the stripes of a file of size with k data shards
+type layout struct {
+	k       int64
+	full    int64 // number of full stripes
+	partial int64 // data bytes in the last short stripe
+}
+
+// newLayout returns the layout of a file of size with k data shards
+func newLayout(size int64, k int) layout {
+	stripe := int64(k) * blockSize
+	return layout{
+		k:       int64(k),
+		full:    size / stripe,
+		partial: size % stripe,
+	}
+}
+
+// stripeSize returns the number of data bytes in a full stripe
+func (l layout) stripeSize() int64 {
+	return l.k * blockSize
+}
+
+// stripes returns the number of stripes
+func (l layout) stripes() int64 {
+	if l.partial > 0 {
+		return l.full + 1
+	}
+	return l.full
+}
+
+// dataLen returns the number of data bytes in stripe s
+func (l layout) dataLen(s int64) int64 {
+	if s < l.full {
+		return l.stripeSize()
+	}
+	return l.partial
+}
+
+// blockLen returns the size of the blocks of stripe s
+func (l layout) blockLen(s int64) int64 {
+	if s < l.full {
+		return blockSize
+	}
+	return (l.partial + l.k - 1) / l.k
+}
+
+// shardOffset returns the offset of stripe s in each shard
+func (l layout) shardOffset(s int64) int64 {
+	if s <= l.full {
+		return s * (crcSize + blockSize)
+	}
+	return l.shardSize()
+}
+
+// shardSize returns the size of each shard
+func (l layout) shardSize() int64 {
+	size := l.full * (crcSize + blockSize)
+	if l.partial > 0 {
+		size += crcSize + l.blockLen(l.full)
+	}
+	return size
+}
+
+// shardWriter streams a shard to an upstream
+type shardWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	o    fs.Object
+	err  error
+}
+
+// newShardWriter starts uploading shard i of the file name of size
+func (f *Fs) newShardWriter(ctx context.Context, i int, name string, modTime time.Time, size int64) *shardWriter {
+	pr, pw := io.Pipe()
+	w := &shardWriter{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		info := object.NewStaticObjectInfo(name, modTime, size, true, nil, f.upstreams[i])
+		w.o, w.err = f.upstreams[i].Put(ctx, pr, info)
+		if w.err == nil {
+			// Stop writes if Put didn't read everything
+			_ = pr.CloseWithError(errors.New("upload finished early"))
+		} else {
+			_ = pr.CloseWithError(w.err)
+		}
+	}()
+	return w
+}
+
+// writeBlock writes block preceded by its CRC
+func (w *shardWriter) writeBlock(block []byte) error {
+	var crc [crcSize]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.Checksum(block, crcTable))
+	if _, err := w.pw.Write(crc[:]); err != nil {
+		return err
+	}
+	_, err := w.pw.Write(block)
+	return err
+}
+
+// close finishes the upload, or aborts it if err is set, and returns
+// the uploaded object.
+func (w *shardWriter) close(err error) (fs.Object, error) {
+	if err != nil {
+		_ = w.pw.CloseWithError(err)
+	} else {
+		_ = w.pw.Close()
+	}
+	<-w.done
+	return w.o, w.err
+}
+
+// encoder splits the stripes of a file into shards
+type encoder struct {
+	f      *Fs
+	l      layout
+	data   []byte   // data of the current stripe
+	shards [][]byte // blocks of the current stripe
+}
+
+// newEncoder makes an encoder for a file with layout l
+func (f *Fs) newEncoder(l layout) *encoder {
+	e := &encoder{
+		f:      f,
+		l:      l,
+		data:   make([]byte, l.stripeSize()),
+		shards: make([][]byte, len(f.upstreams)),
+	}
+	for i := f.k; i < len(e.shards); i++ {
+		e.shards[i] = make([]byte, blockSize)
+	}
+	return e
+}
+
+// encode reads stripe s from in and computes its blocks
+func (e *encoder) encode(in io.Reader, s int64) error {
+	dataLen, blockLen := e.l.dataLen(s), e.l.blockLen(s)
+	if _, err := io.ReadFull(in, e.data[:dataLen]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	padded := e.data[:int64(e.f.k)*blockLen]
+	for i := dataLen; i < int64(len(padded)); i++ {
+		padded[i] = 0
+	}
+	for i := range e.shards {
+		if i < e.f.k {
+			e.shards[i] = padded[int64(i)*blockLen : int64(i+1)*blockLen]
+		} else {
+			e.shards[i] = e.shards[i][:blockLen]
+		}
+	}
+	return e.f.enc.Encode(e.shards)
+}
+
+// put uploads in as a new upload of src.Remote() returning the Object
+//
+// At least k+1 shards must be uploaded for it to succeed, so the file
+// can still be read if another upstream is lost. Any shards which
+// couldn't be uploaded are left for the heal command to write.
+func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo) (o *Object, err error) {
+	size := src.Size()
+	if size < 0 {
+		return nil, errors.New("can't upload files of unknown size")
+	}
+	l := newLayout(size, f.k)
+	o = &Object{
+		f:      f,
+		remote: src.Remote(),
+		size:   size,
+		id:     time.Now().UnixNano(),
+		shards: make([]fs.Object, len(f.upstreams)),
+	}
+	name := makeShardName(o.remote, size, o.id)
+	modTime := src.ModTime(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writers := make([]*shardWriter, len(f.upstreams))
+	for i := range writers {
+		writers[i] = f.newShardWriter(ctx, i, name, modTime, l.shardSize())
+	}
+	failed := make([]error, len(writers)) // why each shard failed
+	e := f.newEncoder(l)
+	for s := int64(0); s < l.stripes(); s++ {
+		err = e.encode(in, s)
+		if err != nil {
+			break
+		}
+		multithread(len(writers), func(i int) {
+			if failed[i] == nil {
+				failed[i] = writers[i].writeBlock(e.shards[i])
+			}
+		})
+		if err = f.checkQuorum(failed); err != nil {
+			break
+		}
+	}
+	for i, w := range writers {
+		closeErr := err
+		if closeErr == nil {
+			closeErr = failed[i]
+		}
+		var uploadErr error
+		o.shards[i], uploadErr = w.close(closeErr)
+		if failed[i] == nil {
+			failed[i] = uploadErr
+		}
+		if failed[i] != nil && o.shards[i] != nil && err == nil {
+			// Don't keep part of a shard which failed
+			if removeErr := o.shards[i].Remove(context.Background()); removeErr != nil {
+				fs.Errorf(o, "Failed to remove shard %d of failed upload: %v", i, removeErr)
+			}
+			o.shards[i] = nil
+		}
+	}
+	if err == nil {
+		err = f.checkQuorum(failed)
+	}
+	if err != nil {
+		cancel()
+		if removeErr := o.removeShards(context.Background()); removeErr != nil {
+			fs.Errorf(o, "Failed to remove shards of failed upload: %v", removeErr)
+		}
+		return nil, fmt.Errorf("failed to upload shards: %w", err)
+	}
+	for i, shardErr := range failed {
+		if shardErr != nil {
+			fs.Errorf(o, "Failed to upload shard %d to %v - run the heal command to write it: %v", i, f.upstreams[i], shardErr)
+		}
+	}
+	return o, nil
+}
+
+// checkQuorum returns an error if too few shards are left to upload
+// to make a file which can survive losing another upstream
+func (f *Fs) checkQuorum(failed []error) error {
+	ok := 0
+	for _, err := range failed {
+		if err == nil {
+			ok++
+		}
+	}
+	if ok < f.k+1 {
+		return fmt.Errorf("only %d shards could be uploaded but %d are needed: %w", ok, f.k+1, firstError(failed))
+	}
+	return nil
+}
+
+// shardReader reads stripes of a file from its shards
+type shardReader struct {
+	ctx     context.Context
+	o       *Object
+	l       layout
+	usable  []bool          // shards which can be read
+	streams []io.ReadCloser // open shards
+	pos     []int64         // stripe each open shard is at
+	next    int64           // stripe to read next
+	end     int64           // stripe to stop reading at
+	shards  [][]byte        // blocks of the current stripe
+	bufs    [][]byte        // buffers for the blocks
+}
+
+// newShardReader makes a reader for stripes start to end-1 of o
+//
+// Shards which aren't usable aren't read.
+func (o *Object) newShardReader(ctx context.Context, start, end int64, usable []bool) *shardReader {
+	n := len(o.shards)
+	r := &shardReader{
+		ctx:     ctx,
+		o:       o,
+		l:       newLayout(o.size, o.f.k),
+		usable:  make([]bool, n),
+		streams: make([]io.ReadCloser, n),
+		pos:     make([]int64, n),
+		next:    start,
+		end:     end,
+		shards:  make([][]byte, n),
+		bufs:    make([][]byte, n),
+	}
+	for i := range r.usable {
+		r.usable[i] = o.shards[i] != nil && (usable == nil || usable[i])
+	}
+	return r
+}
+
+// discard stops reading shard i
+func (r *shardReader) discard(i int, err error) {
+	fs.Errorf(r.o, "Failed to read shard %d from %v: %v", i, r.o.f.upstreams[i], err)
+	r.usable[i] = false
+	if r.streams[i] != nil {
+		_ = r.streams[i].Close()
+		r.streams[i] = nil
+	}
+}
+
+// errCorrupted is returned by readBlock if the block read doesn't
+// match its checksum
+var errCorrupted = errors.New("block is corrupted")
+
+// readBlock reads the block of stripe r.next from shard i into
+// r.shards[i]
+func (r *shardReader) readBlock(i int) error {
+	if r.streams[i] == nil {
+		start, end := r.l.shardOffset(r.next), r.l.shardOffset(r.end)
+		in, err := r.o.shards[i].Open(r.ctx, &fs.RangeOption{Start: start, End: end - 1})
+		if err != nil {
+			return err
+		}
+		r.streams[i] = in
+		r.pos[i] = r.next
+	}
+	// Skip the stripes which weren't needed from this shard
+	if skip := r.l.shardOffset(r.next) - r.l.shardOffset(r.pos[i]); skip > 0 {
+		if _, err := io.CopyN(io.Discard, r.streams[i], skip); err != nil {
+			return err
+		}
+	}
+	blockLen := r.l.blockLen(r.next)
+	if r.bufs[i] == nil {
+		r.bufs[i] = make([]byte, crcSize+blockSize)
+	}
+	buf := r.bufs[i][:crcSize+blockLen]
+	if _, err := io.ReadFull(r.streams[i], buf); err != nil {
+		return err
+	}
+	r.pos[i] = r.next + 1
+	if crc32.Checksum(buf[crcSize:], crcTable) != binary.LittleEndian.Uint32(buf) {
+		return errCorrupted
+	}
+	r.shards[i] = buf[crcSize:]
+	return nil
+}
+
+// read reads the next stripe into r.shards from k shards,
+// reconstructing the missing data blocks, or all the missing blocks
+// if all is set.
+func (r *shardReader) read(all bool) error {
+	if r.next >= r.end {
+		return io.EOF
+	}
+	got := 0
+	for i := range r.shards {
+		r.shards[i] = r.shards[i][:0]
+		if got == r.o.f.k || !r.usable[i] {
+			continue
+		}
+		err := r.readBlock(i)
+		if err == errCorrupted {
+			// The rest of the shard may still be good
+			fs.Errorf(r.o, "Block %d of shard %d on %v is corrupted", r.next, i, r.o.f.upstreams[i])
+			r.shards[i] = r.shards[i][:0]
+			continue
+		} else if err != nil {
+			r.discard(i, err)
+			r.shards[i] = r.shards[i][:0]
+			continue
+		}
+		got++
+	}
+	if got < r.o.f.k {
+		return fmt.Errorf("only %d shards could be read but %d are needed", got, r.o.f.k)
+	}
+	var err error
+	if all {
+		err = r.o.f.enc.Reconstruct(r.shards)
+	} else {
+		err = r.o.f.enc.ReconstructData(r.shards)
+	}
+	if err != nil {
+		return err
+	}
+	r.next++
+	return nil
+}
+
+// Close closes all the open shards
+func (r *shardReader) Close() error {
+	var err error
+	for i, in := range r.streams {
+		if in != nil {
+			if closeErr := in.Close(); closeErr != nil {
+				err = closeErr
+			}
+			r.streams[i] = nil
+		}
+	}
+	return err
+}
+
+// objectReader reads a range of a file
+type objectReader struct {
+	r         *shardReader
+	skip      int64  // bytes to skip at the start of the next stripe
+	remaining int64  // bytes left to read
+	stripe    []byte // data of the current stripe
+	buf       []byte // unread data of the current stripe
+}
+
+// Read reads up to len(p) bytes into p
+func (or *objectReader) Read(p []byte) (n int, err error) {
+	if or.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if len(or.buf) == 0 {
+		s := or.r.next
+		if err = or.r.read(false); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		or.stripe = or.stripe[:0]
+		remaining := or.r.l.dataLen(s)
+		for _, block := range or.r.shards[:or.r.o.f.k] {
+			if int64(len(block)) > remaining {
+				block = block[:remaining]
+			}
+			or.stripe = append(or.stripe, block...)
+			remaining -= int64(len(block))
+		}
+		or.buf = or.stripe[or.skip:]
+		or.skip = 0
+	}
+	if int64(len(p)) > or.remaining {
+		p = p[:or.remaining]
+	}
+	n = copy(p, or.buf)
+	or.buf = or.buf[n:]
+	or.remaining -= int64(n)
+	return n, nil
+}
+
+// Close closes the shards
+func (or *objectReader) Close() error {
+	return or.r.Close()
+}
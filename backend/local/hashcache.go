@@ -0,0 +1,167 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+const (
+	hashCacheFacility = "hashcache"
+
+	// Files modified this close to when they were hashed could be
+	// modified again without their modification time changing, so
+	// their hashes aren't cached.
+	hashCacheRacyWindow = 2 * time.Second
+)
+
+// hashCacheID identifies the version of a file a cached hash is for
+type hashCacheID struct {
+	Size    int64
+	ModTime int64 // in Unix nanoseconds
+	Dev     uint64
+	Ino     uint64
+}
+
+// hashCacheRecord is what is stored in the hash cache for each file
+type hashCacheRecord struct {
+	ID     hashCacheID
+	Hashes map[string]string // hash name to value
+}
+
+func (r *hashCacheRecord) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *hashCacheRecord) decode(data []byte) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(r)
+}
+
+// kvHashGet reads the record for key if it is for the file id
+type kvHashGet struct {
+	key   string
+	id    hashCacheID
+	found bool
+	rec   hashCacheRecord
+}
+
+func (op *kvHashGet) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if data == nil {
+		return nil
+	}
+	if err := op.rec.decode(data); err != nil {
+		fs.Debugf(op.key, "hash cache: ignoring bad record: %v", err)
+		return nil
+	}
+	op.found = op.rec.ID == op.id
+	return nil
+}
+
+// kvHashPut adds hashes to the record for key, replacing it if it
+// was for a different version of the file
+type kvHashPut struct {
+	key    string
+	id     hashCacheID
+	hashes map[string]string
+}
+
+func (op *kvHashPut) Do(ctx context.Context, b kv.Bucket) error {
+	rec := hashCacheRecord{ID: op.id, Hashes: op.hashes}
+	var old hashCacheRecord
+	if data := b.Get([]byte(op.key)); data != nil && old.decode(data) == nil && old.ID == op.id {
+		for name, value := range op.hashes {
+			old.Hashes[name] = value
+		}
+		rec.Hashes = old.Hashes
+	}
+	data, err := rec.encode()
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(op.key), data)
+}
+
+// kvHashDelete removes the record for key
+type kvHashDelete struct {
+	key string
+}
+
+func (op *kvHashDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete([]byte(op.key))
+}
+
+// hashCacheID reads the identity of the file for the hash cache,
+// returning false if the cache isn't in use for it
+func (o *Object) hashCacheID() (id hashCacheID, ok bool) {
+	if o.fs.hashCache == nil || o.translatedLink {
+		return id, false
+	}
+	fi, err := o.fs.lstat(o.path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return id, false
+	}
+	id.Size = fi.Size()
+	id.ModTime = fi.ModTime().UnixNano()
+	id.Dev, id.Ino = readInode(fi)
+	return id, true
+}
+
+// getCachedHash returns the hash of type ht from the hash cache if
+// it was saved for the version of the file identified by id
+func (o *Object) getCachedHash(ctx context.Context, id hashCacheID, ht hash.Type) (string, bool) {
+	op := &kvHashGet{key: o.path, id: id}
+	err := o.fs.hashCache.Do(false, op)
+	if err != nil {
+		if err != kv.ErrEmpty {
+			fs.Debugf(o, "hash cache: failed to read: %v", err)
+		}
+		return "", false
+	}
+	if !op.found {
+		return "", false
+	}
+	value, found := op.rec.Hashes[ht.String()]
+	return value, found
+}
+
+// putCachedHashes saves the hashes of the version of the file
+// identified by id which was read starting at hashStart
+func (o *Object) putCachedHashes(ctx context.Context, id hashCacheID, hashes map[hash.Type]string, hashStart time.Time) {
+	if time.Unix(0, id.ModTime).After(hashStart.Add(-hashCacheRacyWindow)) {
+		fs.Debugf(o, "hash cache: not saving hash of recently modified file")
+		return
+	}
+	op := &kvHashPut{
+		key:    o.path,
+		id:     id,
+		hashes: make(map[string]string, len(hashes)),
+	}
+	for ht, value := range hashes {
+		op.hashes[ht.String()] = value
+	}
+	err := o.fs.hashCache.Do(true, op)
+	if err != nil {
+		fs.Debugf(o, "hash cache: failed to save: %v", err)
+	}
+}
+
+// removeCachedHashes removes the file from the hash cache
+func (o *Object) removeCachedHashes() {
+	if o.fs.hashCache == nil {
+		return
+	}
+	err := o.fs.hashCache.Do(true, &kvHashDelete{key: o.path})
+	if err != nil && err != kv.ErrEmpty {
+		fs.Debugf(o, "hash cache: failed to remove: %v", err)
+	}
+}
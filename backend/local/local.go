@@ -26,6 +26,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/kv"
 	"github.com/rclone/rclone/lib/readers"
 	"golang.org/x/text/unicode/norm"
 )
@@ -205,6 +206,18 @@ when copying to a CIFS mount owned by another user. If this option is
 enabled, rclone will no longer update the modtime after copying a file.`,
 			Default:  false,
 			Advanced: true,
+		}, {
+			Name: "hash_cache",
+			Help: `Keep a persistent cache of file hashes.
+
+Normally rclone reads the whole of a local file each time it needs its
+hash, for example in every run of "rclone check" or "rclone sync
+--checksum". If this flag is set then rclone saves the hashes it
+calculates in a database in the rclone cache directory, along with the
+size, modification time and inode of the file. The hash is read from
+the database instead of the file as long as none of these have changed.`,
+			Default:  false,
+			Advanced: true,
 		}, {
 			Name:     config.ConfigEncoding,
 			Help:     config.ConfigEncodingHelp,
@@ -229,6 +242,7 @@ type Options struct {
 	NoPreAllocate     bool                 `config:"no_preallocate"`
 	NoSparse          bool                 `config:"no_sparse"`
 	NoSetModTime      bool                 `config:"no_set_modtime"`
+	HashCache         bool                 `config:"hash_cache"`
 	Enc               encoder.MultiEncoder `config:"encoding"`
 }
 
@@ -244,6 +258,7 @@ type Fs struct {
 	warnedMu       sync.Mutex          // used for locking access to 'warned'.
 	warned         map[string]struct{} // whether we have warned about this string
 	xattrSupported int32               // whether xattrs are supported (atomic access)
+	hashCache      *kv.DB              // persistent hash cache if in use
 
 	// do os.Lstat or os.Stat
 	lstat        func(name string) (os.FileInfo, error)
@@ -307,6 +322,12 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if opt.FollowSymlinks {
 		f.lstat = os.Stat
 	}
+	if opt.HashCache {
+		f.hashCache, err = kv.Start(ctx, hashCacheFacility, f)
+		if err != nil {
+			fs.Errorf(f, "Not using the hash cache: %v", err)
+		}
+	}
 
 	// Check to see if this points to a file
 	fi, err := f.lstat(f.root)
@@ -935,6 +956,19 @@ func (o *Object) Hash(ctx context.Context, r hash.Type) (string, error) {
 	o.fs.objectMetaMu.RUnlock()
 
 	if changed || !hashFound {
+		id, idOK := o.hashCacheID()
+		if idOK {
+			if hashValue, hashFound = o.getCachedHash(ctx, id, r); hashFound {
+				o.fs.objectMetaMu.Lock()
+				if o.hashes == nil {
+					o.hashes = map[hash.Type]string{}
+				}
+				o.hashes[r] = hashValue
+				o.fs.objectMetaMu.Unlock()
+				return hashValue, nil
+			}
+		}
+		hashStart := time.Now()
 		var in io.ReadCloser
 
 		if !o.translatedLink {
@@ -970,6 +1004,9 @@ func (o *Object) Hash(ctx context.Context, r hash.Type) (string, error) {
 			o.hashes[r] = hashValue
 		}
 		o.fs.objectMetaMu.Unlock()
+		if idOK {
+			o.putCachedHashes(ctx, id, hashes, hashStart)
+		}
 	}
 	return hashValue, nil
 }
@@ -1289,7 +1326,26 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	}
 
 	// ReRead info now that we have finished
-	return o.lstat()
+	err = o.lstat()
+	if err != nil {
+		return err
+	}
+
+	// Save the hashes we calculated for next time
+	if hasher != nil {
+		if id, ok := o.hashCacheID(); ok {
+			o.putCachedHashes(ctx, id, hasher.Sums(), time.Now())
+		}
+	}
+	return nil
+}
+
+// Shutdown the backend, closing the hash cache if in use
+func (f *Fs) Shutdown(ctx context.Context) error {
+	if f.hashCache == nil {
+		return nil
+	}
+	return f.hashCache.Stop(false)
 }
 
 var sparseWarning sync.Once
@@ -1298,7 +1354,9 @@ var sparseWarning sync.Once
 //
 // Pass in the remote desired and the size if known.
 //
-// It truncates any existing object
+// An existing object larger than size is truncated to size, or to 0 if
+// the size is unknown. A shorter one is kept as it is, so data already
+// written is preserved and the file isn't extended before it is written.
 func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
 	// Temporary Object under construction
 	o := f.newObject(remote)
@@ -1312,10 +1370,28 @@ func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.Wr
 		return nil, errors.New("can't open a symlink for random writing")
 	}
 
-	out, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	out, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
+	// Only ever shrink the file - extending it to the final size
+	// here would make an interrupted write look complete
+	fi, err := out.Stat()
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	truncateSize := size
+	if truncateSize < 0 {
+		truncateSize = 0
+	}
+	if fi.Size() > truncateSize {
+		err = out.Truncate(truncateSize)
+		if err != nil {
+			_ = out.Close()
+			return nil, err
+		}
+	}
 	// Pre-allocate the file for performance reasons
 	if !f.opt.NoPreAllocate {
 		err = file.PreAllocate(size, out)
@@ -1337,6 +1413,21 @@ func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.Wr
 	return out, nil
 }
 
+// BlockHashes returns the MD5 hashes of each block of blockSize bytes
+// of remote, the last of which may be short.
+func (f *Fs) BlockHashes(ctx context.Context, remote string, blockSize int64) (hashes []string, err error) {
+	o, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	return hash.StreamBlocks(in, blockSize)
+}
+
 // setMetadata sets the file info from the os.FileInfo passed in
 func (o *Object) setMetadata(info os.FileInfo) {
 	// if not checking updated then don't update the stat
@@ -1369,6 +1460,7 @@ func (o *Object) clearHashCache() {
 	o.fs.objectMetaMu.Lock()
 	o.hashes = nil
 	o.fs.objectMetaMu.Unlock()
+	o.removeCachedHashes()
 }
 
 // Stat an Object into info
@@ -1401,6 +1493,17 @@ func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error)
 	return metadata, nil
 }
 
+// SetMetadata sets the keys in metadata on the object leaving any
+// other metadata alone
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	err := o.writeMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	// Setting the metadata may have changed the times or the mode
+	return o.lstat()
+}
+
 // Write the metadata on the object
 func (o *Object) writeMetadata(metadata fs.Metadata) (err error) {
 	err = o.setXattr(metadata)
@@ -1449,6 +1552,9 @@ var (
 	_ fs.DirMover       = &Fs{}
 	_ fs.Commander      = &Fs{}
 	_ fs.OpenWriterAter = &Fs{}
+	_ fs.BlockHasher    = &Fs{}
+	_ fs.Shutdowner     = &Fs{}
 	_ fs.Object         = &Object{}
 	_ fs.Metadataer     = &Object{}
+	_ fs.SetMetadataer  = &Object{}
 )
@@ -21,6 +21,7 @@ import (
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/kv"
 	"github.com/rclone/rclone/lib/readers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -245,6 +246,60 @@ func TestHashOnDelete(t *testing.T) {
 	require.Error(t, err)
 }
 
+// Test the persistent hash cache
+func TestHashCache(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	const filePath = "file.txt"
+	when := time.Now().Add(-time.Hour)
+	r.WriteFile(filePath, "content", when)
+	f, err := NewFs(ctx, "local", r.LocalName, configmap.Simple{
+		"hash_cache": "true",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, f.(*Fs).hashCache)
+
+	getHash := func() string {
+		// Use a new object each time so nothing is cached in memory
+		o, err := f.NewObject(ctx, filePath)
+		require.NoError(t, err)
+		md5, err := o.Hash(ctx, hash.MD5)
+		require.NoError(t, err)
+		return md5
+	}
+	assert.Equal(t, "9a0364b9e99bb480dd25e1f0284c8555", getHash())
+
+	// Change the contents in place without changing the size,
+	// modification time or inode - the cached hash is used
+	localPath := filepath.Join(r.LocalName, filePath)
+	fd, err := os.OpenFile(localPath, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = fd.WriteAt([]byte("CONTENT"), 0)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+	require.NoError(t, os.Chtimes(localPath, when, when))
+	assert.Equal(t, "9a0364b9e99bb480dd25e1f0284c8555", getHash())
+
+	// Change the modification time and the file is hashed again
+	when = when.Add(time.Minute)
+	require.NoError(t, os.Chtimes(localPath, when, when))
+	assert.Equal(t, "45685e95985e20822fb2538a522a5ccf", getHash())
+
+	// Update the object and the hash is as expected
+	o, err := f.NewObject(ctx, filePath)
+	require.NoError(t, err)
+	b := bytes.NewBufferString("potato")
+	src := object.NewStaticObjectInfo(filePath, when, int64(b.Len()), true, nil, f)
+	err = o.Update(ctx, b, src, &fs.HashesOption{Hashes: hash.NewHashSet(hash.MD5)})
+	require.NoError(t, err)
+	assert.Equal(t, "8ee2027983915ec78acc45027d874316", getHash())
+
+	// Shutdown stops the hash cache
+	db := f.(*Fs).hashCache
+	require.NoError(t, f.Features().Shutdown(ctx))
+	assert.Equal(t, kv.ErrInactive, db.Do(false, &kvHashDelete{key: localPath}))
+}
+
 func TestMetadata(t *testing.T) {
 	ctx := context.Background()
 	r := fstest.NewRun(t)
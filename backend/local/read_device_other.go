@@ -12,3 +12,9 @@ import "os"
 func readDevice(fi os.FileInfo, oneFileSystem bool) uint64 {
 	return devUnset
 }
+
+// readInode returns the device and inode numbers of a valid
+// os.FileInfo, or zeros if it fails.
+func readInode(fi os.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
@@ -25,3 +25,13 @@ func readDevice(fi os.FileInfo, oneFileSystem bool) uint64 {
 	}
 	return uint64(statT.Dev) // nolint: unconvert
 }
+
+// readInode returns the device and inode numbers of a valid
+// os.FileInfo, or zeros if it fails.
+func readInode(fi os.FileInfo) (dev, ino uint64) {
+	statT, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(statT.Dev), uint64(statT.Ino) // nolint: unconvert
+}
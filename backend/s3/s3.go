@@ -3854,6 +3854,44 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 	return f.Put(ctx, in, src, options...)
 }
 
+// OpenChunkWriter returns the chunk size and a ChunkWriter
+//
+// Pass in the remote and the src object
+// You can also use options to hint at the desired chunk size
+func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	if f.opt.VersionAt.IsSet() {
+		return info, nil, errNotWithVersionAt
+	}
+	if src.Size() < 0 {
+		return info, nil, errors.New("can't open a chunk writer for an object of unknown size")
+	}
+	// Temporary Object under construction
+	o := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	bucket, _ := o.split()
+	err = f.makeBucket(ctx, bucket)
+	if err != nil {
+		return info, nil, err
+	}
+	req, _, err := o.prepareUpload(ctx, src, options, true)
+	if err != nil {
+		return info, nil, err
+	}
+	w, err := f.newChunkWriter(ctx, o, req, src.Size())
+	if err != nil {
+		return info, nil, err
+	}
+	w.checkETag = true
+	info = fs.ChunkWriterInfo{
+		ChunkSize:         w.chunkSize,
+		LeavePartsOnError: f.opt.LeavePartsOnError,
+	}
+	fs.Debugf(o, "open chunk writer: started multipart upload: %v", *w.uploadID)
+	return info, w, nil
+}
+
 // Check if the bucket exists
 //
 // NB this can return incorrect results if called immediately after bucket deletion
@@ -4913,6 +4951,82 @@ func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
 	return o.fs.copy(ctx, &req, bucket, bucketPath, bucket, bucketPath, o)
 }
 
+// SetMetadata sets the keys in metadata on the object leaving any
+// other metadata alone
+//
+// This copies the object to itself to update the metadata.
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Can't update metadata here
+	if o.storageClass != nil && (*o.storageClass == "GLACIER" || *o.storageClass == "DEEP_ARCHIVE") {
+		return fs.ErrorNotImplemented
+	}
+
+	// Start from the existing metadata as it is all replaced
+	meta := make(map[string]string, len(o.meta)+len(metadata))
+	for k, v := range o.meta {
+		meta[k] = v
+	}
+	req := s3.CopyObjectInput{
+		CacheControl:       o.cacheControl,
+		ContentDisposition: o.contentDisposition,
+		ContentEncoding:    o.contentEncoding,
+		ContentLanguage:    o.contentLanguage,
+		ContentType:        stringPointerOrNil(o.mimeType),
+		StorageClass:       o.storageClass,
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace), // replace metadata with that passed in
+	}
+	// merge metadata into request and user metadata
+	for k, v := range metadata {
+		pv := aws.String(v)
+		k = strings.ToLower(k)
+		if o.fs.opt.NoSystemMetadata {
+			meta[k] = v
+			continue
+		}
+		switch k {
+		case "cache-control":
+			req.CacheControl = pv
+		case "content-disposition":
+			req.ContentDisposition = pv
+		case "content-encoding":
+			req.ContentEncoding = pv
+		case "content-language":
+			req.ContentLanguage = pv
+		case "content-type":
+			req.ContentType = pv
+		case "x-amz-tagging":
+			req.Tagging = pv
+			req.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+		case "tier":
+			// ignore
+		case "mtime":
+			modTime, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				fs.Debugf(o, "failed to parse metadata %s: %q: %v", k, v, err)
+			} else {
+				meta[metaMtime] = swift.TimeToFloatString(modTime)
+			}
+		default:
+			meta[k] = v
+		}
+	}
+	req.Metadata = mapToS3Metadata(meta)
+
+	bucket, bucketPath := o.split()
+	err = o.fs.copy(ctx, &req, bucket, bucketPath, bucket, bucketPath, o)
+	if err != nil {
+		return err
+	}
+	// Read the metadata again when it is next needed
+	o.meta = nil
+	return nil
+}
+
 // Storable raturns a boolean indicating if this object is storable
 func (o *Object) Storable() bool {
 	return true
@@ -5077,15 +5191,38 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.Read
 
 var warnStreamUpload sync.Once
 
-func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, size int64, in io.Reader) (wantETag, gotETag string, versionID *string, err error) {
-	f := o.fs
+// s3ChunkWriter uploads the parts of a multipart upload
+type s3ChunkWriter struct {
+	f                *Fs
+	o                *Object
+	bucket           *string
+	key              *string
+	uploadID         *string
+	requestPayer     *string
+	sseAlgorithm     *string
+	sseKey           *string
+	sseKeyMD5        *string
+	chunkSize        int64
+	concurrency      int
+	completedPartsMu sync.Mutex
+	completedParts   []*s3.CompletedPart
+	md5sMu           sync.Mutex
+	md5s             []byte
+	eTag             string  // Etag we got from the upload
+	versionID        *string // versionID we got from the upload
+	checkETag        bool    // check the Etag in Close if set
+}
 
+// newChunkWriter starts a multipart upload of size bytes to o
+// described by req.
+//
+// size may be -1 if the size isn't known.
+func (f *Fs) newChunkWriter(ctx context.Context, o *Object, req *s3.PutObjectInput, size int64) (*s3ChunkWriter, error) {
 	// make concurrency machinery
 	concurrency := f.opt.UploadConcurrency
 	if concurrency < 1 {
 		concurrency = 1
 	}
-	tokens := pacer.NewTokenDispenser(concurrency)
 
 	uploadParts := f.opt.MaxUploadParts
 	if uploadParts < 1 {
@@ -5109,21 +5246,180 @@ func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, si
 		partSize = chunksize.Calculator(o, size, uploadParts, f.opt.ChunkSize)
 	}
 
-	memPool := f.getMemoryPool(int64(partSize))
-
 	var mReq s3.CreateMultipartUploadInput
 	//structs.SetFrom(&mReq, req)
 	setFrom_s3CreateMultipartUploadInput_s3PutObjectInput(&mReq, req)
 	var cout *s3.CreateMultipartUploadOutput
-	err = f.pacer.Call(func() (bool, error) {
+	err := f.pacer.Call(func() (bool, error) {
 		var err error
 		cout, err = f.c.CreateMultipartUploadWithContext(ctx, &mReq)
 		return f.shouldRetry(ctx, err)
 	})
 	if err != nil {
-		return wantETag, gotETag, nil, fmt.Errorf("multipart upload failed to initialise: %w", err)
+		return nil, fmt.Errorf("multipart upload failed to initialise: %w", err)
+	}
+	return &s3ChunkWriter{
+		f:            f,
+		o:            o,
+		bucket:       req.Bucket,
+		key:          req.Key,
+		uploadID:     cout.UploadId,
+		requestPayer: req.RequestPayer,
+		sseAlgorithm: req.SSECustomerAlgorithm,
+		sseKey:       req.SSECustomerKey,
+		sseKeyMD5:    req.SSECustomerKeyMD5,
+		chunkSize:    int64(partSize),
+		concurrency:  concurrency,
+	}, nil
+}
+
+// addMd5 adds a binary md5 to the md5 calculated so far
+func (w *s3ChunkWriter) addMd5(md5binary *[md5.Size]byte, chunkNumber int64) {
+	w.md5sMu.Lock()
+	defer w.md5sMu.Unlock()
+	start := chunkNumber * md5.Size
+	end := start + md5.Size
+	if extend := end - int64(len(w.md5s)); extend > 0 {
+		w.md5s = append(w.md5s, make([]byte, extend)...)
 	}
-	uid := cout.UploadId
+	copy(w.md5s[start:end], (*md5binary)[:])
+}
+
+// WriteChunk uploads chunk number chunkNumber (starting from 0)
+func (w *s3ChunkWriter) WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (int64, error) {
+	if chunkNumber < 0 {
+		return -1, fmt.Errorf("invalid chunk number provided: %v", chunkNumber)
+	}
+
+	// create checksum of buffer for integrity checking
+	m := md5.New()
+	currentChunkSize, err := io.Copy(m, reader)
+	if err != nil {
+		return -1, err
+	}
+	var md5sumBinary [md5.Size]byte
+	copy(md5sumBinary[:], m.Sum(nil))
+	w.addMd5(&md5sumBinary, int64(chunkNumber))
+	md5sum := base64.StdEncoding.EncodeToString(md5sumBinary[:])
+
+	// S3 requires 1 <= PartNumber <= 10000
+	partNum := int64(chunkNumber) + 1
+	err = w.f.pacer.Call(func() (bool, error) {
+		// rewind the reader on retry and after reading md5
+		_, err := reader.Seek(0, io.SeekStart)
+		if err != nil {
+			return false, err
+		}
+		uploadPartReq := &s3.UploadPartInput{
+			Body:                 reader,
+			Bucket:               w.bucket,
+			Key:                  w.key,
+			PartNumber:           &partNum,
+			UploadId:             w.uploadID,
+			ContentMD5:           &md5sum,
+			ContentLength:        &currentChunkSize,
+			RequestPayer:         w.requestPayer,
+			SSECustomerAlgorithm: w.sseAlgorithm,
+			SSECustomerKey:       w.sseKey,
+			SSECustomerKeyMD5:    w.sseKeyMD5,
+		}
+		uout, err := w.f.c.UploadPartWithContext(ctx, uploadPartReq)
+		if err != nil {
+			if partNum <= int64(w.concurrency) {
+				return w.f.shouldRetry(ctx, err)
+			}
+			// retry all chunks once have done the first batch
+			return true, err
+		}
+		w.completedPartsMu.Lock()
+		w.completedParts = append(w.completedParts, &s3.CompletedPart{
+			PartNumber: &partNum,
+			ETag:       uout.ETag,
+		})
+		w.completedPartsMu.Unlock()
+		return false, nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("multipart upload failed to upload part: %w", err)
+	}
+	fs.Debugf(w.o, "multipart upload wrote chunk %d with %v bytes", partNum, currentChunkSize)
+	return currentChunkSize, nil
+}
+
+// Abort the multipart upload
+func (w *s3ChunkWriter) Abort(ctx context.Context) error {
+	err := w.f.pacer.Call(func() (bool, error) {
+		_, err := w.f.c.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:       w.bucket,
+			Key:          w.key,
+			UploadId:     w.uploadID,
+			RequestPayer: w.requestPayer,
+		})
+		return w.f.shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	fs.Debugf(w.o, "multipart upload aborted")
+	return nil
+}
+
+// Close and finalise the multipart upload
+func (w *s3ChunkWriter) Close(ctx context.Context) (err error) {
+	// sort the completed parts by part number
+	sort.Slice(w.completedParts, func(i, j int) bool {
+		return *w.completedParts[i].PartNumber < *w.completedParts[j].PartNumber
+	})
+	var resp *s3.CompleteMultipartUploadOutput
+	err = w.f.pacer.Call(func() (bool, error) {
+		resp, err = w.f.c.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket: w.bucket,
+			Key:    w.key,
+			MultipartUpload: &s3.CompletedMultipartUpload{
+				Parts: w.completedParts,
+			},
+			RequestPayer: w.requestPayer,
+			UploadId:     w.uploadID,
+		})
+		return w.f.shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return fmt.Errorf("multipart upload failed to finalise: %w", err)
+	}
+	if resp != nil {
+		if resp.ETag != nil {
+			w.eTag = *resp.ETag
+		}
+		w.versionID = resp.VersionId
+	}
+	// Check multipart upload ETag if required
+	if w.checkETag && w.f.opt.UseMultipartEtag.Value && !w.f.etagIsNotMD5 && w.eTag != "" {
+		wantETag := w.wantETag()
+		gotETag := strings.Trim(strings.ToLower(w.eTag), `"`)
+		if wantETag != gotETag {
+			return fmt.Errorf("multipart upload corrupted: Etag differ: expecting %s but got %s", wantETag, gotETag)
+		}
+		fs.Debugf(w.o, "Multipart upload Etag: %s OK", wantETag)
+	}
+	return nil
+}
+
+// wantETag returns the ETag the multipart upload should have
+func (w *s3ChunkWriter) wantETag() string {
+	hashOfHashes := md5.Sum(w.md5s)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hashOfHashes[:]), len(w.completedParts))
+}
+
+func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, size int64, in io.Reader) (wantETag, gotETag string, versionID *string, err error) {
+	f := o.fs
+
+	w, err := f.newChunkWriter(ctx, o, req, size)
+	if err != nil {
+		return wantETag, gotETag, nil, err
+	}
+
+	tokens := pacer.NewTokenDispenser(w.concurrency)
+	memPool := f.getMemoryPool(w.chunkSize)
 
 	uploadCtx, cancel := context.WithCancel(ctx)
 	defer atexit.OnError(&err, func() {
@@ -5132,15 +5428,7 @@ func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, si
 			return
 		}
 		fs.Debugf(o, "Cancelling multipart upload")
-		errCancel := f.pacer.Call(func() (bool, error) {
-			_, err := f.c.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
-				Bucket:       req.Bucket,
-				Key:          req.Key,
-				UploadId:     uid,
-				RequestPayer: req.RequestPayer,
-			})
-			return f.shouldRetry(ctx, err)
-		})
+		errCancel := w.Abort(context.Background())
 		if errCancel != nil {
 			fs.Debugf(o, "Failed to cancel multipart upload: %v", errCancel)
 		}
@@ -5149,24 +5437,9 @@ func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, si
 	var (
 		g, gCtx  = errgroup.WithContext(uploadCtx)
 		finished = false
-		partsMu  sync.Mutex // to protect parts
-		parts    []*s3.CompletedPart
 		off      int64
-		md5sMu   sync.Mutex
-		md5s     []byte
 	)
 
-	addMd5 := func(md5binary *[md5.Size]byte, partNum int64) {
-		md5sMu.Lock()
-		defer md5sMu.Unlock()
-		start := partNum * md5.Size
-		end := start + md5.Size
-		if extend := end - int64(len(md5s)); extend > 0 {
-			md5s = append(md5s, make([]byte, extend)...)
-		}
-		copy(md5s[start:end], (*md5binary)[:])
-	}
-
 	for partNum := int64(1); !finished; partNum++ {
 		// Get a block of memory from the pool and token which limits concurrency.
 		tokens.Get()
@@ -5200,53 +5473,13 @@ func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, si
 		}
 		buf = buf[:n]
 
-		partNum := partNum
+		chunkNumber := int(partNum - 1)
 		fs.Debugf(o, "multipart upload starting chunk %d size %v offset %v/%v", partNum, fs.SizeSuffix(n), fs.SizeSuffix(off), fs.SizeSuffix(size))
 		off += int64(n)
 		g.Go(func() (err error) {
 			defer free()
-			partLength := int64(len(buf))
-
-			// create checksum of buffer for integrity checking
-			md5sumBinary := md5.Sum(buf)
-			addMd5(&md5sumBinary, partNum-1)
-			md5sum := base64.StdEncoding.EncodeToString(md5sumBinary[:])
-
-			err = f.pacer.Call(func() (bool, error) {
-				uploadPartReq := &s3.UploadPartInput{
-					Body:                 bytes.NewReader(buf),
-					Bucket:               req.Bucket,
-					Key:                  req.Key,
-					PartNumber:           &partNum,
-					UploadId:             uid,
-					ContentMD5:           &md5sum,
-					ContentLength:        &partLength,
-					RequestPayer:         req.RequestPayer,
-					SSECustomerAlgorithm: req.SSECustomerAlgorithm,
-					SSECustomerKey:       req.SSECustomerKey,
-					SSECustomerKeyMD5:    req.SSECustomerKeyMD5,
-				}
-				uout, err := f.c.UploadPartWithContext(gCtx, uploadPartReq)
-				if err != nil {
-					if partNum <= int64(concurrency) {
-						return f.shouldRetry(gCtx, err)
-					}
-					// retry all chunks once have done the first batch
-					return true, err
-				}
-				partsMu.Lock()
-				parts = append(parts, &s3.CompletedPart{
-					PartNumber: &partNum,
-					ETag:       uout.ETag,
-				})
-				partsMu.Unlock()
-
-				return false, nil
-			})
-			if err != nil {
-				return fmt.Errorf("multipart upload failed to upload part: %w", err)
-			}
-			return nil
+			_, err = w.WriteChunk(gCtx, chunkNumber, bytes.NewReader(buf))
+			return err
 		})
 	}
 	err = g.Wait()
@@ -5254,36 +5487,11 @@ func (o *Object) uploadMultipart(ctx context.Context, req *s3.PutObjectInput, si
 		return wantETag, gotETag, nil, err
 	}
 
-	// sort the completed parts by part number
-	sort.Slice(parts, func(i, j int) bool {
-		return *parts[i].PartNumber < *parts[j].PartNumber
-	})
-
-	var resp *s3.CompleteMultipartUploadOutput
-	err = f.pacer.Call(func() (bool, error) {
-		resp, err = f.c.CompleteMultipartUploadWithContext(uploadCtx, &s3.CompleteMultipartUploadInput{
-			Bucket: req.Bucket,
-			Key:    req.Key,
-			MultipartUpload: &s3.CompletedMultipartUpload{
-				Parts: parts,
-			},
-			RequestPayer: req.RequestPayer,
-			UploadId:     uid,
-		})
-		return f.shouldRetry(uploadCtx, err)
-	})
+	err = w.Close(uploadCtx)
 	if err != nil {
-		return wantETag, gotETag, nil, fmt.Errorf("multipart upload failed to finalise: %w", err)
-	}
-	hashOfHashes := md5.Sum(md5s)
-	wantETag = fmt.Sprintf("%s-%d", hex.EncodeToString(hashOfHashes[:]), len(parts))
-	if resp != nil {
-		if resp.ETag != nil {
-			gotETag = *resp.ETag
-		}
-		versionID = resp.VersionId
+		return wantETag, gotETag, nil, err
 	}
-	return wantETag, gotETag, versionID, nil
+	return w.wantETag(), w.eTag, w.versionID, nil
 }
 
 // unWrapAwsError unwraps AWS errors, looking for a non AWS error
@@ -5413,22 +5621,15 @@ func (o *Object) uploadSinglepartPresignedRequest(ctx context.Context, req *s3.P
 	return etag, lastModified, versionID, nil
 }
 
-// Update the Object from in with modTime and size
-func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
-	if o.fs.opt.VersionAt.IsSet() {
-		return errNotWithVersionAt
-	}
+// prepareUpload makes the request to upload src to o
+//
+// It returns the request and the MD5 of src if known.
+func (o *Object) prepareUpload(ctx context.Context, src fs.ObjectInfo, options []fs.OpenOption, multipart bool) (req *s3.PutObjectInput, md5sumHex string, err error) {
 	bucket, bucketPath := o.split()
-	err := o.fs.makeBucket(ctx, bucket)
-	if err != nil {
-		return err
-	}
 	modTime := src.ModTime(ctx)
 	size := src.Size()
 
-	multipart := size < 0 || size >= int64(o.fs.opt.UploadCutoff)
-
-	req := s3.PutObjectInput{
+	req = &s3.PutObjectInput{
 		Bucket: &bucket,
 		ACL:    stringPointerOrNil(o.fs.opt.ACL),
 		Key:    &bucketPath,
@@ -5437,7 +5638,7 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	// Fetch metadata if --metadata is in use
 	meta, err := fs.GetMetadataOptions(ctx, src, options)
 	if err != nil {
-		return fmt.Errorf("failed to read metadata from source object: %w", err)
+		return nil, "", fmt.Errorf("failed to read metadata from source object: %w", err)
 	}
 	req.Metadata = make(map[string]*string, len(meta)+2)
 	// merge metadata into request and user metadata
@@ -5489,7 +5690,6 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	// - for multipart provided checksums aren't disabled
 	//    - so we can add the md5sum in the metadata as metaMD5Hash
 	var md5sumBase64 string
-	var md5sumHex string
 	if !multipart || !o.fs.opt.DisableChecksum {
 		md5sumHex, err = src.Hash(ctx, hash.MD5)
 		if err == nil && matchMd5.MatchString(md5sumHex) {
@@ -5582,17 +5782,38 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		}
 	}
 
+	return req, md5sumHex, nil
+}
+
+// Update the Object from in with modTime and size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
+	bucket, _ := o.split()
+	err := o.fs.makeBucket(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	size := src.Size()
+	multipart := size < 0 || size >= int64(o.fs.opt.UploadCutoff)
+
+	req, md5sumHex, err := o.prepareUpload(ctx, src, options, multipart)
+	if err != nil {
+		return err
+	}
+
 	var wantETag string        // Multipart upload Etag to check
 	var gotETag string         // Etag we got from the upload
 	var lastModified time.Time // Time we got from the upload
 	var versionID *string      // versionID we got from the upload
 	if multipart {
-		wantETag, gotETag, versionID, err = o.uploadMultipart(ctx, &req, size, in)
+		wantETag, gotETag, versionID, err = o.uploadMultipart(ctx, req, size, in)
 	} else {
 		if o.fs.opt.UsePresignedRequest {
-			gotETag, lastModified, versionID, err = o.uploadSinglepartPresignedRequest(ctx, &req, size, in)
+			gotETag, lastModified, versionID, err = o.uploadSinglepartPresignedRequest(ctx, req, size, in)
 		} else {
-			gotETag, lastModified, versionID, err = o.uploadSinglepartPutObject(ctx, &req, size, in)
+			gotETag, lastModified, versionID, err = o.uploadSinglepartPutObject(ctx, req, size, in)
 		}
 	}
 	if err != nil {
@@ -5612,7 +5833,7 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	if o.fs.opt.NoHead && size >= 0 {
 		head = new(s3.HeadObjectOutput)
 		//structs.SetFrom(head, &req)
-		setFrom_s3HeadObjectOutput_s3PutObjectInput(head, &req)
+		setFrom_s3HeadObjectOutput_s3PutObjectInput(head, req)
 		head.ETag = &md5sumHex // doesn't matter quotes are missing
 		head.ContentLength = &size
 		// We get etag back from single and multipart upload so fill it in here
@@ -5751,16 +5972,18 @@ func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error)
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.Purger      = &Fs{}
-	_ fs.Copier      = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.ListRer     = &Fs{}
-	_ fs.Commander   = &Fs{}
-	_ fs.CleanUpper  = &Fs{}
-	_ fs.Object      = &Object{}
-	_ fs.MimeTyper   = &Object{}
-	_ fs.GetTierer   = &Object{}
-	_ fs.SetTierer   = &Object{}
-	_ fs.Metadataer  = &Object{}
+	_ fs.Fs              = &Fs{}
+	_ fs.Purger          = &Fs{}
+	_ fs.Copier          = &Fs{}
+	_ fs.PutStreamer     = &Fs{}
+	_ fs.OpenChunkWriter = &Fs{}
+	_ fs.ListRer         = &Fs{}
+	_ fs.Commander       = &Fs{}
+	_ fs.CleanUpper      = &Fs{}
+	_ fs.Object          = &Object{}
+	_ fs.MimeTyper       = &Object{}
+	_ fs.GetTierer       = &Object{}
+	_ fs.SetTierer       = &Object{}
+	_ fs.Metadataer      = &Object{}
+	_ fs.SetMetadataer   = &Object{}
 )
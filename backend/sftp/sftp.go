@@ -440,6 +440,8 @@ type Fs struct {
 	url          string
 	mkdirLock    *stringLock
 	cachedHashes *hash.Set
+	blockOnce    sync.Once // checks the block hash commands work
+	blockErr     error     // error from the block hash check
 	poolMu       sync.Mutex
 	pool         []*conn
 	drain        *time.Timer // used to drain the pool when we stop using the connections
@@ -1041,6 +1043,10 @@ func NewFsWithConnection(ctx context.Context, f *Fs, name string, root string, m
 		fs.Debugf(f, "Shell type %q detected (set option shell_type to override)", f.shellType)
 		f.m.Set("shell_type", f.shellType)
 	}
+	if f.shellType != defaultShellType {
+		// Block hashes need a unix shell
+		f.features.BlockHashes = nil
+	}
 	// Ensure we have absolute path to root
 	// It appears that WS FTP doesn't like relative paths,
 	// and the openssh sftp tool also uses absolute paths.
@@ -1626,6 +1632,106 @@ func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
 	return usage, nil
 }
 
+// objectWriterAt is a file open for random access writes on the SFTP
+// server
+type objectWriterAt struct {
+	*sftp.File
+	f *Fs
+}
+
+// Close the file
+func (file *objectWriterAt) Close() error {
+	err := file.File.Close()
+	// Show connection no longer in use
+	file.f.removeSession()
+	return err
+}
+
+// OpenWriterAt opens with a handle for random access writes
+//
+// Pass in the remote desired and the size if known.
+//
+// An existing file larger than size is truncated to size, or to 0 if
+// the size is unknown.
+func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+	err := f.mkParentDir(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("OpenWriterAt mkParentDir failed: %w", err)
+	}
+	c, err := f.getSftpConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("OpenWriterAt: %w", err)
+	}
+	file, err := c.sftpClient.OpenFile(f.remotePath(remote), os.O_WRONLY|os.O_CREATE)
+	f.putSftpConnection(&c, err)
+	if err != nil {
+		return nil, fmt.Errorf("OpenWriterAt failed: %w", err)
+	}
+	if size < 0 {
+		size = 0
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() > size {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("OpenWriterAt truncate failed: %w", err)
+	}
+	// Show connection in use
+	f.addSession()
+	return &objectWriterAt{File: file, f: f}, nil
+}
+
+// BlockHashes returns the MD5 hashes of each block of blockSize bytes
+// of remote, the last of which may be short.
+//
+// The hashes are calculated on the server by running dd and the MD5
+// command for each block so the data isn't transferred.
+func (f *Fs) BlockHashes(ctx context.Context, remote string, blockSize int64) ([]string, error) {
+	f.blockOnce.Do(func() {
+		if !f.Hashes().Contains(hash.MD5) {
+			f.blockErr = fmt.Errorf("block hashes need a working md5sum_command: %w", fs.ErrorNotImplemented)
+			return
+		}
+		outBytes, err := f.run(ctx, "dd if=/dev/null 2>/dev/null | "+f.opt.Md5sumCommand)
+		if err != nil || parseHash(bytes.TrimSpace(outBytes)) != "d41d8cd98f00b204e9800998ecf8427e" {
+			fs.Debugf(f, "Block hash command check failed: %v", err)
+			f.blockErr = fmt.Errorf("block hashes need dd on the server: %w", fs.ErrorNotImplemented)
+		}
+	})
+	if f.blockErr != nil {
+		return nil, f.blockErr
+	}
+	info, err := f.stat(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("block hashes: %w", err)
+	}
+	blocks := (info.Size() + blockSize - 1) / blockSize
+	shellPathArg, err := f.quoteOrEscapeShellPath(f.remoteShellPath(remote))
+	if err != nil {
+		return nil, fmt.Errorf("block hashes: %w", err)
+	}
+	cmd := fmt.Sprintf(`i=0; while [ $i -lt %d ]; do dd if=%s bs=%d skip=$i count=1 2>/dev/null | %s || exit 1; i=$((i+1)); done`,
+		blocks, shellPathArg, blockSize, f.opt.Md5sumCommand)
+	outBytes, err := f.run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate block hashes: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(outBytes)), "\n")
+	if blocks == 0 {
+		lines = nil
+	}
+	if int64(len(lines)) != blocks {
+		return nil, fmt.Errorf("failed to calculate block hashes: expecting %d hashes but got %d", blocks, len(lines))
+	}
+	hashes := make([]string, len(lines))
+	for i, line := range lines {
+		hashes[i] = parseHash([]byte(line))
+	}
+	return hashes, nil
+}
+
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
 func (f *Fs) Shutdown(ctx context.Context) error {
@@ -2052,11 +2158,13 @@ func (o *Object) Remove(ctx context.Context) error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.Mover       = &Fs{}
-	_ fs.DirMover    = &Fs{}
-	_ fs.Abouter     = &Fs{}
-	_ fs.Shutdowner  = &Fs{}
-	_ fs.Object      = &Object{}
+	_ fs.Fs             = &Fs{}
+	_ fs.PutStreamer    = &Fs{}
+	_ fs.Mover          = &Fs{}
+	_ fs.DirMover       = &Fs{}
+	_ fs.Abouter        = &Fs{}
+	_ fs.OpenWriterAter = &Fs{}
+	_ fs.BlockHasher    = &Fs{}
+	_ fs.Shutdowner     = &Fs{}
+	_ fs.Object         = &Object{}
 )
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	smb2 "github.com/hirochachacha/go-smb2"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
@@ -470,6 +471,77 @@ func (f *Fs) About(ctx context.Context) (_ *fs.Usage, err error) {
 	return usage, nil
 }
 
+// OpenWriterAt opens with a handle for random access writes
+//
+// Pass in the remote desired and the size if known.
+//
+// An existing file larger than size is truncated to size, or to 0 if
+// the size is unknown.
+func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (_ fs.WriterAtCloser, err error) {
+	share, filename := f.split(remote)
+	if share == "" || filename == "" {
+		return nil, fs.ErrorIsDir
+	}
+
+	err = f.ensureDirectory(ctx, share, filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make parent directories: %w", err)
+	}
+
+	filename = f.toSambaPath(filename)
+
+	f.addSession() // Show session in use
+	defer f.removeSession()
+
+	cn, err := f.getConnection(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+	fl, err := cn.smbShare.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		f.putConnection(&cn)
+		return nil, fmt.Errorf("failed to open: %w", err)
+	}
+	if size < 0 {
+		size = 0
+	}
+	stat, err := fl.Stat()
+	if err == nil && stat.Size() > size {
+		err = fl.Truncate(size)
+	}
+	if err != nil {
+		_ = fl.Close()
+		f.putConnection(&cn)
+		return nil, fmt.Errorf("failed to truncate: %w", err)
+	}
+
+	return &boundWriterAtCloser{
+		File: fl,
+		close: func() error {
+			f.putConnection(&cn)
+			return nil
+		},
+	}, nil
+}
+
+// BlockHashes returns the MD5 hashes of each block of blockSize bytes
+// of remote, the last of which may be short.
+//
+// SMB has no way of calculating hashes on the server so this reads
+// the file.
+func (f *Fs) BlockHashes(ctx context.Context, remote string, blockSize int64) (hashes []string, err error) {
+	o, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	return hash.StreamBlocks(in, blockSize)
+}
+
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
 func (f *Fs) Shutdown(ctx context.Context) error {
@@ -782,6 +854,20 @@ func (r *boundReadCloser) Close() error {
 	return err2
 }
 
+type boundWriterAtCloser struct {
+	*smb2.File
+	close func() error
+}
+
+func (w *boundWriterAtCloser) Close() error {
+	err1 := w.File.Close()
+	err2 := w.close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
 func translateError(e error, dir bool) error {
 	if os.IsNotExist(e) {
 		if dir {
@@ -794,12 +880,15 @@ func translateError(e error, dir bool) error {
 }
 
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.Mover       = &Fs{}
-	_ fs.DirMover    = &Fs{}
-	_ fs.Abouter     = &Fs{}
-	_ fs.Shutdowner  = &Fs{}
-	_ fs.Object      = &Object{}
-	_ io.ReadCloser  = &boundReadCloser{}
+	_ fs.Fs             = &Fs{}
+	_ fs.PutStreamer    = &Fs{}
+	_ fs.Mover          = &Fs{}
+	_ fs.DirMover       = &Fs{}
+	_ fs.Abouter        = &Fs{}
+	_ fs.OpenWriterAter = &Fs{}
+	_ fs.BlockHasher    = &Fs{}
+	_ fs.Shutdowner     = &Fs{}
+	_ fs.Object         = &Object{}
+	_ io.ReadCloser     = &boundReadCloser{}
+	_ fs.WriterAtCloser = &boundWriterAtCloser{}
 )
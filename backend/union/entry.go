@@ -139,6 +139,37 @@ func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
 	return errs.Err()
 }
 
+// SetMetadata sets the keys in metadata on the object leaving any
+// other metadata alone
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	entries, err := o.fs.actionEntries(o.candidates()...)
+	if err != nil {
+		return err
+	}
+	// Don't set the metadata on some upstreams only
+	for _, e := range entries {
+		if o, ok := e.(*upstream.Object); ok {
+			if _, ok := o.Object.(fs.SetMetadataer); !ok {
+				return fs.ErrorNotImplemented
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	errs := Errors(make([]error, len(entries)))
+	multithread(len(entries), func(i int) {
+		if o, ok := entries[i].(*upstream.Object); ok {
+			err := o.SetMetadata(ctx, metadata)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", o.UpstreamFs().Name(), err)
+			}
+		} else {
+			errs[i] = fs.ErrorNotAFile
+		}
+	})
+	wg.Wait()
+	return errs.Err()
+}
+
 // GetTier returns storage tier or class of the Object
 func (o *Object) GetTier() string {
 	do, ok := o.Object.Object.(fs.GetTierer)
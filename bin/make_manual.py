@@ -31,15 +31,18 @@ docs = [
     "alias.md",
     "amazonclouddrive.md",
     "s3.md",
+    "archive.md",
     "b2.md",
     "box.md",
     "cache.md",
+    "cdc.md",
     "chunker.md",
     "sharefile.md",
     "crypt.md",
     "compress.md",
     "combine.md",
     "dropbox.md",
+    "erasure.md",
     "filefabric.md",
     "ftp.md",
     "googlecloudstorage.md",
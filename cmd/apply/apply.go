@@ -0,0 +1,58 @@
+// Package apply provides the apply command.
+package apply
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "apply plan.json",
+	Short: `Carry out a plan made with --plan-out.`,
+	Long: `
+Carry out the copies, moves, deletions, modification time updates and
+directory changes in a plan written by ` + "`sync`, `copy` or `move`" + ` with
+the ` + "`--plan-out`" + ` flag.
+
+    rclone sync /path/to/local remote:backup --plan-out plan.json
+    rclone apply plan.json
+
+This makes it possible to review what a sync will do, for example to
+approve the files it deletes, before doing it.
+
+Before doing anything rclone checks that none of the files the plan
+copies, moves or deletes, nor the files at the destination they
+replace, have changed since the plan was made, by comparing their
+size, modification time and hash if available. If any have changed,
+been created or no longer exist, rclone refuses to apply the plan and
+a new plan should be made.
+
+Directories are made first, then files are moved, then copied, then
+deleted and finally directories are removed. Filter flags are not
+applied as the plan lists exactly the files to act on.
+
+The plan is applied once and ` + "`--retries`" + ` is ignored, as a second
+attempt would find the files the first one acted on changed. If
+applying the plan fails part way, make a new plan and apply that.
+`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		cmd.Run(false, true, command, func() error {
+			plan, err := operations.LoadPlan(args[0])
+			if err != nil {
+				return err
+			}
+			return operations.ApplyPlan(context.Background(), plan)
+		})
+	},
+}
@@ -0,0 +1,128 @@
+// Package archive provides the archive command.
+package archive
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd.Root.AddCommand(Command)
+	Command.AddCommand(createCommand)
+	Command.AddCommand(extractCommand)
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "archive <action> [opts] <source> <destination>",
+	Short: `Create and extract archives on remotes.`,
+	Long: `Create archives from and extract archives to any remote. Requires
+the use of a subcommand to specify the action, e.g.
+
+    rclone archive create remote:dir remote2:backup.tar.zst
+    rclone archive extract remote2:backup.tar.zst remote3:dir
+
+The archives are streamed to and from the remotes so no local copy
+of the files or the archive is needed.
+
+Each subcommand has its own options which you can see in their help.
+`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+}
+
+// Format is a type of archive
+type Format int
+
+// Archive formats
+const (
+	FormatNone Format = iota
+	FormatZip
+	FormatTar
+	FormatTarGz
+	FormatTarZst
+)
+
+// formats are the names of the archive formats with their alternative
+// names and extensions
+var formats = []struct {
+	format Format
+	names  []string
+}{
+	{FormatZip, []string{"zip"}},
+	{FormatTar, []string{"tar"}},
+	{FormatTarGz, []string{"tar.gz", "tgz"}},
+	{FormatTarZst, []string{"tar.zst", "tzst"}},
+}
+
+// String returns the name of the format
+func (format Format) String() string {
+	for _, x := range formats {
+		if x.format == format {
+			return x.names[0]
+		}
+	}
+	return "none"
+}
+
+// ParseFormat returns the Format called name
+func ParseFormat(name string) (Format, error) {
+	name = strings.TrimPrefix(strings.ToLower(name), ".")
+	for _, x := range formats {
+		for _, formatName := range x.names {
+			if name == formatName {
+				return x.format, nil
+			}
+		}
+	}
+	return FormatNone, fmt.Errorf("unknown archive format %q - must be one of zip, tar, tar.gz or tar.zst", name)
+}
+
+// FormatFromName returns the Format of the archive file name from its
+// extension or FormatNone if it isn't recognised
+func FormatFromName(name string) Format {
+	lower := strings.ToLower(path.Base(name))
+	for _, x := range formats {
+		for _, formatName := range x.names {
+			ext := "." + formatName
+			if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+				return x.format
+			}
+		}
+	}
+	return FormatNone
+}
+
+// formatFor returns the format of the archive name, using formatName
+// if set
+func formatFor(formatName, name string) (Format, error) {
+	if formatName != "" {
+		return ParseFormat(formatName)
+	}
+	format := FormatFromName(name)
+	if format == FormatNone {
+		return FormatNone, fmt.Errorf("can't tell the archive format of %q from its extension - use --format", name)
+	}
+	return format, nil
+}
+
+// metadataPrefix is the prefix for the PAX records used to store the
+// metadata of files in tar archives
+const metadataPrefix = "RCLONE.meta."
+
+// cleanName cleans the name of an archive member so it can be used
+// as a remote, returning "" if it should be ignored.
+func cleanName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return ""
+		}
+	}
+	return path.Clean("/" + name)[1:]
+}
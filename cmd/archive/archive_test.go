@@ -0,0 +1,222 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want Format
+	}{
+		{"zip", FormatZip},
+		{".TAR", FormatTar},
+		{"tgz", FormatTarGz},
+		{"tar.zst", FormatTarZst},
+		{"7z", FormatNone},
+	} {
+		got, err := ParseFormat(test.name)
+		assert.Equal(t, test.want, got, test.name)
+		assert.Equal(t, test.want == FormatNone, err != nil, test.name)
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want Format
+	}{
+		{"dir/backup.zip", FormatZip},
+		{"backup.tar", FormatTar},
+		{"backup.TAR.GZ", FormatTarGz},
+		{"backup.tzst", FormatTarZst},
+		{"backup.gz", FormatNone},
+		{".zip", FormatNone},
+	} {
+		assert.Equal(t, test.want, FormatFromName(test.name), test.name)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"file", "file"},
+		{"./dir/file", "dir/file"},
+		{"/dir//file", "dir/file"},
+		{"dir\\file", "dir/file"},
+		{"dir/", "dir"},
+		{"../file", ""},
+		{"dir/../../file", ""},
+		{".", ""},
+	} {
+		assert.Equal(t, test.want, cleanName(test.name), test.name)
+	}
+}
+
+var (
+	t1 = time.Date(2023, 5, 1, 10, 20, 30, 0, time.UTC)
+	t2 = time.Date(2022, 1, 2, 3, 4, 6, 0, time.UTC)
+)
+
+// testFiles are the files in the source directory
+var testFiles = map[string]struct {
+	contents string
+	modTime  time.Time
+}{
+	"file1.txt":             {"hello world", t1},
+	"dir/file2.txt":         {strings.Repeat("potato ", 10000), t2},
+	"dir/sub dir/empty.txt": {"", t1},
+}
+
+// makeSource makes the source directory returning an Fs for it
+func makeSource(t *testing.T) fs.Fs {
+	dir := t.TempDir()
+	for name, file := range testFiles {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0777))
+		require.NoError(t, os.WriteFile(p, []byte(file.contents), 0600))
+		require.NoError(t, os.Chtimes(p, file.modTime, file.modTime))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty dir"), 0777))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	return f
+}
+
+// checkExtracted checks dir contains the test files
+func checkExtracted(t *testing.T, dir string) {
+	for name, file := range testFiles {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		data, err := os.ReadFile(p)
+		require.NoError(t, err, name)
+		assert.Equal(t, file.contents, string(data), name)
+		fi, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.True(t, file.modTime.Equal(fi.ModTime()), "%s: want %v got %v", name, file.modTime, fi.ModTime())
+	}
+	fi, err := os.Stat(filepath.Join(dir, "empty dir"))
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+}
+
+func TestCreateExtract(t *testing.T) {
+	fstest.Initialise()
+	ctx := context.Background()
+	fsrc := makeSource(t)
+	for _, format := range []Format{FormatZip, FormatTar, FormatTarGz, FormatTarZst} {
+		t.Run(format.String(), func(t *testing.T) {
+			// Put the archive in the source to check it isn't added to itself
+			name := "backup." + format.String()
+			require.NoError(t, Create(ctx, fsrc, fsrc, name, format))
+			archive, err := fsrc.NewObject(ctx, name)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, archive.Remove(ctx))
+			}()
+
+			dst := t.TempDir()
+			fdst, err := fs.NewFs(ctx, dst)
+			require.NoError(t, err)
+			require.NoError(t, Extract(ctx, archive, format, fdst))
+			checkExtracted(t, dst)
+			_, err = os.Stat(filepath.Join(dst, name))
+			assert.True(t, os.IsNotExist(err), "archive added to itself")
+		})
+	}
+}
+
+func TestCreateExtractMetadata(t *testing.T) {
+	fstest.Initialise()
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.Metadata = true
+	fsrc := makeSource(t)
+
+	require.NoError(t, Create(ctx, fsrc, fsrc, "backup.tar", FormatTar))
+	archive, err := fsrc.NewObject(ctx, "backup.tar")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, archive.Remove(ctx))
+	}()
+
+	dst := t.TempDir()
+	fdst, err := fs.NewFs(ctx, dst)
+	require.NoError(t, err)
+	require.NoError(t, Extract(ctx, archive, FormatTar, fdst))
+	checkExtracted(t, dst)
+	fi, err := os.Stat(filepath.Join(dst, "file1.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+}
+
+func TestExtractBadNames(t *testing.T) {
+	fstest.Initialise()
+	ctx := context.Background()
+	src := t.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"../outside.txt", "dir/../../outside.txt", "inside.txt"} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(src, "bad.zip"), buf.Bytes(), 0600))
+	fsrc, err := fs.NewFs(ctx, src)
+	require.NoError(t, err)
+	archive, err := fsrc.NewObject(ctx, "bad.zip")
+	require.NoError(t, err)
+
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "dst")
+	fdst, err := fs.NewFs(ctx, dst)
+	require.NoError(t, err)
+	require.NoError(t, Extract(ctx, archive, FormatZip, fdst))
+	_, err = os.Stat(filepath.Join(dst, "inside.txt"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(parent, "outside.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStreamReaderAt(t *testing.T) {
+	fstest.Initialise()
+	ctx := context.Background()
+	fsrc := makeSource(t)
+	require.NoError(t, Create(ctx, fsrc, fsrc, "backup.zip", FormatZip))
+	archive, err := fsrc.NewObject(ctx, "backup.zip")
+	require.NoError(t, err)
+
+	ra := &streamReaderAt{ctx: ctx, o: archive, maxTries: 1}
+	defer func() {
+		require.NoError(t, ra.Close())
+	}()
+	zr, err := zip.NewReader(ra, archive.Size())
+	require.NoError(t, err)
+	opens := ra.opens
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		var data bytes.Buffer
+		_, err = data.ReadFrom(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, testFiles[f.Name].contents, data.String(), f.Name)
+	}
+	// Reading the members in order should only need one more stream
+	assert.Equal(t, opens+1, ra.opens)
+}
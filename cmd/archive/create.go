@@ -0,0 +1,317 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/spf13/cobra"
+)
+
+var (
+	createFormat = ""
+)
+
+func init() {
+	cmdFlags := createCommand.Flags()
+	flags.StringVarP(cmdFlags, &createFormat, "format", "", createFormat, "Archive format: zip, tar, tar.gz or tar.zst (default from the extension)")
+}
+
+var createCommand = &cobra.Command{
+	Use:   "create source:path dest:path/archive",
+	Short: `Create an archive of source:path at dest:path/archive.`,
+	Long: `
+Create an archive containing the files and directories in source:path
+and upload it to dest:path/archive.
+
+    rclone archive create remote:dir remote2:backups/dir.tar.zst
+
+The archive is streamed directly to the destination as it is made so
+no local copy is needed. This means it is uploaded as if with
+` + "`rclone rcat`" + `, so see its help for what that means for the
+remote and retries.
+
+The format is chosen from the extension of the archive, or with
+` + "`--format`" + `:
+
+- ` + "`zip`" + ` - files are compressed with deflate
+- ` + "`tar`" + ` - uncompressed tar
+- ` + "`tar.gz`" + ` or ` + "`tgz`" + ` - tar compressed with gzip
+- ` + "`tar.zst`" + ` or ` + "`tzst`" + ` - tar compressed with zstd
+
+Files are added in name order with their modification times. Use the
+filter flags to choose which files are added. If the archive is
+inside source:path it won't be added to itself.
+
+With ` + "`--metadata`/`-M`" + ` the metadata of each file is stored in tar
+archives as PAX records and restored by ` + "`rclone archive extract -M`" + `.
+Zip archives don't store metadata.
+
+Files whose size isn't known in advance can't be added to tar
+archives and are skipped with an error.
+`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc := cmd.NewFsSrc(args[:1])
+		fdst, dstFileName := cmd.NewFsDstFile(args[1:])
+		format, err := formatFor(createFormat, dstFileName)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cmd.Run(false, true, command, func() error {
+			return Create(context.Background(), fsrc, fdst, dstFileName, format)
+		})
+	},
+}
+
+// Create makes an archive in format of the files in fsrc and uploads
+// it to dstFileName in fdst.
+func Create(ctx context.Context, fsrc fs.Fs, fdst fs.Fs, dstFileName string, format Format) error {
+	entries, err := listSource(ctx, fsrc, fdst, dstFileName)
+	if err != nil {
+		return err
+	}
+	if operations.SkipDestructive(ctx, dstFileName, fmt.Sprintf("create %v archive of %d entries", format, len(entries))) {
+		return nil
+	}
+	pr, pw := io.Pipe()
+	var wg sync.WaitGroup
+	var writeErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writeErr = writeArchive(ctx, pw, entries, format)
+		_ = pw.CloseWithError(writeErr)
+	}()
+	_, err = operations.Rcat(ctx, fdst, dstFileName, pr, time.Now(), nil)
+	// Stop the writer if the upload failed
+	_ = pr.CloseWithError(err)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to create archive: %w", writeErr)
+	}
+	return nil
+}
+
+// listSource returns the entries in fsrc in name order, leaving out
+// the archive dstFileName in fdst.
+func listSource(ctx context.Context, fsrc fs.Fs, fdst fs.Fs, dstFileName string) (entries fs.DirEntries, err error) {
+	ci := fs.GetConfig(ctx)
+	dstPath := path.Join(fdst.Root(), dstFileName)
+	var mu sync.Mutex
+	err = walk.ListR(ctx, fsrc, "", false, ci.MaxDepth, walk.ListAll, func(newEntries fs.DirEntries) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, entry := range newEntries {
+			if operations.SameConfig(fsrc, fdst) && path.Join(fsrc.Root(), entry.Remote()) == dstPath {
+				fs.Debugf(entry, "Not adding archive to itself")
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Remote() < entries[j].Remote()
+	})
+	return entries, nil
+}
+
+// archiveWriter writes the members of an archive
+type archiveWriter interface {
+	// addDir adds the directory d
+	addDir(ctx context.Context, d fs.Directory) error
+	// addObject adds the object o reading its contents from in
+	addObject(ctx context.Context, o fs.Object, in io.Reader) error
+	// Close finishes the archive
+	Close() error
+}
+
+// writeArchive writes an archive in format of entries to out
+func writeArchive(ctx context.Context, out io.Writer, entries fs.DirEntries, format Format) (err error) {
+	var aw archiveWriter
+	switch format {
+	case FormatZip:
+		aw = &zipWriter{zw: zip.NewWriter(out)}
+	case FormatTar:
+		aw = &tarWriter{tw: tar.NewWriter(out)}
+	case FormatTarGz:
+		gw := gzip.NewWriter(out)
+		aw = &tarWriter{tw: tar.NewWriter(gw), compressor: gw}
+	case FormatTarZst:
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return err
+		}
+		aw = &tarWriter{tw: tar.NewWriter(zw), compressor: zw}
+	default:
+		return fmt.Errorf("can't write %v archives", format)
+	}
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Directory:
+			err = aw.addDir(ctx, x)
+		case fs.Object:
+			err = addObject(ctx, aw, x)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// errSkip is returned by archiveWriter.addObject for files which
+// can't be added to the archive
+var errSkip = errors.New("skipping file")
+
+// addObject adds o to the archive
+func addObject(ctx context.Context, aw archiveWriter, o fs.Object) (err error) {
+	ci := fs.GetConfig(ctx)
+	fs.Debugf(o, "Adding to archive")
+	in, err := operations.NewReOpen(ctx, o, ci.LowLevelRetries)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", o.Remote(), err)
+	}
+	defer fs.CheckClose(in, &err)
+	err = aw.addObject(ctx, o, in)
+	if errors.Is(err, errSkip) {
+		err = fs.CountError(err)
+		fs.Errorf(o, "%v", err)
+		return nil
+	}
+	return err
+}
+
+// zipWriter writes zip archives
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+// addDir adds the directory d
+func (w *zipWriter) addDir(ctx context.Context, d fs.Directory) error {
+	hdr := &zip.FileHeader{
+		Name:     d.Remote() + "/",
+		Method:   zip.Store,
+		Modified: d.ModTime(ctx),
+	}
+	hdr.SetMode(os.ModeDir | 0755)
+	_, err := w.zw.CreateHeader(hdr)
+	return err
+}
+
+// addObject adds the object o reading its contents from in
+func (w *zipWriter) addObject(ctx context.Context, o fs.Object, in io.Reader) error {
+	hdr := &zip.FileHeader{
+		Name:     o.Remote(),
+		Method:   zip.Deflate,
+		Modified: o.ModTime(ctx),
+	}
+	hdr.SetMode(0644)
+	fw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, in)
+	return err
+}
+
+// Close finishes the archive
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// tarWriter writes tar archives which may be compressed
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser // if set, closed after tw
+}
+
+// addDir adds the directory d
+func (w *tarWriter) addDir(ctx context.Context, d fs.Directory) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     d.Remote() + "/",
+		Mode:     0755,
+		ModTime:  d.ModTime(ctx),
+		Format:   tar.FormatPAX,
+	})
+}
+
+// addObject adds the object o reading its contents from in
+//
+// The metadata of o is stored as PAX records.
+func (w *tarWriter) addObject(ctx context.Context, o fs.Object, in io.Reader) error {
+	if o.Size() < 0 {
+		return fmt.Errorf("can't add file of unknown size to tar archive: %w", errSkip)
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     o.Remote(),
+		Size:     o.Size(),
+		Mode:     0644,
+		ModTime:  o.ModTime(ctx),
+		Format:   tar.FormatPAX,
+	}
+	metadata, err := fs.GetMetadataOptions(ctx, o, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata of %q: %w", o.Remote(), err)
+	}
+	if len(metadata) > 0 {
+		hdr.PAXRecords = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			hdr.PAXRecords[metadataPrefix+k] = v
+		}
+		if mode, err := strconv.ParseInt(metadata["mode"], 8, 64); err == nil {
+			hdr.Mode = mode & 07777
+		}
+	}
+	err = w.tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w.tw, in)
+	if err != nil {
+		return fmt.Errorf("failed to add %q: %w", o.Remote(), err)
+	}
+	// Checks all of the file was written
+	return w.tw.Flush()
+}
+
+// Close finishes the archive
+func (w *tarWriter) Close() error {
+	err := w.tw.Close()
+	if err != nil {
+		return err
+	}
+	if w.compressor != nil {
+		return w.compressor.Close()
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	extractFormat = ""
+)
+
+func init() {
+	cmdFlags := extractCommand.Flags()
+	flags.StringVarP(cmdFlags, &extractFormat, "format", "", extractFormat, "Archive format: zip, tar, tar.gz or tar.zst (default from the extension)")
+}
+
+var extractCommand = &cobra.Command{
+	Use:   "extract source:path/archive dest:path",
+	Short: `Extract the archive at source:path/archive to dest:path.`,
+	Long: `
+Extract the files in the archive at source:path/archive and upload
+each of them to dest:path.
+
+    rclone archive extract remote2:backups/dir.tar.zst remote3:dir
+
+The archive is read once from start to finish and the files are
+uploaded as they are read so no local copy is needed. Existing files
+in dest:path are overwritten.
+
+The format is chosen from the extension of the archive, or with
+` + "`--format`" + `. See ` + "`rclone archive create`" + ` for the formats supported.
+Zip members compressed with store, deflate, bzip2 or zstd can be
+extracted, but encrypted members can't.
+
+The modification times of the files are set from the archive. With
+` + "`--metadata`/`-M`" + ` the metadata stored by ` + "`rclone archive create -M`" + `
+is restored too.
+
+Use the filter flags to choose which files are extracted. Symlinks,
+devices and other special files in tar archives are skipped, as are
+files with names which would be outside dest:path.
+`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc, srcFileName := cmd.NewFsFile(args[0])
+		if srcFileName == "" {
+			log.Fatalf("%q must be the path to an archive file", args[0])
+		}
+		fdst := cmd.NewFsDir(args[1:])
+		format, err := formatFor(extractFormat, srcFileName)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cmd.Run(false, true, command, func() error {
+			ctx := context.Background()
+			src, err := fsrc.NewObject(ctx, srcFileName)
+			if err != nil {
+				return err
+			}
+			return Extract(ctx, src, format, fdst)
+		})
+	},
+}
+
+// Extract uploads the members of the archive src in format to fdst.
+//
+// Errors uploading members are counted and logged and the last one
+// returned after the rest of the archive has been extracted.
+func Extract(ctx context.Context, src fs.Object, format Format, fdst fs.Fs) error {
+	x := &extractor{
+		ctx:  ctx,
+		ci:   fs.GetConfig(ctx),
+		fi:   filter.GetConfig(ctx),
+		fdst: fdst,
+	}
+	var err error
+	switch format {
+	case FormatZip:
+		err = x.extractZip(src)
+	case FormatTar, FormatTarGz, FormatTarZst:
+		err = x.extractTar(src, format)
+	default:
+		err = fmt.Errorf("can't extract %v archives", format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %q: %w", src.Remote(), err)
+	}
+	return x.err
+}
+
+// extractor uploads the members of an archive
+type extractor struct {
+	ctx  context.Context
+	ci   *fs.ConfigInfo
+	fi   *filter.Filter
+	fdst fs.Fs
+	err  error // last error uploading a member
+}
+
+// mkdir makes the directory name in the destination
+func (x *extractor) mkdir(name string) {
+	name = cleanName(name)
+	if name == "" || !x.fi.IncludeRemote(name+"/") {
+		return
+	}
+	err := operations.Mkdir(x.ctx, x.fdst, name)
+	if err != nil {
+		x.err = fs.CountError(err)
+		fs.Errorf(name, "Failed to make directory: %v", err)
+	}
+}
+
+// upload uploads the member name of size reading it from in
+func (x *extractor) upload(name string, in io.Reader, size int64, modTime time.Time, metadata fs.Metadata) {
+	remote := cleanName(name)
+	if remote == "" {
+		fs.Logf(nil, "Skipping archive member with bad name %q", name)
+		return
+	}
+	if !x.fi.Include(remote, size, modTime, metadata) {
+		fs.Debugf(remote, "Excluded from extract")
+		return
+	}
+	if !x.ci.Metadata {
+		metadata = nil
+	}
+	_, err := operations.RcatSize(x.ctx, x.fdst, remote, io.NopCloser(in), size, modTime, metadata)
+	if err != nil {
+		x.err = fs.CountError(err)
+		fs.Errorf(remote, "Failed to extract: %v", err)
+	}
+}
+
+// extractTar uploads the members of the tar archive src
+//
+// The archive is read from start to finish in one stream.
+func (x *extractor) extractTar(src fs.Object, format Format) (err error) {
+	in, err := operations.NewReOpen(x.ctx, src, x.ci.LowLevelRetries)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	var r io.Reader = in
+	switch format {
+	case FormatTarGz:
+		gr, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer fs.CheckClose(gr, &err)
+		r = gr
+	case FormatTarZst:
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			x.mkdir(hdr.Name)
+		case tar.TypeReg, '\x00':
+			x.upload(hdr.Name, tr, hdr.Size, hdr.ModTime, tarMetadata(hdr))
+		default:
+			fs.Logf(nil, "Skipping tar member %q of type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// tarMetadata returns the metadata stored in the PAX records of hdr
+func tarMetadata(hdr *tar.Header) (metadata fs.Metadata) {
+	for k, v := range hdr.PAXRecords {
+		if !strings.HasPrefix(k, metadataPrefix) {
+			continue
+		}
+		if metadata == nil {
+			metadata = fs.Metadata{}
+		}
+		metadata[k[len(metadataPrefix):]] = v
+	}
+	return metadata
+}
+
+// extractZip uploads the members of the zip archive src
+//
+// The members are read in the order of the central directory which
+// is usually the order they are stored in, so the archive is read in
+// very few streams.
+func (x *extractor) extractZip(src fs.Object) (err error) {
+	ra := &streamReaderAt{ctx: x.ctx, o: src, maxTries: x.ci.LowLevelRetries}
+	defer fs.CheckClose(ra, &err)
+	zr, err := zip.NewReader(ra, src.Size())
+	if err != nil {
+		return err
+	}
+	zr.RegisterDecompressor(12, func(r io.Reader) io.ReadCloser {
+		return io.NopCloser(bzip2.NewReader(r))
+	})
+	zr.RegisterDecompressor(zstd.ZipMethodWinZip, zstd.ZipDecompressor())
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			x.mkdir(f.Name)
+			continue
+		}
+		if f.Flags&0x1 != 0 {
+			x.err = fs.CountError(errors.New("encrypted zip members aren't supported"))
+			fs.Errorf(f.Name, "Failed to extract: %v", x.err)
+			continue
+		}
+		err := x.uploadZipMember(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadZipMember uploads the zip member f
+func (x *extractor) uploadZipMember(f *zip.File) (err error) {
+	in, err := f.Open()
+	if err != nil {
+		if errors.Is(err, zip.ErrAlgorithm) {
+			x.err = fs.CountError(err)
+			fs.Errorf(f.Name, "Failed to extract: %v", err)
+			return nil
+		}
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	x.upload(f.Name, in, int64(f.UncompressedSize64), f.Modified, nil)
+	return nil
+}
+
+// If a read is less than this far past the end of the last one then
+// streamReaderAt skips over the gap rather than opening the object
+// again.
+const maxSkip = 64 * 1024
+
+// streamReaderAt implements io.ReaderAt by reading the object o from
+// the offset of a read to its end, only opening it again if a read
+// isn't just after the last one.
+//
+// This means reading an archive mostly in order needs very few
+// requests.
+type streamReaderAt struct {
+	ctx      context.Context
+	o        fs.Object
+	maxTries int
+	in       io.ReadCloser // current stream or nil
+	pos      int64         // offset in the object of in
+	opens    int           // number of times the object was opened
+}
+
+// ReadAt reads len(p) bytes at off
+func (r *streamReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if r.in != nil && off >= r.pos && off-r.pos <= maxSkip {
+		skipped, err := io.CopyN(io.Discard, r.in, off-r.pos)
+		r.pos += skipped
+		if err != nil {
+			_ = r.Close()
+		}
+	}
+	if r.in == nil || off != r.pos {
+		_ = r.Close()
+		r.in, err = operations.NewReOpen(r.ctx, r.o, r.maxTries, &fs.RangeOption{Start: off, End: -1})
+		if err != nil {
+			return 0, err
+		}
+		r.pos = off
+		r.opens++
+	}
+	n, err = io.ReadFull(r.in, p)
+	r.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close closes the current stream if any
+func (r *streamReaderAt) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
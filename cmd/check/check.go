@@ -27,6 +27,7 @@ var (
 	match             = ""
 	differ            = ""
 	errFile           = ""
+	moved             = ""
 	checkFileHashType = ""
 )
 
@@ -47,6 +48,7 @@ func AddFlags(cmdFlags *pflag.FlagSet) {
 	flags.StringVarP(cmdFlags, &match, "match", "", match, "Report all matching files to this file")
 	flags.StringVarP(cmdFlags, &differ, "differ", "", differ, "Report all non-matching files to this file")
 	flags.StringVarP(cmdFlags, &errFile, "error", "", errFile, "Report all files with errors (hashing or reading) to this file")
+	flags.StringVarP(cmdFlags, &moved, "moved", "", moved, "Report all files moved or renamed to this file")
 }
 
 // FlagsHelp describes the flags for the help
@@ -57,8 +59,8 @@ the source match the files in the destination, not the other way
 around. This means that extra files in the destination that are not in
 the source will not be detected.
 
-The |--differ|, |--missing-on-dst|, |--missing-on-src|, |--match|
-and |--error| flags write paths, one per line, to the file name (or
+The |--differ|, |--missing-on-dst|, |--missing-on-src|, |--match|,
+|--error| and |--moved| flags write paths, one per line, to the file name (or
 stdout if it is |-|) supplied. What they write is described in the
 help below. For example |--differ| will write all paths which are
 present on both the source and destination but different.
@@ -72,6 +74,15 @@ you what happened to it. These are reminiscent of diff files.
 - |+ path| means path was missing on the destination, so only in the source
 - |* path| means path was present in source and destination but different.
 - |! path| means there was an error reading or hashing the source or dest.
+- |> old -> new| means the file at old in the destination was found at new in the source.
+
+If you supply the |--moved| flag or the global |--track-renames| flag
+then files which are only in the source are matched against files
+which are only in the destination using the
+[--track-renames-strategy](/docs/#track-renames-strategy-hash-modtime-leaf-size).
+Files which match are reported as moved with the path in the
+destination and the path in the source as |old -> new|, rather than
+as missing from both.
 
 The default number of parallel checks is 8. See the [--checkers=N](/docs/#checkers-n)
 option for more information.
@@ -122,6 +133,9 @@ func GetCheckOpt(fsrc, fdst fs.Fs) (opt *operations.CheckOpt, close func(), err
 	if err = open(errFile, &opt.Error); err != nil {
 		return nil, nil, err
 	}
+	if err = open(moved, &opt.Moved); err != nil {
+		return nil, nil, err
+	}
 
 	close = func() {
 		for _, closer := range closers {
@@ -499,7 +499,7 @@ func resolveExitCode(err error) {
 		os.Exit(exitcode.FileNotFound)
 	case errors.Is(err, errorUncategorized):
 		os.Exit(exitcode.UncategorizedError)
-	case errors.Is(err, accounting.ErrorMaxTransferLimitReached):
+	case errors.Is(err, accounting.ErrorMaxTransferLimitReached), errors.Is(err, accounting.ErrorQuotaExceeded):
 		os.Exit(exitcode.TransferExceeded)
 	case fserrors.ShouldRetry(err):
 		os.Exit(exitcode.RetryError)
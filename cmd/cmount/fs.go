@@ -612,6 +612,8 @@ func translateError(err error) (errc int) {
 		return -fuse.ENOSYS
 	case vfs.EINVAL:
 		return -fuse.EINVAL
+	case vfs.ENOSPC:
+		return -fuse.ENOSPC
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return -fuse.EIO
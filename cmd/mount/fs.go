@@ -101,6 +101,8 @@ func translateError(err error) error {
 		return syscall.ENOSYS
 	case vfs.EINVAL:
 		return fuse.Errno(syscall.EINVAL)
+	case vfs.ENOSPC:
+		return fuse.Errno(syscall.ENOSPC)
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return err
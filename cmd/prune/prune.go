@@ -0,0 +1,63 @@
+// Package prune provides the prune command.
+package prune
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	opt = operations.PruneOpt{}
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+	cmdFlags := commandDefinition.Flags()
+	flags.IntVarP(cmdFlags, &opt.KeepLast, "keep-last", "", opt.KeepLast, "Keep the newest N snapshots")
+	flags.IntVarP(cmdFlags, &opt.KeepDaily, "keep-daily", "", opt.KeepDaily, "Keep the newest snapshot of each of the last N days")
+	flags.IntVarP(cmdFlags, &opt.KeepWeekly, "keep-weekly", "", opt.KeepWeekly, "Keep the newest snapshot of each of the last N weeks")
+	flags.IntVarP(cmdFlags, &opt.KeepMonthly, "keep-monthly", "", opt.KeepMonthly, "Keep the newest snapshot of each of the last N months")
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "prune remote:path",
+	Short: `Remove old snapshots made with --backup-snapshot.`,
+	Long: `
+Remove the snapshot directories in remote:path made by
+` + "`--backup-dir remote:path --backup-snapshot`" + ` which aren't selected by
+the retention policy.
+
+A snapshot is kept if any of the ` + "`--keep-*`" + ` flags selects it.
+
+- ` + "`--keep-last N`" + ` keeps the newest N snapshots.
+- ` + "`--keep-daily N`" + ` keeps the newest snapshot of each of the last N days which have snapshots.
+- ` + "`--keep-weekly N`" + ` keeps the newest snapshot of each of the last N weeks which have snapshots.
+- ` + "`--keep-monthly N`" + ` keeps the newest snapshot of each of the last N months which have snapshots.
+
+Days, weeks and months are calculated in UTC and weeks start on Monday.
+At least one of the flags must be supplied. Directories whose names
+aren't snapshot times are left alone.
+
+For example to keep a week of nightly snapshots, then one a week for a
+month, then one a month for a year use
+
+    rclone prune remote:snapshots --keep-daily 7 --keep-weekly 4 --keep-monthly 12
+
+**Important**: Since this can cause data loss, test first with the
+` + "`--dry-run` or the `--interactive`/`-i`" + ` flag.
+`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		fdst := cmd.NewFsDir(args)
+		cmd.Run(true, false, command, func() error {
+			return operations.PruneSnapshots(context.Background(), fdst, &opt)
+		})
+	},
+}
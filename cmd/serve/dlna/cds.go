@@ -1,10 +1,12 @@
 package dlna
 
 import (
+	"bytes"
 	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -13,6 +15,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/anacrolix/dms/dlna"
 	"github.com/anacrolix/dms/upnp"
@@ -34,7 +38,9 @@ var mediaMimeTypeRegexp = regexp.MustCompile("^(video|audio|image)/")
 
 // Turns the given entry and DMS host into a UPnP object. A nil object is
 // returned if the entry is not of interest.
-func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fileInfo vfs.Node, resources vfs.Nodes, host string) (ret interface{}, err error) {
+//
+// cover is the cover art image next to the entry or nil if there isn't one.
+func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fileInfo vfs.Node, resources vfs.Nodes, cover vfs.Node, host string) (ret interface{}, err error) {
 	obj := upnpav.Object{
 		ID:         cdsObject.ID(),
 		Restricted: 1,
@@ -55,6 +61,16 @@ func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fi
 		return
 	}
 
+	if isPlaylist(fileInfo) {
+		defaultChildCount := 1
+		obj.Class = "object.container.playlistContainer"
+		obj.Title, _ = splitExt(fileInfo.Name())
+		return upnpav.Container{
+			Object:     obj,
+			ChildCount: &defaultChildCount,
+		}, nil
+	}
+
 	// Read the mime type from the fs.Object if possible,
 	// otherwise fall back to working out what it is from the file path.
 	var mimeType string
@@ -102,6 +118,36 @@ func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fi
 		})
 	}
 
+	if mediaType[1] != "image" {
+		var coverURL, coverMimeType string
+		if cover != nil {
+			coverURL = (&url.URL{
+				Scheme: "http",
+				Host:   host,
+				Path:   path.Join(resPath, cover.Path()),
+			}).String()
+			coverMimeType = fs.MimeTypeFromName(cover.Name())
+		} else if mimeType := cds.embeddedCoverType(fileInfo); mimeType != "" {
+			coverURL = (&url.URL{
+				Scheme: "http",
+				Host:   host,
+				Path:   path.Join(coverPath, cdsObject.Path),
+			}).String()
+			coverMimeType = mimeType
+		}
+		if coverURL != "" {
+			profile := coverProfile(coverMimeType)
+			item.AlbumArtURI = &upnpav.AlbumArtURI{
+				ProfileID: profile,
+				URL:       coverURL,
+			}
+			item.Res = append(item.Res, upnpav.Resource{
+				URL:          coverURL,
+				ProtocolInfo: fmt.Sprintf("http-get:*:%s:DLNA.ORG_PN=%s", coverMimeType, profile),
+			})
+		}
+	}
+
 	ret = item
 	return
 }
@@ -113,6 +159,10 @@ func (cds *contentDirectoryService) readContainer(o object, host string) (ret []
 		return
 	}
 
+	if isPlaylist(node) {
+		return cds.readPlaylist(o, node, host)
+	}
+
 	if !node.IsDir() {
 		err = errors.New("not a directory")
 		return
@@ -125,12 +175,13 @@ func (cds *contentDirectoryService) readContainer(o object, host string) (ret []
 		return
 	}
 
+	covers := findCoverArt(dirEntries)
 	dirEntries, mediaResources := mediaWithResources(dirEntries)
 	for _, de := range dirEntries {
 		child := object{
 			path.Join(o.Path, de.Name()),
 		}
-		obj, err := cds.cdsObjectToUpnpavObject(child, de, mediaResources[de], host)
+		obj, err := cds.cdsObjectToUpnpavObject(child, de, mediaResources[de], covers.forNode(de), host)
 		if err != nil {
 			fs.Errorf(cds, "error with %s: %s", child.FilePath(), err)
 			continue
@@ -145,6 +196,162 @@ func (cds *contentDirectoryService) readContainer(o object, host string) (ret []
 	return
 }
 
+// isPlaylist returns whether node is a playlist which should be shown
+// as a container.
+func isPlaylist(node vfs.Node) bool {
+	if node.IsDir() {
+		return false
+	}
+	_, ext := splitExt(strings.ToLower(node.Name()))
+	return ext == ".m3u" || ext == ".m3u8"
+}
+
+// maxPlaylistSize is the largest playlist which will be read.
+const maxPlaylistSize = 16 << 20
+
+// playlistEntry is a file named in a playlist.
+type playlistEntry struct {
+	path  string // absolute path of the file in the remote
+	title string // title from #EXTINF if set
+}
+
+// parsePlaylist reads the entries of the m3u playlist at playlistPath.
+//
+// Relative paths are relative to the directory of the playlist and
+// absolute paths are relative to the root of the remote. URLs are
+// ignored as only files on the remote can be served.
+func parsePlaylist(in io.Reader, playlistPath string) (entries []playlistEntry, err error) {
+	data, err := io.ReadAll(io.LimitReader(in, maxPlaylistSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPlaylistSize {
+		return nil, errors.New("playlist too large")
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := string(data)
+	if !utf8.ValidString(text) {
+		// .m3u files are often Latin-1
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+		}
+		text = string(runes)
+	}
+	dir := path.Dir(playlistPath)
+	title := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if strings.HasPrefix(line, "#EXTINF:") {
+				if _, t, found := strings.Cut(line, ","); found {
+					title = strings.TrimSpace(t)
+				}
+			}
+			continue
+		}
+		entryTitle := title
+		title = ""
+		if strings.Contains(line, "://") {
+			fs.Debugf(playlistPath, "ignoring URL in playlist: %s", line)
+			continue
+		}
+		line = strings.ReplaceAll(line, "\\", "/")
+		if !path.IsAbs(line) {
+			line = path.Join(dir, line)
+		}
+		entries = append(entries, playlistEntry{
+			path:  path.Clean(line),
+			title: entryTitle,
+		})
+	}
+	return entries, nil
+}
+
+// playlistDir is a directory holding files from a playlist.
+type playlistDir struct {
+	nodes     map[string]vfs.Node
+	resources map[vfs.Node]vfs.Nodes
+	covers    coverArt
+}
+
+// Returns the upnpav objects for the files in a playlist.
+func (cds *contentDirectoryService) readPlaylist(o object, node vfs.Node, host string) (ret []interface{}, err error) {
+	in, err := node.(*vfs.File).Open(os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open playlist: %w", err)
+	}
+	entries, err := parsePlaylist(in, o.Path)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	// The directories of the entries are read so they get their
+	// subtitles and cover art in the same way as in a directory
+	dirs := map[string]*playlistDir{}
+	readDir := func(dirPath string) *playlistDir {
+		if d, found := dirs[dirPath]; found {
+			return d
+		}
+		d := &playlistDir{nodes: map[string]vfs.Node{}}
+		dirs[dirPath] = d
+		dirNode, err := cds.vfs.Stat(dirPath)
+		if err != nil || !dirNode.IsDir() {
+			return d
+		}
+		dirEntries, err := dirNode.(*vfs.Dir).ReadDirAll()
+		if err != nil {
+			fs.Errorf(cds, "error listing %s for playlist %s: %v", dirPath, o.Path, err)
+			return d
+		}
+		for _, de := range dirEntries {
+			d.nodes[de.Name()] = de
+		}
+		d.covers = findCoverArt(dirEntries)
+		_, d.resources = mediaWithResources(dirEntries)
+		return d
+	}
+
+	for _, entry := range entries {
+		d := readDir(path.Dir(entry.path))
+		de, found := d.nodes[path.Base(entry.path)]
+		if !found || de.IsDir() {
+			fs.Infof(o.Path, "playlist entry not found: %s", entry.path)
+			continue
+		}
+		child := object{entry.path}
+		obj, err := cds.cdsObjectToUpnpavObject(child, de, d.resources[de], d.covers.forNode(de), host)
+		if err != nil {
+			fs.Errorf(cds, "error with %s: %s", child.FilePath(), err)
+			continue
+		}
+		switch item := obj.(type) {
+		case upnpav.Item:
+			item.ParentID = o.ID()
+			if entry.title != "" {
+				item.Title = entry.title
+			}
+			obj = item
+		case upnpav.Container:
+			item.ParentID = o.ID()
+			obj = item
+		default:
+			fs.Debugf(cds, "unrecognized file type in playlist: %s", de)
+			continue
+		}
+		ret = append(ret, obj)
+	}
+
+	return
+}
+
 // Given a list of nodes, separate them into potential media items and any associated resources (external subtitles,
 // for example.)
 //
@@ -202,6 +409,160 @@ type browse struct {
 	RequestedCount int
 }
 
+type search struct {
+	ContainerID    string
+	SearchCriteria string
+	Filter         string
+	StartingIndex  int
+	RequestedCount int
+}
+
+// A search of the upnpav objects below a container which match crit.
+//
+// Directories are searched recursively but playlists aren't as
+// their contents are found in their directories anyway.
+//
+// The search only walks as far as it needs to and is kept in
+// server.searches so that the next page of the same search carries
+// on from where this one stopped rather than starting again.
+type searchWalk struct {
+	mu    sync.Mutex
+	cds   *contentDirectoryService
+	host  string
+	crit  searchCriteria
+	stack []searchFrame // containers being walked, innermost last
+	found []interface{} // matches found so far
+}
+
+// A container being walked by a searchWalk.
+type searchFrame struct {
+	objs []interface{} // the contents of the container
+	i    int           // index of the next object to look at
+}
+
+// Returns the search of the container o for crit from host, carrying
+// on with a previous one if there is one unless the first page is
+// being asked for.
+func (cds *contentDirectoryService) searchWalk(o object, host string, criteria string, crit searchCriteria, startingIndex int) (*searchWalk, error) {
+	key := host + "\x00" + o.Path + "\x00" + criteria
+	if startingIndex == 0 {
+		cds.searches.Delete(key)
+	}
+	value, err := cds.searches.Get(key, func(key string) (interface{}, bool, error) {
+		objs, err := cds.readContainer(o, host)
+		if err != nil {
+			return nil, false, err
+		}
+		return &searchWalk{
+			cds:   cds,
+			host:  host,
+			crit:  crit,
+			stack: []searchFrame{{objs: objs}},
+		}, true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*searchWalk), nil
+}
+
+// Returns the matches found so far, walking until there are at least
+// limit of them, or to the end of the search if limit is 0.
+func (w *searchWalk) find(limit int) []interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.stack) > 0 && (limit <= 0 || len(w.found) < limit) {
+		frame := &w.stack[len(w.stack)-1]
+		if frame.i >= len(frame.objs) {
+			w.stack = w.stack[:len(w.stack)-1]
+			continue
+		}
+		obj := frame.objs[frame.i]
+		frame.i++
+		var upnpObject *upnpav.Object
+		switch v := obj.(type) {
+		case upnpav.Item:
+			upnpObject = &v.Object
+		case upnpav.Container:
+			upnpObject = &v.Object
+		default:
+			continue
+		}
+		if w.crit(upnpObject) {
+			w.found = append(w.found, obj)
+		}
+		if upnpObject.Class != "object.container.storageFolder" {
+			continue
+		}
+		child, err := w.cds.objectFromID(upnpObject.ID)
+		if err != nil {
+			fs.Errorf(w.cds, "error searching %s: %v", upnpObject.ID, err)
+			continue
+		}
+		childObjs, err := w.cds.readContainer(child, w.host)
+		if err != nil {
+			fs.Errorf(w.cds, "error searching %s: %v", child.FilePath(), err)
+			continue
+		}
+		w.stack = append(w.stack, searchFrame{objs: childObjs})
+	}
+	return w.found[:len(w.found):len(w.found)]
+}
+
+// Returns the response to Browse or Search for a page of objs.
+func (cds *contentDirectoryService) objectsResponse(objs []interface{}, startingIndex, requestedCount int) (map[string]string, error) {
+	totalMatches := len(objs)
+	objs = objs[func() (low int) {
+		low = startingIndex
+		if low > len(objs) {
+			low = len(objs)
+		}
+		return
+	}():]
+	if requestedCount != 0 && requestedCount < len(objs) {
+		objs = objs[:requestedCount]
+	}
+	result, err := xml.Marshal(objs)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"TotalMatches":   fmt.Sprint(totalMatches),
+		"NumberReturned": fmt.Sprint(len(objs)),
+		"Result":         didlLite(string(result)),
+		"UpdateID":       cds.updateIDString(),
+	}, nil
+}
+
+// Returns the upnpav object for a single object with its subtitles and
+// cover art.
+func (cds *contentDirectoryService) readObject(o object, host string) (interface{}, error) {
+	node, err := cds.vfs.Stat(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	var resources vfs.Nodes
+	var cover vfs.Node
+	if !o.IsRoot() && !node.IsDir() {
+		parent, err := cds.vfs.Stat(path.Dir(o.Path))
+		if err == nil && parent.IsDir() {
+			dirEntries, err := parent.(*vfs.Dir).ReadDirAll()
+			if err == nil {
+				for _, de := range dirEntries {
+					if de.Name() == node.Name() {
+						node = de
+						break
+					}
+				}
+				cover = findCoverArt(dirEntries).forNode(node)
+				_, mediaResources := mediaWithResources(dirEntries)
+				resources = mediaResources[node]
+			}
+		}
+	}
+	return cds.cdsObjectToUpnpavObject(o, node, resources, cover, host)
+}
+
 // ContentDirectory object from ObjectID.
 func (cds *contentDirectoryService) objectFromID(id string) (o object, err error) {
 	o.Path, err = url.QueryUnescape(id)
@@ -246,34 +607,9 @@ func (cds *contentDirectoryService) Handle(action string, argsXML []byte, r *htt
 			if err != nil {
 				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
 			}
-			totalMatches := len(objs)
-			objs = objs[func() (low int) {
-				low = browse.StartingIndex
-				if low > len(objs) {
-					low = len(objs)
-				}
-				return
-			}():]
-			if browse.RequestedCount != 0 && browse.RequestedCount < len(objs) {
-				objs = objs[:browse.RequestedCount]
-			}
-			result, err := xml.Marshal(objs)
-			if err != nil {
-				return nil, err
-			}
-			return map[string]string{
-				"TotalMatches":   fmt.Sprint(totalMatches),
-				"NumberReturned": fmt.Sprint(len(objs)),
-				"Result":         didlLite(string(result)),
-				"UpdateID":       cds.updateIDString(),
-			}, nil
+			return cds.objectsResponse(objs, browse.StartingIndex, browse.RequestedCount)
 		case "BrowseMetadata":
-			node, err := cds.vfs.Stat(obj.Path)
-			if err != nil {
-				return nil, err
-			}
-			// TODO: External subtitles won't appear in the metadata here, but probably should.
-			upnpObject, err := cds.cdsObjectToUpnpavObject(obj, node, vfs.Nodes{}, host)
+			upnpObject, err := cds.readObject(obj, host)
 			if err != nil {
 				return nil, err
 			}
@@ -289,8 +625,35 @@ func (cds *contentDirectoryService) Handle(action string, argsXML []byte, r *htt
 		}
 	case "GetSearchCapabilities":
 		return map[string]string{
-			"SearchCaps": "",
+			"SearchCaps": searchCaps,
 		}, nil
+	case "Search":
+		var search search
+		if err := xml.Unmarshal(argsXML, &search); err != nil {
+			return nil, err
+		}
+		obj, err := cds.objectFromID(search.ContainerID)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		}
+		crit, err := parseSearchCriteria(search.SearchCriteria)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.InvalidSearchCriteriaErrorCode, err.Error())
+		}
+		walk, err := cds.searchWalk(obj, host, search.SearchCriteria, crit, search.StartingIndex)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		}
+		// Only look for one more than the page asked for to
+		// save walking the whole tree. TotalMatches is then a
+		// lower bound which is more than the end of the page so
+		// clients ask for the next page.
+		limit := 0
+		if search.RequestedCount > 0 {
+			limit = search.StartingIndex + search.RequestedCount + 1
+		}
+		objs := walk.find(limit)
+		return cds.objectsResponse(objs, search.StartingIndex, search.RequestedCount)
 	// Samsung Extensions
 	case "X_GetFeatureList":
 		return map[string]string{
@@ -0,0 +1,573 @@
+package dlna
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// Cover art is either an image next to the media (video.jpg for
+// video.mp4, or cover.jpg for everything in the directory) or a
+// picture embedded in the media file itself. Neither is resized so
+// the clients get the images as they are.
+
+// Names (without extension) of images which are the cover art for all
+// the media in their directory, in order of preference.
+var folderCoverNames = []string{"cover", "folder", "front", "albumart", "albumartsmall", "thumb", "poster"}
+
+// Extensions of images which can be cover art.
+var coverExts = []string{".jpg", ".jpeg", ".png"}
+
+// Extensions of media files which may have embedded cover art.
+var embeddedCoverExts = map[string]struct{}{
+	".mp3":  {},
+	".flac": {},
+	".m4a":  {},
+	".m4b":  {},
+	".mp4":  {},
+	".m4v":  {},
+}
+
+// maxCoverSize is the largest amount of metadata read looking for
+// embedded cover art.
+const maxCoverSize = 16 << 20
+
+// errNoCover is returned when a file has no embedded cover art.
+var errNoCover = errors.New("no embedded cover art")
+
+// coverArt finds the cover art for the media in a directory.
+type coverArt struct {
+	folder vfs.Node            // cover for all the media in the directory
+	byName map[string]vfs.Node // covers keyed by lowercase base name
+}
+
+// findCoverArt looks through the nodes of a directory for cover art.
+func findCoverArt(nodes vfs.Nodes) (c coverArt) {
+	c.byName = make(map[string]vfs.Node)
+	folderRank := len(folderCoverNames)
+	for _, node := range nodes {
+		if node.IsDir() {
+			continue
+		}
+		baseName, ext := splitExt(strings.ToLower(node.Name()))
+		if !isCoverExt(ext) {
+			continue
+		}
+		if _, found := c.byName[baseName]; !found {
+			c.byName[baseName] = node
+		}
+		for rank, name := range folderCoverNames[:folderRank] {
+			if baseName == name {
+				c.folder, folderRank = node, rank
+				break
+			}
+		}
+	}
+	return c
+}
+
+// forNode returns the cover art image for node or nil if there isn't
+// one next to it.
+func (c coverArt) forNode(node vfs.Node) vfs.Node {
+	baseName, _ := splitExt(strings.ToLower(node.Name()))
+	if cover, found := c.byName[baseName]; found && cover != node {
+		return cover
+	}
+	return c.folder
+}
+
+// isCoverExt returns whether ext is the extension of an image which
+// can be cover art.
+func isCoverExt(ext string) bool {
+	for _, coverExt := range coverExts {
+		if ext == coverExt {
+			return true
+		}
+	}
+	return false
+}
+
+// mayHaveEmbeddedCover returns whether the file name is of a type
+// which can have embedded cover art.
+func mayHaveEmbeddedCover(name string) bool {
+	_, ext := splitExt(strings.ToLower(name))
+	_, found := embeddedCoverExts[ext]
+	return found
+}
+
+// coverType is the cached result of looking for embedded cover art.
+type coverType struct {
+	modTime  time.Time
+	size     int64
+	mimeType string // "" if there isn't any
+}
+
+// embeddedCoverType returns the MIME type of the cover art embedded in
+// node or "" if it hasn't got any.
+//
+// The media file is read to find out, so the result is kept in
+// server.coverTypes until the file changes.
+func (s *server) embeddedCoverType(node vfs.Node) string {
+	if !mayHaveEmbeddedCover(node.Name()) || !node.Mode().IsRegular() {
+		return ""
+	}
+	modTime, size := node.ModTime(), node.Size()
+	if value, found := s.coverTypes.GetMaybe(node.Path()); found {
+		cached := value.(coverType)
+		if cached.modTime.Equal(modTime) && cached.size == size {
+			return cached.mimeType
+		}
+	}
+	in, err := node.(*vfs.File).Open(os.O_RDONLY)
+	if err != nil {
+		fs.Debugf(node, "Failed to open looking for embedded cover art: %v", err)
+		return ""
+	}
+	_, ext := splitExt(strings.ToLower(node.Name()))
+	_, mimeType, err := embeddedCover(in, size, ext)
+	_ = in.Close()
+	if err != nil && err != errNoCover {
+		fs.Debugf(node, "Failed to read embedded cover art: %v", err)
+		return ""
+	}
+	s.coverTypes.Put(node.Path(), coverType{
+		modTime:  modTime,
+		size:     size,
+		mimeType: mimeType,
+	})
+	return mimeType
+}
+
+// coverProfile returns the DLNA profile of a cover art image.
+func coverProfile(mimeType string) string {
+	if mimeType == "image/png" {
+		return "PNG_TN"
+	}
+	return "JPEG_TN"
+}
+
+// Serves the cover art embedded in media files.
+func (s *server) coverHandler(w http.ResponseWriter, r *http.Request) {
+	node, err := s.vfs.Stat(r.URL.Path)
+	if err != nil || !node.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	file := node.(*vfs.File)
+	in, err := file.Open(os.O_RDONLY)
+	if err != nil {
+		serveError(node, w, "Could not open resource", err)
+		return
+	}
+	defer fs.CheckClose(in, &err)
+
+	_, ext := splitExt(strings.ToLower(node.Name()))
+	data, mimeType, err := embeddedCover(in, node.Size(), ext)
+	if err == errNoCover {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		fs.Debugf(node, "Failed to read embedded cover art: %v", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_PN="+coverProfile(mimeType))
+	w.Header().Set("transferMode.dlna.org", "Interactive")
+	if r.Method == "HEAD" {
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		fs.Debugf(node, "Error writing cover art: %v", err)
+	}
+}
+
+// embeddedCover returns the cover art embedded in the media file in
+// which has the extension ext.
+func embeddedCover(in io.ReaderAt, size int64, ext string) (data []byte, mimeType string, err error) {
+	switch ext {
+	case ".mp3":
+		data, mimeType, err = id3Cover(in)
+	case ".flac":
+		data, mimeType, err = flacCover(in)
+	case ".m4a", ".m4b", ".mp4", ".m4v":
+		data, mimeType, err = mp4Cover(in, size)
+	default:
+		return nil, "", errNoCover
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errNoCover
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if mimeType == "" || !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	return data, mimeType, nil
+}
+
+// readAt reads n bytes at off from in.
+func readAt(in io.ReaderAt, off int64, n int64) ([]byte, error) {
+	if n < 0 || n > maxCoverSize {
+		return nil, fmt.Errorf("metadata too large: %d bytes", n)
+	}
+	buf := make([]byte, n)
+	_, err := in.ReadAt(buf, off)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
+// syncsafe decodes an ID3v2 syncsafe integer.
+func syncsafe(b []byte) int64 {
+	var x int64
+	for _, c := range b {
+		x = x<<7 | int64(c&0x7f)
+	}
+	return x
+}
+
+// removeUnsync undoes the ID3v2 unsynchronisation scheme.
+func removeUnsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// id3Cover finds the picture in the ID3v2 tag at the start of an
+// mp3 file, preferring the front cover.
+func id3Cover(in io.ReaderAt) (data []byte, mimeType string, err error) {
+	header, err := readAt(in, 0, 10)
+	if err != nil {
+		return nil, "", err
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, "", errNoCover
+	}
+	version, flags := header[3], header[5]
+	if version < 2 || version > 4 {
+		return nil, "", errNoCover
+	}
+	tag, err := readAt(in, 10, syncsafe(header[6:10]))
+	if err != nil {
+		return nil, "", err
+	}
+	if flags&0x80 != 0 && version < 4 {
+		tag = removeUnsync(tag)
+	}
+	if flags&0x40 != 0 && version > 2 {
+		// skip the extended header
+		if len(tag) < 4 {
+			return nil, "", errNoCover
+		}
+		var n int64
+		if version == 3 {
+			n = int64(binary.BigEndian.Uint32(tag)) + 4
+		} else {
+			n = syncsafe(tag[:4])
+		}
+		if n > int64(len(tag)) {
+			return nil, "", errNoCover
+		}
+		tag = tag[n:]
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	var found []byte
+	var foundMimeType string
+	for len(tag) >= headerSize && tag[0] != 0 {
+		id := string(tag[:idSize])
+		var frameSize int64
+		var frameFlags byte
+		switch version {
+		case 2:
+			frameSize = int64(tag[3])<<16 | int64(tag[4])<<8 | int64(tag[5])
+		case 3:
+			frameSize = int64(binary.BigEndian.Uint32(tag[4:8]))
+			frameFlags = tag[9]
+		case 4:
+			frameSize = syncsafe(tag[4:8])
+			frameFlags = tag[9]
+		}
+		if frameSize > int64(len(tag)-headerSize) {
+			break
+		}
+		frame := tag[headerSize : int64(headerSize)+frameSize]
+		tag = tag[int64(headerSize)+frameSize:]
+		if id != "APIC" && id != "PIC" {
+			continue
+		}
+		if version == 3 && frameFlags&0xc0 != 0 {
+			// compressed or encrypted
+			continue
+		}
+		if version == 4 {
+			if frameFlags&0x0c != 0 {
+				// compressed or encrypted
+				continue
+			}
+			if frameFlags&0x01 != 0 {
+				// data length indicator
+				if len(frame) < 4 {
+					continue
+				}
+				frame = frame[4:]
+			}
+			if frameFlags&0x02 != 0 {
+				frame = removeUnsync(frame)
+			}
+		}
+		picture, pictureType, pictureMimeType, ok := parseID3Picture(frame, version)
+		if !ok {
+			continue
+		}
+		if found == nil || pictureType == 3 {
+			found, foundMimeType = picture, pictureMimeType
+		}
+		if pictureType == 3 {
+			break
+		}
+	}
+	if found == nil {
+		return nil, "", errNoCover
+	}
+	return found, foundMimeType, nil
+}
+
+// parseID3Picture parses an APIC (or PIC in ID3v2.2) frame.
+func parseID3Picture(frame []byte, version byte) (picture []byte, pictureType byte, mimeType string, ok bool) {
+	if len(frame) < 2 {
+		return nil, 0, "", false
+	}
+	encoding := frame[0]
+	frame = frame[1:]
+	if version == 2 {
+		if len(frame) < 4 {
+			return nil, 0, "", false
+		}
+		switch strings.ToUpper(string(frame[:3])) {
+		case "PNG":
+			mimeType = "image/png"
+		case "JPG":
+			mimeType = "image/jpeg"
+		}
+		frame = frame[3:]
+	} else {
+		i := bytes.IndexByte(frame, 0)
+		if i < 0 {
+			return nil, 0, "", false
+		}
+		mimeType = strings.ToLower(string(frame[:i]))
+		if !strings.Contains(mimeType, "/") {
+			// some taggers write just "jpg" or "png"
+			mimeType = "image/" + strings.TrimPrefix(mimeType, "image")
+		}
+		frame = frame[i+1:]
+	}
+	if len(frame) < 1 {
+		return nil, 0, "", false
+	}
+	pictureType = frame[0]
+	frame = frame[1:]
+	// skip the description
+	if encoding == 1 || encoding == 2 {
+		// UTF-16 is terminated by two zero bytes on a two byte boundary
+		for i := 0; ; i += 2 {
+			if i+1 >= len(frame) {
+				return nil, 0, "", false
+			}
+			if frame[i] == 0 && frame[i+1] == 0 {
+				frame = frame[i+2:]
+				break
+			}
+		}
+	} else {
+		i := bytes.IndexByte(frame, 0)
+		if i < 0 {
+			return nil, 0, "", false
+		}
+		frame = frame[i+1:]
+	}
+	if len(frame) == 0 {
+		return nil, 0, "", false
+	}
+	return frame, pictureType, mimeType, true
+}
+
+// flacCover finds the PICTURE metadata block in a FLAC file,
+// preferring the front cover.
+func flacCover(in io.ReaderAt) (data []byte, mimeType string, err error) {
+	magic, err := readAt(in, 0, 4)
+	if err != nil {
+		return nil, "", err
+	}
+	if string(magic) != "fLaC" {
+		return nil, "", errNoCover
+	}
+	var found []byte
+	var foundMimeType string
+	for off := int64(4); ; {
+		header, err := readAt(in, off, 4)
+		if err != nil {
+			return nil, "", err
+		}
+		last, blockType := header[0]&0x80 != 0, header[0]&0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		off += 4
+		if blockType == 6 {
+			block, err := readAt(in, off, length)
+			if err != nil {
+				return nil, "", err
+			}
+			picture, pictureType, pictureMimeType, ok := parseFlacPicture(block)
+			if ok && (found == nil || pictureType == 3) {
+				found, foundMimeType = picture, pictureMimeType
+				if pictureType == 3 {
+					break
+				}
+			}
+		}
+		off += length
+		if last {
+			break
+		}
+	}
+	if found == nil {
+		return nil, "", errNoCover
+	}
+	return found, foundMimeType, nil
+}
+
+// parseFlacPicture parses a FLAC PICTURE metadata block.
+func parseFlacPicture(block []byte) (picture []byte, pictureType uint32, mimeType string, ok bool) {
+	next := func(n uint32) []byte {
+		if uint64(n) > uint64(len(block)) {
+			ok = false
+			return nil
+		}
+		b := block[:n]
+		block = block[n:]
+		return b
+	}
+	u32 := func() uint32 {
+		b := next(4)
+		if b == nil {
+			return 0
+		}
+		return binary.BigEndian.Uint32(b)
+	}
+	ok = true
+	pictureType = u32()
+	mimeType = string(next(u32()))
+	_ = next(u32()) // description
+	_ = next(16)    // width, height, depth and colors
+	picture = next(u32())
+	if !ok || len(picture) == 0 {
+		return nil, 0, "", false
+	}
+	return picture, pictureType, mimeType, true
+}
+
+// mp4Box is the position of a box in an MP4 file.
+type mp4Box struct {
+	kind  string
+	start int64 // offset of the contents
+	end   int64 // offset of the end of the box
+}
+
+// readMP4Boxes returns the boxes between start and end of in.
+func readMP4Boxes(in io.ReaderAt, start, end int64) (boxes []mp4Box, err error) {
+	for off := start; off+8 <= end; {
+		header, err := readAt(in, off, 8)
+		if err != nil {
+			return nil, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			size = end - off
+		case 1:
+			largeSize, err := readAt(in, off+8, 8)
+			if err != nil {
+				return nil, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(largeSize)), 16
+		}
+		if size < headerSize || off+size > end {
+			return nil, errors.New("bad mp4 box size")
+		}
+		boxes = append(boxes, mp4Box{
+			kind:  string(header[4:8]),
+			start: off + headerSize,
+			end:   off + size,
+		})
+		off += size
+	}
+	return boxes, nil
+}
+
+// findMP4Box finds the box called kind between start and end of in.
+func findMP4Box(in io.ReaderAt, start, end int64, kind string) (box mp4Box, err error) {
+	boxes, err := readMP4Boxes(in, start, end)
+	if err != nil {
+		return box, err
+	}
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, nil
+		}
+	}
+	return box, errNoCover
+}
+
+// mp4Cover finds the cover art in the iTunes metadata of an MP4 file
+// which is in moov/udta/meta/ilst/covr/data.
+func mp4Cover(in io.ReaderAt, size int64) (data []byte, mimeType string, err error) {
+	box := mp4Box{end: size}
+	for _, kind := range []string{"moov", "udta", "meta", "ilst", "covr", "data"} {
+		start := box.start
+		if kind == "ilst" {
+			// meta is a full box with a version and flags
+			start += 4
+		}
+		box, err = findMP4Box(in, start, box.end, kind)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	// data has a type and a locale before the image
+	if box.end-box.start <= 8 {
+		return nil, "", errNoCover
+	}
+	header, err := readAt(in, box.start, 8)
+	if err != nil {
+		return nil, "", err
+	}
+	switch binary.BigEndian.Uint32(header) & 0xffffff {
+	case 13:
+		mimeType = "image/jpeg"
+	case 14:
+		mimeType = "image/png"
+	}
+	data, err = readAt(in, box.start+8, box.end-box.start-8)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mimeType, nil
+}
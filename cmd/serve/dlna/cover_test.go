@@ -0,0 +1,150 @@
+package dlna
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testPicture = []byte("\xff\xd8\xff\xe0picture")
+	testCover   = []byte("\xff\xd8\xff\xe0cover")
+)
+
+// appendUint32 appends x as big endian to b.
+func appendUint32(b []byte, x uint32) []byte {
+	return append(b, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+}
+
+// makeSyncsafe encodes n as an ID3v2 syncsafe integer.
+func makeSyncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+// makeID3 makes an ID3v2 tag with the frames.
+func makeID3(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	return append(append([]byte{'I', 'D', '3', version, 0, 0}, makeSyncsafe(len(body))...), body...)
+}
+
+// makeID3Frame makes a frame for an ID3v2.3 or ID3v2.4 tag.
+func makeID3Frame(version byte, id string, data []byte) []byte {
+	frame := []byte(id)
+	if version == 4 {
+		frame = append(frame, makeSyncsafe(len(data))...)
+	} else {
+		frame = appendUint32(frame, uint32(len(data)))
+	}
+	return append(append(frame, 0, 0), data...)
+}
+
+func TestID3Cover(t *testing.T) {
+	apic := func(pictureType byte, picture []byte) []byte {
+		return append(append([]byte("\x00image/jpeg\x00"), pictureType), append([]byte("desc\x00"), picture...)...)
+	}
+	for _, version := range []byte{3, 4} {
+		tag := makeID3(version,
+			makeID3Frame(version, "TIT2", []byte("\x00title")),
+			makeID3Frame(version, "APIC", apic(0, testPicture)),
+			makeID3Frame(version, "APIC", apic(3, testCover)),
+		)
+		data, mimeType, err := embeddedCover(bytes.NewReader(tag), int64(len(tag)), ".mp3")
+		require.NoError(t, err)
+		assert.Equal(t, testCover, data)
+		assert.Equal(t, "image/jpeg", mimeType)
+	}
+
+	// UTF-16 description and no front cover
+	utf16 := append([]byte("\x01image/png\x00\x04\xff\xfed\x00\x00\x00"), testPicture...)
+	tag := makeID3(3, makeID3Frame(3, "APIC", utf16))
+	data, mimeType, err := embeddedCover(bytes.NewReader(tag), int64(len(tag)), ".mp3")
+	require.NoError(t, err)
+	assert.Equal(t, testPicture, data)
+	assert.Equal(t, "image/png", mimeType)
+
+	// ID3v2.2
+	pic := append([]byte("\x00JPG\x03\x00"), testCover...)
+	tag = makeID3(2, append([]byte{'P', 'I', 'C', 0, 0, byte(len(pic))}, pic...))
+	data, _, err = embeddedCover(bytes.NewReader(tag), int64(len(tag)), ".mp3")
+	require.NoError(t, err)
+	assert.Equal(t, testCover, data)
+
+	// No picture, no tag or truncated
+	for _, in := range [][]byte{
+		makeID3(3, makeID3Frame(3, "TIT2", []byte("\x00title"))),
+		[]byte("\xff\xfb\x90\x00"),
+		makeID3(3, makeID3Frame(3, "APIC", apic(3, testCover)))[:20],
+	} {
+		_, _, err = embeddedCover(bytes.NewReader(in), int64(len(in)), ".mp3")
+		assert.Equal(t, errNoCover, err)
+	}
+}
+
+func TestFlacCover(t *testing.T) {
+	picture := func(pictureType uint32, mimeType string, data []byte) []byte {
+		var b []byte
+		b = appendUint32(b, pictureType)
+		b = appendUint32(b, uint32(len(mimeType)))
+		b = append(b, mimeType...)
+		b = appendUint32(b, 0)
+		b = append(b, make([]byte, 16)...)
+		b = appendUint32(b, uint32(len(data)))
+		return append(b, data...)
+	}
+	block := func(last bool, blockType byte, data []byte) []byte {
+		if last {
+			blockType |= 0x80
+		}
+		n := len(data)
+		return append([]byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}, data...)
+	}
+	in := bytes.Join([][]byte{
+		[]byte("fLaC"),
+		block(false, 0, make([]byte, 34)),
+		block(false, 6, picture(0, "image/png", testPicture)),
+		block(true, 6, picture(3, "image/jpeg", testCover)),
+		[]byte("audio"),
+	}, nil)
+	data, mimeType, err := embeddedCover(bytes.NewReader(in), int64(len(in)), ".flac")
+	require.NoError(t, err)
+	assert.Equal(t, testCover, data)
+	assert.Equal(t, "image/jpeg", mimeType)
+
+	in = append([]byte("fLaC"), block(true, 0, make([]byte, 34))...)
+	_, _, err = embeddedCover(bytes.NewReader(in), int64(len(in)), ".flac")
+	assert.Equal(t, errNoCover, err)
+}
+
+func TestMP4Cover(t *testing.T) {
+	box := func(kind string, contents ...[]byte) []byte {
+		body := bytes.Join(contents, nil)
+		b := appendUint32(nil, uint32(8+len(body)))
+		return append(append(b, kind...), body...)
+	}
+	in := bytes.Join([][]byte{
+		box("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		box("mdat", []byte("audio")),
+		box("moov",
+			box("mvhd", make([]byte, 100)),
+			box("udta",
+				box("meta", make([]byte, 4),
+					box("hdlr", make([]byte, 25)),
+					box("ilst",
+						box("\xa9nam", box("data", []byte("\x00\x00\x00\x01\x00\x00\x00\x00title"))),
+						box("covr", box("data", []byte("\x00\x00\x00\x0e\x00\x00\x00\x00"), testCover)),
+					),
+				),
+			),
+		),
+	}, nil)
+	data, mimeType, err := embeddedCover(bytes.NewReader(in), int64(len(in)), ".m4a")
+	require.NoError(t, err)
+	assert.Equal(t, testCover, data)
+	assert.Equal(t, "image/png", mimeType)
+
+	in = box("moov", box("mvhd", make([]byte, 100)))
+	_, _, err = embeddedCover(bytes.NewReader(in), int64(len(in)), ".mp4")
+	assert.Equal(t, errNoCover, err)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/rclone/rclone/cmd/serve/dlna/data"
 	"github.com/rclone/rclone/cmd/serve/dlna/dlnaflags"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/cache"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/spf13/cobra"
@@ -47,6 +48,32 @@ based on media formats or file extensions. Additionally, there is no
 media transcoding support. This means that some players might show
 files that they are not able to play back correctly.
 
+Subtitles are paired with the media which has the same name, so
+"video.srt" or "video.en.srt" go with "video.mp4".
+
+Cover art is shown as a thumbnail for audio and video. Rclone uses an
+image with the same name as the media ("video.jpg" for "video.mp4"),
+otherwise an image called "cover", "folder", "front" or "albumart" in
+the same directory, otherwise a picture embedded in MP3, FLAC or MP4
+files. Finding embedded pictures means reading the metadata at the
+start of those files the first time they are listed. Images are
+served as they are and aren't resized.
+
+".m3u" and ".m3u8" playlists are shown as containers holding the media
+they list. Paths in the playlist are relative to the playlist, or to
+the root of the remote if they start with "/". URLs in playlists are
+ignored.
+
+Clients can search by title, class, artist, album and genre. A search
+reads the directories below where the client searches from until it
+has found the page of results asked for, so it can be slow on big
+remotes unless the directory cache is warm (see "--dir-cache-time").
+When a search stops early the total number of matches returned is the
+number found, which is one more than the end of the page, so clients
+know to ask for the next page. The next page carries on from where
+the previous one stopped if it is asked for within "--dir-cache-time",
+while asking for the first page again starts the search afresh.
+
 ` + dlnaflags.Help + vfs.Help,
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.46",
@@ -73,6 +100,7 @@ const (
 	serverField       = "Linux/3.4 DLNADOC/1.50 UPnP/1.0 DMS/1.0"
 	rootDescPath      = "/rootDesc.xml"
 	resPath           = "/r/"
+	coverPath         = "/c/"
 	serviceControlURL = "/ctl"
 )
 
@@ -98,6 +126,13 @@ type server struct {
 
 	f   fs.Fs
 	vfs *vfs.VFS
+
+	// Searches in progress so later pages can carry on from
+	// where the previous one stopped.
+	searches *cache.Cache
+
+	// MIME types of the embedded cover art of media files.
+	coverTypes *cache.Cache
 }
 
 func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
@@ -131,6 +166,9 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
 
 		f:   f,
 		vfs: vfs.New(f, &vfsflags.Opt),
+
+		searches:   cache.New().SetExpireDuration(vfsflags.Opt.DirCacheTime),
+		coverTypes: cache.New(),
 	}
 
 	s.services = map[string]UPnPService{
@@ -149,6 +187,8 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
 	r := http.NewServeMux()
 	r.Handle(resPath, http.StripPrefix(resPath,
 		http.HandlerFunc(s.resourceHandler)))
+	r.Handle(coverPath, http.StripPrefix(coverPath,
+		http.HandlerFunc(s.coverHandler)))
 	if opt.LogTrace {
 		r.Handle(rootDescPath, traceLogging(http.HandlerFunc(s.rootDescHandler)))
 		r.Handle(serviceControlURL, traceLogging(http.HandlerFunc(s.serviceControlHandler)))
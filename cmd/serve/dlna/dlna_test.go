@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -207,3 +208,176 @@ func TestContentDirectoryBrowseDirectChildren(t *testing.T) {
 	require.Contains(t, string(body), "/r/subdir/video.mp4")
 	require.Contains(t, string(body), "/r/subdir/video.srt")
 }
+
+// Make a ContentDirectory SOAP request returning the unescaped body.
+func contentDirectoryRequest(t *testing.T, action, args string) (int, string) {
+	req, err := http.NewRequest("POST", baseURL+serviceControlURL, strings.NewReader(`
+<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"
+            s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+    <s:Body>
+        <u:`+action+` xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">`+args+`</u:`+action+`>
+    </s:Body>
+</s:Envelope>`))
+	require.NoError(t, err)
+	req.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#`+action+`"`)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer fs.CheckClose(resp.Body, &err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, html.UnescapeString(string(body))
+}
+
+// Check that cover art is advertised and served.
+func TestContentDirectoryCoverArt(t *testing.T) {
+	code, body := contentDirectoryRequest(t, "Browse", `
+            <ObjectID>%2Fmusic</ObjectID>
+            <BrowseFlag>BrowseDirectChildren</BrowseFlag>
+            <StartingIndex>0</StartingIndex>
+            <RequestedCount>0</RequestedCount>`)
+	assert.Equal(t, http.StatusOK, code)
+	// song.jpg is the cover for song.mp3 and cover.jpg for the rest
+	assert.Contains(t, body, `<upnp:albumArtURI dlna:profileID="JPEG_TN">`+baseURL+`/r/music/song.jpg</upnp:albumArtURI>`)
+	assert.Contains(t, body, `<upnp:albumArtURI dlna:profileID="JPEG_TN">`+baseURL+`/r/music/cover.jpg</upnp:albumArtURI>`)
+	assert.Contains(t, body, `protocolInfo="http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN"`)
+
+	// media without an image next to it may have one embedded
+	code, body = contentDirectoryRequest(t, "Browse", `
+            <ObjectID>%2Fsubdir%2Fembedded.mp3</ObjectID>
+            <BrowseFlag>BrowseMetadata</BrowseFlag>`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `<upnp:albumArtURI dlna:profileID="JPEG_TN">`+baseURL+`/c/subdir/embedded.mp3</upnp:albumArtURI>`)
+
+	// but it is only advertised if there is one
+	code, body = contentDirectoryRequest(t, "Browse", `
+            <ObjectID>%2Fvideo.mp4</ObjectID>
+            <BrowseFlag>BrowseMetadata</BrowseFlag>`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "albumArtURI")
+	assert.NotContains(t, body, "/c/video.mp4")
+	assert.Contains(t, body, "/r/video.srt")
+
+	golden, err := os.ReadFile("testdata/files/small_jpeg.jpg")
+	require.NoError(t, err)
+	resp, err := http.Get(baseURL + coverPath + "music/embedded.mp3")
+	require.NoError(t, err)
+	defer fs.CheckClose(resp.Body, &err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, golden, got)
+
+	resp, err = http.Get(baseURL + coverPath + "video.mp4")
+	require.NoError(t, err)
+	defer fs.CheckClose(resp.Body, &err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// Check that playlists are containers of the media they list.
+func TestContentDirectoryPlaylist(t *testing.T) {
+	code, body := contentDirectoryRequest(t, "Browse", `
+            <ObjectID>%2Fmusic</ObjectID>
+            <BrowseFlag>BrowseDirectChildren</BrowseFlag>
+            <StartingIndex>0</StartingIndex>
+            <RequestedCount>0</RequestedCount>`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `<container id="%2Fmusic%2Fplaylist.m3u" parentID="%2Fmusic"`)
+	assert.Contains(t, body, `<upnp:class>object.container.playlistContainer</upnp:class><dc:title>playlist</dc:title>`)
+
+	code, body = contentDirectoryRequest(t, "Browse", `
+            <ObjectID>%2Fmusic%2Fplaylist.m3u</ObjectID>
+            <BrowseFlag>BrowseDirectChildren</BrowseFlag>
+            <StartingIndex>0</StartingIndex>
+            <RequestedCount>0</RequestedCount>`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<TotalMatches>3</TotalMatches>")
+	assert.Contains(t, body, `<item id="%2Fmusic%2Fsong.mp3" parentID="%2Fmusic%2Fplaylist.m3u"`)
+	assert.Contains(t, body, "<dc:title>Lovely Song</dc:title>")
+	assert.Contains(t, body, `<item id="%2Fvideo.mp4" parentID="%2Fmusic%2Fplaylist.m3u"`)
+	assert.Contains(t, body, "/r/video.en.srt")
+	assert.Contains(t, body, `<item id="%2Fmusic%2Fembedded.mp3"`)
+	assert.NotContains(t, body, "stream.mp3")
+	assert.True(t, strings.Index(body, "song.mp3") < strings.Index(body, "video.mp4"), "playlist order")
+}
+
+// Check that ContentDirectory#Search finds media by title and class.
+func TestContentDirectorySearch(t *testing.T) {
+	code, body := contentDirectoryRequest(t, "GetSearchCapabilities", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<SearchCaps>dc:title,upnp:class")
+
+	searchPage := func(container, criteria string, start, count int) (int, string) {
+		return contentDirectoryRequest(t, "Search", `
+            <ContainerID>`+container+`</ContainerID>
+            <SearchCriteria>`+html.EscapeString(criteria)+`</SearchCriteria>
+            <Filter>*</Filter>
+            <StartingIndex>`+fmt.Sprint(start)+`</StartingIndex>
+            <RequestedCount>`+fmt.Sprint(count)+`</RequestedCount>
+            <SortCriteria></SortCriteria>`)
+	}
+	search := func(container, criteria string) (int, string) {
+		return searchPage(container, criteria, 0, 0)
+	}
+
+	code, body = search("0", `dc:title contains "VIDEO"`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<TotalMatches>2</TotalMatches>")
+	assert.Contains(t, body, `<item id="%2Fvideo.mp4"`)
+	assert.Contains(t, body, `<item id="%2Fsubdir%2Fvideo.mp4"`)
+
+	code, body = search("0", `upnp:class derivedfrom "object.item.audioItem" and (dc:title = "song.mp3" or dc:title startsWith "emb")`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<TotalMatches>3</TotalMatches>")
+	assert.Contains(t, body, `<item id="%2Fmusic%2Fsong.mp3"`)
+	assert.Contains(t, body, `<item id="%2Fmusic%2Fembedded.mp3"`)
+	assert.Contains(t, body, `<item id="%2Fsubdir%2Fembedded.mp3"`)
+
+	code, body = search("%2Fsubdir", "*")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<TotalMatches>2</TotalMatches>")
+
+	// A page of results stops the search early and returns a
+	// lower bound for the total one past the end of the page
+	code, body = searchPage("0", `upnp:class derivedfrom "object.item"`, 0, 1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<NumberReturned>1</NumberReturned>")
+	assert.Contains(t, body, "<TotalMatches>2</TotalMatches>")
+	code, body = searchPage("0", `upnp:class derivedfrom "object.item"`, 1, 1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<NumberReturned>1</NumberReturned>")
+	assert.Contains(t, body, "<TotalMatches>3</TotalMatches>")
+	code, body = searchPage("0", `dc:title contains "VIDEO"`, 1, 1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<NumberReturned>1</NumberReturned>")
+	assert.Contains(t, body, "<TotalMatches>2</TotalMatches>")
+
+	// Paging through a search one at a time finds the same
+	// matches as searching for them all at once
+	itemID := regexp.MustCompile(`<item id="([^"]*)"`)
+	criteria := `upnp:class derivedfrom "object.item"`
+	code, body = search("0", criteria)
+	assert.Equal(t, http.StatusOK, code)
+	var want []string
+	for _, match := range itemID.FindAllStringSubmatch(body, -1) {
+		want = append(want, match[1])
+	}
+	assert.Equal(t, 8, len(want))
+	var got []string
+	for start := 0; ; start++ {
+		code, body = searchPage("0", criteria, start, 1)
+		require.Equal(t, http.StatusOK, code)
+		match := itemID.FindStringSubmatch(body)
+		if match == nil {
+			break
+		}
+		got = append(got, match[1])
+	}
+	assert.Equal(t, want, got)
+	assert.Contains(t, body, fmt.Sprintf("<TotalMatches>%d</TotalMatches>", len(want)))
+
+	code, body = search("0", `dc:title contains`)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, body, "<errorCode>708</errorCode>")
+}
@@ -0,0 +1,242 @@
+package dlna
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/cmd/serve/dlna/upnpav"
+)
+
+// searchCaps are the properties which can be used in search criteria.
+const searchCaps = "dc:title,upnp:class,upnp:artist,upnp:album,upnp:genre"
+
+// searchCriteria tests whether an object matches a ContentDirectory
+// search.
+type searchCriteria func(obj *upnpav.Object) bool
+
+// searchProperty returns the value of the property called name of obj
+// and whether it is known.
+func searchProperty(obj *upnpav.Object, name string) (string, bool) {
+	switch name {
+	case "dc:title":
+		return obj.Title, true
+	case "upnp:class":
+		return obj.Class, true
+	case "upnp:artist":
+		return obj.Artist, obj.Artist != ""
+	case "upnp:album":
+		return obj.Album, obj.Album != ""
+	case "upnp:genre":
+		return obj.Genre, obj.Genre != ""
+	case "@id":
+		return obj.ID, true
+	case "@parentID":
+		return obj.ParentID, true
+	}
+	return "", false
+}
+
+// parseSearchCriteria parses the SearchCriteria of a ContentDirectory
+// Search action.
+//
+// The grammar is in the UPnP ContentDirectory spec, for example
+//
+//	upnp:class derivedfrom "object.item.audioItem" and dc:title contains "love"
+//
+// String comparisons aren't case sensitive.
+func parseSearchCriteria(s string) (searchCriteria, error) {
+	tokens, err := tokenizeSearch(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 || len(tokens) == 1 && tokens[0] == "*" {
+		return func(*upnpav.Object) bool { return true }, nil
+	}
+	p := &searchParser{tokens: tokens}
+	crit, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in search criteria", p.tokens[p.pos])
+	}
+	return crit, nil
+}
+
+// tokenizeSearch splits search criteria into tokens.
+//
+// Quoted strings are returned with their quotes so they can be told
+// apart from other tokens.
+func tokenizeSearch(s string) (tokens []string, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			var b strings.Builder
+			b.WriteByte('"')
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated string in search criteria")
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				} else if s[i] == '"' {
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			tokens = append(tokens, b.String())
+		case strings.IndexByte("=!<>", c) >= 0:
+			j := i + 1
+			for j < len(s) && strings.IndexByte("=!<>", s[j]) >= 0 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			j := i + 1
+			for j < len(s) && strings.IndexByte(" \t\r\n()\"=!<>", s[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// searchParser is a recursive descent parser for search criteria.
+type searchParser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns the next token or "" at the end.
+func (p *searchParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token
+}
+
+// peekKeyword returns whether the next token is the keyword.
+func (p *searchParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], keyword)
+}
+
+// parseOr parses expressions joined with "or".
+func (p *searchParser) parseOr() (searchCriteria, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(obj *upnpav.Object) bool { return a(obj) || b(obj) }
+	}
+	return left, nil
+}
+
+// parseAnd parses expressions joined with "and".
+func (p *searchParser) parseAnd() (searchCriteria, error) {
+	left, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(obj *upnpav.Object) bool { return a(obj) && b(obj) }
+	}
+	return left, nil
+}
+
+// parseRelation parses a bracketed expression or a single comparison.
+func (p *searchParser) parseRelation() (searchCriteria, error) {
+	property := p.next()
+	switch property {
+	case "":
+		return nil, fmt.Errorf("search criteria ended early")
+	case "(":
+		crit, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ) in search criteria")
+		}
+		return crit, nil
+	}
+	if strings.HasPrefix(property, `"`) || property == ")" {
+		return nil, fmt.Errorf("expecting a property but got %q in search criteria", property)
+	}
+	op := p.next()
+	value := p.next()
+	if strings.EqualFold(op, "exists") {
+		var want bool
+		switch strings.ToLower(value) {
+		case "true":
+			want = true
+		case "false":
+			want = false
+		default:
+			return nil, fmt.Errorf("expecting true or false after exists but got %q in search criteria", value)
+		}
+		return func(obj *upnpav.Object) bool {
+			_, found := searchProperty(obj, property)
+			return found == want
+		}, nil
+	}
+	if !strings.HasPrefix(value, `"`) {
+		return nil, fmt.Errorf("expecting a quoted string after %s but got %q in search criteria", op, value)
+	}
+	value = strings.ToLower(value[1:])
+	var match func(got string) bool
+	switch strings.ToLower(op) {
+	case "=":
+		match = func(got string) bool { return got == value }
+	case "!=":
+		match = func(got string) bool { return got != value }
+	case "<":
+		match = func(got string) bool { return got < value }
+	case "<=":
+		match = func(got string) bool { return got <= value }
+	case ">":
+		match = func(got string) bool { return got > value }
+	case ">=":
+		match = func(got string) bool { return got >= value }
+	case "contains":
+		match = func(got string) bool { return strings.Contains(got, value) }
+	case "doesnotcontain":
+		match = func(got string) bool { return !strings.Contains(got, value) }
+	case "startswith":
+		match = func(got string) bool { return strings.HasPrefix(got, value) }
+	case "derivedfrom":
+		match = func(got string) bool { return got == value || strings.HasPrefix(got, value+".") }
+	default:
+		return nil, fmt.Errorf("unknown operator %q in search criteria", op)
+	}
+	return func(obj *upnpav.Object) bool {
+		got, _ := searchProperty(obj, property)
+		return match(strings.ToLower(got))
+	}, nil
+}
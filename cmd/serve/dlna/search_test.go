@@ -0,0 +1,58 @@
+package dlna
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/cmd/serve/dlna/upnpav"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearchCriteria(t *testing.T) {
+	song := &upnpav.Object{ID: "%2Fsong.mp3", Class: "object.item.audioItem", Title: "Love Song.mp3"}
+	dir := &upnpav.Object{ID: "%2Fdir", Class: "object.container.storageFolder", Title: "Music"}
+	for _, test := range []struct {
+		criteria string
+		song     bool
+		dir      bool
+	}{
+		{"*", true, true},
+		{"", true, true},
+		{`dc:title contains "love"`, true, false},
+		{`dc:title doesNotContain "love"`, false, true},
+		{`dc:title = "music"`, false, true},
+		{`dc:title != "music"`, true, false},
+		{`dc:title startsWith "LOVE"`, true, false},
+		{`dc:title>="m"`, false, true},
+		{`upnp:class derivedfrom "object.item"`, true, false},
+		{`upnp:class derivedfrom "object.item.audio"`, false, false},
+		{`upnp:class = "object.item.audioItem" or upnp:class = "object.container.storageFolder"`, true, true},
+		{`upnp:class derivedfrom "object.item" and dc:title contains "x"`, false, false},
+		{`dc:title contains "music" or dc:title contains "song" and upnp:class derivedfrom "object.container"`, false, true},
+		{`(dc:title contains "music" or dc:title contains "song") and upnp:class derivedfrom "object.item"`, true, false},
+		{`upnp:artist exists true`, false, false},
+		{`upnp:artist exists false AND @id = "%2Fdir"`, false, true},
+		{`dc:title contains "say \"hi\""`, false, false},
+	} {
+		crit, err := parseSearchCriteria(test.criteria)
+		if !assert.NoError(t, err, test.criteria) {
+			continue
+		}
+		assert.Equal(t, test.song, crit(song), test.criteria)
+		assert.Equal(t, test.dir, crit(dir), test.criteria)
+	}
+
+	for _, criteria := range []string{
+		`dc:title contains`,
+		`dc:title contains love`,
+		`dc:title like "love"`,
+		`(dc:title contains "love"`,
+		`dc:title contains "love")`,
+		`dc:title contains "love`,
+		`upnp:artist exists maybe`,
+		`dc:title contains "a" and`,
+		`"love"`,
+	} {
+		_, err := parseSearchCriteria(criteria)
+		assert.Error(t, err, criteria)
+	}
+}
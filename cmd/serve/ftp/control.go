@@ -0,0 +1,323 @@
+//go:build !plan9
+// +build !plan9
+
+package ftp
+
+// Extra FTP commands which the server library doesn't support
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/vfs"
+	ftp "goftp.io/server/v2"
+)
+
+// ftpHash is a checksum the HASH command can use
+type ftpHash struct {
+	name string // name in the HASH command
+	ht   hash.Type
+}
+
+// ftpHashes are the checksums HASH supports in order of preference
+var ftpHashes = []ftpHash{
+	{"SHA-256", hash.SHA256},
+	{"SHA-1", hash.SHA1},
+	{"MD5", hash.MD5},
+	{"CRC32", hash.CRC32},
+}
+
+// mdtmFormat is the time format used by MDTM and MFMT
+const mdtmFormat = "20060102150405"
+
+// extraCommands are the commands added to the library
+var extraCommands = map[string]ftp.Command{
+	"OPTS":    commandOpts{},
+	"MDTM":    commandMdtm{},
+	"MFMT":    commandMfmt{},
+	"HASH":    commandHash{},
+	"XCRC":    commandXHash{hash.CRC32},
+	"XMD5":    commandXHash{hash.MD5},
+	"XSHA1":   commandXHash{hash.SHA1},
+	"XSHA256": commandXHash{hash.SHA256},
+}
+
+// commands returns the commands of the library with the extra ones
+// added
+func (s *server) commands() map[string]ftp.Command {
+	commands := make(map[string]ftp.Command)
+	for name, command := range ftp.DefaultCommands() {
+		commands[name] = command
+	}
+	for name, command := range extraCommands {
+		commands[name] = command
+	}
+	commands["SIZE"] = extend{commands["SIZE"]}
+	// The library only accepts PBSZ and PROT after AUTH TLS, but
+	// connections are TLS from the start here.
+	if s.useTLS {
+		commands["PBSZ"] = commandPbsz{}
+		commands["PROT"] = commandProt{}
+	}
+	return commands
+}
+
+// driver returns the Driver for sess
+func driver(sess *ftp.Session) *Driver {
+	return sess.Options().Driver.(*Driver)
+}
+
+// newContext makes the context the Driver needs for a command
+func newContext(sess *ftp.Session, command, param string) *ftp.Context {
+	return &ftp.Context{
+		Sess:  sess,
+		Cmd:   command,
+		Param: param,
+		Data:  make(map[string]interface{}),
+	}
+}
+
+// extend marks a command of the library as an extension so it is
+// listed in FEAT
+type extend struct {
+	ftp.Command
+}
+
+func (cmd extend) IsExtend() bool { return true }
+
+// commandOpts handles OPTS HASH passing the other options to the
+// library
+type commandOpts struct{}
+
+func (cmd commandOpts) IsExtend() bool     { return false }
+func (cmd commandOpts) RequireParam() bool { return true }
+func (cmd commandOpts) RequireAuth() bool  { return false }
+
+func (cmd commandOpts) Execute(sess *ftp.Session, param string) {
+	opt, value, _ := strings.Cut(param, " ")
+	if !strings.EqualFold(opt, "HASH") {
+		ftp.DefaultCommands()["OPTS"].Execute(sess, param)
+		return
+	}
+	d := driver(sess)
+	name := strings.TrimSpace(value)
+	if name == "" {
+		sess.WriteMessage(200, d.selectedHash(sess).name)
+		return
+	}
+	for _, h := range ftpHashes {
+		if strings.EqualFold(h.name, name) {
+			d.getSession(sess).hash = h.name
+			sess.WriteMessage(200, h.name)
+			return
+		}
+	}
+	sess.WriteMessage(501, "Unknown algorithm, current selection not changed")
+}
+
+// commandMdtm sets the modification time if given one, otherwise the
+// library reads it
+type commandMdtm struct{}
+
+func (cmd commandMdtm) IsExtend() bool     { return true }
+func (cmd commandMdtm) RequireParam() bool { return true }
+func (cmd commandMdtm) RequireAuth() bool  { return true }
+
+func (cmd commandMdtm) Execute(sess *ftp.Session, param string) {
+	modTime, name, ok := parseTimeParam(param)
+	if !ok {
+		ftp.DefaultCommands()["MDTM"].Execute(sess, param)
+		return
+	}
+	setModTime(sess, "MDTM", name, modTime, false)
+}
+
+// commandMfmt sets the modification time
+type commandMfmt struct{}
+
+func (cmd commandMfmt) IsExtend() bool     { return true }
+func (cmd commandMfmt) RequireParam() bool { return true }
+func (cmd commandMfmt) RequireAuth() bool  { return true }
+
+func (cmd commandMfmt) Execute(sess *ftp.Session, param string) {
+	modTime, name, ok := parseTimeParam(param)
+	if !ok {
+		sess.WriteMessage(501, "Syntax error: need MFMT YYYYMMDDHHMMSS path")
+		return
+	}
+	setModTime(sess, "MFMT", name, modTime, true)
+}
+
+// commandHash sends the checksum chosen with OPTS HASH
+type commandHash struct{}
+
+func (cmd commandHash) IsExtend() bool     { return true }
+func (cmd commandHash) RequireParam() bool { return true }
+func (cmd commandHash) RequireAuth() bool  { return true }
+
+func (cmd commandHash) Execute(sess *ftp.Session, param string) {
+	h := driver(sess).selectedHash(sess)
+	sendHash(sess, "HASH", param, h.ht, func(sum string, size int64, name string) {
+		sess.WriteMessage(213, fmt.Sprintf("%s 0-%d %s %s", h.name, size, sum, name))
+	})
+}
+
+// commandXHash sends the checksum of type ht for the X commands
+type commandXHash struct {
+	ht hash.Type
+}
+
+func (cmd commandXHash) IsExtend() bool     { return true }
+func (cmd commandXHash) RequireParam() bool { return true }
+func (cmd commandXHash) RequireAuth() bool  { return true }
+
+func (cmd commandXHash) Execute(sess *ftp.Session, param string) {
+	name, ok := parseXHashParam(param)
+	if !ok {
+		sess.WriteMessage(504, "Checksums of part of a file aren't supported")
+		return
+	}
+	sendHash(sess, "X"+cmd.ht.String(), name, cmd.ht, func(sum string, size int64, name string) {
+		sess.WriteMessage(250, sum)
+	})
+}
+
+// commandPbsz accepts PBSZ 0 on a TLS connection
+type commandPbsz struct{}
+
+func (cmd commandPbsz) IsExtend() bool     { return false }
+func (cmd commandPbsz) RequireParam() bool { return true }
+func (cmd commandPbsz) RequireAuth() bool  { return false }
+
+func (cmd commandPbsz) Execute(sess *ftp.Session, param string) {
+	if param == "0" {
+		sess.WriteMessage(200, "OK")
+	} else {
+		sess.WriteMessage(501, "Only PBSZ 0 is supported")
+	}
+}
+
+// commandProt accepts PROT P on a TLS connection
+type commandProt struct{}
+
+func (cmd commandProt) IsExtend() bool     { return false }
+func (cmd commandProt) RequireParam() bool { return true }
+func (cmd commandProt) RequireAuth() bool  { return false }
+
+func (cmd commandProt) Execute(sess *ftp.Session, param string) {
+	if param == "P" {
+		sess.WriteMessage(200, "OK")
+	} else {
+		sess.WriteMessage(536, "Only P level is supported")
+	}
+}
+
+// selectedHash returns the hash HASH will use
+//
+// This is the one chosen with OPTS HASH, otherwise the default.
+func (d *Driver) selectedHash(sess *ftp.Session) ftpHash {
+	ss := d.getSession(sess)
+	for _, h := range ftpHashes {
+		if h.name == ss.hash {
+			return h
+		}
+	}
+	return defaultHash(ss.vfs)
+}
+
+// defaultHash returns the hash HASH uses for VFS if none is chosen
+// with OPTS HASH
+//
+// This is the first the remote supports.
+func defaultHash(VFS *vfs.VFS) ftpHash {
+	if VFS != nil {
+		hashes := VFS.Fs().Hashes()
+		for _, h := range ftpHashes {
+			if hashes.Contains(h.ht) {
+				return h
+			}
+		}
+	}
+	return ftpHashes[1] // SHA-1
+}
+
+// sendHash works out the hash of type ht of name and sends it with
+// send
+func sendHash(sess *ftp.Session, command, name string, ht hash.Type, send func(sum string, size int64, name string)) {
+	sum, size, err := driver(sess).Hash(newContext(sess, command, name), sess.BuildPath(name), ht)
+	if err != nil {
+		sess.WriteMessage(550, fmt.Sprintf("Failed to read checksum: %v", err))
+		return
+	}
+	send(sum, size, name)
+}
+
+// setModTime sets the modification time of name
+//
+// MFMT replies with the new time whereas MDTM just says it is done.
+func setModTime(sess *ftp.Session, command, name string, modTime time.Time, isMFMT bool) {
+	err := driver(sess).SetModTime(newContext(sess, command, name), sess.BuildPath(name), modTime)
+	if err != nil {
+		sess.WriteMessage(550, fmt.Sprintf("Failed to set modification time: %v", err))
+	} else if isMFMT {
+		sess.WriteMessage(213, fmt.Sprintf("Modify=%s; %s", modTime.UTC().Format(mdtmFormat), name))
+	} else {
+		sess.WriteMessage(213, "File modification time set")
+	}
+}
+
+// parseTimeParam parses "YYYYMMDDHHMMSS[.sss] path" from MFMT and
+// MDTM
+func parseTimeParam(param string) (modTime time.Time, name string, ok bool) {
+	timeString, name, found := strings.Cut(param, " ")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || len(timeString) < len(mdtmFormat) {
+		return modTime, "", false
+	}
+	modTime, err := time.ParseInLocation(mdtmFormat, timeString, time.UTC)
+	if err != nil {
+		return modTime, "", false
+	}
+	return modTime, name, true
+}
+
+// parseXHashParam parses "path [start [end]]" from the X hash
+// commands returning ok false if a part of the file is asked for
+//
+// The path can be in double quotes if it contains spaces. If it
+// isn't, up to two numbers at the end are taken as start and end.
+func parseXHashParam(param string) (name string, ok bool) {
+	var args []string
+	if quoted, rest, found := strings.Cut(strings.TrimPrefix(param, `"`), `"`); strings.HasPrefix(param, `"`) && found {
+		args = strings.Fields(rest)
+		param = quoted
+	} else {
+		for len(args) < 2 {
+			i := strings.LastIndexByte(param, ' ')
+			if i < 0 || !isNumber(param[i+1:]) {
+				break
+			}
+			args = append([]string{param[i+1:]}, args...)
+			param = strings.TrimRight(param[:i], " ")
+		}
+	}
+	if len(args) > 0 && args[0] != "0" || len(args) > 1 {
+		return param, false
+	}
+	return param, true
+}
+
+// isNumber returns true if s is a non empty string of digits
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
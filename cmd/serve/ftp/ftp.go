@@ -12,9 +12,9 @@ import (
 	"net"
 	"os"
 	"os/user"
+	"path"
 	"regexp"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/rclone/rclone/cmd"
@@ -23,13 +23,16 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/cache"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	ftp "goftp.io/server/core"
+	ftp "goftp.io/server/v2"
 )
 
 // Options contains options for the http Server
@@ -98,13 +101,37 @@ then using Authentication is advised - see the next section for info.
 By default this will serve files without needing a login.
 
 You can set a single username and password with the --user and --pass flags.
+
+#### Checksums and modification times
+
+As well as the usual FTP commands the server supports the HASH
+command and the XCRC, XMD5, XSHA1 and XSHA256 commands so clients can
+verify transfers. HASH can use SHA-256, SHA-1, MD5 or CRC32, chosen
+with OPTS HASH. It uses the first of those the remote supports until
+one is chosen and OPTS HASH with no checksum shows which is in use.
+The checksums come from the remote if it supports them, otherwise the
+file is read to work them out.
+
+The MFMT command and the two argument form of MDTM set the
+modification time of a file.
+
+Uploads and downloads can be restarted with REST. An upload after
+REST writes from the offset given over the existing file, so restarting
+uploads needs --vfs-cache-mode minimal or higher.
+
+#### Limitations
+
+Directory listings show the owner and group set with --uid and --gid
+and the permissions set with --file-perms for files and --dir-perms
+for directories. Changing the owner, group or permissions isn't
+supported.
 ` + vfs.Help + proxy.Help,
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.44",
 	},
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxyflags.Opt.AuthProxy == "" {
+		if !proxyflags.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -129,6 +156,7 @@ type server struct {
 	vfs    *vfs.VFS
 	proxy  *proxy.Proxy
 	useTLS bool
+	isDir  *cache.Cache // whether the paths the Driver last returned are directories
 }
 
 var passivePortsRe = regexp.MustCompile(`^\s*\d+\s*-\s*\d+\s*$`)
@@ -145,11 +173,12 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) (*server, error) {
 	}
 
 	s := &server{
-		f:   f,
-		ctx: ctx,
-		opt: *opt,
+		f:     f,
+		ctx:   ctx,
+		opt:   *opt,
+		isDir: cache.New(),
 	}
-	if proxyflags.Opt.AuthProxy != "" {
+	if proxyflags.Opt.Enabled() {
 		s.proxy = proxy.New(ctx, &proxyflags.Opt)
 	} else {
 		s.vfs = vfs.New(f, &vfsflags.Opt)
@@ -161,22 +190,27 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) (*server, error) {
 		return nil, fmt.Errorf("invalid format for passive ports %q", opt.PassivePorts)
 	}
 
-	ftpopt := &ftp.ServerOpts{
+	ftpopt := &ftp.Options{
 		Name:           "Rclone FTP Server",
 		WelcomeMessage: "Welcome to Rclone " + fs.Version + " FTP Server",
-		Factory:        s, // implemented by NewDriver method
+		Driver:         &Driver{s: s},
 		Hostname:       host,
 		Port:           portNum,
 		PublicIP:       opt.PublicIP,
 		PassivePorts:   opt.PassivePorts,
-		Auth:           s, // implemented by CheckPasswd method
+		Auth:           s, // not used as the Driver implements CheckPasswd
+		Perm:           &perm{s: s, opt: &vfsflags.Opt},
 		Logger:         &Logger{},
 		TLS:            s.useTLS,
 		CertFile:       s.opt.TLSCert,
 		KeyFile:        s.opt.TLSKey,
-		//TODO implement a maximum of https://godoc.org/goftp.io/server#ServerOpts
+		Commands:       s.commands(),
+		//TODO implement a maximum of https://pkg.go.dev/goftp.io/server/v2#Options
+	}
+	s.srv, err = ftp.NewServer(ftpopt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make FTP server: %w", err)
 	}
-	s.srv = ftp.NewServer(ftpopt)
 	return s, nil
 }
 
@@ -194,6 +228,16 @@ func (s *server) close() error {
 	return s.srv.Shutdown()
 }
 
+// dirKey returns the key for name in server.isDir
+func dirKey(name string) string {
+	return path.Clean("/" + name)
+}
+
+// recordIsDir records whether node is a directory for perm
+func (s *server) recordIsDir(node vfs.Node) {
+	s.isDir.Put(dirKey(node.Path()), node.IsDir())
+}
+
 // Logger ftp logger output formatted message
 type Logger struct{}
 
@@ -224,31 +268,108 @@ func (l *Logger) PrintResponse(sessionID string, code int, message string) {
 // CheckPasswd handle auth based on configuration
 //
 // This is not used - the one in Driver should be called instead
-func (s *server) CheckPasswd(user, pass string) (ok bool, err error) {
+func (s *server) CheckPasswd(sctx *ftp.Context, user, pass string) (ok bool, err error) {
 	err = errors.New("internal error: server.CheckPasswd should never be called")
 	fs.Errorf(nil, "Error: %v", err)
 	return false, err
 }
 
-// NewDriver starts a new session for each client connection
-func (s *server) NewDriver() (ftp.Driver, error) {
-	log.Trace("", "Init driver")("")
-	d := &Driver{
-		s:   s,
-		vfs: s.vfs, // this can be nil if proxy set
+// perm reports the owner, group and permissions of files in listings
+//
+// The server library only passes the path so these come from the VFS
+// options rather than from the file.
+type perm struct {
+	s   *server
+	opt *vfscommon.Options
+}
+
+// GetOwner returns the owner of the file. Try to find the username if possible
+func (p *perm) GetOwner(string) (string, error) {
+	str := fmt.Sprint(p.opt.UID)
+	u, err := user.LookupId(str)
+	if err != nil {
+		return str, nil //User not found
+	}
+	return u.Username, nil
+}
+
+// GetGroup returns the group of the file. Try to find the group name if possible
+func (p *perm) GetGroup(string) (string, error) {
+	str := fmt.Sprint(p.opt.GID)
+	g, err := user.LookupGroupId(str)
+	if err != nil {
+		return str, nil //Group not found default to numerical value
 	}
-	return d, nil
+	return g.Name, nil
+}
+
+// GetMode returns the permissions of the file or directory
+//
+// The library only asks about paths the Driver has just returned from
+// Stat or ListDir, which record whether they are directories. The
+// library adds the directory flag to directories.
+func (p *perm) GetMode(name string) (os.FileMode, error) {
+	if isDir, found := p.s.isDir.GetMaybe(dirKey(name)); found && isDir.(bool) {
+		return p.opt.DirPerms, nil
+	}
+	return p.opt.FilePerms, nil
+}
+
+// ChOwner isn't supported
+func (p *perm) ChOwner(string, string) error {
+	return errors.New("changing the owner isn't supported")
+}
+
+// ChGroup isn't supported
+func (p *perm) ChGroup(string, string) error {
+	return errors.New("changing the group isn't supported")
+}
+
+// ChMode isn't supported
+func (p *perm) ChMode(string, os.FileMode) error {
+	return errors.New("changing the permissions isn't supported")
 }
 
 // Driver implementation of ftp server
+//
+// There is one Driver for the server. The state of each client
+// connection is kept in a session. The library runs the commands of a
+// connection one at a time so the session needs no locking.
 type Driver struct {
-	s    *server
-	vfs  *vfs.VFS
-	lock sync.Mutex
+	s *server
+}
+
+// session is the state of a client connection
+type session struct {
+	vfs  *vfs.VFS // this can be nil if proxy set and not logged in
+	hash string   // name of the hash selected with OPTS HASH
+}
+
+// sessionKey is the key of the session in ftp.Session.Data
+const sessionKey = "rclone"
+
+// getSession returns the session for sess making it if necessary
+func (d *Driver) getSession(sess *ftp.Session) *session {
+	ss, ok := sess.Data[sessionKey].(*session)
+	if !ok {
+		log.Trace("", "Init session")("")
+		ss = &session{vfs: d.s.vfs}
+		sess.Data[sessionKey] = ss
+	}
+	return ss
+}
+
+// getVFS returns the VFS for the connection of sctx
+func (d *Driver) getVFS(sctx *ftp.Context) (*vfs.VFS, error) {
+	VFS := d.getSession(sctx.Sess).vfs
+	if VFS == nil {
+		return nil, errors.New("not logged in")
+	}
+	return VFS, nil
 }
 
 // CheckPasswd handle auth based on configuration
-func (d *Driver) CheckPasswd(user, pass string) (ok bool, err error) {
+func (d *Driver) CheckPasswd(sctx *ftp.Context, user, pass string) (ok bool, err error) {
 	s := d.s
 	if s.proxy != nil {
 		var VFS *vfs.VFS
@@ -257,7 +378,7 @@ func (d *Driver) CheckPasswd(user, pass string) (ok bool, err error) {
 			fs.Infof(nil, "proxy login failed: %v", err)
 			return false, nil
 		}
-		d.vfs = VFS
+		d.getSession(sctx.Sess).vfs = VFS
 	} else {
 		ok = s.opt.BasicUser == user && (s.opt.BasicPass == "" || s.opt.BasicPass == pass)
 		if !ok {
@@ -269,36 +390,28 @@ func (d *Driver) CheckPasswd(user, pass string) (ok bool, err error) {
 }
 
 // Stat get information on file or folder
-func (d *Driver) Stat(path string) (fi ftp.FileInfo, err error) {
+func (d *Driver) Stat(sctx *ftp.Context, path string) (fi os.FileInfo, err error) {
 	defer log.Trace(path, "")("fi=%+v, err = %v", &fi, &err)
-	n, err := d.vfs.Stat(path)
+	VFS, err := d.getVFS(sctx)
 	if err != nil {
 		return nil, err
 	}
-	return &FileInfo{n, n.Mode(), d.vfs.Opt.UID, d.vfs.Opt.GID}, err
-}
-
-// ChangeDir move current folder
-func (d *Driver) ChangeDir(path string) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-	defer log.Trace(path, "")("err = %v", &err)
-	n, err := d.vfs.Stat(path)
+	n, err := VFS.Stat(path)
 	if err != nil {
-		return err
-	}
-	if !n.IsDir() {
-		return errors.New("not a directory")
+		return nil, err
 	}
-	return nil
+	d.s.recordIsDir(n)
+	return &FileInfo{n}, err
 }
 
 // ListDir list content of a folder
-func (d *Driver) ListDir(path string, callback func(ftp.FileInfo) error) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+func (d *Driver) ListDir(sctx *ftp.Context, path string, callback func(os.FileInfo) error) (err error) {
 	defer log.Trace(path, "")("err = %v", &err)
-	node, err := d.vfs.Stat(path)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return err
+	}
+	node, err := VFS.Stat(path)
 	if err == vfs.ENOENT {
 		return errors.New("directory not found")
 	} else if err != nil {
@@ -315,13 +428,14 @@ func (d *Driver) ListDir(path string, callback func(ftp.FileInfo) error) (err er
 	}
 
 	// Account the transfer
-	tr := accounting.GlobalStats().NewTransferRemoteSize(path, node.Size())
+	tr := accounting.GlobalStats().NewTransferRemoteSize(path, node.Size(), nil, nil)
 	defer func() {
 		tr.Done(d.s.ctx, err)
 	}()
 
 	for _, file := range dirEntries {
-		err = callback(&FileInfo{file, file.Mode(), d.vfs.Opt.UID, d.vfs.Opt.GID})
+		d.s.recordIsDir(file)
+		err = callback(&FileInfo{file})
 		if err != nil {
 			return err
 		}
@@ -330,11 +444,13 @@ func (d *Driver) ListDir(path string, callback func(ftp.FileInfo) error) (err er
 }
 
 // DeleteDir delete a folder and his content
-func (d *Driver) DeleteDir(path string) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+func (d *Driver) DeleteDir(sctx *ftp.Context, path string) (err error) {
 	defer log.Trace(path, "")("err = %v", &err)
-	node, err := d.vfs.Stat(path)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return err
+	}
+	node, err := VFS.Stat(path)
 	if err != nil {
 		return err
 	}
@@ -349,11 +465,13 @@ func (d *Driver) DeleteDir(path string) (err error) {
 }
 
 // DeleteFile delete a file
-func (d *Driver) DeleteFile(path string) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+func (d *Driver) DeleteFile(sctx *ftp.Context, path string) (err error) {
 	defer log.Trace(path, "")("err = %v", &err)
-	node, err := d.vfs.Stat(path)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return err
+	}
+	node, err := VFS.Stat(path)
 	if err != nil {
 		return err
 	}
@@ -368,19 +486,23 @@ func (d *Driver) DeleteFile(path string) (err error) {
 }
 
 // Rename rename a file or folder
-func (d *Driver) Rename(oldName, newName string) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+func (d *Driver) Rename(sctx *ftp.Context, oldName, newName string) (err error) {
 	defer log.Trace(oldName, "newName=%q", newName)("err = %v", &err)
-	return d.vfs.Rename(oldName, newName)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return err
+	}
+	return VFS.Rename(oldName, newName)
 }
 
 // MakeDir create a folder
-func (d *Driver) MakeDir(path string) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+func (d *Driver) MakeDir(sctx *ftp.Context, path string) (err error) {
 	defer log.Trace(path, "")("err = %v", &err)
-	dir, leaf, err := d.vfs.StatParent(path)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return err
+	}
+	dir, leaf, err := VFS.StatParent(path)
 	if err != nil {
 		return err
 	}
@@ -389,11 +511,13 @@ func (d *Driver) MakeDir(path string) (err error) {
 }
 
 // GetFile download a file
-func (d *Driver) GetFile(path string, offset int64) (size int64, fr io.ReadCloser, err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+func (d *Driver) GetFile(sctx *ftp.Context, path string, offset int64) (size int64, fr io.ReadCloser, err error) {
 	defer log.Trace(path, "offset=%v", offset)("err = %v", &err)
-	node, err := d.vfs.Stat(path)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	node, err := VFS.Stat(path)
 	if err == vfs.ENOENT {
 		fs.Infof(path, "File not found")
 		return 0, nil, errors.New("file not found")
@@ -414,19 +538,26 @@ func (d *Driver) GetFile(path string, offset int64) (size int64, fr io.ReadClose
 	}
 
 	// Account the transfer
-	tr := accounting.GlobalStats().NewTransferRemoteSize(path, node.Size())
+	tr := accounting.GlobalStats().NewTransferRemoteSize(path, node.Size(), nil, nil)
 	defer tr.Done(d.s.ctx, nil)
 
 	return node.Size(), handle, nil
 }
 
 // PutFile upload a file
-func (d *Driver) PutFile(path string, data io.Reader, appendData bool) (n int64, err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-	defer log.Trace(path, "append=%v", appendData)("err = %v", &err)
+//
+// The library passes the offset from REST or -1. A STOR after REST
+// restarts the upload, writing the data from offset over what is in
+// the file already.
+func (d *Driver) PutFile(sctx *ftp.Context, path string, data io.Reader, offset int64) (n int64, err error) {
+	appendData := sctx.Cmd == "APPE"
+	defer log.Trace(path, "append=%v, offset=%v", appendData, offset)("err = %v", &err)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return 0, err
+	}
 	var isExist bool
-	node, err := d.vfs.Stat(path)
+	node, err := VFS.Stat(path)
 	if err == nil {
 		isExist = true
 		if node.IsDir() {
@@ -440,6 +571,25 @@ func (d *Driver) PutFile(path string, data io.Reader, appendData bool) (n int64,
 		}
 	}
 
+	if offset > 0 && !appendData {
+		if !isExist {
+			return 0, errors.New("can't restart the upload of a file which doesn't exist")
+		}
+		if offset > node.Size() {
+			return 0, fmt.Errorf("can't restart the upload at %d as the file is only %d bytes", offset, node.Size())
+		}
+		f, err := VFS.OpenFile(path, os.O_RDWR, 0660)
+		if err != nil {
+			return 0, err
+		}
+		defer closeIO(path, f)
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		return io.Copy(f, data)
+	}
+
 	if appendData && !isExist {
 		appendData = false
 	}
@@ -451,7 +601,7 @@ func (d *Driver) PutFile(path string, data io.Reader, appendData bool) (n int64,
 				return 0, err
 			}
 		}
-		f, err := d.vfs.OpenFile(path, os.O_RDWR|os.O_CREATE, 0660)
+		f, err := VFS.OpenFile(path, os.O_RDWR|os.O_CREATE, 0660)
 		if err != nil {
 			return 0, err
 		}
@@ -463,7 +613,7 @@ func (d *Driver) PutFile(path string, data io.Reader, appendData bool) (n int64,
 		return bytes, nil
 	}
 
-	of, err := d.vfs.OpenFile(path, os.O_APPEND|os.O_RDWR, 0660)
+	of, err := VFS.OpenFile(path, os.O_APPEND|os.O_RDWR, 0660)
 	if err != nil {
 		return 0, err
 	}
@@ -482,38 +632,81 @@ func (d *Driver) PutFile(path string, data io.Reader, appendData bool) (n int64,
 	return bytes, nil
 }
 
-// FileInfo struct to hold file info for ftp server
-type FileInfo struct {
-	os.FileInfo
-
-	mode  os.FileMode
-	owner uint32
-	group uint32
-}
-
-// Mode return mode of file.
-func (f *FileInfo) Mode() os.FileMode {
-	return f.mode
+// Hash returns the checksum of type ht for the file at path
+//
+// If the remote can't supply it then the file is read to work it out.
+func (d *Driver) Hash(sctx *ftp.Context, path string, ht hash.Type) (sum string, size int64, err error) {
+	defer log.Trace(path, "hash=%v", ht)("sum=%q, err = %v", &sum, &err)
+	VFS, err := d.getVFS(sctx)
+	if err != nil {
+		return "", 0, err
+	}
+	node, err := VFS.Stat(path)
+	if err == vfs.ENOENT {
+		return "", 0, errors.New("file not found")
+	} else if err != nil {
+		return "", 0, err
+	}
+	if !node.IsFile() {
+		return "", 0, errors.New("not a file")
+	}
+	obj, ok := node.DirEntry().(fs.Object)
+	if !ok || obj == nil {
+		return "", 0, errors.New("file is being written")
+	}
+	sum, err = obj.Hash(d.s.ctx, ht)
+	if err != nil && err != hash.ErrUnsupported {
+		return "", 0, err
+	}
+	if sum == "" {
+		sum, err = d.readHash(obj, ht)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return sum, obj.Size(), nil
 }
 
-// Owner return owner of file. Try to find the username if possible
-func (f *FileInfo) Owner() string {
-	str := fmt.Sprint(f.owner)
-	u, err := user.LookupId(str)
+// readHash reads obj to find its checksum of type ht
+func (d *Driver) readHash(obj fs.Object, ht hash.Type) (sum string, err error) {
+	in, err := obj.Open(d.s.ctx)
+	if err != nil {
+		return "", err
+	}
+	tr := accounting.GlobalStats().NewTransfer(obj, nil)
+	defer func() {
+		tr.Done(d.s.ctx, err)
+	}()
+	in = tr.Account(d.s.ctx, in).WithBuffer()
+	defer closeIO(obj.Remote(), in)
+	sums, err := hash.StreamTypes(in, hash.NewHashSet(ht))
 	if err != nil {
-		return str //User not found
+		return "", err
 	}
-	return u.Username
+	return sums[ht], nil
 }
 
-// Group return group of file. Try to find the group name if possible
-func (f *FileInfo) Group() string {
-	str := fmt.Sprint(f.group)
-	g, err := user.LookupGroupId(str)
+// SetModTime sets the modification time of the file at path
+func (d *Driver) SetModTime(sctx *ftp.Context, path string, modTime time.Time) (err error) {
+	defer log.Trace(path, "modTime=%v", modTime)("err = %v", &err)
+	VFS, err := d.getVFS(sctx)
 	if err != nil {
-		return str //Group not found default to numerical value
+		return err
 	}
-	return g.Name
+	node, err := VFS.Stat(path)
+	if err == vfs.ENOENT {
+		return errors.New("file not found")
+	} else if err != nil {
+		return err
+	}
+	return node.SetModTime(modTime)
+}
+
+// FileInfo struct to hold file info for ftp server
+//
+// The owner, group and mode are filled in by perm.
+type FileInfo struct {
+	os.FileInfo
 }
 
 // ModTime returns the time in UTC
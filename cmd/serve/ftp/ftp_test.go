@@ -10,20 +10,35 @@ package ftp
 
 import (
 	"context"
+	"crypto/tls"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	ftpclient "github.com/jlaffaye/ftp"
 	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/cmd/serve/servetest"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/stretchr/testify/assert"
-	ftp "goftp.io/server/core"
+	"github.com/stretchr/testify/require"
+	ftp "goftp.io/server/v2"
 )
 
 const (
 	testHOST             = "localhost"
 	testPORT             = "51780"
+	testCommandsPORT     = "51781"
+	testTLSPORT          = "51782"
+	testRestartPORT      = "51783"
 	testPASSIVEPORTRANGE = "30000-32000"
 	testUSER             = "rclone"
 	testPASS             = "password"
@@ -70,3 +85,234 @@ func TestFTP(t *testing.T) {
 
 	servetest.Run(t, "ftp", start)
 }
+
+// TestFTPCommands tests the commands the server adds to the library
+func TestFTPCommands(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "hello world.txt"), []byte("hello"), 0666))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+
+	opt := DefaultOpt
+	opt.ListenAddr = testHOST + ":" + testCommandsPORT
+	opt.BasicUser = testUSER
+	opt.BasicPass = testPASS
+	s, err := newServer(context.Background(), f, &opt)
+	require.NoError(t, err)
+	quit := make(chan struct{})
+	go func() {
+		err := s.serve()
+		close(quit)
+		if err != ftp.ErrServerClosed {
+			assert.NoError(t, err)
+		}
+	}()
+	defer func() {
+		assert.NoError(t, s.close())
+		<-quit
+	}()
+
+	var c *textproto.Conn
+	for i := 0; i < 50; i++ {
+		c, err = textproto.Dial("tcp", opt.ListenAddr)
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	_, _, err = c.ReadResponse(220)
+	require.NoError(t, err)
+
+	cmd := func(wantCode int, format string, args ...interface{}) string {
+		id, err := c.Cmd(format, args...)
+		require.NoError(t, err)
+		c.StartResponse(id)
+		defer c.EndResponse(id)
+		_, message, err := c.ReadResponse(wantCode)
+		require.NoError(t, err, format)
+		return message
+	}
+
+	// Not available before login
+	cmd(530, "XMD5 sub/hello world.txt")
+	cmd(331, "USER %s", testUSER)
+	cmd(230, "PASS %s", testPASS)
+
+	feat := cmd(211, "FEAT")
+	assert.Contains(t, feat, "MFMT")
+	assert.Contains(t, feat, " HASH\n")
+	assert.Contains(t, feat, " XMD5\n")
+
+	// Paths relative to the current directory
+	cmd(250, "CWD sub")
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", cmd(250, "XMD5 hello world.txt"))
+	assert.Equal(t, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", cmd(250, "XSHA1 /sub/hello world.txt"))
+	assert.Equal(t, "3610a686", cmd(250, `XCRC "hello world.txt" 0`))
+	cmd(504, `XCRC "hello world.txt" 1 3`)
+	assert.Equal(t, "3610a686", cmd(250, "XCRC hello world.txt 0"))
+	cmd(504, "XMD5 hello world.txt 0 3")
+	cmd(504, "XMD5 hello world.txt 2")
+	cmd(550, "XMD5 missing.txt")
+
+	// HASH uses the algorithm chosen with OPTS HASH
+	assert.Equal(t, "SHA-256 0-5 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 hello world.txt", cmd(213, "HASH hello world.txt"))
+	assert.Equal(t, "MD5", cmd(200, "OPTS HASH md5"))
+	assert.Equal(t, "MD5 0-5 5d41402abc4b2a76b9719d911017c592 hello world.txt", cmd(213, "HASH hello world.txt"))
+	cmd(501, "OPTS HASH BLAKE3")
+	cmd(250, "CDUP")
+
+	// Setting the modification time
+	assert.Equal(t, "Modify=20200102030405; sub/hello world.txt", cmd(213, "MFMT 20200102030405 sub/hello world.txt"))
+	assert.Equal(t, "20200102030405", cmd(213, "MDTM sub/hello world.txt"))
+	cmd(213, "MDTM 20210102030405 sub/hello world.txt")
+	fi, err := os.Stat(filepath.Join(dir, "sub", "hello world.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), fi.ModTime().UTC())
+	cmd(501, "MFMT yesterday sub/hello world.txt")
+	cmd(550, "MFMT 20200102030405 missing.txt")
+}
+
+// TestFTPS tests the server with implicit TLS
+func TestFTPS(t *testing.T) {
+	dir := t.TempDir()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+
+	opt := DefaultOpt
+	opt.ListenAddr = testHOST + ":" + testTLSPORT
+	opt.PassivePorts = testPASSIVEPORTRANGE
+	opt.BasicUser = testUSER
+	opt.BasicPass = testPASS
+	opt.TLSCert = "testdata/local.crt"
+	opt.TLSKey = "testdata/local.key"
+	s, err := newServer(context.Background(), f, &opt)
+	require.NoError(t, err)
+	quit := make(chan struct{})
+	go func() {
+		err := s.serve()
+		close(quit)
+		if err != ftp.ErrServerClosed {
+			assert.NoError(t, err)
+		}
+	}()
+	defer func() {
+		assert.NoError(t, s.close())
+		<-quit
+	}()
+
+	var c *ftpclient.ServerConn
+	for i := 0; i < 50; i++ {
+		c, err = ftpclient.Dial(opt.ListenAddr, ftpclient.DialWithTLS(&tls.Config{InsecureSkipVerify: true}), ftpclient.DialWithTimeout(10*time.Second))
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Quit()
+	}()
+
+	// Login sends PBSZ and PROT P which need the connection to be TLS
+	require.NoError(t, c.Login(testUSER, testPASS))
+
+	// The data connections use TLS too
+	require.NoError(t, c.Stor("file.txt", strings.NewReader("hello")))
+	data, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	r, err := c.Retr("file.txt")
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hello", string(data))
+	entries, err := c.List("/")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "file.txt", entries[0].Name)
+}
+
+// TestFTPRestartUpload tests restarting an upload with REST and the
+// permissions in listings
+func TestFTPRestartUpload(t *testing.T) {
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	oldOpt := vfsflags.Opt
+	vfsflags.Opt.CacheMode = vfscommon.CacheModeMinimal
+	vfsflags.Opt.FilePerms = 0640
+	vfsflags.Opt.DirPerms = 0750
+	defer func() {
+		vfsflags.Opt = oldOpt
+	}()
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0777))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+
+	opt := DefaultOpt
+	opt.ListenAddr = testHOST + ":" + testRestartPORT
+	opt.PassivePorts = testPASSIVEPORTRANGE
+	opt.BasicUser = testUSER
+	opt.BasicPass = testPASS
+	s, err := newServer(context.Background(), f, &opt)
+	require.NoError(t, err)
+	quit := make(chan struct{})
+	go func() {
+		err := s.serve()
+		close(quit)
+		if err != ftp.ErrServerClosed {
+			assert.NoError(t, err)
+		}
+	}()
+	defer func() {
+		assert.NoError(t, s.close())
+		<-quit
+		s.vfs.Shutdown()
+		_ = s.vfs.CleanUp()
+	}()
+
+	var c *ftpclient.ServerConn
+	for i := 0; i < 50; i++ {
+		c, err = ftpclient.Dial(opt.ListenAddr, ftpclient.DialWithTimeout(10*time.Second))
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Quit()
+	}()
+	require.NoError(t, c.Login(testUSER, testPASS))
+
+	// Restart an upload which stopped part way through
+	require.NoError(t, c.Stor("file.txt", strings.NewReader("hello")))
+	require.NoError(t, c.StorFrom("file.txt", strings.NewReader(" world"), 5))
+	r, err := c.Retr("file.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(data))
+
+	// It can't start past the end of the file
+	assert.Error(t, c.StorFrom("file.txt", strings.NewReader("!"), 100))
+	assert.Error(t, c.StorFrom("missing.txt", strings.NewReader("!"), 5))
+
+	// Files and directories have their own permissions
+	entries, err := c.List("/")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	p := s.srv.Perm
+	mode, err := p.GetMode("/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), mode)
+	mode, err = p.GetMode("/sub")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), mode)
+}
@@ -18,8 +18,11 @@ import (
 	"github.com/rclone/rclone/cmd"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/cmd/serve/share"
+	"github.com/rclone/rclone/cmd/serve/tus"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/flags"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
 	"github.com/rclone/rclone/vfs"
@@ -29,9 +32,13 @@ import (
 
 // Options required for http server
 type Options struct {
-	Auth     libhttp.AuthConfig
-	HTTP     libhttp.Config
-	Template libhttp.TemplateConfig
+	Auth       libhttp.AuthConfig
+	HTTP       libhttp.Config
+	Template   libhttp.TemplateConfig
+	AllowWrite bool     // allow uploads, deletes and making directories
+	WriteUsers []string // if set only these users may write
+	Share      share.Options
+	Tus        tus.Options
 }
 
 // DefaultOpt is the default values used for Options
@@ -39,6 +46,8 @@ var DefaultOpt = Options{
 	Auth:     libhttp.DefaultAuthCfg(),
 	HTTP:     libhttp.DefaultCfg(),
 	Template: libhttp.DefaultTemplateCfg(),
+	Share:    share.DefaultOpt,
+	Tus:      tus.DefaultOpt,
 }
 
 // Opt is options set by command line flags
@@ -55,6 +64,10 @@ func init() {
 	libhttp.AddTemplateFlagsPrefix(flagSet, flagPrefix, &Opt.Template)
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
+	flags.BoolVarP(flagSet, &Opt.AllowWrite, "allow-write", "", Opt.AllowWrite, "Allow uploading, deleting and making directories")
+	flags.StringArrayVarP(flagSet, &Opt.WriteUsers, "write-user", "", Opt.WriteUsers, "Only allow this user to write (can be repeated)")
+	share.AddFlags(flagSet, &Opt.Share)
+	tus.AddFlags(flagSet, &Opt.Tus)
 }
 
 // Command definition for cobra
@@ -72,13 +85,45 @@ The server will log errors.  Use ` + "`-v`" + ` to see access logs.
 
 ` + "`--bwlimit`" + ` will be respected for file transfers.  Use ` + "`--stats`" + ` to
 control the stats printing.
-` + libhttp.Help(flagPrefix) + libhttp.TemplateHelp(flagPrefix) + libhttp.AuthHelp(flagPrefix) + vfs.Help + proxy.Help,
+
+#### Uploads
+
+By default the server is read only. Use ` + "`--allow-write`" + ` to allow
+files to be uploaded, deleted and directories to be made. The
+directory listings will then have a form to upload files and make
+directories and a button to delete each entry.
+
+Files can also be changed without a browser:
+
+- ` + "`PUT /path/to/file`" + ` uploads the body of the request to the file
+- ` + "`PUT /path/to/dir/`" + ` makes the directory and any parents
+- ` + "`DELETE /path/to/file`" + ` deletes the file
+- ` + "`DELETE /path/to/dir/`" + ` deletes the directory if it is empty
+- ` + "`POST /path/to/dir/`" + ` with a ` + "`multipart/form-data`" + ` body uploads
+  each file in it to the directory
+
+For example
+
+    curl -u user:pass -T file.txt http://localhost:8080/dir/file.txt
+
+Use ` + "`--write-user`" + ` to only allow the users named to write - the
+other users can only read. This can be repeated. When using
+` + "`--auth-proxy`" + ` the proxy can also make a user read only by returning
+` + "`_read_only`" + ` set to ` + "`true`" + `.
+
+Form posts from pages on other sites (with an ` + "`Origin`" + ` or
+` + "`Referer`" + ` header for a different host) are refused to stop cross
+site request forgery.
+
+Be careful using ` + "`--allow-write`" + ` without authentication as
+anyone who can reach the server can then change the files.
+` + libhttp.Help(flagPrefix) + libhttp.TemplateHelp(flagPrefix) + libhttp.AuthHelp(flagPrefix) + share.Help + tus.Help + vfs.Help + proxy.Help,
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
 	},
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxyflags.Opt.AuthProxy == "" {
+		if !proxyflags.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -104,6 +149,8 @@ type HTTP struct {
 	server *libhttp.Server
 	opt    Options
 	proxy  *proxy.Proxy
+	share  *share.Server
+	tus    *tus.Handler
 	ctx    context.Context // for global config
 }
 
@@ -139,7 +186,10 @@ func run(ctx context.Context, f fs.Fs, opt Options) (s *HTTP, err error) {
 		opt: opt,
 	}
 
-	if proxyflags.Opt.AuthProxy != "" {
+	if proxyflags.Opt.Enabled() {
+		if s.opt.Share.Enabled {
+			return nil, errors.New("--share can't be used with --auth-proxy")
+		}
 		s.proxy = proxy.New(ctx, &proxyflags.Opt)
 		// override auth
 		s.opt.Auth.CustomAuthFn = s.auth
@@ -147,11 +197,15 @@ func run(ctx context.Context, f fs.Fs, opt Options) (s *HTTP, err error) {
 		s._vfs = vfs.New(f, &vfsflags.Opt)
 	}
 
-	s.server, err = libhttp.NewServer(ctx,
+	options := []libhttp.Option{
 		libhttp.WithConfig(s.opt.HTTP),
 		libhttp.WithAuth(s.opt.Auth),
 		libhttp.WithTemplate(s.opt.Template),
-	)
+	}
+	if s._vfs != nil && s.opt.Share.Enabled {
+		options = append(options, libhttp.WithPublicPrefix(share.Prefix))
+	}
+	s.server, err = libhttp.NewServer(ctx, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
@@ -163,6 +217,31 @@ func run(ctx context.Context, f fs.Fs, opt Options) (s *HTTP, err error) {
 	)
 	router.Get("/*", s.handler)
 	router.Head("/*", s.handler)
+	if s._vfs != nil && s.opt.Share.Enabled {
+		s.share, err = share.New(&s.opt.Share, s._vfs, s.server.HTMLTemplate(), s.server.URLs())
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(share.Prefix+"*", s.share)
+	}
+	if s.opt.AllowWrite {
+		if s.opt.Auth.HtPasswd == "" && s.opt.Auth.BasicUser == "" && s.opt.Auth.CustomAuthFn == nil {
+			fs.Logf(nil, "Warning: --allow-write is set without authentication so anyone can change the files")
+		}
+		router.Post("/*", s.writeHandler)
+		router.Put("/*", s.writeHandler)
+		router.Delete("/*", s.writeHandler)
+	}
+	if s.opt.Tus.Enabled {
+		if !s.opt.AllowWrite {
+			return nil, errors.New("--tus needs --allow-write")
+		}
+		s.tus, err = tus.New(&s.opt.Tus, &vfsflags.Opt, s.opt.HTTP.BaseURL, s.getVFS, s.canWrite)
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(tus.Prefix+"*", s.tus)
+	}
 
 	s.server.Serve()
 
@@ -221,6 +300,7 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 	sortParm := r.URL.Query().Get("sort")
 	orderParm := r.URL.Query().Get("order")
 	directory.ProcessQueryParams(sortParm, orderParm)
+	directory.Writable = s.canWrite(r, VFS)
 
 	// Set the Last-Modified header to the timestamp
 	w.Header().Set("Last-Modified", dir.ModTime().UTC().Format(http.TimeFormat))
@@ -294,7 +374,7 @@ func (s *HTTP) serveFile(w http.ResponseWriter, r *http.Request, remote string)
 	}()
 
 	// Account the transfer
-	tr := accounting.Stats(r.Context()).NewTransfer(obj)
+	tr := accounting.Stats(r.Context()).NewTransfer(obj, nil)
 	defer tr.Done(r.Context(), nil)
 	// FIXME in = fs.NewAccount(in, obj).WithBuffer() // account the transfer
 
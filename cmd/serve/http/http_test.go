@@ -1,14 +1,19 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	_ "github.com/rclone/rclone/backend/local"
@@ -32,12 +37,18 @@ const (
 )
 
 func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string) {
+	return startShare(ctx, t, f, false)
+}
+
+// startShare starts the test server with share links enabled if share is set
+func startShare(ctx context.Context, t *testing.T, f fs.Fs, share bool) (s *HTTP, testURL string) {
 	opts := Options{
 		HTTP: libhttp.DefaultCfg(),
 		Template: libhttp.TemplateConfig{
 			Path: testTemplate,
 		},
 	}
+	opts.Share.Enabled = share
 	opts.HTTP.ListenAddr = []string{testBindAddress}
 	if proxyflags.Opt.AuthProxy == "" {
 		opts.Auth.BasicUser = testUser
@@ -267,3 +278,206 @@ func TestGET(t *testing.T) {
 func TestAuthProxy(t *testing.T) {
 	testGET(t, true)
 }
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	startWrite := func(writeUsers []string) (*HTTP, string) {
+		opts := Options{
+			HTTP:       libhttp.DefaultCfg(),
+			AllowWrite: true,
+			WriteUsers: writeUsers,
+		}
+		opts.HTTP.ListenAddr = []string{testBindAddress}
+		opts.Auth.BasicUser = testUser
+		opts.Auth.BasicPass = testPass
+		s, err := run(ctx, f, opts)
+		require.NoError(t, err)
+		return s, s.server.URLs()[0]
+	}
+
+	s, testURL := startWrite(nil)
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	do := func(testURL, method, path string, body io.Reader, contentType string, wantStatus int) string {
+		req, err := http.NewRequest(method, testURL+path, body)
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.SetBasicAuth(testUser, testPass)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, wantStatus, resp.StatusCode, "%s %s: %s", method, path, data)
+		return string(data)
+	}
+	exists := func(remote string) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(remote)))
+		return err == nil
+	}
+
+	// PUT
+	do(testURL, "PUT", "file.txt", strings.NewReader("hello"), "", http.StatusCreated)
+	do(testURL, "PUT", "file.txt", strings.NewReader("hello world"), "", http.StatusNoContent)
+	assert.Equal(t, "hello world", do(testURL, "GET", "file.txt", nil, "", http.StatusOK))
+	do(testURL, "PUT", "dir/sub/", nil, "", http.StatusCreated)
+	do(testURL, "PUT", "dir/sub/file.txt", strings.NewReader("potato"), "", http.StatusCreated)
+	do(testURL, "PUT", "notfound/file.txt", strings.NewReader("potato"), "", http.StatusNotFound)
+	do(testURL, "PUT", "dir/../file.txt", strings.NewReader("potato"), "", http.StatusBadRequest)
+	assert.True(t, exists("dir/sub/file.txt"))
+
+	// DELETE
+	do(testURL, "DELETE", "dir/", nil, "", http.StatusConflict)
+	do(testURL, "DELETE", "dir/sub/file.txt", nil, "", http.StatusNoContent)
+	do(testURL, "DELETE", "dir/sub/", nil, "", http.StatusNoContent)
+	do(testURL, "DELETE", "dir/sub/", nil, "", http.StatusNotFound)
+	assert.False(t, exists("dir/sub"))
+
+	// The listing has the forms
+	listing := do(testURL, "GET", "dir/", nil, "", http.StatusOK)
+	assert.Contains(t, listing, `value="Upload"`)
+
+	// POST a multipart form
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = io.WriteString(fw, "contents of "+name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+	do(testURL, "POST", "dir/", &buf, mw.FormDataContentType(), http.StatusSeeOther)
+	assert.Equal(t, "contents of b.txt", do(testURL, "GET", "dir/b.txt", nil, "", http.StatusOK))
+
+	// POST the mkdir and delete forms
+	form := func(key, value string) io.Reader {
+		return strings.NewReader(url.Values{key: {value}}.Encode())
+	}
+	const formType = "application/x-www-form-urlencoded"
+	do(testURL, "POST", "dir/", form("mkdir", "new"), formType, http.StatusSeeOther)
+	assert.True(t, exists("dir/new"))
+	do(testURL, "POST", "dir/", form("mkdir", "../escape"), formType, http.StatusBadRequest)
+	do(testURL, "POST", "dir/", form("delete", "new/"), formType, http.StatusSeeOther)
+	do(testURL, "POST", "dir/", form("delete", "a.txt"), formType, http.StatusSeeOther)
+	assert.False(t, exists("dir/new"))
+	assert.False(t, exists("dir/a.txt"))
+	do(testURL, "POST", "dir/b.txt", form("delete", "a.txt"), formType, http.StatusMethodNotAllowed)
+
+	// Form posts from another site are refused
+	doOrigin := func(origin, referer string, wantStatus int) {
+		req, err := http.NewRequest("POST", testURL+"dir/", form("mkdir", "csrf"))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", formType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+		req.SetBasicAuth(testUser, testPass)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, wantStatus, resp.StatusCode, "origin=%q referer=%q", origin, referer)
+	}
+	doOrigin("http://evil.example.com", "", http.StatusForbidden)
+	doOrigin("", "http://evil.example.com/page", http.StatusForbidden)
+	doOrigin("null", "", http.StatusForbidden)
+	assert.False(t, exists("dir/csrf"))
+	doOrigin(strings.TrimSuffix(testURL, "/"), "", http.StatusSeeOther)
+	assert.True(t, exists("dir/csrf"))
+
+	// A failed overwrite leaves the existing file alone
+	req, err := http.NewRequest("PUT", testURL+"file.txt", io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("upload failed"))))
+	require.NoError(t, err)
+	req.SetBasicAuth(testUser, testPass)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".rclone-upload-") {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "hello world", do(testURL, "GET", "file.txt", nil, "", http.StatusOK))
+
+	// Only the users in --write-user may write
+	s2, testURL2 := startWrite([]string{"someone-else"})
+	defer func() {
+		assert.NoError(t, s2.server.Shutdown())
+	}()
+	do(testURL2, "PUT", "file2.txt", strings.NewReader("hello"), "", http.StatusForbidden)
+	do(testURL2, "DELETE", "file.txt", nil, "", http.StatusForbidden)
+	listing = do(testURL2, "GET", "", nil, "", http.StatusOK)
+	assert.NotContains(t, listing, `value="Upload"`)
+	assert.True(t, exists("file.txt"))
+}
+
+func TestShare(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, "testdata/files")
+	require.NoError(t, err)
+	s, testURL := startShare(ctx, t, f, true)
+	defer func() {
+		s.share.Close()
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	get := func(url string, wantStatus int) string {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, wantStatus, resp.StatusCode, url)
+		return string(body)
+	}
+
+	// Share links don't need the login
+	get(testURL+"two.txt", http.StatusUnauthorized)
+	link, err := s.share.Link("two.txt", time.Now().Add(time.Hour), "")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link, testURL+"_share/"), link)
+	assert.Equal(t, "0123456789\n", get(link, http.StatusOK))
+
+	// But everything else does
+	get(testURL+"_share/", http.StatusNotFound)
+	req, err := http.NewRequest("PUT", link, strings.NewReader("potato"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestShareDisabled(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, "testdata/files")
+	require.NoError(t, err)
+	s, testURL := start(ctx, t, f)
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+	assert.Nil(t, s.share)
+
+	// Without --share the path isn't public
+	resp, err := http.Get(testURL + "_share/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
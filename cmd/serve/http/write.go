@@ -0,0 +1,288 @@
+package http
+
+// Uploading, deleting and making directories
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs"
+)
+
+// canWrite returns whether the user making the request may change
+// the files in VFS
+func (s *HTTP) canWrite(r *http.Request, VFS *vfs.VFS) bool {
+	if !s.opt.AllowWrite || VFS.Opt.ReadOnly {
+		return false
+	}
+	if len(s.opt.WriteUsers) == 0 {
+		return true
+	}
+	user, ok := libhttp.CtxGetUser(r.Context())
+	if !ok {
+		return false
+	}
+	for _, writeUser := range s.opt.WriteUsers {
+		if user == writeUser {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError reports err from changing remote to the client
+func writeError(remote string, w http.ResponseWriter, text string, err error) {
+	switch {
+	case errors.Is(err, vfs.ENOENT):
+		http.Error(w, text+": not found", http.StatusNotFound)
+	case errors.Is(err, vfs.EEXIST), errors.Is(err, vfs.ENOTEMPTY):
+		http.Error(w, text+": "+err.Error(), http.StatusConflict)
+	case errors.Is(err, vfs.EROFS), errors.Is(err, vfs.EPERM):
+		http.Error(w, text+": "+err.Error(), http.StatusForbidden)
+	default:
+		serve.Error(remote, w, text, err)
+	}
+}
+
+// checkName returns whether name is usable as a single path element
+func checkName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// writeHandler changes files for POST, PUT and DELETE requests
+func (s *HTTP) writeHandler(w http.ResponseWriter, r *http.Request) {
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to change files: %v", err)
+		return
+	}
+	if !s.canWrite(r, VFS) {
+		fs.Infof(r.URL.Path, "%s: Write forbidden", r.RemoteAddr)
+		http.Error(w, "Write forbidden", http.StatusForbidden)
+		return
+	}
+	isDir := strings.HasSuffix(r.URL.Path, "/")
+	remote := strings.Trim(r.URL.Path, "/")
+	if remote != "" && path.Clean(remote) != remote {
+		http.Error(w, "Bad path", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "POST":
+		if !isDir {
+			http.Error(w, "Can only POST to a directory", http.StatusMethodNotAllowed)
+			return
+		}
+		s.postDir(w, r, VFS, remote)
+	case "PUT":
+		if isDir {
+			s.mkdir(w, r, VFS, remote)
+		} else {
+			s.putFile(w, r, VFS, remote)
+		}
+	case "DELETE":
+		s.delete(w, r, VFS, remote)
+	}
+}
+
+// upload writes the contents of in to the file at remote
+//
+// An existing file is replaced by uploading to a temporary name and
+// renaming that over it, so it is left alone if the upload fails. A
+// failed upload of a new file is removed so a partial file isn't left
+// behind.
+func upload(VFS *vfs.VFS, remote string, in io.Reader) (err error) {
+	target := remote
+	if _, err := VFS.Stat(remote); err == nil {
+		dir, leaf := path.Split(remote)
+		target = path.Join(dir, ".rclone-upload-"+random.String(8)+"-"+leaf)
+	}
+	fh, err := VFS.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fh, in)
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && target != remote {
+		err = VFS.Rename(target, remote)
+	}
+	if err != nil {
+		// Don't leave a partial file behind
+		_ = VFS.Remove(target)
+	}
+	return err
+}
+
+// putFile uploads the body of the request to remote
+func (s *HTTP) putFile(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, remote string) {
+	if remote == "" {
+		http.Error(w, "Can't upload to the root", http.StatusBadRequest)
+		return
+	}
+	status := http.StatusCreated
+	node, err := VFS.Stat(remote)
+	if err == nil {
+		if node.IsDir() {
+			http.Error(w, "Can't overwrite a directory", http.StatusConflict)
+			return
+		}
+		status = http.StatusNoContent
+	}
+	err = upload(VFS, remote, r.Body)
+	if err != nil {
+		writeError(remote, w, "Failed to upload file", err)
+		return
+	}
+	fs.Infof(remote, "%s: Uploaded file", r.RemoteAddr)
+	w.WriteHeader(status)
+}
+
+// mkdir makes the directory remote and any parents
+func (s *HTTP) mkdir(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, remote string) {
+	err := VFS.MkdirAll(remote, 0777)
+	if err != nil {
+		writeError(remote, w, "Failed to make directory", err)
+		return
+	}
+	fs.Infof(remote, "%s: Made directory", r.RemoteAddr)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// delete removes the file or empty directory at remote
+func (s *HTTP) delete(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, remote string) {
+	if remote == "" {
+		http.Error(w, "Can't delete the root", http.StatusForbidden)
+		return
+	}
+	err := VFS.Remove(remote)
+	if err != nil {
+		writeError(remote, w, "Failed to delete", err)
+		return
+	}
+	fs.Infof(remote, "%s: Deleted", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sameOrigin returns whether the request came from a page served by
+// this server.
+//
+// Browsers send an Origin header (or failing that a Referer) with form
+// posts, so a post from another site can be refused to stop cross site
+// request forgery. Requests with neither, which don't come from a
+// browser form, are allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// postDir handles the forms in the directory listing at dirRemote
+//
+// A multipart/form-data body uploads the files in it, otherwise the
+// "mkdir" and "delete" form values make a sub directory or delete an
+// entry.
+//
+// The client is redirected back to the listing afterwards.
+func (s *HTTP) postDir(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, dirRemote string) {
+	if !sameOrigin(r) {
+		fs.Infof(dirRemote, "%s: Refusing form post from another site", r.RemoteAddr)
+		http.Error(w, "Cross site form post forbidden", http.StatusForbidden)
+		return
+	}
+	node, err := VFS.Stat(dirRemote)
+	if err != nil {
+		writeError(dirRemote, w, "Failed to find directory", err)
+		return
+	}
+	if !node.IsDir() {
+		http.Error(w, "Not a directory", http.StatusNotFound)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err == nil {
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, "Bad multipart form", http.StatusBadRequest)
+				return
+			}
+			leaf := part.FileName()
+			if leaf == "" {
+				// not a file
+				continue
+			}
+			leaf = path.Base(strings.ReplaceAll(leaf, "\\", "/"))
+			if !checkName(leaf) {
+				http.Error(w, "Bad file name", http.StatusBadRequest)
+				return
+			}
+			remote := path.Join(dirRemote, leaf)
+			err = upload(VFS, remote, part)
+			if err != nil {
+				writeError(remote, w, "Failed to upload file", err)
+				return
+			}
+			fs.Infof(remote, "%s: Uploaded file", r.RemoteAddr)
+		}
+	} else {
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, "Bad form", http.StatusBadRequest)
+			return
+		}
+		if leaf := r.PostForm.Get("mkdir"); leaf != "" {
+			if !checkName(leaf) {
+				http.Error(w, "Bad directory name", http.StatusBadRequest)
+				return
+			}
+			remote := path.Join(dirRemote, leaf)
+			err = VFS.Mkdir(remote, 0777)
+			if err != nil {
+				writeError(remote, w, "Failed to make directory", err)
+				return
+			}
+			fs.Infof(remote, "%s: Made directory", r.RemoteAddr)
+		}
+		if leaf := strings.TrimSuffix(r.PostForm.Get("delete"), "/"); leaf != "" {
+			if !checkName(leaf) {
+				http.Error(w, "Bad name", http.StatusBadRequest)
+				return
+			}
+			remote := path.Join(dirRemote, leaf)
+			err = VFS.Remove(remote)
+			if err != nil {
+				writeError(remote, w, "Failed to delete", err)
+				return
+			}
+			fs.Infof(remote, "%s: Deleted", r.RemoteAddr)
+		}
+	}
+	// Relative so it works with --baseurl
+	w.Header().Set("Location", "./")
+	w.WriteHeader(http.StatusSeeOther)
+}
@@ -0,0 +1,272 @@
+package nfs
+
+// Open file handles kept between NFS calls
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// openFileTimeout is how long an open file is kept without being used
+const openFileTimeout = 10 * time.Second
+
+// openFile is a file opened in the VFS
+type openFile struct {
+	handle   vfs.Handle
+	write    bool      // set if opened for write
+	read     bool      // set if can be read from
+	users    int       // number of calls using the handle
+	closing  bool      // set if should be closed when no longer used
+	lastUsed time.Time // when the handle was last released
+}
+
+// openFiles caches open files by path
+//
+// NFS has no open or close calls, so files are opened on the first
+// READ or WRITE and kept open until they haven't been used for a
+// while, or a COMMIT is received for a file being written.
+type openFiles struct {
+	vfs   *vfs.VFS
+	mu    sync.Mutex
+	files map[string]*openFile
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newOpenFiles(VFS *vfs.VFS) *openFiles {
+	o := &openFiles{
+		vfs:   VFS,
+		files: map[string]*openFile{},
+		quit:  make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.reaper()
+	return o
+}
+
+// reaper closes files which haven't been used recently
+func (o *openFiles) reaper() {
+	defer o.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.quit:
+			return
+		case <-ticker.C:
+		}
+		o.mu.Lock()
+		for p, file := range o.files {
+			if file.users == 0 && time.Since(file.lastUsed) > openFileTimeout {
+				delete(o.files, p)
+				go o.closeFile(p, file)
+			}
+		}
+		o.mu.Unlock()
+	}
+}
+
+// closeFile closes the handle
+func (o *openFiles) closeFile(p string, file *openFile) error {
+	err := file.handle.Close()
+	if err != nil {
+		fs.Errorf(p, "NFS failed to close file: %v", err)
+	}
+	return err
+}
+
+// release finishes using file closing it if needed
+func (o *openFiles) release(p string, file *openFile) {
+	o.mu.Lock()
+	file.users--
+	file.lastUsed = time.Now()
+	closeNow := file.closing && file.users == 0
+	o.mu.Unlock()
+	if closeNow {
+		_ = o.closeFile(p, file)
+	}
+}
+
+// detach removes the file at p from the cache returning it if it
+// should be closed now
+//
+// Call with the lock held
+func (o *openFiles) detach(p string) *openFile {
+	file, ok := o.files[p]
+	if !ok {
+		return nil
+	}
+	delete(o.files, p)
+	if file.users > 0 {
+		// close when the last user has finished with it
+		file.closing = true
+		return nil
+	}
+	return file
+}
+
+// acquire returns an open file for node suitable for reading or
+// writing which must be released after use
+func (o *openFiles) acquire(node vfs.Node, write bool) (*openFile, error) {
+	p := node.Path()
+	o.mu.Lock()
+	file, ok := o.files[p]
+	if ok && (write && file.write || !write && file.read) {
+		file.users++
+		o.mu.Unlock()
+		return file, nil
+	}
+	// The file is open in the wrong mode so close it first
+	old := o.detach(p)
+	o.mu.Unlock()
+	if old != nil {
+		_ = o.closeFile(p, old)
+	}
+
+	file = &openFile{users: 1}
+	flags := os.O_RDONLY
+	if write {
+		file.write = true
+		if o.vfs.Opt.CacheMode >= vfscommon.CacheModeWrites {
+			flags = os.O_RDWR
+			file.read = true
+		} else {
+			flags = os.O_WRONLY
+			// Without the cache, files can only be written
+			// from the start so allow empty files to be
+			// truncated.
+			if node.Size() == 0 {
+				flags |= os.O_TRUNC
+			}
+		}
+	} else {
+		file.read = true
+	}
+	handle, err := node.Open(flags)
+	if err != nil {
+		return nil, err
+	}
+	file.handle = handle
+	o.add(p, file)
+	return file, nil
+}
+
+// add file to the cache under p closing any file already there
+func (o *openFiles) add(p string, file *openFile) {
+	o.mu.Lock()
+	old := o.detach(p)
+	o.files[p] = file
+	o.mu.Unlock()
+	if old != nil {
+		_ = o.closeFile(p, old)
+	}
+}
+
+// readAt reads from the file at node
+func (o *openFiles) readAt(node vfs.Node, buf []byte, offset int64) (int, error) {
+	file, err := o.acquire(node, false)
+	if err != nil {
+		return 0, err
+	}
+	defer o.release(node.Path(), file)
+	return file.handle.ReadAt(buf, offset)
+}
+
+// writeAt writes to the file at node
+func (o *openFiles) writeAt(node vfs.Node, buf []byte, offset int64) (int, error) {
+	file, err := o.acquire(node, true)
+	if err != nil {
+		return 0, err
+	}
+	defer o.release(node.Path(), file)
+	return file.handle.WriteAt(buf, offset)
+}
+
+// create makes a new empty file at p keeping it open for writing
+func (o *openFiles) create(p string) error {
+	file := &openFile{write: true}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if o.vfs.Opt.CacheMode >= vfscommon.CacheModeWrites {
+		flags = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		file.read = true
+	}
+	handle, err := o.vfs.OpenFile(p, flags, 0777)
+	if err != nil {
+		return err
+	}
+	file.handle = handle
+	file.lastUsed = time.Now()
+	o.add(p, file)
+	return nil
+}
+
+// truncate sets the size of the file at node
+func (o *openFiles) truncate(node vfs.Node, size int64) error {
+	p := node.Path()
+	o.mu.Lock()
+	file, ok := o.files[p]
+	isWriting := ok && file.write
+	o.mu.Unlock()
+	// With the cache the file may not be uploaded yet so truncate
+	// it through a handle rather than the node.
+	if isWriting || o.vfs.Opt.CacheMode >= vfscommon.CacheModeWrites {
+		file, err := o.acquire(node, true)
+		if err != nil {
+			return err
+		}
+		defer o.release(p, file)
+		return file.handle.Truncate(size)
+	}
+	_ = o.close(p)
+	return node.Truncate(size)
+}
+
+// commit makes sure the data written to the file at p is saved
+//
+// With the VFS cache the file is closed which uploads it. Without
+// the cache the upload can only be finished when the writes stop, so
+// this is left to the file being closed when it isn't used.
+func (o *openFiles) commit(p string) error {
+	if o.vfs.Opt.CacheMode < vfscommon.CacheModeWrites {
+		return nil
+	}
+	return o.close(p)
+}
+
+// close the file at p if it is open
+func (o *openFiles) close(p string) error {
+	o.mu.Lock()
+	file := o.detach(p)
+	o.mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	return o.closeFile(p, file)
+}
+
+// closeUnder closes all the open files at or under p
+func (o *openFiles) closeUnder(p string) {
+	o.mu.Lock()
+	var toClose []string
+	for filePath := range o.files {
+		if p == "" || isUnder(filePath, p) {
+			toClose = append(toClose, filePath)
+		}
+	}
+	o.mu.Unlock()
+	for _, filePath := range toClose {
+		_ = o.close(filePath)
+	}
+}
+
+// shutdown closes all the open files
+func (o *openFiles) shutdown() {
+	close(o.quit)
+	o.wg.Wait()
+	o.closeUnder("")
+}
@@ -0,0 +1,416 @@
+package nfs
+
+// NFS file handles which stay valid across restarts of the server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+const (
+	handleSize = 8     // bytes in a file handle
+	rootID     = 1     // id of the root directory
+	handleKV   = "nfs" // kv facility for the handle cache
+)
+
+// errStale is returned for handles which don't refer to anything
+var errStale = errors.New("stale file handle")
+
+// handleCache maps file handles to and from paths in the VFS
+//
+// Each path is given an id when its handle is first needed, and the
+// id stays with the path until it is removed or renamed.
+type handleCache interface {
+	// toID returns the id for path, allocating one if needed
+	toID(path string) (uint64, error)
+	// toIDs returns the ids for paths, allocating them if needed
+	toIDs(paths []string) ([]uint64, error)
+	// toPath returns the path for id or errStale
+	toPath(id uint64) (string, error)
+	// rename moves the ids of oldPath and everything under it to
+	// newPath
+	rename(oldPath, newPath string) error
+	// remove the id for path
+	remove(path string) error
+	// close the cache
+	close() error
+}
+
+// encodeHandle returns the file handle for id
+func encodeHandle(id uint64) []byte {
+	fh := make([]byte, handleSize)
+	binary.BigEndian.PutUint64(fh, id)
+	return fh
+}
+
+// decodeHandle returns the id in the file handle
+func decodeHandle(fh []byte) (uint64, error) {
+	if len(fh) != handleSize {
+		return 0, errBadHandle
+	}
+	return binary.BigEndian.Uint64(fh), nil
+}
+
+// isUnder returns true if p is dir or is inside it
+func isUnder(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// memoryHandles keeps the handles in memory only
+type memoryHandles struct {
+	mu     sync.Mutex
+	next   uint64
+	byID   map[uint64]string
+	byPath map[string]uint64
+}
+
+func newMemoryHandles() *memoryHandles {
+	return &memoryHandles{
+		next:   rootID + 1,
+		byID:   map[uint64]string{},
+		byPath: map[string]uint64{},
+	}
+}
+
+func (m *memoryHandles) toID(p string) (uint64, error) {
+	if p == "" {
+		return rootID, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.byPath[p]
+	if !ok {
+		id = m.next
+		m.next++
+		m.byPath[p] = id
+		m.byID[id] = p
+	}
+	return id, nil
+}
+
+func (m *memoryHandles) toIDs(paths []string) ([]uint64, error) {
+	ids := make([]uint64, len(paths))
+	for i, p := range paths {
+		ids[i], _ = m.toID(p)
+	}
+	return ids, nil
+}
+
+// lookup returns the id of p or 0 if it hasn't got one
+func (m *memoryHandles) lookup(p string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.byPath[p]
+}
+
+// add sets the id of p
+func (m *memoryHandles) add(p string, id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byPath[p] = id
+	m.byID[id] = p
+}
+
+func (m *memoryHandles) toPath(id uint64) (string, error) {
+	if id == rootID {
+		return "", nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.byID[id]
+	if !ok {
+		return "", errStale
+	}
+	return p, nil
+}
+
+func (m *memoryHandles) rename(oldPath, newPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lockedRemove(newPath)
+	for p, id := range m.byPath {
+		if isUnder(p, oldPath) {
+			delete(m.byPath, p)
+			p = newPath + p[len(oldPath):]
+			m.byPath[p] = id
+			m.byID[id] = p
+		}
+	}
+	return nil
+}
+
+func (m *memoryHandles) lockedRemove(p string) {
+	if id, ok := m.byPath[p]; ok {
+		delete(m.byPath, p)
+		delete(m.byID, id)
+	}
+}
+
+func (m *memoryHandles) remove(p string) error {
+	m.mu.Lock()
+	m.lockedRemove(p)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryHandles) close() error {
+	return nil
+}
+
+// kvHandles keeps the handles in a kv database so they persist across
+// restarts, with a memory cache in front of it.
+//
+// The database is shared by all the roots of a remote so the full
+// path of each file on the remote is stored.
+type kvHandles struct {
+	db    *kv.DB
+	root  string // root of the remote served
+	cache *memoryHandles
+}
+
+func newKVHandles(ctx context.Context, f fs.Fs) (*kvHandles, error) {
+	db, err := kv.Start(ctx, handleKV, f)
+	if err != nil {
+		return nil, err
+	}
+	return &kvHandles{
+		db:    db,
+		root:  f.Root(),
+		cache: newMemoryHandles(),
+	}, nil
+}
+
+// Keys used in the kv database
+const (
+	kvNextKey    = "next"
+	kvPathPrefix = "p:"
+	kvIDPrefix   = "i:"
+)
+
+func kvIDKey(id uint64) []byte {
+	return append([]byte(kvIDPrefix), encodeHandle(id)...)
+}
+
+func kvPathKey(p string) []byte {
+	return []byte(kvPathPrefix + p)
+}
+
+// full returns the path on the remote of the VFS path p
+func (h *kvHandles) full(p string) string {
+	if h.root == "" {
+		return p
+	}
+	return h.root + "/" + p
+}
+
+// kvToIDs looks up the ids of paths, allocating them if not found
+// and allocate is set
+type kvToIDs struct {
+	paths    []string
+	ids      []uint64 // 0 if not found
+	allocate bool
+}
+
+func (op *kvToIDs) Do(ctx context.Context, b kv.Bucket) error {
+	op.ids = make([]uint64, len(op.paths))
+	next := uint64(0)
+	for i, p := range op.paths {
+		if data := b.Get(kvPathKey(p)); len(data) == handleSize {
+			op.ids[i] = binary.BigEndian.Uint64(data)
+			continue
+		}
+		if !op.allocate {
+			continue
+		}
+		if next == 0 {
+			next = rootID + 1
+			if data := b.Get([]byte(kvNextKey)); len(data) == handleSize {
+				next = binary.BigEndian.Uint64(data)
+			}
+		}
+		op.ids[i] = next
+		next++
+		if err := b.Put(kvPathKey(p), encodeHandle(op.ids[i])); err != nil {
+			return err
+		}
+		if err := b.Put(kvIDKey(op.ids[i]), []byte(p)); err != nil {
+			return err
+		}
+	}
+	if next != 0 {
+		return b.Put([]byte(kvNextKey), encodeHandle(next))
+	}
+	return nil
+}
+
+// missing returns true if any of the ids weren't found
+func (op *kvToIDs) missing() bool {
+	for _, id := range op.ids {
+		if id == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// kvToPath looks up the path of id
+type kvToPath struct {
+	id    uint64
+	path  string
+	found bool
+}
+
+func (op *kvToPath) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get(kvIDKey(op.id))
+	op.path, op.found = string(data), data != nil
+	return nil
+}
+
+// kvRename moves the ids of oldPath and the paths under it
+type kvRename struct {
+	oldPath string
+	newPath string
+}
+
+func (op *kvRename) Do(ctx context.Context, b kv.Bucket) error {
+	if err := kvRemovePath(b, op.newPath); err != nil {
+		return err
+	}
+	type entry struct {
+		path string
+		id   []byte
+	}
+	var moves []entry
+	if id := b.Get(kvPathKey(op.oldPath)); id != nil {
+		moves = append(moves, entry{op.oldPath, append([]byte{}, id...)})
+	}
+	prefix := kvPathKey(op.oldPath + "/")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		moves = append(moves, entry{string(k[len(kvPathPrefix):]), append([]byte{}, v...)})
+	}
+	for _, move := range moves {
+		newPath := op.newPath + move.path[len(op.oldPath):]
+		if err := b.Delete(kvPathKey(move.path)); err != nil {
+			return err
+		}
+		if err := b.Put(kvPathKey(newPath), move.id); err != nil {
+			return err
+		}
+		if err := b.Put(append([]byte(kvIDPrefix), move.id...), []byte(newPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvRemove removes the id of path
+type kvRemove struct {
+	path string
+}
+
+func (op *kvRemove) Do(ctx context.Context, b kv.Bucket) error {
+	return kvRemovePath(b, op.path)
+}
+
+func kvRemovePath(b kv.Bucket, p string) error {
+	id := b.Get(kvPathKey(p))
+	if id == nil {
+		return nil
+	}
+	if err := b.Delete(append([]byte(kvIDPrefix), id...)); err != nil {
+		return err
+	}
+	return b.Delete(kvPathKey(p))
+}
+
+func (h *kvHandles) toID(p string) (uint64, error) {
+	ids, err := h.toIDs([]string{p})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (h *kvHandles) toIDs(paths []string) ([]uint64, error) {
+	ids := make([]uint64, len(paths))
+	var missing []int
+	for i, p := range paths {
+		if p == "" {
+			ids[i] = rootID
+		} else if ids[i] = h.cache.lookup(p); ids[i] == 0 {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return ids, nil
+	}
+	op := &kvToIDs{}
+	for _, i := range missing {
+		op.paths = append(op.paths, h.full(paths[i]))
+	}
+	// Try a read first as writes are expensive
+	err := h.db.Do(false, op)
+	if err != nil && err != kv.ErrEmpty {
+		return nil, err
+	}
+	if err == kv.ErrEmpty || op.missing() {
+		op.allocate = true
+		err = h.db.Do(true, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for j, i := range missing {
+		ids[i] = op.ids[j]
+		h.cache.add(paths[i], ids[i])
+	}
+	return ids, nil
+}
+
+func (h *kvHandles) toPath(id uint64) (string, error) {
+	if p, err := h.cache.toPath(id); err == nil {
+		return p, nil
+	}
+	op := &kvToPath{id: id}
+	err := h.db.Do(false, op)
+	if err == kv.ErrEmpty || (err == nil && !op.found) {
+		return "", errStale
+	} else if err != nil {
+		return "", err
+	}
+	p := op.path
+	if h.root != "" {
+		if !strings.HasPrefix(p, h.root+"/") {
+			// handle for a file outside the root served
+			return "", errStale
+		}
+		p = p[len(h.root)+1:]
+	}
+	h.cache.add(p, id)
+	return p, nil
+}
+
+func (h *kvHandles) rename(oldPath, newPath string) error {
+	_ = h.cache.rename(oldPath, newPath)
+	return h.db.Do(true, &kvRename{oldPath: h.full(oldPath), newPath: h.full(newPath)})
+}
+
+func (h *kvHandles) remove(p string) error {
+	_ = h.cache.remove(p)
+	err := h.db.Do(true, &kvRemove{path: h.full(p)})
+	if err == kv.ErrEmpty {
+		err = nil
+	}
+	return err
+}
+
+func (h *kvHandles) close() error {
+	return h.db.Stop(false)
+}
@@ -0,0 +1,71 @@
+package nfs
+
+// MOUNT version 3 as described in RFC 1813 appendix I
+
+const (
+	mountProgram = 100005
+	mountVersion = 3
+)
+
+// MOUNT procedures
+const (
+	mountProcNull    = 0
+	mountProcMnt     = 1
+	mountProcDump    = 2
+	mountProcUmnt    = 3
+	mountProcUmntall = 4
+	mountProcExport  = 5
+)
+
+// MOUNT status codes
+const (
+	mountOK       = 0
+	mountErrNoEnt = 2
+	mountErrIO    = 5
+)
+
+// exportPath is the only path exported
+const exportPath = "/"
+
+// mountHandler handles the MOUNT program
+//
+// Only the root of the VFS is exported, but clients may mount any
+// directory inside it.
+func (s *server) mountHandler(call *rpcCall, w *xdrWriter) error {
+	r := call.args
+	switch call.proc {
+	case mountProcNull, mountProcUmntall:
+	case mountProcMnt:
+		dirPath := r.string(maxPathSize)
+		if r.err != nil {
+			return r.err
+		}
+		node, err := s.vfs.Stat(dirPath)
+		if err != nil || !node.IsDir() {
+			w.uint32(mountErrNoEnt)
+			return nil
+		}
+		id, err := s.handles.toID(node.Path())
+		if err != nil {
+			w.uint32(mountErrIO)
+			return nil
+		}
+		w.uint32(mountOK)
+		w.opaque(encodeHandle(id))
+		w.uint32(2) // auth flavors
+		w.uint32(authUnix)
+		w.uint32(authNone)
+	case mountProcDump:
+		w.bool(false) // no mounts listed
+	case mountProcUmnt:
+		_ = r.string(maxPathSize)
+	case mountProcExport:
+		w.bool(true)
+		w.string(exportPath)
+		w.bool(false) // no groups
+		w.bool(false) // no more exports
+	default:
+		return errProcUnavail
+	}
+	return r.err
+}
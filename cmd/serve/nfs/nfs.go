@@ -0,0 +1,117 @@
+// Package nfs implements an NFSv3 server to serve an rclone VFS
+package nfs
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Options contains options for the NFS Server
+type Options struct {
+	ListenAddr  string // Port to listen on
+	HandleCache string // where to keep the file handles - disk or memory
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	ListenAddr:  "localhost:2049",
+	HandleCache: "disk",
+}
+
+// Opt is options set by command line flags
+var Opt = DefaultOpt
+
+// AddFlags adds flags for the nfs
+func AddFlags(flagSet *pflag.FlagSet) {
+	rc.AddOption("nfs", &Opt)
+	flags.StringVarP(flagSet, &Opt.ListenAddr, "addr", "", Opt.ListenAddr, "IPaddress:Port or :Port to bind server to")
+	flags.StringVarP(flagSet, &Opt.HandleCache, "handle-cache", "", Opt.HandleCache, "Where to keep the NFS file handles: disk or memory")
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags())
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "nfs remote:path",
+	Short: `Serve remote:path over NFS.`,
+	Long: `
+Run an NFSv3 server to serve a remote over the NFS protocol.
+
+This can be mounted with the standard NFS client of the operating
+system so is an alternative to ` + "`rclone mount`" + ` where FUSE isn't
+available or wanted.
+
+The MOUNT protocol is served on the same port as NFS and no portmapper
+or lock manager is run, so the client needs to be told the ports and
+not to use locking. For example on Linux
+
+    rclone serve nfs remote: --addr :2049 --vfs-cache-mode writes
+    mount -t nfs -o port=2049,mountport=2049,mountproto=tcp,nfsvers=3,tcp,nolock localhost:/ /mnt/rclone
+
+and on macOS
+
+    mount -t nfs -o port=2049,mountport=2049,tcp,nolocks,vers=3 localhost:/ /mnt/rclone
+
+### Server options
+
+Use --addr to specify which IP address and port the server should
+listen on, e.g. --addr 1.2.3.4:2049 or --addr :2049 to listen to all
+IPs.  By default it only listens on localhost.
+
+There is no authentication, so if you set --addr to listen on a public
+or LAN accessible IP address then anyone who can connect to it can
+read and write the remote. Use --read-only to stop writes.
+
+#### File handles
+
+NFS clients refer to files with file handles which need to stay the
+same for as long as the files exist, including across restarts of the
+server, otherwise clients get "Stale file handle" errors.
+
+With --handle-cache disk (the default) the file handles are kept in a
+database in the rclone cache directory, one per remote, so they
+persist across restarts. Use --handle-cache memory to keep them in
+memory only.
+
+#### VFS cache mode
+
+NFS has no open or close operations, so files are opened when first
+read or written and closed when they haven't been used for a few
+seconds.
+
+Writing files without a VFS cache only works when files are written
+sequentially from the start, so it is strongly recommended to use
+` + "`--vfs-cache-mode writes`" + ` or ` + "`--vfs-cache-mode full`" + `. With the
+cache the file is uploaded when the client commits the file, which
+it normally does when the file is closed.
+` + vfs.Help,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt)
+			if err != nil {
+				return err
+			}
+			err = s.Serve()
+			if err != nil {
+				return err
+			}
+			s.Wait()
+			return nil
+		})
+	},
+}
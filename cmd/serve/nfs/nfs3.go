@@ -0,0 +1,834 @@
+package nfs
+
+// NFS version 3 as described in RFC 1813
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/vfs"
+)
+
+const (
+	nfsProgram = 100003
+	nfsVersion = 3
+
+	// maxTransferSize is the largest READ or WRITE
+	maxTransferSize = 1024 * 1024
+
+	maxHandleSize = 64  // largest nfs_fh3 accepted
+	maxNameSize   = 255 // longest file name accepted
+	maxPathSize   = 1024
+)
+
+// NFSv3 procedures
+const (
+	nfsProcNull        = 0
+	nfsProcGetattr     = 1
+	nfsProcSetattr     = 2
+	nfsProcLookup      = 3
+	nfsProcAccess      = 4
+	nfsProcReadlink    = 5
+	nfsProcRead        = 6
+	nfsProcWrite       = 7
+	nfsProcCreate      = 8
+	nfsProcMkdir       = 9
+	nfsProcSymlink     = 10
+	nfsProcMknod       = 11
+	nfsProcRemove      = 12
+	nfsProcRmdir       = 13
+	nfsProcRename      = 14
+	nfsProcLink        = 15
+	nfsProcReaddir     = 16
+	nfsProcReaddirplus = 17
+	nfsProcFsstat      = 18
+	nfsProcFsinfo      = 19
+	nfsProcPathconf    = 20
+	nfsProcCommit      = 21
+)
+
+// NFSv3 status codes
+const (
+	nfsOK             = 0
+	nfsErrPerm        = 1
+	nfsErrNoEnt       = 2
+	nfsErrIO          = 5
+	nfsErrAccess      = 13
+	nfsErrExist       = 17
+	nfsErrNotDir      = 20
+	nfsErrIsDir       = 21
+	nfsErrInval       = 22
+	nfsErrNoSpc       = 28
+	nfsErrRofs        = 30
+	nfsErrNameTooLong = 63
+	nfsErrNotEmpty    = 66
+	nfsErrStale       = 70
+	nfsErrBadHandle   = 10001
+	nfsErrNotSupp     = 10004
+	nfsErrServerFault = 10006
+)
+
+// File types
+const (
+	nfsTypeReg = 1
+	nfsTypeDir = 2
+)
+
+// ACCESS bits
+const (
+	accessRead    = 0x01
+	accessLookup  = 0x02
+	accessModify  = 0x04
+	accessExtend  = 0x08
+	accessDelete  = 0x10
+	accessExecute = 0x20
+)
+
+// Values of stable_how and createmode3
+const (
+	writeUnstable = 0
+
+	createUnchecked = 0
+	createGuarded   = 1
+	createExclusive = 2
+)
+
+// Values of time_how in sattr3
+const (
+	timeDontChange = 0
+	timeServer     = 1
+	timeClient     = 2
+)
+
+// FSINFO properties
+const (
+	fsfHomogeneous = 0x0008
+	fsfCanSetTime  = 0x0010
+)
+
+// Errors used to return particular status codes
+var (
+	errBadHandle   = errors.New("bad file handle")
+	errNotDir      = errors.New("not a directory")
+	errIsDir       = errors.New("is a directory")
+	errNameTooLong = errors.New("file name too long")
+	errNotSupp     = errors.New("operation not supported")
+)
+
+// nfsStatus translates err into an NFSv3 status code
+func nfsStatus(err error) uint32 {
+	if err == nil {
+		return nfsOK
+	}
+	_, uErr := fserrors.Cause(err)
+	switch uErr {
+	case vfs.OK:
+		return nfsOK
+	case errStale:
+		return nfsErrStale
+	case errBadHandle:
+		return nfsErrBadHandle
+	case errNotDir:
+		return nfsErrNotDir
+	case errIsDir:
+		return nfsErrIsDir
+	case errNameTooLong:
+		return nfsErrNameTooLong
+	case vfs.ENOENT, fs.ErrorDirNotFound, fs.ErrorObjectNotFound:
+		return nfsErrNoEnt
+	case vfs.EEXIST, fs.ErrorDirExists:
+		return nfsErrExist
+	case vfs.EPERM, fs.ErrorPermissionDenied:
+		return nfsErrPerm
+	case vfs.ENOTEMPTY, fs.ErrorDirectoryNotEmpty:
+		return nfsErrNotEmpty
+	case vfs.EROFS:
+		return nfsErrRofs
+	case vfs.EINVAL:
+		return nfsErrInval
+	case vfs.ENOSPC:
+		return nfsErrNoSpc
+	case vfs.ENOSYS, errNotSupp, fs.ErrorNotImplemented:
+		return nfsErrNotSupp
+	case vfs.ECLOSED, vfs.EBADF, vfs.ESPIPE:
+		return nfsErrIO
+	}
+	fs.Errorf(nil, "NFS IO error: %v", err)
+	return nfsErrIO
+}
+
+// joinPath returns the path of name in dir
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// checkName checks a file name sent by the client is usable
+func checkName(name string) error {
+	switch {
+	case len(name) > maxNameSize:
+		return errNameTooLong
+	case name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/'):
+		return vfs.EINVAL
+	}
+	return nil
+}
+
+// writeTime writes t as an nfstime3
+func writeTime(w *xdrWriter, t time.Time) {
+	w.uint32(uint32(t.Unix()))
+	w.uint32(uint32(t.Nanosecond()))
+}
+
+// writeFattr writes the attributes of node as a fattr3
+func (s *server) writeFattr(w *xdrWriter, node vfs.Node, id uint64) {
+	modTime := node.ModTime()
+	if node.IsDir() {
+		w.uint32(nfsTypeDir)
+		w.uint32(uint32(node.Mode().Perm()))
+		w.uint32(2) // nlink
+	} else {
+		w.uint32(nfsTypeReg)
+		w.uint32(uint32(node.Mode().Perm()))
+		w.uint32(1) // nlink
+	}
+	w.uint32(s.vfs.Opt.UID)
+	w.uint32(s.vfs.Opt.GID)
+	w.uint64(uint64(node.Size())) // size
+	w.uint64(uint64(node.Size())) // used
+	w.uint32(0)                   // rdev
+	w.uint32(0)
+	w.uint64(s.fsid)
+	w.uint64(id)
+	writeTime(w, modTime) // atime
+	writeTime(w, modTime) // mtime
+	writeTime(w, modTime) // ctime
+}
+
+// writeAttr writes the post_op_attr for node
+func (s *server) writeAttr(w *xdrWriter, node vfs.Node) {
+	if node == nil {
+		w.bool(false)
+		return
+	}
+	id, err := s.handles.toID(node.Path())
+	if err != nil {
+		fs.Errorf(node.Path(), "NFS failed to make file handle: %v", err)
+		w.bool(false)
+		return
+	}
+	w.bool(true)
+	s.writeFattr(w, node, id)
+}
+
+// writePathAttr writes the post_op_attr for the path p
+func (s *server) writePathAttr(w *xdrWriter, p string) {
+	node, err := s.vfs.Stat(p)
+	if err != nil {
+		node = nil
+	}
+	s.writeAttr(w, node)
+}
+
+// writeWcc writes the wcc_data for the path p
+//
+// The attributes before the operation aren't kept so only the ones
+// after are sent.
+func (s *server) writeWcc(w *xdrWriter, p string) {
+	w.bool(false)
+	s.writePathAttr(w, p)
+}
+
+// writeHandle writes the post_op_fh3 for the path p
+func (s *server) writeHandle(w *xdrWriter, p string) {
+	id, err := s.handles.toID(p)
+	if err != nil {
+		fs.Errorf(p, "NFS failed to make file handle: %v", err)
+		w.bool(false)
+		return
+	}
+	w.bool(true)
+	w.opaque(encodeHandle(id))
+}
+
+// readHandle reads a file handle returning its path
+func (s *server) readHandle(r *xdrReader) (string, error) {
+	fh := r.opaque(maxHandleSize)
+	if r.err != nil {
+		return "", r.err
+	}
+	id, err := decodeHandle(fh)
+	if err != nil {
+		return "", err
+	}
+	return s.handles.toPath(id)
+}
+
+// readDirOp reads a diropargs3 returning the path of the directory
+// and the name in it
+func (s *server) readDirOp(r *xdrReader) (dir, name string, err error) {
+	dir, err = s.readHandle(r)
+	name = r.string(maxPathSize)
+	if r.err != nil {
+		return "", "", r.err
+	}
+	return dir, name, err
+}
+
+// sattr is a decoded sattr3
+type sattr struct {
+	setSize  bool
+	size     uint64
+	mtimeHow uint32
+	mtime    time.Time
+}
+
+// readSattr reads a sattr3
+//
+// Modes and owners can't be changed in the VFS so are ignored.
+func readSattr(r *xdrReader) (attr sattr) {
+	if r.bool() {
+		_ = r.uint32() // mode
+	}
+	if r.bool() {
+		_ = r.uint32() // uid
+	}
+	if r.bool() {
+		_ = r.uint32() // gid
+	}
+	if attr.setSize = r.bool(); attr.setSize {
+		attr.size = r.uint64()
+	}
+	if r.uint32() == timeClient { // atime
+		_ = r.uint64()
+	}
+	if attr.mtimeHow = r.uint32(); attr.mtimeHow == timeClient {
+		attr.mtime = time.Unix(int64(r.uint32()), int64(r.uint32()))
+	}
+	return attr
+}
+
+// setAttr sets the attributes in attr on the file at p
+func (s *server) setAttr(p string, attr sattr) error {
+	node, err := s.vfs.Stat(p)
+	if err != nil {
+		return err
+	}
+	if attr.setSize {
+		if node.IsDir() {
+			return errIsDir
+		}
+		err = s.files.truncate(node, int64(attr.size))
+		if err != nil {
+			return err
+		}
+	}
+	switch attr.mtimeHow {
+	case timeServer:
+		err = node.SetModTime(time.Now())
+	case timeClient:
+		err = node.SetModTime(attr.mtime)
+	}
+	return err
+}
+
+// nfsHandler handles the NFSv3 program
+func (s *server) nfsHandler(call *rpcCall, w *xdrWriter) error {
+	r := call.args
+	switch call.proc {
+	case nfsProcNull:
+		return nil
+	case nfsProcGetattr:
+		s.getattr(r, w)
+	case nfsProcSetattr:
+		s.setattr(r, w)
+	case nfsProcLookup:
+		s.lookup(r, w)
+	case nfsProcAccess:
+		s.access(r, w)
+	case nfsProcReadlink:
+		w.uint32(nfsErrNotSupp)
+		w.bool(false)
+	case nfsProcRead:
+		s.read(r, w)
+	case nfsProcWrite:
+		s.write(r, w)
+	case nfsProcCreate:
+		s.create(r, w)
+	case nfsProcMkdir:
+		s.mkdir(r, w)
+	case nfsProcSymlink, nfsProcMknod:
+		dir, _, _ := s.readDirOp(r)
+		w.uint32(nfsErrNotSupp)
+		s.writeWcc(w, dir)
+	case nfsProcRemove, nfsProcRmdir:
+		s.remove(r, w, call.proc == nfsProcRmdir)
+	case nfsProcRename:
+		s.rename(r, w)
+	case nfsProcLink:
+		p, _ := s.readHandle(r)
+		dir, _, _ := s.readDirOp(r)
+		w.uint32(nfsErrNotSupp)
+		s.writePathAttr(w, p)
+		s.writeWcc(w, dir)
+	case nfsProcReaddir:
+		s.readdir(r, w, false)
+	case nfsProcReaddirplus:
+		s.readdir(r, w, true)
+	case nfsProcFsstat:
+		s.fsstat(r, w)
+	case nfsProcFsinfo:
+		s.fsinfo(r, w)
+	case nfsProcPathconf:
+		s.pathconf(r, w)
+	case nfsProcCommit:
+		s.commit(r, w)
+	default:
+		return errProcUnavail
+	}
+	return r.err
+}
+
+// statHandle reads a file handle and returns its path and node
+func (s *server) statHandle(r *xdrReader) (string, vfs.Node, error) {
+	p, err := s.readHandle(r)
+	if err != nil {
+		return p, nil, err
+	}
+	node, err := s.vfs.Stat(p)
+	if err == vfs.ENOENT {
+		// the handle is for something which has gone away
+		_ = s.handles.remove(p)
+		err = errStale
+	}
+	return p, node, err
+}
+
+func (s *server) getattr(r *xdrReader, w *xdrWriter) {
+	p, node, err := s.statHandle(r)
+	var id uint64
+	if err == nil {
+		id, err = s.handles.toID(p)
+	}
+	w.uint32(nfsStatus(err))
+	if err == nil {
+		s.writeFattr(w, node, id)
+	}
+}
+
+func (s *server) setattr(r *xdrReader, w *xdrWriter) {
+	p, err := s.readHandle(r)
+	attr := readSattr(r)
+	if r.bool() { // guard
+		_ = r.uint64() // ctime
+	}
+	if r.err != nil {
+		return
+	}
+	if err == nil {
+		err = s.setAttr(p, attr)
+	}
+	w.uint32(nfsStatus(err))
+	s.writeWcc(w, p)
+}
+
+func (s *server) lookup(r *xdrReader, w *xdrWriter) {
+	dir, name, err := s.readDirOp(r)
+	if r.err != nil {
+		return
+	}
+	var p string
+	var node vfs.Node
+	if err == nil {
+		switch name {
+		case ".":
+			p = dir
+		case "..":
+			if p = path.Dir(dir); p == "." {
+				p = ""
+			}
+		default:
+			err = checkName(name)
+			p = joinPath(dir, name)
+		}
+	}
+	if err == nil {
+		node, err = s.vfs.Stat(p)
+	}
+	var id uint64
+	if err == nil {
+		id, err = s.handles.toID(p)
+	}
+	w.uint32(nfsStatus(err))
+	if err != nil {
+		s.writePathAttr(w, dir)
+		return
+	}
+	w.opaque(encodeHandle(id))
+	w.bool(true)
+	s.writeFattr(w, node, id)
+	s.writePathAttr(w, dir)
+}
+
+func (s *server) access(r *xdrReader, w *xdrWriter) {
+	_, node, err := s.statHandle(r)
+	access := r.uint32()
+	if r.err != nil {
+		return
+	}
+	w.uint32(nfsStatus(err))
+	s.writeAttr(w, node)
+	if err != nil {
+		return
+	}
+	if s.vfs.Opt.ReadOnly {
+		access &^= accessModify | accessExtend | accessDelete
+	}
+	if !node.IsDir() {
+		access &^= accessLookup
+	}
+	w.uint32(access & (accessRead | accessLookup | accessModify | accessExtend | accessDelete | accessExecute))
+}
+
+func (s *server) read(r *xdrReader, w *xdrWriter) {
+	_, node, err := s.statHandle(r)
+	offset := r.uint64()
+	count := r.uint32()
+	if r.err != nil {
+		return
+	}
+	if err == nil && node.IsDir() {
+		err = errIsDir
+	}
+	var data []byte
+	eof := false
+	if err == nil {
+		if count > maxTransferSize {
+			count = maxTransferSize
+		}
+		data = make([]byte, count)
+		var n int
+		n, err = s.files.readAt(node, data, int64(offset))
+		data = data[:n]
+		if err == nil || err == io.EOF {
+			err = nil
+			eof = int64(offset)+int64(n) >= node.Size()
+		}
+	}
+	w.uint32(nfsStatus(err))
+	s.writeAttr(w, node)
+	if err != nil {
+		return
+	}
+	w.uint32(uint32(len(data)))
+	w.bool(eof)
+	w.opaque(data)
+}
+
+func (s *server) write(r *xdrReader, w *xdrWriter) {
+	p, node, err := s.statHandle(r)
+	offset := r.uint64()
+	_ = r.uint32() // count
+	_ = r.uint32() // stable
+	data := r.opaque(maxTransferSize)
+	if r.err != nil {
+		return
+	}
+	if err == nil && node.IsDir() {
+		err = errIsDir
+	}
+	var n int
+	if err == nil {
+		n, err = s.files.writeAt(node, data, int64(offset))
+	}
+	w.uint32(nfsStatus(err))
+	s.writeWcc(w, p)
+	if err != nil {
+		return
+	}
+	w.uint32(uint32(n))
+	w.uint32(writeUnstable)
+	w.fixedOpaque(s.verifier[:])
+}
+
+func (s *server) create(r *xdrReader, w *xdrWriter) {
+	dir, name, err := s.readDirOp(r)
+	mode := r.uint32()
+	var attr sattr
+	if mode == createExclusive {
+		_ = r.fixedOpaque(8) // verifier
+	} else {
+		attr = readSattr(r)
+	}
+	if r.err != nil {
+		return
+	}
+	if err == nil {
+		err = checkName(name)
+	}
+	p := joinPath(dir, name)
+	if err == nil {
+		var node vfs.Node
+		node, err = s.vfs.Stat(p)
+		switch {
+		case err == vfs.ENOENT:
+			err = s.files.create(p)
+		case err != nil:
+		case mode != createUnchecked:
+			err = vfs.EEXIST
+		case node.IsDir():
+			err = errIsDir
+		}
+	}
+	if err == nil && (attr.setSize || attr.mtimeHow != timeDontChange) {
+		err = s.setAttr(p, attr)
+	}
+	w.uint32(nfsStatus(err))
+	if err == nil {
+		s.writeHandle(w, p)
+		s.writePathAttr(w, p)
+	}
+	s.writeWcc(w, dir)
+}
+
+func (s *server) mkdir(r *xdrReader, w *xdrWriter) {
+	dir, name, err := s.readDirOp(r)
+	_ = readSattr(r)
+	if r.err != nil {
+		return
+	}
+	if err == nil {
+		err = checkName(name)
+	}
+	p := joinPath(dir, name)
+	if err == nil {
+		err = s.vfs.Mkdir(p, 0777)
+	}
+	w.uint32(nfsStatus(err))
+	if err == nil {
+		s.writeHandle(w, p)
+		s.writePathAttr(w, p)
+	}
+	s.writeWcc(w, dir)
+}
+
+func (s *server) remove(r *xdrReader, w *xdrWriter, isDir bool) {
+	dir, name, err := s.readDirOp(r)
+	if r.err != nil {
+		return
+	}
+	if err == nil {
+		err = checkName(name)
+	}
+	p := joinPath(dir, name)
+	if err == nil {
+		var node vfs.Node
+		node, err = s.vfs.Stat(p)
+		switch {
+		case err != nil:
+		case isDir && !node.IsDir():
+			err = errNotDir
+		case !isDir && node.IsDir():
+			err = errIsDir
+		}
+	}
+	if err == nil {
+		_ = s.files.close(p)
+		err = s.vfs.Remove(p)
+	}
+	if err == nil {
+		err = s.handles.remove(p)
+	}
+	w.uint32(nfsStatus(err))
+	s.writeWcc(w, dir)
+}
+
+func (s *server) rename(r *xdrReader, w *xdrWriter) {
+	fromDir, fromName, err := s.readDirOp(r)
+	toDir, toName, toErr := s.readDirOp(r)
+	if r.err != nil {
+		return
+	}
+	if err == nil {
+		err = toErr
+	}
+	if err == nil {
+		err = checkName(fromName)
+	}
+	if err == nil {
+		err = checkName(toName)
+	}
+	from, to := joinPath(fromDir, fromName), joinPath(toDir, toName)
+	if err == nil && from != to {
+		s.files.closeUnder(from)
+		_ = s.files.close(to)
+		err = s.vfs.Rename(from, to)
+		if err == nil {
+			err = s.handles.rename(from, to)
+		}
+	}
+	w.uint32(nfsStatus(err))
+	s.writeWcc(w, fromDir)
+	s.writeWcc(w, toDir)
+}
+
+// Sizes used to estimate how big READDIR replies are
+const (
+	readdirHeaderSize = 4 + 4 + 84 + 8 + 4 + 4 // status, attr, verifier, end of list, eof
+	readdirEntrySize  = 4 + 8 + 4 + 8          // value follows, fileid, name length, cookie
+	readdirPlusSize   = 4 + 84 + 4 + 4 + 8     // attr, handle
+)
+
+func (s *server) readdir(r *xdrReader, w *xdrWriter, plus bool) {
+	_, node, err := s.statHandle(r)
+	cookie := r.uint64()
+	_ = r.fixedOpaque(8) // cookie verifier
+	count := r.uint32()  // count or dircount
+	if plus {
+		count = r.uint32() // maxcount
+	}
+	if r.err != nil {
+		return
+	}
+	var items vfs.Nodes
+	if err == nil {
+		d, ok := node.(*vfs.Dir)
+		if !ok {
+			err = errNotDir
+		} else {
+			items, err = d.ReadDirAll()
+		}
+	}
+	// Cookies are the index of the entry after the one returned
+	if err == nil && cookie > uint64(len(items)) {
+		err = vfs.EINVAL
+	}
+	var ids []uint64
+	if err == nil {
+		items = items[cookie:]
+		paths := make([]string, len(items))
+		for i, item := range items {
+			paths[i] = item.Path()
+		}
+		ids, err = s.handles.toIDs(paths)
+	}
+	w.uint32(nfsStatus(err))
+	s.writeAttr(w, node)
+	if err != nil {
+		return
+	}
+	w.fixedOpaque(make([]byte, 8)) // cookie verifier
+	size := readdirHeaderSize
+	eof := true
+	for i, item := range items {
+		name := item.Name()
+		size += readdirEntrySize + len(name) + pad(len(name))
+		if plus {
+			size += readdirPlusSize
+		}
+		if size > int(count) {
+			eof = false
+			break
+		}
+		w.bool(true)
+		w.uint64(ids[i])
+		w.string(name)
+		w.uint64(cookie + uint64(i) + 1)
+		if plus {
+			w.bool(true)
+			s.writeFattr(w, item, ids[i])
+			w.bool(true)
+			w.opaque(encodeHandle(ids[i]))
+		}
+	}
+	w.bool(false)
+	w.bool(eof)
+}
+
+func (s *server) fsstat(r *xdrReader, w *xdrWriter) {
+	_, node, err := s.statHandle(r)
+	if r.err != nil {
+		return
+	}
+	w.uint32(nfsStatus(err))
+	s.writeAttr(w, node)
+	if err != nil {
+		return
+	}
+	total, _, free := s.vfs.Statfs()
+	w.uint64(uint64(total))
+	w.uint64(uint64(free))
+	w.uint64(uint64(free))
+	// The number of files isn't limited
+	const files = 1 << 30
+	w.uint64(files)
+	w.uint64(files)
+	w.uint64(files)
+	w.uint32(0) // invarsec
+}
+
+func (s *server) fsinfo(r *xdrReader, w *xdrWriter) {
+	_, node, err := s.statHandle(r)
+	if r.err != nil {
+		return
+	}
+	w.uint32(nfsStatus(err))
+	s.writeAttr(w, node)
+	if err != nil {
+		return
+	}
+	w.uint32(maxTransferSize) // rtmax
+	w.uint32(maxTransferSize) // rtpref
+	w.uint32(4096)            // rtmult
+	w.uint32(maxTransferSize) // wtmax
+	w.uint32(maxTransferSize) // wtpref
+	w.uint32(4096)            // wtmult
+	w.uint32(64 * 1024)       // dtpref
+	w.uint64(1<<63 - 1)       // maxfilesize
+	w.uint32(0)               // time_delta
+	w.uint32(1)
+	w.uint32(fsfHomogeneous | fsfCanSetTime)
+}
+
+func (s *server) pathconf(r *xdrReader, w *xdrWriter) {
+	_, node, err := s.statHandle(r)
+	if r.err != nil {
+		return
+	}
+	w.uint32(nfsStatus(err))
+	s.writeAttr(w, node)
+	if err != nil {
+		return
+	}
+	w.uint32(1)           // linkmax
+	w.uint32(maxNameSize) // name_max
+	w.bool(true)          // no_trunc
+	w.bool(true)          // chown_restricted
+	w.bool(false)         // case_insensitive
+	w.bool(true)          // case_preserving
+}
+
+func (s *server) commit(r *xdrReader, w *xdrWriter) {
+	p, node, err := s.statHandle(r)
+	_ = r.uint64() // offset
+	_ = r.uint32() // count
+	if r.err != nil {
+		return
+	}
+	if err == nil && !node.IsDir() {
+		err = s.files.commit(p)
+	}
+	w.uint32(nfsStatus(err))
+	s.writeWcc(w, p)
+	if err != nil {
+		return
+	}
+	w.fixedOpaque(s.verifier[:])
+}
@@ -0,0 +1,588 @@
+package nfs
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXDR(t *testing.T) {
+	w := &xdrWriter{}
+	w.uint32(1)
+	w.uint64(1 << 40)
+	w.bool(true)
+	w.string("hello")
+	w.opaque([]byte{1, 2, 3, 4})
+	w.fixedOpaque([]byte{5})
+	assert.Equal(t, 0, len(w.buf)%4)
+
+	r := newXDRReader(w.buf)
+	assert.Equal(t, uint32(1), r.uint32())
+	assert.Equal(t, uint64(1<<40), r.uint64())
+	assert.Equal(t, true, r.bool())
+	assert.Equal(t, "hello", r.string(10))
+	assert.Equal(t, []byte{1, 2, 3, 4}, r.opaque(10))
+	assert.Equal(t, []byte{5}, r.fixedOpaque(1))
+	require.NoError(t, r.err)
+	assert.Equal(t, 0, len(r.buf))
+
+	// errors are sticky
+	r.uint32()
+	assert.Equal(t, errGarbageArgs, r.err)
+	assert.Equal(t, "", r.string(10))
+
+	// too long
+	r = newXDRReader(w.buf[16:])
+	assert.Nil(t, r.opaque(2))
+	assert.Equal(t, errGarbageArgs, r.err)
+}
+
+// makeCall returns an RPC call record
+func makeCall(xid, rpcVers, prog, vers, proc uint32, args []byte) []byte {
+	w := &xdrWriter{}
+	w.uint32(xid)
+	w.uint32(msgCall)
+	w.uint32(rpcVers)
+	w.uint32(prog)
+	w.uint32(vers)
+	w.uint32(proc)
+	w.uint32(authUnix) // credential
+	w.opaque([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	w.uint32(authNone) // verifier
+	w.opaque(nil)
+	w.buf = append(w.buf, args...)
+	return w.buf
+}
+
+// readReply checks the header of the reply and returns the accept
+// status and the reader positioned after it
+func readReply(t *testing.T, xid uint32, reply []byte) (uint32, *xdrReader) {
+	r := newXDRReader(reply)
+	assert.Equal(t, xid, r.uint32())
+	assert.Equal(t, uint32(msgReply), r.uint32())
+	assert.Equal(t, uint32(replyAccepted), r.uint32())
+	assert.Equal(t, uint32(authNone), r.uint32())
+	_ = r.opaque(maxAuthSize)
+	stat := r.uint32()
+	require.NoError(t, r.err)
+	return stat, r
+}
+
+func TestHandleCall(t *testing.T) {
+	programs := map[uint32]*rpcProgram{
+		1000: {name: "test", low: 2, high: 3, handler: func(call *rpcCall, w *xdrWriter) error {
+			switch call.proc {
+			case 0:
+				return nil
+			case 1:
+				n := call.args.uint32()
+				w.uint32(n + 1)
+				return nil
+			}
+			return errProcUnavail
+		}},
+	}
+
+	// success
+	args := &xdrWriter{}
+	args.uint32(41)
+	stat, r := readReply(t, 1, handleCall(programs, makeCall(1, rpcVersion, 1000, 3, 1, args.buf)))
+	assert.Equal(t, uint32(acceptSuccess), stat)
+	assert.Equal(t, uint32(42), r.uint32())
+
+	// missing arguments
+	stat, _ = readReply(t, 2, handleCall(programs, makeCall(2, rpcVersion, 1000, 3, 1, nil)))
+	assert.Equal(t, uint32(acceptGarbageArgs), stat)
+
+	// unknown procedure
+	stat, _ = readReply(t, 3, handleCall(programs, makeCall(3, rpcVersion, 1000, 3, 7, nil)))
+	assert.Equal(t, uint32(acceptProcUnavail), stat)
+
+	// unknown program
+	stat, _ = readReply(t, 4, handleCall(programs, makeCall(4, rpcVersion, 1001, 3, 0, nil)))
+	assert.Equal(t, uint32(acceptProgUnavail), stat)
+
+	// wrong version
+	stat, r = readReply(t, 5, handleCall(programs, makeCall(5, rpcVersion, 1000, 4, 0, nil)))
+	assert.Equal(t, uint32(acceptProgMismatch), stat)
+	assert.Equal(t, uint32(2), r.uint32())
+	assert.Equal(t, uint32(3), r.uint32())
+
+	// wrong RPC version
+	r = newXDRReader(handleCall(programs, makeCall(6, 3, 1000, 3, 0, nil)))
+	assert.Equal(t, uint32(6), r.uint32())
+	assert.Equal(t, uint32(msgReply), r.uint32())
+	assert.Equal(t, uint32(replyDenied), r.uint32())
+	assert.Equal(t, uint32(rejectRPCMismatch), r.uint32())
+
+	// not a call
+	assert.Nil(t, handleCall(programs, []byte{0, 0, 0, 1, 0, 0, 0, 1}))
+}
+
+// client is a minimal NFS client for testing
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	xid  uint32
+}
+
+func newClient(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &client{t: t, conn: conn}
+}
+
+// call runs the procedure returning a reader for the results
+func (c *client) call(prog, vers, proc uint32, args *xdrWriter) *xdrReader {
+	c.xid++
+	require.NoError(c.t, writeRecord(c.conn, makeCall(c.xid, rpcVersion, prog, vers, proc, args.buf)))
+	reply, err := readRecord(c.conn)
+	require.NoError(c.t, err)
+	stat, r := readReply(c.t, c.xid, reply)
+	require.Equal(c.t, uint32(acceptSuccess), stat)
+	return r
+}
+
+// nfs runs an NFS procedure returning the status and the reader
+func (c *client) nfs(proc uint32, args *xdrWriter) (uint32, *xdrReader) {
+	r := c.call(nfsProgram, nfsVersion, proc, args)
+	return r.uint32(), r
+}
+
+// mount returns the handle for the root
+func (c *client) mount(dirPath string) []byte {
+	args := &xdrWriter{}
+	args.string(dirPath)
+	r := c.call(mountProgram, mountVersion, mountProcMnt, args)
+	require.Equal(c.t, uint32(mountOK), r.uint32())
+	fh := r.opaque(maxHandleSize)
+	require.NoError(c.t, r.err)
+	return fh
+}
+
+// skipAttr skips a post_op_attr returning the size in it or -1
+func skipAttr(r *xdrReader) int64 {
+	if !r.bool() {
+		return -1
+	}
+	_ = r.fixedOpaque(5 * 4)
+	size := r.uint64()
+	_ = r.fixedOpaque(84 - 5*4 - 8)
+	return int64(size)
+}
+
+// skipWcc skips a wcc_data
+func skipWcc(r *xdrReader) {
+	if r.bool() {
+		_ = r.fixedOpaque(24)
+	}
+	skipAttr(r)
+}
+
+func dirOp(fh []byte, name string) *xdrWriter {
+	args := &xdrWriter{}
+	args.opaque(fh)
+	args.string(name)
+	return args
+}
+
+func (c *client) lookup(dir []byte, name string) (uint32, []byte) {
+	status, r := c.nfs(nfsProcLookup, dirOp(dir, name))
+	if status != nfsOK {
+		return status, nil
+	}
+	fh := r.opaque(maxHandleSize)
+	require.NoError(c.t, r.err)
+	return status, fh
+}
+
+func (c *client) getattr(fh []byte) (status uint32, typ uint32, size int64) {
+	args := &xdrWriter{}
+	args.opaque(fh)
+	status, r := c.nfs(nfsProcGetattr, args)
+	if status != nfsOK {
+		return status, 0, 0
+	}
+	typ = r.uint32()
+	_ = r.fixedOpaque(4 * 4)
+	size = int64(r.uint64())
+	require.NoError(c.t, r.err)
+	return status, typ, size
+}
+
+func (c *client) create(dir []byte, name string) []byte {
+	args := dirOp(dir, name)
+	args.uint32(createGuarded)
+	for i := 0; i < 4; i++ {
+		args.bool(false) // mode, uid, gid, size
+	}
+	args.uint32(timeDontChange) // atime
+	args.uint32(timeDontChange) // mtime
+	status, r := c.nfs(nfsProcCreate, args)
+	require.Equal(c.t, uint32(nfsOK), status)
+	require.True(c.t, r.bool())
+	fh := r.opaque(maxHandleSize)
+	require.NoError(c.t, r.err)
+	return fh
+}
+
+func (c *client) write(fh []byte, offset uint64, data string) {
+	args := &xdrWriter{}
+	args.opaque(fh)
+	args.uint64(offset)
+	args.uint32(uint32(len(data)))
+	args.uint32(writeUnstable)
+	args.opaque([]byte(data))
+	status, r := c.nfs(nfsProcWrite, args)
+	require.Equal(c.t, uint32(nfsOK), status)
+	skipWcc(r)
+	assert.Equal(c.t, uint32(len(data)), r.uint32())
+	require.NoError(c.t, r.err)
+}
+
+func (c *client) commit(fh []byte) {
+	args := &xdrWriter{}
+	args.opaque(fh)
+	args.uint64(0)
+	args.uint32(0)
+	status, _ := c.nfs(nfsProcCommit, args)
+	require.Equal(c.t, uint32(nfsOK), status)
+}
+
+func (c *client) read(fh []byte, offset uint64, count uint32) (string, bool) {
+	args := &xdrWriter{}
+	args.opaque(fh)
+	args.uint64(offset)
+	args.uint32(count)
+	status, r := c.nfs(nfsProcRead, args)
+	require.Equal(c.t, uint32(nfsOK), status)
+	skipAttr(r)
+	n := r.uint32()
+	eof := r.bool()
+	data := r.opaque(maxTransferSize)
+	require.NoError(c.t, r.err)
+	assert.Equal(c.t, int(n), len(data))
+	return string(data), eof
+}
+
+// readdirplus lists the directory returning the names and sizes
+func (c *client) readdirplus(dir []byte) map[string]int64 {
+	entries := map[string]int64{}
+	cookie := uint64(0)
+	for {
+		args := &xdrWriter{}
+		args.opaque(dir)
+		args.uint64(cookie)
+		args.fixedOpaque(make([]byte, 8))
+		args.uint32(512)  // dircount
+		args.uint32(1024) // maxcount - small to test continuing
+		status, r := c.nfs(nfsProcReaddirplus, args)
+		require.Equal(c.t, uint32(nfsOK), status)
+		skipAttr(r)
+		_ = r.fixedOpaque(8)
+		for r.bool() {
+			_ = r.uint64() // fileid
+			name := r.string(maxNameSize)
+			cookie = r.uint64()
+			entries[name] = skipAttr(r)
+			require.True(c.t, r.bool())
+			_ = r.opaque(maxHandleSize)
+		}
+		eof := r.bool()
+		require.NoError(c.t, r.err)
+		if eof {
+			return entries
+		}
+	}
+}
+
+// startServer starts a server on dir
+func startServer(t *testing.T, dir string, handleCache string) *server {
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	opt := DefaultOpt
+	opt.ListenAddr = "localhost:0"
+	opt.HandleCache = handleCache
+	s, err := newServer(context.Background(), f, &opt)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve())
+	return s
+}
+
+func stopServer(s *server) {
+	s.Close()
+	s.Wait()
+	s.vfs.Shutdown()
+	_ = s.vfs.CleanUp()
+}
+
+func testServer(t *testing.T) {
+	s := startServer(t, t.TempDir(), "memory")
+	defer stopServer(s)
+	c := newClient(t, s.Addr())
+
+	// NULL
+	status, _ := c.nfs(nfsProcNull, &xdrWriter{})
+	assert.Equal(t, uint32(0), status) // no results so reads as 0
+
+	root := c.mount("/")
+
+	// Export and mounting things which aren't there
+	r := c.call(mountProgram, mountVersion, mountProcExport, &xdrWriter{})
+	assert.True(t, r.bool())
+	assert.Equal(t, exportPath, r.string(maxPathSize))
+	args := &xdrWriter{}
+	args.string("/notfound")
+	r = c.call(mountProgram, mountVersion, mountProcMnt, args)
+	assert.Equal(t, uint32(mountErrNoEnt), r.uint32())
+
+	// Create and write a file
+	fh := c.create(root, "hello.txt")
+	c.write(fh, 0, "hello ")
+	c.write(fh, 6, "world")
+	c.commit(fh)
+
+	status, typ, size := c.getattr(fh)
+	require.Equal(t, uint32(nfsOK), status)
+	assert.Equal(t, uint32(nfsTypeReg), typ)
+	assert.Equal(t, int64(11), size)
+
+	data, eof := c.read(fh, 0, 100)
+	assert.Equal(t, "hello world", data)
+	assert.True(t, eof)
+	data, eof = c.read(fh, 6, 3)
+	assert.Equal(t, "wor", data)
+	assert.False(t, eof)
+
+	// Creating it again fails
+	args = dirOp(root, "hello.txt")
+	args.uint32(createExclusive)
+	args.fixedOpaque(make([]byte, 8))
+	status, _ = c.nfs(nfsProcCreate, args)
+	assert.Equal(t, uint32(nfsErrExist), status)
+
+	// Lookup finds the same handle
+	status, found := c.lookup(root, "hello.txt")
+	require.Equal(t, uint32(nfsOK), status)
+	assert.Equal(t, fh, found)
+	status, _ = c.lookup(root, "notfound.txt")
+	assert.Equal(t, uint32(nfsErrNoEnt), status)
+	status, _ = c.lookup(root, "a/b")
+	assert.Equal(t, uint32(nfsErrInval), status)
+
+	// Make a directory
+	args = dirOp(root, "dir")
+	for i := 0; i < 4; i++ {
+		args.bool(false)
+	}
+	args.uint32(timeDontChange)
+	args.uint32(timeDontChange)
+	status, r = c.nfs(nfsProcMkdir, args)
+	require.Equal(t, uint32(nfsOK), status)
+	require.True(t, r.bool())
+	dir := r.opaque(maxHandleSize)
+	status, typ, _ = c.getattr(dir)
+	require.Equal(t, uint32(nfsOK), status)
+	assert.Equal(t, uint32(nfsTypeDir), typ)
+
+	// Lots of files to test READDIRPLUS continuing
+	var want = map[string]int64{"hello.txt": 11, "dir": 0}
+	for i := 0; i < 20; i++ {
+		name := string(rune('a'+i)) + ".txt"
+		c.create(root, name)
+		want[name] = 0
+	}
+	assert.Equal(t, want, c.readdirplus(root))
+
+	// Rename keeps the file handle
+	args = dirOp(root, "hello.txt")
+	args.opaque(dir)
+	args.string("moved.txt")
+	status, _ = c.nfs(nfsProcRename, args)
+	require.Equal(t, uint32(nfsOK), status)
+	status, _, size = c.getattr(fh)
+	require.Equal(t, uint32(nfsOK), status)
+	assert.Equal(t, int64(11), size)
+	assert.Equal(t, map[string]int64{"moved.txt": 11}, c.readdirplus(dir))
+
+	// Truncate
+	args = &xdrWriter{}
+	args.opaque(fh)
+	for i := 0; i < 3; i++ {
+		args.bool(false) // mode, uid, gid
+	}
+	args.bool(true)
+	args.uint64(0)
+	args.uint32(timeDontChange)
+	args.uint32(timeDontChange)
+	args.bool(false) // guard
+	status, _ = c.nfs(nfsProcSetattr, args)
+	require.Equal(t, uint32(nfsOK), status)
+	_, _, size = c.getattr(fh)
+	assert.Equal(t, int64(0), size)
+
+	// Can't remove a non empty directory
+	status, _ = c.nfs(nfsProcRmdir, dirOp(root, "dir"))
+	assert.Equal(t, uint32(nfsErrNotEmpty), status)
+
+	// Remove the file and the directory
+	status, _ = c.nfs(nfsProcRemove, dirOp(dir, "moved.txt"))
+	require.Equal(t, uint32(nfsOK), status)
+	status, _, _ = c.getattr(fh)
+	assert.Equal(t, uint32(nfsErrStale), status)
+	status, _ = c.nfs(nfsProcRmdir, dirOp(root, "dir"))
+	require.Equal(t, uint32(nfsOK), status)
+
+	// Unsupported things
+	status, _ = c.nfs(nfsProcReadlink, dirOp(root, ""))
+	assert.Equal(t, uint32(nfsErrNotSupp), status)
+
+	// Bad handles
+	status, _, _ = c.getattr([]byte{1, 2, 3})
+	assert.Equal(t, uint32(nfsErrBadHandle), status)
+	status, _, _ = c.getattr(encodeHandle(1 << 60))
+	assert.Equal(t, uint32(nfsErrStale), status)
+
+	// File system info
+	args = &xdrWriter{}
+	args.opaque(root)
+	status, r = c.nfs(nfsProcFsinfo, args)
+	require.Equal(t, uint32(nfsOK), status)
+	skipAttr(r)
+	assert.Equal(t, uint32(maxTransferSize), r.uint32())
+	status, _ = c.nfs(nfsProcFsstat, args)
+	require.Equal(t, uint32(nfsOK), status)
+}
+
+func TestServer(t *testing.T) {
+	for _, cacheMode := range []vfscommon.CacheMode{vfscommon.CacheModeOff, vfscommon.CacheModeWrites} {
+		t.Run(cacheMode.String(), func(t *testing.T) {
+			oldCacheMode := vfsflags.Opt.CacheMode
+			vfsflags.Opt.CacheMode = cacheMode
+			defer func() { vfsflags.Opt.CacheMode = oldCacheMode }()
+			testServer(t)
+		})
+	}
+}
+
+// Test the handles stay the same when the server is restarted
+func TestServerRestart(t *testing.T) {
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	dir := t.TempDir()
+	s := startServer(t, dir, "disk")
+	_, ok := s.handles.(*kvHandles)
+	if !ok {
+		stopServer(s)
+		t.Skip("handles can't be persisted on this platform")
+	}
+	c := newClient(t, s.Addr())
+	root := c.mount("/")
+	fh := c.create(root, "file.txt")
+	c.write(fh, 0, "potato")
+	c.commit(fh)
+
+	// The test binary drops the database whenever it is opened,
+	// so start the new server before stopping the old one.
+	s2 := startServer(t, dir, "disk")
+	defer stopServer(s2)
+	stopServer(s)
+
+	c = newClient(t, s2.Addr())
+	data, _ := c.read(fh, 0, 100)
+	assert.Equal(t, "potato", data)
+	status, found := c.lookup(root, "file.txt")
+	require.Equal(t, uint32(nfsOK), status)
+	assert.Equal(t, fh, found)
+}
+
+func testHandleCache(t *testing.T, h handleCache) {
+	id, err := h.toID("")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(rootID), id)
+
+	ids, err := h.toIDs([]string{"a", "a/b", "a/b/c", "ab", ""})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(rootID), ids[4])
+	unique := map[uint64]struct{}{}
+	for _, id := range ids {
+		unique[id] = struct{}{}
+	}
+	assert.Equal(t, len(ids), len(unique))
+
+	id, err = h.toID("a/b/c")
+	require.NoError(t, err)
+	assert.Equal(t, ids[2], id)
+
+	require.NoError(t, h.rename("a", "z"))
+	var paths []string
+	for _, id := range ids {
+		p, err := h.toPath(id)
+		require.NoError(t, err)
+		paths = append(paths, p)
+	}
+	assert.Equal(t, []string{"z", "z/b", "z/b/c", "ab", ""}, paths)
+
+	require.NoError(t, h.remove("ab"))
+	_, err = h.toPath(ids[3])
+	assert.Equal(t, errStale, err)
+	id, err = h.toID("ab")
+	require.NoError(t, err)
+	assert.NotEqual(t, ids[3], id)
+
+	_, err = h.toPath(1 << 60)
+	assert.Equal(t, errStale, err)
+}
+
+func TestMemoryHandles(t *testing.T) {
+	testHandleCache(t, newMemoryHandles())
+}
+
+func TestKVHandles(t *testing.T) {
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	f, err := fs.NewFs(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	h, err := newKVHandles(context.Background(), f)
+	if err != nil {
+		t.Skipf("kv not supported: %v", err)
+	}
+	testHandleCache(t, h)
+
+	// A new cache sees the same handles without the memory cache
+	h2, err := newKVHandles(context.Background(), f)
+	require.NoError(t, err)
+	require.NoError(t, h.close())
+	p, err := h2.toPath(2)
+	require.NoError(t, err)
+	assert.Equal(t, "z", p)
+
+	// Handles outside the root are stale
+	sub, err := fs.NewFs(context.Background(), f.Root()+"/z")
+	require.NoError(t, err)
+	h3, err := newKVHandles(context.Background(), sub)
+	require.NoError(t, err)
+	require.NoError(t, h2.close())
+	defer func() { require.NoError(t, h3.close()) }()
+	ids, err := h3.toIDs([]string{"b", "b/c"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, ids)
+	_, err = h3.toPath(2)
+	assert.Equal(t, errStale, err)
+	var sorted []string
+	for _, id := range []uint64{4, 3} {
+		p, err := h3.toPath(id)
+		require.NoError(t, err)
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	assert.Equal(t, []string{"b", "b/c"}, sorted)
+}
@@ -0,0 +1,171 @@
+package nfs
+
+// ONC RPC version 2 over TCP as described in RFC 5531
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RPC message constants
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+	acceptSystemErr    = 5
+
+	rejectRPCMismatch = 0
+
+	authNone = 0
+	authUnix = 1
+
+	maxAuthSize = 400
+
+	// lastFragment is set in the record marker of the last fragment
+	// of a record
+	lastFragment = 1 << 31
+
+	// maxRecordSize is the largest RPC record accepted which
+	// needs to be big enough for the largest WRITE
+	maxRecordSize = maxTransferSize + 64*1024
+)
+
+// errProcUnavail is returned by handlers for procedures which don't exist
+var errProcUnavail = errors.New("procedure unavailable")
+
+// rpcCall is a decoded RPC call
+type rpcCall struct {
+	xid  uint32
+	prog uint32
+	vers uint32
+	proc uint32
+	args *xdrReader
+}
+
+// rpcHandler handles the calls to an RPC program, writing the results
+// of the procedure to reply.
+//
+// It returns errGarbageArgs if the arguments can't be decoded or
+// errProcUnavail if the procedure doesn't exist.
+type rpcHandler func(call *rpcCall, reply *xdrWriter) error
+
+// rpcProgram is an RPC program served by the server
+type rpcProgram struct {
+	name    string
+	low     uint32 // lowest version supported
+	high    uint32 // highest version supported
+	handler rpcHandler
+}
+
+// readRecord reads an RPC record made up of one or more fragments
+func readRecord(in io.Reader) (record []byte, err error) {
+	var marker [4]byte
+	for {
+		_, err = io.ReadFull(in, marker[:])
+		if err != nil {
+			return nil, err
+		}
+		header := binary.BigEndian.Uint32(marker[:])
+		size := int(header &^ lastFragment)
+		if len(record)+size > maxRecordSize {
+			return nil, fmt.Errorf("RPC record too large: %d bytes", len(record)+size)
+		}
+		start := len(record)
+		record = append(record, make([]byte, size)...)
+		_, err = io.ReadFull(in, record[start:])
+		if err != nil {
+			return nil, err
+		}
+		if header&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes record as a single fragment
+func writeRecord(out io.Writer, record []byte) error {
+	buf := make([]byte, 4, 4+len(record))
+	binary.BigEndian.PutUint32(buf, lastFragment|uint32(len(record)))
+	_, err := out.Write(append(buf, record...))
+	return err
+}
+
+// handleCall decodes the call in record, runs it and returns the
+// reply to send, or nil if there is no reply
+func handleCall(programs map[uint32]*rpcProgram, record []byte) []byte {
+	r := newXDRReader(record)
+	call := &rpcCall{
+		xid: r.uint32(),
+	}
+	if r.uint32() != msgCall || r.err != nil {
+		return nil
+	}
+	version := r.uint32()
+	call.prog = r.uint32()
+	call.vers = r.uint32()
+	call.proc = r.uint32()
+	_ = r.uint32()            // credential flavor
+	_ = r.opaque(maxAuthSize) // credential body
+	_ = r.uint32()            // verifier flavor
+	_ = r.opaque(maxAuthSize) // verifier body
+	call.args = r
+
+	w := &xdrWriter{}
+	w.uint32(call.xid)
+	w.uint32(msgReply)
+	if version != rpcVersion {
+		w.uint32(replyDenied)
+		w.uint32(rejectRPCMismatch)
+		w.uint32(rpcVersion)
+		w.uint32(rpcVersion)
+		return w.buf
+	}
+	w.uint32(replyAccepted)
+	w.uint32(authNone) // verifier
+	w.opaque(nil)
+	if r.err != nil {
+		w.uint32(acceptGarbageArgs)
+		return w.buf
+	}
+	program, ok := programs[call.prog]
+	if !ok {
+		w.uint32(acceptProgUnavail)
+		return w.buf
+	}
+	if call.vers < program.low || call.vers > program.high {
+		w.uint32(acceptProgMismatch)
+		w.uint32(program.low)
+		w.uint32(program.high)
+		return w.buf
+	}
+	mark := len(w.buf)
+	w.uint32(acceptSuccess)
+	err := program.handler(call, w)
+	if err == nil && r.err != nil {
+		err = r.err
+	}
+	if err != nil {
+		w.buf = w.buf[:mark]
+		switch err {
+		case errGarbageArgs:
+			w.uint32(acceptGarbageArgs)
+		case errProcUnavail:
+			w.uint32(acceptProcUnavail)
+		default:
+			w.uint32(acceptSystemErr)
+		}
+	}
+	return w.buf
+}
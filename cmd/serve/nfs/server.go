@@ -0,0 +1,182 @@
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+)
+
+// maxConcurrentCalls is the number of calls on a connection run at once
+const maxConcurrentCalls = 16
+
+// server contains everything to run the server
+type server struct {
+	f        fs.Fs
+	opt      Options
+	vfs      *vfs.VFS
+	listener net.Listener
+	handles  handleCache
+	files    *openFiles
+	programs map[uint32]*rpcProgram
+	fsid     uint64        // identifies the file system to the client
+	verifier [8]byte       // changes each time the server starts
+	waitChan chan struct{} // for waiting on the listener to close
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+func newServer(ctx context.Context, f fs.Fs, opt *Options) (*server, error) {
+	s := &server{
+		f:        f,
+		opt:      *opt,
+		vfs:      vfs.New(f, &vfsflags.Opt),
+		waitChan: make(chan struct{}),
+		conns:    map[net.Conn]struct{}{},
+	}
+	switch opt.HandleCache {
+	case "disk":
+		handles, err := newKVHandles(ctx, f)
+		if err == nil {
+			s.handles = handles
+			break
+		}
+		if err != kv.ErrUnsupported {
+			fs.Errorf(nil, "NFS failed to open the file handle cache: %v", err)
+		}
+		fs.Logf(nil, "NFS file handles will not persist across restarts")
+		s.handles = newMemoryHandles()
+	case "memory":
+		s.handles = newMemoryHandles()
+	default:
+		return nil, errors.New("--handle-cache must be disk or memory")
+	}
+	if _, err := rand.Read(s.verifier[:]); err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, fs.ConfigString(f))
+	s.fsid = h.Sum64()
+	s.files = newOpenFiles(s.vfs)
+	s.programs = map[uint32]*rpcProgram{
+		nfsProgram:   {name: "NFS", low: nfsVersion, high: nfsVersion, handler: s.nfsHandler},
+		mountProgram: {name: "MOUNT", low: mountVersion, high: mountVersion, handler: s.mountHandler},
+	}
+	return s, nil
+}
+
+// Serve starts the NFS server in the background
+//
+// Use s.Close() and s.Wait() to shutdown server
+func (s *server) Serve() (err error) {
+	s.listener, err = net.Listen("tcp", s.opt.ListenAddr)
+	if err != nil {
+		return err
+	}
+	fs.Logf(nil, "NFS server listening on %v", s.listener.Addr())
+	go s.acceptConnections()
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Wait blocks while the listener is open.
+func (s *server) Wait() {
+	<-s.waitChan
+}
+
+// Close shuts the running server down
+func (s *server) Close() {
+	err := s.listener.Close()
+	if err != nil {
+		fs.Errorf(nil, "Error on closing NFS server: %v", err)
+	}
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.files.shutdown()
+	err = s.handles.close()
+	if err != nil {
+		fs.Errorf(nil, "Error on closing NFS file handle cache: %v", err)
+	}
+	close(s.waitChan)
+}
+
+// Accept connections and call them in a go routine
+func (s *server) acceptConnections() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fs.Errorf(nil, "Failed to accept incoming NFS connection: %v", err)
+			continue
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads the calls on conn and runs them
+func (s *server) serveConn(conn net.Conn) {
+	fs.Debugf(nil, "NFS connection from %v", conn.RemoteAddr())
+	var (
+		writeMu sync.Mutex
+		calls   sync.WaitGroup
+		limit   = make(chan struct{}, maxConcurrentCalls)
+	)
+	defer func() {
+		calls.Wait()
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	for {
+		record, err := readRecord(conn)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(nil, "NFS connection from %v failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		limit <- struct{}{}
+		calls.Add(1)
+		go func() {
+			defer func() {
+				<-limit
+				calls.Done()
+			}()
+			reply := handleCall(s.programs, record)
+			if reply == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			err := writeRecord(conn, reply)
+			if err != nil {
+				fs.Debugf(nil, "NFS failed to send reply to %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,117 @@
+package nfs
+
+// XDR encoding as described in RFC 4506
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errGarbageArgs is returned when the arguments of a call can't be decoded
+var errGarbageArgs = errors.New("can't decode arguments")
+
+// xdrReader decodes XDR from a buffer
+//
+// Errors are sticky so the decoding can be checked once at the end
+type xdrReader struct {
+	buf []byte
+	err error
+}
+
+func newXDRReader(buf []byte) *xdrReader {
+	return &xdrReader{buf: buf}
+}
+
+// next returns the next n bytes of the buffer
+func (r *xdrReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.buf) {
+		r.err = errGarbageArgs
+		return nil
+	}
+	p := r.buf[:n]
+	r.buf = r.buf[n:]
+	return p
+}
+
+func (r *xdrReader) uint32() uint32 {
+	p := r.next(4)
+	if p == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(p)
+}
+
+func (r *xdrReader) uint64() uint64 {
+	p := r.next(8)
+	if p == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(p)
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+// fixedOpaque reads n bytes of opaque data with its padding
+func (r *xdrReader) fixedOpaque(n int) []byte {
+	p := r.next(n)
+	r.next(pad(n))
+	return p
+}
+
+// opaque reads variable length opaque data of up to max bytes
+func (r *xdrReader) opaque(max int) []byte {
+	n := r.uint32()
+	if n > uint32(max) {
+		r.err = errGarbageArgs
+		return nil
+	}
+	return r.fixedOpaque(int(n))
+}
+
+func (r *xdrReader) string(max int) string {
+	return string(r.opaque(max))
+}
+
+// pad returns the number of bytes of padding needed after n bytes
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+// xdrWriter encodes XDR into a buffer
+type xdrWriter struct {
+	buf []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *xdrWriter) uint64(v uint64) {
+	w.uint32(uint32(v >> 32))
+	w.uint32(uint32(v))
+}
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+// fixedOpaque writes p with its padding
+func (w *xdrWriter) fixedOpaque(p []byte) {
+	w.buf = append(w.buf, p...)
+	w.buf = append(w.buf, make([]byte, pad(len(p)))...)
+}
+
+func (w *xdrWriter) opaque(p []byte) {
+	w.uint32(uint32(len(p)))
+	w.fixedOpaque(p)
+}
+
+func (w *xdrWriter) string(s string) {
+	w.opaque([]byte(s))
+}
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	"github.com/rclone/rclone/fs/config/obscure"
 	libcache "github.com/rclone/rclone/lib/cache"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
 )
 
@@ -49,8 +51,9 @@ config.
 This config generated must have this extra parameter
 - |_root| - root to use for the backend
 
-And it may have this parameter
+And it may have these parameters
 - |_obscure| - comma separated strings for parameters to obscure
+- |_read_only| - set to |true| to only allow the user to read
 
 If password authentication was used by the client, input to the proxy
 process (on STDIN) would look similar to this:
@@ -104,21 +107,80 @@ before it takes effect.
 
 This can be used to build general purpose proxies to any kind of
 backend that rclone supports.  
+
+### Auth Users
+
+If you supply the parameter |--auth-users /path/to/users.json| then
+rclone will serve each user their own remote as described in that
+file instead of serving a single remote. This is an easier way of
+setting up users than |--auth-proxy| and if both are set
+|--auth-proxy| is ignored.
+
+The file is a JSON list of users like this
+
+|||
+[
+	{
+		"user": "alice",
+		"pass": "$2y$05$0Fz6ZkW0pHrU1DoGN9t7SeP...4fSq",
+		"root": "s3:bucket/alice",
+		"quota": "10G"
+	},
+	{
+		"user": "bob",
+		"public_keys": ["ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDgL...zk6Y bob@laptop"],
+		"root": "/srv/files",
+		"read_only": true
+	}
+]
+|||
+
+Where each user has
+- |user| - the user name to log in with
+- |pass| - the password hashed as |htpasswd| does (bcrypt, MD5 or SHA1)
+- |public_keys| - a list of public keys in |authorized_keys| format
+- |root| - the remote and path the user sees as their root
+- |read_only| - set to |true| to only allow the user to read
+- |quota| - the most the user may store in |root|, eg |10G|. Leave it
+  out or set it to |off| for no limit. |0| means the user can't store
+  anything.
+
+A user may log in with their password or any of their public keys. If
+neither are set the user can't log in.
+
+If |quota| is set then writes which would make the total size of the
+files in |root| bigger than |quota| fail with "no space left on
+device". The sizes of the files are read by walking |root| so this may
+be slow for big remotes. The quota is reported as the size of the disk
+to the client, for example by the |df| command in |serve sftp|.
+
+The file is read again when it changes so users can be added, removed
+or changed without restarting the server. This doesn't affect users
+already logged in.
 `, "|", "`", -1)
 
 // Options is options for creating the proxy
 type Options struct {
 	AuthProxy string
+	AuthUsers string
 }
 
 // DefaultOpt is the default values uses for Opt
 var DefaultOpt = Options{
 	AuthProxy: "",
+	AuthUsers: "",
+}
+
+// Enabled returns true if the options create the VFS for each user
+// instead of serving a remote
+func (opt *Options) Enabled() bool {
+	return opt.AuthProxy != "" || opt.AuthUsers != ""
 }
 
 // Proxy represents a proxy to turn auth requests into a VFS
 type Proxy struct {
-	cmdLine  []string // broken down command line
+	cmdLine  []string   // broken down command line
+	users    *usersFile // set if using --auth-users
 	vfsCache *libcache.Cache
 	ctx      context.Context // for global config
 	Opt      Options
@@ -132,12 +194,19 @@ type cacheEntry struct {
 
 // New creates a new proxy with the Options passed in
 func New(ctx context.Context, opt *Options) *Proxy {
-	return &Proxy{
+	p := &Proxy{
 		ctx:      ctx,
 		Opt:      *opt,
 		cmdLine:  strings.Fields(opt.AuthProxy),
 		vfsCache: libcache.New(),
 	}
+	if opt.AuthUsers != "" {
+		if opt.AuthProxy != "" {
+			fs.Logf(nil, "Ignoring --auth-proxy as --auth-users is set")
+		}
+		p.users = newUsersFile(opt.AuthUsers)
+	}
+	return p
 }
 
 // run the proxy command returning a config map
@@ -181,6 +250,20 @@ func (p *Proxy) run(in map[string]string) (config configmap.Simple, err error) {
 	return config, nil
 }
 
+// getVFSOptions returns the VFS options for the config returned by
+// the proxy
+func getVFSOptions(config configmap.Simple) (*vfscommon.Options, error) {
+	opt := vfsflags.Opt
+	if readOnly, ok := config.Get("_read_only"); ok {
+		var err error
+		opt.ReadOnly, err = strconv.ParseBool(readOnly)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: bad _read_only value %q: %w", readOnly, err)
+		}
+	}
+	return &opt, nil
+}
+
 // call runs the auth proxy and returns a cacheEntry and an error
 func (p *Proxy) call(user, auth string, isPublicKey bool) (value interface{}, err error) {
 	var config configmap.Simple
@@ -238,11 +321,16 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value interface{}, er
 			return nil, false, err
 		}
 
+		vfsOpt, err := getVFSOptions(config)
+		if err != nil {
+			return nil, false, err
+		}
+
 		// We hash the auth here so we don't copy the auth more than we
 		// need to in memory. An attacker would find it easier to go
 		// after the unencrypted password in memory most likely.
 		entry := cacheEntry{
-			vfs:    vfs.New(f, &vfsflags.Opt),
+			vfs:    vfs.New(f, vfsOpt),
 			pwHash: sha256.Sum256([]byte(auth)),
 		}
 		return entry, true, nil
@@ -253,9 +341,44 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value interface{}, er
 	return value, nil
 }
 
+// callUsers checks the auth against the users file and returns the
+// VFS for the user
+func (p *Proxy) callUsers(userName, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey string, err error) {
+	u, err := p.users.check(userName, auth, isPublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := cache.Get(p.ctx, u.Root)
+	if err != nil {
+		return nil, "", fmt.Errorf("proxy: failed to create backend: %w", err)
+	}
+	vfsOpt := vfsflags.Opt
+	vfsOpt.ReadOnly = vfsOpt.ReadOnly || u.ReadOnly
+	vfsOpt.Quota = -1
+	if u.Quota != nil {
+		vfsOpt.Quota = *u.Quota
+	}
+	// Re-use the VFS unless the user's entry has changed
+	if value, ok := p.vfsCache.GetMaybe(userName); ok {
+		entry := value.(cacheEntry)
+		if entry.vfs.Fs() == f && entry.vfs.Opt.ReadOnly == vfsOpt.ReadOnly && entry.vfs.Opt.Quota == vfsOpt.Quota {
+			return entry.vfs, userName, nil
+		}
+	}
+	VFS = vfs.New(f, &vfsOpt)
+	p.vfsCache.Put(userName, cacheEntry{vfs: VFS})
+	return VFS, userName, nil
+}
+
 // Call runs the auth proxy with the username and password/public key provided
 // returning a *vfs.VFS and the key used in the VFS cache.
 func (p *Proxy) Call(user, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey string, err error) {
+	// The users file is checked on every login so changes to it take
+	// effect immediately
+	if p.users != nil {
+		return p.callUsers(user, auth, isPublicKey)
+	}
+
 	// Look in the cache first
 	value, ok := p.vfsCache.GetMaybe(user)
 
@@ -6,15 +6,21 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -233,3 +239,132 @@ func TestRun(t *testing.T) {
 		assert.Equal(t, 1, p.vfsCache.Entries())
 	})
 }
+
+func TestGetVFSOptions(t *testing.T) {
+	opt, err := getVFSOptions(configmap.Simple{})
+	require.NoError(t, err)
+	assert.False(t, opt.ReadOnly)
+
+	opt, err = getVFSOptions(configmap.Simple{"_read_only": "true"})
+	require.NoError(t, err)
+	assert.True(t, opt.ReadOnly)
+
+	_, err = getVFSOptions(configmap.Simple{"_read_only": "potato"})
+	assert.ErrorContains(t, err, "_read_only")
+}
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	for _, test := range []struct {
+		hash string
+		want bool
+	}{
+		{string(bcryptHash), true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", true},     // htpasswd -nbs
+		{"$apr1$N2i8bS0F$Jd2kZXXs8A8APO2mtXr5H0", true}, // htpasswd -nbm
+		{"secret", false},
+		{"", false},
+	} {
+		assert.Equal(t, test.want, checkPassword(test.hash, "secret"), test.hash)
+		assert.False(t, checkPassword(test.hash, "potato"), test.hash)
+	}
+}
+
+func TestAuthUsers(t *testing.T) {
+	dir := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte("alicepass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	publicKeyString := base64.StdEncoding.EncodeToString(publicKey.Marshal())
+
+	users, err := json.Marshal([]map[string]interface{}{{
+		"user":  "alice",
+		"pass":  string(hash),
+		"root":  filepath.Join(dir, "alice"),
+		"quota": "1k",
+	}, {
+		"user":        "bob",
+		"public_keys": []string{string(ssh.MarshalAuthorizedKey(publicKey))},
+		"root":        filepath.Join(dir, "bob"),
+		"read_only":   true,
+	}})
+	require.NoError(t, err)
+	usersPath := filepath.Join(dir, "users.json")
+	require.NoError(t, os.WriteFile(usersPath, users, 0600))
+
+	opt := DefaultOpt
+	opt.AuthUsers = usersPath
+	assert.True(t, opt.Enabled())
+	p := New(context.Background(), &opt)
+
+	VFS, vfsKey, err := p.Call("alice", "alicepass", false)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", vfsKey)
+	assert.Equal(t, VFS, p.Get("alice"))
+	assert.Equal(t, fs.SizeSuffix(1024), VFS.Opt.Quota)
+	assert.False(t, VFS.Opt.ReadOnly)
+
+	// same VFS next time
+	VFS2, _, err := p.Call("alice", "alicepass", false)
+	require.NoError(t, err)
+	assert.Equal(t, VFS, VFS2)
+
+	_, _, err = p.Call("alice", "wrong", false)
+	assert.ErrorContains(t, err, "incorrect password")
+	_, _, err = p.Call("alice", publicKeyString, true)
+	assert.ErrorContains(t, err, "incorrect public key")
+
+	VFS, vfsKey, err = p.Call("bob", publicKeyString, true)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", vfsKey)
+	assert.Equal(t, fs.SizeSuffix(-1), VFS.Opt.Quota)
+	assert.True(t, VFS.Opt.ReadOnly)
+
+	// bob has no password so can't log in with one
+	_, _, err = p.Call("bob", "", false)
+	assert.ErrorContains(t, err, "incorrect password")
+
+	_, _, err = p.Call("carol", "pass", false)
+	assert.ErrorContains(t, err, "unknown user")
+
+	// Changes to the file are read
+	require.NoError(t, os.WriteFile(usersPath, []byte(`[{"user":"carol","pass":"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=","root":"`+filepath.ToSlash(dir)+`"}]`), 0600))
+	require.NoError(t, os.Chtimes(usersPath, time.Now(), time.Now().Add(time.Minute)))
+	_, _, err = p.Call("carol", "secret", false)
+	require.NoError(t, err)
+	_, _, err = p.Call("alice", "alicepass", false)
+	assert.ErrorContains(t, err, "unknown user")
+}
+
+func TestParseUsers(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		err string
+	}{
+		{`[{"user":"a","root":"/"}]`, ""},
+		{`[{"user":"","root":"/"}]`, "no user"},
+		{`[{"user":"a"}]`, "no root"},
+		{`[{"user":"a","root":"/"},{"user":"a","root":"/"}]`, "duplicated"},
+		{`[{"user":"a","root":"/","public_keys":["potato"]}]`, "bad public key"},
+		{`[{"user":"a","root":"/","potato":true}]`, "unknown field"},
+		{`[{"user":"a","root":"/","quota":"-1"}]`, "can't be negative"},
+	} {
+		_, err := parseUsers([]byte(test.in))
+		if test.err == "" {
+			assert.NoError(t, err, test.in)
+		} else {
+			assert.ErrorContains(t, err, test.err, test.in)
+		}
+	}
+
+	// A quota of 0 is kept rather than meaning no limit
+	users, err := parseUsers([]byte(`[{"user":"a","root":"/","quota":0},{"user":"b","root":"/"}]`))
+	require.NoError(t, err)
+	require.NotNil(t, users["a"].Quota)
+	assert.Equal(t, fs.SizeSuffix(0), *users["a"].Quota)
+	assert.Nil(t, users["b"].Quota)
+}
@@ -15,4 +15,5 @@ var (
 // AddFlags adds the non filing system specific flags to the command
 func AddFlags(flagSet *pflag.FlagSet) {
 	flags.StringVarP(flagSet, &Opt.AuthProxy, "auth-proxy", "", Opt.AuthProxy, "A program to use to create the backend from the auth")
+	flags.StringVarP(flagSet, &Opt.AuthUsers, "auth-users", "", Opt.AuthUsers, "A JSON file of users with their own root, read only flag and quota")
 }
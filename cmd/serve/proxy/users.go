@@ -0,0 +1,151 @@
+package proxy
+
+// Users file for --auth-users
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goauth "github.com/abbot/go-http-auth"
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// user is an entry in the users file
+type user struct {
+	User       string         `json:"user"`
+	Pass       string         `json:"pass,omitempty"`        // htpasswd style hash of the password
+	PublicKeys []string       `json:"public_keys,omitempty"` // in authorized_keys format
+	Root       string         `json:"root"`                  // remote:path to serve
+	ReadOnly   bool           `json:"read_only,omitempty"`
+	Quota      *fs.SizeSuffix `json:"quota,omitempty"` // nil if not set
+
+	publicKeys map[string]struct{} // base64 of the parsed public keys
+}
+
+// usersFile reads the users file, reloading it if it changes
+type usersFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	users   map[string]*user
+}
+
+func newUsersFile(path string) *usersFile {
+	return &usersFile{path: path}
+}
+
+// parseUsers parses the users file in data
+func parseUsers(data []byte) (map[string]*user, error) {
+	var list []*user
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]*user, len(list))
+	for _, u := range list {
+		if u.User == "" {
+			return nil, errors.New("entry with no user")
+		}
+		if _, found := users[u.User]; found {
+			return nil, fmt.Errorf("user %q is duplicated", u.User)
+		}
+		if u.Root == "" {
+			return nil, fmt.Errorf("user %q has no root", u.User)
+		}
+		u.publicKeys = make(map[string]struct{}, len(u.PublicKeys))
+		for _, line := range u.PublicKeys {
+			pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("user %q has bad public key: %w", u.User, err)
+			}
+			u.publicKeys[base64.StdEncoding.EncodeToString(pubKey.Marshal())] = struct{}{}
+		}
+		users[u.User] = u
+	}
+	return users, nil
+}
+
+// load reads the users file if it has changed since it was last read
+//
+// Call with mu held
+func (uf *usersFile) load() error {
+	fi, err := os.Stat(uf.path)
+	if err != nil {
+		return fmt.Errorf("proxy: failed to read users file: %w", err)
+	}
+	if uf.users != nil && fi.ModTime().Equal(uf.modTime) && fi.Size() == uf.size {
+		return nil
+	}
+	data, err := os.ReadFile(uf.path)
+	if err != nil {
+		return fmt.Errorf("proxy: failed to read users file: %w", err)
+	}
+	users, err := parseUsers(data)
+	if err != nil {
+		return fmt.Errorf("proxy: failed to parse users file %q: %w", uf.path, err)
+	}
+	fs.Debugf(nil, "Loaded %d users from %q", len(users), uf.path)
+	uf.users, uf.modTime, uf.size = users, fi.ModTime(), fi.Size()
+	return nil
+}
+
+// check the password or public key is correct for userName and
+// return the user
+func (uf *usersFile) check(userName, auth string, isPublicKey bool) (*user, error) {
+	uf.mu.Lock()
+	err := uf.load()
+	u := uf.users[userName]
+	uf.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("proxy: unknown user %q", userName)
+	}
+	if isPublicKey {
+		if _, ok := u.publicKeys[auth]; !ok {
+			return nil, errors.New("proxy: incorrect public key")
+		}
+	} else if !checkPassword(u.Pass, auth) {
+		return nil, errors.New("proxy: incorrect password")
+	}
+	return u, nil
+}
+
+// checkPassword returns true if password matches hash
+//
+// hash can be bcrypt, MD5 or SHA1 as made by htpasswd. An empty hash
+// never matches.
+func checkPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		want := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(want)) == 1
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		e := goauth.NewMD5Entry(hash)
+		if e == nil {
+			return false
+		}
+		got := goauth.MD5Crypt([]byte(password), e.Salt, e.Magic)
+		return subtle.ConstantTimeCompare(got, []byte(hash)) == 1
+	}
+	return false
+}
@@ -0,0 +1,474 @@
+package s3
+
+// AWS Signature Version 4 checking
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+const (
+	signV4Algorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	yyyymmdd         = "20060102"
+	maxClockSkew     = 15 * time.Minute
+	maxPresignExpiry = 7 * 24 * time.Hour
+	maxChunkSize     = 16 * 1024 * 1024
+
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// emptySHA256 is the hex SHA256 of no data
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// timeNow is the time now - can be overridden in tests
+var timeNow = time.Now
+
+// signature is the parsed and verified signature of a request
+type signature struct {
+	accessKey  string
+	amzDate    string // the request time in amzDateFormat
+	scope      string // date/region/service/aws4_request
+	signingKey []byte
+	seed       string // the hex signature of the request
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sha256Hex returns the hex SHA256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate checks the signature of the request returning it if
+// it is valid.
+//
+// It returns a nil signature if the server doesn't need authentication.
+func (s *Server) authenticate(r *http.Request) (*signature, error) {
+	if len(s.keys) == 0 {
+		return nil, nil
+	}
+	query := r.URL.Query()
+	authorization := r.Header.Get("Authorization")
+	var (
+		credential    string
+		signedHeaders string
+		sentSignature string
+		amzDate       string
+		payloadHash   string
+		presigned     bool
+	)
+	switch {
+	case strings.HasPrefix(authorization, signV4Algorithm+" "):
+		for _, field := range strings.Split(authorization[len(signV4Algorithm)+1:], ",") {
+			field = strings.TrimSpace(field)
+			equals := strings.IndexRune(field, '=')
+			if equals < 0 {
+				return nil, errAuthorizationHeaderBad
+			}
+			value := field[equals+1:]
+			switch field[:equals] {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				sentSignature = value
+			}
+		}
+		if credential == "" || signedHeaders == "" || sentSignature == "" {
+			return nil, errAuthorizationHeaderBad
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		if amzDate == "" {
+			date, err := http.ParseTime(r.Header.Get("Date"))
+			if err != nil {
+				return nil, errMissingSecurityHeader
+			}
+			amzDate = date.UTC().Format(amzDateFormat)
+		}
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, errMissingSecurityHeader
+		}
+	case query.Get("X-Amz-Algorithm") != "":
+		if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+			return nil, errUnsupportedSignatureType
+		}
+		presigned = true
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		sentSignature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		if credential == "" || signedHeaders == "" || sentSignature == "" || amzDate == "" {
+			return nil, errAuthorizationQueryBad
+		}
+		payloadHash = query.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			payloadHash = unsignedPayload
+		}
+	case authorization != "" || query.Get("Signature") != "":
+		return nil, errUnsupportedSignatureType
+	default:
+		return nil, errAccessDenied
+	}
+
+	// The host must be signed so the request can't be replayed
+	// against a different server
+	hostSigned := false
+	for _, header := range strings.Split(signedHeaders, ";") {
+		hostSigned = hostSigned || header == "host"
+	}
+	if !hostSigned {
+		if presigned {
+			return nil, errAuthorizationQueryBad
+		}
+		return nil, errAuthorizationHeaderBad
+	}
+
+	// Check the credential is access_key/date/region/service/aws4_request
+	credentials := strings.Split(credential, "/")
+	if len(credentials) != 5 || credentials[4] != "aws4_request" {
+		return nil, errAuthorizationHeaderBad
+	}
+	secret, ok := s.keys[credentials[0]]
+	if !ok {
+		return nil, errInvalidAccessKeyID
+	}
+
+	// Check the time of the request
+	date, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || credentials[1] != date.Format(yyyymmdd) {
+		return nil, errAuthorizationHeaderBad
+	}
+	now := timeNow()
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpiry {
+			return nil, errAuthorizationQueryBad
+		}
+		if date.After(now.Add(maxClockSkew)) {
+			return nil, errRequestTimeTooSkewed
+		}
+		if now.After(date.Add(time.Duration(expires) * time.Second)) {
+			return nil, errExpiredPresignedRequest
+		}
+	} else if date.Before(now.Add(-maxClockSkew)) || date.After(now.Add(maxClockSkew)) {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	// Calculate the signature
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		canonicalURI(r),
+		canonicalQuery(query, presigned),
+		canonicalHeaders(r, signedHeaders),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join(credentials[1:], "/")
+	stringToSign := strings.Join([]string{
+		signV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	signingKey := []byte("AWS4" + secret)
+	for _, part := range credentials[1:] {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	wantSignature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(wantSignature), []byte(sentSignature)) {
+		fs.Debugf(r.URL.Path, "Signature mismatch: canonical request:\n%s", canonicalRequest)
+		return nil, errSignatureDoesNotMatch
+	}
+	return &signature{
+		accessKey:  credentials[0],
+		amzDate:    amzDate,
+		scope:      scope,
+		signingKey: signingKey,
+		seed:       sentSignature,
+	}, nil
+}
+
+// uriEncode encodes s as in the canonical request, leaving "/"
+// unencoded if encodeSlash is false
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalURI returns the path of the request as it was sent by the
+// client, before any --baseurl was stripped, URI encoded
+func canonicalURI(r *http.Request) string {
+	urlPath := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		urlPath = u.Path
+	}
+	if urlPath == "" {
+		urlPath = "/"
+	}
+	return uriEncode(urlPath, false)
+}
+
+// canonicalQuery returns the sorted and encoded query string
+func canonicalQuery(query url.Values, presigned bool) string {
+	var params []string
+	for key, values := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalHeaders returns the canonical headers of the signed headers
+func canonicalHeaders(r *http.Request, signedHeaders string) string {
+	var b strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = r.Header.Values(name)
+			if len(values) == 0 && r.ContentLength >= 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		case "transfer-encoding":
+			values = r.TransferEncoding
+		default:
+			values = r.Header.Values(name)
+		}
+		for i, value := range values {
+			values[i] = strings.Join(strings.Fields(value), " ")
+		}
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(values, ","))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// wrapBody wraps the body of the request to decode aws-chunked
+// uploads and check the hashes of the payload as it is read
+func (s *Server) wrapBody(r *http.Request, sig *signature) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	switch payloadHash {
+	case "", unsignedPayload:
+	case streamingPayload, streamingUnsignedTrailer:
+		if payloadHash == streamingUnsignedTrailer {
+			sig = nil
+		}
+		decodedLength, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			return errMissingSecurityHeader
+		}
+		r.ContentLength = decodedLength
+		r.Body = &struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: newChunkedReader(r.Body, sig),
+			Closer: r.Body,
+		}
+	case streamingPayloadTrailer:
+		return errNotImplemented
+	default:
+		want, err := hex.DecodeString(payloadHash)
+		if err != nil || len(want) != sha256.Size {
+			return errContentSHA256Mismatch
+		}
+		r.Body = newVerifyingReader(r.Body, sha256.New(), want, errContentSHA256Mismatch)
+	}
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		want, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(want) != md5.Size {
+			return errInvalidArgument
+		}
+		r.Body = newVerifyingReader(r.Body, md5.New(), want, errBadDigest)
+	}
+	return nil
+}
+
+// verifyingReader checks the hash of the data read through it
+type verifyingReader struct {
+	in     io.ReadCloser
+	hasher hash.Hash
+	want   []byte
+	err    error
+}
+
+func newVerifyingReader(in io.ReadCloser, hasher hash.Hash, want []byte, err error) *verifyingReader {
+	return &verifyingReader{
+		in:     in,
+		hasher: hasher,
+		want:   want,
+		err:    err,
+	}
+}
+
+// Read data checking the hash at the end
+func (v *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = v.in.Read(p)
+	_, _ = v.hasher.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hasher.Sum(nil), v.want) {
+		err = v.err
+	}
+	return n, err
+}
+
+// Close the underlying reader
+func (v *verifyingReader) Close() error {
+	return v.in.Close()
+}
+
+// chunkedReader decodes an aws-chunked body, checking the signatures
+// of the chunks if it has a signature
+type chunkedReader struct {
+	in      *bufio.Reader
+	sig     *signature
+	prevSig string
+	buf     []byte // undelivered data of the current chunk
+	err     error  // error to return after buf
+}
+
+func newChunkedReader(in io.Reader, sig *signature) *chunkedReader {
+	c := &chunkedReader{
+		in:  bufio.NewReader(in),
+		sig: sig,
+	}
+	if sig != nil {
+		c.prevSig = sig.seed
+	}
+	return c
+}
+
+// Read decoded data
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readChunk()
+	}
+	n = copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readLine reads a CRLF terminated line
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.in.ReadString('\n')
+	if err == io.EOF {
+		return "", errIncompleteBody
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// readChunk reads the next chunk into c.buf
+func (c *chunkedReader) readChunk() error {
+	header, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, extension, _ := strings.Cut(header, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errInvalidArgument
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(c.in, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errIncompleteBody
+	} else if err != nil {
+		return err
+	}
+	if c.sig != nil {
+		chunkSig := strings.TrimPrefix(strings.TrimSpace(extension), "chunk-signature=")
+		stringToSign := strings.Join([]string{
+			"AWS4-HMAC-SHA256-PAYLOAD",
+			c.sig.amzDate,
+			c.sig.scope,
+			c.prevSig,
+			emptySHA256,
+			sha256Hex(data),
+		}, "\n")
+		wantSig := hex.EncodeToString(hmacSHA256(c.sig.signingKey, stringToSign))
+		if !hmac.Equal([]byte(wantSig), []byte(chunkSig)) {
+			return errSignatureDoesNotMatch
+		}
+		c.prevSig = chunkSig
+	}
+	if size == 0 {
+		// Skip any trailers up to the final blank line
+		for {
+			line, err := c.readLine()
+			if errors.Is(err, errIncompleteBody) {
+				break
+			} else if err != nil {
+				return err
+			}
+			if line == "" {
+				break
+			}
+		}
+		return io.EOF
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return errIncompleteBody
+	}
+	c.buf = data
+	return nil
+}
@@ -0,0 +1,124 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/list"
+)
+
+// listRoot lists the buckets, returning an empty list if the root
+// doesn't exist yet
+func (s *Server) listRoot(ctx context.Context) (fs.DirEntries, error) {
+	entries, err := list.DirSorted(ctx, s.f, false, "")
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// findBucket returns the directory for bucket or errNoSuchBucket
+func (s *Server) findBucket(ctx context.Context, bucket string) (fs.Directory, error) {
+	entries, err := s.listRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if dir, ok := entry.(fs.Directory); ok && dir.Remote() == bucket {
+			return dir, nil
+		}
+	}
+	return nil, errNoSuchBucket
+}
+
+// GET /
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	entries, err := s.listRoot(ctx)
+	if err != nil {
+		return err
+	}
+	result := listAllMyBucketsResult{
+		Owner:   defaultOwner,
+		Buckets: []bucketInfo{},
+	}
+	for _, entry := range entries {
+		if dir, ok := entry.(fs.Directory); ok && validBucketName(dir.Remote()) {
+			result.Buckets = append(result.Buckets, bucketInfo{
+				Name:         dir.Remote(),
+				CreationDate: formatTime(dir.ModTime(ctx)),
+			})
+		}
+	}
+	return s.writeXML(w, r, &result)
+}
+
+// HEAD /bucket
+func (s *Server) headBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	_, err := s.findBucket(r.Context(), bucket)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// PUT /bucket
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx := r.Context()
+	_, err := s.findBucket(ctx, bucket)
+	if err == nil {
+		return errBucketAlreadyOwnedByYou
+	} else if err != errNoSuchBucket {
+		return err
+	}
+	err = s.f.Mkdir(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// DELETE /bucket
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx := r.Context()
+	_, err := s.findBucket(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	entries, err := s.f.List(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 0 {
+		return errBucketNotEmpty
+	}
+	err = s.f.Rmdir(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /bucket?location
+func (s *Server) getBucketLocation(w http.ResponseWriter, r *http.Request, bucket string) error {
+	_, err := s.findBucket(r.Context(), bucket)
+	if err != nil {
+		return err
+	}
+	return s.writeXML(w, r, &locationConstraint{})
+}
+
+// GET /bucket?versioning
+func (s *Server) getBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) error {
+	_, err := s.findBucket(r.Context(), bucket)
+	if err != nil {
+		return err
+	}
+	return s.writeXML(w, r, &versioningConfiguration{})
+}
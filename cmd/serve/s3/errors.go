@@ -0,0 +1,115 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// s3Error is an error which is returned to the client as an S3 error
+type s3Error struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+// Error satisfies the error interface
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newError makes a new S3 error
+func newError(statusCode int, code, message string) *s3Error {
+	return &s3Error{Code: code, Message: message, StatusCode: statusCode}
+}
+
+// S3 errors returned by the server
+var (
+	errAccessDenied             = newError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	errBadDigest                = newError(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+	errBucketAlreadyOwnedByYou  = newError(http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.")
+	errBucketNotEmpty           = newError(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty.")
+	errContentSHA256Mismatch    = newError(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+	errIncompleteBody           = newError(http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header.")
+	errInternalError            = newError(http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	errInvalidAccessKeyID       = newError(http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records.")
+	errInvalidArgument          = newError(http.StatusBadRequest, "InvalidArgument", "Invalid Argument")
+	errInvalidBucketName        = newError(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	errInvalidPart              = newError(http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+	errInvalidPartOrder         = newError(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+	errInvalidRange             = newError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+	errMalformedXML             = newError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+	errMethodNotAllowed         = newError(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	errMissingSecurityHeader    = newError(http.StatusBadRequest, "MissingSecurityHeader", "Your request is missing a required header.")
+	errNoSuchBucket             = newError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	errNoSuchKey                = newError(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	errNoSuchUpload             = newError(http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+	errNotImplemented           = newError(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+	errRequestTimeTooSkewed     = newError(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.")
+	errSignatureDoesNotMatch    = newError(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	errAuthorizationHeaderBad   = newError(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed.")
+	errAuthorizationQueryBad    = newError(http.StatusBadRequest, "AuthorizationQueryParametersError", "The authorization query parameters are malformed.")
+	errExpiredPresignedRequest  = newError(http.StatusForbidden, "AccessDenied", "Request has expired")
+	errUnsupportedSignatureType = newError(http.StatusBadRequest, "InvalidRequest", "Only AWS Signature Version 4 is supported.")
+)
+
+// errorResponse is the XML body of an S3 error
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string `xml:",omitempty"`
+}
+
+// writeError writes err to the client as an S3 error
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var s3Err *s3Error
+	if !errors.As(err, &s3Err) {
+		switch {
+		case errors.Is(err, fs.ErrorObjectNotFound):
+			s3Err = errNoSuchKey
+		case errors.Is(err, fs.ErrorDirNotFound):
+			s3Err = errNoSuchBucket
+		case errors.Is(err, fs.ErrorPermissionDenied):
+			s3Err = errAccessDenied
+		case errors.Is(err, fs.ErrorDirectoryNotEmpty):
+			s3Err = errBucketNotEmpty
+		default:
+			fs.Errorf(r.URL.Path, "%s %s failed: %v", r.Method, r.URL.RequestURI(), err)
+			s3Err = errInternalError
+		}
+	} else {
+		fs.Debugf(r.URL.Path, "%s %s: %v", r.Method, r.URL.RequestURI(), err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(s3Err.StatusCode)
+	if r.Method == "HEAD" {
+		return
+	}
+	s.writeXMLBody(w, r, &errorResponse{
+		Code:     s3Err.Code,
+		Message:  s3Err.Message,
+		Resource: r.URL.Path,
+	})
+}
+
+// writeXML writes v to the client as XML with a 200 status
+func (s *Server) writeXML(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	s.writeXMLBody(w, r, v)
+	return nil
+}
+
+// writeXMLBody writes the XML for v
+func (s *Server) writeXMLBody(w http.ResponseWriter, r *http.Request, v interface{}) {
+	_, err := w.Write([]byte(xml.Header))
+	if err == nil {
+		err = xml.NewEncoder(w).Encode(v)
+	}
+	if err != nil {
+		fs.Errorf(r.URL.Path, "Failed to write XML response: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/list"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+const maxKeys = 1000
+
+// listEntry is an object or a common prefix in a listing
+type listEntry struct {
+	key    string
+	object fs.Object // nil for a common prefix
+}
+
+// listParams are the parameters of a listing
+type listParams struct {
+	prefix    string
+	delimiter string
+	after     string // only list keys after this
+	maxKeys   int
+	encode    bool
+}
+
+// parseListParams reads the listing parameters common to both
+// versions of ListObjects
+func parseListParams(r *http.Request) (p listParams, err error) {
+	query := r.URL.Query()
+	p.prefix = query.Get("prefix")
+	p.delimiter = query.Get("delimiter")
+	p.maxKeys = maxKeys
+	if value := query.Get("max-keys"); value != "" {
+		p.maxKeys, err = strconv.Atoi(value)
+		if err != nil || p.maxKeys < 0 {
+			return p, errInvalidArgument
+		}
+		if p.maxKeys > maxKeys {
+			p.maxKeys = maxKeys
+		}
+	}
+	switch encodingType := query.Get("encoding-type"); encodingType {
+	case "":
+	case "url":
+		p.encode = true
+	default:
+		return p, errInvalidArgument
+	}
+	return p, nil
+}
+
+// encodeKey URL encodes key if requested
+func (p *listParams) encodeKey(key string) string {
+	if p.encode {
+		return uriEncode(key, false)
+	}
+	return key
+}
+
+// listEntries lists the keys in bucket with prefix sorted in key
+// order, grouping those with the delimiter after the prefix into
+// common prefixes
+func (s *Server) listEntries(ctx context.Context, bucket string, p *listParams) (entries []listEntry, err error) {
+	// The directory to start listing from
+	dir := ""
+	if i := strings.LastIndex(p.prefix, "/"); i >= 0 {
+		dir = p.prefix[:i]
+	}
+	remoteDir := bucket
+	if dir != "" {
+		remoteDir, err = objectRemote(bucket, dir)
+		if err != nil {
+			// No keys can match this prefix
+			return nil, nil
+		}
+	}
+	prefixes := map[string]struct{}{}
+	add := func(entry fs.DirEntry) {
+		key := strings.TrimPrefix(entry.Remote(), bucket+"/")
+		if !strings.HasPrefix(key, p.prefix) {
+			if _, isDir := entry.(fs.Directory); !isDir || !strings.HasPrefix(key+"/", p.prefix) {
+				return
+			}
+		}
+		if p.delimiter != "" {
+			if _, isDir := entry.(fs.Directory); isDir {
+				key += "/"
+			}
+			if i := strings.Index(key[len(p.prefix):], p.delimiter); i >= 0 {
+				prefixes[key[:len(p.prefix)+i+len(p.delimiter)]] = struct{}{}
+				return
+			}
+		}
+		if o, ok := entry.(fs.Object); ok {
+			entries = append(entries, listEntry{key: key, object: o})
+		}
+	}
+	if p.delimiter == "/" {
+		// Only one directory needs listing
+		var dirEntries fs.DirEntries
+		dirEntries, err = list.DirSorted(ctx, s.f, false, remoteDir)
+		for _, entry := range dirEntries {
+			add(entry)
+		}
+	} else {
+		err = walk.ListR(ctx, s.f, remoteDir, false, -1, walk.ListObjects, func(dirEntries fs.DirEntries) error {
+			for _, entry := range dirEntries {
+				add(entry)
+			}
+			return nil
+		})
+	}
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		// Return an empty listing if the bucket exists
+		_, err = s.findBucket(ctx, bucket)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	for prefix := range prefixes {
+		entries = append(entries, listEntry{key: prefix})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries, nil
+}
+
+// listObjects does the listing for both versions of ListObjects
+func (s *Server) listObjects(ctx context.Context, bucket string, p *listParams, result *listBucketResult) (lastKey string, err error) {
+	entries, err := s.listEntries(ctx, bucket, p)
+	if err != nil {
+		return "", err
+	}
+	result.Name = bucket
+	result.Prefix = p.encodeKey(p.prefix)
+	result.Delimiter = p.encodeKey(p.delimiter)
+	result.MaxKeys = p.maxKeys
+	if p.encode {
+		result.EncodingType = "url"
+	}
+	result.Contents = []objectInfo{}
+	n := 0
+	for _, entry := range entries {
+		if entry.key <= p.after {
+			continue
+		}
+		if n >= p.maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		n++
+		lastKey = entry.key
+		if entry.object == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{
+				Prefix: p.encodeKey(entry.key),
+			})
+			continue
+		}
+		result.Contents = append(result.Contents, objectInfo{
+			Key:          p.encodeKey(entry.key),
+			LastModified: formatTime(entry.object.ModTime(ctx)),
+			ETag:         quote(s.etag(ctx, entry.object, true)),
+			Size:         entry.object.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	return lastKey, nil
+}
+
+// GET /bucket
+func (s *Server) listObjectsV1(w http.ResponseWriter, r *http.Request, bucket string) error {
+	p, err := parseListParams(r)
+	if err != nil {
+		return err
+	}
+	marker := r.URL.Query().Get("marker")
+	p.after = marker
+	result := listBucketResult{
+		Marker: &marker,
+	}
+	lastKey, err := s.listObjects(r.Context(), bucket, &p, &result)
+	if err != nil {
+		return err
+	}
+	*result.Marker = p.encodeKey(marker)
+	if result.IsTruncated {
+		result.NextMarker = p.encodeKey(lastKey)
+	}
+	for i := range result.Contents {
+		result.Contents[i].Owner = &defaultOwner
+	}
+	return s.writeXML(w, r, &result)
+}
+
+// GET /bucket?list-type=2
+func (s *Server) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) error {
+	p, err := parseListParams(r)
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	result := listBucketResult{
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+	}
+	p.after = result.StartAfter
+	if result.ContinuationToken != "" {
+		after, err := base64.URLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return errInvalidArgument
+		}
+		p.after = string(after)
+	}
+	lastKey, err := s.listObjects(r.Context(), bucket, &p, &result)
+	if err != nil {
+		return err
+	}
+	result.StartAfter = p.encodeKey(result.StartAfter)
+	keyCount := len(result.Contents) + len(result.CommonPrefixes)
+	result.KeyCount = &keyCount
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(lastKey))
+	}
+	if query.Get("fetch-owner") == "true" {
+		for i := range result.Contents {
+			result.Contents[i].Owner = &defaultOwner
+		}
+	}
+	return s.writeXML(w, r, &result)
+}
@@ -0,0 +1,363 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/random"
+)
+
+const maxPartNumber = 10000
+
+// part is an uploaded part of a multipart upload
+type part struct {
+	number       int
+	etag         string // hex MD5 of the part
+	size         int64
+	lastModified time.Time
+}
+
+// multipartUpload is a multipart upload in progress
+type multipartUpload struct {
+	id        string
+	bucket    string
+	key       string
+	initiated time.Time
+	modTime   time.Time
+
+	mu    sync.Mutex
+	parts map[int]*part
+}
+
+// multipartUploads stores the parts of multipart uploads in a
+// temporary directory until they are completed
+type multipartUploads struct {
+	dir     string
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+func newMultipartUploads() (*multipartUploads, error) {
+	dir, err := os.MkdirTemp("", "rclone-serve-s3-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to make directory for multipart uploads: %w", err)
+	}
+	return &multipartUploads{
+		dir:     dir,
+		uploads: map[string]*multipartUpload{},
+	}, nil
+}
+
+// cleanup removes all the uploads
+func (m *multipartUploads) cleanup() {
+	m.mu.Lock()
+	m.uploads = map[string]*multipartUpload{}
+	m.mu.Unlock()
+	err := os.RemoveAll(m.dir)
+	if err != nil {
+		fs.Errorf(nil, "Failed to remove multipart uploads: %v", err)
+	}
+}
+
+// partPath returns the path of the file for part number of upload id
+func (m *multipartUploads) partPath(id string, number int) string {
+	return filepath.Join(m.dir, id, strconv.Itoa(number))
+}
+
+// get finds the upload with id for bucket and key
+func (m *multipartUploads) get(id, bucket, key string) (*multipartUpload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[id]
+	if !ok || upload.bucket != bucket || upload.key != key {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
+
+// remove the upload with id returning false if it has already been
+// removed
+func (m *multipartUploads) remove(id string) bool {
+	m.mu.Lock()
+	_, ok := m.uploads[id]
+	delete(m.uploads, id)
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	err := os.RemoveAll(filepath.Join(m.dir, id))
+	if err != nil {
+		fs.Errorf(nil, "Failed to remove multipart upload %q: %v", id, err)
+	}
+	return true
+}
+
+// openParts checks the parts in req have been uploaded and opens
+// them in order, returning the total size and the concatenated MD5s
+// of the parts
+func (upload *multipartUpload) openParts(m *multipartUploads, req *completeMultipartUpload) (files []*os.File, size int64, md5s []byte, err error) {
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	for i, reqPart := range req.Parts {
+		if i > 0 && reqPart.PartNumber <= req.Parts[i-1].PartNumber {
+			return files, 0, nil, errInvalidPartOrder
+		}
+		p, ok := upload.parts[reqPart.PartNumber]
+		if !ok || unquote(reqPart.ETag) != p.etag {
+			return files, 0, nil, errInvalidPart
+		}
+		in, err := os.Open(m.partPath(upload.id, p.number))
+		if err != nil {
+			return files, 0, nil, err
+		}
+		files = append(files, in)
+		size += p.size
+		sum, _ := hex.DecodeString(p.etag)
+		md5s = append(md5s, sum...)
+	}
+	return files, size, md5s, nil
+}
+
+// POST /bucket/key?uploads
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if strings.HasSuffix(key, "/") {
+		return errInvalidArgument
+	}
+	_, err := objectRemote(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = s.findBucket(r.Context(), bucket)
+	if err != nil {
+		return err
+	}
+	upload := &multipartUpload{
+		id:        random.String(32),
+		bucket:    bucket,
+		key:       key,
+		initiated: time.Now(),
+		modTime:   requestModTime(r),
+		parts:     map[int]*part{},
+	}
+	err = os.Mkdir(filepath.Join(s.multipart.dir, upload.id), 0700)
+	if err != nil {
+		return err
+	}
+	s.multipart.mu.Lock()
+	s.multipart.uploads[upload.id] = upload
+	s.multipart.mu.Unlock()
+	return s.writeXML(w, r, &initiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: upload.id,
+	})
+}
+
+// PUT /bucket/key?partNumber=N&uploadId=ID
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	query := r.URL.Query()
+	upload, err := s.multipart.get(query.Get("uploadId"), bucket, key)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		return errInvalidArgument
+	}
+
+	// Write the part to a temporary file then rename it into place
+	partPath := s.multipart.partPath(upload.id, number)
+	out, err := os.CreateTemp(filepath.Dir(partPath), "part-")
+	if err != nil {
+		return errNoSuchUpload
+	}
+	defer func() {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+	}()
+	hasher := md5.New()
+	size, err := io.Copy(io.MultiWriter(out, hasher), r.Body)
+	if err != nil {
+		return err
+	}
+	if r.ContentLength >= 0 && size != r.ContentLength {
+		return errIncompleteBody
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	p := &part{
+		number:       number,
+		etag:         hex.EncodeToString(hasher.Sum(nil)),
+		size:         size,
+		lastModified: time.Now(),
+	}
+	upload.mu.Lock()
+	err = os.Rename(out.Name(), partPath)
+	if err == nil {
+		upload.parts[number] = p
+	}
+	upload.mu.Unlock()
+	if err != nil {
+		return errNoSuchUpload
+	}
+	w.Header().Set("ETag", quote(p.etag))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// POST /bucket/key?uploadId=ID
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	upload, err := s.multipart.get(r.URL.Query().Get("uploadId"), bucket, key)
+	if err != nil {
+		return err
+	}
+	var req completeMultipartUpload
+	err = xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	files, size, md5s, err := upload.openParts(s.multipart, &req)
+	defer func() {
+		for _, in := range files {
+			_ = in.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	readers := make([]io.Reader, len(files))
+	for i, in := range files {
+		readers[i] = in
+	}
+
+	remote, err := objectRemote(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = operations.RcatSize(ctx, s.f, remote, io.NopCloser(io.MultiReader(readers...)), size, upload.modTime, nil)
+	if err != nil {
+		return err
+	}
+	s.multipart.remove(upload.id)
+	etagSum := md5.Sum(md5s)
+	return s.writeXML(w, r, &completeMultipartUploadResult{
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     quote(fmt.Sprintf("%s-%d", hex.EncodeToString(etagSum[:]), len(req.Parts))),
+	})
+}
+
+// DELETE /bucket/key?uploadId=ID
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	upload, err := s.multipart.get(r.URL.Query().Get("uploadId"), bucket, key)
+	if err != nil {
+		return err
+	}
+	if !s.multipart.remove(upload.id) {
+		return errNoSuchUpload
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /bucket/key?uploadId=ID
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	query := r.URL.Query()
+	upload, err := s.multipart.get(query.Get("uploadId"), bucket, key)
+	if err != nil {
+		return err
+	}
+	result := listPartsResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: upload.id,
+		MaxParts: 1000,
+	}
+	if value := query.Get("part-number-marker"); value != "" {
+		result.PartNumberMarker, err = strconv.Atoi(value)
+		if err != nil {
+			return errInvalidArgument
+		}
+	}
+	if value := query.Get("max-parts"); value != "" {
+		result.MaxParts, err = strconv.Atoi(value)
+		if err != nil || result.MaxParts < 0 {
+			return errInvalidArgument
+		}
+	}
+	upload.mu.Lock()
+	parts := make([]*part, 0, len(upload.parts))
+	for _, p := range upload.parts {
+		if p.number > result.PartNumberMarker {
+			parts = append(parts, p)
+		}
+	}
+	upload.mu.Unlock()
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].number < parts[j].number
+	})
+	if len(parts) > result.MaxParts {
+		parts = parts[:result.MaxParts]
+		result.IsTruncated = true
+	}
+	for _, p := range parts {
+		result.Parts = append(result.Parts, partInfo{
+			PartNumber:   p.number,
+			LastModified: formatTime(p.lastModified),
+			ETag:         quote(p.etag),
+			Size:         p.size,
+		})
+		result.NextPartNumberMarker = p.number
+	}
+	return s.writeXML(w, r, &result)
+}
+
+// GET /bucket?uploads
+func (s *Server) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) error {
+	_, err := s.findBucket(r.Context(), bucket)
+	if err != nil {
+		return err
+	}
+	prefix := r.URL.Query().Get("prefix")
+	result := listMultipartUploadsResult{
+		Bucket:     bucket,
+		Prefix:     prefix,
+		MaxUploads: 1000,
+	}
+	s.multipart.mu.Lock()
+	for _, upload := range s.multipart.uploads {
+		if upload.bucket == bucket && strings.HasPrefix(upload.key, prefix) {
+			result.Uploads = append(result.Uploads, uploadInfo{
+				Key:       upload.key,
+				UploadID:  upload.id,
+				Initiated: formatTime(upload.initiated),
+			})
+		}
+	}
+	s.multipart.mu.Unlock()
+	sort.Slice(result.Uploads, func(i, j int) bool {
+		if result.Uploads[i].Key != result.Uploads[j].Key {
+			return result.Uploads[i].Key < result.Uploads[j].Key
+		}
+		return result.Uploads[i].Initiated < result.Uploads[j].Initiated
+	})
+	return s.writeXML(w, r, &result)
+}
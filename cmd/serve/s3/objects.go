@@ -0,0 +1,320 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ncw/swift/v2"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/http/serve"
+)
+
+const (
+	mtimeHeader = "X-Amz-Meta-Mtime"
+	emptyMD5    = "d41d8cd98f00b204e9800998ecf8427e"
+)
+
+// objectRemote returns the path of the key in bucket on the remote
+func objectRemote(bucket, key string) (string, error) {
+	for _, segment := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", errInvalidArgument
+		}
+	}
+	return path.Join(bucket, key), nil
+}
+
+// newObject finds the object for key in bucket
+func (s *Server) newObject(ctx context.Context, bucket, key string) (fs.Object, error) {
+	if strings.HasSuffix(key, "/") {
+		return nil, errNoSuchKey
+	}
+	remote, err := objectRemote(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	o, err := s.f.NewObject(ctx, remote)
+	if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorNotAFile) {
+		if _, bucketErr := s.findBucket(ctx, bucket); bucketErr != nil {
+			return nil, bucketErr
+		}
+		return nil, errNoSuchKey
+	}
+	return o, err
+}
+
+// etag returns the unquoted ETag for o
+//
+// This is the hash of the object if available. If not, or if the hash
+// is slow to read and this is for a listing, then it is made from the
+// size and modification time which is long enough not to be mistaken
+// for an MD5 hash.
+func (s *Server) etag(ctx context.Context, o fs.Object, listing bool) string {
+	if s.opt.HashType != hash.None && !(listing && s.f.Features().SlowHash) {
+		sum, err := o.Hash(ctx, s.opt.HashType)
+		if err == nil && sum != "" {
+			return sum
+		}
+		if err != nil {
+			fs.Debugf(o, "Failed to read hash for ETag: %v", err)
+		}
+	}
+	fingerprint := sha1.Sum([]byte(fmt.Sprintf("%d,%d", o.Size(), o.ModTime(ctx).UnixNano())))
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// quote returns the etag in quotes as used in headers and XML
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
+
+// unquote removes the quotes from the etag if present
+func unquote(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+// requestModTime returns the modification time set in the request
+// metadata or the time now if not set
+func requestModTime(r *http.Request) time.Time {
+	if mtime := r.Header.Get(mtimeHeader); mtime != "" {
+		modTime, err := swift.FloatStringToTime(mtime)
+		if err == nil {
+			return modTime
+		}
+		fs.Debugf(r.URL.Path, "Failed to parse %s %q: %v", mtimeHeader, mtime, err)
+	}
+	return time.Now()
+}
+
+// checkConditions checks the conditional request headers returning
+// the status code to return instead of the object if they fail
+func checkConditions(r *http.Request, etag string, modTime time.Time) int {
+	modTime = modTime.Truncate(time.Second)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if ifMatch != "*" && unquote(ifMatch) != etag {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modTime.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if ifNoneMatch == "*" || unquote(ifNoneMatch) == etag {
+			return http.StatusNotModified
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// GET or HEAD /bucket/key
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	o, err := s.newObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	etag := s.etag(ctx, o, false)
+	modTime := o.ModTime(ctx)
+	h := w.Header()
+	h.Set("ETag", quote(etag))
+	h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	h.Set(mtimeHeader, swift.TimeToFloatString(modTime))
+	if code := checkConditions(r, etag, modTime); code != 0 {
+		if code == http.StatusPreconditionFailed {
+			return newError(code, "PreconditionFailed", "At least one of the preconditions you specified did not hold.")
+		}
+		w.WriteHeader(code)
+		return nil
+	}
+	if rangeRequest := r.Header.Get("Range"); rangeRequest != "" && r.Method == "GET" {
+		option, err := fs.ParseRangeOption(rangeRequest)
+		if err != nil {
+			return errInvalidRange
+		}
+		if offset, _ := option.Decode(o.Size()); offset >= o.Size() && o.Size() > 0 {
+			return errInvalidRange
+		}
+	}
+	serve.Object(w, r, o)
+	return nil
+}
+
+// PUT /bucket/key
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	remote, err := objectRemote(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = s.findBucket(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	// Keys ending in / are directory markers
+	if strings.HasSuffix(key, "/") {
+		_, err = io.Copy(io.Discard, r.Body)
+		if err != nil {
+			return err
+		}
+		err = s.f.Mkdir(ctx, remote)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", quote(emptyMD5))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	hasher, err := hash.NewMultiHasherTypes(hash.NewHashSet(hash.MD5))
+	if err != nil {
+		return err
+	}
+	in := io.TeeReader(r.Body, hasher)
+	_, err = operations.RcatSize(ctx, s.f, remote, io.NopCloser(in), r.ContentLength, requestModTime(r), nil)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", quote(hasher.Sums()[hash.MD5]))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// PUT /bucket/key with X-Amz-Copy-Source
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	if strings.HasSuffix(key, "/") {
+		return errInvalidArgument
+	}
+	dstRemote, err := objectRemote(bucket, key)
+	if err != nil {
+		return err
+	}
+	source, _, _ := strings.Cut(r.Header.Get("X-Amz-Copy-Source"), "?")
+	source, err = url.PathUnescape(source)
+	if err != nil {
+		return errInvalidArgument
+	}
+	srcBucket, srcKey := splitPath(source)
+	if !validBucketName(srcBucket) {
+		return errInvalidBucketName
+	}
+	src, err := s.newObject(ctx, srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	_, err = s.findBucket(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	replace := r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE"
+	var dst fs.Object
+	if src.Remote() == dstRemote {
+		if !replace {
+			return newError(http.StatusBadRequest, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.")
+		}
+		dst = src
+	} else {
+		dst, err = operations.Copy(ctx, s.f, nil, dstRemote, src)
+		if err != nil {
+			return err
+		}
+	}
+	if replace {
+		modTime := requestModTime(r)
+		err = dst.SetModTime(ctx, modTime)
+		if err != nil && !errors.Is(err, fs.ErrorCantSetModTime) && !errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			return err
+		}
+	}
+	return s.writeXML(w, r, &copyObjectResult{
+		ETag:         quote(s.etag(ctx, dst, false)),
+		LastModified: formatTime(dst.ModTime(ctx)),
+	})
+}
+
+// removeObject removes key from bucket along with any directories
+// left empty
+func (s *Server) removeObject(ctx context.Context, bucket, key string) error {
+	remote, err := objectRemote(bucket, key)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		err = s.f.Rmdir(ctx, remote)
+		if err != nil {
+			fs.Debugf(remote, "Not removing directory: %v", err)
+		}
+		return nil
+	}
+	o, err := s.f.NewObject(ctx, remote)
+	if err == nil {
+		err = o.Remove(ctx)
+	}
+	if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorNotAFile) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if s.f.Rmdir(ctx, path.Join(bucket, dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// DELETE /bucket/key
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	err := s.removeObject(r.Context(), bucket, key)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// POST /bucket?delete
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx := r.Context()
+	var req deleteRequest
+	err := xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return errMalformedXML
+	}
+	_, err = s.findBucket(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	result := deleteResult{}
+	for _, object := range req.Objects {
+		err := s.removeObject(ctx, bucket, object.Key)
+		if err != nil {
+			fs.Errorf(path.Join(bucket, object.Key), "Failed to delete: %v", err)
+			s3Err := errInternalError
+			errors.As(err, &s3Err)
+			result.Errors = append(result.Errors, deleteError{
+				Key:     object.Key,
+				Code:    s3Err.Code,
+				Message: s3Err.Message,
+			})
+		} else if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: object.Key})
+		}
+	}
+	return s.writeXML(w, r, &result)
+}
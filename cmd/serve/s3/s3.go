@@ -0,0 +1,333 @@
+// Package s3 implements an S3 compatible server backed by an rclone remote
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/hash"
+	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/spf13/cobra"
+)
+
+// Options required for s3 server
+type Options struct {
+	HTTP     libhttp.Config
+	AuthKeys []string  // access_key_id,secret_access_key pairs
+	HashName string    // name of the hash to use for ETags
+	HashType hash.Type // parsed HashName
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	HTTP:     libhttp.DefaultCfg(),
+	HashName: "MD5",
+}
+
+// Opt is options set by command line flags
+var Opt = DefaultOpt
+
+// flagPrefix is the prefix used to uniquely identify command line flags.
+// It is intentionally empty for this package.
+const flagPrefix = ""
+
+func init() {
+	flagSet := Command.Flags()
+	libhttp.AddHTTPFlagsPrefix(flagSet, flagPrefix, &Opt.HTTP)
+	flags.StringArrayVarP(flagSet, &Opt.AuthKeys, "auth-key", "", Opt.AuthKeys, "Set key pair for v4 authorization: access_key_id,secret_access_key")
+	flags.StringVarP(flagSet, &Opt.HashName, "etag-hash", "", Opt.HashName, "Which hash to use for the ETag, or auto or blank for off")
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "s3 remote:path",
+	Short: `Serve remote:path over s3.`,
+	Long: `Run an S3 compatible server to serve a remote so it can be used by
+tools which only speak S3, or by rclone's own s3 backend.
+
+The top level directories of remote:path are served as buckets and the
+files within them as objects, so ` + "`remote:path/bucket/dir/file`" + ` is
+served as the object ` + "`dir/file`" + ` in ` + "`bucket`" + `. Buckets must
+be addressed in the path of the URL (path style) rather than in the
+host name.
+
+The following S3 operations are supported
+
+- ListBuckets, CreateBucket, DeleteBucket, HeadBucket, GetBucketLocation
+- ListObjects and ListObjectsV2 (with prefixes, delimiters and paging)
+- GetObject (including ranges), HeadObject, PutObject, CopyObject
+- DeleteObject and DeleteObjects
+- CreateMultipartUpload, UploadPart, CompleteMultipartUpload,
+  AbortMultipartUpload, ListParts and ListMultipartUploads
+
+As S3 has no directories, when the last object in a directory is
+deleted the directory is removed too. Putting an object whose key
+ends in ` + "`/`" + ` makes a directory.
+
+The modification time of objects is read and written as the
+` + "`X-Amz-Meta-Mtime`" + ` metadata, the same way rclone's s3 backend stores
+it. Other metadata is ignored.
+
+Parts of multipart uploads are stored in a temporary directory until
+the upload is completed, when they are uploaded to the remote as a
+single file.
+
+### Authentication
+
+Use ` + "`--auth-key access_key_id,secret_access_key`" + ` to require
+clients to sign their requests with AWS Signature Version 4, either in
+the Authorization header or in the query of a presigned URL. This can
+be repeated to allow several keys. If no keys are set then anyone can
+read and write the remote.
+
+### ETags
+
+The ETag of an object is its MD5 hash if the remote supports it, as
+clients expect, or the hash set with ` + "`--etag-hash`" + `. If the remote
+has no such hash, or the hash is slow to calculate (as on the local
+backend) then listings use an ETag made from the size and modification
+time of the object instead, which isn't mistaken for an MD5 hash by
+clients.
+
+### Example
+
+    rclone serve s3 --auth-key ACCESS_KEY_ID,SECRET_ACCESS_KEY remote:path
+
+can be used with the rclone s3 backend configured like this
+
+    [serves3]
+    type = s3
+    provider = Other
+    access_key_id = ACCESS_KEY_ID
+    secret_access_key = SECRET_ACCESS_KEY
+    endpoint = http://127.0.0.1:8080/
+
+` + libhttp.Help(flagPrefix),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.63",
+	},
+	RunE: func(command *cobra.Command, args []string) error {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, false, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt)
+			if err != nil {
+				return err
+			}
+			s.Serve()
+			s.Wait()
+			return nil
+		})
+		return nil
+	},
+}
+
+// Server is an S3 compatible server
+type Server struct {
+	*libhttp.Server
+	f         fs.Fs
+	opt       Options
+	keys      map[string]string // access key ID to secret access key
+	multipart *multipartUploads
+}
+
+// Make a new S3 server to serve the remote
+func newServer(ctx context.Context, f fs.Fs, opt *Options) (s *Server, err error) {
+	s = &Server{
+		f:    f,
+		opt:  *opt,
+		keys: make(map[string]string, len(opt.AuthKeys)),
+	}
+	for _, pair := range opt.AuthKeys {
+		split := strings.SplitN(pair, ",", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			return nil, errors.New("--auth-key must be in the form access_key_id,secret_access_key")
+		}
+		s.keys[split[0]] = split[1]
+	}
+	if len(s.keys) == 0 {
+		fs.Logf(f, "No --auth-key set so the server can be used without authentication")
+	}
+
+	s.opt.HashType = hash.None
+	switch s.opt.HashName {
+	case "":
+	case "auto":
+		s.opt.HashType = f.Hashes().GetOne()
+	default:
+		err = s.opt.HashType.Set(s.opt.HashName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !f.Hashes().Contains(s.opt.HashType) {
+		fs.Debugf(f, "Remote doesn't support %v hash - not using it for ETags", s.opt.HashType)
+		s.opt.HashType = hash.None
+	}
+
+	s.multipart, err = newMultipartUploads()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Server, err = libhttp.NewServer(ctx, libhttp.WithConfig(s.opt.HTTP))
+	if err != nil {
+		s.multipart.cleanup()
+		return nil, fmt.Errorf("failed to init server: %w", err)
+	}
+
+	router := s.Server.Router()
+	router.Use(
+		middleware.SetHeader("Server", "rclone/"+fs.Version),
+	)
+	router.Handle("/*", s)
+	return s, nil
+}
+
+// Shutdown the server and remove any incomplete multipart uploads
+func (s *Server) Shutdown() error {
+	err := s.Server.Shutdown()
+	s.multipart.cleanup()
+	return err
+}
+
+// ServeHTTP dispatches the S3 request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remoteAddr := r.RemoteAddr
+	fs.Infof(r.URL.Path, "%s: %s %s", remoteAddr, r.Method, r.URL.RequestURI())
+
+	sig, err := s.authenticate(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	err = s.wrapBody(r, sig)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	bucket, key := splitPath(r.URL.Path)
+	query := r.URL.Query()
+	has := func(name string) bool {
+		_, ok := query[name]
+		return ok
+	}
+	if bucket != "" && !validBucketName(bucket) {
+		s.writeError(w, r, errInvalidBucketName)
+		return
+	}
+	switch {
+	case bucket == "":
+		switch r.Method {
+		case "GET":
+			err = s.listBuckets(w, r)
+		default:
+			err = errMethodNotAllowed
+		}
+	case key == "":
+		switch r.Method {
+		case "GET":
+			switch {
+			case has("location"):
+				err = s.getBucketLocation(w, r, bucket)
+			case has("uploads"):
+				err = s.listMultipartUploads(w, r, bucket)
+			case has("versioning"):
+				err = s.getBucketVersioning(w, r, bucket)
+			case has("versions"), has("acl"), has("policy"), has("lifecycle"), has("cors"), has("tagging"):
+				err = errNotImplemented
+			case query.Get("list-type") == "2":
+				err = s.listObjectsV2(w, r, bucket)
+			default:
+				err = s.listObjectsV1(w, r, bucket)
+			}
+		case "HEAD":
+			err = s.headBucket(w, r, bucket)
+		case "PUT":
+			err = s.createBucket(w, r, bucket)
+		case "DELETE":
+			err = s.deleteBucket(w, r, bucket)
+		case "POST":
+			if has("delete") {
+				err = s.deleteObjects(w, r, bucket)
+			} else {
+				err = errNotImplemented
+			}
+		default:
+			err = errMethodNotAllowed
+		}
+	default:
+		switch r.Method {
+		case "GET":
+			if has("uploadId") {
+				err = s.listParts(w, r, bucket, key)
+			} else if has("acl") || has("tagging") {
+				err = errNotImplemented
+			} else {
+				err = s.getObject(w, r, bucket, key)
+			}
+		case "HEAD":
+			err = s.getObject(w, r, bucket, key)
+		case "PUT":
+			switch {
+			case has("uploadId") && has("partNumber"):
+				if r.Header.Get("X-Amz-Copy-Source") != "" {
+					err = errNotImplemented
+				} else {
+					err = s.uploadPart(w, r, bucket, key)
+				}
+			case has("acl") || has("tagging"):
+				err = errNotImplemented
+			case r.Header.Get("X-Amz-Copy-Source") != "":
+				err = s.copyObject(w, r, bucket, key)
+			default:
+				err = s.putObject(w, r, bucket, key)
+			}
+		case "POST":
+			switch {
+			case has("uploads"):
+				err = s.createMultipartUpload(w, r, bucket, key)
+			case has("uploadId"):
+				err = s.completeMultipartUpload(w, r, bucket, key)
+			default:
+				err = errNotImplemented
+			}
+		case "DELETE":
+			if has("uploadId") {
+				err = s.abortMultipartUpload(w, r, bucket, key)
+			} else {
+				err = s.deleteObject(w, r, bucket, key)
+			}
+		default:
+			err = errMethodNotAllowed
+		}
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+	}
+}
+
+// splitPath splits the URL path into a bucket and a key
+func splitPath(urlPath string) (bucket, key string) {
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	i := strings.IndexRune(urlPath, '/')
+	if i < 0 {
+		return urlPath, ""
+	}
+	return urlPath[:i], urlPath[i+1:]
+}
+
+// validBucketName returns true if bucket can be used as a directory
+// name for a bucket
+func validBucketName(bucket string) bool {
+	return bucket != "." && bucket != ".." && !strings.ContainsAny(bucket, "\\"+string(os.PathSeparator))
+}
@@ -0,0 +1,462 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/ncw/swift/v2"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBindAddress = "localhost:0"
+	testAccessKey   = "ACCESS"
+	testSecretKey   = "SECRET"
+)
+
+// start the server on a temporary directory returning a client for it
+func start(t *testing.T, opt Options, secretKey string) (*Server, *s3.S3, fs.Fs) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	opt.HTTP.ListenAddr = []string{testBindAddress}
+	s, err := newServer(ctx, f, &opt)
+	require.NoError(t, err)
+	s.Serve()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+		s.Wait()
+	})
+
+	if secretKey == "" {
+		secretKey = testSecretKey
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(testAccessKey, secretKey, ""),
+		Endpoint:         aws.String(s.URLs()[0]),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	return s, s3.New(sess), f
+}
+
+func newOpt() Options {
+	opt := DefaultOpt
+	opt.AuthKeys = []string{testAccessKey + "," + testSecretKey}
+	return opt
+}
+
+// errCode returns the S3 error code of err
+func errCode(t *testing.T, err error) string {
+	require.Error(t, err)
+	var aerr awserr.Error
+	require.ErrorAs(t, err, &aerr)
+	return aerr.Code()
+}
+
+func put(t *testing.T, c *s3.S3, bucket, key, contents string) {
+	_, err := c.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(contents),
+	})
+	require.NoError(t, err)
+}
+
+func TestSplitPath(t *testing.T) {
+	for _, test := range []struct {
+		in     string
+		bucket string
+		key    string
+	}{
+		{"/", "", ""},
+		{"/bucket", "bucket", ""},
+		{"/bucket/", "bucket", ""},
+		{"/bucket/key", "bucket", "key"},
+		{"/bucket/dir/key/", "bucket", "dir/key/"},
+	} {
+		bucket, key := splitPath(test.in)
+		assert.Equal(t, test.bucket, bucket, test.in)
+		assert.Equal(t, test.key, key, test.in)
+	}
+}
+
+func TestUriEncode(t *testing.T) {
+	assert.Equal(t, "a/b%20c~d.e_f-g%2B%C3%A9", uriEncode("a/b c~d.e_f-g+é", false))
+	assert.Equal(t, "a%2Fb", uriEncode("a/b", true))
+}
+
+func TestBuckets(t *testing.T) {
+	_, c, _ := start(t, newOpt(), "")
+
+	out, err := c.ListBuckets(&s3.ListBucketsInput{})
+	require.NoError(t, err)
+	assert.Len(t, out.Buckets, 0)
+
+	_, err = c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	_, err = c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	assert.Equal(t, "BucketAlreadyOwnedByYou", errCode(t, err))
+
+	_, err = c.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	_, err = c.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("missing")})
+	assert.Equal(t, "NotFound", errCode(t, err))
+
+	out, err = c.ListBuckets(&s3.ListBucketsInput{})
+	require.NoError(t, err)
+	require.Len(t, out.Buckets, 1)
+	assert.Equal(t, "bucket", *out.Buckets[0].Name)
+
+	put(t, c, "bucket", "file", "hello")
+	_, err = c.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	assert.Equal(t, "BucketNotEmpty", errCode(t, err))
+	_, err = c.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("file")})
+	require.NoError(t, err)
+	_, err = c.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	_, err = c.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	assert.Equal(t, "NoSuchBucket", errCode(t, err))
+}
+
+func TestObjects(t *testing.T) {
+	ctx := context.Background()
+	_, c, f := start(t, newOpt(), "")
+	_, err := c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	put(t, c, "bucket", "key", "hello")
+	_, err = c.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("missing"),
+		Key:    aws.String("key"),
+		Body:   strings.NewReader("hello"),
+	})
+	assert.Equal(t, "NoSuchBucket", errCode(t, err))
+
+	// Put with a modification time
+	modTime := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	putOut, err := c.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("dir/file name.txt"),
+		Body:     strings.NewReader("hello world"),
+		Metadata: map[string]*string{"Mtime": aws.String(swift.TimeToFloatString(modTime))},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, *putOut.ETag)
+	o, err := f.NewObject(ctx, "bucket/dir/file name.txt")
+	require.NoError(t, err)
+	fstest.AssertTimeEqualWithPrecision(t, o.Remote(), modTime, o.ModTime(ctx), time.Millisecond)
+
+	// Head
+	head, err := c.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file name.txt"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), *head.ContentLength)
+	assert.Equal(t, `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, *head.ETag)
+	assert.Equal(t, swift.TimeToFloatString(o.ModTime(ctx)), *head.Metadata["Mtime"])
+	_, err = c.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/missing"),
+	})
+	assert.Equal(t, "NotFound", errCode(t, err))
+
+	// Get with a range
+	get, err := c.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file name.txt"),
+		Range:  aws.String("bytes=6-"),
+	})
+	require.NoError(t, err)
+	data, err := io.ReadAll(get.Body)
+	require.NoError(t, err)
+	require.NoError(t, get.Body.Close())
+	assert.Equal(t, "world", string(data))
+	_, err = c.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("missing"),
+	})
+	assert.Equal(t, "NoSuchKey", errCode(t, err))
+
+	// Copy
+	copyOut, err := c.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("copy"),
+		CopySource: aws.String("bucket/dir/file%20name.txt"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, *copyOut.CopyObjectResult.ETag)
+	o, err = f.NewObject(ctx, "bucket/copy")
+	require.NoError(t, err)
+	fstest.AssertTimeEqualWithPrecision(t, o.Remote(), modTime, o.ModTime(ctx), time.Millisecond)
+
+	// Delete removes the empty directory too
+	_, err = c.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file name.txt"),
+	})
+	require.NoError(t, err)
+	entries, err := f.List(ctx, "bucket")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// Delete multiple
+	delOut, err := c.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String("bucket"),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{
+			{Key: aws.String("key")},
+			{Key: aws.String("copy")},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, delOut.Deleted, 2)
+	assert.Len(t, delOut.Errors, 0)
+	entries, err = f.List(ctx, "bucket")
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestList(t *testing.T) {
+	_, c, _ := start(t, newOpt(), "")
+	_, err := c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b/c", "b/d/e", "b/f", "c d", "ee"} {
+		put(t, c, "bucket", key, key)
+	}
+	keys := func(contents []*s3.Object, prefixes []*s3.CommonPrefix) (out []string) {
+		for _, o := range contents {
+			out = append(out, *o.Key)
+		}
+		for _, p := range prefixes {
+			out = append(out, *p.Prefix)
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	for _, test := range []struct {
+		prefix    string
+		delimiter string
+		want      []string
+	}{
+		{"", "", []string{"a", "b/c", "b/d/e", "b/f", "c d", "ee"}},
+		{"", "/", []string{"a", "b/", "c d", "ee"}},
+		{"b/", "/", []string{"b/c", "b/d/", "b/f"}},
+		{"b", "/", []string{"b/"}},
+		{"b/d", "", []string{"b/d/e"}},
+		{"e", "/", []string{"ee"}},
+		{"", "d", []string{"a", "b/c", "b/d", "b/f", "c d", "ee"}},
+		{"missing/", "/", nil},
+	} {
+		v2, err := c.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:    aws.String("bucket"),
+			Prefix:    aws.String(test.prefix),
+			Delimiter: aws.String(test.delimiter),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, test.want, keys(v2.Contents, v2.CommonPrefixes), "v2 %+v", test)
+
+		v1, err := c.ListObjects(&s3.ListObjectsInput{
+			Bucket:       aws.String("bucket"),
+			Prefix:       aws.String(test.prefix),
+			Delimiter:    aws.String(test.delimiter),
+			EncodingType: aws.String("url"),
+		})
+		require.NoError(t, err)
+		var v1Keys []string
+		for _, key := range keys(v1.Contents, v1.CommonPrefixes) {
+			key, err = url.QueryUnescape(key)
+			require.NoError(t, err)
+			v1Keys = append(v1Keys, key)
+		}
+		assert.Equal(t, test.want, v1Keys, "v1 %+v", test)
+	}
+
+	// Paging
+	var got []string
+	err = c.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:  aws.String("bucket"),
+		MaxKeys: aws.Int64(2),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		assert.LessOrEqual(t, len(page.Contents), 2)
+		got = append(got, keys(page.Contents, nil)...)
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b/c", "b/d/e", "b/f", "c d", "ee"}, got)
+
+	_, err = c.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("missing")})
+	assert.Equal(t, "NoSuchBucket", errCode(t, err))
+}
+
+func TestMultipart(t *testing.T) {
+	ctx := context.Background()
+	s, c, f := start(t, newOpt(), "")
+	_, err := c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	create, err := c.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/multipart"),
+	})
+	require.NoError(t, err)
+
+	var parts []*s3.CompletedPart
+	for i, contents := range []string{"one ", "two ", "three"} {
+		out, err := c.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String("bucket"),
+			Key:        aws.String("dir/multipart"),
+			UploadId:   create.UploadId,
+			PartNumber: aws.Int64(int64(i + 1)),
+			Body:       strings.NewReader(contents),
+		})
+		require.NoError(t, err)
+		parts = append(parts, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(int64(i + 1))})
+	}
+
+	listParts, err := c.ListParts(&s3.ListPartsInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("dir/multipart"),
+		UploadId: create.UploadId,
+	})
+	require.NoError(t, err)
+	assert.Len(t, listParts.Parts, 3)
+
+	// Parts in the wrong order
+	_, err = c.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("dir/multipart"),
+		UploadId:        create.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{parts[1], parts[0]}},
+	})
+	assert.Equal(t, "InvalidPartOrder", errCode(t, err))
+
+	complete, err := c.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("dir/multipart"),
+		UploadId:        create.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(*complete.ETag, `-3"`))
+
+	o, err := f.NewObject(ctx, "bucket/dir/multipart")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("one two three")), o.Size())
+	assert.Len(t, s.multipart.uploads, 0)
+
+	// Abort
+	create, err = c.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("aborted"),
+	})
+	require.NoError(t, err)
+	_, err = c.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("aborted"),
+		UploadId: create.UploadId,
+	})
+	require.NoError(t, err)
+	_, err = c.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("aborted"),
+		UploadId:   create.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       strings.NewReader("data"),
+	})
+	assert.Equal(t, "NoSuchUpload", errCode(t, err))
+}
+
+func TestAuth(t *testing.T) {
+	_, c, _ := start(t, newOpt(), "")
+	_, err := c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	put(t, c, "bucket", "file", "hello")
+
+	// Presigned URL
+	req, _ := c.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file"),
+	})
+	presigned, err := req.Presign(time.Minute)
+	require.NoError(t, err)
+	resp, err := http.Get(presigned)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(data))
+
+	// Tampered presigned URL
+	resp, err = http.Get(strings.Replace(presigned, "/file?", "/other?", 1))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Host not signed
+	require.Contains(t, presigned, "X-Amz-SignedHeaders=host&")
+	resp, err = http.Get(strings.Replace(presigned, "X-Amz-SignedHeaders=host&", "X-Amz-SignedHeaders=x-amz-date&", 1))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Unauthenticated
+	resp, err = http.Get(strings.Split(presigned, "?")[0])
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Wrong secret
+	_, c, _ = start(t, newOpt(), "WRONG")
+	_, err = c.ListBuckets(&s3.ListBucketsInput{})
+	assert.Equal(t, "SignatureDoesNotMatch", errCode(t, err))
+}
+
+func TestAnonymous(t *testing.T) {
+	_, c, _ := start(t, DefaultOpt, "")
+	_, err := c.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	put(t, c, "bucket", "file", "hello")
+}
+
+func TestChunkedReader(t *testing.T) {
+	body := "5;chunk-signature=x\r\nhello\r\n6\r\n world\r\n0\r\nx-amz-checksum-crc32:abc\r\n\r\n"
+	data, err := io.ReadAll(newChunkedReader(strings.NewReader(body), nil))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	_, err = io.ReadAll(newChunkedReader(strings.NewReader("5\r\nhel"), nil))
+	assert.Equal(t, errIncompleteBody, err)
+}
+
+func TestVerifyingReader(t *testing.T) {
+	want := sha256.Sum256([]byte("hello"))
+	in := newVerifyingReader(io.NopCloser(bytes.NewBufferString("hello")), sha256.New(), want[:], errContentSHA256Mismatch)
+	_, err := io.ReadAll(in)
+	require.NoError(t, err)
+
+	in = newVerifyingReader(io.NopCloser(bytes.NewBufferString("HELLO")), sha256.New(), want[:], errContentSHA256Mismatch)
+	_, err = io.ReadAll(in)
+	assert.Equal(t, errContentSHA256Mismatch, err)
+}
@@ -56,35 +56,46 @@ type conn struct {
 }
 
 // execCommand implements an extremely limited number of commands to
-// interoperate with the rclone sftp backend
-func (c *conn) execCommand(ctx context.Context, out io.Writer, command string) (err error) {
+// interoperate with the rclone sftp backend, scp and rsync
+func (c *conn) execCommand(ctx context.Context, in io.Reader, out io.Writer, command string) (err error) {
 	binary, args := command, ""
 	space := strings.Index(command, " ")
 	if space >= 0 {
 		binary = command[:space]
 		args = strings.TrimLeft(command[space+1:], " ")
 	}
+	rawArgs := args
 	args = shellUnEscape(args)
 	fs.Debugf(c.what, "exec command: binary = %q, args = %q", binary, args)
 	switch binary {
+	case "scp":
+		return c.runSCP(in, out, rawArgs)
+	case "rsync":
+		return c.runRsync(in, out, rawArgs)
 	case "df":
-		about := c.vfs.Fs().Features().About
-		if about == nil {
-			return errors.New("df not supported")
-		}
-		usage, err := about(ctx)
-		if err != nil {
-			return fmt.Errorf("about failed: %w", err)
-		}
 		total, used, free := int64(-1), int64(-1), int64(-1)
-		if usage.Total != nil {
-			total = *usage.Total / 1024
-		}
-		if usage.Used != nil {
-			used = *usage.Used / 1024
-		}
-		if usage.Free != nil {
-			free = *usage.Free / 1024
+		if c.vfs.Opt.Quota >= 0 {
+			// Report the user's quota rather than the backend
+			total, used, free = c.vfs.Statfs()
+			total, used, free = total/1024, used/1024, free/1024
+		} else {
+			about := c.vfs.Fs().Features().About
+			if about == nil {
+				return errors.New("df not supported")
+			}
+			usage, err := about(ctx)
+			if err != nil {
+				return fmt.Errorf("about failed: %w", err)
+			}
+			if usage.Total != nil {
+				total = *usage.Total / 1024
+			}
+			if usage.Used != nil {
+				used = *usage.Used / 1024
+			}
+			if usage.Free != nil {
+				free = *usage.Free / 1024
+			}
 		}
 		perc := int64(0)
 		if total > 0 && used >= 0 {
@@ -243,7 +254,7 @@ func (c *conn) handleChannel(newChannel ssh.NewChannel) {
 		}
 	} else {
 		var rc = uint32(0)
-		err := c.execCommand(context.TODO(), channel, command.Command)
+		err := c.execCommand(context.TODO(), channel, channel, command.Command)
 		if err != nil {
 			rc = 1
 			_, errPrint := fmt.Fprintf(channel.Stderr(), "%v\n", err)
@@ -0,0 +1,1409 @@
+//go:build !plan9
+// +build !plan9
+
+package sftp
+
+// Minimal server side of the rsync protocol for "rsync -e ssh"
+//
+// This speaks protocol version 29 which rsync clients since 2.6.4
+// can use. Files are always transferred whole - the rsync delta
+// algorithm isn't used - and only regular files and directories are
+// transferred.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/crypto/md4" //nolint:staticcheck // rsync protocol 29 uses MD4
+)
+
+const (
+	rsyncProtocol  = 29        // protocol version we speak
+	rsyncChunkSize = 32 * 1024 // size of the literal data blocks we send
+	rsyncMaxData   = 1 << 24   // biggest literal data block we accept
+	rsyncMaxRule   = 4096      // longest filter rule we accept
+	rsyncSumLength = md4.Size  // length of the whole file checksum
+	rsyncNdxDone   = -1        // index which ends a phase
+	rsyncMaxPhase  = 2         // number of phases after the first
+
+	// Multiplexed message codes
+	rsyncMplexBase    = 7
+	rsyncMsgData      = 0
+	rsyncMsgErrorXfer = 1
+	rsyncMsgInfo      = 2
+
+	// File list flags
+	xmitTopDir           = 1 << 0
+	xmitSameMode         = 1 << 1
+	xmitExtendedFlags    = 1 << 2
+	xmitSameUID          = 1 << 3
+	xmitSameGID          = 1 << 4
+	xmitSameName         = 1 << 5
+	xmitLongName         = 1 << 6
+	xmitSameTime         = 1 << 7
+	xmitSameRdevMajor    = 1 << 8
+	xmitHasIdevData      = 1 << 9
+	xmitSameDev          = 1 << 10
+	xmitRdevMinorIsSmall = 1 << 11
+
+	// Item flags sent with each file index
+	itemReportSize       = 1 << 2
+	itemReportTime       = 1 << 3
+	itemBasisTypeFollows = 1 << 11
+	itemXnameFollows     = 1 << 12
+	itemIsNew            = 1 << 13
+	itemTransfer         = 1 << 15
+
+	// Unix file types
+	sIFMT   = 0170000
+	sIFSOCK = 0140000
+	sIFLNK  = 0120000
+	sIFREG  = 0100000
+	sIFBLK  = 0060000
+	sIFDIR  = 0040000
+	sIFCHR  = 0020000
+	sIFIFO  = 0010000
+)
+
+// rsyncOptions are the options the rsync client passed to the server
+type rsyncOptions struct {
+	sender         bool
+	recursive      bool
+	dirs           bool
+	times          bool
+	omitDirTimes   bool
+	owner          bool
+	group          bool
+	links          bool
+	devices        bool
+	numericIDs     bool
+	sizeOnly       bool
+	ignoreTimes    bool
+	update         bool
+	existing       bool
+	ignoreExisting bool
+	dryRun         bool
+	modifyWindow   int64
+	checksumSeed   int32
+	args           []string // the paths after the options
+}
+
+// parseRsyncArgs parses the command line the rsync client runs on
+// the server
+func parseRsyncArgs(words []string) (opt rsyncOptions, err error) {
+	if len(words) == 0 || words[0] != "--server" {
+		return opt, errors.New("rsync: only --server mode is supported")
+	}
+	i := 1
+	for ; i < len(words); i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "-") {
+			break
+		}
+		if strings.HasPrefix(word, "--") {
+			name, value, _ := strings.Cut(word[2:], "=")
+			switch name {
+			case "sender":
+				opt.sender = true
+			case "numeric-ids":
+				opt.numericIDs = true
+			case "size-only":
+				opt.sizeOnly = true
+			case "ignore-times":
+				opt.ignoreTimes = true
+			case "existing":
+				opt.existing = true
+			case "ignore-existing":
+				opt.ignoreExisting = true
+			case "omit-dir-times":
+				opt.omitDirTimes = true
+			case "modify-window":
+				opt.modifyWindow, err = strconv.ParseInt(value, 10, 64)
+			case "checksum-seed":
+				var seed int64
+				seed, err = strconv.ParseInt(value, 10, 32)
+				opt.checksumSeed = int32(seed)
+			case "partial", "inplace", "whole-file", "safe-links", "no-implied-dirs",
+				"timeout", "contimeout", "bwlimit", "log-format", "out-format",
+				"no-whole-file", "block-size", "omit-link-times":
+				// no effect here
+			default:
+				return opt, fmt.Errorf("rsync: option --%s not supported", name)
+			}
+			if err != nil {
+				return opt, fmt.Errorf("rsync: bad value for --%s: %w", name, err)
+			}
+			continue
+		}
+	flags:
+		for _, flag := range word[1:] {
+			switch flag {
+			case 'r':
+				opt.recursive = true
+			case 'd':
+				opt.dirs = true
+			case 't':
+				opt.times = true
+			case 'O':
+				opt.omitDirTimes = true
+			case 'o':
+				opt.owner = true
+			case 'g':
+				opt.group = true
+			case 'l':
+				opt.links = true
+			case 'D':
+				opt.devices = true
+			case 'I':
+				opt.ignoreTimes = true
+			case 'u':
+				opt.update = true
+			case 'n':
+				opt.dryRun = true
+			case 'e':
+				// the rest of the word is the client's capabilities
+				break flags
+			case 'v', 'q', 'i', 'p', 'x', 'S', 'W', 'L', 'k', 'K', 'E', 'J', 'h':
+				// no effect here
+			default:
+				return opt, fmt.Errorf("rsync: option -%c not supported", flag)
+			}
+		}
+	}
+	if i >= len(words) {
+		return opt, errors.New("rsync: missing arguments")
+	}
+	// The first argument is the directory the paths are relative to
+	base := words[i]
+	for _, arg := range words[i+1:] {
+		opt.args = append(opt.args, path.Join(base, arg)+trailingSlash(arg))
+	}
+	if len(opt.args) == 0 {
+		return opt, errors.New("rsync: missing path")
+	}
+	return opt, nil
+}
+
+// trailingSlash returns "/" if p ends with one
+func trailingSlash(p string) string {
+	if strings.HasSuffix(p, "/") {
+		return "/"
+	}
+	return ""
+}
+
+// rsyncFile is an entry in the file list
+type rsyncFile struct {
+	name    string // path relative to the transfer, "." for the top directory
+	mode    uint32 // unix mode including the file type
+	size    int64
+	modTime int64  // unix time
+	topDir  bool   // set if this is a directory named on the command line
+	remote  string // path in the VFS if sending
+}
+
+func (f *rsyncFile) isDir() bool {
+	return f.mode&sIFMT == sIFDIR
+}
+
+func (f *rsyncFile) isRegular() bool {
+	return f.mode&sIFMT == sIFREG
+}
+
+// States and types for rsyncCompare
+const (
+	fncItem = iota
+	fncPath
+)
+
+const (
+	fncDir = iota
+	fncSlash
+	fncBase
+	fncTrailing
+)
+
+// fncCursor walks through the parts of a name for rsyncCompare
+type fncCursor struct {
+	f     *rsyncFile
+	base  string
+	s     string // part being compared
+	i     int    // position in s
+	state int
+	typ   int
+}
+
+func (c *fncCursor) baseType() int {
+	if c.f.isDir() {
+		return fncPath
+	}
+	return fncItem
+}
+
+func (c *fncCursor) init(f *rsyncFile, dir string, useDir bool) {
+	c.f = f
+	c.base = path.Base(f.name)
+	if !useDir {
+		c.typ, c.state, c.s = c.baseType(), fncBase, c.base
+		if c.typ == fncPath && c.base == "." {
+			// The top directory sorts first
+			c.typ, c.state, c.s = fncItem, fncTrailing, ""
+		}
+	} else {
+		c.typ, c.state, c.s = fncPath, fncDir, dir
+	}
+}
+
+// more returns true if there is more to read in the current part
+func (c *fncCursor) more() bool {
+	return c.i < len(c.s)
+}
+
+// cur returns the current byte or 0 at the end
+func (c *fncCursor) cur() byte {
+	if c.more() {
+		return c.s[c.i]
+	}
+	return 0
+}
+
+// next moves on to the next part of the name
+func (c *fncCursor) next() {
+	switch c.state {
+	case fncDir:
+		c.state, c.s, c.i = fncSlash, "/", 0
+	case fncSlash:
+		c.typ, c.state, c.s, c.i = c.baseType(), fncBase, c.base, 0
+	case fncBase:
+		c.state = fncTrailing
+		if c.typ == fncPath {
+			c.s, c.i = "/", 0
+			return
+		}
+		c.typ = fncItem
+	case fncTrailing:
+		c.typ = fncItem
+	}
+}
+
+// typeOrder returns the order of two different types
+func typeOrder(typ int) int {
+	if typ == fncPath {
+		return 1
+	}
+	return -1
+}
+
+// dirName returns the directory of name or "" if none
+func dirName(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// rsyncCompare orders the file list as rsync does for protocol 29 so
+// both ends agree on the index of each file.
+//
+// The top directory comes first then, within each directory, files
+// come before directories and each directory comes just before its
+// contents.
+func rsyncCompare(a, b *rsyncFile) int {
+	var c1, c2 fncCursor
+	dir1, dir2 := dirName(a.name), dirName(b.name)
+	sameDir := dir1 == dir2
+	c1.init(a, dir1, dir1 != "" && !sameDir)
+	c2.init(b, dir2, dir2 != "" && !sameDir)
+	if c1.typ != c2.typ {
+		return typeOrder(c1.typ)
+	}
+	for {
+		if !c1.more() {
+			c1.next()
+			if c2.more() && c1.typ != c2.typ {
+				return typeOrder(c1.typ)
+			}
+		}
+		if !c2.more() {
+			c2.next()
+			if c1.typ != c2.typ {
+				return typeOrder(c1.typ)
+			}
+		}
+		b1, b2 := c1.cur(), c2.cur()
+		if b1 != b2 {
+			return int(b1) - int(b2)
+		}
+		if b1 == 0 {
+			return 0
+		}
+		c1.i++
+		c2.i++
+	}
+}
+
+// sortRsyncFiles sorts files into rsync order
+func sortRsyncFiles(files []*rsyncFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return rsyncCompare(files[i], files[j]) < 0
+	})
+}
+
+// rsync serves one "rsync --server" command
+type rsync struct {
+	vfs     *vfs.VFS
+	what    string
+	opt     rsyncOptions
+	in      *bufio.Reader
+	read    int64 // bytes read from the client
+	seed    int32 // checksum seed
+	ioError int32 // set if there were errors making the file list
+
+	mu      sync.Mutex // protects the fields below
+	out     *bufio.Writer
+	written int64  // bytes written to the client
+	buf     []byte // data waiting to be sent
+	mux     bool   // set if output is multiplexed
+	werr    error  // first error writing to the client
+}
+
+// rsyncReader counts the input and flushes the output before
+// reading so the client has everything it needs to reply
+type rsyncReader struct {
+	r  *rsync
+	in io.Reader
+}
+
+func (rr rsyncReader) Read(p []byte) (n int, err error) {
+	if err := rr.r.flush(); err != nil {
+		return 0, err
+	}
+	n, err = rr.in.Read(p)
+	rr.r.read += int64(n)
+	return n, err
+}
+
+// runRsync runs the rsync server with the arguments after "rsync"
+func (c *conn) runRsync(in io.Reader, out io.Writer, args string) error {
+	words, err := shellSplit(args)
+	if err != nil {
+		return fmt.Errorf("rsync: bad arguments: %w", err)
+	}
+	opt, err := parseRsyncArgs(words)
+	if err != nil {
+		return err
+	}
+	r := &rsync{
+		vfs:  c.vfs,
+		what: c.what,
+		opt:  opt,
+		out:  bufio.NewWriter(out),
+		seed: opt.checksumSeed,
+	}
+	r.in = bufio.NewReader(rsyncReader{r: r, in: in})
+	if r.seed == 0 {
+		r.seed = int32(time.Now().Unix())
+	}
+	err = r.setup()
+	if err != nil {
+		return err
+	}
+	if opt.sender {
+		err = r.send()
+	} else {
+		err = r.receive()
+	}
+	if err != nil {
+		r.errorf("%v", err)
+	}
+	flushErr := r.flush()
+	if err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// setup agrees the protocol version and starts multiplexing
+func (r *rsync) setup() error {
+	r.writeInt(rsyncProtocol)
+	remote, err := r.readInt()
+	if err != nil {
+		return fmt.Errorf("rsync: failed to read protocol version: %w", err)
+	}
+	if remote < rsyncProtocol {
+		return fmt.Errorf("rsync: protocol version %d is too old - need %d", remote, rsyncProtocol)
+	}
+	fs.Debugf(r.what, "rsync: client protocol version %d, using %d", remote, rsyncProtocol)
+	r.writeInt(r.seed)
+	err = r.flush()
+	r.mu.Lock()
+	r.mux = true
+	r.mu.Unlock()
+	return err
+}
+
+// flushLocked sends the buffered data - call with mu held
+func (r *rsync) flushLocked() {
+	for len(r.buf) > 0 && r.werr == nil {
+		data := r.buf
+		if !r.mux {
+			_, r.werr = r.out.Write(data)
+			r.written += int64(len(data))
+			r.buf = r.buf[:0]
+			break
+		}
+		if len(data) > 0xFFFFFF {
+			data = data[:0xFFFFFF]
+		}
+		r.frameLocked(rsyncMsgData, data)
+		r.buf = r.buf[len(data):]
+	}
+	r.buf = r.buf[:0]
+}
+
+// frameLocked writes data as a multiplexed message - call with mu held
+func (r *rsync) frameLocked(code int, data []byte) {
+	if r.werr != nil {
+		return
+	}
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(rsyncMplexBase+code)<<24|uint32(len(data)))
+	_, r.werr = r.out.Write(header[:])
+	if r.werr == nil {
+		_, r.werr = r.out.Write(data)
+	}
+	r.written += int64(len(header) + len(data))
+}
+
+// flush sends everything written to the client
+func (r *rsync) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushLocked()
+	if r.werr == nil {
+		r.werr = r.out.Flush()
+	}
+	return r.werr
+}
+
+// write queues p to send to the client
+func (r *rsync) write(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = append(r.buf, p...)
+	if len(r.buf) >= rsyncChunkSize {
+		r.flushLocked()
+	}
+}
+
+func (r *rsync) writeByte(x byte) {
+	r.write([]byte{x})
+}
+
+func (r *rsync) writeShort(x uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], x)
+	r.write(b[:])
+}
+
+func (r *rsync) writeInt(x int32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(x))
+	r.write(b[:])
+}
+
+// writeLongint writes x in 4 bytes if it fits or 12 if not
+func (r *rsync) writeLongint(x int64) {
+	if x >= 0 && x <= 0x7FFFFFFF {
+		r.writeInt(int32(x))
+		return
+	}
+	var b [12]byte
+	binary.LittleEndian.PutUint32(b[0:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint64(b[4:], uint64(x))
+	r.write(b[:])
+}
+
+// writeVstring writes a string with a 1 or 2 byte length
+func (r *rsync) writeVstring(s []byte) {
+	if len(s) > 0x7F {
+		r.writeByte(byte(len(s)>>8) | 0x80)
+	}
+	r.writeByte(byte(len(s)))
+	r.write(s)
+}
+
+// message sends a message to the client to show to the user
+func (r *rsync) message(code int, text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.mux {
+		return
+	}
+	r.flushLocked()
+	r.frameLocked(code, []byte(text+"\n"))
+}
+
+// errorf logs an error and sends it to the client
+func (r *rsync) errorf(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fs.Errorf(r.what, "rsync: %s", msg)
+	r.message(rsyncMsgErrorXfer, "rclone: "+msg)
+}
+
+// infof logs a message and sends it to the client
+func (r *rsync) infof(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fs.Infof(r.what, "rsync: %s", msg)
+	r.message(rsyncMsgInfo, msg)
+}
+
+func (r *rsync) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r.in, b)
+	return b, err
+}
+
+func (r *rsync) readByte() (byte, error) {
+	return r.in.ReadByte()
+}
+
+func (r *rsync) readShort() (uint16, error) {
+	var b [2]byte
+	_, err := io.ReadFull(r.in, b[:])
+	return binary.LittleEndian.Uint16(b[:]), err
+}
+
+func (r *rsync) readInt() (int32, error) {
+	var b [4]byte
+	_, err := io.ReadFull(r.in, b[:])
+	return int32(binary.LittleEndian.Uint32(b[:])), err
+}
+
+func (r *rsync) readLongint() (int64, error) {
+	x, err := r.readInt()
+	if err != nil || x != -1 {
+		return int64(x), err
+	}
+	var b [8]byte
+	_, err = io.ReadFull(r.in, b[:])
+	return int64(binary.LittleEndian.Uint64(b[:])), err
+}
+
+func (r *rsync) readVstring() ([]byte, error) {
+	n, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	size := int(n)
+	if n&0x80 != 0 {
+		lo, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size = int(n&0x7F)<<8 | int(lo)
+	}
+	return r.readBytes(size)
+}
+
+// readNdx reads a file index and its attributes
+//
+// For rsyncNdxDone only ndx is set.
+func (r *rsync) readNdx() (ndx int32, iflags uint16, basis []byte, xname []byte, err error) {
+	ndx, err = r.readInt()
+	if err != nil || ndx == rsyncNdxDone {
+		return ndx, 0, nil, nil, err
+	}
+	iflags, err = r.readShort()
+	if err != nil {
+		return ndx, 0, nil, nil, err
+	}
+	if iflags&itemBasisTypeFollows != 0 {
+		basis, err = r.readBytes(1)
+		if err != nil {
+			return ndx, 0, nil, nil, err
+		}
+	}
+	if iflags&itemXnameFollows != 0 {
+		xname, err = r.readVstring()
+	}
+	return ndx, iflags, basis, xname, err
+}
+
+// writeNdx writes a file index and its attributes
+func (r *rsync) writeNdx(ndx int32, iflags uint16, basis []byte, xname []byte) {
+	r.writeInt(ndx)
+	r.writeShort(iflags)
+	if iflags&itemBasisTypeFollows != 0 {
+		r.write(basis)
+	}
+	if iflags&itemXnameFollows != 0 {
+		r.writeVstring(xname)
+	}
+}
+
+// readSumHead reads the block checksum header returning the number
+// of bytes of block checksums which follow it
+func (r *rsync) readSumHead() (sumsLength int64, err error) {
+	var head [4]int32 // count, block length, checksum length, remainder
+	for i := range head {
+		head[i], err = r.readInt()
+		if err != nil {
+			return 0, err
+		}
+	}
+	count, blength, s2length, remainder := head[0], head[1], head[2], head[3]
+	if count < 0 || blength < 0 || s2length < 0 || s2length > 64 || remainder < 0 || remainder > blength {
+		return 0, fmt.Errorf("rsync: protocol error: bad checksum header %v", head)
+	}
+	return int64(count) * int64(4+s2length), nil
+}
+
+// writeSumHead writes an empty checksum header which means the file
+// is sent whole
+func (r *rsync) writeSumHead() {
+	for i := 0; i < 4; i++ {
+		r.writeInt(0)
+	}
+}
+
+// newSum returns the hash for the whole file checksum
+func (r *rsync) newSum() interface {
+	io.Writer
+	Sum([]byte) []byte
+} {
+	h := md4.New()
+	var seed [4]byte
+	binary.LittleEndian.PutUint32(seed[:], uint32(r.seed))
+	_, _ = h.Write(seed[:])
+	return h
+}
+
+// unixMode returns the unix mode for node
+func unixMode(node vfs.Node) uint32 {
+	mode := uint32(node.Mode().Perm())
+	if node.IsDir() {
+		return sIFDIR | mode
+	}
+	return sIFREG | mode
+}
+
+// id returns the user or group ID to send
+func id(x uint32) int32 {
+	if x == ^uint32(0) {
+		return 0
+	}
+	return int32(x)
+}
+
+// send is the server side of "rsync remote:src dst"
+func (r *rsync) send() error {
+	fi, err := r.readFilterList()
+	if err != nil {
+		return err
+	}
+	files := r.makeFileList(fi)
+	sortRsyncFiles(files)
+	r.sendFileList(files)
+	if len(files) == 0 {
+		return nil
+	}
+	err = r.sendFiles(files)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	// Stats: bytes read, bytes written, total size, file list
+	// build and transfer time
+	r.mu.Lock()
+	written := r.written
+	r.mu.Unlock()
+	r.writeLongint(r.read)
+	r.writeLongint(written)
+	r.writeLongint(size)
+	r.writeLongint(0)
+	r.writeLongint(0)
+	// Wait for the client to say goodbye
+	ndx, _, _, _, err := r.readNdx()
+	if err != nil {
+		return fmt.Errorf("rsync: failed to read goodbye: %w", err)
+	}
+	if ndx != rsyncNdxDone {
+		return fmt.Errorf("rsync: protocol error: invalid packet at end of run (%d)", ndx)
+	}
+	return nil
+}
+
+// readFilterList reads the filter rules from the client
+func (r *rsync) readFilterList() (*filter.Filter, error) {
+	fi, err := filter.NewFilter(nil)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		n, err := r.readInt()
+		if err != nil {
+			return nil, fmt.Errorf("rsync: failed to read filter list: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 || n > rsyncMaxRule {
+			return nil, fmt.Errorf("rsync: protocol error: filter rule length %d", n)
+		}
+		rule, err := r.readBytes(int(n))
+		if err != nil {
+			return nil, fmt.Errorf("rsync: failed to read filter list: %w", err)
+		}
+		err = addRsyncRule(fi, string(rule))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+// addRsyncRule adds an rsync filter rule to fi
+func addRsyncRule(fi *filter.Filter, rule string) error {
+	kind, glob, _ := strings.Cut(rule, " ")
+	switch kind {
+	case "-", "H":
+		return fi.Add(false, glob)
+	case "+", "S":
+		return fi.Add(true, glob)
+	case "P", "R":
+		// only affect deletions on the receiver
+		return nil
+	case "!":
+		fi.Clear()
+		return nil
+	}
+	return fmt.Errorf("rsync: filter rule %q not supported", rule)
+}
+
+// makeFileList makes the list of files to send
+func (r *rsync) makeFileList(fi *filter.Filter) (files []*rsyncFile) {
+	seen := map[string]struct{}{}
+	add := func(name string, node vfs.Node, topDir bool) {
+		if _, found := seen[name]; found {
+			return
+		}
+		size := node.Size()
+		if node.IsDir() {
+			size = 0
+		} else if size < 0 {
+			r.errorf("%s: can't send file of unknown size", node.Path())
+			r.ioError |= 1
+			return
+		}
+		seen[name] = struct{}{}
+		files = append(files, &rsyncFile{
+			name:    name,
+			mode:    unixMode(node),
+			size:    size,
+			modTime: node.ModTime().Unix(),
+			topDir:  topDir,
+			remote:  node.Path(),
+		})
+	}
+	var addDir func(name string, dir *vfs.Dir, recurse bool)
+	addDir = func(name string, dir *vfs.Dir, recurse bool) {
+		entries, err := dir.ReadDirAll()
+		if err != nil {
+			r.errorf("%s: failed to list directory: %v", dir.Path(), err)
+			r.ioError |= 1
+			return
+		}
+		for _, node := range entries {
+			childName := path.Join(name, node.Name())
+			if node.IsDir() {
+				include, err := fi.IncludeDirectory(context.TODO(), nil)(childName)
+				if err != nil || !include {
+					continue
+				}
+				add(childName, node, false)
+				if recurse {
+					addDir(childName, node.(*vfs.Dir), true)
+				}
+			} else if fi.IncludeRemote(childName) {
+				add(childName, node, false)
+			}
+		}
+	}
+	for _, arg := range r.opt.args {
+		remote := cleanRemote(arg)
+		node, err := r.vfs.Stat(remote)
+		if err != nil {
+			r.errorf("link_stat %q failed: %v", arg, err)
+			r.ioError |= 1
+			continue
+		}
+		// "dir/" sends the contents of dir
+		contents := strings.HasSuffix(arg, "/") || remote == ""
+		name := "."
+		if !contents {
+			name = path.Base(remote)
+		}
+		if !node.IsDir() {
+			add(path.Base(remote), node, false)
+		} else if !r.opt.recursive && !r.opt.dirs {
+			r.infof("skipping directory %s", name)
+		} else {
+			add(name, node, true)
+			if contents || r.opt.recursive {
+				addDir(name, node.(*vfs.Dir), r.opt.recursive)
+			}
+		}
+	}
+	return files
+}
+
+// sendFileList sends the sorted file list
+func (r *rsync) sendFileList(files []*rsyncFile) {
+	uid, gid := id(r.vfs.Opt.UID), id(r.vfs.Opt.GID)
+	for _, f := range files {
+		var xflags uint16
+		if f.topDir {
+			xflags |= xmitTopDir
+		}
+		if len(f.name) > 255 {
+			xflags |= xmitLongName
+		}
+		if xflags == 0 && !f.isDir() {
+			xflags |= xmitTopDir
+		}
+		if xflags == 0 {
+			xflags |= xmitExtendedFlags
+			r.writeShort(xflags)
+		} else {
+			r.writeByte(byte(xflags))
+		}
+		if xflags&xmitLongName != 0 {
+			r.writeInt(int32(len(f.name)))
+		} else {
+			r.writeByte(byte(len(f.name)))
+		}
+		r.write([]byte(f.name))
+		r.writeLongint(f.size)
+		r.writeInt(int32(f.modTime))
+		r.writeInt(int32(f.mode))
+		if r.opt.owner {
+			r.writeInt(uid)
+		}
+		if r.opt.group {
+			r.writeInt(gid)
+		}
+	}
+	r.writeByte(0)
+	// empty user and group name lists
+	if !r.opt.numericIDs {
+		if r.opt.owner {
+			r.writeInt(0)
+		}
+		if r.opt.group {
+			r.writeInt(0)
+		}
+	}
+	r.writeInt(r.ioError)
+}
+
+// sendFiles sends the files the client asks for
+func (r *rsync) sendFiles(files []*rsyncFile) error {
+	phase := 0
+	for {
+		ndx, iflags, basis, xname, err := r.readNdx()
+		if err != nil {
+			return fmt.Errorf("rsync: failed to read file index: %w", err)
+		}
+		if ndx == rsyncNdxDone {
+			phase++
+			if phase > rsyncMaxPhase {
+				break
+			}
+			r.writeInt(rsyncNdxDone)
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(files) {
+			return fmt.Errorf("rsync: protocol error: bad file index %d", ndx)
+		}
+		if iflags&itemTransfer == 0 {
+			// Just echo it back for the client to log
+			r.writeNdx(ndx, iflags, basis, xname)
+			continue
+		}
+		if phase == rsyncMaxPhase {
+			return fmt.Errorf("rsync: protocol error: got transfer request in phase %d", phase)
+		}
+		sumsLength, err := r.readSumHead()
+		if err != nil {
+			return err
+		}
+		// We don't use the block checksums
+		_, err = io.CopyN(io.Discard, r.in, sumsLength)
+		if err != nil {
+			return fmt.Errorf("rsync: failed to read checksums: %w", err)
+		}
+		f := files[ndx]
+		if !f.isRegular() {
+			return fmt.Errorf("rsync: protocol error: transfer requested for non file %q", f.name)
+		}
+		fh, err := r.vfs.OpenFile(f.remote, os.O_RDONLY, 0)
+		if err != nil {
+			r.errorf("send_files failed to open %q: %v", f.remote, err)
+			continue
+		}
+		r.writeNdx(ndx, iflags, basis, xname)
+		r.writeSumHead()
+		err = r.sendData(fh)
+		closeErr := fh.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			r.errorf("read errors mapping %q: %v", f.remote, err)
+		} else {
+			fs.Infof(f.remote, "rsync: sent file")
+		}
+	}
+	r.writeInt(rsyncNdxDone)
+	return nil
+}
+
+// sendData sends the contents of in as literal data then the file
+// checksum
+//
+// If reading fails the checksum sent is wrong so the client discards
+// the file.
+func (r *rsync) sendData(in io.Reader) (err error) {
+	h := r.newSum()
+	buf := make([]byte, rsyncChunkSize)
+	for {
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			r.writeInt(int32(n))
+			r.write(buf[:n])
+			_, _ = h.Write(buf[:n])
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			err = readErr
+			_, _ = h.Write([]byte("read error"))
+			break
+		}
+	}
+	r.writeInt(0)
+	r.write(h.Sum(nil))
+	return err
+}
+
+// receive is the server side of "rsync src remote:dst"
+func (r *rsync) receive() error {
+	if len(r.opt.args) != 1 {
+		return errors.New("rsync: need exactly one destination")
+	}
+	files, err := r.receiveFileList()
+	if err != nil {
+		return err
+	}
+	sortRsyncFiles(files)
+
+	// Work out where the files go
+	dest := cleanRemote(r.opt.args[0])
+	node, err := r.vfs.Stat(dest)
+	single := err != nil && len(files) == 1 && files[0].isRegular() && !strings.HasSuffix(r.opt.args[0], "/")
+	if err == nil && !node.IsDir() {
+		if len(files) != 1 || !files[0].isRegular() {
+			return fmt.Errorf("rsync: destination %q is not a directory", dest)
+		}
+		single = true
+	}
+	remoteOf := func(f *rsyncFile) string {
+		if single {
+			return dest
+		}
+		return path.Join(dest, f.name)
+	}
+	if !single && err != nil && !r.opt.dryRun {
+		if err := r.vfs.MkdirAll(dest, 0777); err != nil {
+			return fmt.Errorf("rsync: failed to make destination %q: %w", dest, err)
+		}
+	}
+
+	// Make the directories and find which files we need
+	var want []int32
+	for i, f := range files {
+		remote := remoteOf(f)
+		switch {
+		case f.isDir():
+			if !r.opt.dryRun {
+				if err := r.vfs.MkdirAll(remote, 0777); err != nil {
+					r.errorf("%s: failed to make directory: %v", remote, err)
+				}
+			}
+		case f.isRegular():
+			if iflags := r.needed(f, remote); iflags != 0 && !r.opt.dryRun {
+				want = append(want, int32(i))
+			}
+		default:
+			r.infof("skipping non-regular file %q", f.name)
+		}
+	}
+
+	// Ask for the files while receiving them
+	iflags := make(map[int32]uint16, len(want))
+	for _, ndx := range want {
+		f := files[ndx]
+		iflags[ndx] = r.needed(f, remoteOf(f))
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, ndx := range want {
+			r.writeNdx(ndx, iflags[ndx], nil, nil)
+			r.writeSumHead()
+		}
+		// end of each phase then goodbye
+		for i := 0; i <= rsyncMaxPhase+1; i++ {
+			r.writeInt(rsyncNdxDone)
+		}
+		_ = r.flush()
+	}()
+	err = r.receiveFiles(files, remoteOf)
+	<-done
+	if err != nil {
+		return err
+	}
+
+	// Set the directory times now their contents are written
+	if r.opt.times && !r.opt.omitDirTimes && !r.opt.dryRun {
+		for _, f := range files {
+			if f.isDir() {
+				r.setModTime(remoteOf(f), f.modTime)
+			}
+		}
+	}
+	return nil
+}
+
+// needed returns the item flags to ask for f to be written to remote
+// or 0 if it isn't needed
+func (r *rsync) needed(f *rsyncFile, remote string) uint16 {
+	node, err := r.vfs.Stat(remote)
+	if err != nil {
+		if r.opt.existing {
+			return 0
+		}
+		return itemTransfer | itemIsNew
+	}
+	if r.opt.ignoreExisting || node.IsDir() {
+		return 0
+	}
+	modTime := node.ModTime().Unix()
+	if r.opt.update && modTime > f.modTime {
+		return 0
+	}
+	var iflags uint16
+	if node.Size() != f.size {
+		iflags |= itemReportSize
+	}
+	dt := modTime - f.modTime
+	if dt < 0 {
+		dt = -dt
+	}
+	if dt > r.opt.modifyWindow {
+		iflags |= itemReportTime
+	}
+	if r.opt.sizeOnly {
+		iflags &^= itemReportTime
+	}
+	if iflags == 0 && !r.opt.ignoreTimes {
+		return 0
+	}
+	return itemTransfer | iflags
+}
+
+// setModTime sets the modification time of remote
+func (r *rsync) setModTime(remote string, modTime int64) {
+	node, err := r.vfs.Stat(remote)
+	if err == nil {
+		err = node.SetModTime(time.Unix(modTime, 0))
+	}
+	if err != nil {
+		fs.Debugf(remote, "rsync: failed to set modification time: %v", err)
+	}
+}
+
+// receiveFileList reads the file list from the client
+func (r *rsync) receiveFileList() (files []*rsyncFile, err error) {
+	var (
+		lastName  string
+		mode      uint32
+		modTime   int32
+		rdevMajor int32
+	)
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return nil, fmt.Errorf("rsync: failed to read file list: %w", err)
+		}
+		if b == 0 {
+			break
+		}
+		xflags := uint16(b)
+		if xflags&xmitExtendedFlags != 0 {
+			b, err = r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			xflags |= uint16(b) << 8
+		}
+		var l1 int
+		if xflags&xmitSameName != 0 {
+			b, err = r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			l1 = int(b)
+		}
+		var l2 int32
+		if xflags&xmitLongName != 0 {
+			l2, err = r.readInt()
+		} else {
+			b, err = r.readByte()
+			l2 = int32(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l1 > len(lastName) || l2 < 0 || l2 > 4096 {
+			return nil, errors.New("rsync: protocol error: bad file name length")
+		}
+		suffix, err := r.readBytes(int(l2))
+		if err != nil {
+			return nil, err
+		}
+		lastName = lastName[:l1] + string(suffix)
+		f := &rsyncFile{
+			topDir: xflags&xmitTopDir != 0,
+		}
+		f.size, err = r.readLongint()
+		if err != nil {
+			return nil, err
+		}
+		if xflags&xmitSameTime == 0 {
+			if modTime, err = r.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		if xflags&xmitSameMode == 0 {
+			var x int32
+			if x, err = r.readInt(); err != nil {
+				return nil, err
+			}
+			mode = uint32(x)
+		}
+		f.modTime, f.mode = int64(modTime), mode
+		if r.opt.owner && xflags&xmitSameUID == 0 {
+			if _, err = r.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		if r.opt.group && xflags&xmitSameGID == 0 {
+			if _, err = r.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		switch mode & sIFMT {
+		case sIFCHR, sIFBLK, sIFIFO, sIFSOCK:
+			if r.opt.devices {
+				if xflags&xmitSameRdevMajor == 0 {
+					if rdevMajor, err = r.readInt(); err != nil {
+						return nil, err
+					}
+				}
+				_ = rdevMajor
+				if xflags&xmitRdevMinorIsSmall != 0 {
+					_, err = r.readByte()
+				} else {
+					_, err = r.readInt()
+				}
+			}
+		case sIFLNK:
+			if r.opt.links {
+				var n int32
+				if n, err = r.readInt(); err == nil {
+					if n < 0 || n > 4096 {
+						return nil, errors.New("rsync: protocol error: bad link length")
+					}
+					_, err = r.readBytes(int(n))
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if xflags&xmitHasIdevData != 0 {
+			if xflags&xmitSameDev == 0 {
+				if _, err = r.readLongint(); err != nil {
+					return nil, err
+				}
+			}
+			if _, err = r.readLongint(); err != nil {
+				return nil, err
+			}
+		}
+		f.name, err = cleanRsyncName(lastName)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	// user and group name lists which we ignore
+	if !r.opt.numericIDs {
+		for _, wanted := range []bool{r.opt.owner, r.opt.group} {
+			if !wanted {
+				continue
+			}
+			for {
+				x, err := r.readInt()
+				if err != nil {
+					return nil, err
+				}
+				if x == 0 {
+					break
+				}
+				if _, err = r.readVstring(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	ioError, err := r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if ioError != 0 {
+		fs.Debugf(r.what, "rsync: client had errors making the file list")
+	}
+	return files, nil
+}
+
+// cleanRsyncName checks a name from the client is safe to use
+func cleanRsyncName(name string) (string, error) {
+	cleaned := path.Clean(name)
+	if strings.HasPrefix(name, "/") || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("rsync: unsafe file name %q", name)
+	}
+	return cleaned, nil
+}
+
+// receiveFiles receives the files from the client
+func (r *rsync) receiveFiles(files []*rsyncFile, remoteOf func(*rsyncFile) string) error {
+	phase := 0
+	for {
+		ndx, iflags, _, _, err := r.readNdx()
+		if err != nil {
+			return fmt.Errorf("rsync: failed to read file index: %w", err)
+		}
+		if ndx == rsyncNdxDone {
+			phase++
+			if phase > rsyncMaxPhase {
+				return nil
+			}
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(files) || !files[ndx].isRegular() {
+			return fmt.Errorf("rsync: protocol error: bad file index %d", ndx)
+		}
+		if iflags&itemTransfer == 0 {
+			continue
+		}
+		err = r.receiveFile(files[ndx], remoteOf(files[ndx]))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile receives the data for f writing it to remote
+//
+// Only errors which stop the protocol are returned.
+func (r *rsync) receiveFile(f *rsyncFile, remote string) error {
+	sumsLength, err := r.readSumHead()
+	if err != nil {
+		return err
+	}
+	if sumsLength != 0 {
+		return errors.New("rsync: protocol error: unexpected checksums")
+	}
+	fh, fileErr := r.vfs.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	h := r.newSum()
+	for {
+		n, err := r.readInt()
+		if err != nil {
+			return fmt.Errorf("rsync: failed to read data: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			return fmt.Errorf("rsync: protocol error: unexpected block match in %q", f.name)
+		}
+		if n > rsyncMaxData {
+			return fmt.Errorf("rsync: protocol error: data block too big (%d)", n)
+		}
+		data, err := r.readBytes(int(n))
+		if err != nil {
+			return fmt.Errorf("rsync: failed to read data: %w", err)
+		}
+		_, _ = h.Write(data)
+		if fileErr == nil {
+			_, fileErr = fh.Write(data)
+		}
+	}
+	sum, err := r.readBytes(rsyncSumLength)
+	if err != nil {
+		return fmt.Errorf("rsync: failed to read checksum: %w", err)
+	}
+	if fh != nil {
+		closeErr := fh.Close()
+		if fileErr == nil {
+			fileErr = closeErr
+		}
+	}
+	if fileErr == nil && !bytes.Equal(sum, h.Sum(nil)) {
+		fileErr = errors.New("checksum mismatch")
+	}
+	if fileErr != nil {
+		if fh != nil {
+			// Don't leave a partial file behind
+			_ = r.vfs.Remove(remote)
+		}
+		r.errorf("%s: failed to receive file: %v", remote, fileErr)
+		return nil
+	}
+	if r.opt.times {
+		r.setModTime(remote, f.modTime)
+	}
+	fs.Infof(remote, "rsync: received file")
+	return nil
+}
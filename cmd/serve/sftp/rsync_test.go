@@ -0,0 +1,320 @@
+//go:build !plan9
+// +build !plan9
+
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/md4" //nolint:staticcheck // rsync protocol 29 uses MD4
+)
+
+func TestParseRsyncArgs(t *testing.T) {
+	opt, err := parseRsyncArgs([]string{"--server", "--sender", "-vlogDtpre.iLsfxC", "--numeric-ids", "--modify-window=2", ".", "dir/", "file"})
+	require.NoError(t, err)
+	assert.True(t, opt.sender)
+	assert.True(t, opt.recursive)
+	assert.True(t, opt.times)
+	assert.True(t, opt.owner)
+	assert.True(t, opt.numericIDs)
+	assert.Equal(t, int64(2), opt.modifyWindow)
+	assert.Equal(t, []string{"dir/", "file"}, opt.args)
+
+	for _, args := range [][]string{
+		{"--daemon", "."},
+		{"--server", "-c", ".", "x"},
+		{"--server", "--delete", ".", "x"},
+		{"--server", "-r", "."},
+		{"--server", "-r"},
+	} {
+		_, err := parseRsyncArgs(args)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestRsyncCompare(t *testing.T) {
+	dir := func(name string) *rsyncFile {
+		return &rsyncFile{name: name, mode: sIFDIR | 0755}
+	}
+	file := func(name string) *rsyncFile {
+		return &rsyncFile{name: name, mode: sIFREG | 0644}
+	}
+	want := []*rsyncFile{
+		dir("."),
+		file("a.txt"),
+		file("b"),
+		file("b.txt"),
+		dir("a"),
+		file("a/z"),
+		dir("a/b"),
+		file("a/b/c"),
+		dir("b-dir"),
+		file("b-dir/x"),
+	}
+	files := []*rsyncFile{}
+	for i := len(want) - 1; i >= 0; i-- {
+		files = append(files, want[i])
+	}
+	sortRsyncFiles(files)
+	var got, wantNames []string
+	for i := range files {
+		got = append(got, files[i].name)
+		wantNames = append(wantNames, want[i].name)
+	}
+	assert.Equal(t, wantNames, got)
+	for i := range want {
+		assert.Equal(t, 0, rsyncCompare(want[i], want[i]))
+		for j := i + 1; j < len(want); j++ {
+			assert.Less(t, rsyncCompare(want[i], want[j]), 0, "%s < %s", want[i].name, want[j].name)
+			assert.Greater(t, rsyncCompare(want[j], want[i]), 0, "%s > %s", want[j].name, want[i].name)
+		}
+	}
+}
+
+// rsyncClient builds the input a client sends
+type rsyncClient struct {
+	bytes.Buffer
+}
+
+func (c *rsyncClient) int(x int32) {
+	_ = binary.Write(c, binary.LittleEndian, x)
+}
+
+func (c *rsyncClient) short(x uint16) {
+	_ = binary.Write(c, binary.LittleEndian, x)
+}
+
+// file sends a file list entry
+func (c *rsyncClient) file(xflags uint16, name string, size int64, modTime int32, mode uint32) {
+	if xflags == 0 {
+		c.short(xmitExtendedFlags)
+	} else {
+		_ = c.WriteByte(byte(xflags))
+	}
+	_ = c.WriteByte(byte(len(name)))
+	_, _ = c.WriteString(name)
+	c.int(int32(size))
+	c.int(modTime)
+	c.int(int32(mode))
+}
+
+// data sends the contents of a file
+func (c *rsyncClient) data(seed int32, data string) {
+	c.int(int32(len(data)))
+	_, _ = c.WriteString(data)
+	c.int(0)
+	h := md4.New()
+	_ = binary.Write(h, binary.LittleEndian, seed)
+	_, _ = h.Write([]byte(data))
+	_, _ = c.Write(h.Sum(nil))
+}
+
+// rsyncServerOutput reads what the server sent
+type rsyncServerOutput struct {
+	t        *testing.T
+	data     *bytes.Reader
+	messages []string
+}
+
+// newRsyncServerOutput checks the header and demultiplexes the rest
+func newRsyncServerOutput(t *testing.T, out []byte) *rsyncServerOutput {
+	require.True(t, len(out) >= 8)
+	assert.Equal(t, uint32(rsyncProtocol), binary.LittleEndian.Uint32(out))
+	out = out[8:]
+	var data []byte
+	o := &rsyncServerOutput{t: t}
+	for len(out) > 0 {
+		require.True(t, len(out) >= 4)
+		header := binary.LittleEndian.Uint32(out)
+		code, n := int(header>>24)-rsyncMplexBase, int(header&0xFFFFFF)
+		require.True(t, len(out) >= 4+n)
+		if code == rsyncMsgData {
+			data = append(data, out[4:4+n]...)
+		} else {
+			o.messages = append(o.messages, string(out[4:4+n]))
+		}
+		out = out[4+n:]
+	}
+	o.data = bytes.NewReader(data)
+	return o
+}
+
+func (o *rsyncServerOutput) int() (x int32) {
+	require.NoError(o.t, binary.Read(o.data, binary.LittleEndian, &x))
+	return x
+}
+
+func (o *rsyncServerOutput) short() (x uint16) {
+	require.NoError(o.t, binary.Read(o.data, binary.LittleEndian, &x))
+	return x
+}
+
+func (o *rsyncServerOutput) bytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := io.ReadFull(o.data, b)
+	require.NoError(o.t, err)
+	return b
+}
+
+func TestRsyncPush(t *testing.T) {
+	c, dir := newTestConn(t)
+	const seed = 42
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "same.txt"), []byte("same"), 0666))
+	modTime := time.Unix(1600000000, 0)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "same.txt"), modTime, modTime))
+
+	var in rsyncClient
+	in.int(rsyncProtocol)
+	in.file(xmitTopDir, ".", 0, 1600000000, sIFDIR|0755)
+	in.file(0, "a.txt", 5, 1600000000, sIFREG|0644)
+	in.file(0, "same.txt", 4, 1600000000, sIFREG|0644)
+	in.file(0, "sub", 0, 1600000000, sIFDIR|0755)
+	in.file(0, "sub/b.txt", 3, 1600000000, sIFREG|0644)
+	in.file(0, "link", 0, 1600000000, sIFLNK|0777)
+	_ = in.WriteByte(0)
+	in.int(0) // io_error
+	// the sender replies to the server's requests
+	for _, f := range []struct {
+		ndx  int32
+		data string
+	}{{1, "hello"}, {5, "abc"}} {
+		in.int(f.ndx)
+		in.short(itemTransfer | itemIsNew)
+		for i := 0; i < 4; i++ {
+			in.int(0)
+		}
+		in.data(seed, f.data)
+	}
+	for i := 0; i <= rsyncMaxPhase; i++ {
+		in.int(rsyncNdxDone)
+	}
+
+	var out bytes.Buffer
+	err := c.runRsync(&in, &out, "--server -tre.iLsfxC --checksum-seed=42 . .")
+	require.NoError(t, err)
+	assert.Equal(t, 0, in.Len(), "didn't read all the input")
+
+	o := newRsyncServerOutput(t, out.Bytes())
+	assert.Equal(t, []string{"skipping non-regular file \"link\"\n"}, o.messages)
+	for _, ndx := range []int32{1, 5} {
+		assert.Equal(t, ndx, o.int())
+		assert.Equal(t, uint16(itemTransfer|itemIsNew), o.short())
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, int32(0), o.int())
+		}
+	}
+	for i := 0; i <= rsyncMaxPhase+1; i++ {
+		assert.Equal(t, int32(rsyncNdxDone), o.int())
+	}
+	assert.Equal(t, 0, o.data.Len())
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	data, err = os.ReadFile(filepath.Join(dir, "sub", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+	fi, err := os.Stat(filepath.Join(dir, "sub", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, modTime, fi.ModTime())
+}
+
+func TestRsyncPushBadChecksum(t *testing.T) {
+	c, dir := newTestConn(t)
+	var in rsyncClient
+	in.int(rsyncProtocol)
+	in.file(0, "a.txt", 5, 1600000000, sIFREG|0644)
+	_ = in.WriteByte(0)
+	in.int(0)
+	in.int(0)
+	in.short(itemTransfer | itemIsNew)
+	for i := 0; i < 4; i++ {
+		in.int(0)
+	}
+	in.data(1, "hello") // wrong seed
+	for i := 0; i <= rsyncMaxPhase; i++ {
+		in.int(rsyncNdxDone)
+	}
+	var out bytes.Buffer
+	err := c.runRsync(&in, &out, "--server -te.iLsfxC --checksum-seed=42 . a.txt")
+	require.NoError(t, err)
+	o := newRsyncServerOutput(t, out.Bytes())
+	require.Equal(t, 1, len(o.messages))
+	assert.Contains(t, o.messages[0], "checksum mismatch")
+	_, err = os.Stat(filepath.Join(dir, "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRsyncPull(t *testing.T) {
+	c, dir := newTestConn(t)
+	const seed = 42
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "src"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("hello"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "b.log"), []byte("excluded"), 0666))
+
+	var in rsyncClient
+	in.int(rsyncProtocol)
+	rule := "- *.log"
+	in.int(int32(len(rule)))
+	_, _ = in.WriteString(rule)
+	in.int(0)
+	// ask for a.txt
+	in.int(1)
+	in.short(itemTransfer | itemIsNew)
+	for i := 0; i < 4; i++ {
+		in.int(0)
+	}
+	for i := 0; i <= rsyncMaxPhase+1; i++ {
+		in.int(rsyncNdxDone)
+	}
+
+	var out bytes.Buffer
+	err := c.runRsync(&in, &out, "--server --sender -re.iLsfxC --checksum-seed=42 . src/")
+	require.NoError(t, err)
+	assert.Equal(t, 0, in.Len(), "didn't read all the input")
+
+	o := newRsyncServerOutput(t, out.Bytes())
+	assert.Nil(t, o.messages)
+	// file list
+	assert.Equal(t, []byte{xmitTopDir, 1, '.'}, o.bytes(3))
+	assert.Equal(t, int32(0), o.int())
+	o.int()
+	assert.Equal(t, int32(sIFDIR|0777), o.int())
+	assert.Equal(t, []byte{xmitTopDir, 5}, o.bytes(2))
+	assert.Equal(t, "a.txt", string(o.bytes(5)))
+	assert.Equal(t, int32(5), o.int())
+	o.int()
+	assert.Equal(t, int32(sIFREG|0666), o.int())
+	assert.Equal(t, []byte{0}, o.bytes(1))
+	assert.Equal(t, int32(0), o.int()) // io_error
+	// the file
+	assert.Equal(t, int32(1), o.int())
+	assert.Equal(t, uint16(itemTransfer|itemIsNew), o.short())
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, int32(0), o.int())
+	}
+	assert.Equal(t, int32(5), o.int())
+	assert.Equal(t, "hello", string(o.bytes(5)))
+	assert.Equal(t, int32(0), o.int())
+	h := md4.New()
+	_ = binary.Write(h, binary.LittleEndian, int32(seed))
+	_, _ = h.Write([]byte("hello"))
+	assert.Equal(t, h.Sum(nil), o.bytes(rsyncSumLength))
+	// end of phases
+	for i := 0; i <= rsyncMaxPhase; i++ {
+		assert.Equal(t, int32(rsyncNdxDone), o.int())
+	}
+	// stats
+	for i := 0; i < 5; i++ {
+		o.int()
+	}
+	assert.Equal(t, 0, o.data.Len())
+}
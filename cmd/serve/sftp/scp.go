@@ -0,0 +1,560 @@
+//go:build !plan9
+// +build !plan9
+
+package sftp
+
+// Server side of the scp protocol as used by "scp -O"
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/readers"
+	"github.com/rclone/rclone/vfs"
+)
+
+// errSCPFatal is returned when the client reports a fatal error
+var errSCPFatal = errors.New("scp: fatal error from client")
+
+// scp serves one "scp -t" or "scp -f" command
+type scp struct {
+	vfs       *vfs.VFS
+	what      string
+	in        *bufio.Reader
+	out       io.Writer
+	recursive bool // -r
+	preserve  bool // -p
+	targetDir bool // -d
+	errors    int  // count of non fatal errors
+}
+
+// shellSplit splits args into words as a shell would, handling
+// single quotes, double quotes and backslash escapes.
+func shellSplit(args string) (words []string, err error) {
+	var (
+		word    strings.Builder
+		inWord  bool
+		quote   byte
+		escaped bool
+	)
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case escaped:
+			word.WriteByte(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				if i+1 < len(args) && strings.IndexByte("\\\"$`", args[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(args[i])
+			default:
+				word.WriteByte(c)
+			}
+		case c == '\\':
+			escaped, inWord = true, true
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// cleanRemote turns a path from the client into a path in the VFS
+func cleanRemote(p string) string {
+	p = strings.TrimPrefix(p, "~")
+	p = path.Clean("/" + p)
+	return strings.TrimPrefix(p, "/")
+}
+
+// runSCP runs the scp server with the arguments after "scp"
+func (c *conn) runSCP(in io.Reader, out io.Writer, args string) error {
+	words, err := shellSplit(args)
+	if err != nil {
+		return fmt.Errorf("scp: bad arguments: %w", err)
+	}
+	s := &scp{
+		vfs:  c.vfs,
+		what: c.what,
+		in:   bufio.NewReader(in),
+		out:  out,
+	}
+	var sink, source bool
+	var paths []string
+	for i, word := range words {
+		if word == "--" {
+			paths = append(paths, words[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(word, "-") || word == "-" {
+			paths = append(paths, word)
+			continue
+		}
+		for _, flag := range word[1:] {
+			switch flag {
+			case 't':
+				sink = true
+			case 'f':
+				source = true
+			case 'r':
+				s.recursive = true
+			case 'p':
+				s.preserve = true
+			case 'd':
+				s.targetDir = true
+			case 'v', 'q':
+			default:
+				return fmt.Errorf("scp: unknown option -%c", flag)
+			}
+		}
+	}
+	switch {
+	case sink == source:
+		return errors.New("scp: need exactly one of -t or -f")
+	case sink:
+		if len(paths) != 1 {
+			return errors.New("scp: need exactly one target")
+		}
+		err = s.sink(cleanRemote(paths[0]))
+	default:
+		err = s.source(paths)
+	}
+	if err == nil && s.errors > 0 {
+		err = fmt.Errorf("scp: %d errors", s.errors)
+	}
+	return err
+}
+
+// ack tells the client the last command was OK
+func (s *scp) ack() error {
+	_, err := s.out.Write([]byte{0})
+	return err
+}
+
+// runErr reports a non fatal error to the client
+func (s *scp) runErr(format string, a ...interface{}) error {
+	s.errors++
+	msg := fmt.Sprintf(format, a...)
+	fs.Infof(s.what, "scp: %s", msg)
+	_, err := fmt.Fprintf(s.out, "\x01scp: %s\n", strings.ReplaceAll(msg, "\n", " "))
+	return err
+}
+
+// response reads the client's reply to a command returning whether
+// it was OK
+func (s *scp) response() (ok bool, err error) {
+	c, err := s.in.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch c {
+	case 0:
+		return true, nil
+	case 1, 2:
+		msg, err := s.in.ReadString('\n')
+		if err != nil {
+			return false, err
+		}
+		fs.Infof(s.what, "scp: client error: %s", strings.TrimSpace(msg))
+		if c == 2 {
+			return false, errSCPFatal
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("scp: protocol error: unexpected response %q", c)
+}
+
+// parseTime parses the line after "T"
+func parseTime(line string) (time.Time, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return time.Time{}, errors.New("mtime.sec not present")
+	}
+	sec, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad mtime.sec: %w", err)
+	}
+	usec, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad mtime.usec: %w", err)
+	}
+	return time.Unix(sec, usec*1000), nil
+}
+
+// parseEntry parses the line after "C" or "D"
+//
+// The mode is checked but not used as the VFS sets the permissions.
+func parseEntry(line string) (size int64, name string, err error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return 0, "", errors.New("bad entry")
+	}
+	_, err = strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, "", errors.New("bad mode")
+	}
+	size, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, "", errors.New("size not delimited")
+	}
+	name = fields[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, "", fmt.Errorf("unexpected filename: %q", name)
+	}
+	return size, name, nil
+}
+
+// setModTime sets the modification time of remote if it isn't zero
+func (s *scp) setModTime(remote string, modTime time.Time) {
+	if modTime.IsZero() {
+		return
+	}
+	node, err := s.vfs.Stat(remote)
+	if err == nil {
+		err = node.SetModTime(modTime)
+	}
+	if err != nil {
+		fs.Debugf(remote, "scp: failed to set modification time: %v", err)
+	}
+}
+
+// sink receives files from the client into target
+func (s *scp) sink(target string) error {
+	node, err := s.vfs.Stat(target)
+	isDir := err == nil && node.IsDir()
+	if s.targetDir && !isDir {
+		if err := s.runErr("%s: Not a directory", target); err != nil {
+			return err
+		}
+		return nil
+	}
+	if err := s.ack(); err != nil {
+		return err
+	}
+	return s.sinkDir(target, isDir, 0)
+}
+
+// sinkDir receives entries into target until "E" at depth
+func (s *scp) sinkDir(target string, isDir bool, depth int) error {
+	var modTime time.Time
+	for {
+		c, err := s.in.ReadByte()
+		if err == io.EOF {
+			if depth > 0 {
+				return errors.New("scp: unexpected end of input")
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("scp: lost connection: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch c {
+		case 1:
+			fs.Infof(s.what, "scp: client warning: %s", line)
+			continue
+		case 2:
+			fs.Infof(s.what, "scp: client error: %s", line)
+			return errSCPFatal
+		case 'E':
+			if depth == 0 {
+				return errors.New("scp: protocol error: unexpected <newline>")
+			}
+			return s.ack()
+		case 'T':
+			modTime, err = parseTime(line)
+			if err != nil {
+				return fmt.Errorf("scp: protocol error: %w", err)
+			}
+			if err := s.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return fmt.Errorf("scp: protocol error: expected control record, got %q", c)
+		}
+		size, name, err := parseEntry(line)
+		if err != nil {
+			return fmt.Errorf("scp: protocol error: %w", err)
+		}
+		remote := target
+		if isDir {
+			remote = path.Join(target, name)
+		}
+		if c == 'D' {
+			err = s.sinkSubDir(remote)
+		} else {
+			err = s.sinkFile(remote, size)
+		}
+		if err != nil {
+			return err
+		}
+		if !s.preserve {
+			modTime = time.Time{}
+		}
+		s.setModTime(remote, modTime)
+		modTime = time.Time{}
+	}
+}
+
+// sinkSubDir receives a directory into remote
+func (s *scp) sinkSubDir(remote string) error {
+	if !s.recursive {
+		return s.runErr("received directory without -r")
+	}
+	node, err := s.vfs.Stat(remote)
+	if err == nil && !node.IsDir() {
+		return s.runErr("%s: Not a directory", remote)
+	} else if err != nil {
+		err = s.vfs.Mkdir(remote, 0777)
+		if err != nil {
+			return s.runErr("%s: %v", remote, err)
+		}
+	}
+	if err := s.ack(); err != nil {
+		return err
+	}
+	return s.sinkDir(remote, true, 1)
+}
+
+// sinkFile receives size bytes into the file at remote
+func (s *scp) sinkFile(remote string, size int64) error {
+	fh, err := s.vfs.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return s.runErr("%s: %v", remote, err)
+	}
+	if err := s.ack(); err != nil {
+		_ = fh.Close()
+		return err
+	}
+	// Count what is read as a failed write may not write all of it
+	in := readers.NewCountingReader(s.in)
+	_, writeErr := io.CopyN(fh, in, size)
+	if read := int64(in.BytesRead()); writeErr != nil && read < size {
+		// Read the rest of the file so we stay in sync
+		if _, err := io.CopyN(io.Discard, s.in, size-read); err != nil {
+			_ = fh.Close()
+			return fmt.Errorf("scp: lost connection: %w", err)
+		}
+	}
+	closeErr := fh.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	ok, err := s.response()
+	if err != nil {
+		return err
+	}
+	if !ok && writeErr == nil {
+		writeErr = errors.New("client failed to send file")
+	}
+	if writeErr != nil {
+		// Don't leave a partial file behind
+		_ = s.vfs.Remove(remote)
+		return s.runErr("%s: %v", remote, writeErr)
+	}
+	fs.Infof(remote, "scp: received file")
+	return s.ack()
+}
+
+// source sends the files in paths to the client
+func (s *scp) source(paths []string) error {
+	ok, err := s.response()
+	if err != nil || !ok {
+		return err
+	}
+	for _, p := range paths {
+		remote := cleanRemote(p)
+		node, err := s.vfs.Stat(remote)
+		if err != nil {
+			err = s.runErr("%s: %v", p, err)
+		} else {
+			err = s.sourceNode(node)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendTime sends the modification time of node if preserving
+func (s *scp) sendTime(node vfs.Node) (ok bool, err error) {
+	if !s.preserve {
+		return true, nil
+	}
+	modTime := node.ModTime().Unix()
+	_, err = fmt.Fprintf(s.out, "T%d 0 %d 0\n", modTime, modTime)
+	if err != nil {
+		return false, err
+	}
+	return s.response()
+}
+
+// sourceNode sends the file or directory at node
+func (s *scp) sourceNode(node vfs.Node) error {
+	name := node.Name()
+	if node.Path() == "" {
+		// the root
+		name = "."
+	}
+	if node.IsDir() {
+		if !s.recursive {
+			return s.runErr("%s: not a regular file", node.Path())
+		}
+		return s.sourceDir(node.(*vfs.Dir), name)
+	}
+	return s.sourceFile(node.(*vfs.File), name)
+}
+
+// sourceDir sends the directory dir calling it name
+func (s *scp) sourceDir(dir *vfs.Dir, name string) error {
+	entries, err := dir.ReadDirAll()
+	if err != nil {
+		return s.runErr("%s: %v", dir.Path(), err)
+	}
+	ok, err := s.sendTime(dir)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "D%04o 0 %s\n", dir.Mode()&os.ModePerm, name)
+	if err != nil {
+		return err
+	}
+	ok, err = s.response()
+	if err != nil || !ok {
+		return err
+	}
+	for _, node := range entries {
+		if err := s.sourceNode(node); err != nil {
+			return err
+		}
+	}
+	_, err = s.out.Write([]byte("E\n"))
+	if err != nil {
+		return err
+	}
+	_, err = s.response()
+	return err
+}
+
+// sourceFile sends the file calling it name
+func (s *scp) sourceFile(file *vfs.File, name string) error {
+	size := file.Size()
+	if size < 0 {
+		return s.runErr("%s: file of unknown size", file.Path())
+	}
+	fh, err := file.Open(os.O_RDONLY)
+	if err != nil {
+		return s.runErr("%s: %v", file.Path(), err)
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+	ok, err := s.sendTime(file)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "C%04o %d %s\n", file.Mode()&os.ModePerm, size, name)
+	if err != nil {
+		return err
+	}
+	ok, err = s.response()
+	if err != nil || !ok {
+		return err
+	}
+	sent, readErr := s.copyFile(fh, size)
+	if sent < 0 {
+		return readErr
+	}
+	if readErr != nil {
+		// Pad the file so the client stays in sync
+		if _, err := io.CopyN(s.out, zeroReader{}, size-sent); err != nil {
+			return err
+		}
+		if err := s.runErr("%s: %v", file.Path(), readErr); err != nil {
+			return err
+		}
+	} else if err := s.ack(); err != nil {
+		return err
+	}
+	_, err = s.response()
+	if err == nil && readErr == nil {
+		fs.Infof(file.Path(), "scp: sent file")
+	}
+	return err
+}
+
+// copyFile copies size bytes from in to the client returning the
+// number of bytes sent and any error reading in.
+//
+// If writing to the client fails it returns -1 and the error.
+func (s *scp) copyFile(in io.Reader, size int64) (sent int64, err error) {
+	buf := make([]byte, 32*1024)
+	for sent < size {
+		toRead := int64(len(buf))
+		if size-sent < toRead {
+			toRead = size - sent
+		}
+		n, readErr := in.Read(buf[:toRead])
+		if n > 0 {
+			if _, err := s.out.Write(buf[:n]); err != nil {
+				return -1, err
+			}
+			sent += int64(n)
+		}
+		if readErr == io.EOF && sent < size {
+			return sent, io.ErrUnexpectedEOF
+		} else if readErr != nil && readErr != io.EOF {
+			return sent, readErr
+		}
+	}
+	return sent, nil
+}
+
+// zeroReader reads zeros forever
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,156 @@
+//go:build !plan9
+// +build !plan9
+
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestConn makes a conn serving a temporary directory
+func newTestConn(t *testing.T) (*conn, string) {
+	dir := t.TempDir()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	VFS := vfs.New(f, nil)
+	t.Cleanup(VFS.Shutdown)
+	return &conn{vfs: VFS, what: "test"}, dir
+}
+
+func TestShellSplit(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"-t -- dir", []string{"-t", "--", "dir"}, false},
+		{"  -f   'a b'  c\\ d ", []string{"-f", "a b", "c d"}, false},
+		{`"it's" x"y"z`, []string{"it's", "xyz"}, false},
+		{`'unterminated`, nil, true},
+		{`trailing\`, nil, true},
+	} {
+		got, err := shellSplit(test.in)
+		if test.wantErr {
+			assert.Error(t, err, test.in)
+			continue
+		}
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestSCPSink(t *testing.T) {
+	c, dir := newTestConn(t)
+	in := strings.Join([]string{
+		"T1600000000 0 1600000000 0\n",
+		"C0644 5 hello.txt\n", "hello\x00",
+		"D0755 0 sub\n",
+		"C0600 3 a.txt\n", "abc\x00",
+		"E\n",
+	}, "")
+	var out bytes.Buffer
+	err := c.runSCP(strings.NewReader(in), &out, "-r -p -t -- .")
+	require.NoError(t, err)
+	// one ack for the start and for each line and file
+	assert.Equal(t, strings.Repeat("\x00", 8), out.String())
+
+	data, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	fi, err := os.Stat(filepath.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1600000000, 0), fi.ModTime())
+	data, err = os.ReadFile(filepath.Join(dir, "sub", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+}
+
+func TestSCPSinkErrors(t *testing.T) {
+	c, dir := newTestConn(t)
+
+	// A directory without -r is refused but the transfer continues
+	in := "D0755 0 sub\nC0644 2 b.txt\nhi\x00"
+	var out bytes.Buffer
+	err := c.runSCP(strings.NewReader(in), &out, "-t .")
+	assert.EqualError(t, err, "scp: 1 errors")
+	assert.Equal(t, "\x00\x01scp: received directory without -r\n\x00\x00", out.String())
+	data, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+
+	// A file the client failed to send is removed
+	out.Reset()
+	in = "C0644 2 c.txt\nxx\x01scp: read error\n"
+	err = c.runSCP(strings.NewReader(in), &out, "-t .")
+	assert.EqualError(t, err, "scp: 1 errors")
+	_, err = os.Stat(filepath.Join(dir, "c.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// A file which fails to write is skipped without losing sync
+	opt := vfscommon.DefaultOpt
+	opt.Quota = 5
+	opt.UsedIsSize = true
+	quotaVFS := vfs.New(c.vfs.Fs(), &opt)
+	defer quotaVFS.Shutdown()
+	quotaConn := &conn{vfs: quotaVFS, what: "test"}
+	out.Reset()
+	err = quotaConn.runSCP(strings.NewReader("C0644 5 big.txt\nhello\x00C0644 2 d.txt\nhi\x00"), &out, "-t .")
+	assert.EqualError(t, err, "scp: 1 errors")
+	_, err = os.Stat(filepath.Join(dir, "big.txt"))
+	assert.True(t, os.IsNotExist(err))
+	data, err = os.ReadFile(filepath.Join(dir, "d.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+
+	// Names with / in are rejected
+	out.Reset()
+	err = c.runSCP(strings.NewReader("C0644 2 ../x\nhi\x00"), &out, "-t .")
+	assert.ErrorContains(t, err, "unexpected filename")
+
+	// Need -t or -f
+	err = c.runSCP(strings.NewReader(""), &out, "-r .")
+	assert.Error(t, err)
+}
+
+func TestSCPSource(t *testing.T) {
+	c, dir := newTestConn(t)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("abc"), 0666))
+	modTime := time.Unix(1600000000, 0)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "sub", "a.txt"), modTime, modTime))
+
+	// Single file
+	var out bytes.Buffer
+	err := c.runSCP(strings.NewReader(strings.Repeat("\x00", 3)), &out, "-f sub/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "C0666 3 a.txt\nabc\x00", out.String())
+
+	// Recursive with times
+	out.Reset()
+	err = c.runSCP(strings.NewReader(strings.Repeat("\x00", 10)), &out, "-r -p -f sub")
+	require.NoError(t, err)
+	got := out.String()
+	assert.Contains(t, got, "D0777 0 sub\n")
+	assert.Contains(t, got, "T1600000000 0 1600000000 0\nC0666 3 a.txt\nabc\x00")
+	assert.True(t, strings.HasSuffix(got, "E\n"), got)
+
+	// Directory without -r and missing files are errors
+	out.Reset()
+	err = c.runSCP(strings.NewReader(strings.Repeat("\x00", 3)), &out, "-f sub missing")
+	assert.EqualError(t, err, "scp: 2 errors")
+	assert.Equal(t, 2, strings.Count(out.String(), "\x01scp: "))
+}
@@ -71,6 +71,19 @@ The server will respond to a small number of shell commands, mainly
 md5sum, sha1sum and df, which enable it to provide support for checksums
 and the about feature when accessed from an sftp remote.
 
+It will also respond to ` + "`scp`" + ` and ` + "`rsync`" + ` so the ` + "`scp`" + ` command (in
+its legacy mode with ` + "`-O`" + ` - newer versions use SFTP by default) and
+` + "`rsync -e ssh`" + ` can be used to copy files to and from the server, for
+example
+
+    rsync -av -e "ssh -p 2022" dir/ user@localhost:backup/
+
+rsync support is limited. Files are always sent whole rather than
+using the rsync delta algorithm, only files and directories are
+copied, and options such as ` + "`--delete`" + `, ` + "`--checksum`" + `, ` + "`--compress`" + `
+and ` + "`--hard-links`" + ` aren't supported. rsync needs protocol version 29
+or later (rsync 2.6.4 or later).
+
 Note that this server uses standard 32 KiB packet payload size, which
 means you must not configure the client to expect anything else, e.g.
 with the [chunk_size](/sftp/#sftp-chunk-size) option on an sftp remote.
@@ -83,7 +96,7 @@ Use ` + "`--stats`" + ` to control the stats printing.
 You must provide some means of authentication, either with
 ` + "`--user`/`--pass`" + `, an authorized keys file (specify location with
 ` + "`--authorized-keys`" + ` - the default is the same as ssh), an
-` + "`--auth-proxy`" + `, or set the ` + "`--no-auth`" + ` flag for no
+` + "`--auth-proxy`" + `, an ` + "`--auth-users`" + ` file, or set the ` + "`--no-auth`" + ` flag for no
 authentication when logging in.
 
 If you don't supply a host ` + "`--key`" + ` then rclone will generate rsa, ecdsa
@@ -95,7 +108,9 @@ By default the server binds to localhost:2022 - if you want it to be
 reachable externally then supply ` + "`--addr :2022`" + ` for example.
 
 Note that the default of ` + "`--vfs-cache-mode off`" + ` is fine for the rclone
-sftp backend, but it may not be with other SFTP clients.
+sftp backend, but it may not be with other SFTP clients. The exceptions
+are multi-thread copies and ` + "`--delta`" + ` copies to the sftp backend which
+write out of order and need ` + "`--vfs-cache-mode writes`" + ` or higher.
 
 If ` + "`--stdio`" + ` is specified, rclone will serve SFTP over stdio, which can
 be used with sshd via ~/.ssh/authorized_keys, for example:
@@ -119,7 +134,7 @@ provided by OpenSSH in this case.
 	},
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxyflags.Opt.AuthProxy == "" {
+		if !proxyflags.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
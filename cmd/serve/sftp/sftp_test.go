@@ -19,6 +19,8 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/stretchr/testify/require"
 )
 
@@ -39,6 +41,14 @@ var (
 // TestSftp runs the sftp server then runs the unit tests for the
 // sftp remote against it.
 func TestSftp(t *testing.T) {
+	// The sftp remote writes out of order with OpenWriterAt so
+	// cache the writes, uploading them on close so they can be
+	// hashed straight away
+	oldOpt := vfsflags.Opt
+	vfsflags.Opt.CacheMode = vfscommon.CacheModeWrites
+	vfsflags.Opt.WriteBack = 0
+	defer func() { vfsflags.Opt = oldOpt }()
+
 	// Configure and start the server
 	start := func(f fs.Fs) (configmap.Simple, func()) {
 		opt := DefaultOpt
@@ -0,0 +1,67 @@
+package share
+
+import (
+	"context"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "share/create",
+		AuthRequired: true,
+		Fn:           rcCreate,
+		Title:        "Make a signed link to share a file or directory.",
+		Help: `This takes the following parameters:
+
+- fs - a remote name string e.g. "drive:"
+- remote - a path within that remote e.g. "dir/file.txt"
+- expire - string - how long the link lasts e.g. "1d" or "off" for ever (optional, default "1h")
+- password - string - password the link needs (optional)
+
+Returns:
+
+- url - URL to share
+- expires - time the link expires (if it does)
+
+This needs a "rclone serve http" or "rclone serve webdav" of the
+remote running with --share --rc in the same process. The link is served by
+that server.
+`,
+	})
+}
+
+// Make a share link
+func rcCreate(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, remote, err := rc.GetFsAndRemote(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	expire, err := in.GetDuration("expire")
+	if rc.IsErrParamNotFound(err) {
+		expire = time.Hour
+	} else if err != nil {
+		return nil, err
+	}
+	password, err := in.GetString("password")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	var expires time.Time
+	if expire != time.Duration(fs.DurationOff) {
+		expires = time.Now().Add(expire)
+	}
+	link, err := Create(f, remote, expires, password)
+	if err != nil {
+		return nil, err
+	}
+	out = rc.Params{
+		"url": link,
+	}
+	if !expires.IsZero() {
+		out["expires"] = expires
+	}
+	return out, nil
+}
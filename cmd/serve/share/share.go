@@ -0,0 +1,306 @@
+// Package share serves signed expiring links to files and directories
+// for rclone serve
+package share
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/lib/rest"
+	"github.com/rclone/rclone/vfs"
+	"github.com/spf13/pflag"
+)
+
+// Prefix is the path the share links are served under
+const Prefix = "/_share/"
+
+// Help contains text describing the share links
+var Help = strings.Replace(`
+### Share links
+
+Use |--share| to serve links to share a file or directory with people
+who don't have a login. The links are made with the |share/create|
+remote control call, so run the server with |--share --rc| then, for
+example
+
+    rclone rc share/create fs=remote: remote=path/to/file expire=1d password=secret
+
+which returns the URL to share. The links are served under |/_share/|
+and need no other authentication. They can only be used to read. With
+|--share| the |/_share/| path is reserved for the links, so a file or
+directory called |_share| in the root can't be reached.
+
+The links are signed with |--share-key| so they don't need storing on
+the server. If |--share-key| isn't set a random key is used which means
+the links will stop working when the server is restarted. Changing the
+key revokes all the links made with it.
+
+Links expire after |expire| (default 1h) - use |expire=off| to make a
+link which never expires. If |password| is set then the link will ask
+for it using basic authentication (any user name will do).
+
+Share links can't be used with |--auth-proxy|. They were added in
+rclone v1.63.
+`, "|", "`", -1)
+
+// Options for the share links
+type Options struct {
+	Enabled bool   // set to serve share links
+	Key     string // key to sign the links with
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	Enabled: false,
+	Key:     "",
+}
+
+// AddFlags adds the flags for the share links to flagSet
+func AddFlags(flagSet *pflag.FlagSet, opt *Options) {
+	flags.BoolVarP(flagSet, &opt.Enabled, "share", "", opt.Enabled, "Serve share links made with the share/create rc call under /_share/")
+	flags.StringVarP(flagSet, &opt.Key, "share-key", "", opt.Key, "Secret key to sign share links with (default random)")
+}
+
+// Server serves the share links for a VFS
+type Server struct {
+	signer   *signer
+	vfs      *vfs.VFS
+	template *template.Template
+	urls     []string
+}
+
+// active servers for the rc
+var (
+	activeMu sync.Mutex
+	active   = map[*Server]struct{}{}
+)
+
+// New makes a Server for the share links to VFS which is served at
+// urls, using template to list directories.
+//
+// The Server is made available to the rc until Close is called.
+func New(opt *Options, VFS *vfs.VFS, htmlTemplate *template.Template, urls []string) (*Server, error) {
+	signer, err := newSigner(opt.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make share key: %w", err)
+	}
+	if opt.Key == "" {
+		fs.Infof(nil, "Share links will stop working when the server is restarted - use --share-key to keep them")
+	}
+	s := &Server{
+		signer:   signer,
+		vfs:      VFS,
+		template: htmlTemplate,
+		urls:     urls,
+	}
+	activeMu.Lock()
+	active[s] = struct{}{}
+	activeMu.Unlock()
+	return s, nil
+}
+
+// Close removes the Server from the rc
+func (s *Server) Close() {
+	activeMu.Lock()
+	delete(active, s)
+	activeMu.Unlock()
+}
+
+// Link returns a URL to share remote until expires (never if zero)
+// protected by password (if set)
+func (s *Server) Link(remote string, expires time.Time, password string) (string, error) {
+	if len(s.urls) == 0 {
+		return "", errors.New("server has no URL to share")
+	}
+	remote = strings.Trim(remote, "/")
+	node, err := s.vfs.Stat(remote)
+	if err != nil {
+		return "", fmt.Errorf("can't share %q: %w", remote, err)
+	}
+	token, err := s.signer.sign(remote, expires, password)
+	if err != nil {
+		return "", err
+	}
+	link := strings.TrimSuffix(s.urls[0], "/") + Prefix + token + "/"
+	if node.IsFile() {
+		// so the file is downloaded with its own name
+		link += rest.URLPathEscape(path.Base(remote))
+	}
+	return link, nil
+}
+
+// ServeHTTP serves the share links under Prefix
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	token, subPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	l, err := s.signer.check(token, time.Now())
+	if err == errExpired {
+		http.Error(w, "Share link expired", http.StatusGone)
+		return
+	} else if err != nil {
+		fs.Infof(nil, "%s: Bad share link %q", r.RemoteAddr, r.URL.Path)
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if _, password, _ := r.BasicAuth(); !s.signer.checkPassword(l, password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="share", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	node, err := s.vfs.Stat(l.Remote)
+	if err == vfs.ENOENT {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		serve.Error(l.Remote, w, "Failed to find file", err)
+		return
+	}
+	if node.IsFile() {
+		// the rest of the path is just the name of the file
+		s.serveFile(w, r, node)
+		return
+	}
+
+	// Find the node in the shared directory
+	isDir := subPath == "" || strings.HasSuffix(subPath, "/")
+	subPath = strings.Trim(subPath, "/")
+	if subPath != "" {
+		if path.Clean(subPath) != subPath || subPath == ".." || strings.HasPrefix(subPath, "../") {
+			http.Error(w, "Bad path", http.StatusBadRequest)
+			return
+		}
+		node, err = s.vfs.Stat(path.Join(l.Remote, subPath))
+		if err == vfs.ENOENT {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			serve.Error(l.Remote, w, "Failed to find file", err)
+			return
+		}
+	}
+	switch {
+	case node.IsFile():
+		s.serveFile(w, r, node)
+	case !isDir:
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+	default:
+		s.serveDir(w, r, node.(*vfs.Dir), path.Join(path.Base(l.Remote), subPath))
+	}
+}
+
+// serveDir serves a listing of dir calling it name
+func (s *Server) serveDir(w http.ResponseWriter, r *http.Request, dir *vfs.Dir, name string) {
+	dirEntries, err := dir.ReadDirAll()
+	if err != nil {
+		serve.Error(dir.Path(), w, "Failed to list directory", err)
+		return
+	}
+	directory := serve.NewDirectory(name, s.template)
+	for _, node := range dirEntries {
+		if s.vfs.Opt.NoModTime {
+			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), time.Time{})
+		} else {
+			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), node.ModTime().UTC())
+		}
+	}
+	directory.ProcessQueryParams(r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+	w.Header().Set("Last-Modified", dir.ModTime().UTC().Format(http.TimeFormat))
+	directory.Serve(w, r)
+}
+
+// serveFile serves the contents of the file at node
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, node vfs.Node) {
+	obj, ok := node.DirEntry().(fs.Object)
+	if !ok || obj == nil {
+		http.Error(w, "Can't open file being written", http.StatusNotFound)
+		return
+	}
+	file := node.(*vfs.File)
+	knownSize := obj.Size() >= 0
+	if knownSize {
+		w.Header().Set("Content-Length", strconv.FormatInt(node.Size(), 10))
+	}
+	mimeType := fs.MimeType(r.Context(), obj)
+	if mimeType != "application/octet-stream" || path.Ext(obj.Remote()) != "" {
+		w.Header().Set("Content-Type", mimeType)
+	}
+	w.Header().Set("Last-Modified", file.ModTime().UTC().Format(http.TimeFormat))
+	if r.Method == "HEAD" {
+		return
+	}
+	in, err := file.Open(os.O_RDONLY)
+	if err != nil {
+		serve.Error(obj, w, "Failed to open file", err)
+		return
+	}
+	defer func() {
+		err := in.Close()
+		if err != nil {
+			fs.Errorf(obj, "Failed to close file: %v", err)
+		}
+	}()
+	tr := accounting.Stats(r.Context()).NewTransfer(obj, nil)
+	defer tr.Done(r.Context(), nil)
+	fs.Infof(obj, "%s: Serving shared file", r.RemoteAddr)
+	if knownSize {
+		http.ServeContent(w, r, node.Name(), node.ModTime(), in)
+		return
+	}
+	if r.Header.Get("Range") != "" {
+		http.Error(w, "Can't use Range: on files of unknown length", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	n, err := io.Copy(w, in)
+	if err != nil {
+		fs.Errorf(obj, "Didn't finish writing GET request (wrote %d/unknown bytes): %v", n, err)
+	}
+}
+
+// find returns the active server serving the path remote of f and
+// the path of remote in it
+func find(f fs.Fs, remote string) (*Server, string, error) {
+	full := path.Join(f.Root(), remote)
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	for s := range active {
+		sf := s.vfs.Fs()
+		if sf.Name() != f.Name() {
+			continue
+		}
+		root := sf.Root()
+		switch {
+		case root == full:
+			return s, "", nil
+		case root == "" || root == "/":
+			return s, strings.TrimPrefix(full, root), nil
+		case strings.HasPrefix(full, root+"/"):
+			return s, full[len(root)+1:], nil
+		}
+	}
+	return nil, "", fmt.Errorf("no server is sharing %q - start rclone serve http or webdav with --share --rc", fs.ConfigString(f)+"/"+remote)
+}
+
+// Create makes a share link for remote on f
+func Create(f fs.Fs, remote string, expires time.Time, password string) (string, error) {
+	s, rel, err := find(f, remote)
+	if err != nil {
+		return "", err
+	}
+	return s.Link(rel, expires, password)
+}
@@ -0,0 +1,207 @@
+package share
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	s, err := newSigner("key")
+	require.NoError(t, err)
+	now := time.Now()
+
+	token, err := s.sign("dir/file.txt", now.Add(time.Hour), "")
+	require.NoError(t, err)
+	l, err := s.check(token, now)
+	require.NoError(t, err)
+	assert.Equal(t, "dir/file.txt", l.Remote)
+	assert.True(t, s.checkPassword(l, ""))
+	assert.True(t, s.checkPassword(l, "anything"))
+
+	// Expired
+	_, err = s.check(token, now.Add(2*time.Hour))
+	assert.Equal(t, errExpired, err)
+
+	// Never expires
+	token2, err := s.sign("dir", time.Time{}, "")
+	require.NoError(t, err)
+	_, err = s.check(token2, now.Add(100*365*24*time.Hour))
+	require.NoError(t, err)
+
+	// Tampered with or signed with another key
+	dot := strings.IndexByte(token, '.')
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"r":"secret.txt"}`)) + token[dot:]
+	for _, bad := range []string{"", "potato", token[:dot] + token2[strings.IndexByte(token2, '.'):], token + "x", tampered} {
+		_, err = s.check(bad, now)
+		assert.Equal(t, errBadToken, err, bad)
+	}
+	other, err := newSigner("other key")
+	require.NoError(t, err)
+	_, err = other.check(token, now)
+	assert.Equal(t, errBadToken, err)
+
+	// Random keys are different
+	r1, err := newSigner("")
+	require.NoError(t, err)
+	r2, err := newSigner("")
+	require.NoError(t, err)
+	assert.NotEqual(t, r1.key, r2.key)
+
+	// Passwords
+	token, err = s.sign("file.txt", time.Time{}, "secret")
+	require.NoError(t, err)
+	assert.NotContains(t, token, "secret")
+	l, err = s.check(token, now)
+	require.NoError(t, err)
+	assert.False(t, s.checkPassword(l, ""))
+	assert.False(t, s.checkPassword(l, "wrong"))
+	assert.True(t, s.checkPassword(l, "secret"))
+}
+
+// newTestServer serves share links to dir returning the server and
+// its URL
+func newTestServer(t *testing.T, dir string) (*Server, fs.Fs) {
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	VFS := vfs.New(f, &vfscommon.DefaultOpt)
+	htmlTemplate, err := libhttp.GetTemplate("")
+	require.NoError(t, err)
+	s, err := New(&Options{Key: "test"}, VFS, htmlTemplate, nil)
+	require.NoError(t, err)
+	ts := httptest.NewServer(s)
+	s.urls = []string{ts.URL + "/"}
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return s, f
+}
+
+func get(t *testing.T, url, password string, wantStatus int) string {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	if password != "" {
+		req.SetBasicAuth("anyone", password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, wantStatus, resp.StatusCode, "%s: %s", url, body)
+	return string(body)
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "shared", "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shared", "sub", "a file.txt"), []byte("in sub"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shared", "b.txt"), []byte("in shared"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0666))
+	s, _ := newTestServer(t, dir)
+
+	// File
+	link, err := s.Link("shared/b.txt", time.Now().Add(time.Hour), "")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(link, "/b.txt"), link)
+	assert.Equal(t, "in shared", get(t, link, "", http.StatusOK))
+
+	// Directory
+	link, err = s.Link("shared", time.Time{}, "")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(link, "/"), link)
+	listing := get(t, link, "", http.StatusOK)
+	assert.Contains(t, listing, "b.txt")
+	assert.Contains(t, listing, "sub/")
+	assert.NotContains(t, listing, "secret.txt")
+	assert.Contains(t, get(t, link+"sub/", "", http.StatusOK), "a file.txt")
+	assert.Equal(t, "in sub", get(t, link+"sub/a%20file.txt", "", http.StatusOK))
+	get(t, link+"sub/notfound.txt", "", http.StatusNotFound)
+
+	// Can't escape the directory
+	get(t, link+"../secret.txt", "", http.StatusBadRequest)
+	get(t, link+"sub/../../secret.txt", "", http.StatusBadRequest)
+	get(t, link+"%2e%2e/secret.txt", "", http.StatusBadRequest)
+
+	// Password
+	link, err = s.Link("secret.txt", time.Time{}, "pass")
+	require.NoError(t, err)
+	get(t, link, "", http.StatusUnauthorized)
+	get(t, link, "wrong", http.StatusUnauthorized)
+	assert.Equal(t, "secret", get(t, link, "pass", http.StatusOK))
+
+	// Expired
+	link, err = s.Link("secret.txt", time.Now().Add(-time.Second), "")
+	require.NoError(t, err)
+	get(t, link, "", http.StatusGone)
+
+	// Bad links
+	get(t, s.urls[0]+"_share/potato/secret.txt", "", http.StatusNotFound)
+	_, err = s.Link("notfound", time.Time{}, "")
+	assert.Error(t, err)
+
+	// Read only
+	link, err = s.Link("secret.txt", time.Time{}, "")
+	require.NoError(t, err)
+	resp, err := http.Post(link, "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestRcCreate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("hello"), 0666))
+	s, _ := newTestServer(t, filepath.Join(dir, "sub"))
+
+	call := rc.Calls.Get("share/create")
+	require.NotNil(t, call)
+
+	// The fs can be above the root served
+	out, err := call.Fn(context.Background(), rc.Params{
+		"fs":       dir,
+		"remote":   "sub/file.txt",
+		"expire":   "1d",
+		"password": "pass",
+	})
+	require.NoError(t, err)
+	link := out["url"].(string)
+	assert.True(t, strings.HasPrefix(link, s.urls[0]+"_share/"), link)
+	expires := out["expires"].(time.Time)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), expires, time.Minute)
+	assert.Equal(t, "hello", get(t, link, "pass", http.StatusOK))
+
+	out, err = call.Fn(context.Background(), rc.Params{
+		"fs":     filepath.Join(dir, "sub"),
+		"remote": "",
+		"expire": "off",
+	})
+	require.NoError(t, err)
+	assert.Nil(t, out["expires"])
+	assert.Contains(t, get(t, out["url"].(string), "", http.StatusOK), "file.txt")
+
+	// Not being served
+	_, err = call.Fn(context.Background(), rc.Params{
+		"fs":     t.TempDir(),
+		"remote": "file.txt",
+	})
+	assert.ErrorContains(t, err, "no server")
+}
@@ -0,0 +1,111 @@
+package share
+
+// Signed tokens for the share links
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	errBadToken = errors.New("share link not valid")
+	errExpired  = errors.New("share link expired")
+)
+
+// link is what is signed in the token
+type link struct {
+	Remote   string `json:"r"`           // path relative to the root of the VFS
+	Expires  int64  `json:"e,omitempty"` // unix time the link expires or 0 for never
+	Password string `json:"p,omitempty"` // MAC of the password if set
+}
+
+// signer makes and checks the tokens
+type signer struct {
+	key []byte
+}
+
+// newSigner makes a signer using key or a random key if key is empty
+func newSigner(key string) (*signer, error) {
+	s := &signer{key: []byte(key)}
+	if key == "" {
+		s.key = make([]byte, 32)
+		if _, err := rand.Read(s.key); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// mac returns the MAC of what with the key
+func (s *signer) mac(what, data string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	_, _ = h.Write([]byte(what))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// passwordMAC returns the MAC of the password as stored in the link
+func (s *signer) passwordMAC(password string) string {
+	return base64.RawURLEncoding.EncodeToString(s.mac("password", password))
+}
+
+// sign makes a token for remote which expires at expires (unless it
+// is zero) and needs password (unless it is empty)
+func (s *signer) sign(remote string, expires time.Time, password string) (string, error) {
+	l := link{Remote: remote}
+	if !expires.IsZero() {
+		l.Expires = expires.Unix()
+	}
+	if password != "" {
+		l.Password = s.passwordMAC(password)
+	}
+	payload, err := json.Marshal(&l)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.mac("link", string(payload))), nil
+}
+
+// check checks the token is signed with our key and hasn't expired
+// at now and returns the link in it
+func (s *signer) check(token string, now time.Time) (*link, error) {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 {
+		return nil, errBadToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:dot])
+	if err != nil {
+		return nil, errBadToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil {
+		return nil, errBadToken
+	}
+	if !hmac.Equal(sig, s.mac("link", string(payload))) {
+		return nil, errBadToken
+	}
+	var l link
+	err = json.Unmarshal(payload, &l)
+	if err != nil {
+		return nil, errBadToken
+	}
+	if l.Expires != 0 && !now.Before(time.Unix(l.Expires, 0)) {
+		return nil, errExpired
+	}
+	return &l, nil
+}
+
+// checkPassword returns true if password is correct for the link
+func (s *signer) checkPassword(l *link, password string) bool {
+	if l.Password == "" {
+		return true
+	}
+	return hmac.Equal([]byte(l.Password), []byte(s.passwordMAC(password)))
+}
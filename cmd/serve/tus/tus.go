@@ -0,0 +1,575 @@
+// Package tus implements the tus resumable upload protocol for the
+// http based servers
+//
+// See https://tus.io/protocols/resumable-upload for the protocol.
+package tus
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/spf13/pflag"
+)
+
+// Prefix is the path the tus endpoint is served under
+const Prefix = "/_tus/"
+
+// Version is the version of the tus protocol supported
+const Version = "1.0.0"
+
+// stagingDir is the hidden directory at the root of the VFS the
+// uploads are staged in until they are finished
+const stagingDir = ".rclone-tus"
+
+// Extensions of the tus protocol supported
+const Extensions = "creation,creation-with-upload,termination,expiration,checksum"
+
+// Help contains text describing the tus uploads
+var Help = strings.Replace(`
+### Resumable uploads
+
+If |--tus| is set the server supports the [tus](https://tus.io/)
+resumable upload protocol under |/_tus/| so large uploads which fail
+part way through can carry on from where they stopped.
+
+To upload a file make the upload by sending a |POST| to |/_tus/path|.
+If the |filename| (or |name|) metadata is set then |path| is the
+directory to upload into, otherwise it is the path of the file.
+
+The parts of the upload are staged in the VFS cache so this needs
+|--vfs-cache-mode minimal| or higher. While the upload is in progress
+it is in the hidden |.rclone-tus| directory at the root rather than
+where it is going, which is removed again when no uploads are using
+it. The file is only written to the remote when all of it has
+arrived. Uploads which aren't finished are removed after
+|--tus-expire| since the last data arrived and uploads aren't kept
+when the server is restarted.
+
+Use |--tus-max-size| to limit the size of the uploads.
+
+Resumable uploads were added in rclone v1.63.
+`, "|", "`", -1)
+
+// Options for the tus uploads
+type Options struct {
+	Enabled bool          // set to serve tus uploads
+	MaxSize fs.SizeSuffix // biggest upload allowed, -1 for no limit
+	Expire  time.Duration // how long unfinished uploads are kept
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	Enabled: false,
+	MaxSize: -1,
+	Expire:  24 * time.Hour,
+}
+
+// AddFlags adds the flags for the tus uploads to flagSet
+func AddFlags(flagSet *pflag.FlagSet, opt *Options) {
+	flags.BoolVarP(flagSet, &opt.Enabled, "tus", "", opt.Enabled, "Allow resumable uploads with the tus protocol")
+	flags.FVarP(flagSet, &opt.MaxSize, "tus-max-size", "", "Maximum size of a tus upload (default off)")
+	flags.DurationVarP(flagSet, &opt.Expire, "tus-expire", "", opt.Expire, "Remove unfinished tus uploads after this long")
+}
+
+// Handler serves tus uploads
+type Handler struct {
+	opt      Options
+	baseURL  string
+	getVFS   func(ctx context.Context) (*vfs.VFS, error)
+	canWrite func(r *http.Request, VFS *vfs.VFS) bool
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+
+	stagingMu sync.Mutex // held while making or removing stagingDir
+}
+
+// upload is a tus upload in progress
+type upload struct {
+	mu       sync.Mutex // held while writing
+	id       string
+	vfs      *vfs.VFS
+	remote   string // where the file will go
+	partial  string // where the file is staged
+	handle   vfs.Handle
+	length   int64
+	offset   int64
+	metadata string // Upload-Metadata as sent
+	expires  time.Time
+	done     bool
+}
+
+// New makes a Handler for the tus uploads with the urls based at
+// baseURL.
+//
+// getVFS finds the VFS for a request and canWrite returns whether the
+// request may write to it.
+func New(opt *Options, vfsOpt *vfscommon.Options, baseURL string, getVFS func(ctx context.Context) (*vfs.VFS, error), canWrite func(r *http.Request, VFS *vfs.VFS) bool) (*Handler, error) {
+	if vfsOpt.CacheMode < vfscommon.CacheModeMinimal {
+		return nil, errors.New("--tus needs --vfs-cache-mode minimal or higher")
+	}
+	return &Handler{
+		opt:      *opt,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		getVFS:   getVFS,
+		canWrite: canWrite,
+		uploads:  map[string]*upload{},
+	}, nil
+}
+
+// ServeHTTP serves the tus protocol under Prefix
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		w.Header().Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+		if h.opt.MaxSize >= 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(int64(h.opt.MaxSize), 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	VFS, err := h.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to upload: %v", err)
+		return
+	}
+	if !h.canWrite(r, VFS) {
+		fs.Infof(r.URL.Path, "%s: Upload forbidden", r.RemoteAddr)
+		http.Error(w, "Upload forbidden", http.StatusForbidden)
+		return
+	}
+	h.expire(time.Now())
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); method == "POST" && override != "" {
+		method = override
+	}
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+	if method == "POST" {
+		h.create(w, r, VFS, name)
+		return
+	}
+	u := h.find(name, VFS)
+	if u == nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	switch method {
+	case "HEAD":
+		h.head(w, u)
+	case "PATCH":
+		h.patch(w, r, u)
+	case "DELETE":
+		h.terminate(w, u)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// find returns the upload with id belonging to VFS or nil if not found
+func (h *Handler) find(id string, VFS *vfs.VFS) *upload {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u := h.uploads[id]
+	if u == nil || u.vfs != VFS {
+		return nil
+	}
+	return u
+}
+
+// expire removes the uploads which have expired by now
+//
+// Uploads which are busy are skipped rather than waited for so a slow
+// PATCH doesn't hold up other requests. They are removed by a later
+// call.
+func (h *Handler) expire(now time.Time) {
+	var expired []*upload
+	h.mu.Lock()
+	for id, u := range h.uploads {
+		if !u.mu.TryLock() {
+			continue
+		}
+		if now.After(u.expires) {
+			delete(h.uploads, id)
+			expired = append(expired, u)
+		} else {
+			u.mu.Unlock()
+		}
+	}
+	h.mu.Unlock()
+	for _, u := range expired {
+		fs.Infof(u.remote, "Removing expired tus upload")
+		h.abort(u)
+		u.mu.Unlock()
+	}
+}
+
+// openPartial makes the file the upload is staged in
+func (h *Handler) openPartial(u *upload) (err error) {
+	h.stagingMu.Lock()
+	defer h.stagingMu.Unlock()
+	err = u.vfs.Mkdir(stagingDir, 0777)
+	if err != nil {
+		return err
+	}
+	u.handle, err = u.vfs.OpenFile(u.partial, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	return err
+}
+
+// removeStaging removes stagingDir from VFS if no uploads are using it
+func (h *Handler) removeStaging(VFS *vfs.VFS) {
+	h.stagingMu.Lock()
+	defer h.stagingMu.Unlock()
+	dir, err := VFS.Stat(stagingDir)
+	if err != nil || !dir.IsDir() {
+		return
+	}
+	nodes, err := dir.(*vfs.Dir).ReadDirAll()
+	if err != nil || len(nodes) > 0 {
+		return
+	}
+	if err := dir.Remove(); err != nil {
+		fs.Debugf(stagingDir, "Failed to remove tus staging directory: %v", err)
+	}
+}
+
+// abort removes the staged file of an unfinished upload and the
+// staging directory if it isn't needed any more
+//
+// Call with u.mu held
+func (h *Handler) abort(u *upload) {
+	u.abort()
+	h.removeStaging(u.vfs)
+}
+
+// parseMetadata parses the Upload-Metadata header
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty key")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %q: %w", key, err)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// newID makes a random upload ID
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// create makes a new upload for the file at name
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, name string) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Bad or missing Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.opt.MaxSize >= 0 && length > int64(h.opt.MaxSize) {
+		http.Error(w, "Upload too big", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadataHeader := r.Header.Get("Upload-Metadata")
+	metadata, err := parseMetadata(metadataHeader)
+	if err != nil {
+		http.Error(w, "Bad Upload-Metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Work out where the file goes
+	remote := strings.Trim(name, "/")
+	leaf := metadata["filename"]
+	if leaf == "" {
+		leaf = metadata["name"]
+	}
+	if leaf != "" {
+		if leaf == "." || leaf == ".." || strings.ContainsAny(leaf, "/\\") {
+			http.Error(w, "Bad file name", http.StatusBadRequest)
+			return
+		}
+		remote = path.Join(remote, leaf)
+	}
+	if remote == "" || path.Clean(remote) != remote || remote == ".." || strings.HasPrefix(remote, "../") {
+		http.Error(w, "Bad path", http.StatusBadRequest)
+		return
+	}
+	if node, err := VFS.Stat(remote); err == nil && node.IsDir() {
+		http.Error(w, "Can't upload over a directory", http.StatusConflict)
+		return
+	}
+	dir, _ := path.Split(remote)
+	if _, err := VFS.Stat(dir); err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		serve.Error(remote, w, "Failed to make upload", err)
+		return
+	}
+	u := &upload{
+		id:       id,
+		vfs:      VFS,
+		remote:   remote,
+		partial:  path.Join(stagingDir, id),
+		length:   length,
+		metadata: metadataHeader,
+		expires:  time.Now().Add(h.opt.Expire),
+	}
+	err = h.openPartial(u)
+	if err != nil {
+		serve.Error(remote, w, "Failed to make upload", err)
+		return
+	}
+	h.mu.Lock()
+	h.uploads[id] = u
+	h.mu.Unlock()
+	fs.Infof(remote, "%s: Started tus upload of %d bytes", r.RemoteAddr, length)
+
+	w.Header().Set("Location", h.baseURL+Prefix+id)
+	w.Header().Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+	if r.Header.Get("Content-Type") == "application/offset+octet-stream" || length == 0 {
+		// creation-with-upload
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		code, err := h.write(r, u)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head returns the state of the upload
+func (h *Handler) head(w http.ResponseWriter, u *upload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	if u.metadata != "" {
+		w.Header().Set("Upload-Metadata", u.metadata)
+	}
+	if !u.done {
+		w.Header().Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch adds data to the upload
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, u *upload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	if !u.mu.TryLock() {
+		http.Error(w, "Upload in use", http.StatusConflict)
+		return
+	}
+	defer u.mu.Unlock()
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad or missing Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if offset != u.offset || u.done {
+		http.Error(w, "Upload-Offset doesn't match", http.StatusConflict)
+		return
+	}
+	code, err := h.write(r, u)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	// The upload is still in use so keep it for longer
+	u.expires = time.Now().Add(h.opt.Expire)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	if !u.done {
+		w.Header().Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newChecksum returns the hash and the expected sum for the
+// Upload-Checksum header
+func newChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, value, _ := strings.Cut(header, " ")
+	want, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad Upload-Checksum: %w", err)
+	}
+	switch algorithm {
+	case "md5":
+		return md5.New(), want, nil
+	case "sha1":
+		return sha1.New(), want, nil
+	case "sha256":
+		return sha256.New(), want, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+}
+
+// offsetWriter writes to a Handle at an advancing offset
+type offsetWriter struct {
+	handle vfs.Handle
+	offset int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = ow.handle.WriteAt(p, ow.offset)
+	ow.offset += int64(n)
+	return n, err
+}
+
+// write adds the body of r to the upload, committing it if complete
+//
+// Call with u.mu held. On error it returns the HTTP status code to
+// use.
+func (h *Handler) write(r *http.Request, u *upload) (code int, err error) {
+	ow := &offsetWriter{handle: u.handle, offset: u.offset}
+	var out io.Writer = ow
+	var checksum hash.Hash
+	var want []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		checksum, want, err = newChecksum(header)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		out = io.MultiWriter(ow, checksum)
+	}
+	_, err = io.Copy(out, io.LimitReader(r.Body, u.length-u.offset))
+	if err == nil {
+		// Check there isn't any more without writing it
+		var extra [1]byte
+		if n, _ := io.ReadFull(r.Body, extra[:]); n > 0 {
+			return http.StatusRequestEntityTooLarge, errors.New("more data than Upload-Length")
+		}
+	}
+	if checksum != nil {
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("failed to read data: %w", err)
+		}
+		if got := checksum.Sum(nil); string(got) != string(want) {
+			// Leave the offset where it was so the client resends
+			return 460, errors.New("checksum mismatch")
+		}
+	}
+	// Keep whatever arrived even if the connection failed so the
+	// client can carry on from there
+	u.offset = ow.offset
+	if err != nil {
+		fs.Infof(u.remote, "tus upload interrupted at %d/%d bytes: %v", u.offset, u.length, err)
+		return http.StatusBadRequest, fmt.Errorf("failed to read data: %w", err)
+	}
+	if u.offset == u.length {
+		err = u.commit()
+		if err != nil {
+			fs.Errorf(u.remote, "Failed to finish tus upload: %v", err)
+			h.abort(u)
+			h.mu.Lock()
+			delete(h.uploads, u.id)
+			h.mu.Unlock()
+			return http.StatusInternalServerError, errors.New("failed to finish upload")
+		}
+		h.removeStaging(u.vfs)
+	}
+	return 0, nil
+}
+
+// commit finishes the upload moving it to its final place
+//
+// Call with u.mu held
+func (u *upload) commit() error {
+	err := u.handle.Close()
+	u.handle = nil
+	if err != nil {
+		return err
+	}
+	if node, err := u.vfs.Stat(u.remote); err == nil && node.IsDir() {
+		return errors.New("a directory has the same name")
+	}
+	// Rename over any existing file so it is kept if this fails
+	err = u.vfs.Rename(u.partial, u.remote)
+	if err != nil {
+		return err
+	}
+	u.done = true
+	fs.Infof(u.remote, "Finished tus upload of %d bytes", u.length)
+	return nil
+}
+
+// abort removes the staged file of an unfinished upload
+//
+// Call with u.mu held
+func (u *upload) abort() {
+	if u.done {
+		return
+	}
+	if u.handle != nil {
+		_ = u.handle.Close()
+		u.handle = nil
+	}
+	if err := u.vfs.Remove(u.partial); err != nil && !errors.Is(err, vfs.ENOENT) {
+		fs.Errorf(u.partial, "Failed to remove tus upload: %v", err)
+	}
+}
+
+// terminate removes the upload
+func (h *Handler) terminate(w http.ResponseWriter, u *upload) {
+	h.mu.Lock()
+	delete(h.uploads, u.id)
+	h.mu.Unlock()
+	u.mu.Lock()
+	h.abort(u)
+	u.mu.Unlock()
+	fs.Infof(u.remote, "Removed tus upload")
+	w.WriteHeader(http.StatusNoContent)
+}
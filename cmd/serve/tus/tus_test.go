@@ -0,0 +1,308 @@
+package tus
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHandler serves tus uploads to dir
+func newTestHandler(t *testing.T, dir string, opt Options) (*Handler, *vfs.VFS) {
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	vfsOpt := vfscommon.DefaultOpt
+	vfsOpt.CacheMode = vfscommon.CacheModeWrites
+	vfsOpt.WriteBack = 0
+	VFS := vfs.New(f, &vfsOpt)
+	t.Cleanup(func() {
+		VFS.Shutdown()
+		_ = VFS.CleanUp()
+	})
+	h, err := New(&opt, &vfsOpt, "/base/", func(ctx context.Context) (*vfs.VFS, error) {
+		return VFS, nil
+	}, func(r *http.Request, VFS *vfs.VFS) bool {
+		return r.Header.Get("X-Read-Only") == ""
+	})
+	require.NoError(t, err)
+	return h, VFS
+}
+
+// do makes a tus request returning the response
+func do(t *testing.T, h *Handler, method, url string, headers map[string]string, body string) *http.Response {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	resp := w.Result()
+	assert.Equal(t, Version, resp.Header.Get("Tus-Resumable"))
+	return resp
+}
+
+// metadata encodes key value pairs for Upload-Metadata
+func metadata(kv ...string) string {
+	var pairs []string
+	for i := 0; i < len(kv); i += 2 {
+		pairs = append(pairs, kv[i]+" "+base64.StdEncoding.EncodeToString([]byte(kv[i+1])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// waitForFile waits for the file to appear in dir with the contents
+func waitForFile(t *testing.T, VFS *vfs.VFS, name, want string) {
+	VFS.WaitForWriters(10 * time.Second)
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(data))
+}
+
+func TestParseMetadata(t *testing.T) {
+	got, err := parseMetadata(metadata("filename", "hello world.txt", "type", "text/plain") + ",empty")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "hello world.txt", "type": "text/plain", "empty": ""}, got)
+	_, err = parseMetadata("filename !!!")
+	assert.Error(t, err)
+	_, err = parseMetadata(" ,")
+	assert.Error(t, err)
+}
+
+func TestNewNeedsCache(t *testing.T) {
+	vfsOpt := vfscommon.DefaultOpt
+	_, err := New(&DefaultOpt, &vfsOpt, "", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestOptions(t *testing.T) {
+	opt := DefaultOpt
+	opt.MaxSize = 100
+	h, _ := newTestHandler(t, t.TempDir(), opt)
+	r := httptest.NewRequest("OPTIONS", Prefix, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, Version, w.Header().Get("Tus-Version"))
+	assert.Equal(t, Extensions, w.Header().Get("Tus-Extension"))
+	assert.Equal(t, "100", w.Header().Get("Tus-Max-Size"))
+
+	// Too big
+	resp := do(t, h, "POST", Prefix+"file.txt", map[string]string{"Upload-Length": "101"}, "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Wrong version
+	r = httptest.NewRequest("POST", Prefix+"file.txt", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	// Not allowed to write
+	resp = do(t, h, "POST", Prefix+"file.txt", map[string]string{"Upload-Length": "1", "X-Read-Only": "1"}, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0777))
+	h, VFS := newTestHandler(t, dir, DefaultOpt)
+
+	// Bad requests
+	for _, headers := range []map[string]string{
+		{},
+		{"Upload-Length": "-1"},
+		{"Upload-Length": "5", "Upload-Defer-Length": "1"},
+		{"Upload-Length": "5", "Upload-Metadata": metadata("filename", "../x")},
+	} {
+		resp := do(t, h, "POST", Prefix+"sub", headers, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, headers)
+	}
+	resp := do(t, h, "POST", Prefix+"missing/file.txt", map[string]string{"Upload-Length": "5"}, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do(t, h, "POST", Prefix+"sub", map[string]string{"Upload-Length": "5"}, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// Create the upload
+	resp = do(t, h, "POST", Prefix+"sub", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": metadata("filename", "hello.txt"),
+	}, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/base"+Prefix), location)
+	url := strings.TrimPrefix(location, "/base")
+	assert.NotEqual(t, "", resp.Header.Get("Upload-Expires"))
+
+	head := func(wantOffset int64) {
+		resp := do(t, h, "HEAD", url, nil, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, strconv.FormatInt(wantOffset, 10), resp.Header.Get("Upload-Offset"))
+		assert.Equal(t, "11", resp.Header.Get("Upload-Length"))
+		assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	}
+	patch := func(offset int64, data string, headers map[string]string) *http.Response {
+		all := map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.FormatInt(offset, 10),
+		}
+		for k, v := range headers {
+			all[k] = v
+		}
+		return do(t, h, "PATCH", url, all, data)
+	}
+	head(0)
+
+	// First part
+	resp = patch(0, "hello", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+	head(5)
+	_, err := os.Stat(filepath.Join(dir, "sub", "hello.txt"))
+	assert.True(t, os.IsNotExist(err), "file shouldn't exist until the upload is finished")
+	nodes, err := VFS.ReadDir("sub")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(nodes), "upload should be staged outside the directory")
+	nodes, err = VFS.ReadDir(stagingDir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(nodes))
+
+	// Wrong offset and content type
+	resp = patch(0, "hello", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = patch(5, " world", map[string]string{"Content-Type": "text/plain"})
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	// Bad checksum isn't stored
+	resp = patch(5, " world", map[string]string{"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(make([]byte, 20))})
+	assert.Equal(t, 460, resp.StatusCode)
+	resp = patch(5, " world", map[string]string{"Upload-Checksum": "crc32 AAAA"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	head(5)
+
+	// Too much data
+	resp = patch(5, " world!", nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Last part with a good checksum
+	sum := sha1.Sum([]byte(" world"))
+	resp = patch(5, " world", map[string]string{"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:])})
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "11", resp.Header.Get("Upload-Offset"))
+	head(11)
+	waitForFile(t, VFS, filepath.Join(dir, "sub", "hello.txt"), "hello world")
+
+	// Only the file is left in the directory and the staging
+	// directory has gone
+	entries, err := os.ReadDir(filepath.Join(dir, "sub"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	assert.Equal(t, "hello.txt", entries[0].Name())
+	_, err = os.Stat(filepath.Join(dir, stagingDir))
+	assert.True(t, os.IsNotExist(err), "staging directory should be removed")
+}
+
+func TestUploadOverwriteAndCreateWithUpload(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("old contents"), 0666))
+	h, VFS := newTestHandler(t, dir, DefaultOpt)
+
+	resp := do(t, h, "POST", Prefix+"file.txt", map[string]string{
+		"Upload-Length": "3",
+		"Content-Type":  "application/offset+octet-stream",
+	}, "new")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Upload-Offset"))
+	waitForFile(t, VFS, filepath.Join(dir, "file.txt"), "new")
+
+	// Empty file
+	resp = do(t, h, "POST", Prefix+"empty.txt", map[string]string{"Upload-Length": "0"}, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	waitForFile(t, VFS, filepath.Join(dir, "empty.txt"), "")
+}
+
+func TestTerminateAndExpire(t *testing.T) {
+	dir := t.TempDir()
+	h, VFS := newTestHandler(t, dir, DefaultOpt)
+	create := func() string {
+		resp := do(t, h, "POST", Prefix+"file.txt", map[string]string{
+			"Upload-Length": "10",
+			"Content-Type":  "application/offset+octet-stream",
+		}, "part")
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		return strings.TrimPrefix(resp.Header.Get("Location"), "/base")
+	}
+	isEmpty := func() {
+		VFS.WaitForWriters(10 * time.Second)
+		root, err := VFS.Root()
+		require.NoError(t, err)
+		nodes, err := root.ReadDirAll()
+		require.NoError(t, err)
+		assert.Equal(t, 0, len(nodes))
+	}
+
+	// Terminate
+	url := create()
+	resp := do(t, h, "DELETE", url, nil, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do(t, h, "HEAD", url, nil, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	isEmpty()
+
+	// Terminate with X-HTTP-Method-Override
+	url = create()
+	resp = do(t, h, "POST", url, map[string]string{"X-HTTP-Method-Override": "DELETE"}, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	isEmpty()
+
+	// Expire skips a busy upload rather than waiting for it
+	url = create()
+	u := h.find(strings.TrimPrefix(url, Prefix), VFS)
+	require.NotNil(t, u)
+	u.mu.Lock()
+	h.expire(time.Now().Add(DefaultOpt.Expire + time.Minute))
+	u.mu.Unlock()
+	resp = do(t, h, "HEAD", url, nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Each PATCH keeps the upload for longer
+	u.expires = time.Now().Add(time.Minute)
+	resp = do(t, h, "PATCH", url, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "4",
+	}, "more")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	expires, err := http.ParseTime(resp.Header.Get("Upload-Expires"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(DefaultOpt.Expire), expires, time.Minute)
+	h.expire(time.Now().Add(2 * time.Minute))
+	resp = do(t, h, "HEAD", url, nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Expire
+	h.expire(time.Now().Add(DefaultOpt.Expire + time.Minute))
+	resp = do(t, h, "HEAD", url, nil, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	isEmpty()
+
+	// Unknown upload
+	resp = do(t, h, "PATCH", Prefix+"potato", map[string]string{"Content-Type": "application/offset+octet-stream"}, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, _ = io.Copy(io.Discard, resp.Body)
+}
@@ -0,0 +1,478 @@
+package webdav
+
+// Persistent WebDAV locks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/kv"
+	"golang.org/x/net/webdav"
+)
+
+// lockKV is the kv facility for the persistent locks
+const lockKV = "webdav-locks"
+
+// tokenPrefix starts every lock token
+const tokenPrefix = "opaquelocktoken:"
+
+// maxLockRecordSize is the largest lock record read from a remote
+const maxLockRecordSize = 1 << 20
+
+// newLockSystem makes the lock system named by opt.LockSystem
+//
+// f may be nil when using the auth proxy in which case the locks are
+// kept in memory.
+func newLockSystem(ctx context.Context, f fs.Fs, opt *Options) (webdav.LockSystem, error) {
+	switch opt.LockSystem {
+	case "", "memory":
+		return webdav.NewMemLS(), nil
+	}
+	if f == nil {
+		fs.Logf(nil, "WebDAV locks can't be persisted with --auth-proxy so will be kept in memory")
+		return webdav.NewMemLS(), nil
+	}
+	if opt.LockSystem == "disk" {
+		db, err := kv.Start(ctx, lockKV, f)
+		if err != nil {
+			return nil, err
+		}
+		return newStoreLS(&kvLockStore{db: db}, f.Root()), nil
+	}
+	if !strings.Contains(opt.LockSystem, ":") {
+		return nil, errors.New("must be memory, disk or a remote path")
+	}
+	lockFs, err := cache.Get(ctx, opt.LockSystem)
+	if err != nil {
+		return nil, err
+	}
+	return newStoreLS(&remoteLockStore{ctx: ctx, f: lockFs}, f.Root()), nil
+}
+
+// lockStore is where a storeLS keeps the lock records
+//
+// The stores don't need to be transactional, but a lock record
+// which has been saved must be returned by the next load.
+type lockStore interface {
+	// load returns all the lock records keyed by token
+	load() (map[string]*lockRecord, error)
+	// save stores rec under token, or removes token if rec is nil
+	save(token string, rec *lockRecord) error
+}
+
+// storeLS is a webdav.LockSystem which keeps the locks in a lockStore
+// so they persist across restarts and can be shared between rclone
+// instances using the same store.
+//
+// The store may be shared by all roots of a remote so each lock
+// records the root it was made for.
+//
+// Locks are only held (see webdav.LockSystem.Confirm) by the instance
+// which confirmed them.
+type storeLS struct {
+	store  lockStore
+	prefix string // root of the remote served
+
+	mu   sync.Mutex
+	held map[string]bool // tokens held by this instance
+}
+
+func newStoreLS(store lockStore, prefix string) *storeLS {
+	return &storeLS{
+		store:  store,
+		prefix: prefix,
+		held:   map[string]bool{},
+	}
+}
+
+// check interface
+var _ webdav.LockSystem = (*storeLS)(nil)
+
+// lockRecord is a lock as stored
+type lockRecord struct {
+	Prefix    string        `json:"prefix"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"`
+	OwnerXML  string        `json:"owner,omitempty"`
+	ZeroDepth bool          `json:"zeroDepth,omitempty"`
+	Expiry    time.Time     `json:"expiry"`
+}
+
+func (rec *lockRecord) details() webdav.LockDetails {
+	return webdav.LockDetails{
+		Root:      rec.Root,
+		Duration:  rec.Duration,
+		OwnerXML:  rec.OwnerXML,
+		ZeroDepth: rec.ZeroDepth,
+	}
+}
+
+// setDuration sets the duration of the lock and when it expires
+func (rec *lockRecord) setDuration(now time.Time, duration time.Duration) {
+	rec.Duration = duration
+	rec.Expiry = time.Time{}
+	if duration >= 0 {
+		rec.Expiry = now.Add(duration)
+	}
+}
+
+// covers returns true if the lock covers name
+func (rec *lockRecord) covers(name string) bool {
+	if name == rec.Root {
+		return true
+	}
+	if rec.ZeroDepth {
+		return false
+	}
+	return rec.Root == "/" || strings.HasPrefix(name, rec.Root+"/")
+}
+
+// conflicts returns true if the locks rec and other can't both exist
+func (rec *lockRecord) conflicts(other *lockRecord) bool {
+	return rec.covers(other.Root) || other.covers(rec.Root)
+}
+
+// slashClean is equivalent to but slightly more efficient than
+// path.Clean("/" + name).
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+// load returns the unexpired locks for this root at time now,
+// removing the expired ones from the store
+//
+// Call with ls.mu held
+func (ls *storeLS) load(now time.Time) (map[string]*lockRecord, error) {
+	all, err := ls.store.load()
+	if err != nil {
+		return nil, err
+	}
+	locks := map[string]*lockRecord{}
+	for token, rec := range all {
+		if rec.Prefix != ls.prefix {
+			continue
+		}
+		if !rec.Expiry.IsZero() && !now.Before(rec.Expiry) && !ls.held[token] {
+			if err := ls.store.save(token, nil); err != nil {
+				fs.Debugf(nil, "WebDAV failed to remove expired lock %q: %v", token, err)
+			}
+			continue
+		}
+		locks[token] = rec
+	}
+	return locks, nil
+}
+
+// lookup returns the token of the lock that locks name, provided
+// that it matches at least one of the conditions and that lock isn't
+// held. Otherwise, it returns "".
+//
+// Call with ls.mu held
+func (ls *storeLS) lookup(locks map[string]*lockRecord, name string, conditions []webdav.Condition) string {
+	for _, c := range conditions {
+		rec := locks[c.Token]
+		if rec == nil || ls.held[c.Token] {
+			continue
+		}
+		if rec.covers(name) {
+			return c.Token
+		}
+	}
+	return ""
+}
+
+// Confirm confirms that the caller can claim all of the locks
+// specified by the given conditions.
+func (ls *storeLS) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (release func(), err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks, err := ls.load(now)
+	if err != nil {
+		return nil, err
+	}
+	var token0, token1 string
+	if name0 != "" {
+		if token0 = ls.lookup(locks, slashClean(name0), conditions); token0 == "" {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if token1 = ls.lookup(locks, slashClean(name1), conditions); token1 == "" {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	// Don't hold the same lock twice.
+	if token1 == token0 {
+		token1 = ""
+	}
+	for _, token := range []string{token0, token1} {
+		if token != "" {
+			ls.held[token] = true
+		}
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		delete(ls.held, token0)
+		delete(ls.held, token1)
+	}, nil
+}
+
+// canCreate returns whether rec can be created alongside locks
+func canCreate(locks map[string]*lockRecord, rec *lockRecord) bool {
+	for _, other := range locks {
+		if rec.conflicts(other) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create creates a lock with the given depth, duration, owner and
+// root (name).
+//
+// Another instance may create a conflicting lock at the same time,
+// so once the lock is saved the locks are read again. If there is a
+// conflicting lock then this one is removed. This means both locks
+// may fail but both can't succeed.
+func (ls *storeLS) Create(now time.Time, details webdav.LockDetails) (token string, err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	rec := &lockRecord{
+		Prefix:    ls.prefix,
+		Root:      slashClean(details.Root),
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+	}
+	rec.setDuration(now, details.Duration)
+	locks, err := ls.load(now)
+	if err != nil {
+		return "", err
+	}
+	if !canCreate(locks, rec) {
+		return "", webdav.ErrLocked
+	}
+	token = tokenPrefix + uuid.New().String()
+	err = ls.store.save(token, rec)
+	if err != nil {
+		return "", err
+	}
+	locks, err = ls.load(now)
+	if err == nil {
+		delete(locks, token)
+		if !canCreate(locks, rec) {
+			err = webdav.ErrLocked
+		}
+	}
+	if err != nil {
+		if removeErr := ls.store.save(token, nil); removeErr != nil {
+			fs.Errorf(nil, "WebDAV failed to remove lock %q: %v", token, removeErr)
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// Refresh refreshes the lock with the given token.
+func (ls *storeLS) Refresh(now time.Time, token string, duration time.Duration) (details webdav.LockDetails, err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks, err := ls.load(now)
+	if err != nil {
+		return details, err
+	}
+	rec := locks[token]
+	if rec == nil {
+		return details, webdav.ErrNoSuchLock
+	}
+	if ls.held[token] {
+		return details, webdav.ErrLocked
+	}
+	rec.setDuration(now, duration)
+	return rec.details(), ls.store.save(token, rec)
+}
+
+// Unlock unlocks the lock with the given token.
+func (ls *storeLS) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks, err := ls.load(now)
+	if err != nil {
+		return err
+	}
+	if locks[token] == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if ls.held[token] {
+		return webdav.ErrLocked
+	}
+	return ls.store.save(token, nil)
+}
+
+// kvLockStore keeps the lock records in a kv database in the cache
+// directory
+type kvLockStore struct {
+	db *kv.DB
+}
+
+// kvLoad is the kv operation to load the lock records
+type kvLoad struct {
+	locks map[string]*lockRecord
+}
+
+// Do the load, removing any corrupt records
+func (op *kvLoad) Do(ctx context.Context, b kv.Bucket) error {
+	var corrupt []string
+	err := b.ForEach(func(key, data []byte) error {
+		token := string(key)
+		var rec lockRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			fs.Debugf(nil, "WebDAV removing corrupt lock %q: %v", token, err)
+			corrupt = append(corrupt, token)
+			return nil
+		}
+		op.locks[token] = &rec
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range corrupt {
+		if err := b.Delete([]byte(token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvSave is the kv operation to save a lock record
+type kvSave struct {
+	token string
+	data  []byte // nil to remove
+}
+
+// Do the save
+func (op *kvSave) Do(ctx context.Context, b kv.Bucket) error {
+	if op.data == nil {
+		return b.Delete([]byte(op.token))
+	}
+	return b.Put([]byte(op.token), op.data)
+}
+
+func (s *kvLockStore) load() (map[string]*lockRecord, error) {
+	op := &kvLoad{locks: map[string]*lockRecord{}}
+	err := s.db.Do(true, op)
+	return op.locks, err
+}
+
+func (s *kvLockStore) save(token string, rec *lockRecord) (err error) {
+	op := &kvSave{token: token}
+	if rec != nil {
+		op.data, err = json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Do(true, op)
+}
+
+// remoteLockStore keeps each lock record in a file on a remote so it
+// can be shared by rclone instances on different hosts
+type remoteLockStore struct {
+	ctx context.Context
+	f   fs.Fs // where the lock records are kept
+}
+
+// lockFileName returns the name of the file the lock token is kept in
+func lockFileName(token string) string {
+	return strings.TrimPrefix(token, tokenPrefix) + ".json"
+}
+
+// readLockRecord reads the lock record in o
+func readLockRecord(ctx context.Context, o fs.Object) (*lockRecord, error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(in, maxLockRecordSize))
+	closeErr := in.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	var rec lockRecord
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt lock record: %w", err)
+	}
+	return &rec, nil
+}
+
+// load reads all the lock records
+//
+// Records which can't be read are skipped as they may be being
+// written or removed by another instance.
+func (s *remoteLockStore) load() (map[string]*lockRecord, error) {
+	locks := map[string]*lockRecord{}
+	entries, err := s.f.List(s.ctx, "")
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return locks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok || path.Ext(o.Remote()) != ".json" {
+			continue
+		}
+		rec, err := readLockRecord(s.ctx, o)
+		if err != nil {
+			fs.Debugf(o, "WebDAV skipping lock: %v", err)
+			continue
+		}
+		locks[tokenPrefix+strings.TrimSuffix(o.Remote(), ".json")] = rec
+	}
+	return locks, nil
+}
+
+func (s *remoteLockStore) save(token string, rec *lockRecord) error {
+	name := lockFileName(token)
+	o, err := s.f.NewObject(s.ctx, name)
+	if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		return err
+	}
+	if rec == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Remove(s.ctx)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	src := object.NewStaticObjectInfo(name, time.Now(), int64(len(data)), true, nil, s.f)
+	if o != nil {
+		return o.Update(s.ctx, bytes.NewReader(data), src)
+	}
+	_, err = s.f.Put(s.ctx, bytes.NewReader(data), src)
+	return err
+}
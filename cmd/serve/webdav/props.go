@@ -0,0 +1,251 @@
+package webdav
+
+// Dead properties stored as object metadata
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/net/webdav"
+)
+
+// deadPropsKey is the metadata key the dead properties are stored in
+const deadPropsKey = "webdav-props"
+
+// deadProp is a dead property as stored in the metadata
+type deadProp struct {
+	Space    string `json:"ns,omitempty"`
+	Local    string `json:"name"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML string `json:"xml"`
+}
+
+// decodeDeadProps decodes the dead properties in the metadata
+func decodeDeadProps(metadata fs.Metadata) (map[xml.Name]webdav.Property, error) {
+	props := map[xml.Name]webdav.Property{}
+	value := metadata[deadPropsKey]
+	if value == "" {
+		return props, nil
+	}
+	var stored []deadProp
+	err := json.Unmarshal([]byte(value), &stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode WebDAV properties: %w", err)
+	}
+	for _, p := range stored {
+		name := xml.Name{Space: p.Space, Local: p.Local}
+		props[name] = webdav.Property{
+			XMLName:  name,
+			Lang:     p.Lang,
+			InnerXML: []byte(p.InnerXML),
+		}
+	}
+	return props, nil
+}
+
+// encodeDeadProps encodes the dead properties for the metadata
+func encodeDeadProps(props map[xml.Name]webdav.Property) (string, error) {
+	if len(props) == 0 {
+		return "", nil
+	}
+	stored := make([]deadProp, 0, len(props))
+	for name, p := range props {
+		stored = append(stored, deadProp{
+			Space:    name.Space,
+			Local:    name.Local,
+			Lang:     p.Lang,
+			InnerXML: string(p.InnerXML),
+		})
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].Space != stored[j].Space {
+			return stored[i].Space < stored[j].Space
+		}
+		return stored[i].Local < stored[j].Local
+	})
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// propHandle is a file which stores the dead properties set with
+// PROPPATCH in the metadata of the object
+type propHandle struct {
+	webdav.File
+	node vfs.Node
+	w    *WebDAV
+}
+
+// check interface
+var _ webdav.DeadPropsHolder = propHandle{}
+
+// object returns the object for the handle if there is one
+func (h propHandle) object() (fs.Object, bool) {
+	o, ok := h.node.DirEntry().(fs.Object)
+	return o, ok && o != nil
+}
+
+// DeadProps returns a copy of the dead properties held.
+func (h propHandle) DeadProps() (map[xml.Name]webdav.Property, error) {
+	o, ok := h.object()
+	if !ok {
+		return nil, nil
+	}
+	metadata, err := fs.GetMetadata(h.w.ctx, o)
+	if err != nil {
+		fs.Debugf(o, "Failed to read metadata for WebDAV properties: %v", err)
+		return nil, nil
+	}
+	props, err := decodeDeadProps(metadata)
+	if err != nil {
+		fs.Debugf(o, "%v", err)
+		return nil, nil
+	}
+	return props, nil
+}
+
+// Patch patches the dead properties held.
+//
+// Either all or none of the patches succeed.
+func (h propHandle) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	var names []webdav.Property
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			names = append(names, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	forbidden := []webdav.Propstat{{Status: http.StatusForbidden, Props: names}}
+	o, ok := h.object()
+	file, isFile := h.node.(*vfs.File)
+	if !ok || !isFile || h.node.VFS().Opt.ReadOnly {
+		// Directories and files not uploaded yet can't have properties
+		return forbidden, nil
+	}
+	if _, ok := o.(fs.SetMetadataer); !ok {
+		// Refuse rather than upload the object again
+		return forbidden, nil
+	}
+	ctx := h.w.ctx
+	metadata, err := fs.GetMetadata(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	props, err := decodeDeadProps(metadata)
+	if err != nil {
+		fs.Debugf(o, "Replacing WebDAV properties: %v", err)
+		props = map[xml.Name]webdav.Property{}
+	}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			if patch.Remove {
+				delete(props, p.XMLName)
+			} else {
+				props[p.XMLName] = p
+			}
+		}
+	}
+	value, err := encodeDeadProps(props)
+	if err != nil {
+		return nil, err
+	}
+	if value != metadata[deadPropsKey] {
+		err = file.SetMetadata(ctx, fs.Metadata{deadPropsKey: value})
+		if errors.Is(err, vfs.ENOSYS) || errors.Is(err, vfs.EPERM) || errors.Is(err, vfs.EROFS) {
+			return forbidden, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []webdav.Propstat{{Status: http.StatusOK, Props: names}}, nil
+}
+
+// lazyFile is a file which is only opened when it is read from or
+// written to
+//
+// The webdav library opens files with just O_RDWR for PROPPATCH,
+// which doesn't use the contents, so this saves opening a file for
+// writing which won't be written to.
+type lazyFile struct {
+	node vfs.Node
+	w    *WebDAV
+	open func() (webdav.File, error)
+
+	mu   sync.Mutex
+	file webdav.File // set once opened
+	err  error       // error opening the file
+}
+
+// check interface
+var _ webdav.File = (*lazyFile)(nil)
+
+// get returns the open file, opening it if necessary
+func (f *lazyFile) get() (webdav.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil && f.err == nil {
+		f.file, f.err = f.open()
+	}
+	return f.file, f.err
+}
+
+// Read reads from the file
+func (f *lazyFile) Read(p []byte) (n int, err error) {
+	file, err := f.get()
+	if err != nil {
+		return 0, err
+	}
+	return file.Read(p)
+}
+
+// Write writes to the file
+func (f *lazyFile) Write(p []byte) (n int, err error) {
+	file, err := f.get()
+	if err != nil {
+		return 0, err
+	}
+	return file.Write(p)
+}
+
+// Seek sets the offset for the next Read or Write
+func (f *lazyFile) Seek(offset int64, whence int) (int64, error) {
+	file, err := f.get()
+	if err != nil {
+		return 0, err
+	}
+	return file.Seek(offset, whence)
+}
+
+// Readdir reads directory entries
+func (f *lazyFile) Readdir(count int) ([]os.FileInfo, error) {
+	file, err := f.get()
+	if err != nil {
+		return nil, err
+	}
+	return file.Readdir(count)
+}
+
+// Stat returns info about the file without opening it
+func (f *lazyFile) Stat() (os.FileInfo, error) {
+	return FileInfo{FileInfo: f.node, w: f.w}, nil
+}
+
+// Close closes the file if it was opened
+func (f *lazyFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/rclone/rclone/cmd"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/cmd/serve/share"
+	"github.com/rclone/rclone/cmd/serve/tus"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/hash"
@@ -34,6 +36,10 @@ type Options struct {
 	HashName      string
 	HashType      hash.Type
 	DisableGETDir bool
+	LockSystem    string
+	DeadProps     bool
+	Share         share.Options
+	Tus           tus.Options
 }
 
 // DefaultOpt is the default values used for Options
@@ -43,6 +49,10 @@ var DefaultOpt = Options{
 	Template:      libhttp.DefaultTemplateCfg(),
 	HashType:      hash.None,
 	DisableGETDir: false,
+	LockSystem:    "memory",
+	DeadProps:     false,
+	Share:         share.DefaultOpt,
+	Tus:           tus.DefaultOpt,
 }
 
 // Opt is options set by command line flags
@@ -61,6 +71,10 @@ func init() {
 	proxyflags.AddFlags(flagSet)
 	flags.StringVarP(flagSet, &Opt.HashName, "etag-hash", "", "", "Which hash to use for the ETag, or auto or blank for off")
 	flags.BoolVarP(flagSet, &Opt.DisableGETDir, "disable-dir-list", "", false, "Disable HTML directory list on GET request for a directory")
+	flags.StringVarP(flagSet, &Opt.LockSystem, "lock-system", "", Opt.LockSystem, "Where to keep WebDAV locks: memory, disk or a remote path")
+	flags.BoolVarP(flagSet, &Opt.DeadProps, "dead-props", "", Opt.DeadProps, "Store properties set with PROPPATCH in the object metadata")
+	share.AddFlags(flagSet, &Opt.Share)
+	tus.AddFlags(flagSet, &Opt.Tus)
 }
 
 // Command definition for cobra
@@ -83,6 +97,40 @@ supported hash on the backend or you can use a named hash such as
 "MD5" or "SHA-1". Use the [hashsum](/commands/rclone_hashsum/) command
 to see the full list.
 
+#### --lock-system
+
+This controls where the locks made by WebDAV clients are kept.
+
+With the default of "memory" the locks are lost when rclone is
+restarted. With "disk" they are kept in a database in the rclone cache
+directory, so they persist across restarts and are shared between
+instances of rclone serving the same remote with the same cache
+directory.
+
+Otherwise this should be a remote path, eg "remote:webdav-locks",
+where each lock is kept in a small file. Instances of rclone on
+different hosts serving the same remote can share their locks by using
+the same path. The path needs read after write consistency, which all
+the major object stores have. Each lock is read back after it is made
+and if another instance made a conflicting lock at the same time it is
+refused and the client will need to retry. Expired locks are
+removed by the next instance to read them.
+
+#### --dead-props
+
+If this flag is set then properties set by clients with PROPPATCH
+(known as dead properties) are stored in the metadata of the file on
+backends which support user metadata (see the [overview](/overview/#metadata)).
+Office applications and macOS Finder use these.
+
+Properties are only set on backends which can change the metadata of
+a file without uploading it again. These are local and s3 (which
+copies the object onto itself, so not for GLACIER or DEEP_ARCHIVE
+objects) and crypt, compress, hasher, combine and union on top of
+them. On other backends PROPPATCH is refused. Reading properties may need an extra transaction
+per file on some backends. Properties can't be set on directories or
+on files which are being written.
+
 ### Access WebDAV on Windows
 WebDAV shared folder can be mapped as a drive on Windows, however the default settings prevent it.
 Windows will fail to connect to the server using insecure Basic authentication.
@@ -107,13 +155,13 @@ Create a new DWORD BasicAuthLevel with value 2.
 
 https://learn.microsoft.com/en-us/office/troubleshoot/powerpoint/office-opens-blank-from-sharepoint
 
-` + libhttp.Help(flagPrefix) + libhttp.TemplateHelp(flagPrefix) + libhttp.AuthHelp(flagPrefix) + vfs.Help + proxy.Help,
+` + libhttp.Help(flagPrefix) + libhttp.TemplateHelp(flagPrefix) + libhttp.AuthHelp(flagPrefix) + share.Help + tus.Help + vfs.Help + proxy.Help,
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
 	},
 	RunE: func(command *cobra.Command, args []string) error {
 		var f fs.Fs
-		if proxyflags.Opt.AuthProxy == "" {
+		if !proxyflags.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -166,6 +214,8 @@ type WebDAV struct {
 	_vfs          *vfs.VFS // don't use directly, use getVFS
 	webdavhandler *webdav.Handler
 	proxy         *proxy.Proxy
+	share         *share.Server
+	tus           *tus.Handler
 	ctx           context.Context // for global config
 }
 
@@ -179,7 +229,10 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error
 		ctx: ctx,
 		opt: *opt,
 	}
-	if proxyflags.Opt.AuthProxy != "" {
+	if proxyflags.Opt.Enabled() {
+		if w.opt.Share.Enabled {
+			return nil, errors.New("--share can't be used with --auth-proxy")
+		}
 		w.proxy = proxy.New(ctx, &proxyflags.Opt)
 		// override auth
 		w.opt.Auth.CustomAuthFn = w.auth
@@ -187,19 +240,28 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error
 		w._vfs = vfs.New(f, &vfsflags.Opt)
 	}
 
-	w.Server, err = libhttp.NewServer(ctx,
+	options := []libhttp.Option{
 		libhttp.WithConfig(w.opt.HTTP),
 		libhttp.WithAuth(w.opt.Auth),
 		libhttp.WithTemplate(w.opt.Template),
-	)
+	}
+	if w._vfs != nil && w.opt.Share.Enabled {
+		options = append(options, libhttp.WithPublicPrefix(share.Prefix))
+	}
+	w.Server, err = libhttp.NewServer(ctx, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
 
+	lockSystem, err := newLockSystem(ctx, f, &w.opt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make lock system %q: %w", w.opt.LockSystem, err)
+	}
+
 	webdavHandler := &webdav.Handler{
 		Prefix:     w.opt.HTTP.BaseURL,
 		FileSystem: w,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: lockSystem,
 		Logger:     w.logRequest, // FIXME
 	}
 	w.webdavhandler = webdavHandler
@@ -211,6 +273,22 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error
 	)
 
 	router.Handle("/*", w)
+	if w._vfs != nil && w.opt.Share.Enabled {
+		w.share, err = share.New(&w.opt.Share, w._vfs, w.Server.HTMLTemplate(), w.Server.URLs())
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(share.Prefix+"*", w.share)
+	}
+	if w.opt.Tus.Enabled {
+		w.tus, err = tus.New(&w.opt.Tus, &vfsflags.Opt, w.opt.HTTP.BaseURL, w.getVFS, func(r *http.Request, VFS *vfs.VFS) bool {
+			return !VFS.Opt.ReadOnly
+		})
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(tus.Prefix+"*", w.tus)
+	}
 
 	// Webdav only methods not defined in chi
 	methods := []string{
@@ -352,11 +430,29 @@ func (w *WebDAV) OpenFile(ctx context.Context, name string, flags int, perm os.F
 	if err != nil {
 		return nil, err
 	}
-	f, err := VFS.OpenFile(name, flags, perm)
+	open := func() (webdav.File, error) {
+		f, err := VFS.OpenFile(name, flags, perm)
+		if err != nil {
+			return nil, err
+		}
+		return Handle{Handle: f, w: w}, nil
+	}
+	if !w.opt.DeadProps || !VFS.Fs().Features().UserMetadata {
+		return open()
+	}
+	if flags == os.O_RDWR {
+		// Only open files for PROPPATCH if they are used
+		node, err := VFS.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return propHandle{File: &lazyFile{node: node, w: w, open: open}, node: node, w: w}, nil
+	}
+	h, err := open()
 	if err != nil {
 		return nil, err
 	}
-	return Handle{Handle: f, w: w}, nil
+	return propHandle{File: h, node: h.(Handle).Node(), w: w}, nil
 }
 
 // RemoveAll removes a file or a directory and its contents
@@ -21,6 +21,7 @@ import (
 	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/cmd/serve/servetest"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/filter"
@@ -261,3 +262,169 @@ func HelpTestGET(t *testing.T, testURL string) {
 		checkGolden(t, test.Golden, body)
 	}
 }
+
+// racingLockStore saves a conflicting lock from another instance
+// just after the next lock is saved
+type racingLockStore struct {
+	lockStore
+	other *lockRecord
+}
+
+func (s *racingLockStore) save(token string, rec *lockRecord) error {
+	err := s.lockStore.save(token, rec)
+	if err == nil && rec != nil && s.other != nil {
+		err = s.lockStore.save(tokenPrefix+"other", s.other)
+		s.other = nil
+	}
+	return err
+}
+
+func TestLockSystem(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name       string
+		lockSystem string
+	}{
+		{"Disk", "disk"},
+		{"Remote", ":local:" + t.TempDir() + "/locks"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ls, err := newLockSystem(ctx, f, &Options{LockSystem: test.lockSystem})
+			require.NoError(t, err)
+			sls, ok := ls.(*storeLS)
+			require.True(t, ok)
+			if kvStore, ok := sls.store.(*kvLockStore); ok {
+				defer func() { require.NoError(t, kvStore.db.Stop(false)) }()
+			}
+			testLockSystem(t, sls)
+		})
+	}
+
+	_, err = newLockSystem(ctx, f, &Options{LockSystem: "potato"})
+	assert.Error(t, err)
+}
+
+func testLockSystem(t *testing.T, ls *storeLS) {
+	now := time.Now()
+	tokenA, err := ls.Create(now, webdav.LockDetails{Root: "a", Duration: time.Hour})
+	require.NoError(t, err)
+
+	// Conflicting locks
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/a/b", Duration: time.Hour, ZeroDepth: true})
+	assert.Equal(t, webdav.ErrLocked, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/", Duration: time.Hour})
+	assert.Equal(t, webdav.ErrLocked, err)
+	tokenX, err := ls.Create(now, webdav.LockDetails{Root: "/x", Duration: time.Second, ZeroDepth: true})
+	require.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/x/y", Duration: time.Hour, ZeroDepth: true})
+	require.NoError(t, err)
+
+	// Confirming holds the lock
+	_, err = ls.Confirm(now, "/a/b", "", webdav.Condition{Token: tokenX})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	release, err := ls.Confirm(now, "/a/b", "/a", webdav.Condition{Token: tokenA})
+	require.NoError(t, err)
+	_, err = ls.Refresh(now, tokenA, time.Hour)
+	assert.Equal(t, webdav.ErrLocked, err)
+	assert.Equal(t, webdav.ErrLocked, ls.Unlock(now, tokenA))
+	release()
+	details, err := ls.Refresh(now, tokenA, 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, webdav.LockDetails{Root: "/a", Duration: 2 * time.Hour}, details)
+
+	// Another instance sees the same locks but not ones for
+	// another root
+	other := newStoreLS(ls.store, ls.prefix)
+	_, err = other.Create(now, webdav.LockDetails{Root: "/a", Duration: time.Hour})
+	assert.Equal(t, webdav.ErrLocked, err)
+	otherRoot := newStoreLS(ls.store, ls.prefix+"/sub")
+	_, err = otherRoot.Create(now, webdav.LockDetails{Root: "/a", Duration: time.Hour})
+	require.NoError(t, err)
+
+	// A conflicting lock made by another instance at the same
+	// time wins
+	racing := newStoreLS(&racingLockStore{
+		lockStore: ls.store,
+		other:     &lockRecord{Prefix: ls.prefix, Root: "/r", Expiry: now.Add(time.Hour)},
+	}, ls.prefix)
+	_, err = racing.Create(now, webdav.LockDetails{Root: "/r/s", Duration: time.Hour})
+	assert.Equal(t, webdav.ErrLocked, err)
+	locks, err := ls.load(now)
+	require.NoError(t, err)
+	for _, rec := range locks {
+		assert.NotEqual(t, "/r/s", rec.Root)
+	}
+	require.NoError(t, ls.Unlock(now, tokenPrefix+"other"))
+
+	// Locks expire
+	later := now.Add(2 * time.Second)
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(later, tokenX))
+	_, err = ls.Create(later, webdav.LockDetails{Root: "/x", Duration: time.Hour, ZeroDepth: true})
+	require.NoError(t, err)
+
+	require.NoError(t, other.Unlock(now, tokenA))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, tokenA))
+}
+
+func TestDeadProps(t *testing.T) {
+	dir := t.TempDir()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	if !f.Features().UserMetadata {
+		t.Skip("backend doesn't support user metadata")
+	}
+	opt := DefaultOpt
+	opt.HTTP.ListenAddr = []string{testBindAddress}
+	opt.DeadProps = true
+	w, err := newWebDAV(context.Background(), f, &opt)
+	require.NoError(t, err)
+	require.NoError(t, w.serve())
+	defer func() {
+		assert.NoError(t, w.Shutdown())
+		w.Wait()
+	}()
+	testURL := w.Server.URLs()[0]
+
+	do := func(method, path, body string, wantStatus int) string {
+		req, err := http.NewRequest(method, testURL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, wantStatus, resp.StatusCode, string(data))
+		return string(data)
+	}
+	const propfind = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:test"><D:prop><Z:colour/><D:getcontentlength/></D:prop></D:propfind>`
+
+	do("PUT", "file.txt", "hello", http.StatusCreated)
+	body := do("PROPFIND", "file.txt", propfind, http.StatusMultiStatus)
+	assert.Contains(t, body, "404 Not Found")
+
+	do("PROPPATCH", "file.txt", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:colour>blue</Z:colour></D:prop></D:set></D:propertyupdate>`, http.StatusMultiStatus)
+	body = do("PROPFIND", "file.txt", propfind, http.StatusMultiStatus)
+	assert.Contains(t, body, ">blue</colour>")
+	assert.NotContains(t, body, "404 Not Found")
+
+	// The properties are stored in the metadata and the data is unchanged
+	o, err := f.NewObject(context.Background(), "file.txt")
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(context.Background(), o)
+	require.NoError(t, err)
+	assert.Contains(t, metadata[deadPropsKey], `"colour"`)
+	assert.Equal(t, "hello", do("GET", "file.txt", "", http.StatusOK))
+
+	do("PROPPATCH", "file.txt", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:remove><D:prop><Z:colour/></D:prop></D:remove></D:propertyupdate>`, http.StatusMultiStatus)
+	body = do("PROPFIND", "file.txt", propfind, http.StatusMultiStatus)
+	assert.Contains(t, body, "404 Not Found")
+
+	// Directories can't have properties
+	do("MKCOL", "dir", "", http.StatusCreated)
+	body = do("PROPPATCH", "dir", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:colour>red</Z:colour></D:prop></D:set></D:propertyupdate>`, http.StatusMultiStatus)
+	assert.Contains(t, body, "403 Forbidden")
+}
@@ -63,6 +63,9 @@ type Account struct {
 
 	tokenBucket buckets // per file bandwidth limiter (may be nil)
 
+	quotas   []*quotaUse // remote quotas this counts against (may be nil)
+	quotaErr error       // set if starting the transfer would exceed a quota
+
 	values accountValues
 }
 
@@ -250,6 +253,16 @@ func (acc *Account) checkReadBefore() (bytesUntilLimit int64, err error) {
 	} else {
 		bytesUntilLimit = 1 << 62
 	}
+	if acc.quotaErr != nil {
+		acc.values.mu.Unlock()
+		return 0, acc.quotaErr
+	}
+	for _, u := range acc.quotas {
+		if u.q.exceeded() {
+			acc.values.mu.Unlock()
+			return 0, ErrorQuotaExceededFatal
+		}
+	}
 	// Set start time.
 	if acc.values.start.IsZero() {
 		acc.values.start = time.Now()
@@ -269,6 +282,16 @@ func (acc *Account) checkReadAfter(bytesUntilLimit int64, n int, err error) (out
 		}
 		err = ErrorMaxTransferLimitReachedFatal
 	}
+	// Fail the transfer if a read which has been accounted went over
+	// a quota, even if it was the last one
+	if err == nil || err == io.EOF {
+		for _, u := range acc.quotas {
+			if u.q.exceeded() {
+				err = ErrorQuotaExceededFatal
+				break
+			}
+		}
+	}
 	return n, err
 }
 
@@ -286,7 +309,14 @@ func (acc *Account) ServerSideCopyStart() {
 
 // ServerSideCopyEnd accounts for a read of n bytes in a sever side copy
 func (acc *Account) ServerSideCopyEnd(n int64) {
-	// Update Stats
+	acc.addBytes(n)
+	for _, u := range acc.quotas {
+		u.add(n)
+	}
+}
+
+// addBytes updates the stats with n bytes not read through the Account
+func (acc *Account) addBytes(n int64) {
 	acc.values.mu.Lock()
 	acc.values.bytes += n
 	acc.values.mu.Unlock()
@@ -295,9 +325,11 @@ func (acc *Account) ServerSideCopyEnd(n int64) {
 }
 
 // DryRun accounts for statistics without running the operation
+//
+// Nothing is transferred so the quotas aren't used.
 func (acc *Account) DryRun(n int64) {
 	acc.ServerSideCopyStart()
-	acc.ServerSideCopyEnd(n)
+	acc.addBytes(n)
 }
 
 // Account for n bytes from the current file bandwidth limit (if any)
@@ -323,6 +355,9 @@ func (acc *Account) accountRead(n int) {
 	acc.values.mu.Unlock()
 
 	acc.stats.Bytes(int64(n))
+	for _, u := range acc.quotas {
+		u.add(int64(n))
+	}
 
 	TokenBucket.LimitBandwidth(TokenBucketSlotAccounting, n)
 	acc.limitPerFileBandwidth(n)
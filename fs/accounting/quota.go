@@ -0,0 +1,330 @@
+package accounting
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// ErrorQuotaExceeded is returned when a transfer would exceed one of
+// the quotas set in the config of a remote.
+var ErrorQuotaExceeded = errors.New("transfer quota exceeded")
+
+// ErrorQuotaExceededFatal is returned from Read when a quota is
+// exceeded during a transfer.
+var ErrorQuotaExceededFatal = fserrors.FatalError(ErrorQuotaExceeded)
+
+// ErrorQuotaExceededGraceful is returned from operations.Copy when
+// starting a transfer would exceed a quota and a graceful stop is
+// required.
+var ErrorQuotaExceededGraceful = fserrors.NoRetryError(ErrorQuotaExceeded)
+
+// Quota directions
+const (
+	QuotaUpload   = "upload"   // bytes written to the remote
+	QuotaDownload = "download" // bytes read from the remote
+)
+
+const (
+	quotaFacility  = "quota"          // name of the kv database
+	quotaFlushTime = 10 * time.Second // write usage to the database at least this often
+	quotaFlushSize = 64 * 1024 * 1024 // or when this many bytes are unwritten
+)
+
+// quotaPeriods are the periods quotas can be set for with the time
+// format which names each one
+var quotaPeriods = []struct {
+	name   string
+	format string
+}{
+	{"daily", "2006-01-02"},
+	{"monthly", "2006-01"},
+}
+
+// quotaLimit is a limit on the bytes transferred in a period
+type quotaLimit struct {
+	period string // name of the period
+	format string // time format for the period
+	limit  int64  // max bytes in the period
+}
+
+// Quota tracks the bytes transferred in one direction to or from a
+// remote against the limits set in the config of the remote, for
+// example
+//
+//	quota_upload_daily = 750G
+//
+// The usage is persisted in a kv database so it is shared between
+// runs of rclone.
+type Quota struct {
+	name      string       // name of the remote
+	direction string       // QuotaUpload or QuotaDownload
+	limits    []quotaLimit // limits to enforce
+	db        *kv.DB       // persistent usage, nil if unavailable
+	mu        sync.Mutex   // protects the below
+	used      []int64      // usage for each limit at the last flush
+	keys      []string     // database keys used at the last flush
+	pending   int64        // bytes transferred but not yet written to db
+	reserved  int64        // bytes reserved by transfers in progress
+	lastFlush time.Time    // time of the last flush
+}
+
+var (
+	quotasMu       sync.Mutex
+	quotas         = map[string]*Quota{}
+	quotasAtExitFn atexit.FnHandle
+)
+
+// quotaRemoteName returns the name of the config section for f
+func quotaRemoteName(f fs.Info) string {
+	name := f.Name()
+	if idx := strings.Index(name, "{"); idx != -1 {
+		name = name[:idx]
+	}
+	return name
+}
+
+// GetQuota returns the quota for transfers in direction to or from
+// the remote f, or nil if the remote doesn't have one.
+func GetQuota(ctx context.Context, f fs.Info, direction string) *Quota {
+	if f == nil {
+		return nil
+	}
+	name := quotaRemoteName(f)
+	quotasMu.Lock()
+	defer quotasMu.Unlock()
+	key := name + "/" + direction
+	if q, found := quotas[key]; found {
+		return q
+	}
+	var q *Quota
+	for _, period := range quotaPeriods {
+		configKey := "quota_" + direction + "_" + period.name
+		value, ok := fs.ConfigFileGet(name, configKey)
+		if !ok || value == "" {
+			continue
+		}
+		var limit fs.SizeSuffix
+		if err := limit.Set(value); err != nil {
+			fs.Errorf(nil, "%s: ignoring bad %s %q: %v", name, configKey, value, err)
+			continue
+		}
+		if q == nil {
+			q = &Quota{
+				name:      name,
+				direction: direction,
+			}
+		}
+		q.limits = append(q.limits, quotaLimit{
+			period: period.name,
+			format: period.format,
+			limit:  int64(limit),
+		})
+	}
+	if q != nil {
+		q.used = make([]int64, len(q.limits))
+		q.keys = make([]string, len(q.limits))
+		db, err := kv.Start(ctx, quotaFacility, nil)
+		if err != nil {
+			fs.Errorf(nil, "%s: quota usage will not be saved between runs: %v", name, err)
+		} else {
+			q.db = db
+		}
+		if quotasAtExitFn == nil {
+			quotasAtExitFn = atexit.Register(flushQuotas)
+		}
+		q.mu.Lock()
+		q.flush(true)
+		q.mu.Unlock()
+	}
+	quotas[key] = q
+	return q
+}
+
+// flushQuotas writes the usage of all the quotas to the database
+func flushQuotas() {
+	quotasMu.Lock()
+	defer quotasMu.Unlock()
+	for _, q := range quotas {
+		if q != nil {
+			q.mu.Lock()
+			q.flush(true)
+			q.mu.Unlock()
+		}
+	}
+}
+
+// opQuotaAdd adds n to the usage stored in keys and reads the totals
+type opQuotaAdd struct {
+	keys   []string
+	n      int64
+	totals []int64
+}
+
+// Do adds the usage - implements kv.Op
+func (op *opQuotaAdd) Do(ctx context.Context, b kv.Bucket) error {
+	for i, key := range op.keys {
+		var total int64
+		if data := b.Get([]byte(key)); len(data) == 8 {
+			total = int64(binary.BigEndian.Uint64(data))
+		}
+		if op.n != 0 {
+			total += op.n
+			var data [8]byte
+			binary.BigEndian.PutUint64(data[:], uint64(total))
+			if err := b.Put([]byte(key), data[:]); err != nil {
+				return err
+			}
+		}
+		op.totals[i] = total
+	}
+	return nil
+}
+
+// flush writes the pending usage to the database and reads the
+// usage by other rclone processes back if due or if force is set.
+//
+// Call with q.mu held.
+func (q *Quota) flush(force bool) {
+	now := time.Now().UTC()
+	if !force && q.pending < quotaFlushSize && now.Sub(q.lastFlush) < quotaFlushTime {
+		return
+	}
+	q.lastFlush = now
+	op := &opQuotaAdd{
+		keys:   make([]string, len(q.limits)),
+		n:      q.pending,
+		totals: make([]int64, len(q.limits)),
+	}
+	for i, limit := range q.limits {
+		op.keys[i] = q.name + "/" + q.direction + "/" + now.Format(limit.format)
+	}
+	// Start counting afresh if the period has changed
+	for i, key := range op.keys {
+		if key != q.keys[i] {
+			q.keys[i] = key
+			q.used[i] = 0
+		}
+	}
+	if q.db == nil {
+		for i := range q.used {
+			q.used[i] += q.pending
+		}
+		q.pending = 0
+		return
+	}
+	err := q.db.Do(op.n != 0, op)
+	if err == kv.ErrEmpty {
+		// nothing saved yet
+		err = nil
+	}
+	if err != nil {
+		fs.Errorf(nil, "%s: failed to save %s quota usage: %v", q.name, q.direction, err)
+		return
+	}
+	copy(q.used, op.totals)
+	q.pending = 0
+}
+
+// remaining returns the number of bytes which may be transferred
+// before the tightest limit is reached.
+//
+// Call with q.mu held.
+func (q *Quota) remaining() (remaining int64, limit *quotaLimit) {
+	remaining = 1 << 62
+	for i := range q.limits {
+		left := q.limits[i].limit - q.used[i] - q.pending - q.reserved
+		if left < remaining {
+			remaining, limit = left, &q.limits[i]
+		}
+	}
+	return remaining, limit
+}
+
+// quotaUse is the use of a Quota by a transfer
+type quotaUse struct {
+	q        *Quota
+	reserved int64 // bytes still reserved for the transfer, protected by q.mu
+}
+
+// reserve checks that size bytes can be transferred without
+// exceeding the quota and reserves them for the transfer if so,
+// returning ErrorQuotaExceededGraceful if not.
+//
+// If size is unknown (< 0) it checks the quota isn't already used up.
+func (q *Quota) reserve(size int64) (*quotaUse, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flush(false)
+	remaining, limit := q.remaining()
+	if remaining <= 0 || size > remaining {
+		fs.Errorf(nil, "%s: %s %s quota of %v would be exceeded", q.name, limit.period, q.direction, fs.SizeSuffix(limit.limit))
+		return nil, ErrorQuotaExceededGraceful
+	}
+	u := &quotaUse{q: q}
+	if size > 0 {
+		u.reserved = size
+		q.reserved += size
+	}
+	return u, nil
+}
+
+// add records n bytes as transferred, using up the reservation
+func (u *quotaUse) add(n int64) {
+	q := u.q
+	q.mu.Lock()
+	q.pending += n
+	used := n
+	if used > u.reserved {
+		used = u.reserved
+	}
+	u.reserved -= used
+	q.reserved -= used
+	q.flush(false)
+	q.mu.Unlock()
+}
+
+// release returns what is left of the reservation when the transfer
+// is finished
+func (u *quotaUse) release() {
+	q := u.q
+	q.mu.Lock()
+	q.reserved -= u.reserved
+	u.reserved = 0
+	q.flush(false)
+	q.mu.Unlock()
+}
+
+// exceeded returns true if a limit has been passed, not counting
+// reservations
+func (q *Quota) exceeded() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.limits {
+		if q.used[i]+q.pending > q.limits[i].limit {
+			return true
+		}
+	}
+	return false
+}
+
+// Used returns the bytes used in the current period for each limit
+// as a map of period name to bytes.
+func (q *Quota) Used() map[string]int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flush(true)
+	used := make(map[string]int64, len(q.limits))
+	for i, limit := range q.limits {
+		used[limit.period] = q.used[i] + q.pending
+	}
+	return used
+}
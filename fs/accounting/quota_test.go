@@ -0,0 +1,82 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	oldConfigFileGet := fs.ConfigFileGet
+	fs.ConfigFileGet = func(section, key string) (string, bool) {
+		if section == "quotaremote" && key == "quota_upload_daily" {
+			return "100B", true
+		}
+		return "", false
+	}
+	defer func() {
+		fs.ConfigFileGet = oldConfigFileGet
+		quotasMu.Lock()
+		quotas = map[string]*Quota{}
+		quotasMu.Unlock()
+	}()
+
+	stats := NewStats(ctx)
+	f := mockfs.NewFs(ctx, "quotaremote", "root")
+	other := mockfs.NewFs(ctx, "other", "root")
+
+	assert.Nil(t, GetQuota(ctx, f, QuotaDownload))
+	assert.Nil(t, GetQuota(ctx, other, QuotaUpload))
+	q := GetQuota(ctx, f, QuotaUpload)
+	require.NotNil(t, q)
+	assert.Equal(t, q, GetQuota(ctx, f, QuotaUpload))
+
+	// Too big to start
+	tr := newTransferRemoteSize(stats, "too-big", 101, false, "", other, f)
+	assert.Equal(t, ErrorQuotaExceededGraceful, tr.CheckQuotas())
+	tr.Done(ctx, nil)
+
+	// Reservations stop other transfers starting
+	tr1 := newTransferRemoteSize(stats, "file1", 60, false, "", other, f)
+	require.NoError(t, tr1.CheckQuotas())
+	tr2 := newTransferRemoteSize(stats, "file2", 60, false, "", other, f)
+	assert.Equal(t, ErrorQuotaExceededGraceful, tr2.CheckQuotas())
+	tr2.Done(ctx, nil)
+
+	in := tr1.Account(ctx, io.NopCloser(bytes.NewBuffer(make([]byte, 60))))
+	n, err := io.Copy(io.Discard, in)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), n)
+	tr1.Done(ctx, nil)
+	assert.Equal(t, map[string]int64{"daily": 60}, q.Used())
+	assert.False(t, q.exceeded())
+
+	// Unknown size transfers are stopped when they exceed the quota
+	tr3 := newTransferRemoteSize(stats, "file3", -1, false, "", other, f)
+	require.NoError(t, tr3.CheckQuotas())
+	in = tr3.Account(ctx, io.NopCloser(bytes.NewBuffer(make([]byte, 100))))
+	_, err = io.ReadAll(in)
+	assert.ErrorIs(t, err, ErrorQuotaExceeded)
+	tr3.Done(ctx, err)
+	assert.True(t, q.exceeded())
+
+	// Nothing can start now
+	tr4 := newTransferRemoteSize(stats, "file4", 0, false, "", other, f)
+	assert.Equal(t, ErrorQuotaExceededGraceful, tr4.CheckQuotas())
+	in = tr4.Account(ctx, io.NopCloser(bytes.NewBuffer(make([]byte, 1))))
+	_, err = io.ReadAll(in)
+	assert.Equal(t, ErrorQuotaExceededGraceful, err)
+	tr4.Done(ctx, err)
+
+	// Transfers which don't involve the remote aren't affected
+	tr5 := newTransferRemoteSize(stats, "file5", 10, false, "", other, nil)
+	assert.NoError(t, tr5.CheckQuotas())
+	tr5.Done(ctx, nil)
+}
@@ -51,6 +51,7 @@ type Transfer struct {
 	startedAt time.Time
 	checking  bool
 	what      string // what kind of transfer this is
+	quotaErr  error  // set if the transfer would exceed a quota
 
 	// Protects all below
 	//
@@ -61,19 +62,30 @@ type Transfer struct {
 	acc         *Account
 	err         error
 	completedAt time.Time
+	quotas      []*quotaUse // quotas this transfer counts against
 }
 
 // newCheckingTransfer instantiates new checking of the object.
 func newCheckingTransfer(stats *StatsInfo, obj fs.DirEntry, what string) *Transfer {
-	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), true, what)
+	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), true, what, nil, nil)
 }
 
 // newTransfer instantiates new transfer.
-func newTransfer(stats *StatsInfo, obj fs.DirEntry) *Transfer {
-	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), false, "")
+//
+// If obj is an object the transfer counts against the download quota
+// of its Fs.
+func newTransfer(stats *StatsInfo, obj fs.DirEntry, dstFs fs.Info) *Transfer {
+	var srcFs fs.Info
+	if o, ok := obj.(fs.Object); ok {
+		srcFs = o.Fs()
+	}
+	return newTransferRemoteSize(stats, obj.Remote(), obj.Size(), false, "", srcFs, dstFs)
 }
 
-func newTransferRemoteSize(stats *StatsInfo, remote string, size int64, checking bool, what string) *Transfer {
+// newTransferRemoteSize instantiates a new transfer counting against
+// the download quota of srcFs and the upload quota of dstFs, either
+// of which may be nil.
+func newTransferRemoteSize(stats *StatsInfo, remote string, size int64, checking bool, what string, srcFs, dstFs fs.Info) *Transfer {
 	tr := &Transfer{
 		stats:     stats,
 		remote:    remote,
@@ -82,6 +94,7 @@ func newTransferRemoteSize(stats *StatsInfo, remote string, size int64, checking
 		checking:  checking,
 		what:      what,
 	}
+	tr.quotaErr = tr.useQuotas(stats.ctx, srcFs, dstFs)
 	stats.AddTransfer(tr)
 	return tr
 }
@@ -99,6 +112,7 @@ func (tr *Transfer) Done(ctx context.Context, err error) {
 
 	tr.mu.RLock()
 	acc := tr.acc
+	quotas := tr.quotas
 	tr.mu.RUnlock()
 
 	ci := fs.GetConfig(ctx)
@@ -113,6 +127,10 @@ func (tr *Transfer) Done(ctx context.Context, err error) {
 		acc = nil
 	}
 
+	for _, u := range quotas {
+		u.release()
+	}
+
 	tr.mu.Lock()
 	tr.completedAt = time.Now()
 	tr.mu.Unlock()
@@ -146,6 +164,8 @@ func (tr *Transfer) Account(ctx context.Context, in io.ReadCloser) *Account {
 	tr.mu.Lock()
 	if tr.acc == nil {
 		tr.acc = newAccountSizeName(ctx, tr.stats, in, tr.size, tr.remote)
+		tr.acc.quotas = tr.quotas
+		tr.acc.quotaErr = tr.quotaErr
 	} else {
 		tr.acc.UpdateReader(ctx, in)
 	}
@@ -153,6 +173,44 @@ func (tr *Transfer) Account(ctx context.Context, in io.ReadCloser) *Account {
 	return tr.acc
 }
 
+// CheckQuotas returns ErrorQuotaExceededGraceful if starting the
+// transfer would exceed the download quota of its source or the
+// upload quota of its destination.
+//
+// Reading from the Account of the transfer returns the same error so
+// this need only be called to stop before doing any work.
+func (tr *Transfer) CheckQuotas() error {
+	return tr.quotaErr
+}
+
+// useQuotas makes the transfer count against the download quota of
+// src and the upload quota of dst if they have them.
+//
+// It returns ErrorQuotaExceededGraceful if the transfer would exceed
+// either of them.
+func (tr *Transfer) useQuotas(ctx context.Context, src, dst fs.Info) error {
+	for _, use := range []struct {
+		f         fs.Info
+		direction string
+	}{
+		{src, QuotaDownload},
+		{dst, QuotaUpload},
+	} {
+		q := GetQuota(ctx, use.f, use.direction)
+		if q == nil {
+			continue
+		}
+		u, err := q.reserve(tr.size)
+		if err != nil {
+			return err
+		}
+		tr.mu.Lock()
+		tr.quotas = append(tr.quotas, u)
+		tr.mu.Unlock()
+	}
+	return nil
+}
+
 // TimeRange returns the time transfer started and ended at. If not completed
 // it will return zero time for end time.
 func (tr *Transfer) TimeRange() (time.Time, time.Time) {
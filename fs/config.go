@@ -83,6 +83,8 @@ type ConfigInfo struct {
 	IgnoreCaseSync          bool
 	NoTraverse              bool
 	CheckFirst              bool
+	Resume                  bool // Use a transfer journal to resume interrupted syncs
+	PlanOut                 string
 	NoCheckDest             bool
 	NoUnicodeNormalization  bool
 	NoUpdateModTime         bool
@@ -90,6 +92,8 @@ type ConfigInfo struct {
 	CompareDest             []string
 	CopyDest                []string
 	BackupDir               string
+	BackupSnapshot          bool
+	BackupSnapshotName      string // snapshot directory for --backup-snapshot - set when the backup dir is first resolved
 	Suffix                  string
 	SuffixKeepExtension     bool
 	UseListR                bool
@@ -126,7 +130,12 @@ type ConfigInfo struct {
 	ClientKey               string   // Client Side Key
 	MultiThreadCutoff       SizeSuffix
 	MultiThreadStreams      int
-	MultiThreadSet          bool   // whether MultiThreadStreams was set (set in fs/config/configflags)
+	MultiThreadSet          bool // whether MultiThreadStreams was set (set in fs/config/configflags)
+	Delta                   bool
+	DeltaBlockSize          SizeSuffix
+	PostVerify              bool
+	PostVerifyPercent       int
+	PostVerifyMaxSize       SizeSuffix
 	OrderBy                 string // instructions on how to order the transfer
 	UploadHeaders           []*HTTPOption
 	DownloadHeaders         []*HTTPOption
@@ -175,6 +184,8 @@ func NewConfig() *ConfigInfo {
 	c.AskPassword = true
 	c.TPSLimitBurst = 1
 	c.MaxTransfer = -1
+	c.PostVerifyPercent = 100
+	c.PostVerifyMaxSize = -1
 	c.MaxBacklog = 10000
 	// We do not want to set the default here. We use this variable being empty as part of the fall-through of options.
 	//	c.StatsOneLineDateFormat = "2006/01/02 15:04:05 - "
@@ -84,12 +84,15 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &ci.IgnoreCaseSync, "ignore-case-sync", "", ci.IgnoreCaseSync, "Ignore case when synchronizing")
 	flags.BoolVarP(flagSet, &ci.NoTraverse, "no-traverse", "", ci.NoTraverse, "Don't traverse destination file system on copy")
 	flags.BoolVarP(flagSet, &ci.CheckFirst, "check-first", "", ci.CheckFirst, "Do all the checks before starting transfers")
+	flags.BoolVarP(flagSet, &ci.Resume, "resume", "", ci.Resume, "Keep a transfer journal and use it to resume an interrupted sync, copy or move")
 	flags.BoolVarP(flagSet, &ci.NoCheckDest, "no-check-dest", "", ci.NoCheckDest, "Don't check the destination, copy regardless")
 	flags.BoolVarP(flagSet, &ci.NoUnicodeNormalization, "no-unicode-normalization", "", ci.NoUnicodeNormalization, "Don't normalize unicode characters in filenames")
 	flags.BoolVarP(flagSet, &ci.NoUpdateModTime, "no-update-modtime", "", ci.NoUpdateModTime, "Don't update destination mod-time if files identical")
 	flags.StringArrayVarP(flagSet, &ci.CompareDest, "compare-dest", "", nil, "Include additional comma separated server-side paths during comparison")
 	flags.StringArrayVarP(flagSet, &ci.CopyDest, "copy-dest", "", nil, "Implies --compare-dest but also copies files from paths into destination")
+	flags.StringVarP(flagSet, &ci.PlanOut, "plan-out", "", ci.PlanOut, "Write what sync, copy or move would do to this file for rclone apply, instead of doing it")
 	flags.StringVarP(flagSet, &ci.BackupDir, "backup-dir", "", ci.BackupDir, "Make backups into hierarchy based in DIR")
+	flags.BoolVarP(flagSet, &ci.BackupSnapshot, "backup-snapshot", "", ci.BackupSnapshot, "Make backups into a new timestamped directory under --backup-dir")
 	flags.StringVarP(flagSet, &ci.Suffix, "suffix", "", ci.Suffix, "Suffix to add to changed files")
 	flags.BoolVarP(flagSet, &ci.SuffixKeepExtension, "suffix-keep-extension", "", ci.SuffixKeepExtension, "Preserve the extension when using --suffix")
 	flags.BoolVarP(flagSet, &ci.UseListR, "fast-list", "", ci.UseListR, "Use recursive list if available; uses more memory but fewer transactions")
@@ -126,6 +129,11 @@ func AddFlags(ci *fs.ConfigInfo, flagSet *pflag.FlagSet) {
 	flags.StringVarP(flagSet, &ci.ClientKey, "client-key", "", ci.ClientKey, "Client SSL private key (PEM) for mutual TLS auth")
 	flags.FVarP(flagSet, &ci.MultiThreadCutoff, "multi-thread-cutoff", "", "Use multi-thread downloads for files above this size")
 	flags.IntVarP(flagSet, &ci.MultiThreadStreams, "multi-thread-streams", "", ci.MultiThreadStreams, "Max number of streams to use for multi-thread downloads")
+	flags.BoolVarP(flagSet, &ci.Delta, "delta", "", ci.Delta, "Update changed files in place sending only the changed blocks")
+	flags.FVarP(flagSet, &ci.DeltaBlockSize, "delta-block-size", "", "Block size for --delta (0 to choose from the file size)")
+	flags.BoolVarP(flagSet, &ci.PostVerify, "post-verify", "", ci.PostVerify, "Read files back after uploading them to check they match the source")
+	flags.IntVarP(flagSet, &ci.PostVerifyPercent, "post-verify-percent", "", ci.PostVerifyPercent, "Percentage of files chosen at random to check with --post-verify")
+	flags.FVarP(flagSet, &ci.PostVerifyMaxSize, "post-verify-max-size", "", "Don't check files larger than this with --post-verify")
 	flags.BoolVarP(flagSet, &ci.UseJSONLog, "use-json-log", "", ci.UseJSONLog, "Use json log format")
 	flags.StringVarP(flagSet, &ci.OrderBy, "order-by", "", ci.OrderBy, "Instructions on how to order the transfers, e.g. 'size,descending'")
 	flags.StringArrayVarP(flagSet, &uploadHeaders, "header-upload", "", nil, "Set HTTP header for upload transactions")
@@ -241,6 +249,10 @@ func SetFlags(ci *fs.ConfigInfo) {
 		log.Fatalf(`Can't use --compare-dest with --copy-dest.`)
 	}
 
+	if ci.PostVerifyPercent < 0 || ci.PostVerifyPercent > 100 {
+		log.Fatalf("--post-verify-percent must be between 0 and 100 not %d", ci.PostVerifyPercent)
+	}
+
 	switch {
 	case len(ci.StatsOneLineDateFormat) > 0:
 		ci.StatsOneLineDate = true
@@ -144,9 +144,27 @@ type Features struct {
 	//
 	// Pass in the remote desired and the size if known.
 	//
-	// It truncates any existing object
+	// An existing object larger than size is truncated to size, or
+	// to 0 if the size is unknown. A shorter one is kept as it is so
+	// data already written is preserved. Callers should write the
+	// last byte last so the object only reaches size once the write
+	// is complete.
 	OpenWriterAt func(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
 
+	// BlockHashes returns the MD5 hashes of each block of blockSize
+	// bytes of remote, the last of which may be short.
+	//
+	// The hashes should be calculated where the data is stored if
+	// possible so the data doesn't need to be transferred.
+	BlockHashes func(ctx context.Context, remote string, blockSize int64) ([]string, error)
+
+	// OpenChunkWriter opens a ChunkWriter to write remote in
+	// chunks which may be written concurrently.
+	//
+	// Pass in the remote and the src object which must have a
+	// known size.
+	OpenChunkWriter func(ctx context.Context, remote string, src ObjectInfo, options ...OpenOption) (info ChunkWriterInfo, writer ChunkWriter, err error)
+
 	// UserInfo returns info about the connected user
 	UserInfo func(ctx context.Context) (map[string]string, error)
 
@@ -297,6 +315,12 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(OpenWriterAter); ok {
 		ft.OpenWriterAt = do.OpenWriterAt
 	}
+	if do, ok := f.(BlockHasher); ok {
+		ft.BlockHashes = do.BlockHashes
+	}
+	if do, ok := f.(OpenChunkWriter); ok {
+		ft.OpenChunkWriter = do.OpenChunkWriter
+	}
 	if do, ok := f.(UserInfoer); ok {
 		ft.UserInfo = do.UserInfo
 	}
@@ -386,6 +410,12 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.OpenWriterAt == nil {
 		ft.OpenWriterAt = nil
 	}
+	if mask.BlockHashes == nil {
+		ft.BlockHashes = nil
+	}
+	if mask.OpenChunkWriter == nil {
+		ft.OpenChunkWriter = nil
+	}
 	if mask.UserInfo == nil {
 		ft.UserInfo = nil
 	}
@@ -612,10 +642,34 @@ type OpenWriterAter interface {
 	//
 	// Pass in the remote desired and the size if known.
 	//
-	// It truncates any existing object
+	// An existing object larger than size is truncated to size, or
+	// to 0 if the size is unknown. A shorter one is kept as it is so
+	// data already written is preserved. Callers should write the
+	// last byte last so the object only reaches size once the write
+	// is complete.
 	OpenWriterAt(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
 }
 
+// BlockHasher is an optional interface for Fs
+type BlockHasher interface {
+	// BlockHashes returns the MD5 hashes of each block of blockSize
+	// bytes of remote, the last of which may be short.
+	//
+	// The hashes should be calculated where the data is stored if
+	// possible so the data doesn't need to be transferred.
+	BlockHashes(ctx context.Context, remote string, blockSize int64) ([]string, error)
+}
+
+// OpenChunkWriter is an optional interface for Fs
+type OpenChunkWriter interface {
+	// OpenChunkWriter opens a ChunkWriter to write remote in
+	// chunks which may be written concurrently.
+	//
+	// Pass in the remote and the src object which must have a
+	// known size.
+	OpenChunkWriter(ctx context.Context, remote string, src ObjectInfo, options ...OpenOption) (info ChunkWriterInfo, writer ChunkWriter, err error)
+}
+
 // UserInfoer is an optional interface for Fs
 type UserInfoer interface {
 	// UserInfo returns info about the connected user
@@ -127,6 +127,26 @@ func StreamTypes(r io.Reader, set Set) (map[Type]string, error) {
 	return ret, nil
 }
 
+// StreamBlocks will calculate the MD5 hash of each block of blockSize
+// bytes read from r, the last of which may be short.
+func StreamBlocks(r io.Reader, blockSize int64) (hashes []string, err error) {
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		sum := md5.Sum(buf[:n])
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return hashes, nil
+}
+
 // String returns a string representation of the hash type.
 // The function will panic if the hash type is unknown.
 func (h Type) String() string {
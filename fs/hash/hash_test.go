@@ -156,6 +156,22 @@ func TestHashStreamTypes(t *testing.T) {
 	}
 }
 
+func TestHashStreamBlocks(t *testing.T) {
+	for _, test := range []struct {
+		input     string
+		blockSize int64
+		want      []string
+	}{
+		{"", 4, nil},
+		{"abcd", 4, []string{"e2fc714c4727ee9395f324cd2e7f331f"}},
+		{"abcdef", 4, []string{"e2fc714c4727ee9395f324cd2e7f331f", "feb78cc258bdc76867354f01c22dbe43"}},
+	} {
+		hashes, err := hash.StreamBlocks(bytes.NewBufferString(test.input), test.blockSize)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, hashes, test.input)
+	}
+}
+
 func TestHashSetStringer(t *testing.T) {
 	h := hash.NewHashSet(hash.SHA1, hash.MD5)
 	assert.Equal(t, "[md5, sha1]", h.String())
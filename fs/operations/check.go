@@ -43,6 +43,7 @@ type CheckOpt struct {
 	Match        io.Writer // matching files
 	Differ       io.Writer // differing files
 	Error        io.Writer // files with errors of some kind
+	Moved        io.Writer // files moved or renamed as "old -> new"
 }
 
 // checkMarch is used to march over two Fses in the same way as
@@ -56,7 +57,12 @@ type checkMarch struct {
 	srcFilesMissing int32
 	dstFilesMissing int32
 	matches         int32
+	moved           int32
 	opt             CheckOpt
+	renameMap       *RenameMap  // set if tracking renames
+	renameMu        sync.Mutex  // protects srcOnly and dstOnly
+	srcOnly         []fs.Object // objects only in the source if tracking renames
+	dstOnly         []fs.Object // objects only in the destination if tracking renames
 }
 
 // report outputs the fileName to out if required and to the combined log
@@ -73,19 +79,51 @@ func (c *checkMarch) reportFilename(filename string, out io.Writer, sigil rune)
 	}
 }
 
+// reportDstOnly reports an object which is in the destination only
+func (c *checkMarch) reportDstOnly(dst fs.Object) {
+	if c.opt.OneWay {
+		return
+	}
+	err := fmt.Errorf("file not in %v", c.opt.Fsrc)
+	fs.Errorf(dst, "%v", err)
+	_ = fs.CountError(err)
+	atomic.AddInt32(&c.differences, 1)
+	atomic.AddInt32(&c.srcFilesMissing, 1)
+	c.report(dst, c.opt.MissingOnSrc, '-')
+}
+
+// reportSrcOnly reports an object which is in the source only
+func (c *checkMarch) reportSrcOnly(src fs.Object) {
+	err := fmt.Errorf("file not in %v", c.opt.Fdst)
+	fs.Errorf(src, "%v", err)
+	_ = fs.CountError(err)
+	atomic.AddInt32(&c.differences, 1)
+	atomic.AddInt32(&c.dstFilesMissing, 1)
+	c.report(src, c.opt.MissingOnDst, '+')
+}
+
+// reportMoved reports that dst in the destination is the same file
+// as src in the source but at a different path
+func (c *checkMarch) reportMoved(dst, src fs.Object) {
+	err := fmt.Errorf("file moved from %q in %v", dst.Remote(), c.opt.Fdst)
+	fs.Errorf(src, "%v", err)
+	_ = fs.CountError(err)
+	atomic.AddInt32(&c.differences, 1)
+	atomic.AddInt32(&c.moved, 1)
+	c.reportFilename(fmt.Sprintf("%s -> %s", dst.String(), src.String()), c.opt.Moved, '>')
+}
+
 // DstOnly have an object which is in the destination only
 func (c *checkMarch) DstOnly(dst fs.DirEntry) (recurse bool) {
-	switch dst.(type) {
+	switch x := dst.(type) {
 	case fs.Object:
-		if c.opt.OneWay {
+		if c.renameMap != nil {
+			c.renameMu.Lock()
+			c.dstOnly = append(c.dstOnly, x)
+			c.renameMu.Unlock()
 			return false
 		}
-		err := fmt.Errorf("file not in %v", c.opt.Fsrc)
-		fs.Errorf(dst, "%v", err)
-		_ = fs.CountError(err)
-		atomic.AddInt32(&c.differences, 1)
-		atomic.AddInt32(&c.srcFilesMissing, 1)
-		c.report(dst, c.opt.MissingOnSrc, '-')
+		c.reportDstOnly(x)
 	case fs.Directory:
 		// Do the same thing to the entire contents of the directory
 		if c.opt.OneWay {
@@ -100,14 +138,15 @@ func (c *checkMarch) DstOnly(dst fs.DirEntry) (recurse bool) {
 
 // SrcOnly have an object which is in the source only
 func (c *checkMarch) SrcOnly(src fs.DirEntry) (recurse bool) {
-	switch src.(type) {
+	switch x := src.(type) {
 	case fs.Object:
-		err := fmt.Errorf("file not in %v", c.opt.Fdst)
-		fs.Errorf(src, "%v", err)
-		_ = fs.CountError(err)
-		atomic.AddInt32(&c.differences, 1)
-		atomic.AddInt32(&c.dstFilesMissing, 1)
-		c.report(src, c.opt.MissingOnDst, '+')
+		if c.renameMap != nil {
+			c.renameMu.Lock()
+			c.srcOnly = append(c.srcOnly, x)
+			c.renameMu.Unlock()
+			return false
+		}
+		c.reportSrcOnly(x)
 	case fs.Directory:
 		// Do the same thing to the entire contents of the directory
 		return true
@@ -216,6 +255,9 @@ func CheckFn(ctx context.Context, opt *CheckOpt) error {
 		tokens: make(chan struct{}, ci.Checkers),
 		opt:    *opt,
 	}
+	if err := c.startTrackRenames(ctx); err != nil {
+		return err
+	}
 
 	// set up a march over fdst and fsrc
 	m := &march.March{
@@ -230,10 +272,115 @@ func CheckFn(ctx context.Context, opt *CheckOpt) error {
 	fs.Debugf(c.opt.Fdst, "Waiting for checks to finish")
 	err := m.Run(ctx)
 	c.wg.Wait() // wait for background go-routines
+	c.checkRenames(ctx)
 
 	return c.reportResults(ctx, err)
 }
 
+// startTrackRenames sets up the detection of moved files if
+// --track-renames is set or a report of moved files was requested.
+//
+// Files are matched using --track-renames-strategy in the same way
+// as sync does.
+func (c *checkMarch) startTrackRenames(ctx context.Context) error {
+	ci := fs.GetConfig(ctx)
+	if (!ci.TrackRenames && c.opt.Moved == nil) || c.opt.Fsrc == nil {
+		return nil
+	}
+	strategy, err := ParseRenamesStrategy(ci.TrackRenamesStrategy)
+	if err != nil {
+		return err
+	}
+	hashType := c.opt.Fsrc.Hashes().Overlap(c.opt.Fdst.Hashes()).GetOne()
+	if strategy.Hash() && hashType == hash.None {
+		fs.Errorf(c.opt.Fdst, "Ignoring --track-renames as the source and destination do not have a common hash")
+		return nil
+	}
+	modifyWindow := fs.GetModifyWindow(ctx, c.opt.Fsrc, c.opt.Fdst)
+	if strategy.ModTime() && modifyWindow == fs.ModTimeNotSupported {
+		fs.Errorf(c.opt.Fdst, "Ignoring --track-renames as either the source or destination do not support modtime")
+		return nil
+	}
+	c.renameMap = NewRenameMap(ctx, strategy, hashType, modifyWindow)
+	return nil
+}
+
+// renameIDs works out the rename IDs of the objects whose size is in
+// sizes using --checkers threads. The ID is "" if the object can't be
+// matched.
+func (c *checkMarch) renameIDs(ctx context.Context, objs []fs.Object, sizes map[int64]struct{}) []string {
+	ci := fs.GetConfig(ctx)
+	ids := make([]string, len(objs))
+	in := make(chan int, ci.Checkers)
+	var wg sync.WaitGroup
+	wg.Add(ci.Checkers)
+	for i := 0; i < ci.Checkers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range in {
+				obj := objs[i]
+				if _, found := sizes[obj.Size()]; !found {
+					continue
+				}
+				tr := accounting.Stats(ctx).NewCheckingTransfer(obj, "renaming")
+				ids[i] = c.renameMap.ID(obj)
+				tr.Done(ctx, nil)
+			}
+		}()
+	}
+	for i := range objs {
+		in <- i
+	}
+	close(in)
+	wg.Wait()
+	return ids
+}
+
+// checkRenames matches the objects only in the source with the
+// objects only in the destination and reports the pairs found as
+// moved. The objects left over are reported as missing.
+func (c *checkMarch) checkRenames(ctx context.Context) {
+	if c.renameMap == nil {
+		return
+	}
+	fs.Infof(c.opt.Fdst, "Looking for moved files")
+
+	// only objects with sizes on both sides can match
+	srcSizes := map[int64]struct{}{}
+	for _, obj := range c.srcOnly {
+		srcSizes[obj.Size()] = struct{}{}
+	}
+	dstSizes := map[int64]struct{}{}
+	for _, obj := range c.dstOnly {
+		dstSizes[obj.Size()] = struct{}{}
+	}
+
+	for i, id := range c.renameIDs(ctx, c.dstOnly, srcSizes) {
+		if id != "" {
+			c.renameMap.Push(id, c.dstOnly[i])
+		}
+	}
+	moved := map[string]struct{}{}
+	for i, id := range c.renameIDs(ctx, c.srcOnly, dstSizes) {
+		src := c.srcOnly[i]
+		var dst fs.Object
+		if id != "" {
+			dst = c.renameMap.Pop(id, src)
+		}
+		if dst == nil {
+			c.reportSrcOnly(src)
+			continue
+		}
+		moved[dst.Remote()] = struct{}{}
+		c.reportMoved(dst, src)
+	}
+	for _, dst := range c.dstOnly {
+		if _, found := moved[dst.Remote()]; !found {
+			c.reportDstOnly(dst)
+		}
+	}
+}
+
 func (c *checkMarch) reportResults(ctx context.Context, err error) error {
 	if c.dstFilesMissing > 0 {
 		fs.Logf(c.opt.Fdst, "%d files missing", c.dstFilesMissing)
@@ -256,6 +403,9 @@ func (c *checkMarch) reportResults(ctx context.Context, err error) error {
 	if c.matches > 0 {
 		fs.Logf(c.opt.Fdst, "%d matching files", c.matches)
 	}
+	if c.moved > 0 {
+		fs.Logf(c.opt.Fdst, "%d files moved", c.moved)
+	}
 	if err != nil {
 		return err
 	}
@@ -339,7 +489,7 @@ func checkIdenticalDownload(ctx context.Context, dst, src fs.Object) (differ boo
 	if err != nil {
 		return true, fmt.Errorf("failed to open %q: %w", dst, err)
 	}
-	tr1 := accounting.Stats(ctx).NewTransfer(dst)
+	tr1 := accounting.Stats(ctx).NewTransfer(dst, nil)
 	defer func() {
 		tr1.Done(ctx, nil) // error handling is done by the caller
 	}()
@@ -349,7 +499,7 @@ func checkIdenticalDownload(ctx context.Context, dst, src fs.Object) (differ boo
 	if err != nil {
 		return true, fmt.Errorf("failed to open %q: %w", src, err)
 	}
-	tr2 := accounting.Stats(ctx).NewTransfer(dst)
+	tr2 := accounting.Stats(ctx).NewTransfer(dst, nil)
 	defer func() {
 		tr2.Done(ctx, nil) // error handling is done by the caller
 	}()
@@ -486,7 +636,7 @@ func (c *checkMarch) checkSum(ctx context.Context, obj fs.Object, download bool,
 		if in, err = obj.Open(ctx); err != nil {
 			return
 		}
-		tr := accounting.Stats(ctx).NewTransfer(obj)
+		tr := accounting.Stats(ctx).NewTransfer(obj, nil)
 		in = tr.Account(ctx, in).WithBuffer() // account and buffer the transfer
 		defer func() {
 			tr.Done(ctx, nil) // will close the stream
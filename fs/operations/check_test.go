@@ -544,3 +544,36 @@ func TestCheckSum(t *testing.T) {
 func TestCheckSumDownload(t *testing.T) {
 	testCheckSum(t, true)
 }
+
+func TestCheckMoved(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx := context.Background()
+
+	file1 := r.WriteBoth(ctx, "rutabaga", "is tasty", t3)
+	file2 := r.WriteFile("dir/potato", "moved in the source", t1)
+	file3 := r.WriteFile("carrot", "only in the source", t2)
+	file4 := r.WriteObject(ctx, "potato", "moved in the source", t1)
+	r.CheckLocalItems(t, file1, file2, file3)
+	r.CheckRemoteItems(t, file1, file4)
+
+	accounting.GlobalStats().ResetCounters()
+	var combined, moved, missingOnDst, missingOnSrc bytes.Buffer
+	opt := operations.CheckOpt{
+		Fdst:         r.Fremote,
+		Fsrc:         r.Flocal,
+		Combined:     &combined,
+		Moved:        &moved,
+		MissingOnDst: &missingOnDst,
+		MissingOnSrc: &missingOnSrc,
+	}
+	err := operations.Check(ctx, &opt)
+	require.Error(t, err)
+	assert.Equal(t, int64(2), accounting.GlobalStats().GetErrors())
+
+	assert.Equal(t, "potato -> dir/potato\n", moved.String())
+	assert.Equal(t, "carrot\n", missingOnDst.String())
+	assert.Equal(t, "", missingOnSrc.String())
+	lines := strings.Split(strings.TrimSpace(combined.String()), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{"+ carrot", "= rutabaga", "> potato -> dir/potato"}, lines)
+}
@@ -0,0 +1,272 @@
+package operations
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+)
+
+const (
+	deltaMinSize      = 1024 * 1024     // don't use delta copies for destinations smaller than this
+	deltaMinBlockSize = 64 * 1024       // smallest automatic block size
+	deltaMaxBlockSize = 4 * 1024 * 1024 // largest automatic block size
+)
+
+// Return a boolean as to whether we should use a delta copy to
+// update dst from src
+func doDeltaCopy(ctx context.Context, dst, src fs.Object) bool {
+	ci := fs.GetConfig(ctx)
+
+	// Disable delta copy if...
+
+	// ...it isn't configured
+	if !ci.Delta {
+		return false
+	}
+	// ...there is nothing to update
+	if dst == nil || dst.Size() < deltaMinSize {
+		return false
+	}
+	// ...size of source is unknown
+	if src.Size() < 0 {
+		return false
+	}
+	return true
+}
+
+// deltaBlockSize returns the block size to use for a delta copy of a
+// file of size bytes
+func deltaBlockSize(ctx context.Context, size int64) int64 {
+	ci := fs.GetConfig(ctx)
+	if ci.DeltaBlockSize > 0 {
+		return int64(ci.DeltaBlockSize)
+	}
+	blockSize := int64(math.Sqrt(float64(size)))
+	// Round up to a multiple of 1k
+	blockSize = (blockSize + 1023) &^ 1023
+	if blockSize < deltaMinBlockSize {
+		blockSize = deltaMinBlockSize
+	} else if blockSize > deltaMaxBlockSize {
+		blockSize = deltaMaxBlockSize
+	}
+	return blockSize
+}
+
+// deltaBlockHashes returns the hashes of the blocks of remote on f
+// checking there is one for each block of a file of size bytes
+func deltaBlockHashes(ctx context.Context, f fs.Info, remote string, size, blockSize int64) ([]string, error) {
+	hashes, err := f.Features().BlockHashes(ctx, remote, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	blocks := (size + blockSize - 1) / blockSize
+	if int64(len(hashes)) != blocks {
+		return nil, fmt.Errorf("expecting %d block hashes but got %d", blocks, len(hashes))
+	}
+	return hashes, nil
+}
+
+// deltaRange is a range of bytes from start up to but not including
+// end
+type deltaRange struct {
+	start, end int64
+}
+
+// deltaChanges returns the ranges of a file of size bytes which need
+// writing to turn the destination into the source given the hashes
+// of their blocks. Adjacent blocks are merged into one range.
+func deltaChanges(srcHashes, dstHashes []string, size, blockSize int64) (changes []deltaRange) {
+	for i, srcHash := range srcHashes {
+		if i < len(dstHashes) && srcHash == dstHashes[i] {
+			continue
+		}
+		start := int64(i) * blockSize
+		end := start + blockSize
+		if end > size {
+			end = size
+		}
+		if n := len(changes); n > 0 && changes[n-1].end == start {
+			changes[n-1].end = end
+		} else {
+			changes = append(changes, deltaRange{start: start, end: end})
+		}
+	}
+	return changes
+}
+
+// deltaWriter writes the changed blocks of the source to the
+// destination in place
+type deltaWriter struct {
+	ctx       context.Context
+	src       fs.Object           // source to read blocks from
+	wc        fs.WriterAtCloser   // destination to write to
+	acc       *accounting.Account // accounts the data read from the source
+	blockSize int64
+	buf       []byte // buffer of blockSize bytes
+	written   int64  // bytes written to the destination
+}
+
+// write writes the blocks read from in to the destination starting at
+// offset pos, returning the number of bytes read.
+//
+// If dstHashes is set then only the blocks whose hashes differ are
+// written.
+func (w *deltaWriter) write(in io.Reader, pos int64, dstHashes []string) (n int64, err error) {
+	for {
+		nr, err := io.ReadFull(in, w.buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return n, fmt.Errorf("delta copy: failed to read source: %w", err)
+		}
+		if accErr := w.acc.AccountRead(nr); accErr != nil {
+			return n, accErr
+		}
+		block := w.buf[:nr]
+		i := (pos + n) / w.blockSize
+		if dstHashes == nil || i >= int64(len(dstHashes)) || blockHash(block) != dstHashes[i] {
+			_, writeErr := w.wc.WriteAt(block, pos+n)
+			if writeErr != nil {
+				return n, fmt.Errorf("delta copy: write failed: %w", writeErr)
+			}
+			w.written += int64(nr)
+		}
+		n += int64(nr)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return n, nil
+}
+
+// writeRange reads the range r from the source and writes it to the
+// destination
+func (w *deltaWriter) writeRange(r deltaRange) (err error) {
+	ci := fs.GetConfig(w.ctx)
+	in, err := NewReOpen(w.ctx, w.src, ci.LowLevelRetries, &fs.RangeOption{Start: r.start, End: r.end - 1})
+	if err != nil {
+		return fmt.Errorf("delta copy: failed to open source: %w", err)
+	}
+	defer fs.CheckClose(in, &err)
+	n, err := w.write(in, r.start, nil)
+	if err != nil {
+		return err
+	}
+	if n != r.end-r.start {
+		return fmt.Errorf("delta copy: source changed: read %d bytes at %d but expecting %d", n, r.start, r.end-r.start)
+	}
+	return nil
+}
+
+// writeAll reads the whole of the source and writes the blocks which
+// differ from dstHashes to the destination
+func (w *deltaWriter) writeAll(dstHashes []string) (err error) {
+	ci := fs.GetConfig(w.ctx)
+	in, err := NewReOpen(w.ctx, w.src, ci.LowLevelRetries)
+	if err != nil {
+		return fmt.Errorf("delta copy: failed to open source: %w", err)
+	}
+	defer fs.CheckClose(in, &err)
+	n, err := w.write(in, 0, dstHashes)
+	if err != nil {
+		return err
+	}
+	if n != w.src.Size() {
+		return fmt.Errorf("delta copy: source changed: read %d bytes but expecting %d", n, w.src.Size())
+	}
+	return nil
+}
+
+// blockHash returns the hash of block as returned by BlockHashes
+func blockHash(block []byte) string {
+	sum := md5.Sum(block)
+	return hex.EncodeToString(sum[:])
+}
+
+// Update dst at (f, remote) in place to be the same as src using a
+// delta copy
+//
+// The destination is compared with the source a block at a time using
+// the hashes of its blocks calculated by the remote. If the source can
+// calculate the hashes of its blocks then only the blocks which differ
+// are read from it, otherwise it is read in full but only the blocks
+// which differ are written.
+func deltaCopy(ctx context.Context, f fs.Fs, remote string, dst, src fs.Object, tr *accounting.Transfer) (newDst fs.Object, err error) {
+	openWriterAt := f.Features().OpenWriterAt
+	if openWriterAt == nil || f.Features().BlockHashes == nil {
+		return nil, fserrors.NoRetryError(fmt.Errorf("--delta: %v can't be updated in place as it doesn't support OpenWriterAt and BlockHashes", f))
+	}
+	if src.Size() < 0 {
+		return nil, errors.New("delta copy: can't copy unknown sized file")
+	}
+
+	blockSize := deltaBlockSize(ctx, dst.Size())
+	fs.Debugf(src, "Starting delta copy with block size %v", fs.SizeSuffix(blockSize))
+	dstHashes, err := deltaBlockHashes(ctx, f, remote, dst.Size(), blockSize)
+	if err != nil {
+		return nil, fmt.Errorf("delta copy: failed to hash destination blocks: %w", err)
+	}
+	var srcHashes []string
+	srcHashed := false
+	if src.Fs().Features().BlockHashes != nil {
+		srcHashes, err = deltaBlockHashes(ctx, src.Fs(), src.Remote(), src.Size(), blockSize)
+		if err == nil {
+			srcHashed = true
+		} else {
+			fs.Logf(src, "Delta copy reading whole source as failed to hash its blocks: %v", err)
+		}
+	}
+
+	wc, err := openWriterAt(ctx, remote, src.Size())
+	if err != nil {
+		return nil, fmt.Errorf("delta copy: failed to open destination: %w", err)
+	}
+	w := &deltaWriter{
+		ctx:       ctx,
+		src:       src,
+		wc:        wc,
+		acc:       tr.Account(ctx, nil),
+		blockSize: blockSize,
+		buf:       make([]byte, blockSize),
+	}
+	if srcHashed {
+		for _, r := range deltaChanges(srcHashes, dstHashes, src.Size(), blockSize) {
+			err = w.writeRange(r)
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		err = w.writeAll(dstHashes)
+	}
+	closeErr := wc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("delta copy: failed to close object after copy: %w", closeErr)
+	}
+
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("delta copy: failed to find object after copy: %w", err)
+	}
+
+	err = obj.SetModTime(ctx, src.ModTime(ctx))
+	switch err {
+	case nil, fs.ErrorCantSetModTime, fs.ErrorCantSetModTimeWithoutDelete:
+	default:
+		return nil, fmt.Errorf("delta copy: failed to set modification time: %w", err)
+	}
+
+	fs.Debugf(src, "Finished delta copy writing %v of %v", fs.SizeSuffix(w.written), fs.SizeSuffix(src.Size()))
+	return obj, nil
+}
@@ -0,0 +1,124 @@
+package operations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeltaBlockSize(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, int64(deltaMinBlockSize), deltaBlockSize(ctx, 1024))
+	assert.Equal(t, int64(1024*1024), deltaBlockSize(ctx, 1<<40))
+	assert.Equal(t, int64(deltaMaxBlockSize), deltaBlockSize(ctx, 1<<50))
+}
+
+func TestDeltaChanges(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		src       []string
+		dst       []string
+		size      int64
+		wantRange []deltaRange
+	}{
+		{"same", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 25, nil},
+		{"changed", []string{"a", "x", "c"}, []string{"a", "b", "c"}, 25, []deltaRange{{10, 20}}},
+		{"adjacent", []string{"x", "y", "c", "z"}, []string{"a", "b", "c", "d"}, 40, []deltaRange{{0, 20}, {30, 40}}},
+		{"extended", []string{"a", "b", "c", "d"}, []string{"a", "b"}, 35, []deltaRange{{20, 35}}},
+		{"truncated", []string{"a", "x"}, []string{"a", "b", "c"}, 15, []deltaRange{{10, 15}}},
+		{"empty", nil, []string{"a"}, 0, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.wantRange, deltaChanges(test.src, test.dst, test.size, 10))
+		})
+	}
+}
+
+// withoutFeature is an fs.Fs without one of its optional features
+type withoutFeature struct {
+	fs.Fs
+	name string
+}
+
+func (f withoutFeature) Features() *fs.Features {
+	ft := *f.Fs.Features()
+	return ft.Disable(f.name)
+}
+
+// withoutFeatureObject is an fs.Object whose Fs is withoutFeature
+type withoutFeatureObject struct {
+	fs.Object
+	name string
+}
+
+func (o withoutFeatureObject) Fs() fs.Info {
+	return withoutFeature{Fs: o.Object.Fs().(fs.Fs), name: o.name}
+}
+
+func TestDeltaCopy(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	if r.Flocal.Features().OpenWriterAt == nil || r.Flocal.Features().BlockHashes == nil {
+		t.Skip("OpenWriterAt and BlockHashes not supported")
+	}
+	ci.Delta = true
+	ci.DeltaBlockSize = 64 * 1024
+
+	const size = 2 * deltaMinSize
+	contents := random.String(size)
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	t2 := fstest.Time("2011-12-25T12:59:59.123456789Z")
+	t3 := fstest.Time("2021-12-25T12:59:59.123456789Z")
+
+	// copy dst from the source on remote returning the bytes read
+	copyFile := func(t *testing.T, hideSrcFeature string) int64 {
+		dst, err := r.Flocal.NewObject(ctx, "file1")
+		require.NoError(t, err)
+		src, err := r.Fremote.NewObject(ctx, "file1")
+		require.NoError(t, err)
+		if hideSrcFeature != "" {
+			src = withoutFeatureObject{Object: src, name: hideSrcFeature}
+		}
+		require.True(t, doDeltaCopy(ctx, dst, src))
+		before := accounting.Stats(ctx).GetBytes()
+		newDst, err := Copy(ctx, r.Flocal, dst, "file1", src)
+		require.NoError(t, err)
+		assert.Equal(t, src.Size(), newDst.Size())
+		return accounting.Stats(ctx).GetBytes() - before
+	}
+
+	r.WriteFile("file1", contents, t1)
+
+	t.Run("ReadChanged", func(t *testing.T) {
+		// Change the second block and shorten the file
+		newContents := contents[:70000] + "changed" + contents[70007:size-3000]
+		file := r.WriteObject(ctx, "file1", newContents, t2)
+		assert.Equal(t, int64(64*1024+size-3000-31*64*1024), copyFile(t, ""))
+		r.CheckLocalItems(t, file)
+		contents = newContents
+	})
+
+	t.Run("ReadAll", func(t *testing.T) {
+		newContents := "changed" + contents[7:]
+		file := r.WriteObject(ctx, "file1", newContents, t3)
+		assert.Equal(t, int64(len(newContents)), copyFile(t, "BlockHashes"))
+		r.CheckLocalItems(t, file)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		dst, err := r.Flocal.NewObject(ctx, "file1")
+		require.NoError(t, err)
+		src, err := r.Fremote.NewObject(ctx, "file1")
+		require.NoError(t, err)
+		f := withoutFeature{Fs: r.Flocal, name: "OpenWriterAt"}
+		_, err = Copy(ctx, f, dst, "file1", src)
+		assert.ErrorContains(t, err, "--delta")
+	})
+}
@@ -1,13 +1,23 @@
 package operations
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/ranges"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -15,6 +25,8 @@ const (
 	multithreadChunkSize     = 64 << 10
 	multithreadChunkSizeMask = multithreadChunkSize - 1
 	multithreadBufferSize    = 32 * 1024
+	multithreadResumeDir     = "multithread-resume" // directory in the cache dir for the resume state
+	multithreadResumeSave    = 10 * time.Second     // how often to save the resume state
 )
 
 // Return a boolean as to whether we should use multi thread copy for
@@ -32,9 +44,9 @@ func doMultiThreadCopy(ctx context.Context, f fs.Fs, src fs.Object) bool {
 	if src.Size() < int64(ci.MultiThreadCutoff) {
 		return false
 	}
-	// ...source doesn't support it
+	// ...destination doesn't support it
 	dstFeatures := f.Features()
-	if dstFeatures.OpenWriterAt == nil {
+	if dstFeatures.OpenWriterAt == nil && dstFeatures.OpenChunkWriter == nil {
 		return false
 	}
 	// ...if --multi-thread-streams not in use and source and
@@ -54,11 +66,146 @@ type multiThreadCopyState struct {
 	src      fs.Object
 	acc      *accounting.Account
 	streams  int
+	resume   *multiThreadResume
+	last     []byte // last byte of the file, written once everything else is in
+}
+
+// multiThreadResumeInfo is saved in the cache directory while a
+// multi-thread copy is in progress so that it can be continued if
+// rclone is interrupted.
+type multiThreadResumeInfo struct {
+	Fingerprint string        `json:"fingerprint"` // fingerprint of the source
+	Size        int64         `json:"size"`        // size of the source
+	Done        ranges.Ranges `json:"done"`        // ranges written to the destination
+}
+
+// multiThreadResume keeps track of the ranges written by a
+// multi-thread copy and persists them to the cache directory.
+//
+// The state is kept outside the destination so syncs don't see it.
+type multiThreadResume struct {
+	path     string // path of the resume state file
+	wc       fs.WriterAtCloser
+	mu       sync.Mutex
+	info     multiThreadResumeInfo
+	lastSave time.Time
+}
+
+// newMultiThreadResume reads the resume state for copying src to
+// (f, remote) if any.
+//
+// If there isn't any resume state or it doesn't match src then the
+// returned state is empty so the copy will start from the beginning.
+func newMultiThreadResume(ctx context.Context, f fs.Fs, remote string, src fs.Object) *multiThreadResume {
+	id := md5.Sum([]byte(fs.ConfigString(f) + "\x00" + remote))
+	r := &multiThreadResume{
+		path: filepath.Join(config.GetCacheDir(), multithreadResumeDir, hex.EncodeToString(id[:])+".json"),
+		info: multiThreadResumeInfo{
+			Fingerprint: fs.Fingerprint(ctx, src, true),
+			Size:        src.Size(),
+		},
+		lastSave: time.Now(),
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return r
+	}
+	var info multiThreadResumeInfo
+	err = json.Unmarshal(data, &info)
+	switch {
+	case err != nil:
+		fs.Debugf(src, "multi-thread copy: ignoring unreadable resume state: %v", err)
+	case info.Fingerprint != r.info.Fingerprint || info.Size != r.info.Size:
+		fs.Infof(src, "multi-thread copy: source has changed - restarting copy")
+	default:
+		o, err := f.NewObject(ctx, remote)
+		if err != nil {
+			fs.Debugf(src, "multi-thread copy: partial destination not found - restarting copy")
+			break
+		}
+		// The partial destination must hold all the ranges written
+		// but can't be complete as the last byte is written last
+		var written int64
+		if n := len(info.Done); n > 0 {
+			written = info.Done[n-1].End()
+		}
+		if o.Size() < written || o.Size() >= src.Size() {
+			fs.Infof(src, "multi-thread copy: partial destination size %d doesn't match resume state - restarting copy", o.Size())
+			break
+		}
+		r.info.Done = info.Done
+		fs.Infof(src, "multi-thread copy: resuming with %v already copied", fs.SizeSuffix(info.Done.Size()))
+	}
+	return r
+}
+
+// done returns a copy of the ranges written so far
+func (r *multiThreadResume) done() ranges.Ranges {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(ranges.Ranges(nil), r.info.Done...)
+}
+
+// written marks the range as written, saving the state if it is due
+func (r *multiThreadResume) written(ctx context.Context, rng ranges.Range) {
+	r.mu.Lock()
+	r.info.Done.Insert(rng)
+	due := time.Since(r.lastSave) >= multithreadResumeSave
+	r.mu.Unlock()
+	if due {
+		r.save(ctx)
+	}
+}
+
+// save the resume state to the cache directory
+//
+// The ranges are copied before the destination is flushed so that
+// the state never claims more than has been written.
+func (r *multiThreadResume) save(ctx context.Context) {
+	r.mu.Lock()
+	r.lastSave = time.Now()
+	info := r.info
+	info.Done = append(ranges.Ranges(nil), r.info.Done...)
+	r.mu.Unlock()
+	if do, ok := r.wc.(interface{ Sync() error }); ok {
+		if err := do.Sync(); err != nil {
+			fs.Debugf(r.path, "multi-thread copy: failed to flush destination: %v", err)
+			return
+		}
+	}
+	data, err := json.Marshal(&info)
+	if err != nil {
+		fs.Errorf(r.path, "multi-thread copy: failed to encode resume state: %v", err)
+		return
+	}
+	// Write to a temporary file and rename so the state is never
+	// left half written
+	err = os.MkdirAll(filepath.Dir(r.path), 0700)
+	if err == nil {
+		tmp := fmt.Sprintf("%s.%d.tmp", r.path, time.Now().UnixNano())
+		err = os.WriteFile(tmp, data, 0600)
+		if err == nil {
+			err = os.Rename(tmp, r.path)
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}
+	if err != nil {
+		fs.Errorf(r.path, "multi-thread copy: failed to save resume state: %v", err)
+	}
+}
+
+// remove the resume state if present
+func (r *multiThreadResume) remove(ctx context.Context) {
+	err := os.Remove(r.path)
+	if err != nil && !os.IsNotExist(err) {
+		fs.Errorf(r.path, "multi-thread copy: failed to remove resume state: %v", err)
+	}
 }
 
 // Copy a single stream into place
-func (mc *multiThreadCopyState) copyStream(ctx context.Context, stream int) (err error) {
-	ci := fs.GetConfig(ctx)
+func (mc *multiThreadCopyState) copyStream(ctx context.Context, stream int, done ranges.Ranges) (err error) {
 	defer func() {
 		if err != nil {
 			fs.Debugf(mc.src, "multi-thread copy: stream %d/%d failed: %v", stream+1, mc.streams, err)
@@ -73,6 +220,24 @@ func (mc *multiThreadCopyState) copyStream(ctx context.Context, stream int) (err
 		end = mc.size
 	}
 
+	// Copy only the parts which weren't written by a previous run
+	for _, fr := range done.FindAll(ranges.Range{Pos: start, Size: end - start}) {
+		if fr.Present {
+			fs.Debugf(mc.src, "multi-thread copy: stream %d/%d (%d-%d) size %v already copied", stream+1, mc.streams, fr.R.Pos, fr.R.End(), fs.SizeSuffix(fr.R.Size))
+			continue
+		}
+		err = mc.copyRange(ctx, stream, fr.R.Pos, fr.R.End())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy the range start to end of a single stream into place
+func (mc *multiThreadCopyState) copyRange(ctx context.Context, stream int, start, end int64) (err error) {
+	ci := fs.GetConfig(ctx)
+
 	fs.Debugf(mc.src, "multi-thread copy: stream %d/%d (%d-%d) size %v starting", stream+1, mc.streams, start, end, fs.SizeSuffix(end-start))
 
 	rc, err := NewReOpen(ctx, mc.src, ci.LowLevelRetries, &fs.RangeOption{Start: start, End: end - 1})
@@ -95,16 +260,32 @@ func (mc *multiThreadCopyState) copyStream(ctx context.Context, stream int) (err
 			if err != nil {
 				return fmt.Errorf("multipart copy: accounting failed: %w", err)
 			}
-			nw, ew := mc.wc.WriteAt(buf[0:nr], offset)
+			// Hold back the last byte of the file so the
+			// destination only reaches its full size once every
+			// other range has been written
+			n := nr
+			if offset+int64(n) == mc.size {
+				n--
+				mc.last = []byte{buf[n]}
+			}
+			var nw int
+			var ew error
+			if n > 0 {
+				nw, ew = mc.wc.WriteAt(buf[0:n], offset)
+			}
 			if nw > 0 {
+				mc.resume.written(ctx, ranges.Range{Pos: offset, Size: int64(nw)})
 				offset += int64(nw)
 			}
 			if ew != nil {
 				return fmt.Errorf("multipart copy: write failed: %w", ew)
 			}
-			if nr != nw {
+			if n != nw {
 				return fmt.Errorf("multipart copy: %w", io.ErrShortWrite)
 			}
+			if n != nr {
+				offset++
+			}
 		}
 		if er != nil {
 			if er != io.EOF {
@@ -139,11 +320,16 @@ func (mc *multiThreadCopyState) calculateChunks() {
 	}
 }
 
-// Copy src to (f, remote) using streams download threads and the OpenWriterAt feature
-func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object, streams int, tr *accounting.Transfer) (newDst fs.Object, err error) {
+// Copy src to (f, remote) using streams download threads and the
+// OpenWriterAt feature, or the OpenChunkWriter feature if
+// OpenWriterAt isn't available
+//
+// The options are passed to OpenChunkWriter if it is used.
+func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object, streams int, tr *accounting.Transfer, options ...fs.OpenOption) (newDst fs.Object, err error) {
 	openWriterAt := f.Features().OpenWriterAt
-	if openWriterAt == nil {
-		return nil, errors.New("multi-thread copy: OpenWriterAt not supported")
+	openChunkWriter := f.Features().OpenChunkWriter
+	if openWriterAt == nil && openChunkWriter == nil {
+		return nil, errors.New("multi-thread copy: neither OpenWriterAt nor OpenChunkWriter supported")
 	}
 	if src.Size() < 0 {
 		return nil, errors.New("multi-thread copy: can't copy unknown sized file")
@@ -151,6 +337,9 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	if src.Size() == 0 {
 		return nil, errors.New("multi-thread copy: can't copy zero sized file")
 	}
+	if openWriterAt == nil {
+		return multiThreadCopyChunks(ctx, f, remote, src, streams, tr, options...)
+	}
 
 	g, gCtx := errgroup.WithContext(ctx)
 	mc := &multiThreadCopyState{
@@ -164,20 +353,47 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	// Make accounting
 	mc.acc = tr.Account(ctx, nil)
 
+	// Find out what a previous interrupted copy wrote
+	mc.resume = newMultiThreadResume(ctx, f, remote, src)
+	// The last byte is always copied as it is written last
+	done := mc.resume.done().Intersection(ranges.Range{Pos: 0, Size: mc.size - 1})
+
+	// With nothing to resume start from an empty destination so an
+	// existing larger object isn't cut down to a complete looking size
+	if done.Size() == 0 {
+		if o, err := f.NewObject(ctx, remote); err == nil {
+			if err = o.Remove(ctx); err != nil {
+				return nil, fmt.Errorf("multi-thread copy: failed to remove existing destination: %w", err)
+			}
+		}
+	}
+
 	// create write file handle
 	mc.wc, err = openWriterAt(gCtx, remote, mc.size)
 	if err != nil {
 		return nil, fmt.Errorf("multipart copy: failed to open destination: %w", err)
 	}
+	mc.resume.wc = mc.wc
 
 	fs.Debugf(src, "Starting multi-thread copy with %d parts of size %v", mc.streams, fs.SizeSuffix(mc.partSize))
 	for stream := 0; stream < mc.streams; stream++ {
 		stream := stream
 		g.Go(func() (err error) {
-			return mc.copyStream(gCtx, stream)
+			return mc.copyStream(gCtx, stream, done)
 		})
 	}
 	err = g.Wait()
+	if err == nil {
+		if mc.last == nil {
+			err = errors.New("multi-thread copy: last byte of file not read")
+		} else if _, err = mc.wc.WriteAt(mc.last, mc.size-1); err != nil {
+			err = fmt.Errorf("multi-thread copy: failed to write last byte: %w", err)
+		}
+	}
+	if err != nil {
+		// Save what was written so a later copy can resume
+		mc.resume.save(ctx)
+	}
 	closeErr := mc.wc.Close()
 	if err != nil {
 		return nil, err
@@ -185,6 +401,7 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	if closeErr != nil {
 		return nil, fmt.Errorf("multi-thread copy: failed to close object after copy: %w", closeErr)
 	}
+	mc.resume.remove(ctx)
 
 	obj, err := f.NewObject(ctx, remote)
 	if err != nil {
@@ -201,3 +418,105 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	fs.Debugf(src, "Finished multi-thread copy with %d parts of size %v", mc.streams, fs.SizeSuffix(mc.partSize))
 	return obj, nil
 }
+
+// Copy a single chunk of src with the ChunkWriter
+func copyChunk(ctx context.Context, src fs.Object, w fs.ChunkWriter, acc *accounting.Account, chunkNumber int, start, end int64) (err error) {
+	ci := fs.GetConfig(ctx)
+	defer func() {
+		if err != nil {
+			fs.Debugf(src, "multi-thread copy: chunk %d (%d-%d) failed: %v", chunkNumber+1, start, end, err)
+		}
+	}()
+	fs.Debugf(src, "multi-thread copy: chunk %d (%d-%d) size %v starting", chunkNumber+1, start, end, fs.SizeSuffix(end-start))
+
+	rc, err := NewReOpen(ctx, src, ci.LowLevelRetries, &fs.RangeOption{Start: start, End: end - 1})
+	if err != nil {
+		return fmt.Errorf("multipart copy: failed to open source: %w", err)
+	}
+	defer fs.CheckClose(rc, &err)
+
+	// Read the chunk into memory so it can be retried
+	buf := make([]byte, end-start)
+	n, err := io.ReadFull(rc, buf)
+	if n > 0 {
+		if accErr := acc.AccountRead(n); accErr != nil {
+			return fmt.Errorf("multipart copy: accounting failed: %w", accErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("multipart copy: read failed: %w", err)
+	}
+
+	_, err = w.WriteChunk(ctx, chunkNumber, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("multipart copy: write failed: %w", err)
+	}
+
+	fs.Debugf(src, "multi-thread copy: chunk %d (%d-%d) size %v finished", chunkNumber+1, start, end, fs.SizeSuffix(end-start))
+	return nil
+}
+
+// Copy src to (f, remote) using streams upload threads and the
+// OpenChunkWriter feature
+func multiThreadCopyChunks(ctx context.Context, f fs.Fs, remote string, src fs.Object, streams int, tr *accounting.Transfer, options ...fs.OpenOption) (newDst fs.Object, err error) {
+	info, w, err := f.Features().OpenChunkWriter(ctx, remote, src, options...)
+	if err != nil {
+		return nil, fmt.Errorf("multi-thread copy: failed to open chunk writer: %w", err)
+	}
+	finalised := false
+	defer func() {
+		if err == nil || finalised || info.LeavePartsOnError {
+			return
+		}
+		fs.Debugf(src, "multi-thread copy: aborting chunked upload")
+		if abortErr := w.Abort(context.Background()); abortErr != nil {
+			fs.Debugf(src, "multi-thread copy: failed to abort chunked upload: %v", abortErr)
+		}
+	}()
+
+	size := src.Size()
+	chunkSize := info.ChunkSize
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("multi-thread copy: invalid chunk size %d", chunkSize)
+	}
+	chunks := int((size + chunkSize - 1) / chunkSize)
+
+	// Make accounting
+	acc := tr.Account(ctx, nil)
+
+	fs.Debugf(src, "Starting multi-thread copy with %d chunks of size %v using %d streams", chunks, fs.SizeSuffix(chunkSize), streams)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(streams)
+	for chunkNumber := 0; chunkNumber < chunks; chunkNumber++ {
+		// Fail fast if any of the chunks have failed
+		if gCtx.Err() != nil {
+			break
+		}
+		chunkNumber := chunkNumber
+		start := int64(chunkNumber) * chunkSize
+		end := start + chunkSize
+		if end > size {
+			end = size
+		}
+		g.Go(func() error {
+			return copyChunk(gCtx, src, w, acc, chunkNumber, start, end)
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("multi-thread copy: failed to finalise chunked upload: %w", err)
+	}
+	finalised = true
+
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("multi-thread copy: failed to find object after copy: %w", err)
+	}
+
+	fs.Debugf(src, "Finished multi-thread copy with %d chunks of size %v", chunks, fs.SizeSuffix(chunkSize))
+	return obj, nil
+}
@@ -2,13 +2,18 @@ package operations
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sync"
 	"testing"
 
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fstest/mockfs"
 	"github.com/rclone/rclone/fstest/mockobject"
 	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/ranges"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fstest"
@@ -59,6 +64,11 @@ func TestDoMultiThreadCopy(t *testing.T) {
 
 	f.Features().OpenWriterAt = nil
 	assert.False(t, doMultiThreadCopy(ctx, f, src))
+	f.Features().OpenChunkWriter = func(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (fs.ChunkWriterInfo, fs.ChunkWriter, error) {
+		panic("don't call me")
+	}
+	assert.True(t, doMultiThreadCopy(ctx, f, src))
+	f.Features().OpenChunkWriter = nil
 	f.Features().OpenWriterAt = nullWriterAt
 	assert.True(t, doMultiThreadCopy(ctx, f, src))
 
@@ -130,7 +140,7 @@ func TestMultithreadCopy(t *testing.T) {
 			src, err := r.Fremote.NewObject(ctx, "file1")
 			require.NoError(t, err)
 			accounting.GlobalStats().ResetCounters()
-			tr := accounting.GlobalStats().NewTransfer(src)
+			tr := accounting.GlobalStats().NewTransfer(src, nil)
 
 			defer func() {
 				tr.Done(ctx, err)
@@ -146,3 +156,258 @@ func TestMultithreadCopy(t *testing.T) {
 	}
 
 }
+
+func TestMultithreadCopyResume(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx := context.Background()
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	size := multithreadChunkSize*4 + 17
+	contents := random.String(size)
+	file1 := r.WriteObject(ctx, "file1", contents, t1)
+	src, err := r.Fremote.NewObject(ctx, "file1")
+	require.NoError(t, err)
+
+	// Write a partial destination with the middle chunks and the
+	// last byte missing and the resume state saying the ends are done
+	partial := []byte(contents[:size-1])
+	for i := multithreadChunkSize; i < 3*multithreadChunkSize; i++ {
+		partial[i] = 0
+	}
+	r.WriteFile("file1", string(partial), t1)
+	resume := newMultiThreadResume(ctx, r.Flocal, "file1", src)
+	resume.info.Done.Insert(ranges.Range{Pos: 0, Size: multithreadChunkSize})
+	resume.info.Done.Insert(ranges.Range{Pos: 3 * multithreadChunkSize, Size: int64(size) - 1 - 3*multithreadChunkSize})
+	resume.save(ctx)
+
+	accounting.GlobalStats().ResetCounters()
+	tr := accounting.GlobalStats().NewTransfer(src, nil)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	dst, err := multiThreadCopy(ctx, r.Flocal, "file1", src, 2, tr)
+	require.NoError(t, err)
+
+	// Only the missing part and the last byte should have been transferred
+	assert.Equal(t, int64(2*multithreadChunkSize+1), accounting.GlobalStats().GetBytes())
+
+	// The resume state should be removed
+	_, err = os.Stat(resume.path)
+	assert.True(t, os.IsNotExist(err))
+
+	fstest.CheckListingWithPrecision(t, r.Flocal, []fstest.Item{file1}, nil, fs.GetModifyWindow(ctx, r.Flocal, r.Fremote))
+	require.NoError(t, dst.Remove(ctx))
+}
+
+func TestMultithreadCopyResumeChangedSource(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx := context.Background()
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	size := multithreadChunkSize*2 + 1
+	contents := random.String(size)
+	file1 := r.WriteObject(ctx, "file1", contents, t1)
+	src, err := r.Fremote.NewObject(ctx, "file1")
+	require.NoError(t, err)
+
+	// Resume state from a different source
+	r.WriteFile("file1", random.String(size), t1)
+	resume := newMultiThreadResume(ctx, r.Flocal, "file1", src)
+	resume.info.Fingerprint = "changed"
+	resume.info.Done.Insert(ranges.Range{Pos: 0, Size: int64(size)})
+	resume.save(ctx)
+
+	// Should ignore the resume state
+	resume = newMultiThreadResume(ctx, r.Flocal, "file1", src)
+	assert.Equal(t, int64(0), resume.done().Size())
+
+	accounting.GlobalStats().ResetCounters()
+	tr := accounting.GlobalStats().NewTransfer(src, nil)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	dst, err := multiThreadCopy(ctx, r.Flocal, "file1", src, 2, tr)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), accounting.GlobalStats().GetBytes())
+
+	fstest.CheckListingWithPrecision(t, r.Flocal, []fstest.Item{file1}, nil, fs.GetModifyWindow(ctx, r.Flocal, r.Fremote))
+	require.NoError(t, dst.Remove(ctx))
+}
+
+func TestMultithreadCopyResumeBadDestination(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx := context.Background()
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	size := multithreadChunkSize*2 + 1
+	contents := random.String(size)
+	file1 := r.WriteObject(ctx, "file1", contents, t1)
+	src, err := r.Fremote.NewObject(ctx, "file1")
+	require.NoError(t, err)
+
+	// Resume state saying more was written than the destination holds
+	r.WriteFile("file1", contents[:multithreadChunkSize], t1)
+	resume := newMultiThreadResume(ctx, r.Flocal, "file1", src)
+	resume.info.Done.Insert(ranges.Range{Pos: 0, Size: 2 * multithreadChunkSize})
+	resume.save(ctx)
+
+	// Should ignore the resume state
+	resume = newMultiThreadResume(ctx, r.Flocal, "file1", src)
+	assert.Equal(t, int64(0), resume.done().Size())
+
+	accounting.GlobalStats().ResetCounters()
+	tr := accounting.GlobalStats().NewTransfer(src, nil)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	dst, err := multiThreadCopy(ctx, r.Flocal, "file1", src, 2, tr)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), accounting.GlobalStats().GetBytes())
+
+	fstest.CheckListingWithPrecision(t, r.Flocal, []fstest.Item{file1}, nil, fs.GetModifyWindow(ctx, r.Flocal, r.Fremote))
+	require.NoError(t, dst.Remove(ctx))
+}
+
+// failOpenObject is an fs.Object which fails to open ranges starting
+// before failBefore
+type failOpenObject struct {
+	fs.Object
+	failBefore int64
+}
+
+func (o failOpenObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	for _, option := range options {
+		if rng, ok := option.(*fs.RangeOption); ok && rng.Start < o.failBefore {
+			return nil, errors.New("open failed")
+		}
+	}
+	return o.Object.Open(ctx, options...)
+}
+
+func TestMultithreadCopyInterrupted(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.LowLevelRetries = 1
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	size := multithreadChunkSize*4 + 17
+	r.WriteObject(ctx, "file1", random.String(size), t1)
+	obj, err := r.Fremote.NewObject(ctx, "file1")
+	require.NoError(t, err)
+	src := failOpenObject{Object: obj, failBefore: multithreadChunkSize}
+
+	// An existing larger destination
+	r.WriteFile("file1", random.String(size+100), t1)
+
+	accounting.GlobalStats().ResetCounters()
+	tr := accounting.GlobalStats().NewTransfer(src, nil)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	_, err = multiThreadCopy(ctx, r.Flocal, "file1", src, 2, tr)
+	require.Error(t, err)
+
+	// The destination must not look complete
+	fi, err := os.Stat(r.Flocal.Root() + "/file1")
+	require.NoError(t, err)
+	assert.Less(t, fi.Size(), int64(size))
+
+	// Resuming should complete the copy
+	dst, err := multiThreadCopy(ctx, r.Flocal, "file1", obj, 2, tr)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), dst.Size())
+	require.NoError(t, dst.Remove(ctx))
+}
+
+// chunkWriter is a mock fs.ChunkWriter which assembles the chunks in memory
+type chunkWriter struct {
+	mu      sync.Mutex
+	f       *mockfs.Fs
+	remote  string
+	chunks  map[int][]byte
+	failAt  int
+	closed  bool
+	aborted bool
+}
+
+func (w *chunkWriter) WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (int64, error) {
+	if chunkNumber == w.failAt {
+		return 0, errors.New("chunk write failed")
+	}
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	w.chunks[chunkNumber] = buf
+	w.mu.Unlock()
+	return int64(len(buf)), nil
+}
+
+func (w *chunkWriter) Close(ctx context.Context) error {
+	var out []byte
+	for i := 0; i < len(w.chunks); i++ {
+		out = append(out, w.chunks[i]...)
+	}
+	w.f.AddObject(mockobject.New(w.remote).WithContent(out, mockobject.SeekModeNone))
+	w.closed = true
+	return nil
+}
+
+func (w *chunkWriter) Abort(ctx context.Context) error {
+	w.aborted = true
+	return nil
+}
+
+func TestMultithreadCopyChunks(t *testing.T) {
+	ctx := context.Background()
+	const chunkSize = 1024
+	contents := []byte(random.String(chunkSize*3 + 17))
+	srcFs := mockfs.NewFs(ctx, "sausage", "")
+	src := mockobject.New("file.txt").WithContent(contents, mockobject.SeekModeNone)
+	src.SetFs(srcFs)
+
+	for _, failAt := range []int{-1, 2} {
+		t.Run(fmt.Sprintf("failAt=%d", failAt), func(t *testing.T) {
+			f := mockfs.NewFs(ctx, "potato", "")
+			w := &chunkWriter{
+				f:      f,
+				remote: "file.txt",
+				chunks: map[int][]byte{},
+				failAt: failAt,
+			}
+			var gotOptions []fs.OpenOption
+			f.Features().OpenChunkWriter = func(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (fs.ChunkWriterInfo, fs.ChunkWriter, error) {
+				gotOptions = options
+				return fs.ChunkWriterInfo{ChunkSize: chunkSize}, w, nil
+			}
+			header := &fs.HTTPOption{Key: "X-Potato", Value: "sausage"}
+
+			var err error
+			tr := accounting.GlobalStats().NewTransfer(src, nil)
+			defer func() {
+				tr.Done(ctx, err)
+			}()
+			dst, err := multiThreadCopy(ctx, f, "file.txt", src, 2, tr, header)
+			assert.Equal(t, []fs.OpenOption{header}, gotOptions)
+			if failAt >= 0 {
+				require.Error(t, err)
+				assert.False(t, w.closed)
+				assert.True(t, w.aborted)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, w.closed)
+			assert.False(t, w.aborted)
+			assert.Equal(t, 4, len(w.chunks))
+			assert.Equal(t, int64(len(contents)), dst.Size())
+			in, err := dst.Open(ctx)
+			require.NoError(t, err)
+			got, err := io.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.Equal(t, contents, got)
+		})
+	}
+}
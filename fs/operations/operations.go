@@ -30,6 +30,7 @@ import (
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/fspath"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/walk"
@@ -276,6 +277,8 @@ func equal(ctx context.Context, src fs.ObjectInfo, dst fs.Object, opt equalOpt)
 			} else {
 				fs.Infof(src, "Updated modification time in destination")
 			}
+		} else {
+			GetPlan(ctx).addObject(ctx, PlanSetTime, src, dst.Fs(), dst.Remote(), dst)
 		}
 	}
 	return true
@@ -314,6 +317,20 @@ func CommonHash(ctx context.Context, fa, fb fs.Info) (hash.Type, *fs.HashesOptio
 	return hashType, &fs.HashesOption{Hashes: common}
 }
 
+// uploadOptions returns the options to pass to the destination when
+// uploading with hashOption
+func uploadOptions(ctx context.Context, hashOption *fs.HashesOption) []fs.OpenOption {
+	ci := fs.GetConfig(ctx)
+	options := []fs.OpenOption{hashOption}
+	for _, option := range ci.UploadHeaders {
+		options = append(options, option)
+	}
+	if ci.MetadataSet != nil {
+		options = append(options, fs.MetadataOption(ci.MetadataSet))
+	}
+	return options
+}
+
 // Copy src object to dst or f if nil.  If dst is nil then it uses
 // remote as the name of the new object.
 //
@@ -321,23 +338,30 @@ func CommonHash(ctx context.Context, fa, fb fs.Info) (hash.Type, *fs.HashesOptio
 // be nil.
 func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Object, err error) {
 	ci := fs.GetConfig(ctx)
-	tr := accounting.Stats(ctx).NewTransfer(src)
+	tr := accounting.Stats(ctx).NewTransfer(src, f)
 	defer func() {
 		tr.Done(ctx, err)
 	}()
 	newDst = dst
 	if SkipDestructive(ctx, src, "copy") {
+		GetPlan(ctx).addObject(ctx, PlanCopy, src, f, remote, dst)
 		in := tr.Account(ctx, nil)
 		in.DryRun(src.Size())
 		return newDst, nil
 	}
+	err = tr.CheckQuotas()
+	if err != nil {
+		return nil, err
+	}
 	maxTries := ci.LowLevelRetries
 	tries := 0
 	doUpdate := dst != nil
 	hashType, hashOption := CommonHash(ctx, f, src.Fs())
+	verify := doPostVerify(ctx, src)
 
 	var actionTaken string
 	for {
+		var verifyIn *hashingReadCloser // hashes the source stream for --post-verify
 		// Try server-side copy first - if has optional interface and
 		// is same underlying remote
 		actionTaken = "Copied (server-side copy)"
@@ -373,8 +397,16 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 			err = fs.ErrorCantCopy
 		}
 		// If can't server-side copy, do it manually
-		if err == fs.ErrorCantCopy {
-			if doMultiThreadCopy(ctx, f, src) {
+		manualCopy := err == fs.ErrorCantCopy
+		if manualCopy {
+			if doDeltaCopy(ctx, dst, src) {
+				var deltaDst fs.Object
+				deltaDst, err = deltaCopy(ctx, f, remote, dst, src, tr)
+				if err == nil {
+					dst, newDst = deltaDst, deltaDst
+				}
+				actionTaken = "Delta Copied (replaced existing)"
+			} else if doMultiThreadCopy(ctx, f, src) {
 				// Number of streams proportional to size
 				streams := src.Size() / int64(ci.MultiThreadCutoff)
 				// With maximum
@@ -384,7 +416,7 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 				if streams < 2 {
 					streams = 2
 				}
-				dst, err = multiThreadCopy(ctx, f, remote, src, int(streams), tr)
+				dst, err = multiThreadCopy(ctx, f, remote, src, int(streams), tr, uploadOptions(ctx, hashOption)...)
 				if doUpdate {
 					actionTaken = "Multi-thread Copied (replaced existing)"
 				} else {
@@ -400,6 +432,10 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 				if err != nil {
 					err = fmt.Errorf("failed to open source object: %w", err)
 				} else {
+					if verify {
+						verifyIn = newHashingReadCloser(in0)
+						in0 = verifyIn
+					}
 					if src.Size() == -1 {
 						// -1 indicates unknown size. Use Rcat to handle both remotes supporting and not supporting PutStream.
 						if doUpdate {
@@ -425,13 +461,7 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 						if src.Remote() != remote {
 							wrappedSrc = fs.NewOverrideRemote(src, remote)
 						}
-						options := []fs.OpenOption{hashOption}
-						for _, option := range ci.UploadHeaders {
-							options = append(options, option)
-						}
-						if ci.MetadataSet != nil {
-							options = append(options, fs.MetadataOption(ci.MetadataSet))
-						}
+						options := uploadOptions(ctx, hashOption)
 						if doUpdate {
 							actionTaken = "Copied (replaced existing)"
 							err = dst.Update(ctx, in, wrappedSrc, options...)
@@ -448,6 +478,31 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 				}
 			}
 		}
+		// Read the destination back to check it if --post-verify
+		if verify && manualCopy && err == nil {
+			var srcSum string
+			if verifyIn != nil {
+				srcSum = verifyIn.Sum()
+			}
+			if v := getPostVerifier(ctx); v != nil {
+				// Check it in the background
+				v.add(f, remote, src, dst, srcSum)
+			} else {
+				err = postVerify(ctx, src, dst, srcSum)
+				if err != nil {
+					// Don't make a duplicate when retrying on
+					// remotes which allow duplicate names
+					if src.Size() < 0 {
+						// Rcat can't update so remove the bad copy
+						if removeFailedCopy(ctx, dst) {
+							dst, newDst = nil, nil
+						}
+					} else {
+						doUpdate = true
+					}
+				}
+			}
+		}
 		tries++
 		if tries >= maxTries {
 			break
@@ -475,6 +530,9 @@ func Copy(ctx context.Context, f fs.Fs, dst fs.Object, remote string, src fs.Obj
 	if err != nil {
 		err = fs.CountError(err)
 		fs.Errorf(src, "Failed to copy: %v", err)
+		if errors.Is(err, errPostVerify) {
+			removeFailedCopy(ctx, dst)
+		}
 		return newDst, err
 	}
 
@@ -552,6 +610,7 @@ func Move(ctx context.Context, fdst fs.Fs, dst fs.Object, remote string, src fs.
 	}()
 	newDst = dst
 	if SkipDestructive(ctx, src, "move") {
+		GetPlan(ctx).addObject(ctx, PlanMove, src, fdst, remote, dst)
 		in := tr.Account(ctx, nil)
 		in.DryRun(src.Size())
 		return newDst, nil
@@ -590,7 +649,10 @@ func Move(ctx context.Context, fdst fs.Fs, dst fs.Object, remote string, src fs.
 		}
 	}
 	// Move not found or didn't work so copy dst <- src
-	newDst, err = Copy(ctx, fdst, dst, remote, src)
+	//
+	// Any --post-verify must be done before deleting src so isn't
+	// done in the background
+	newDst, err = Copy(withoutPostVerifier(ctx), fdst, dst, remote, src)
 	if err != nil {
 		fs.Errorf(src, "Not deleting source as copy failed: %v", err)
 		return newDst, err
@@ -660,7 +722,15 @@ func DeleteFileWithBackupDir(ctx context.Context, dst fs.Object, backupDir fs.Fs
 	}
 	skip := SkipDestructive(ctx, dst, action)
 	if skip {
-		// do nothing
+		if backupDir == nil {
+			GetPlan(ctx).addDelete(ctx, dst)
+		} else {
+			if plan := GetPlan(ctx); plan != nil {
+				remote := SuffixName(ctx, dst.Remote())
+				backupDst, _ := backupDir.NewObject(ctx, remote)
+				plan.addObject(ctx, PlanMove, dst, backupDir, remote, backupDst)
+			}
+		}
 	} else if backupDir != nil {
 		err = MoveBackupDir(ctx, backupDir, dst)
 	} else {
@@ -973,7 +1043,7 @@ func hashSum(ctx context.Context, ht hash.Type, base64Encoded bool, downloadFlag
 		// Setup: Define accounting, open the file with NewReOpen to provide restarts, account for the transfer, and setup a multi-hasher with the appropriate type
 		// Execution: io.Copy file to hasher, get hash and encode in hex
 
-		tr := accounting.Stats(ctx).NewTransfer(o)
+		tr := accounting.Stats(ctx).NewTransfer(o, nil)
 		defer func() {
 			tr.Done(ctx, err)
 		}()
@@ -1126,6 +1196,7 @@ func ListDir(ctx context.Context, f fs.Fs, w io.Writer) error {
 // Mkdir makes a destination directory or container
 func Mkdir(ctx context.Context, f fs.Fs, dir string) error {
 	if SkipDestructive(ctx, fs.LogDirName(f, dir), "make directory") {
+		GetPlan(ctx).addDir(PlanMkdir, f, dir)
 		return nil
 	}
 	fs.Debugf(fs.LogDirName(f, dir), "Making directory")
@@ -1142,6 +1213,7 @@ func Mkdir(ctx context.Context, f fs.Fs, dir string) error {
 func TryRmdir(ctx context.Context, f fs.Fs, dir string) error {
 	accounting.Stats(ctx).DeletedDirs(1)
 	if SkipDestructive(ctx, fs.LogDirName(f, dir), "remove directory") {
+		GetPlan(ctx).addDir(PlanRmdir, f, dir)
 		return nil
 	}
 	fs.Infof(fs.LogDirName(f, dir), "Removing directory")
@@ -1264,7 +1336,7 @@ func Cat(ctx context.Context, f fs.Fs, w io.Writer, offset, count int64, sep []b
 	ci := fs.GetConfig(ctx)
 	return ListFn(ctx, f, func(o fs.Object) {
 		var err error
-		tr := accounting.Stats(ctx).NewTransfer(o)
+		tr := accounting.Stats(ctx).NewTransfer(o, nil)
 		defer func() {
 			tr.Done(ctx, err)
 		}()
@@ -1314,11 +1386,34 @@ func Cat(ctx context.Context, f fs.Fs, w io.Writer, offset, count int64, sep []b
 // Rcat reads data from the Reader until EOF and uploads it to a file on remote
 func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser, modTime time.Time, meta fs.Metadata) (dst fs.Object, err error) {
 	ci := fs.GetConfig(ctx)
-	tr := accounting.Stats(ctx).NewTransferRemoteSize(dstFileName, -1)
+	// If the data is spooled to a local file then Copy counts the
+	// upload against the quota of fdst
+	canStream := fdst.Features().PutStream != nil
+	var quotaFs fs.Info
+	if canStream {
+		quotaFs = fdst
+	}
+	tr := accounting.Stats(ctx).NewTransferRemoteSize(dstFileName, -1, nil, quotaFs)
 	defer func() {
 		tr.Done(ctx, err)
 	}()
-	in = tr.Account(ctx, in).WithBuffer()
+
+	// check if file small enough for direct upload
+	//
+	// This reads from in before it is accounted as Copy accounts
+	// the upload of small files.
+	buf := make([]byte, ci.StreamingUploadCutoff)
+	if n, err := io.ReadFull(in, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		fs.Debugf(fdst, "File to upload is small (%d bytes), uploading instead of streaming", n)
+		src := object.NewMemoryObject(dstFileName, modTime, buf[:n]).WithMetadata(meta)
+		return Copy(ctx, fdst, nil, dstFileName, src)
+	}
+
+	// Make a new ReadCloser with the bits we've already read
+	in = tr.Account(ctx, &readCloser{
+		Reader: io.MultiReader(bytes.NewReader(buf), in),
+		Closer: in,
+	}).WithBuffer()
 
 	readCounter := readers.NewCountingReader(in)
 	var trackingIn io.Reader
@@ -1361,22 +1456,13 @@ func Rcat(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadCloser,
 		return nil
 	}
 
-	// check if file small enough for direct upload
-	buf := make([]byte, ci.StreamingUploadCutoff)
-	if n, err := io.ReadFull(trackingIn, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
-		fs.Debugf(fdst, "File to upload is small (%d bytes), uploading instead of streaming", n)
-		src := object.NewMemoryObject(dstFileName, modTime, buf[:n]).WithMetadata(meta)
-		return Copy(ctx, fdst, nil, dstFileName, src)
-	}
-
-	// Make a new ReadCloser with the bits we've already read
+	// Read through the hasher from now on
 	in = &readCloser{
-		Reader: io.MultiReader(bytes.NewReader(buf), trackingIn),
+		Reader: trackingIn,
 		Closer: in,
 	}
 
 	fStreamTo := fdst
-	canStream := fdst.Features().PutStream != nil
 	if !canStream {
 		fs.Debugf(fdst, "Target remote doesn't support streaming uploads, creating temporary local FS to spool file")
 		tmpLocalFs, err := fs.TemporaryLocalFs(ctx)
@@ -1710,7 +1796,7 @@ func RcatSize(ctx context.Context, fdst fs.Fs, dstFileName string, in io.ReadClo
 	if size >= 0 {
 		var err error
 		// Size known use Put
-		tr := accounting.Stats(ctx).NewTransferRemoteSize(dstFileName, size)
+		tr := accounting.Stats(ctx).NewTransferRemoteSize(dstFileName, size, nil, fdst)
 		defer func() {
 			tr.Done(ctx, err)
 		}()
@@ -1808,6 +1894,9 @@ func CopyURLToWriter(ctx context.Context, url string, out io.Writer) (err error)
 // BackupDir returns the correctly configured --backup-dir
 func BackupDir(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, srcFileName string) (backupDir fs.Fs, err error) {
 	ci := fs.GetConfig(ctx)
+	if ci.BackupSnapshot && ci.BackupDir == "" {
+		return nil, fserrors.FatalError(errors.New("--backup-snapshot needs --backup-dir to be set"))
+	}
 	if ci.BackupDir != "" {
 		backupDir, err = cache.Get(ctx, ci.BackupDir)
 		if err != nil {
@@ -1842,6 +1931,15 @@ func BackupDir(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, srcFileName string)
 	if !CanServerSideMove(backupDir) {
 		return nil, fserrors.FatalError(errors.New("can't use --backup-dir on a remote which doesn't support server-side move or copy"))
 	}
+	if ci.BackupSnapshot {
+		// Put the backups in a new snapshot directory under --backup-dir
+		snapshotDir := fspath.JoinRootPath(ci.BackupDir, getSnapshotName(ctx))
+		backupDir, err = cache.Get(ctx, snapshotDir)
+		if err != nil {
+			return nil, fserrors.FatalError(fmt.Errorf("failed to make fs for snapshot %q: %w", snapshotDir, err))
+		}
+		fs.Infof(backupDir, "Making backups into snapshot")
+	}
 	return backupDir, nil
 }
 
@@ -1855,6 +1953,8 @@ func MoveBackupDir(ctx context.Context, backupDir fs.Fs, dst fs.Object) (err err
 
 // moveOrCopyFile moves or copies a single file possibly to a new name
 func moveOrCopyFile(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, dstFileName string, srcFileName string, cp bool) (err error) {
+	ctx, finishPlan := StartPlan(ctx)
+	defer finishPlan(&err)
 	ci := fs.GetConfig(ctx)
 	dstFilePath := path.Join(fdst.Root(), dstFileName)
 	srcFilePath := path.Join(fsrc.Root(), srcFileName)
@@ -1900,7 +2000,7 @@ func moveOrCopyFile(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, dstFileName str
 			}
 			return fmt.Errorf("error while attempting to move file to a temporary location: %w", err)
 		}
-		tr := accounting.Stats(ctx).NewTransfer(srcObj)
+		tr := accounting.Stats(ctx).NewTransfer(srcObj, nil)
 		defer func() {
 			tr.Done(ctx, err)
 		}()
@@ -1914,7 +2014,7 @@ func moveOrCopyFile(ctx context.Context, fdst fs.Fs, fsrc fs.Fs, dstFileName str
 
 	var backupDir fs.Fs
 	var copyDestDir []fs.Fs
-	if ci.BackupDir != "" || ci.Suffix != "" {
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupSnapshot {
 		backupDir, err = BackupDir(ctx, fdst, fsrc, srcFileName)
 		if err != nil {
 			return fmt.Errorf("creating Fs for --backup-dir failed: %w", err)
@@ -2367,6 +2467,9 @@ func SkipDestructive(ctx context.Context, subject interface{}, action string) (s
 	switch {
 	case ci.DryRun:
 		flag = "--dry-run"
+		if GetPlan(ctx) != nil {
+			flag = "--plan-out"
+		}
 		skip = true
 	case ci.Interactive:
 		flag = "--interactive"
@@ -37,6 +37,7 @@ import (
 	_ "github.com/rclone/rclone/backend/all" // import all backends
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
@@ -1717,6 +1718,37 @@ func TestRcatMetadata(t *testing.T) {
 	})
 }
 
+func TestRcatQuota(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.StreamingUploadCutoff = 10
+	config.FileSet("rcatquota", "type", "local")
+	config.FileSet("rcatquota", "quota_upload_daily", "100B")
+	defer config.LoadedData().DeleteSection("rcatquota")
+	f, err := fs.NewFs(ctx, "rcatquota:"+t.TempDir())
+	require.NoError(t, err)
+	q := accounting.GetQuota(ctx, f, accounting.QuotaUpload)
+	require.NotNil(t, q)
+
+	// Small files are uploaded with Copy and big ones streamed but
+	// each is only counted once
+	_, err = operations.Rcat(ctx, f, "small", io.NopCloser(strings.NewReader("12345")), t1, nil)
+	require.NoError(t, err)
+	_, err = operations.Rcat(ctx, f, "big", io.NopCloser(strings.NewReader(strings.Repeat("x", 90))), t1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"daily": 95}, q.Used())
+
+	// Going over the quota stops the upload
+	_, err = operations.Rcat(ctx, f, "over", io.NopCloser(strings.NewReader(strings.Repeat("x", 20))), t1, nil)
+	assert.ErrorIs(t, err, accounting.ErrorQuotaExceeded)
+
+	// Then nothing more can be uploaded
+	_, err = operations.Rcat(ctx, f, "small2", io.NopCloser(strings.NewReader("12345")), t1, nil)
+	assert.ErrorIs(t, err, accounting.ErrorQuotaExceeded)
+	_, err = operations.Rcat(ctx, f, "big2", io.NopCloser(strings.NewReader(strings.Repeat("x", 20))), t1, nil)
+	assert.ErrorIs(t, err, accounting.ErrorQuotaExceeded)
+}
+
 func TestRcatSize(t *testing.T) {
 	ctx := context.Background()
 	r := fstest.NewRun(t)
@@ -0,0 +1,390 @@
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"golang.org/x/sync/errgroup"
+)
+
+// Actions which can be found in a Plan
+const (
+	PlanCopy    = "copy"    // copy Src to Dst
+	PlanMove    = "move"    // move Src to Dst
+	PlanDelete  = "delete"  // delete Dst
+	PlanMkdir   = "mkdir"   // make directory Dst
+	PlanRmdir   = "rmdir"   // remove directory Dst
+	PlanSetTime = "settime" // set the modification time of Dst to that of Src
+)
+
+// planVersion is the version of the plan file format
+const planVersion = 1
+
+// PlanAction is a single action in a Plan
+type PlanAction struct {
+	Action      string // one of the Plan* constants
+	SrcFs       string `json:",omitempty"` // Fs of the source, if any
+	Src         string `json:",omitempty"` // path of the source in SrcFs
+	DstFs       string // Fs of the destination
+	Dst         string `json:",omitempty"` // path of the destination in DstFs
+	Size        int64  `json:",omitempty"` // size of the object copied, moved or deleted
+	Fingerprint string `json:",omitempty"` // fingerprint of the source or of the object being deleted
+
+	// DstFingerprint is the fingerprint of the object at Dst which a
+	// copy, move or settime replaces or "" if there wasn't one
+	DstFingerprint string `json:",omitempty"`
+}
+
+// Plan is a list of actions recorded by a sync, copy or move with
+// --plan-out which can be carried out later with ApplyPlan.
+type Plan struct {
+	mu      sync.Mutex
+	err     error // set if the plan can't be applied later
+	Version int
+	Actions []PlanAction
+}
+
+type planKey struct{}
+
+// NewPlan returns a context which records the actions which would be
+// taken into a new Plan instead of doing them.
+func NewPlan(ctx context.Context) (context.Context, *Plan) {
+	plan := &Plan{Version: planVersion}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	return context.WithValue(ctx, planKey{}, plan), plan
+}
+
+// GetPlan returns the Plan being recorded in ctx or nil if none
+func GetPlan(ctx context.Context) *Plan {
+	plan, _ := ctx.Value(planKey{}).(*Plan)
+	return plan
+}
+
+// StartPlan starts recording a Plan if --plan-out is set and a Plan
+// isn't being recorded already.
+//
+// The returned function should be deferred with a pointer to the
+// error result of the operation. It writes the plan to the --plan-out
+// file if the operation succeeded.
+func StartPlan(ctx context.Context) (context.Context, func(perr *error)) {
+	ci := fs.GetConfig(ctx)
+	if ci.PlanOut == "" || GetPlan(ctx) != nil {
+		return ctx, func(*error) {}
+	}
+	ctx, plan := NewPlan(ctx)
+	return ctx, func(perr *error) {
+		if *perr != nil {
+			fs.Errorf(nil, "Not writing plan to %q as there were errors", ci.PlanOut)
+			return
+		}
+		*perr = plan.Save(ci.PlanOut)
+	}
+}
+
+// planFs returns a string which can be used to make f again
+//
+// Remotes made with a connection string or overridden config have
+// "{hash}" added to their names and can't be made again from it in a
+// different process, so this records an error in the plan for them.
+func (p *Plan) planFs(f fs.Info) string {
+	if strings.ContainsRune(f.Name(), '{') {
+		p.mu.Lock()
+		if p.err == nil {
+			p.err = fmt.Errorf("can't record %q in a plan as it uses a connection string or overridden config - put it in the config file instead", f.Name())
+		}
+		p.mu.Unlock()
+	}
+	if do, ok := f.(fs.Fs); ok {
+		return fs.ConfigString(do)
+	}
+	return f.Name() + ":" + f.Root()
+}
+
+// add records action in the plan
+func (p *Plan) add(action PlanAction) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.Actions = append(p.Actions, action)
+	p.mu.Unlock()
+}
+
+// addObject records an action on src with its destination in the
+// plan. dst is the object being replaced at the destination, if any.
+func (p *Plan) addObject(ctx context.Context, action string, src fs.ObjectInfo, fdst fs.Info, remote string, dst fs.Object) {
+	if p == nil {
+		return
+	}
+	var dstFingerprint string
+	if dst != nil {
+		dstFingerprint = fs.Fingerprint(ctx, dst, true)
+	}
+	p.add(PlanAction{
+		Action:         action,
+		SrcFs:          p.planFs(src.Fs()),
+		Src:            src.Remote(),
+		DstFs:          p.planFs(fdst),
+		Dst:            remote,
+		Size:           src.Size(),
+		Fingerprint:    fs.Fingerprint(ctx, src, true),
+		DstFingerprint: dstFingerprint,
+	})
+}
+
+// addDelete records the deletion of dst in the plan
+func (p *Plan) addDelete(ctx context.Context, dst fs.Object) {
+	if p == nil {
+		return
+	}
+	p.add(PlanAction{
+		Action:      PlanDelete,
+		DstFs:       p.planFs(dst.Fs()),
+		Dst:         dst.Remote(),
+		Size:        dst.Size(),
+		Fingerprint: fs.Fingerprint(ctx, dst, true),
+	})
+}
+
+// addDir records action on directory dir of f in the plan
+func (p *Plan) addDir(action string, f fs.Fs, dir string) {
+	if p == nil {
+		return
+	}
+	p.add(PlanAction{
+		Action: action,
+		DstFs:  p.planFs(f),
+		Dst:    dir,
+	})
+}
+
+// Save writes the plan as JSON to the file at path
+func (p *Plan) Save(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	data, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	err = os.WriteFile(path, append(data, '\n'), 0666)
+	if err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	fs.Logf(nil, "Wrote plan with %d actions to %q", len(p.Actions), path)
+	return nil
+}
+
+// LoadPlan reads a plan written by Save from the file at path
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	p := new(Plan)
+	err = json.Unmarshal(data, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+	if p.Version != planVersion {
+		return nil, fmt.Errorf("unsupported plan version %d", p.Version)
+	}
+	return p, nil
+}
+
+// planApplier carries out a Plan
+type planApplier struct {
+	ctx      context.Context
+	movedOut map[string]bool // objects moved away by the plan keyed by fs string and path
+}
+
+// getFs returns the Fs for fsString
+func (a *planApplier) getFs(fsString string) (fs.Fs, error) {
+	f, err := cache.Get(a.ctx, fsString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make fs for %q: %w", fsString, err)
+	}
+	return f, nil
+}
+
+// getObject returns the object at remote in the Fs for fsString
+func (a *planApplier) getObject(fsString, remote string) (fs.Object, error) {
+	f, err := a.getFs(fsString)
+	if err != nil {
+		return nil, err
+	}
+	return f.NewObject(a.ctx, remote)
+}
+
+// check returns an error if the objects the action was planned with
+// have changed since
+func (a *planApplier) check(action *PlanAction) error {
+	if action.Fingerprint == "" {
+		return nil
+	}
+	fsString, remote := action.SrcFs, action.Src
+	if action.Action == PlanDelete {
+		fsString, remote = action.DstFs, action.Dst
+	}
+	o, err := a.getObject(fsString, remote)
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", action.Action, remote, err)
+	}
+	if fs.Fingerprint(a.ctx, o, true) != action.Fingerprint {
+		return fmt.Errorf("%s %q: object has changed since the plan was made", action.Action, remote)
+	}
+	if action.Action == PlanDelete {
+		return nil
+	}
+	// Check the destination hasn't changed either
+	dst, err := a.getObject(action.DstFs, action.Dst)
+	switch {
+	case err == fs.ErrorObjectNotFound:
+		if action.DstFingerprint != "" {
+			return fmt.Errorf("%s %q: destination %q has been removed since the plan was made", action.Action, remote, action.Dst)
+		}
+	case err != nil:
+		return fmt.Errorf("%s %q: destination %q: %w", action.Action, remote, action.Dst, err)
+	case action.DstFingerprint == "":
+		// The plan may move the destination out of the way first,
+		// e.g. into --backup-dir
+		if !a.movedOut[action.DstFs+"\x00"+action.Dst] {
+			return fmt.Errorf("%s %q: destination %q has been created since the plan was made", action.Action, remote, action.Dst)
+		}
+	case fs.Fingerprint(a.ctx, dst, true) != action.DstFingerprint:
+		return fmt.Errorf("%s %q: destination %q has changed since the plan was made", action.Action, remote, action.Dst)
+	}
+	return nil
+}
+
+// do carries out a single action
+func (a *planApplier) do(action *PlanAction) (err error) {
+	fdst, err := a.getFs(action.DstFs)
+	if err != nil {
+		return err
+	}
+	switch action.Action {
+	case PlanMkdir:
+		return Mkdir(a.ctx, fdst, action.Dst)
+	case PlanRmdir:
+		return Rmdir(a.ctx, fdst, action.Dst)
+	case PlanDelete:
+		dst, err := fdst.NewObject(a.ctx, action.Dst)
+		if err != nil {
+			return err
+		}
+		return DeleteFile(a.ctx, dst)
+	}
+	src, err := a.getObject(action.SrcFs, action.Src)
+	if err != nil {
+		return err
+	}
+	dst, err := fdst.NewObject(a.ctx, action.Dst)
+	if err == fs.ErrorObjectNotFound {
+		dst = nil
+	} else if err != nil {
+		return err
+	}
+	switch action.Action {
+	case PlanCopy:
+		_, err = Copy(a.ctx, fdst, dst, action.Dst, src)
+	case PlanMove:
+		_, err = Move(a.ctx, fdst, dst, action.Dst, src)
+	case PlanSetTime:
+		if dst == nil {
+			return fs.ErrorObjectNotFound
+		}
+		err = dst.SetModTime(a.ctx, src.ModTime(a.ctx))
+		if err == fs.ErrorCantSetModTime || err == fs.ErrorCantSetModTimeWithoutDelete {
+			_, err = Copy(a.ctx, fdst, dst, action.Dst, src)
+		}
+	default:
+		err = fmt.Errorf("unknown plan action %q", action.Action)
+	}
+	return err
+}
+
+// ApplyPlan carries out the actions in plan.
+//
+// Before doing anything it checks that none of the objects the plan
+// acts on, including the destinations of copies and moves, have
+// changed since the plan was made and refuses to continue if they
+// have.
+//
+// The directories are made first, then the files are moved, then the
+// files are copied using --transfers threads, then the files are
+// deleted and finally the directories are removed.
+func ApplyPlan(ctx context.Context, plan *Plan) error {
+	ci := fs.GetConfig(ctx)
+	a := &planApplier{
+		ctx:      ctx,
+		movedOut: make(map[string]bool),
+	}
+	for _, action := range plan.Actions {
+		if action.Action == PlanMove {
+			a.movedOut[action.SrcFs+"\x00"+action.Src] = true
+		}
+	}
+
+	changed := 0
+	for i := range plan.Actions {
+		if err := a.check(&plan.Actions[i]); err != nil {
+			fs.Errorf(nil, "%v", err)
+			changed++
+		}
+	}
+	if changed > 0 {
+		return fmt.Errorf("refusing to apply plan as %d objects have changed since it was made", changed)
+	}
+
+	var (
+		errMu   sync.Mutex
+		lastErr error
+	)
+	run := func(phase []string, threads int) {
+		g, _ := errgroup.WithContext(ctx)
+		g.SetLimit(threads)
+		for i := range plan.Actions {
+			action := &plan.Actions[i]
+			found := false
+			for _, name := range phase {
+				found = found || action.Action == name
+			}
+			if !found {
+				continue
+			}
+			g.Go(func() error {
+				err := a.do(action)
+				if err != nil {
+					err = fs.CountError(err)
+					fs.Errorf(action.Dst, "Failed to %s: %v", action.Action, err)
+					errMu.Lock()
+					lastErr = err
+					errMu.Unlock()
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+	}
+	run([]string{PlanMkdir}, 1)
+	// Moves go first as they include moving files into --backup-dir
+	// before they are overwritten
+	run([]string{PlanMove}, ci.Transfers)
+	run([]string{PlanCopy, PlanSetTime}, ci.Transfers)
+	run([]string{PlanDelete}, ci.Checkers)
+	run([]string{PlanRmdir}, 1)
+	if lastErr != nil {
+		return fmt.Errorf("failed to apply all of the plan: %w", lastErr)
+	}
+	return nil
+}
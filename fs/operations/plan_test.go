@@ -0,0 +1,115 @@
+package operations_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCopyFile(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+
+	file1 := r.WriteFile("file1", "file1 contents", t1)
+	r.CheckLocalItems(t, file1)
+
+	// Making the plan shouldn't copy anything
+	err := operations.CopyFile(ctx, r.Fremote, r.Flocal, "sub/file2", "file1")
+	require.NoError(t, err)
+	r.CheckRemoteItems(t)
+
+	plan, err := operations.LoadPlan(ci.PlanOut)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(plan.Actions))
+	action := plan.Actions[0]
+	assert.Equal(t, operations.PlanCopy, action.Action)
+	assert.Equal(t, "file1", action.Src)
+	assert.Equal(t, "sub/file2", action.Dst)
+	assert.Equal(t, file1.Size, action.Size)
+	assert.NotEqual(t, "", action.Fingerprint)
+
+	// Applying the plan should do the copy
+	ci.PlanOut = ""
+	require.NoError(t, operations.ApplyPlan(ctx, plan))
+	file2 := file1
+	file2.Path = "sub/file2"
+	r.CheckLocalItems(t, file1)
+	r.CheckRemoteItems(t, file2)
+}
+
+func TestPlanSourceChanged(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	planOut := filepath.Join(t.TempDir(), "plan.json")
+	ci.PlanOut = planOut
+
+	r.WriteFile("file1", "file1 contents", t1)
+	err := operations.CopyFile(ctx, r.Fremote, r.Flocal, "file1", "file1")
+	require.NoError(t, err)
+	ci.PlanOut = ""
+
+	// Change the source after the plan was made
+	file1 := r.WriteFile("file1", "file1 contents changed", t2)
+
+	plan, err := operations.LoadPlan(planOut)
+	require.NoError(t, err)
+	err = operations.ApplyPlan(ctx, plan)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "changed since it was made")
+	r.CheckLocalItems(t, file1)
+	r.CheckRemoteItems(t)
+}
+
+func TestPlanDestinationChanged(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	planOut := filepath.Join(t.TempDir(), "plan.json")
+	ci.PlanOut = planOut
+
+	file1 := r.WriteFile("file1", "file1 contents", t1)
+	r.WriteObject(ctx, "file1", "old contents", t1)
+	err := operations.CopyFile(ctx, r.Fremote, r.Flocal, "file1", "file1")
+	require.NoError(t, err)
+	ci.PlanOut = ""
+
+	plan, err := operations.LoadPlan(planOut)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(plan.Actions))
+	assert.NotEqual(t, "", plan.Actions[0].DstFingerprint)
+
+	// Change the destination after the plan was made
+	file2 := r.WriteObject(ctx, "file1", "new contents written since", t2)
+
+	err = operations.ApplyPlan(ctx, plan)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "changed since it was made")
+	r.CheckLocalItems(t, file1)
+	r.CheckRemoteItems(t, file2)
+}
+
+func TestPlanOverriddenConfig(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+
+	r.WriteFile("file1", "file1 contents", t1)
+	fsrc, err := fs.NewFs(ctx, ":local,links=true:"+r.LocalName)
+	require.NoError(t, err)
+
+	// The source can't be made again from the plan
+	err = operations.CopyFile(ctx, r.Fremote, fsrc, "file1", "file1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection string or overridden config")
+	r.CheckRemoteItems(t)
+}
@@ -189,7 +189,7 @@ func rcMoveOrCopyFile(ctx context.Context, in rc.Params, cp bool) (out rc.Params
 	if err != nil {
 		return nil, err
 	}
-	return nil, moveOrCopyFile(ctx, dstFs, srcFs, dstRemote, srcRemote, cp)
+	return nil, moveOrCopyFile(NewSnapshotRun(ctx), dstFs, srcFs, dstRemote, srcRemote, cp)
 }
 
 func init() {
@@ -435,6 +435,7 @@ This returns info about the remote passed in;
                 "MergeDirs": false,
                 "MetadataInfo": true,
                 "Move": true,
+                "OpenChunkWriter": false,
                 "OpenWriterAt": true,
                 "PublicLink": false,
                 "Purge": true,
@@ -0,0 +1,172 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// RenamesStrategy is a bitmask of the properties used to decide
+// whether two objects with different paths are the same file
+type RenamesStrategy byte
+
+// Strategies which may be combined in a RenamesStrategy
+const (
+	RenamesStrategyHash RenamesStrategy = 1 << iota
+	RenamesStrategyModtime
+	RenamesStrategyLeaf
+)
+
+// Hash returns true if the strategy matches on hash
+func (strategy RenamesStrategy) Hash() bool {
+	return (strategy & RenamesStrategyHash) != 0
+}
+
+// ModTime returns true if the strategy matches on modification time
+func (strategy RenamesStrategy) ModTime() bool {
+	return (strategy & RenamesStrategyModtime) != 0
+}
+
+// Leaf returns true if the strategy matches on the leaf name
+func (strategy RenamesStrategy) Leaf() bool {
+	return (strategy & RenamesStrategyLeaf) != 0
+}
+
+// ParseRenamesStrategy turns a config string such as "hash,modtime"
+// into a RenamesStrategy
+func ParseRenamesStrategy(strategies string) (strategy RenamesStrategy, err error) {
+	if len(strategies) == 0 {
+		return strategy, nil
+	}
+	for _, s := range strings.Split(strategies, ",") {
+		switch s {
+		case "hash":
+			strategy |= RenamesStrategyHash
+		case "modtime":
+			strategy |= RenamesStrategyModtime
+		case "leaf":
+			strategy |= RenamesStrategyLeaf
+		case "size":
+			// ignore
+		default:
+			return strategy, fmt.Errorf("unknown track renames strategy %q", s)
+		}
+	}
+	return strategy, nil
+}
+
+// RenameMap holds objects indexed by their rename ID so that objects
+// which have changed path can be found again.
+//
+// It is safe for concurrent use.
+type RenameMap struct {
+	ctx          context.Context
+	strategy     RenamesStrategy
+	hashType     hash.Type
+	modifyWindow time.Duration
+	mu           sync.Mutex
+	objects      map[string][]fs.Object
+}
+
+// NewRenameMap makes a RenameMap using strategy to match objects.
+//
+// hashType is the hash to use if the strategy includes hash and
+// modifyWindow is used to compare times if it includes modtime.
+func NewRenameMap(ctx context.Context, strategy RenamesStrategy, hashType hash.Type, modifyWindow time.Duration) *RenameMap {
+	return &RenameMap{
+		ctx:          ctx,
+		strategy:     strategy,
+		hashType:     hashType,
+		modifyWindow: modifyWindow,
+		objects:      make(map[string][]fs.Object),
+	}
+}
+
+// ID makes a string with the size and the other identifiers of the
+// requested rename strategies
+//
+// it may return an empty string in which case no hash could be made
+func (rm *RenameMap) ID(obj fs.Object) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "%d", obj.Size())
+
+	if rm.strategy.Hash() {
+		var err error
+		hash, err := obj.Hash(rm.ctx, rm.hashType)
+
+		if err != nil {
+			fs.Debugf(obj, "Hash failed: %v", err)
+			return ""
+		}
+		if hash == "" {
+			return ""
+		}
+
+		builder.WriteRune(',')
+		builder.WriteString(hash)
+	}
+
+	// for strategy.ModTime() we don't add to the hash but we check the times in
+	// Pop
+
+	if rm.strategy.Leaf() {
+		builder.WriteRune(',')
+		builder.WriteString(path.Base(obj.Remote()))
+	}
+
+	return builder.String()
+}
+
+// Push adds the object with id to the map
+func (rm *RenameMap) Push(id string, obj fs.Object) {
+	rm.mu.Lock()
+	rm.objects[id] = append(rm.objects[id], obj)
+	rm.mu.Unlock()
+}
+
+// Pop finds an object matching src with id and removes it from the
+// map, returning nil if not found.
+func (rm *RenameMap) Pop(id string, src fs.Object) (dst fs.Object) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	dsts, ok := rm.objects[id]
+	if ok && len(dsts) > 0 {
+		// Element to remove
+		i := 0
+
+		// If using track renames strategy modtime then we need to check the modtimes here
+		if rm.strategy.ModTime() {
+			i = -1
+			srcModTime := src.ModTime(rm.ctx)
+			for j, dst := range dsts {
+				dstModTime := dst.ModTime(rm.ctx)
+				dt := dstModTime.Sub(srcModTime)
+				if dt < rm.modifyWindow && dt > -rm.modifyWindow {
+					i = j
+					break
+				}
+			}
+			// If nothing matched then return nil
+			if i < 0 {
+				return nil
+			}
+		}
+
+		// Remove the entry and return it
+		dst = dsts[i]
+		dsts = append(dsts[:i], dsts[i+1:]...)
+		if len(dsts) > 0 {
+			rm.objects[id] = dsts
+		} else {
+			delete(rm.objects, id)
+		}
+	}
+	return dst
+}
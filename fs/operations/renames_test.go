@@ -0,0 +1,64 @@
+package operations_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRenamesStrategyModtime(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    operations.RenamesStrategy
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"modtime", operations.RenamesStrategyModtime, false},
+		{"hash", operations.RenamesStrategyHash, false},
+		{"size", 0, false},
+		{"modtime,hash", operations.RenamesStrategyModtime | operations.RenamesStrategyHash, false},
+		{"hash,modtime,size", operations.RenamesStrategyModtime | operations.RenamesStrategyHash, false},
+		{"size,boom", 0, true},
+	} {
+		got, err := operations.ParseRenamesStrategy(test.in)
+		assert.Equal(t, test.want, got, test.in)
+		assert.Equal(t, test.wantErr, err != nil, test.in)
+	}
+}
+
+func TestRenamesStrategyModtime(t *testing.T) {
+	both := operations.RenamesStrategyHash | operations.RenamesStrategyModtime
+	hash := operations.RenamesStrategyHash
+	modTime := operations.RenamesStrategyModtime
+
+	assert.True(t, both.Hash())
+	assert.True(t, both.ModTime())
+	assert.True(t, hash.Hash())
+	assert.False(t, hash.ModTime())
+	assert.False(t, modTime.Hash())
+	assert.True(t, modTime.ModTime())
+}
+
+func TestRenameMap(t *testing.T) {
+	ctx := context.Background()
+	rm := operations.NewRenameMap(ctx, operations.RenamesStrategyLeaf, hash.None, fs.ModTimeNotSupported)
+
+	potato := mockobject.New("dir/potato").WithContent([]byte("potato"), mockobject.SeekModeNone)
+	potato2 := mockobject.New("potato2").WithContent([]byte("potato"), mockobject.SeekModeNone)
+	moved := mockobject.New("newdir/potato").WithContent([]byte("potato"), mockobject.SeekModeNone)
+
+	assert.Equal(t, "6,potato", rm.ID(potato))
+	assert.Equal(t, "6,potato2", rm.ID(potato2))
+
+	rm.Push(rm.ID(potato), potato)
+	rm.Push(rm.ID(potato2), potato2)
+
+	assert.Nil(t, rm.Pop("6,carrot", moved))
+	assert.Equal(t, potato, rm.Pop(rm.ID(moved), moved))
+	assert.Nil(t, rm.Pop(rm.ID(moved), moved))
+}
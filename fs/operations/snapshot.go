@@ -0,0 +1,166 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// SnapshotTimeFormat is the format of the names of the snapshot
+// directories made under --backup-dir by --backup-snapshot
+const SnapshotTimeFormat = "2006-01-02-150405"
+
+// SnapshotName returns the name of the snapshot directory for t
+func SnapshotName(t time.Time) string {
+	return t.UTC().Format(SnapshotTimeFormat)
+}
+
+// snapshotNameMu protects BackupSnapshotName in the config
+var snapshotNameMu sync.Mutex
+
+// getSnapshotName returns the name of the snapshot directory for this
+// run, choosing it the first time it is needed so that retries of the
+// run put their backups in the same snapshot.
+func getSnapshotName(ctx context.Context) string {
+	ci := fs.GetConfig(ctx)
+	snapshotNameMu.Lock()
+	defer snapshotNameMu.Unlock()
+	if ci.BackupSnapshotName == "" {
+		ci.BackupSnapshotName = SnapshotName(time.Now())
+	}
+	return ci.BackupSnapshotName
+}
+
+// NewSnapshotRun returns a context for a new run which makes its own
+// snapshot with --backup-snapshot rather than using the snapshot of an
+// earlier run with the same config.
+func NewSnapshotRun(ctx context.Context) context.Context {
+	if !fs.GetConfig(ctx).BackupSnapshot {
+		return ctx
+	}
+	snapshotNameMu.Lock()
+	defer snapshotNameMu.Unlock()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.BackupSnapshotName = ""
+	return ctx
+}
+
+// ParseSnapshotName returns the time of the snapshot directory name
+func ParseSnapshotName(name string) (time.Time, error) {
+	return time.ParseInLocation(SnapshotTimeFormat, name, time.UTC)
+}
+
+// Snapshot is a snapshot directory made by --backup-snapshot
+type Snapshot struct {
+	Name string    // name of the directory
+	Time time.Time // time the snapshot was made
+}
+
+// ListSnapshots returns the snapshot directories in the root of f
+// sorted newest first. Directories which aren't snapshots are ignored.
+func ListSnapshots(ctx context.Context, f fs.Fs) (snapshots []Snapshot, err error) {
+	entries, err := f.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, entry := range entries {
+		dir, ok := entry.(fs.Directory)
+		if !ok {
+			continue
+		}
+		t, err := ParseSnapshotName(dir.Remote())
+		if err != nil {
+			fs.Debugf(dir, "Ignoring as not a snapshot directory")
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: dir.Remote(), Time: t})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// PruneOpt is the retention policy used by PruneSnapshots.
+//
+// A snapshot is kept if any of the rules selects it.
+type PruneOpt struct {
+	KeepLast    int // keep the newest N snapshots
+	KeepDaily   int // keep the newest snapshot of each of the last N days with snapshots
+	KeepWeekly  int // keep the newest snapshot of each of the last N weeks with snapshots
+	KeepMonthly int // keep the newest snapshot of each of the last N months with snapshots
+}
+
+// keepSnapshots returns which of snapshots, sorted newest first,
+// should be kept according to opt
+func (opt *PruneOpt) keepSnapshots(snapshots []Snapshot) (keep []bool) {
+	keep = make([]bool, len(snapshots))
+	for i := range snapshots {
+		if i < opt.KeepLast {
+			keep[i] = true
+		}
+	}
+	// keepPeriod keeps the newest snapshot in each of the last n
+	// periods, where period returns the period a time is in
+	keepPeriod := func(n int, period func(t time.Time) string) {
+		last := ""
+		for i, snapshot := range snapshots {
+			if n <= 0 {
+				break
+			}
+			p := period(snapshot.Time)
+			if p == last {
+				continue
+			}
+			keep[i] = true
+			last = p
+			n--
+		}
+	}
+	keepPeriod(opt.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPeriod(opt.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-%02d", year, week)
+	})
+	keepPeriod(opt.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+	return keep
+}
+
+// PruneSnapshots removes the snapshot directories in the root of f
+// which aren't selected by the retention policy in opt.
+//
+// It returns an error without removing anything if opt has no rules.
+func PruneSnapshots(ctx context.Context, f fs.Fs, opt *PruneOpt) error {
+	if opt.KeepLast <= 0 && opt.KeepDaily <= 0 && opt.KeepWeekly <= 0 && opt.KeepMonthly <= 0 {
+		return errors.New("no retention policy set - refusing to remove all snapshots")
+	}
+	snapshots, err := ListSnapshots(ctx, f)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for i, keep := range opt.keepSnapshots(snapshots) {
+		snapshot := snapshots[i]
+		if keep {
+			fs.Debugf(f, "Keeping snapshot %q", snapshot.Name)
+			continue
+		}
+		fs.Infof(f, "Removing snapshot %q", snapshot.Name)
+		err := Purge(ctx, f, snapshot.Name)
+		if err != nil {
+			err = fs.CountError(err)
+			fs.Errorf(f, "Failed to remove snapshot %q: %v", snapshot.Name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,100 @@
+package operations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotName(t *testing.T) {
+	when := time.Date(2023, 6, 1, 15, 30, 0, 999, time.FixedZone("CEST", 2*60*60))
+	name := SnapshotName(when)
+	assert.Equal(t, "2023-06-01-133000", name)
+	got, err := ParseSnapshotName(name)
+	require.NoError(t, err)
+	assert.True(t, got.Equal(when.Truncate(time.Second)))
+	_, err = ParseSnapshotName("potato")
+	assert.Error(t, err)
+}
+
+func TestSnapshotRun(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.BackupSnapshot = true
+
+	// The name is chosen once and kept for retries
+	name := getSnapshotName(ctx)
+	assert.Equal(t, name, ci.BackupSnapshotName)
+	assert.Equal(t, name, getSnapshotName(ctx))
+
+	// A new run chooses its own name without changing the old one
+	newCtx := NewSnapshotRun(ctx)
+	assert.Equal(t, "", fs.GetConfig(newCtx).BackupSnapshotName)
+	assert.Equal(t, name, ci.BackupSnapshotName)
+}
+
+func TestKeepSnapshots(t *testing.T) {
+	// Snapshots newest first
+	var snapshots []Snapshot
+	for _, name := range []string{
+		"2023-03-15-120000",
+		"2023-03-15-000000",
+		"2023-03-14-000000",
+		"2023-03-06-000000", // Monday
+		"2023-03-05-000000", // Sunday
+		"2023-02-01-000000",
+		"2023-01-01-000000",
+	} {
+		when, err := ParseSnapshotName(name)
+		require.NoError(t, err)
+		snapshots = append(snapshots, Snapshot{Name: name, Time: when})
+	}
+	for _, test := range []struct {
+		opt  PruneOpt
+		want []bool
+	}{
+		{PruneOpt{}, []bool{false, false, false, false, false, false, false}},
+		{PruneOpt{KeepLast: 2}, []bool{true, true, false, false, false, false, false}},
+		{PruneOpt{KeepLast: 100}, []bool{true, true, true, true, true, true, true}},
+		{PruneOpt{KeepDaily: 3}, []bool{true, false, true, true, false, false, false}},
+		{PruneOpt{KeepWeekly: 3}, []bool{true, false, false, true, true, false, false}},
+		{PruneOpt{KeepMonthly: 2}, []bool{true, false, false, false, false, true, false}},
+		{PruneOpt{KeepLast: 1, KeepMonthly: 3}, []bool{true, false, false, false, false, true, true}},
+	} {
+		assert.Equal(t, test.want, test.opt.keepSnapshots(snapshots), "%+v", test.opt)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+
+	file1 := r.WriteObject(ctx, "2023-03-15-120000/one", "one", t1)
+	file2 := r.WriteObject(ctx, "2023-03-14-000000/two", "two", t1)
+	r.WriteObject(ctx, "2023-03-13-000000/dir/three", "three", t1)
+	file4 := r.WriteObject(ctx, "not-a-snapshot/four", "four", t1)
+	r.WriteObject(ctx, "2023-02-01-000000/five", "five", t1)
+
+	snapshots, err := ListSnapshots(ctx, r.Fremote)
+	require.NoError(t, err)
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	assert.Equal(t, []string{"2023-03-15-120000", "2023-03-14-000000", "2023-03-13-000000", "2023-02-01-000000"}, names)
+
+	assert.Error(t, PruneSnapshots(ctx, r.Fremote, &PruneOpt{}))
+
+	require.NoError(t, PruneSnapshots(ctx, r.Fremote, &PruneOpt{KeepDaily: 2}))
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{file1, file2, file4}, []string{
+		"2023-03-15-120000",
+		"2023-03-14-000000",
+		"not-a-snapshot",
+	}, fs.GetModifyWindow(ctx, r.Fremote))
+}
@@ -0,0 +1,237 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/readers"
+	"golang.org/x/sync/errgroup"
+)
+
+// postVerifyHash is the hash used to compare the source and the
+// destination with --post-verify
+var postVerifyHash = hash.MD5
+
+// errPostVerify is wrapped by the errors returned when the
+// destination doesn't match the source with --post-verify
+var errPostVerify = errors.New("post verify failed")
+
+// doPostVerify returns whether the copy of src should be read back
+// and checked with --post-verify.
+//
+// It should be called once per copy as it samples the files at random
+// if --post-verify-percent is set.
+func doPostVerify(ctx context.Context, src fs.ObjectInfo) bool {
+	ci := fs.GetConfig(ctx)
+	if !ci.PostVerify {
+		return false
+	}
+	if ci.PostVerifyMaxSize >= 0 && src.Size() > int64(ci.PostVerifyMaxSize) {
+		fs.Debugf(src, "Not verifying as larger than --post-verify-max-size")
+		return false
+	}
+	if ci.PostVerifyPercent < 100 && rand.Intn(100) >= ci.PostVerifyPercent {
+		fs.Debugf(src, "Not verifying as not chosen by --post-verify-percent")
+		return false
+	}
+	return true
+}
+
+// hashingReadCloser calculates the hash of what is read through it
+type hashingReadCloser struct {
+	io.Reader
+	io.Closer
+	hasher *hash.MultiHasher
+}
+
+// newHashingReadCloser wraps in so the postVerifyHash of the stream
+// can be read from it once it has been read
+func newHashingReadCloser(in io.ReadCloser) *hashingReadCloser {
+	hasher, _ := hash.NewMultiHasherTypes(hash.NewHashSet(postVerifyHash))
+	return &hashingReadCloser{
+		Reader: io.TeeReader(in, hasher),
+		Closer: in,
+		hasher: hasher,
+	}
+}
+
+// Sum returns the hash of the data read so far
+func (h *hashingReadCloser) Sum() string {
+	return h.hasher.Sums()[postVerifyHash]
+}
+
+// readHash reads the whole of o and returns its postVerifyHash
+func readHash(ctx context.Context, o fs.Object) (string, error) {
+	ci := fs.GetConfig(ctx)
+	in, err := NewReOpen(ctx, o, ci.LowLevelRetries)
+	if err != nil {
+		return "", fmt.Errorf("failed to open: %w", err)
+	}
+	sums, err := hash.StreamTypes(readers.NewContextReader(ctx, in), hash.NewHashSet(postVerifyHash))
+	closeErr := in.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read: %w", err)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("failed to close: %w", closeErr)
+	}
+	return sums[postVerifyHash], nil
+}
+
+// postVerify reads dst back and checks it matches srcSum, the hash of
+// the data uploaded from src.
+//
+// If srcSum is empty then the hash of src is read from the source if
+// it supports it, or worked out by reading src again alongside dst.
+//
+// It returns a retriable error wrapping errPostVerify if the hashes
+// don't match.
+func postVerify(ctx context.Context, src, dst fs.Object, srcSum string) error {
+	if srcSum == "" && src.Fs().Hashes().Contains(postVerifyHash) {
+		var err error
+		srcSum, err = src.Hash(ctx, postVerifyHash)
+		if err != nil {
+			fs.Debugf(src, "Post verify: failed to read hash: %v", err)
+			srcSum = ""
+		}
+	}
+	var dstSum string
+	g, gCtx := errgroup.WithContext(ctx)
+	if srcSum == "" {
+		g.Go(func() (err error) {
+			srcSum, err = readHash(gCtx, src)
+			if err != nil {
+				return fmt.Errorf("post verify: source: %w", err)
+			}
+			return nil
+		})
+	}
+	g.Go(func() (err error) {
+		dstSum, err = readHash(gCtx, dst)
+		if err != nil {
+			return fmt.Errorf("post verify: destination: %w", err)
+		}
+		return nil
+	})
+	err := g.Wait()
+	if err != nil {
+		return err
+	}
+	if srcSum != dstSum {
+		err = fmt.Errorf("%w: %v hash differ %q vs %q", errPostVerify, postVerifyHash, srcSum, dstSum)
+		fs.Errorf(dst, "%v", err)
+		return fserrors.RetryError(err)
+	}
+	fs.Debugf(dst, "Post verify: %v hash OK", postVerifyHash)
+	return nil
+}
+
+// postVerifyJob is a copy to be checked by a postVerifier
+type postVerifyJob struct {
+	f      fs.Fs     // Fs the copy was made to
+	remote string    // name of the copy in f
+	src    fs.Object // source of the copy
+	dst    fs.Object // the copy
+	srcSum string    // hash of the data uploaded or "" if not known
+}
+
+// postVerifier checks copies with --post-verify in the background so
+// the transfers don't wait for the destination to be read back.
+type postVerifier struct {
+	ctx context.Context
+	in  chan postVerifyJob
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error // last error
+}
+
+type postVerifierKey struct{}
+
+// getPostVerifier returns the postVerifier for ctx or nil if the
+// copies should be checked straight away
+func getPostVerifier(ctx context.Context) *postVerifier {
+	v, _ := ctx.Value(postVerifierKey{}).(*postVerifier)
+	return v
+}
+
+// withoutPostVerifier returns a context in which copies are checked
+// straight away
+func withoutPostVerifier(ctx context.Context) context.Context {
+	if getPostVerifier(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, postVerifierKey{}, (*postVerifier)(nil))
+}
+
+// StartPostVerify starts checking the copies made with the returned
+// context in the background using --transfers threads if
+// --post-verify is set.
+//
+// The returned function should be called when all the copies have
+// been made. It waits for the checks to finish and returns the last
+// error.
+func StartPostVerify(ctx context.Context) (context.Context, func() error) {
+	ci := fs.GetConfig(ctx)
+	if !ci.PostVerify || getPostVerifier(ctx) != nil {
+		return ctx, func() error { return nil }
+	}
+	v := &postVerifier{
+		ctx: withoutPostVerifier(ctx),
+		in:  make(chan postVerifyJob, ci.Transfers),
+	}
+	v.wg.Add(ci.Transfers)
+	for i := 0; i < ci.Transfers; i++ {
+		go v.run()
+	}
+	return context.WithValue(ctx, postVerifierKey{}, v), func() error {
+		close(v.in)
+		v.wg.Wait()
+		return v.err
+	}
+}
+
+// add queues a copy to be checked
+func (v *postVerifier) add(f fs.Fs, remote string, src, dst fs.Object, srcSum string) {
+	v.in <- postVerifyJob{f: f, remote: remote, src: src, dst: dst, srcSum: srcSum}
+}
+
+// run checks the copies queued until the queue is closed
+func (v *postVerifier) run() {
+	defer v.wg.Done()
+	for job := range v.in {
+		err := v.verify(job)
+		if err != nil {
+			v.mu.Lock()
+			v.err = err
+			v.mu.Unlock()
+		}
+	}
+}
+
+// verify checks a single copy.
+//
+// If the check fails the file is copied again checking it straight
+// away so the copy can be retried with --low-level-retries.
+func (v *postVerifier) verify(job postVerifyJob) error {
+	tr := accounting.Stats(v.ctx).NewCheckingTransfer(job.dst, "verifying")
+	err := postVerify(v.ctx, job.src, job.dst, job.srcSum)
+	// Errors are counted by the copy below if it fails too
+	tr.Done(v.ctx, nil)
+	if err == nil {
+		return nil
+	}
+	if fserrors.ContextError(v.ctx, &err) {
+		return fs.CountError(err)
+	}
+	fs.Infof(job.dst, "Copying again as post verify failed: %v", err)
+	_, err = Copy(v.ctx, job.f, job.dst, job.remote, job.src)
+	return err
+}
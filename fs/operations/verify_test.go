@@ -0,0 +1,106 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoPostVerify(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	src := mockobject.New("file.txt").WithContent([]byte("hello"), mockobject.SeekModeNone)
+
+	assert.False(t, doPostVerify(ctx, src))
+	ci.PostVerify = true
+	assert.True(t, doPostVerify(ctx, src))
+	ci.PostVerifyMaxSize = 4
+	assert.False(t, doPostVerify(ctx, src))
+	ci.PostVerifyMaxSize = 5
+	assert.True(t, doPostVerify(ctx, src))
+	ci.PostVerifyPercent = 0
+	assert.False(t, doPostVerify(ctx, src))
+}
+
+func TestHashingReadCloser(t *testing.T) {
+	in := newHashingReadCloser(io.NopCloser(strings.NewReader("hello")))
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", in.Sum())
+}
+
+func TestPostVerify(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile("file1", "hello", t1)
+	file2 := r.WriteObject(ctx, "file2", "hello", t1)
+	file3 := r.WriteObject(ctx, "file3", "HELLO", t1)
+	src, err := r.Flocal.NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+	good, err := r.Fremote.NewObject(ctx, file2.Path)
+	require.NoError(t, err)
+	bad, err := r.Fremote.NewObject(ctx, file3.Path)
+	require.NoError(t, err)
+
+	// Source hash from the stream
+	require.NoError(t, postVerify(ctx, src, good, "5d41402abc4b2a76b9719d911017c592"))
+	err = postVerify(ctx, src, bad, "5d41402abc4b2a76b9719d911017c592")
+	assert.True(t, errors.Is(err, errPostVerify))
+	assert.True(t, fserrors.IsRetryError(err))
+
+	// Source hash read from the source
+	require.NoError(t, postVerify(ctx, src, good, ""))
+	err = postVerify(ctx, src, bad, "")
+	assert.True(t, errors.Is(err, errPostVerify))
+}
+
+func TestCopyPostVerify(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.PostVerify = true
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile("file1", "file1 contents", t1)
+	src, err := r.Flocal.NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+	_, err = Copy(ctx, r.Fremote, nil, file1.Path, src)
+	require.NoError(t, err)
+	r.CheckRemoteItems(t, file1)
+}
+
+func TestPostVerifyBackground(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.PostVerify = true
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile("file1", "file1 contents", t1)
+	r.WriteObject(ctx, "file1", "file1 CORRUPTED", t1)
+	src, err := r.Flocal.NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+	bad, err := r.Fremote.NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+
+	ctx, finish := StartPostVerify(ctx)
+	v := getPostVerifier(ctx)
+	require.NotNil(t, v)
+
+	// A bad copy is noticed and copied again
+	v.add(r.Fremote, file1.Path, src, bad, "")
+	require.NoError(t, finish())
+	r.CheckRemoteItems(t, file1)
+}
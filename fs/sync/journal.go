@@ -0,0 +1,255 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/gob"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+const (
+	journalFacility      = "syncjournal"
+	journalBatchSize     = 1000             // flush the journal after this many records
+	journalFlushInterval = 10 * time.Second // or after this long
+)
+
+// journalState is the state of a single transfer in the journal
+type journalState byte
+
+const (
+	journalStarted journalState = iota // transfer in progress
+	journalDone                        // transfer complete or not needed
+)
+
+// journalRecord is stored in the journal for each source object
+type journalRecord struct {
+	State   journalState
+	SrcFp   string // fast fingerprint of the source
+	DstFp   string // fast fingerprint of the destination if done
+	Updated time.Time
+}
+
+func (r *journalRecord) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *journalRecord) decode(data []byte) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(r)
+}
+
+// journal records which transfers have been started and finished
+// by a sync, copy or move so an interrupted run can be resumed with
+// --resume without re-checking everything.
+//
+// Records are kept in a lib/kv database belonging to the destination
+// and keyed by a prefix identifying the source and destination pair.
+//
+// All the methods are safe to call on a nil *journal in which case
+// they do nothing.
+type journal struct {
+	ctx       context.Context
+	db        *kv.DB
+	prefix    string
+	mu        sync.Mutex
+	pending   map[string]*journalRecord
+	lastFlush time.Time
+}
+
+// newJournal opens the journal for syncing fsrc into fdst
+func newJournal(ctx context.Context, fdst, fsrc fs.Fs) (*journal, error) {
+	db, err := kv.Start(ctx, journalFacility, fdst)
+	if err != nil {
+		return nil, err
+	}
+	id := md5.Sum([]byte(fs.ConfigString(fsrc) + "\x00" + fs.ConfigString(fdst)))
+	j := &journal{
+		ctx:       ctx,
+		db:        db,
+		prefix:    hex.EncodeToString(id[:]) + "/",
+		pending:   make(map[string]*journalRecord),
+		lastFlush: time.Now(),
+	}
+	fs.Debugf(fdst, "Using transfer journal %q", db.Path())
+	return j, nil
+}
+
+// lookup checks the journal for src which is to be compared with
+// dst.
+//
+// It returns skip if a previous run transferred or verified src, and
+// neither src nor dst have changed since. It returns restart if a
+// previous run was interrupted while transferring src.
+func (j *journal) lookup(src, dst fs.Object) (skip, restart bool) {
+	if j == nil {
+		return false, false
+	}
+	rec := j.get(src.Remote())
+	if rec == nil {
+		return false, false
+	}
+	switch rec.State {
+	case journalStarted:
+		return false, true
+	case journalDone:
+		if dst == nil || rec.SrcFp != fs.Fingerprint(j.ctx, src, true) {
+			return false, false
+		}
+		return rec.DstFp == fs.Fingerprint(j.ctx, dst, true), false
+	}
+	return false, false
+}
+
+// get the record for remote or nil if not found
+func (j *journal) get(remote string) *journalRecord {
+	j.mu.Lock()
+	rec := j.pending[remote]
+	j.mu.Unlock()
+	if rec != nil {
+		return rec
+	}
+	op := &journalGet{key: j.prefix + remote}
+	if err := j.db.Do(false, op); err != nil || op.rec == nil {
+		return nil
+	}
+	return op.rec
+}
+
+// started records that the transfer of src has started
+func (j *journal) started(src fs.Object) {
+	if j == nil {
+		return
+	}
+	j.put(src.Remote(), &journalRecord{
+		State: journalStarted,
+		SrcFp: fs.Fingerprint(j.ctx, src, true),
+	})
+}
+
+// done records that src is present at dst
+func (j *journal) done(src, dst fs.Object) {
+	if j == nil || dst == nil {
+		return
+	}
+	j.put(src.Remote(), &journalRecord{
+		State: journalDone,
+		SrcFp: fs.Fingerprint(j.ctx, src, true),
+		DstFp: fs.Fingerprint(j.ctx, dst, true),
+	})
+}
+
+// put queues rec for writing, flushing the queue if necessary
+func (j *journal) put(remote string, rec *journalRecord) {
+	rec.Updated = time.Now()
+	j.mu.Lock()
+	j.pending[remote] = rec
+	flush := len(j.pending) >= journalBatchSize || time.Since(j.lastFlush) >= journalFlushInterval
+	j.mu.Unlock()
+	if flush {
+		j.flush()
+	}
+}
+
+// flush writes the pending records to the database
+func (j *journal) flush() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	op := &journalPut{prefix: j.prefix, recs: j.pending}
+	j.pending = make(map[string]*journalRecord)
+	j.lastFlush = time.Now()
+	j.mu.Unlock()
+	if len(op.recs) == 0 {
+		return
+	}
+	if err := j.db.Do(true, op); err != nil {
+		fs.Errorf(nil, "Failed to write transfer journal: %v", err)
+	}
+}
+
+// close the journal, removing its records if complete is set
+func (j *journal) close(complete bool) {
+	if j == nil {
+		return
+	}
+	if complete {
+		j.mu.Lock()
+		j.pending = make(map[string]*journalRecord)
+		j.mu.Unlock()
+		if err := j.db.Do(true, &journalPurge{prefix: j.prefix}); err != nil {
+			fs.Errorf(nil, "Failed to clear transfer journal: %v", err)
+		}
+	} else {
+		j.flush()
+	}
+	_ = j.db.Stop(false)
+}
+
+// journalGet reads a single record
+type journalGet struct {
+	key string
+	rec *journalRecord
+}
+
+func (op *journalGet) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if data == nil {
+		return nil
+	}
+	rec := &journalRecord{}
+	if err := rec.decode(data); err != nil {
+		fs.Debugf(op.key, "Ignoring bad journal record: %v", err)
+		return nil
+	}
+	op.rec = rec
+	return nil
+}
+
+// journalPut writes a batch of records
+type journalPut struct {
+	prefix string
+	recs   map[string]*journalRecord
+}
+
+func (op *journalPut) Do(ctx context.Context, b kv.Bucket) error {
+	for remote, rec := range op.recs {
+		data, err := rec.encode()
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte(op.prefix+remote), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalPurge removes all the records with the prefix
+type journalPurge struct {
+	prefix string
+}
+
+func (op *journalPurge) Do(ctx context.Context, b kv.Bucket) error {
+	var keys [][]byte
+	cur := b.Cursor()
+	for bkey, _ := cur.Seek([]byte(op.prefix)); bkey != nil && strings.HasPrefix(string(bkey), op.prefix); bkey, _ = cur.Next() {
+		keys = append(keys, append([]byte(nil), bkey...))
+	}
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported on this OS")
+	}
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("sub dir/hello world", "hello world", t1)
+	r.Mkdir(ctx, r.Fremote)
+
+	j, err := newJournal(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+
+	src, err := r.Flocal.NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+
+	// Not in the journal
+	skip, restart := j.lookup(src, nil)
+	assert.False(t, skip)
+	assert.False(t, restart)
+
+	// Transfer in progress
+	j.started(src)
+	skip, restart = j.lookup(src, nil)
+	assert.False(t, skip)
+	assert.True(t, restart)
+
+	dst, err := operations.Copy(ctx, r.Fremote, nil, src.Remote(), src)
+	require.NoError(t, err)
+	j.done(src, dst)
+
+	// Read back from the database rather than the pending records
+	j.flush()
+	assert.Equal(t, 0, len(j.pending))
+	skip, restart = j.lookup(src, dst)
+	assert.True(t, skip)
+	assert.False(t, restart)
+
+	// Missing destination must be checked
+	skip, _ = j.lookup(src, nil)
+	assert.False(t, skip)
+
+	// Changed source must be checked
+	file1b := r.WriteFile("sub dir/hello world", "hello world again", t2)
+	src, err = r.Flocal.NewObject(ctx, file1b.Path)
+	require.NoError(t, err)
+	skip, restart = j.lookup(src, dst)
+	assert.False(t, skip)
+	assert.False(t, restart)
+
+	// A complete run clears the journal
+	j2, err := newJournal(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+	j.close(true)
+	assert.Nil(t, j2.get(src.Remote()))
+	j2.close(false)
+}
+
+func TestJournalNil(t *testing.T) {
+	var j *journal
+	skip, restart := j.lookup(nil, nil)
+	assert.False(t, skip)
+	assert.False(t, restart)
+	j.started(nil)
+	j.done(nil, nil)
+	j.flush()
+	j.close(true)
+}
+
+// Check a copy with --resume works and leaves nothing in the journal
+func TestCopyWithResume(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported on this OS")
+	}
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("sub dir/hello world", "hello world", t1)
+	r.Mkdir(ctx, r.Fremote)
+
+	ci.Resume = true
+	err := CopyDir(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+
+	r.CheckLocalItems(t, file1)
+	r.CheckRemoteItems(t, file1)
+
+	j, err := newJournal(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+	assert.Nil(t, j.get(file1.Path))
+	j.close(false)
+}
+
+// Check a transfer interrupted after the destination was written
+// isn't transferred again
+func TestCopyWithResumeRestart(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported on this OS")
+	}
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("hello world", "hello world", t1)
+	r.Mkdir(ctx, r.Fremote)
+	src, err := r.Flocal.NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+	_, err = operations.Copy(ctx, r.Fremote, nil, src.Remote(), src)
+	require.NoError(t, err)
+
+	j, err := newJournal(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+	j.started(src)
+	j.close(false)
+
+	ci.Resume = true
+	accounting.GlobalStats().ResetCounters()
+	err = CopyDir(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetTransfers())
+	r.CheckRemoteItems(t, file1)
+}
+
+// Check a move with --resume works and leaves nothing in the journal
+func TestMoveWithResume(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported on this OS")
+	}
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("sub dir/hello world", "hello world", t1)
+	r.Mkdir(ctx, r.Fremote)
+
+	ci.Resume = true
+	err := MoveDir(ctx, r.Fremote, r.Flocal, false, false)
+	require.NoError(t, err)
+
+	r.CheckLocalItems(t)
+	r.CheckRemoteItems(t, file1)
+
+	j, err := newJournal(ctx, r.Fremote, r.Flocal)
+	require.NoError(t, err)
+	assert.Nil(t, j.get(file1.Path))
+	j.close(false)
+}
+
+// corruptFs is an fs.Fs which corrupts the data uploaded to it
+type corruptFs struct {
+	fs.Fs
+	features *fs.Features
+}
+
+func newCorruptFs(ctx context.Context, f fs.Fs) *corruptFs {
+	c := &corruptFs{Fs: f}
+	c.features = (&fs.Features{}).Fill(ctx, c)
+	return c
+}
+
+func (f *corruptFs) Features() *fs.Features {
+	return f.features
+}
+
+func (f *corruptFs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o, err := f.Fs.Put(ctx, corrupt(in), src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &corruptObject{Object: o, f: f}, nil
+}
+
+// corruptObject is an fs.Object which corrupts the data uploaded to it
+type corruptObject struct {
+	fs.Object
+	f *corruptFs
+}
+
+func (o *corruptObject) Fs() fs.Info {
+	return o.f
+}
+
+func (o *corruptObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.Object.Update(ctx, corrupt(in), src, options...)
+}
+
+// corrupt returns the data in in with its first byte changed
+func corrupt(in io.Reader) io.Reader {
+	data, _ := io.ReadAll(in)
+	if len(data) > 0 {
+		data[0] ^= 0xFF
+	}
+	return bytes.NewReader(data)
+}
+
+// Check a copy which fails --post-verify isn't marked as done in the
+// journal
+func TestCopyWithResumePostVerify(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported on this OS")
+	}
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("hello world", "hello world", t1)
+	r.Mkdir(ctx, r.Fremote)
+	fdst := newCorruptFs(ctx, r.Fremote)
+
+	// Keep the journal open so it isn't dropped when reopened
+	j, err := newJournal(ctx, fdst, r.Flocal)
+	require.NoError(t, err)
+
+	ci.Resume = true
+	ci.PostVerify = true
+	ci.IgnoreChecksum = true // leave the checking to --post-verify
+	ci.LowLevelRetries = 1
+	accounting.GlobalStats().ResetCounters()
+	err = CopyDir(ctx, fdst, r.Flocal, false)
+	require.Error(t, err)
+	accounting.GlobalStats().ResetErrors()
+
+	rec := j.get(file1.Path)
+	require.NotNil(t, rec)
+	assert.Equal(t, journalStarted, rec.State)
+	j.close(false)
+}
@@ -3,6 +3,7 @@ package sync
 import (
 	"context"
 
+	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fs/rc"
 )
 
@@ -46,6 +47,7 @@ func rcSyncCopyMove(ctx context.Context, in rc.Params, name string) (out rc.Para
 	if rc.NotErrParamNotFound(err) {
 		return nil, err
 	}
+	ctx = operations.NewSnapshotRun(ctx)
 	switch name {
 	case "sync":
 		return nil, Sync(ctx, dstFs, srcFs, createEmptySrcDirs)
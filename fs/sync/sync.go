@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"path"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 
@@ -30,70 +29,50 @@ type syncCopyMove struct {
 	deleteEmptySrcDirs bool
 	dir                string
 	// internal state
-	ci                     *fs.ConfigInfo         // global config
-	fi                     *filter.Filter         // filter config
-	ctx                    context.Context        // internal context for controlling go-routines
-	cancel                 func()                 // cancel the context
-	inCtx                  context.Context        // internal context for controlling march
-	inCancel               func()                 // cancel the march context
-	noTraverse             bool                   // if set don't traverse the dst
-	noCheckDest            bool                   // if set transfer all objects regardless without checking dst
-	noUnicodeNormalization bool                   // don't normalize unicode characters in filenames
-	deletersWg             sync.WaitGroup         // for delete before go routine
-	deleteFilesCh          chan fs.Object         // channel to receive deletes if delete before
-	trackRenames           bool                   // set if we should do server-side renames
-	trackRenamesStrategy   trackRenamesStrategy   // strategies used for tracking renames
-	dstFilesMu             sync.Mutex             // protect dstFiles
-	dstFiles               map[string]fs.Object   // dst files, always filled
-	srcFiles               map[string]fs.Object   // src files, only used if deleteBefore
-	srcFilesChan           chan fs.Object         // passes src objects
-	srcFilesResult         chan error             // error result of src listing
-	dstFilesResult         chan error             // error result of dst listing
-	dstEmptyDirsMu         sync.Mutex             // protect dstEmptyDirs
-	dstEmptyDirs           map[string]fs.DirEntry // potentially empty directories
-	srcEmptyDirsMu         sync.Mutex             // protect srcEmptyDirs
-	srcEmptyDirs           map[string]fs.DirEntry // potentially empty directories
-	checkerWg              sync.WaitGroup         // wait for checkers
-	toBeChecked            *pipe                  // checkers channel
-	transfersWg            sync.WaitGroup         // wait for transfers
-	toBeUploaded           *pipe                  // copiers channel
-	errorMu                sync.Mutex             // Mutex covering the errors variables
-	err                    error                  // normal error from copy process
-	noRetryErr             error                  // error with NoRetry set
-	fatalErr               error                  // fatal error
-	commonHash             hash.Type              // common hash type between src and dst
-	modifyWindow           time.Duration          // modify window between fsrc, fdst
-	renameMapMu            sync.Mutex             // mutex to protect the below
-	renameMap              map[string][]fs.Object // dst files by hash - only used by trackRenames
-	renamerWg              sync.WaitGroup         // wait for renamers
-	toBeRenamed            *pipe                  // renamers channel
-	trackRenamesWg         sync.WaitGroup         // wg for background track renames
-	trackRenamesCh         chan fs.Object         // objects are pumped in here
-	renameCheck            []fs.Object            // accumulate files to check for rename here
-	compareCopyDest        []fs.Fs                // place to check for files to server side copy
-	backupDir              fs.Fs                  // place to store overwrites/deletes
-	checkFirst             bool                   // if set run all the checkers before starting transfers
-	maxDurationEndTime     time.Time              // end time if --max-duration is set
-}
-
-type trackRenamesStrategy byte
-
-const (
-	trackRenamesStrategyHash trackRenamesStrategy = 1 << iota
-	trackRenamesStrategyModtime
-	trackRenamesStrategyLeaf
-)
-
-func (strategy trackRenamesStrategy) hash() bool {
-	return (strategy & trackRenamesStrategyHash) != 0
-}
-
-func (strategy trackRenamesStrategy) modTime() bool {
-	return (strategy & trackRenamesStrategyModtime) != 0
-}
-
-func (strategy trackRenamesStrategy) leaf() bool {
-	return (strategy & trackRenamesStrategyLeaf) != 0
+	ci                     *fs.ConfigInfo             // global config
+	fi                     *filter.Filter             // filter config
+	ctx                    context.Context            // internal context for controlling go-routines
+	cancel                 func()                     // cancel the context
+	inCtx                  context.Context            // internal context for controlling march
+	inCancel               func()                     // cancel the march context
+	noTraverse             bool                       // if set don't traverse the dst
+	noCheckDest            bool                       // if set transfer all objects regardless without checking dst
+	noUnicodeNormalization bool                       // don't normalize unicode characters in filenames
+	deletersWg             sync.WaitGroup             // for delete before go routine
+	deleteFilesCh          chan fs.Object             // channel to receive deletes if delete before
+	trackRenames           bool                       // set if we should do server-side renames
+	trackRenamesStrategy   operations.RenamesStrategy // strategies used for tracking renames
+	dstFilesMu             sync.Mutex                 // protect dstFiles
+	dstFiles               map[string]fs.Object       // dst files, always filled
+	srcFiles               map[string]fs.Object       // src files, only used if deleteBefore
+	srcFilesChan           chan fs.Object             // passes src objects
+	srcFilesResult         chan error                 // error result of src listing
+	dstFilesResult         chan error                 // error result of dst listing
+	dstEmptyDirsMu         sync.Mutex                 // protect dstEmptyDirs
+	dstEmptyDirs           map[string]fs.DirEntry     // potentially empty directories
+	srcEmptyDirsMu         sync.Mutex                 // protect srcEmptyDirs
+	srcEmptyDirs           map[string]fs.DirEntry     // potentially empty directories
+	checkerWg              sync.WaitGroup             // wait for checkers
+	toBeChecked            *pipe                      // checkers channel
+	transfersWg            sync.WaitGroup             // wait for transfers
+	toBeUploaded           *pipe                      // copiers channel
+	errorMu                sync.Mutex                 // Mutex covering the errors variables
+	err                    error                      // normal error from copy process
+	noRetryErr             error                      // error with NoRetry set
+	fatalErr               error                      // fatal error
+	commonHash             hash.Type                  // common hash type between src and dst
+	modifyWindow           time.Duration              // modify window between fsrc, fdst
+	renameMap              *operations.RenameMap      // dst files by hash - only used by trackRenames
+	renamerWg              sync.WaitGroup             // wait for renamers
+	toBeRenamed            *pipe                      // renamers channel
+	trackRenamesWg         sync.WaitGroup             // wg for background track renames
+	trackRenamesCh         chan fs.Object             // objects are pumped in here
+	renameCheck            []fs.Object                // accumulate files to check for rename here
+	compareCopyDest        []fs.Fs                    // place to check for files to server side copy
+	backupDir              fs.Fs                      // place to store overwrites/deletes
+	checkFirst             bool                       // if set run all the checkers before starting transfers
+	maxDurationEndTime     time.Time                  // end time if --max-duration is set
+	journal                *journal                   // transfer journal if --resume is set
 }
 
 func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) (*syncCopyMove, error) {
@@ -174,7 +153,7 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 		}
 		s.noTraverse = false
 	}
-	s.trackRenamesStrategy, err = parseTrackRenamesStrategy(ci.TrackRenamesStrategy)
+	s.trackRenamesStrategy, err = operations.ParseRenamesStrategy(ci.TrackRenamesStrategy)
 	if err != nil {
 		return nil, err
 	}
@@ -195,12 +174,12 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 			fs.Errorf(fdst, "Ignoring --track-renames as the destination does not support server-side move or copy")
 			s.trackRenames = false
 		}
-		if s.trackRenamesStrategy.hash() && s.commonHash == hash.None {
+		if s.trackRenamesStrategy.Hash() && s.commonHash == hash.None {
 			fs.Errorf(fdst, "Ignoring --track-renames as the source and destination do not have a common hash")
 			s.trackRenames = false
 		}
 
-		if s.trackRenamesStrategy.modTime() && s.modifyWindow == fs.ModTimeNotSupported {
+		if s.trackRenamesStrategy.ModTime() && s.modifyWindow == fs.ModTimeNotSupported {
 			fs.Errorf(fdst, "Ignoring --track-renames as either the source or destination do not support modtime")
 			s.trackRenames = false
 		}
@@ -221,13 +200,19 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 		}
 	}
 	// Make Fs for --backup-dir if required
-	if ci.BackupDir != "" || ci.Suffix != "" {
+	if ci.BackupDir != "" || ci.Suffix != "" || ci.BackupSnapshot {
 		var err error
 		s.backupDir, err = operations.BackupDir(ctx, fdst, fsrc, "")
 		if err != nil {
 			return nil, err
 		}
 	}
+	if ci.Resume && !ci.DryRun && s.deleteMode != fs.DeleteModeOnly {
+		s.journal, err = newJournal(ctx, fdst, fsrc)
+		if err != nil {
+			fs.Errorf(fdst, "Ignoring --resume as the transfer journal can't be opened: %v", err)
+		}
+	}
 	if len(ci.CompareDest) > 0 {
 		var err error
 		s.compareCopyDest, err = operations.GetCompareDest(ctx)
@@ -274,7 +259,7 @@ func (s *syncCopyMove) processError(err error) {
 	}
 	if err == context.DeadlineExceeded {
 		err = fserrors.NoRetryError(err)
-	} else if err == accounting.ErrorMaxTransferLimitReachedGraceful {
+	} else if err == accounting.ErrorMaxTransferLimitReachedGraceful || err == accounting.ErrorQuotaExceededGraceful {
 		if s.inCtx.Err() == nil {
 			fs.Logf(nil, "%v - stopping transfers", err)
 			// Cancel the march and stop the pipes
@@ -332,14 +317,26 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 		tr := accounting.Stats(s.ctx).NewCheckingTransfer(src, "checking")
 		// Check to see if can store this
 		if src.Storable() {
-			needTransfer := operations.NeedTransfer(s.ctx, pair.Dst, pair.Src)
-			if needTransfer {
-				NoNeedTransfer, err := operations.CompareOrCopyDest(s.ctx, s.fdst, pair.Dst, pair.Src, s.compareCopyDest, s.backupDir)
-				if err != nil {
-					s.processError(err)
+			var needTransfer bool
+			skip, restart := s.journal.lookup(src, pair.Dst)
+			if skip {
+				fs.Debugf(src, "Not checking as transferred by a previous run (--resume)")
+			} else {
+				if restart {
+					// The destination may have been completed before the interruption
+					fs.Infof(src, "Checking transfer interrupted in a previous run (--resume)")
 				}
-				if NoNeedTransfer {
-					needTransfer = false
+				needTransfer = operations.NeedTransfer(s.ctx, pair.Dst, pair.Src)
+				if needTransfer {
+					NoNeedTransfer, err := operations.CompareOrCopyDest(s.ctx, s.fdst, pair.Dst, pair.Src, s.compareCopyDest, s.backupDir)
+					if err != nil {
+						s.processError(err)
+					}
+					if NoNeedTransfer {
+						needTransfer = false
+					}
+				} else if !s.DoMove {
+					s.journal.done(src, pair.Dst)
 				}
 			}
 			if needTransfer {
@@ -429,13 +426,23 @@ func (s *syncCopyMove) pairCopyOrMove(ctx context.Context, in *pipe, fdst fs.Fs,
 		dst := pair.Dst
 		if s.DoMove {
 			if src != dst {
-				_, err = operations.Move(ctx, fdst, dst, src.Remote(), src)
+				var newDst fs.Object
+				s.journal.started(src)
+				newDst, err = operations.Move(ctx, fdst, dst, src.Remote(), src)
+				if err == nil {
+					s.journal.done(src, newDst)
+				}
 			} else {
 				// src == dst signals delete the src
 				err = operations.DeleteFile(ctx, src)
 			}
 		} else {
-			_, err = operations.Copy(ctx, fdst, dst, src.Remote(), src)
+			var newDst fs.Object
+			s.journal.started(src)
+			newDst, err = operations.Copy(ctx, fdst, dst, src.Remote(), src)
+			if err == nil {
+				s.journal.done(src, newDst)
+			}
 		}
 		s.processError(err)
 	}
@@ -665,110 +672,6 @@ func (s *syncCopyMove) srcParentDirCheck(entry fs.DirEntry) {
 	delete(s.srcEmptyDirs, parentDir)
 }
 
-// parseTrackRenamesStrategy turns a config string into a trackRenamesStrategy
-func parseTrackRenamesStrategy(strategies string) (strategy trackRenamesStrategy, err error) {
-	if len(strategies) == 0 {
-		return strategy, nil
-	}
-	for _, s := range strings.Split(strategies, ",") {
-		switch s {
-		case "hash":
-			strategy |= trackRenamesStrategyHash
-		case "modtime":
-			strategy |= trackRenamesStrategyModtime
-		case "leaf":
-			strategy |= trackRenamesStrategyLeaf
-		case "size":
-			// ignore
-		default:
-			return strategy, fmt.Errorf("unknown track renames strategy %q", s)
-		}
-	}
-	return strategy, nil
-}
-
-// renameID makes a string with the size and the other identifiers of the requested rename strategies
-//
-// it may return an empty string in which case no hash could be made
-func (s *syncCopyMove) renameID(obj fs.Object, renamesStrategy trackRenamesStrategy, precision time.Duration) string {
-	var builder strings.Builder
-
-	fmt.Fprintf(&builder, "%d", obj.Size())
-
-	if renamesStrategy.hash() {
-		var err error
-		hash, err := obj.Hash(s.ctx, s.commonHash)
-
-		if err != nil {
-			fs.Debugf(obj, "Hash failed: %v", err)
-			return ""
-		}
-		if hash == "" {
-			return ""
-		}
-
-		builder.WriteRune(',')
-		builder.WriteString(hash)
-	}
-
-	// for renamesStrategy.modTime() we don't add to the hash but we check the times in
-	// popRenameMap
-
-	if renamesStrategy.leaf() {
-		builder.WriteRune(',')
-		builder.WriteString(path.Base(obj.Remote()))
-	}
-
-	return builder.String()
-}
-
-// pushRenameMap adds the object with hash to the rename map
-func (s *syncCopyMove) pushRenameMap(hash string, obj fs.Object) {
-	s.renameMapMu.Lock()
-	s.renameMap[hash] = append(s.renameMap[hash], obj)
-	s.renameMapMu.Unlock()
-}
-
-// popRenameMap finds the object with hash and pop the first match from
-// renameMap or returns nil if not found.
-func (s *syncCopyMove) popRenameMap(hash string, src fs.Object) (dst fs.Object) {
-	s.renameMapMu.Lock()
-	defer s.renameMapMu.Unlock()
-	dsts, ok := s.renameMap[hash]
-	if ok && len(dsts) > 0 {
-		// Element to remove
-		i := 0
-
-		// If using track renames strategy modtime then we need to check the modtimes here
-		if s.trackRenamesStrategy.modTime() {
-			i = -1
-			srcModTime := src.ModTime(s.ctx)
-			for j, dst := range dsts {
-				dstModTime := dst.ModTime(s.ctx)
-				dt := dstModTime.Sub(srcModTime)
-				if dt < s.modifyWindow && dt > -s.modifyWindow {
-					i = j
-					break
-				}
-			}
-			// If nothing matched then return nil
-			if i < 0 {
-				return nil
-			}
-		}
-
-		// Remove the entry and return it
-		dst = dsts[i]
-		dsts = append(dsts[:i], dsts[i+1:]...)
-		if len(dsts) > 0 {
-			s.renameMap[hash] = dsts
-		} else {
-			delete(s.renameMap, hash)
-		}
-	}
-	return dst
-}
-
 // makeRenameMap builds a map of the destination files by hash that
 // match sizes in the slice of objects in s.renameCheck
 func (s *syncCopyMove) makeRenameMap() {
@@ -785,7 +688,7 @@ func (s *syncCopyMove) makeRenameMap() {
 	go s.pumpMapToChan(s.dstFiles, in)
 
 	// now make a map of size,hash for all dstFiles
-	s.renameMap = make(map[string][]fs.Object)
+	s.renameMap = operations.NewRenameMap(s.ctx, s.trackRenamesStrategy, s.commonHash, s.modifyWindow)
 	var wg sync.WaitGroup
 	wg.Add(s.ci.Checkers)
 	for i := 0; i < s.ci.Checkers; i++ {
@@ -795,10 +698,10 @@ func (s *syncCopyMove) makeRenameMap() {
 				// only create hash for dst fs.Object if its size could match
 				if _, found := possibleSizes[obj.Size()]; found {
 					tr := accounting.Stats(s.ctx).NewCheckingTransfer(obj, "renaming")
-					hash := s.renameID(obj, s.trackRenamesStrategy, s.modifyWindow)
+					hash := s.renameMap.ID(obj)
 
 					if hash != "" {
-						s.pushRenameMap(hash, obj)
+						s.renameMap.Push(hash, obj)
 					}
 
 					tr.Done(s.ctx, nil)
@@ -814,14 +717,14 @@ func (s *syncCopyMove) makeRenameMap() {
 // possible, it returns true if the object was renamed.
 func (s *syncCopyMove) tryRename(src fs.Object) bool {
 	// Calculate the hash of the src object
-	hash := s.renameID(src, s.trackRenamesStrategy, fs.GetModifyWindow(s.ctx, s.fsrc, s.fdst))
+	hash := s.renameMap.ID(src)
 
 	if hash == "" {
 		return false
 	}
 
 	// Get a match on fdst
-	dst := s.popRenameMap(hash, src)
+	dst := s.renameMap.Pop(hash, src)
 	if dst == nil {
 		return false
 	}
@@ -862,6 +765,14 @@ func (s *syncCopyMove) run() error {
 		return nil
 	}
 
+	// Check copies with --post-verify in the background. With
+	// --resume they are checked straight away instead, so a file is
+	// only marked done in the journal once its copy has been checked.
+	finishPostVerify := func() error { return nil }
+	if s.journal == nil {
+		s.ctx, finishPostVerify = operations.StartPostVerify(s.ctx)
+	}
+
 	// Start background checking and transferring pipeline
 	s.startCheckers()
 	s.startRenamers()
@@ -908,6 +819,7 @@ func (s *syncCopyMove) run() error {
 	}
 	s.stopRenamers()
 	s.stopTransfers()
+	s.processError(finishPostVerify())
 	s.stopDeleters()
 
 	if s.copyEmptySrcDirs {
@@ -954,6 +866,10 @@ func (s *syncCopyMove) run() error {
 		fs.Infof(nil, "There was nothing to transfer")
 	}
 
+	// Clear the journal if everything completed otherwise save it
+	// for the next --resume
+	s.journal.close(s.currentError() == nil)
+
 	// cancel the contexts to free resources
 	s.inCancel()
 	s.cancel()
@@ -1103,7 +1019,9 @@ func (s *syncCopyMove) Match(ctx context.Context, dst, src fs.DirEntry) (recurse
 // If DoMove is true then files will be moved instead of copied.
 //
 // dir is the start directory, "" for root
-func runSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) error {
+func runSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) (err error) {
+	ctx, finishPlan := operations.StartPlan(ctx)
+	defer finishPlan(&err)
 	ci := fs.GetConfig(ctx)
 	if deleteMode != fs.DeleteModeOff && DoMove {
 		return fserrors.FatalError(errors.New("can't delete and move at the same time"))
@@ -1149,7 +1067,9 @@ func moveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, cop
 }
 
 // MoveDir moves fsrc into fdst
-func MoveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) error {
+func MoveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, copyEmptySrcDirs bool) (err error) {
+	ctx, finishPlan := operations.StartPlan(ctx)
+	defer finishPlan(&err)
 	fi := filter.GetConfig(ctx)
 	if operations.Same(fdst, fsrc) {
 		fs.Errorf(fdst, "Nothing to do as source and destination are the same")
@@ -1157,7 +1077,8 @@ func MoveDir(ctx context.Context, fdst, fsrc fs.Fs, deleteEmptySrcDirs bool, cop
 	}
 
 	// First attempt to use DirMover if exists, same Fs and no filters are active
-	if fdstDirMove := fdst.Features().DirMove; fdstDirMove != nil && operations.SameConfig(fsrc, fdst) && fi.InActive() {
+	// and a plan isn't being made as the plan needs to list each file moved
+	if fdstDirMove := fdst.Features().DirMove; fdstDirMove != nil && operations.SameConfig(fsrc, fdst) && fi.InActive() && operations.GetPlan(ctx) == nil {
 		if operations.SkipDestructive(ctx, fdst, "server-side directory move") {
 			return nil
 		}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -1062,39 +1063,6 @@ func TestSyncWithTrackRenames(t *testing.T) {
 	}
 }
 
-func TestParseRenamesStrategyModtime(t *testing.T) {
-	for _, test := range []struct {
-		in      string
-		want    trackRenamesStrategy
-		wantErr bool
-	}{
-		{"", 0, false},
-		{"modtime", trackRenamesStrategyModtime, false},
-		{"hash", trackRenamesStrategyHash, false},
-		{"size", 0, false},
-		{"modtime,hash", trackRenamesStrategyModtime | trackRenamesStrategyHash, false},
-		{"hash,modtime,size", trackRenamesStrategyModtime | trackRenamesStrategyHash, false},
-		{"size,boom", 0, true},
-	} {
-		got, err := parseTrackRenamesStrategy(test.in)
-		assert.Equal(t, test.want, got, test.in)
-		assert.Equal(t, test.wantErr, err != nil, test.in)
-	}
-}
-
-func TestRenamesStrategyModtime(t *testing.T) {
-	both := trackRenamesStrategyHash | trackRenamesStrategyModtime
-	hash := trackRenamesStrategyHash
-	modTime := trackRenamesStrategyModtime
-
-	assert.True(t, both.hash())
-	assert.True(t, both.modTime())
-	assert.True(t, hash.hash())
-	assert.False(t, hash.modTime())
-	assert.False(t, modTime.hash())
-	assert.True(t, modTime.modTime())
-}
-
 func TestSyncWithTrackRenamesStrategyModtime(t *testing.T) {
 	ctx := context.Background()
 	ctx, ci := fs.AddConfig(ctx)
@@ -1813,6 +1781,94 @@ func TestSyncBackupDirSuffixOnly(t *testing.T) {
 	testSyncBackupDir(t, "", ".bak", false)
 }
 
+func TestSyncBackupSnapshot(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+
+	if !operations.CanServerSideMove(r.Fremote) {
+		t.Skip("Skipping test as remote does not support server-side move")
+	}
+	r.Mkdir(ctx, r.Fremote)
+	ci.BackupDir = r.FremoteName + "/backup"
+	ci.BackupSnapshot = true
+
+	file1 := r.WriteObject(ctx, "dst/one", "one", t1)
+	file2 := r.WriteObject(ctx, "dst/two", "two", t1)
+	file3 := r.WriteObject(ctx, "dst/three.txt", "three", t1)
+	file2a := r.WriteFile("two", "two", t1)
+	file1a := r.WriteFile("one", "oneA", t2)
+	r.CheckLocalItems(t, file1a, file2a)
+
+	fdst, err := fs.NewFs(ctx, r.FremoteName+"/dst")
+	require.NoError(t, err)
+
+	accounting.GlobalStats().ResetCounters()
+	err = Sync(ctx, fdst, r.Flocal, false)
+	require.NoError(t, err)
+
+	// one and three should be moved to a single snapshot
+	fbackup, err := fs.NewFs(ctx, ci.BackupDir)
+	require.NoError(t, err)
+	snapshots, err := operations.ListSnapshots(ctx, fbackup)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snapshots))
+	snapshot := "backup/" + snapshots[0].Name + "/"
+	file1.Path = snapshot + "one"
+	file1a.Path = "dst/one"
+	file3.Path = snapshot + "three.txt"
+	r.CheckRemoteItems(t, file1, file2, file3, file1a)
+}
+
+func TestSyncBackupSnapshotName(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+
+	if !operations.CanServerSideMove(r.Fremote) {
+		t.Skip("Skipping test as remote does not support server-side move")
+	}
+	r.Mkdir(ctx, r.Fremote)
+	ci.BackupDir = r.FremoteName + "/backup"
+	ci.BackupSnapshot = true
+	ci.BackupSnapshotName = "2001-02-03-040506"
+
+	fdst, err := fs.NewFs(ctx, r.FremoteName+"/dst")
+	require.NoError(t, err)
+
+	// Each sync is like a retry of the same run so should use the same snapshot
+	file1 := r.WriteObject(ctx, "dst/one", "one", t1)
+	file1a := r.WriteFile("one", "oneA", t2)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, fdst, r.Flocal, false))
+	file2 := r.WriteObject(ctx, "dst/two", "two", t1)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, fdst, r.Flocal, false))
+
+	fbackup, err := fs.NewFs(ctx, ci.BackupDir)
+	require.NoError(t, err)
+	snapshots, err := operations.ListSnapshots(ctx, fbackup)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snapshots))
+	assert.Equal(t, "2001-02-03-040506", snapshots[0].Name)
+	file1.Path = "backup/2001-02-03-040506/one"
+	file2.Path = "backup/2001-02-03-040506/two"
+	file1a.Path = "dst/one"
+	r.CheckRemoteItems(t, file1, file2, file1a)
+}
+
+func TestSyncBackupSnapshotNeedsBackupDir(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.BackupSnapshot = true
+
+	r.WriteFile("one", "one", t1)
+	err := Sync(ctx, r.Fremote, r.Flocal, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--backup-snapshot needs --backup-dir")
+}
+
 // Test with Suffix set
 func testSyncSuffix(t *testing.T, suffix string, suffixKeepExtension bool) {
 	ctx := context.Background()
@@ -2082,3 +2138,86 @@ func TestSyncConcurrentDelete(t *testing.T) {
 func TestSyncConcurrentTruncate(t *testing.T) {
 	testSyncConcurrent(t, "truncate")
 }
+
+// Test sync with --plan-out and then applying the plan
+func TestSyncPlan(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	planOut := filepath.Join(t.TempDir(), "plan.json")
+	ci.PlanOut = planOut
+
+	file1 := r.WriteFile("one", "one", t1)
+	file2 := r.WriteFile("dir/two", "two", t2)
+	file3 := r.WriteBoth(ctx, "three", "three", t3)
+	file4 := r.WriteObject(ctx, "four", "four", t1)
+	r.CheckLocalItems(t, file1, file2, file3)
+	r.CheckRemoteItems(t, file3, file4)
+
+	// Making the plan shouldn't change the destination
+	accounting.GlobalStats().ResetCounters()
+	err := Sync(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+	r.CheckRemoteItems(t, file3, file4)
+
+	plan, err := operations.LoadPlan(planOut)
+	require.NoError(t, err)
+	var got []string
+	for _, action := range plan.Actions {
+		got = append(got, action.Action+" "+action.Dst)
+	}
+	assert.ElementsMatch(t, []string{"copy one", "copy dir/two", "delete four"}, got)
+
+	// Applying the plan should make the destination the same as the source
+	ci.PlanOut = ""
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, operations.ApplyPlan(ctx, plan))
+	r.CheckRemoteItems(t, file1, file2, file3)
+}
+
+// Test a plan which moves changed files into --backup-dir can be applied
+func TestSyncPlanBackupDir(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	if !operations.CanServerSideMove(r.Fremote) {
+		t.Skip("Skipping test as remote does not support server-side move")
+	}
+	planOut := filepath.Join(t.TempDir(), "plan.json")
+	ci.PlanOut = planOut
+	ci.BackupDir = r.FremoteName + "/backup"
+
+	file1 := r.WriteFile("dst/one", "one changed", t2)
+	r.WriteObject(ctx, "dst/one", "one", t1)
+	fdst, err := fs.NewFs(ctx, r.FremoteName+"/dst")
+	require.NoError(t, err)
+	fsrc, err := fs.NewFs(ctx, r.LocalName+"/dst")
+	require.NoError(t, err)
+
+	err = Sync(ctx, fdst, fsrc, false)
+	require.NoError(t, err)
+
+	plan, err := operations.LoadPlan(planOut)
+	require.NoError(t, err)
+	ci.PlanOut = ""
+	require.NoError(t, operations.ApplyPlan(ctx, plan))
+	file1old := fstest.NewItem("backup/one", "one", t1)
+	r.CheckRemoteItems(t, file1, file1old)
+}
+
+// Test sync with --post-verify checking the copies in the background
+func TestSyncPostVerify(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.PostVerify = true
+
+	file1 := r.WriteFile("one", "one", t1)
+	file2 := r.WriteFile("dir/two", "two", t2)
+
+	accounting.GlobalStats().ResetCounters()
+	err := Sync(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+	r.CheckRemoteItems(t, file1, file2)
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetErrors())
+}
@@ -191,6 +191,13 @@ type Metadataer interface {
 	Metadata(ctx context.Context) (Metadata, error)
 }
 
+// SetMetadataer is an optional interface for Object
+type SetMetadataer interface {
+	// SetMetadata sets the keys in metadata on the object without
+	// uploading it again, leaving any other metadata alone
+	SetMetadata(ctx context.Context, metadata Metadata) error
+}
+
 // FullObjectInfo contains all the read-only optional interfaces
 //
 // Use for checking making wrapping ObjectInfos implement everything
@@ -214,6 +221,7 @@ type FullObject interface {
 	GetTierer
 	SetTierer
 	Metadataer
+	SetMetadataer
 }
 
 // ObjectOptionalInterfaces returns the names of supported and
@@ -245,6 +253,9 @@ func ObjectOptionalInterfaces(o Object) (supported, unsupported []string) {
 	_, ok = o.(Metadataer)
 	store(ok, "Metadata")
 
+	_, ok = o.(SetMetadataer)
+	store(ok, "SetMetadata")
+
 	return supported, unsupported
 }
 
@@ -281,3 +292,27 @@ type WriterAtCloser interface {
 	io.WriterAt
 	io.Closer
 }
+
+// ChunkWriterInfo describes how the ChunkWriter returned by
+// OpenChunkWriter should be used
+type ChunkWriterInfo struct {
+	ChunkSize         int64 // size of each chunk, all but the last chunk must be this size
+	LeavePartsOnError bool  // if set don't call Abort on error
+}
+
+// ChunkWriter writes an object in chunks which may be written
+// concurrently and in any order
+type ChunkWriter interface {
+	// WriteChunk writes chunk number chunkNumber (starting from 0)
+	// with the data in reader.
+	//
+	// The reader may be rewound and read again if the write needs
+	// to be retried.
+	WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (bytesWritten int64, err error)
+
+	// Close finalises the object once all the chunks are written
+	Close(ctx context.Context) error
+
+	// Abort the write, removing any chunks written so far
+	Abort(ctx context.Context) error
+}
@@ -9,6 +9,8 @@ package fstests
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -362,7 +364,7 @@ func Run(t *testing.T, opt *Opt) {
 		purged               bool // whether the dir has been purged or not
 		ctx                  = context.Background()
 		ci                   = fs.GetConfig(ctx)
-		unwrappableFsMethods = []string{"Command"} // these Fs methods don't need to be wrapped ever
+		unwrappableFsMethods = []string{"Command", "BlockHashes"} // these Fs methods don't need to be wrapped ever
 	)
 
 	if strings.HasSuffix(os.Getenv("RCLONE_CONFIG"), "/notfound") && *fstest.RemoteName == "" && !opt.QuickTestOK {
@@ -775,6 +777,35 @@ func Run(t *testing.T, opt *Opt) {
 			assert.NoError(t, f.Rmdir(ctx, "writer-at-subdir"))
 		})
 
+		t.Run("FsBlockHashes", func(t *testing.T) {
+			skipIfNotOk(t)
+			blockHashes := f.Features().BlockHashes
+			if blockHashes == nil {
+				t.Skip("FS has no BlockHashes interface")
+			}
+			contents := random.String(2500)
+			file := fstest.NewItem("block-hashes-file", contents, fstest.Time("2001-02-03T04:05:06.499999999Z"))
+			obj := PutTestContents(ctx, t, f, &file, contents, true)
+			hashes, err := blockHashes(ctx, file.Path, 1000)
+			if errors.Is(err, fs.ErrorNotImplemented) {
+				assert.NoError(t, obj.Remove(ctx))
+				t.Skip("BlockHashes not supported by the server")
+			}
+			require.NoError(t, err)
+			var want []string
+			for i := 0; i < len(contents); i += 1000 {
+				block := contents[i:]
+				if len(block) > 1000 {
+					block = block[:1000]
+				}
+				sum := md5.Sum([]byte(block))
+				want = append(want, hex.EncodeToString(sum[:]))
+			}
+			assert.Equal(t, want, hashes)
+
+			assert.NoError(t, obj.Remove(ctx))
+		})
+
 		// TestFsChangeNotify tests that changes are properly
 		// propagated
 		//
@@ -1434,6 +1465,37 @@ func Run(t *testing.T, opt *Opt) {
 				} // else: Have some metadata here we didn't write - can't really check it!
 			})
 
+			// TestObjectSetMetadata tests that SetMetadata works
+			t.Run("ObjectSetMetadata", func(t *testing.T) {
+				skipIfNotOk(t)
+				obj := findObject(ctx, t, f, file1.Path)
+				do, ok := obj.(fs.SetMetadataer)
+				if !ok {
+					t.Skip("SetMetadata method not supported")
+				}
+				if !f.Features().UserMetadata {
+					t.Skip("User metadata not supported")
+				}
+				err := do.SetMetadata(ctx, fs.Metadata{"rclone-test-set": "carrot"})
+				if errors.Is(err, fs.ErrorNotImplemented) {
+					t.Skip("SetMetadata not supported by the underlying object")
+				}
+				require.NoError(t, err)
+				// read the object from scratch
+				obj = findObject(ctx, t, f, file1.Path)
+				metadata, err := fs.GetMetadata(ctx, obj)
+				require.NoError(t, err)
+				assert.Equal(t, "carrot", metadata["rclone-test-set"])
+				// check the metadata we uploaded is still present
+				for k, v := range file1Metadata {
+					assert.Equal(t, v, metadata[k], "metadata key %q changed", k)
+				}
+				// check the object itself is unchanged
+				assert.Equal(t, file1.Size, obj.Size())
+				file1.CheckModTime(t, obj, obj.ModTime(ctx), f.Precision())
+				assert.Equal(t, file1Contents, ReadObject(ctx, t, obj, -1), "contents of file1 differ")
+			})
+
 			// TestObjectSetModTime tests that SetModTime works
 			t.Run("ObjectSetModTime", func(t *testing.T) {
 				skipIfNotOk(t)
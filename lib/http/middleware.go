@@ -122,9 +122,11 @@ func MiddlewareAuthCustom(fn CustomAuthFn, realm string) Middleware {
 				return
 			}
 
+			ctx := context.WithValue(r.Context(), ctxKeyUser, user)
 			if value != nil {
-				r = r.WithContext(context.WithValue(r.Context(), ctxKeyAuth, value))
+				ctx = context.WithValue(ctx, ctxKeyAuth, value)
 			}
+			r = r.WithContext(ctx)
 
 			next.ServeHTTP(w, r)
 		})
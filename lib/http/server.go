@@ -164,7 +164,8 @@ type Server struct {
 	cfg          Config
 	template     *TemplateConfig
 	htmlTemplate *template.Template
-	usingAuth    bool // set if we are using auth middleware
+	usingAuth    bool     // set if we are using auth middleware
+	public       []string // path prefixes which don't need auth for reading
 	atexitHandle atexit.FnHandle
 }
 
@@ -192,6 +193,16 @@ func WithTemplate(cfg TemplateConfig) Option {
 	}
 }
 
+// WithPublicPrefix option makes GET and HEAD requests for paths
+// starting with prefix skip the authentication.
+//
+// The handler for these paths must do its own access control.
+func WithPublicPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.public = append(s.public, prefix)
+	}
+}
+
 // NewServer instantiates a new http server using provided listeners and options
 // This function is provided if the default http server does not meet a services requirements and should not generally be used
 // A http server can listen using multiple listeners. For example, a listener for port 80, and a listener for port 443.
@@ -295,24 +306,47 @@ func NewServer(ctx context.Context, options ...Option) (*Server, error) {
 func (s *Server) initAuth() {
 	if s.auth.CustomAuthFn != nil {
 		s.usingAuth = true
-		s.mux.Use(MiddlewareAuthCustom(s.auth.CustomAuthFn, s.auth.Realm))
+		s.useAuth(MiddlewareAuthCustom(s.auth.CustomAuthFn, s.auth.Realm))
 		return
 	}
 
 	if s.auth.HtPasswd != "" {
 		s.usingAuth = true
-		s.mux.Use(MiddlewareAuthHtpasswd(s.auth.HtPasswd, s.auth.Realm))
+		s.useAuth(MiddlewareAuthHtpasswd(s.auth.HtPasswd, s.auth.Realm))
 		return
 	}
 
 	if s.auth.BasicUser != "" {
 		s.usingAuth = true
-		s.mux.Use(MiddlewareAuthBasic(s.auth.BasicUser, s.auth.BasicPass, s.auth.Realm, s.auth.Salt))
+		s.useAuth(MiddlewareAuthBasic(s.auth.BasicUser, s.auth.BasicPass, s.auth.Realm, s.auth.Salt))
 		return
 	}
 	s.usingAuth = false
 }
 
+// useAuth installs the auth middleware skipping it for reads of the
+// public paths
+func (s *Server) useAuth(auth Middleware) {
+	if len(s.public) == 0 {
+		s.mux.Use(auth)
+		return
+	}
+	s.mux.Use(func(next http.Handler) http.Handler {
+		authNext := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" || r.Method == "HEAD" {
+				for _, prefix := range s.public {
+					if strings.HasPrefix(r.URL.Path, prefix) {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			authNext.ServeHTTP(w, r)
+		})
+	})
+}
+
 func (s *Server) initTemplate() error {
 	if s.template == nil {
 		return nil
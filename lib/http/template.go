@@ -31,6 +31,7 @@ to be used within the template to server pages:
 | .Order      | The current ordering used.  This is changeable via ?order= parameter |
 |             | Order Options: asc,desc (default asc) |
 | .Query      | Currently unused. |
+| .Writable   | Boolean for if the user may upload, delete and make directories. |
 | .Breadcrumb | Allows for creating a relative navigation |
 |-- .Link     | The relative to the root link of the Text. |
 |-- .Text     | The Name of the directory. |
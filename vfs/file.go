@@ -417,6 +417,32 @@ func (f *File) SetModTime(modTime time.Time) error {
 	return nil
 }
 
+// SetMetadata sets the keys in metadata on the file leaving any other
+// metadata alone.
+//
+// This returns ENOSYS if the backend can't set metadata without
+// uploading the file again.
+func (f *File) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	if f._writingInProgress() {
+		// The upload would overwrite the metadata
+		return EPERM
+	}
+	do, ok := f.o.(fs.SetMetadataer)
+	if !ok {
+		return ENOSYS
+	}
+	err := do.SetMetadata(ctx, metadata)
+	if errors.Is(err, fs.ErrorNotImplemented) {
+		return ENOSYS
+	}
+	return err
+}
+
 // Apply a pending mod time
 // Call with the mutex held
 func (f *File) _applyPendingModTime() error {
@@ -610,6 +636,7 @@ func (f *File) Remove() (err error) {
 	}
 
 	// Remove the object from the cache
+	size := f.Size()
 	wasWriting := false
 	if d.vfs.cache != nil && d.vfs.cache.Exists(f.Path()) {
 		wasWriting = d.vfs.cache.Remove(f.Path())
@@ -636,6 +663,8 @@ func (f *File) Remove() (err error) {
 	// called with File.mu released when there is no error removing the underlying file
 	if err == nil {
 		d.delObject(f.Name())
+		// Give the space back to the quota
+		d.vfs.release(size)
 	}
 	return err
 }
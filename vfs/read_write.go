@@ -334,6 +334,11 @@ func (fh *RWFileHandle) _writeAt(b []byte, off int64, release bool) (n int, err
 		fh.offset = size
 		off = fh.offset
 	}
+	if grow := off + int64(len(b)) - fh._size(); grow > 0 {
+		if err = fh.file.VFS().reserve(grow); err != nil {
+			return n, err
+		}
+	}
 	fh.writeCalled = true
 	if release {
 		// Do the writing with fh.mu unlocked
@@ -380,9 +385,17 @@ func (fh *RWFileHandle) WriteString(s string) (n int, err error) {
 //
 // Call with mutex held
 func (fh *RWFileHandle) _truncate(size int64) (err error) {
-	if size == fh._size() {
+	oldSize := fh._size()
+	if size == oldSize {
 		return nil
 	}
+	if size > oldSize {
+		if err = fh.file.VFS().reserve(size - oldSize); err != nil {
+			return err
+		}
+	} else {
+		fh.file.VFS().release(oldSize - size)
+	}
 	fh.file.setSize(size)
 	return fh.item.Truncate(size)
 }
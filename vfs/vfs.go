@@ -22,6 +22,7 @@ package vfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -166,6 +167,7 @@ type VFS struct {
 	usageMu     sync.Mutex
 	usageTime   time.Time
 	usage       *fs.Usage
+	written     int64 // bytes reserved against the quota since usage was read
 	pollChan    chan time.Duration
 	inUse       int32 // count of number of opens accessed with atomic
 }
@@ -576,17 +578,25 @@ func (vfs *VFS) Statfs() (total, used, free int64) {
 	// defer log.Trace("/", "")("total=%d, used=%d, free=%d", &total, &used, &free)
 	vfs.usageMu.Lock()
 	defer vfs.usageMu.Unlock()
+	return vfs.statfs()
+}
+
+// statfs returns info about the filing system - call with usageMu held
+func (vfs *VFS) statfs() (total, used, free int64) {
 	total, used, free = -1, -1, -1
 	doAbout := vfs.f.Features().About
-	if (doAbout != nil || vfs.Opt.UsedIsSize) && (vfs.usageTime.IsZero() || time.Since(vfs.usageTime) >= vfs.Opt.DirCacheTime) {
+	// The quota is for the files in the VFS so needs their size
+	usedIsSize := vfs.Opt.UsedIsSize || vfs.Opt.Quota >= 0
+	if (doAbout != nil || usedIsSize) && (vfs.usageTime.IsZero() || time.Since(vfs.usageTime) >= vfs.Opt.DirCacheTime) {
 		var err error
 		ctx := context.TODO()
-		if doAbout == nil {
-			vfs.usage = &fs.Usage{}
-		} else {
+		if doAbout != nil {
 			vfs.usage, err = doAbout(ctx)
 		}
-		if vfs.Opt.UsedIsSize {
+		if vfs.usage == nil {
+			vfs.usage = &fs.Usage{}
+		}
+		if usedIsSize {
 			var usedBySizeAlgorithm int64
 			// Algorithm from `rclone size`
 			err = walk.ListR(ctx, vfs.f, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
@@ -595,9 +605,14 @@ func (vfs *VFS) Statfs() (total, used, free int64) {
 				})
 				return nil
 			})
+			if errors.Is(err, fs.ErrorDirNotFound) {
+				// nothing stored yet
+				err = nil
+			}
 			vfs.usage.Used = &usedBySizeAlgorithm
 		}
 		vfs.usageTime = time.Now()
+		vfs.written = 0
 		if err != nil {
 			fs.Errorf(vfs.f, "Statfs failed: %v", err)
 			return
@@ -620,10 +635,52 @@ func (vfs *VFS) Statfs() (total, used, free int64) {
 		total = int64(vfs.Opt.DiskSpaceTotalSize)
 	}
 
+	if vfs.Opt.Quota >= 0 {
+		if used >= 0 {
+			used += vfs.written
+		}
+		total = int64(vfs.Opt.Quota)
+		free = -1
+		if used >= 0 {
+			free = total - used
+			if free < 0 {
+				free = 0
+			}
+		}
+	}
+
 	total, used, free = fillInMissingSizes(total, used, free, unknownFreeBytes)
 	return
 }
 
+// reserve checks there is space in the quota for n more bytes and
+// counts them as used if so, otherwise it returns ENOSPC.
+func (vfs *VFS) reserve(n int64) error {
+	if vfs.Opt.Quota < 0 || n <= 0 {
+		return nil
+	}
+	vfs.usageMu.Lock()
+	defer vfs.usageMu.Unlock()
+	_, used, _ := vfs.statfs()
+	if used+n > int64(vfs.Opt.Quota) {
+		fs.Debugf(vfs.f, "Quota of %v exceeded", vfs.Opt.Quota)
+		return ENOSPC
+	}
+	vfs.written += n
+	return nil
+}
+
+// release returns n bytes to the quota, for when a file is truncated,
+// replaced or removed, so its old contents aren't counted any more.
+func (vfs *VFS) release(n int64) {
+	if vfs.Opt.Quota < 0 || n <= 0 {
+		return
+	}
+	vfs.usageMu.Lock()
+	vfs.written -= n
+	vfs.usageMu.Unlock()
+}
+
 // Remove removes the named file or (empty) directory.
 func (vfs *VFS) Remove(name string) error {
 	node, err := vfs.Stat(name)
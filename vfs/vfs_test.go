@@ -364,6 +364,111 @@ func TestVFSStatfs(t *testing.T) {
 	assert.Equal(t, oldTime, vfs.usageTime)
 }
 
+func TestVFSQuota(t *testing.T) {
+	for _, cacheMode := range []vfscommon.CacheMode{vfscommon.CacheModeOff, vfscommon.CacheModeWrites} {
+		t.Run(cacheMode.String(), func(t *testing.T) {
+			opt := vfscommon.DefaultOpt
+			opt.CacheMode = cacheMode
+			opt.Quota = 100
+			_, vfs := newTestVFSOpt(t, &opt)
+
+			total, used, free := vfs.Statfs()
+			assert.Equal(t, int64(100), total)
+			assert.Equal(t, int64(0), used)
+			assert.Equal(t, int64(100), free)
+
+			write := func(name string, size int) error {
+				fd, err := vfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+				require.NoError(t, err)
+				_, err = fd.Write(make([]byte, size))
+				closeErr := fd.Close()
+				if err == nil {
+					err = closeErr
+				}
+				return err
+			}
+
+			require.NoError(t, write("file1", 60))
+			total, used, free = vfs.Statfs()
+			assert.Equal(t, int64(100), total)
+			assert.Equal(t, int64(60), used)
+			assert.Equal(t, int64(40), free)
+
+			err := write("file2", 60)
+			assert.True(t, errors.Is(err, ENOSPC), "got %v", err)
+
+			require.NoError(t, write("file3", 40))
+			_, _, free = vfs.Statfs()
+			assert.Equal(t, int64(0), free)
+
+			// Overwriting a file only counts the change in size
+			require.NoError(t, write("file1", 50))
+			_, used, free = vfs.Statfs()
+			assert.Equal(t, int64(90), used)
+			assert.Equal(t, int64(10), free)
+			require.NoError(t, write("file1", 60))
+			_, used, free = vfs.Statfs()
+			assert.Equal(t, int64(100), used)
+			assert.Equal(t, int64(0), free)
+
+			// Removing a file gives its space back
+			require.NoError(t, vfs.Remove("file3"))
+			_, used, free = vfs.Statfs()
+			assert.Equal(t, int64(60), used)
+			assert.Equal(t, int64(40), free)
+			require.NoError(t, write("file4", 40))
+			_, _, free = vfs.Statfs()
+			assert.Equal(t, int64(0), free)
+
+			err = write("file1", 61)
+			assert.True(t, errors.Is(err, ENOSPC), "got %v", err)
+		})
+	}
+}
+
+func TestVFSQuotaTruncate(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.CacheMode = vfscommon.CacheModeWrites
+	opt.Quota = 100
+	_, vfs := newTestVFSOpt(t, &opt)
+
+	fd, err := vfs.OpenFile("file1", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	require.NoError(t, err)
+	_, err = fd.Write(make([]byte, 10))
+	require.NoError(t, err)
+
+	// Growing the file past the quota fails and leaves it alone
+	err = fd.Truncate(101)
+	assert.True(t, errors.Is(err, ENOSPC), "got %v", err)
+	fi, err := fd.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), fi.Size())
+	_, used, _ := vfs.Statfs()
+	assert.Equal(t, int64(10), used)
+
+	// Growing it up to the quota counts against it
+	require.NoError(t, fd.Truncate(100))
+	_, used, free := vfs.Statfs()
+	assert.Equal(t, int64(100), used)
+	assert.Equal(t, int64(0), free)
+
+	// Shrinking it gives the space back
+	require.NoError(t, fd.Truncate(40))
+	_, used, free = vfs.Statfs()
+	assert.Equal(t, int64(40), used)
+	assert.Equal(t, int64(60), free)
+
+	// Truncating the node as setattr does is checked too
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	err = node.Truncate(101)
+	assert.True(t, errors.Is(err, ENOSPC), "got %v", err)
+	require.NoError(t, node.Truncate(100))
+	_, used, _ = vfs.Statfs()
+	assert.Equal(t, int64(100), used)
+	require.NoError(t, fd.Close())
+}
+
 func TestVFSMkdir(t *testing.T) {
 	r, vfs := newTestVFS(t)
 
@@ -35,6 +35,7 @@ type Options struct {
 	UsedIsSize         bool          // if true, use the `rclone size` algorithm for Used size
 	FastFingerprint    bool          // if set use fast fingerprints
 	DiskSpaceTotalSize fs.SizeSuffix
+	Quota              fs.SizeSuffix // if >= 0 refuse writes which would use more than this
 }
 
 // DefaultOpt is the default values uses for Opt
@@ -63,6 +64,7 @@ var DefaultOpt = Options{
 	ReadAhead:          0 * fs.Mebi,
 	UsedIsSize:         false,
 	DiskSpaceTotalSize: -1,
+	Quota:              -1,
 }
 
 // Init the options, making sure everything is within range
@@ -77,6 +77,8 @@ func (fh *WriteFileHandle) openPending() (err error) {
 		fh.o = o
 		fh.result <- err
 	}()
+	// the file is replaced so its old size no longer counts against the quota
+	fh.file.VFS().release(fh.file.Size())
 	fh.file.setSize(0)
 	fh.truncated = true
 	fh.file.Dir().addObject(fh.file) // make sure the directory has this object in it now
@@ -139,6 +141,9 @@ func (fh *WriteFileHandle) writeAt(p []byte, off int64) (n int, err error) {
 	if err = fh.openPending(); err != nil {
 		return 0, err
 	}
+	if err = fh.file.VFS().reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
 	fh.writeCalled = true
 	n, err = fh.pipeWriter.Write(p)
 	fh.offset += int64(n)